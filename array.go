@@ -2,27 +2,62 @@ package bs_jvm
 
 import (
 	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
 	"strconv"
 )
 
-// This file contains types relating to managing JVM arrays.
+// This file contains types relating to managing JVM arrays. Arrays are always
+// referred to using pointers, so that references to the same array compare
+// as equal, and so that every reference sees changes to the array's contents.
+
+// This interface is implemented by all JVM array objects.
+type Array interface {
+	Object
+	// Returns the number of elements in the array.
+	Length() int
+	// Returns the type of the array, e.g. int[] or java/lang/String[][].
+	ArrayType() *class_file.ArrayType
+}
+
+// Returns the type of a one-dimensional array of the given primitive.
+func primitiveArrayType(t byte) *class_file.ArrayType {
+	return &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.PrimitiveFieldType(t),
+	}
+}
+
+// Returns the type of the elements in an array of the given type. For example,
+// this will return int[] for an int[][] array, and int for an int[] array.
+func ComponentType(t *class_file.ArrayType) class_file.FieldType {
+	if t.Dimensions <= 1 {
+		return t.ContentType
+	}
+	return &class_file.ArrayType{
+		Dimensions:  t.Dimensions - 1,
+		ContentType: t.ContentType,
+	}
+}
 
 // Implements the Object interface for arrays of Ints.
-type IntArray []Int
+type IntArray struct {
+	Elements []Int
+	objectMonitor
+}
 
-func (n IntArray) IsPrimitive() bool {
+func (n *IntArray) IsPrimitive() bool {
 	return false
 }
 
-func (n IntArray) TypeName() string {
+func (n *IntArray) TypeName() string {
 	return "int[]"
 }
 
-func (n IntArray) String() string {
+func (n *IntArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.FormatInt(int64(v), 10)
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -30,22 +65,33 @@ func (n IntArray) String() string {
 	return s
 }
 
+func (n *IntArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *IntArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('I')
+}
+
 // Implements the Object interface for arrays of Longs.
-type LongArray []Long
+type LongArray struct {
+	Elements []Long
+	objectMonitor
+}
 
-func (n LongArray) IsPrimitive() bool {
+func (n *LongArray) IsPrimitive() bool {
 	return false
 }
 
-func (n LongArray) TypeName() string {
+func (n *LongArray) TypeName() string {
 	return "long[]"
 }
 
-func (n LongArray) String() string {
+func (n *LongArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.FormatInt(int64(v), 10)
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -53,22 +99,33 @@ func (n LongArray) String() string {
 	return s
 }
 
+func (n *LongArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *LongArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('J')
+}
+
 // This implements the Object interface for arrays of Floats.
-type FloatArray []Float
+type FloatArray struct {
+	Elements []Float
+	objectMonitor
+}
 
-func (n FloatArray) IsPrimitive() bool {
+func (n *FloatArray) IsPrimitive() bool {
 	return false
 }
 
-func (n FloatArray) TypeName() string {
+func (n *FloatArray) TypeName() string {
 	return "float[]"
 }
 
-func (n FloatArray) String() string {
+func (n *FloatArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.FormatFloat(float64(v), 'g', 5, 32)
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -76,22 +133,33 @@ func (n FloatArray) String() string {
 	return s
 }
 
+func (n *FloatArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *FloatArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('F')
+}
+
 // This implements the Object interface for arrays of Doubles.
-type DoubleArray []Double
+type DoubleArray struct {
+	Elements []Double
+	objectMonitor
+}
 
-func (n DoubleArray) IsPrimitive() bool {
+func (n *DoubleArray) IsPrimitive() bool {
 	return false
 }
 
-func (n DoubleArray) TypeName() string {
+func (n *DoubleArray) TypeName() string {
 	return "double[]"
 }
 
-func (n DoubleArray) String() string {
+func (n *DoubleArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.FormatFloat(float64(v), 'g', 5, 64)
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -99,22 +167,42 @@ func (n DoubleArray) String() string {
 	return s
 }
 
-// This implements the Object interface for arrays of references.
-type ReferenceArray []Object
+func (n *DoubleArray) Length() int {
+	return len(n.Elements)
+}
 
-func (n ReferenceArray) IsPrimitive() bool {
+func (n *DoubleArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('D')
+}
+
+// This implements the Object interface for arrays of references, including
+// arrays of other arrays.
+type ReferenceArray struct {
+	// The type of the array itself, e.g. java/lang/String[]. This is used to
+	// check that only compatible references are stored in the array.
+	Type *class_file.ArrayType
+	// The array's contents. Null elements may be nil.
+	Elements []Object
+	objectMonitor
+}
+
+func (n *ReferenceArray) IsPrimitive() bool {
 	return false
 }
 
-func (n ReferenceArray) TypeName() string {
-	return "Object[]"
+func (n *ReferenceArray) TypeName() string {
+	return n.Type.String()
 }
 
-func (n ReferenceArray) String() string {
+func (n *ReferenceArray) String() string {
 	s := "["
-	for i, v := range n {
-		s += v.String()
-		if i < (len(n) - 1) {
+	for i, v := range n.Elements {
+		if v == nil {
+			s += "null"
+		} else {
+			s += v.String()
+		}
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -122,22 +210,68 @@ func (n ReferenceArray) String() string {
 	return s
 }
 
+func (n *ReferenceArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *ReferenceArray) ArrayType() *class_file.ArrayType {
+	return n.Type
+}
+
 // This implements the Object interface for arrays of bytes.
-type ByteArray []Byte
+type ByteArray struct {
+	Elements []Byte
+	objectMonitor
+}
 
-func (n ByteArray) IsPrimitive() bool {
+func (n *ByteArray) IsPrimitive() bool {
 	return false
 }
 
-func (n ByteArray) TypeName() string {
+func (n *ByteArray) TypeName() string {
 	return "byte[]"
 }
 
-func (n ByteArray) String() string {
+func (n *ByteArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.Itoa(int(int8(v)))
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
+			s += ","
+		}
+	}
+	s += "]"
+	return s
+}
+
+func (n *ByteArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *ByteArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('B')
+}
+
+// This implements the Object interface for arrays of booleans. Like boolean
+// fields, each element is a Byte that is either 0 (false) or 1 (true).
+type BooleanArray struct {
+	Elements []Byte
+	objectMonitor
+}
+
+func (n *BooleanArray) IsPrimitive() bool {
+	return false
+}
+
+func (n *BooleanArray) TypeName() string {
+	return "boolean[]"
+}
+
+func (n *BooleanArray) String() string {
+	s := "["
+	for i, v := range n.Elements {
+		s += strconv.FormatBool(v != 0)
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
@@ -145,44 +279,223 @@ func (n ByteArray) String() string {
 	return s
 }
 
+func (n *BooleanArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *BooleanArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('Z')
+}
+
 // This implements the Object interface for arrays of chars.
-type CharArray []Char
+type CharArray struct {
+	Elements []Char
+	objectMonitor
+}
 
-func (n CharArray) IsPrimitive() bool {
+func (n *CharArray) IsPrimitive() bool {
 	return false
 }
 
-func (n CharArray) TypeName() string {
+func (n *CharArray) TypeName() string {
 	return "char[]"
 }
 
-func (n CharArray) String() string {
+func (n *CharArray) String() string {
 	s := ""
-	for _, v := range n {
+	for _, v := range n.Elements {
 		s += string(rune(v))
 	}
 	return fmt.Sprintf("%q", s)
 }
 
+func (n *CharArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *CharArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('C')
+}
+
 // This implements the Object interface for arrays of shorts.
-type ShortArray []Short
+type ShortArray struct {
+	Elements []Short
+	objectMonitor
+}
 
-func (n ShortArray) IsPrimitive() bool {
+func (n *ShortArray) IsPrimitive() bool {
 	return false
 }
 
-func (n ShortArray) TypeName() string {
+func (n *ShortArray) TypeName() string {
 	return "short[]"
 }
 
-func (n ShortArray) String() string {
+func (n *ShortArray) String() string {
 	s := "["
-	for i, v := range n {
+	for i, v := range n.Elements {
 		s += strconv.Itoa(int(int16(v)))
-		if i < (len(n) - 1) {
+		if i < (len(n.Elements) - 1) {
 			s += ","
 		}
 	}
 	s += "]"
 	return s
 }
+
+func (n *ShortArray) Length() int {
+	return len(n.Elements)
+}
+
+func (n *ShortArray) ArrayType() *class_file.ArrayType {
+	return primitiveArrayType('S')
+}
+
+// Creates a new array with the given type and number of elements. Elements
+// are initialized to zero, or null for arrays of references. Returns a
+// NegativeArraySizeError if the length is negative.
+func NewArray(t *class_file.ArrayType, length Int) (Array, error) {
+	if length < 0 {
+		return nil, NegativeArraySizeError(length)
+	}
+	primitive, isPrimitive := t.ContentType.(class_file.PrimitiveFieldType)
+	if (t.Dimensions != 1) || !isPrimitive {
+		return &ReferenceArray{
+			Type:     t,
+			Elements: make([]Object, length),
+		}, nil
+	}
+	var toReturn Array
+	switch primitive {
+	case 'B':
+		toReturn = &ByteArray{
+			Elements: make([]Byte, length),
+		}
+	case 'C':
+		toReturn = &CharArray{
+			Elements: make([]Char, length),
+		}
+	case 'D':
+		toReturn = &DoubleArray{
+			Elements: make([]Double, length),
+		}
+	case 'F':
+		toReturn = &FloatArray{
+			Elements: make([]Float, length),
+		}
+	case 'I':
+		toReturn = &IntArray{
+			Elements: make([]Int, length),
+		}
+	case 'J':
+		toReturn = &LongArray{
+			Elements: make([]Long, length),
+		}
+	case 'S':
+		toReturn = &ShortArray{
+			Elements: make([]Short, length),
+		}
+	case 'Z':
+		toReturn = &BooleanArray{
+			Elements: make([]Byte, length),
+		}
+	default:
+		return nil, fmt.Errorf("Invalid array element type: %s", primitive)
+	}
+	return toReturn, nil
+}
+
+// Creates a new multidimensional array with the given type. The lengths slice
+// specifies the length of each dimension, starting with the outermost one.
+// It may contain fewer lengths than the array has dimensions, in which case
+// the innermost arrays are left null.
+func NewMultiArray(t *class_file.ArrayType, lengths []Int) (Array, error) {
+	if len(lengths) == 0 {
+		return nil, fmt.Errorf("No lengths given for a new %s", t)
+	}
+	if len(lengths) > int(t.Dimensions) {
+		return nil, fmt.Errorf("Got %d lengths for a new %s", len(lengths), t)
+	}
+	// Java checks all of the lengths before creating any arrays.
+	for _, length := range lengths {
+		if length < 0 {
+			return nil, NegativeArraySizeError(length)
+		}
+	}
+	toReturn, e := NewArray(t, lengths[0])
+	if e != nil {
+		return nil, e
+	}
+	if len(lengths) == 1 {
+		return toReturn, nil
+	}
+	componentType := ComponentType(t).(*class_file.ArrayType)
+	elements := toReturn.(*ReferenceArray).Elements
+	for i := range elements {
+		elements[i], e = NewMultiArray(componentType, lengths[1:])
+		if e != nil {
+			return nil, e
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns a new String[] array containing the given strings.
+func NewStringArray(values []string) *ReferenceArray {
+	elements := make([]Object, len(values))
+	for i, v := range values {
+		elements[i] = NewStringObject(v)
+	}
+	return &ReferenceArray{
+		Type: &class_file.ArrayType{
+			Dimensions:  1,
+			ContentType: class_file.ClassInstanceType("java/lang/String"),
+		},
+		Elements: elements,
+	}
+}
+
+// Returns a shallow copy of the given array, like calling clone() on an array
+// in Java. For arrays of references, the copy refers to the same objects.
+func CopyArray(a Array) (Array, error) {
+	switch v := a.(type) {
+	case *IntArray:
+		return &IntArray{
+			Elements: append([]Int{}, v.Elements...),
+		}, nil
+	case *LongArray:
+		return &LongArray{
+			Elements: append([]Long{}, v.Elements...),
+		}, nil
+	case *FloatArray:
+		return &FloatArray{
+			Elements: append([]Float{}, v.Elements...),
+		}, nil
+	case *DoubleArray:
+		return &DoubleArray{
+			Elements: append([]Double{}, v.Elements...),
+		}, nil
+	case *ReferenceArray:
+		return &ReferenceArray{
+			Type:     v.Type,
+			Elements: append([]Object{}, v.Elements...),
+		}, nil
+	case *ByteArray:
+		return &ByteArray{
+			Elements: append([]Byte{}, v.Elements...),
+		}, nil
+	case *BooleanArray:
+		return &BooleanArray{
+			Elements: append([]Byte{}, v.Elements...),
+		}, nil
+	case *CharArray:
+		return &CharArray{
+			Elements: append([]Char{}, v.Elements...),
+		}, nil
+	case *ShortArray:
+		return &ShortArray{
+			Elements: append([]Short{}, v.Elements...),
+		}, nil
+	}
+	return nil, TypeError("Can't copy array of type " + a.TypeName())
+}
@@ -0,0 +1,156 @@
+package bs_jvm
+
+import (
+	"github.com/yalue/bs_jvm/class_file"
+	"testing"
+)
+
+func TestNewMultiArray(t *testing.T) {
+	intArray3D, e := class_file.ParseFieldType([]byte("[[[I"))
+	if e != nil {
+		t.Logf("Failed parsing array type: %s\n", e)
+		t.FailNow()
+	}
+	arrayType := intArray3D.(*class_file.ArrayType)
+	a, e := NewMultiArray(arrayType, []Int{2, 3})
+	if e != nil {
+		t.Logf("Failed creating multi-dimensional array: %s\n", e)
+		t.FailNow()
+	}
+	t.Logf("Created %s: %s\n", a.TypeName(), a)
+	outer := a.(*ReferenceArray)
+	if outer.Length() != 2 {
+		t.Logf("Expected an outer length of 2, got %d\n", outer.Length())
+		t.FailNow()
+	}
+	inner, ok := outer.Elements[1].(*ReferenceArray)
+	if !ok {
+		t.Logf("Expected a reference array, got %s\n", outer.Elements[1])
+		t.FailNow()
+	}
+	if inner.Length() != 3 {
+		t.Logf("Expected an inner length of 3, got %d\n", inner.Length())
+		t.Fail()
+	}
+	if inner.TypeName() != "int[][]" {
+		t.Logf("Expected an int[][] inner array, got %s\n", inner.TypeName())
+		t.Fail()
+	}
+	if inner.Elements[0] != nil {
+		t.Logf("Innermost arrays should be null, got %s\n", inner.Elements[0])
+		t.Fail()
+	}
+	if outer.Elements[0] == outer.Elements[1] {
+		t.Logf("Distinct arrays shouldn't be equal\n")
+		t.Fail()
+	}
+
+	_, e = NewMultiArray(arrayType, []Int{2, -1})
+	_, ok = e.(NegativeArraySizeError)
+	if !ok {
+		t.Logf("Expected a NegativeArraySizeError, got %v\n", e)
+		t.Fail()
+	}
+	booleans, e := NewArray(primitiveArrayType('Z'), 2)
+	if e != nil {
+		t.Logf("Failed creating boolean array: %s\n", e)
+		t.FailNow()
+	}
+	if booleans.String() != "[false,false]" {
+		t.Logf("Got incorrect boolean array contents: %s\n", booleans)
+		t.Fail()
+	}
+}
+
+func TestArrayAssignability(t *testing.T) {
+	jvm := NewJVM()
+	loadStubClasses(t, jvm,
+		getFieldTestClassFile("Parent", "java/lang/Object", nil),
+		getFieldTestClassFile("Child", "Parent", nil))
+	parse := func(s string) class_file.FieldType {
+		toReturn, e := class_file.ParseFieldType([]byte(s))
+		if e != nil {
+			t.Logf("Failed parsing type %s: %s\n", s, e)
+			t.FailNow()
+		}
+		return toReturn
+	}
+	expected := []struct {
+		src, dst   string
+		assignable bool
+	}{
+		{"LChild;", "LParent;", true},
+		{"LParent;", "LChild;", false},
+		{"[LChild;", "[LParent;", true},
+		{"[LParent;", "[LChild;", false},
+		{"[[LChild;", "[[LParent;", true},
+		{"[[LChild;", "[Ljava/lang/Object;", true},
+		{"[I", "Ljava/lang/Object;", true},
+		{"[I", "Ljava/lang/Cloneable;", true},
+		{"[I", "[I", true},
+		{"[I", "[J", false},
+		{"[I", "[Ljava/lang/Object;", false},
+		{"LParent;", "[LParent;", false},
+	}
+	for _, x := range expected {
+		result := jvm.IsAssignableType(parse(x.src), parse(x.dst))
+		if result != x.assignable {
+			t.Logf("Expected assignability of %s to %s to be %v\n", x.src,
+				x.dst, x.assignable)
+			t.Fail()
+		}
+	}
+
+	// Storing a Parent in a Child[] that's referred to as a Parent[] should
+	// fail.
+	parentClass, _ := jvm.GetClass("Parent")
+	parent, e := parentClass.CreateInstance()
+	if e != nil {
+		t.Logf("Failed creating Parent instance: %s\n", e)
+		t.FailNow()
+	}
+	children := parse("[LChild;").(*class_file.ArrayType)
+	if jvm.IsInstanceOf(parent, ComponentType(children)) {
+		t.Logf("A Parent instance shouldn't be an instance of Child\n")
+		t.Fail()
+	}
+	if !jvm.IsInstanceOf(NewStringArray([]string{"a"}),
+		parse("[Ljava/lang/String;")) {
+		t.Logf("A String[] should be an instance of String[]\n")
+		t.Fail()
+	}
+	if jvm.IsInstanceOf(nil, parse("Ljava/lang/Object;")) {
+		t.Logf("null shouldn't be an instance of Object\n")
+		t.Fail()
+	}
+}
+
+func TestCopyArray(t *testing.T) {
+	original := &IntArray{
+		Elements: []Int{1, 2, 3},
+	}
+	tmp, e := CopyArray(original)
+	if e != nil {
+		t.Logf("Failed copying int array: %s\n", e)
+		t.FailNow()
+	}
+	copied := tmp.(*IntArray)
+	copied.Elements[0] = 1337
+	if (original.Elements[0] != 1) || (copied.Elements[1] != 2) ||
+		(copied.Length() != 3) {
+		t.Logf("Got incorrect copy of %s: %s\n", original, copied)
+		t.Fail()
+	}
+	strings := NewStringArray([]string{"a", "b"})
+	tmp, e = CopyArray(strings)
+	if e != nil {
+		t.Logf("Failed copying String array: %s\n", e)
+		t.FailNow()
+	}
+	copiedStrings := tmp.(*ReferenceArray)
+	if (copiedStrings == strings) || (copiedStrings.Type != strings.Type) ||
+		(copiedStrings.Elements[1] != strings.Elements[1]) {
+		t.Logf("Copying a String array wasn't shallow: %s\n", copiedStrings)
+		t.Fail()
+	}
+}
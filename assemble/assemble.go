@@ -0,0 +1,57 @@
+// This is a command-line tool for creating a class file from a text file in
+// the format described by the class_file/assembly package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file/assembly"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func run() int {
+	var filename, output string
+	flag.StringVar(&filename, "filename", "",
+		"The name of the assembly source file.")
+	flag.StringVar(&output, "output", "", "The name of the class file to "+
+		"create. Defaults to the source file's name with a .class extension.")
+	flag.Parse()
+	if filename == "" {
+		fmt.Println("Invalid arguments. Run with -help for more information.")
+		return 1
+	}
+	if output == "" {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) +
+			".class"
+	}
+	f, e := os.Open(filename)
+	if e != nil {
+		fmt.Printf("Failed opening %s: %s\n", filename, e)
+		return 1
+	}
+	defer f.Close()
+	class, e := assembly.Assemble(f)
+	if e != nil {
+		fmt.Printf("Failed assembling %s: %s\n", filename, e)
+		return 1
+	}
+	data, e := class.Bytes()
+	if e != nil {
+		fmt.Printf("Failed building class %s: %s\n", class.Name(), e)
+		return 1
+	}
+	e = ioutil.WriteFile(output, data, 0644)
+	if e != nil {
+		fmt.Printf("Failed writing %s: %s\n", output, e)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	// Idiom to allow defer statements in the main routine
+	os.Exit(run())
+}
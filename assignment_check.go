@@ -5,12 +5,28 @@ package bs_jvm
 
 import (
 	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
 )
 
 // Returns nil if it's okay to overwrite object dst with src. This means the
 // types must be compatible.  Largely intended to be used when storing
 // variables in fields.
 func AssignmentOK(src, dst Object) error {
+	// A nil Object is a null reference, which can only overwrite, or be
+	// overwritten by, other references.
+	if src == nil {
+		if (dst != nil) && dst.IsPrimitive() {
+			return TypeError("Can't overwrite a " + dst.TypeName() +
+				" with null")
+		}
+		return nil
+	}
+	if dst == nil {
+		if src.IsPrimitive() {
+			return TypeError("Can't overwrite null with " + src.TypeName())
+		}
+		return nil
+	}
 	if src.IsPrimitive() != dst.IsPrimitive() {
 		return TypeError(fmt.Sprintf("Can't overwrite a %s with %s",
 			dst.TypeName(), src.TypeName()))
@@ -20,3 +36,96 @@ func AssignmentOK(src, dst Object) error {
 	// or non-primitives.
 	return nil
 }
+
+// Returns the type of the given non-null reference, for use when checking
+// whether it's an instance of some other type. Returns nil if the reference's
+// type isn't known.
+func getReferenceType(o Object) class_file.FieldType {
+	switch v := o.(type) {
+	case *ClassInstance:
+		return class_file.ClassInstanceType(v.C.Name)
+	case *StringObject:
+		return class_file.ClassInstanceType("java/lang/String")
+	case *Class:
+		return class_file.ClassInstanceType("java/lang/Class")
+	case Array:
+		return v.ArrayType()
+	}
+	return nil
+}
+
+// Returns true if the src class is the same as, or a subclass or
+// implementation of, the dst class. Both classes must already be loaded,
+// unless they're the same class, or dst is java/lang/Object.
+func (j *JVM) isSubclassByName(src, dst string) bool {
+	if (src == dst) || (dst == "java/lang/Object") {
+		return true
+	}
+	srcClass, e := j.GetClass(src)
+	if e != nil {
+		return false
+	}
+	dstClass, e := j.GetClass(dst)
+	if e != nil {
+		return false
+	}
+	return srcClass.IsSubclassOf(dstClass)
+}
+
+// Returns true if a reference of type src can be assigned to a variable of
+// type dst, following the rules used by the checkcast and aastore
+// instructions in the JVM spec. Primitive types are only assignable to
+// themselves.
+func (j *JVM) IsAssignableType(src, dst class_file.FieldType) bool {
+	switch d := dst.(type) {
+	case class_file.PrimitiveFieldType:
+		s, ok := src.(class_file.PrimitiveFieldType)
+		return ok && (s == d)
+	case class_file.ClassInstanceType:
+		switch s := src.(type) {
+		case class_file.ClassInstanceType:
+			return j.isSubclassByName(string(s), string(d))
+		case *class_file.ArrayType:
+			return (d == "java/lang/Object") || (d == "java/lang/Cloneable") ||
+				(d == "java/io/Serializable")
+		}
+		return false
+	case *class_file.ArrayType:
+		s, ok := src.(*class_file.ArrayType)
+		if !ok {
+			return false
+		}
+		return j.IsAssignableType(ComponentType(s), ComponentType(d))
+	}
+	return false
+}
+
+// Returns true if the given reference is an instance of the given type. Null
+// references aren't instances of any type.
+func (j *JVM) IsInstanceOf(o Object, t class_file.FieldType) bool {
+	if IsNull(o) {
+		return false
+	}
+	// Use the actual class of class instances, in case the class isn't
+	// registered with the JVM.
+	instance, ok := o.(*ClassInstance)
+	if ok {
+		dst, isClass := t.(class_file.ClassInstanceType)
+		if !isClass {
+			return false
+		}
+		if dst == "java/lang/Object" {
+			return true
+		}
+		dstClass, e := j.GetClass(string(dst))
+		if e != nil {
+			return false
+		}
+		return instance.C.IsSubclassOf(dstClass)
+	}
+	src := getReferenceType(o)
+	if src == nil {
+		return false
+	}
+	return j.IsAssignableType(src, t)
+}
@@ -0,0 +1,127 @@
+package bs_jvm
+
+// This file contains the internal representation of instances of the classes
+// that wrap primitive values, such as java/lang/Integer.
+
+import (
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+	"strconv"
+)
+
+// Maps each primitive type to the name of the class that wraps it.
+var boxedClassNames = map[class_file.PrimitiveFieldType]string{
+	'Z': "java/lang/Boolean",
+	'B': "java/lang/Byte",
+	'C': "java/lang/Character",
+	'S': "java/lang/Short",
+	'I': "java/lang/Integer",
+	'J': "java/lang/Long",
+	'F': "java/lang/Float",
+	'D': "java/lang/Double",
+}
+
+// Returns the name of the class wrapping the given primitive type, e.g.
+// "java/lang/Integer" for 'I'. Returns an empty string if the type isn't a
+// valid primitive type.
+func BoxedClassName(t class_file.PrimitiveFieldType) string {
+	return boxedClassNames[t]
+}
+
+// Holds the internal state of an instance of one of the classes wrapping a
+// primitive value, e.g. java/lang/Integer. This is stored in the instance's
+// NativeData.
+type BoxedValue struct {
+	// The type of the wrapped value, e.g. 'I' for java/lang/Integer.
+	Type class_file.PrimitiveFieldType
+	// The wrapped value. Like values on the stack, booleans, bytes, chars,
+	// and shorts are stored as an Int.
+	Value Object
+}
+
+// Returns the BoxedValue held by the object, or nil if the object isn't an
+// instance of one of the classes wrapping a primitive value.
+func GetBoxedValue(o Object) *BoxedValue {
+	instance, ok := o.(*ClassInstance)
+	if !ok {
+		return nil
+	}
+	toReturn, _ := instance.NativeData.(*BoxedValue)
+	return toReturn
+}
+
+// Returns the wrapped value as a string, in the same format as the wrapping
+// class' toString() method.
+func (b *BoxedValue) JavaString() string {
+	switch v := b.Value.(type) {
+	case Int:
+		if b.Type == 'Z' {
+			return strconv.FormatBool(v != 0)
+		}
+		if b.Type == 'C' {
+			return string(rune(uint16(v)))
+		}
+		return strconv.Itoa(int(v))
+	case Long:
+		return strconv.FormatInt(int64(v), 10)
+	case Float:
+		return v.JavaString()
+	case Double:
+		return v.JavaString()
+	}
+	return b.Value.String()
+}
+
+// Returns the wrapped value's hash code, matching the wrapping class'
+// hashCode() method.
+func (b *BoxedValue) HashCode() Int {
+	switch v := b.Value.(type) {
+	case Int:
+		if b.Type == 'Z' {
+			if v != 0 {
+				return 1231
+			}
+			return 1237
+		}
+		return v
+	case Long:
+		return Int(v ^ Long(uint64(v)>>32))
+	case Float:
+		bits := math.Float32bits(float32(v))
+		if v != v {
+			// Like Java's floatToIntBits, use a single canonical NaN.
+			bits = 0x7fc00000
+		}
+		return Int(bits)
+	case Double:
+		bits := math.Float64bits(float64(v))
+		if v != v {
+			bits = 0x7ff8000000000000
+		}
+		return Int(bits ^ (bits >> 32))
+	}
+	return 0
+}
+
+// Returns a new instance of the class wrapping the given primitive type,
+// holding the value v. The class must already be loaded.
+func (j *JVM) Box(t class_file.PrimitiveFieldType, v Object) (*ClassInstance,
+	error) {
+	name := BoxedClassName(t)
+	if name == "" {
+		return nil, TypeError("Invalid primitive type: " + t.String())
+	}
+	c, e := j.GetClass(name)
+	if e != nil {
+		return nil, e
+	}
+	toReturn, e := c.CreateInstance()
+	if e != nil {
+		return nil, e
+	}
+	toReturn.NativeData = &BoxedValue{
+		Type:  t,
+		Value: v,
+	}
+	return toReturn, nil
+}
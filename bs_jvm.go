@@ -4,6 +4,7 @@
 package bs_jvm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/yalue/bs_jvm/class_file"
 	"io"
@@ -13,6 +14,12 @@ import (
 
 // Holds the state of a single JVM thread.
 type Thread struct {
+	// The thread's name, used in stack traces for uncaught exceptions. Java
+	// code may rename a running thread, so once the thread has started, only
+	// access this using GetName and SetName.
+	Name string
+	// Protects the Name field.
+	nameLock sync.Mutex
 	// The method that the thread is currently executing.
 	CurrentMethod *Method
 	// A pointer to the JVM running this thread.
@@ -25,6 +32,9 @@ type Thread struct {
 	Stack ThreadStack
 	// The list of local variables, starting with arguments.
 	LocalVariables []Object
+	// The monitor that was entered when calling CurrentMethod, if it's
+	// synchronized. This is nil if CurrentMethod isn't synchronized.
+	methodMonitor *Monitor
 	// A channel that will contain the thread exit reason when the thread has
 	// finished.
 	threadComplete chan error
@@ -36,6 +46,30 @@ type Thread struct {
 	// (INCLUDING JUST FOR READS) WHILE HOLDING THE PARENT JVM THREAD LIST
 	// LOCK.
 	threadIndex int
+	// If true, WaitForAllThreads won't wait for this thread to exit. Must be
+	// set before the thread is started.
+	Daemon bool
+	// The java/lang/Thread object representing this thread, if one has been
+	// created. Used by the builtin Thread class.
+	JavaThread Object
+	// Closed when the thread exits, after ThreadExitReason has been set. This
+	// can be used by any number of waiters, unlike threadComplete.
+	done chan bool
+	// Protects the interrupted and interruptSignal fields.
+	interruptLock sync.Mutex
+	// True if the thread has been interrupted, and the interrupt hasn't been
+	// handled or cleared.
+	interrupted bool
+	// Closed when the thread is interrupted, in order to wake it up if it's
+	// blocked. Replaced when the interrupt is cleared.
+	interruptSignal chan bool
+	// Set when returning to a frame pushed by Invoke, so that Invoke knows
+	// when the method it called has returned.
+	returnedToNative bool
+	// The number of calls to Invoke that haven't returned yet. Methods called
+	// while this is nonzero don't need a valid return address, since Invoke
+	// restores the instruction index itself.
+	invokeDepth int
 }
 
 // This method will cause a thread to start running. The thread will run
@@ -43,39 +77,84 @@ type Thread struct {
 // to start.
 func (t *Thread) Run() error {
 	go func() {
-		traceSink := t.ParentJVM.TraceSink
-		var e error
-		var n Instruction
+		// Starting a thread in a static method initializes the method's
+		// class, like invokestatic.
+		e := t.initializeStartClass()
+		var monitor *Monitor
+		if e == nil {
+			// The thread's initial method may be synchronized, too.
+			monitor, e = getMethodMonitor(t.CurrentMethod, t.LocalVariables)
+		}
+		if monitor != nil {
+			monitor.Enter(t)
+			t.methodMonitor = monitor
+		}
 		for e == nil {
-			if t.ThreadExitReason != nil {
-				t.threadComplete <- t.ThreadExitReason
-				close(t.threadComplete)
-				return
-			}
-			if t.InstructionIndex >= uint(len(t.CurrentMethod.Instructions)) {
-				e = fmt.Errorf("Invalid instruction index: %d",
-					t.InstructionIndex)
-				break
-			}
-			t.WasBranch = false
-			n = t.CurrentMethod.Instructions[t.InstructionIndex]
-			if traceSink != nil {
-				fmt.Fprintf(traceSink, "Running instruction: %s\n", n.String())
-			}
-			e = n.Execute(t)
-			if !t.WasBranch {
-				// Go to the next instruction in the sequence if we didn't
-				// encounter a branch.
-				t.InstructionIndex++
-			}
+			e = t.runInstruction()
 		}
 		t.ThreadExitReason = e
+		if t.done != nil {
+			close(t.done)
+		}
 		t.threadComplete <- e
 		close(t.threadComplete)
 	}()
 	return nil
 }
 
+// Initializes the class containing the thread's initial method, if the method
+// is static. Exceptions thrown while initializing the class aren't handled by
+// the thread's initial method, so they're reported the same way as any other
+// uncaught exception.
+func (t *Thread) initializeStartClass() error {
+	if !t.CurrentMethod.IsStatic() {
+		return nil
+	}
+	e := t.InitializeClass(t.CurrentMethod.ContainingClass)
+	if e == nil {
+		return nil
+	}
+	var thrown *ThrownException
+	if errors.As(e, &thrown) {
+		t.reportUncaughtException(thrown.Exception)
+	}
+	return e
+}
+
+// Runs the thread's current instruction, and advances to the next one. If the
+// instruction throws an exception, this continues in the exception's handler.
+// Returns an error if the thread should stop running, including a
+// ThreadExitedError if the thread's initial method returned.
+func (t *Thread) runInstruction() error {
+	if t.ThreadExitReason != nil {
+		return t.ThreadExitReason
+	}
+	if t.InstructionIndex >= uint(len(t.CurrentMethod.Instructions)) {
+		return fmt.Errorf("Invalid instruction index: %d", t.InstructionIndex)
+	}
+	t.WasBranch = false
+	n := t.CurrentMethod.Instructions[t.InstructionIndex]
+	traceSink := t.ParentJVM.TraceSink
+	if traceSink != nil {
+		fmt.Fprintf(traceSink, "Running instruction: %s\n", n.String())
+	}
+	e := n.Execute(t)
+	if (e != nil) && (e != ThreadExitedError) {
+		// This returns nil if a handler for the exception was found, in which
+		// case we'll continue running in the handler.
+		return t.HandleException(e)
+	}
+	if e != nil {
+		return e
+	}
+	if !t.WasBranch {
+		// Go to the next instruction in the sequence if we didn't encounter a
+		// branch.
+		t.InstructionIndex++
+	}
+	return nil
+}
+
 // This will return when the thread is complete. Returns the reason the thread
 // exited (will return ThreadExitedError on a normal exit, rather than nil.
 func (t *Thread) WaitForCompletion() error {
@@ -111,6 +190,7 @@ func (t *Thread) GetReturnInfo() ReturnInfo {
 		ReturnIndex:    t.InstructionIndex + 1,
 		StackState:     t.Stack.GetSizes(),
 		LocalVariables: t.LocalVariables,
+		Monitor:        t.methodMonitor,
 	}
 }
 
@@ -123,9 +203,68 @@ func (t *Thread) RestoreReturnInfo(r *ReturnInfo) error {
 		return e
 	}
 	t.LocalVariables = r.LocalVariables
+	t.methodMonitor = r.Monitor
+	if r.NativeCall {
+		t.returnedToNative = true
+	}
 	return nil
 }
 
+// Returns the monitor that must be held while running the given method, or
+// nil if the method isn't synchronized. The locals must contain the method's
+// arguments, including the object it was invoked on if it isn't static.
+func getMethodMonitor(method *Method, locals []Object) (*Monitor, error) {
+	if !method.AccessFlags.IsSynchronized() {
+		return nil, nil
+	}
+	if method.IsStatic() {
+		return method.ContainingClass.GetMonitor(), nil
+	}
+	if len(locals) == 0 {
+		return nil, fmt.Errorf("Missing object reference for synchronized "+
+			"method %s", method.Name)
+	}
+	return GetMonitor(locals[0])
+}
+
+// Exits the monitor held by the current method if it's synchronized. Must be
+// called before leaving the current method, whether by returning or due to an
+// exception.
+func (t *Thread) exitMethodMonitor() error {
+	monitor := t.methodMonitor
+	if monitor == nil {
+		return nil
+	}
+	t.methodMonitor = nil
+	return monitor.Exit(t)
+}
+
+// Calls a native method that's synchronized, holding its monitor while the Go
+// function runs. Native methods take their arguments directly from the stack,
+// so this finds the object the method was invoked on there.
+func (t *Thread) callSynchronizedNative(method *Method) error {
+	var monitor *Monitor
+	if method.IsStatic() {
+		monitor = method.ContainingClass.GetMonitor()
+	} else {
+		o, e := t.Stack.PeekRef(countReferenceArgs(method))
+		if e != nil {
+			return e
+		}
+		monitor, e = GetMonitor(o)
+		if e != nil {
+			return e
+		}
+	}
+	monitor.Enter(t)
+	e := method.Native(t)
+	exitError := monitor.Exit(t)
+	if e != nil {
+		return e
+	}
+	return exitError
+}
+
 // Populates the first local variables with the corresponding number of method
 // arguments, popping the args from the current thread's stack. If the method
 // is non-static, then this will also set locals[0] to the object reference on
@@ -227,16 +366,26 @@ func (t *Thread) Call(method *Method) error {
 	// First, check for a native implementation; there's no further action if
 	// we're just calling something native.
 	if method.Native != nil {
+		if method.AccessFlags.IsSynchronized() {
+			return t.callSynchronizedNative(method)
+		}
 		return method.Native(t)
 	}
-	if (t.InstructionIndex + 1) >= uint(len(t.CurrentMethod.Instructions)) {
+	instructionCount := uint(len(t.CurrentMethod.Instructions))
+	if (t.invokeDepth == 0) && ((t.InstructionIndex + 1) >= instructionCount) {
 		return fmt.Errorf("Invalid return address (inst. index %d)",
 			t.InstructionIndex)
 	}
+	// Other threads may be optimizing the method, so its instructions can't
+	// be accessed until this returns.
 	e := method.Optimize()
 	if e != nil {
 		return e
 	}
+	if len(method.Instructions) == 0 {
+		return fmt.Errorf("Can't call %s.%s: it has no code",
+			method.ContainingClass.Name, method.Name)
+	}
 	// TODO: Optimize local variable allocation so we don't have an allocation
 	// per method invocation. IDEA: Each thread maintains a simple "stack" of
 	// local variables, grown if needed. When popping local variables, etc, we
@@ -247,21 +396,125 @@ func (t *Thread) Call(method *Method) error {
 	if e != nil {
 		return fmt.Errorf("Error initializing method arguments: %w", e)
 	}
+	monitor, e := getMethodMonitor(method, newLocals)
+	if e != nil {
+		return e
+	}
 	e = t.Stack.PushFrame(t.GetReturnInfo())
 	if e != nil {
 		return e
 	}
+	if monitor != nil {
+		monitor.Enter(t)
+	}
 	// Don't increment the PC after calling a method.
 	t.WasBranch = true
 	t.LocalVariables = newLocals
 	t.CurrentMethod = method
 	t.InstructionIndex = 0
+	t.methodMonitor = monitor
 	return nil
 }
 
+// Pops a value of the given type from the stack. Returns nil without popping
+// anything if the type is void.
+func (t *Thread) popValue(valueType class_file.FieldType) (Object, error) {
+	p, isPrimitive := valueType.(class_file.PrimitiveFieldType)
+	if !isPrimitive {
+		return t.Stack.PopRef()
+	}
+	switch p {
+	case 'V':
+		return nil, nil
+	case 'B', 'C', 'S', 'Z', 'I':
+		return t.Stack.Pop()
+	case 'J':
+		return t.Stack.PopLong()
+	case 'F':
+		return t.Stack.PopFloat()
+	case 'D':
+		return t.Stack.PopDouble()
+	}
+	return nil, fmt.Errorf("Invalid primitive type: %s", p)
+}
+
+// Pops values with the given types from the stack, such as a method's
+// arguments. The values are returned in the order they were pushed.
+func (t *Thread) PopValues(types []class_file.FieldType) ([]Object, error) {
+	toReturn := make([]Object, len(types))
+	var e error
+	for i := len(types) - 1; i >= 0; i-- {
+		toReturn[i], e = t.popValue(types[i])
+		if e != nil {
+			return nil, e
+		}
+	}
+	return toReturn, nil
+}
+
+// Used by native methods to call a method and obtain its result. Unlike Call,
+// this runs the method to completion before returning, and returns the value
+// it returns, or nil if it returns void. The args must start with the object
+// the method is invoked on if it isn't static. Like Call, this invokes the
+// given method directly, without selecting a method based on the object's
+// class. If the method throws an exception, this returns the exception as a
+// ThrownException.
+func (t *Thread) Invoke(method *Method, args ...Object) (Object, error) {
+	var e error
+	for _, arg := range args {
+		e = t.Stack.PushUnconditional(arg)
+		if e != nil {
+			return nil, fmt.Errorf("Failed pushing arg for %s: %w",
+				method.Name, e)
+		}
+	}
+	callerIndex := t.InstructionIndex
+	t.WasBranch = false
+	t.invokeDepth++
+	defer func() {
+		t.invokeDepth--
+	}()
+	e = t.Call(method)
+	if e != nil {
+		return nil, e
+	}
+	// Call returns immediately after pushing a new frame if the method
+	// isn't native, or if a native method called a non-native one. In either
+	// case, run instructions until the frame returns. Exceptions that aren't
+	// caught also stop at this frame.
+	if t.WasBranch {
+		frame, e := t.Stack.PopFrame()
+		if e != nil {
+			return nil, e
+		}
+		frame.NativeCall = true
+		e = t.Stack.PushFrame(frame)
+		if e != nil {
+			return nil, e
+		}
+		for !t.returnedToNative {
+			e = t.runInstruction()
+			if e != nil {
+				break
+			}
+		}
+		t.returnedToNative = false
+		t.InstructionIndex = callerIndex
+		t.WasBranch = false
+		if e != nil {
+			return nil, e
+		}
+	}
+	return t.popValue(method.Types.ReturnType)
+}
+
 // Carries out a method return, popping a return location. If the thread's
 // initial method returns in the thread, this ends the thread and returns nil.
 func (t *Thread) Return() error {
+	e := t.exitMethodMonitor()
+	if e != nil {
+		return e
+	}
 	returnInfo, e := t.Stack.PopFrame()
 	if e == StackEmptyError {
 		t.EndThread(ThreadExitedError)
@@ -287,15 +540,42 @@ type JVM struct {
 	// to this only apply to newly created threads, so set this before running
 	// anything.
 	TraceSink io.Writer
-	// Maps class names to all loaded classes.
+	// Maps class names to all loaded classes. Acquire the classes lock before
+	// accessing this after any threads have been started.
 	Classes map[string]*Class
+	// This lock is acquired whenever the Classes map is accessed by the
+	// JVM's class-loading functions.
+	classesLock sync.Mutex
+	// The list of locations to search, in order, when a class that hasn't
+	// been loaded is needed.
+	ClassPath []ClassSource
+	// Stack traces for uncaught exceptions are written here. Defaults to
+	// os.Stderr.
+	ErrorSink io.Writer
+	// Used to generate names for threads that weren't given one.
+	threadCount int
+	// Maps strings to their canonical instances. Access this using
+	// InternString.
+	internedStrings     map[string]*StringObject
+	internedStringsLock sync.Mutex
+	// Holds the classes representing primitive types, which are created the
+	// first time they're needed. Access this using GetPrimitiveClass.
+	primitiveClasses     map[class_file.PrimitiveFieldType]*Class
+	primitiveClassesLock sync.Mutex
+	// Holds the classes of arrays, keyed by their descriptors, which are
+	// created the first time they're needed. Access this using
+	// GetArrayClass.
+	arrayClasses     map[string]*Class
+	arrayClassesLock sync.Mutex
 }
 
 // Returns a new, uninitialized, JVM instance.
 func NewJVM() *JVM {
 	return &JVM{
-		threads: make([]*Thread, 0, 1),
-		Classes: make(map[string]*Class),
+		threads:   make([]*Thread, 0, 1),
+		Classes:   make(map[string]*Class),
+		ClassPath: make([]ClassSource, 0, 4),
+		ErrorSink: os.Stderr,
 	}
 }
 
@@ -317,6 +597,9 @@ type Method struct {
 	// that doubles and longs will be counted twice here, which will currently
 	// waste a bit of space in our implementation... oh well.
 	MaxLocals int
+	// The maximum number of slots the method's operand stack may use. Longs
+	// and doubles use two slots.
+	MaxStack int
 	// Contains all parsed functions in the method.
 	Instructions []Instruction
 	// The raw binary of the function's code.
@@ -328,6 +611,26 @@ type Method struct {
 	// If this is non-nil, most of the other fields of the Method struct may be
 	// nil, so check this first when invoking a method.
 	Native NativeMethod
+	// The exception handlers for the method, in the order they must be
+	// checked. Populated by Optimize().
+	ExceptionHandlers []ExceptionHandler
+	// The method's exception table, as it appears in the class file. This is
+	// converted into ExceptionHandlers during Optimize().
+	exceptionTable []class_file.ExceptionTableEntry
+	// The method's line number table, if the class file contained one. Used
+	// for stack traces.
+	lineNumbers []class_file.LineNumberEntry
+	// The method's StackMapTable attribute, or nil if it doesn't have one.
+	// Used when verifying the method.
+	stackMapTable *class_file.Attribute
+	// Maps instruction indices to byte offsets in CodeBytes. Populated by
+	// Optimize().
+	instructionOffsets []uint
+	// Prevents multiple threads from optimizing the method at once.
+	optimizeLock sync.Mutex
+	// Holds the error returned by the first call to Optimize, if it failed,
+	// so that later calls return the same error.
+	optimizeError error
 }
 
 // Parses the given method from the class file into the structure needed by the
@@ -340,6 +643,20 @@ func (j *JVM) NewMethod(class *Class, index int) (*Method, error) {
 		return nil, fmt.Errorf("Invalid method index: %d", index)
 	}
 	method := classFile.Methods[index]
+	// Abstract and native methods don't have code. Native methods need to be
+	// provided by a builtin class, and abstract methods must never be called
+	// directly, so we'll just leave them with no instructions.
+	if method.Access.IsAbstract() || method.Access.IsNative() {
+		return &Method{
+			ContainingClass: class,
+			Name:            string(method.Name),
+			Types:           method.Descriptor,
+			AccessFlags:     method.Access,
+			Instructions:    nil,
+			CodeBytes:       nil,
+			OptimizeDone:    true,
+		}, nil
+	}
 	codeAttribute, e := method.GetCodeAttribute(classFile)
 	if e != nil {
 		return nil, fmt.Errorf("Failed getting method code attribute: %s", e)
@@ -358,110 +675,202 @@ func (j *JVM) NewMethod(class *Class, index int) (*Method, error) {
 		instructionCount++
 		address += instruction.Length()
 	}
+	// Line numbers are optional, so just ignore any that are malformed.
+	var lineNumbers []class_file.LineNumberEntry
+	var stackMapTable *class_file.Attribute
+	for _, a := range codeAttribute.Attributes {
+		if string(a.Name) == "StackMapTable" {
+			stackMapTable = a
+			continue
+		}
+		if string(a.Name) != "LineNumberTable" {
+			continue
+		}
+		entries, e := class_file.ParseLineNumberTableAttribute(a)
+		if e == nil {
+			lineNumbers = append(lineNumbers, entries...)
+		}
+	}
 	toReturn := Method{
 		ContainingClass: class,
 		Name:            string(method.Name),
 		Types:           method.Descriptor,
 		AccessFlags:     method.Access,
 		MaxLocals:       int(codeAttribute.MaxLocals),
+		MaxStack:        int(codeAttribute.MaxStack),
 		Instructions:    make([]Instruction, instructionCount),
 		CodeBytes:       codeBytes,
 		OptimizeDone:    false,
+		exceptionTable:  codeAttribute.ExceptionTable,
+		lineNumbers:     lineNumbers,
+		stackMapTable:   stackMapTable,
 	}
 	return &toReturn, nil
 }
 
 // This does the "optimization" pass on the method if it hasn't already been
 // done. Returns an error if one occurs. Immediately returns nil if
-// m.OptimizeDone is already true.
+// m.OptimizeDone is already true. Threads may call this concurrently, in which
+// case only one does the optimization, and the others wait for it and return
+// the same result.
 func (m *Method) Optimize() error {
+	m.optimizeLock.Lock()
+	defer m.optimizeLock.Unlock()
 	if m.OptimizeDone {
 		return nil
 	}
+	if m.optimizeError != nil {
+		return m.optimizeError
+	}
+	m.optimizeError = m.optimize()
+	return m.optimizeError
+}
+
+// Does the work of Optimize. The instructions are built separately, and only
+// stored in the Method if every step succeeds. Must be called while holding
+// m.optimizeLock.
+func (m *Method) optimize() error {
+	e := m.Verify()
+	if e != nil {
+		return e
+	}
 	address := uint(0)
-	var e error
 	var instruction Instruction
 	codeMemory := MemoryFromSlice(m.CodeBytes)
 	instructionCount := len(m.Instructions)
+	instructions := make([]Instruction, instructionCount)
 
 	// Create the instruction objects, and make a map of instruction offsets ->
 	// indices in the Instructions slice. This map is used in the next pass,
 	// when calling the "optimize" function.
 	offsetMap := make(map[uint]int)
+	instructionOffsets := make([]uint, instructionCount)
 	for i := 0; i < instructionCount; i++ {
 		instruction, e = GetNextInstruction(codeMemory, address)
 		if e != nil {
 			return fmt.Errorf("Error reading instruction: %s", e)
 		}
-		m.Instructions[i] = instruction
+		instructions[i] = instruction
+		instructionOffsets[i] = address
 		offsetMap[address] = i
 		address += instruction.Length()
 	}
+	exceptionHandlers, e := m.getExceptionHandlers(offsetMap,
+		instructionCount)
+	if e != nil {
+		return e
+	}
 
 	// Finally, call the "optimize" function on every instruction.
 	address = 0
-	for i := 0; i < len(m.Instructions); i++ {
-		instruction = m.Instructions[i]
+	for i := 0; i < len(instructions); i++ {
+		instruction = instructions[i]
 		e = instruction.Optimize(m, address, offsetMap)
 		if e != nil {
-			return fmt.Errorf("Error in optimization pass over %s: %s",
+			return fmt.Errorf("Error in optimization pass over %s: %w",
 				instruction, e)
 		}
 		address += instruction.Length()
 	}
+	m.Instructions = instructions
+	m.instructionOffsets = instructionOffsets
+	m.ExceptionHandlers = exceptionHandlers
 	m.OptimizeDone = true
 	return nil
 }
 
 // Returns true if this method is static.
 func (m *Method) IsStatic() bool {
-	return (m.AccessFlags & 0x0008) != 0
+	return m.AccessFlags.IsStatic()
 }
 
-// Adds the given class file to the JVM so that its code
+// Adds the given class file to the JVM so that its code can be run. This
+// doesn't initialize the class. If a class with the same name has already
+// been loaded, the existing class is kept.
 func (j *JVM) LoadClass(class *class_file.Class) error {
-	loadedClass, e := NewClass(j, class)
-	if e != nil {
-		return fmt.Errorf("Error loading class: %w", e)
-	}
-	j.Classes[string(loadedClass.Name)] = loadedClass
-	clinitKey := getClinitMethodKey()
-	_, e = loadedClass.GetMethod(clinitKey)
-	if e != nil {
-		_, clinitNotFound := e.(MethodNotFoundError)
-		if clinitNotFound {
-			// The class doesn't have a <clinit> method
-			return nil
-		}
-		return fmt.Errorf("Error looking up <clinit> method: %w", e)
-	}
-	clinitThread, e := j.StartThread(string(loadedClass.Name), clinitKey)
+	_, e := j.loadClass(class, nil)
+	return e
+}
+
+// Converts the class file to a Class and adds it to the map of loaded classes,
+// returning the Class that ends up in the map. If another thread loaded a
+// class with the same name first, this returns the existing Class instead, so
+// that every thread shares one copy of the class and its static fields. The
+// loading slice is passed to newClass.
+func (j *JVM) loadClass(class *class_file.Class, loading []string) (*Class,
+	error) {
+	loadedClass, e := newClass(j, class, loading)
 	if e != nil {
-		return fmt.Errorf("Error running <clinit> for %s: %w",
-			loadedClass.Name, e)
+		return nil, fmt.Errorf("Error loading class: %w", e)
 	}
-	e = clinitThread.WaitForCompletion()
-	if e == ThreadExitedError {
-		// The <clinit> method exited normally.
-		return nil
+	name := string(loadedClass.Name)
+	j.lockClasses()
+	defer j.unlockClasses()
+	existing := j.Classes[name]
+	if existing != nil {
+		return existing, nil
 	}
-	// NOTE: Maybe check if e is nil here? A successful thread exit shouldn't
-	// be nil, I think.
-	return e
+	j.Classes[name] = loadedClass
+	// The class' <clinit> method isn't run until the class is initialized,
+	// which happens the first time it's used. See Thread.InitializeClass.
+	return loadedClass, nil
 }
 
 // Returns a reference to the named class. Returns a ClassNotFoundError if the
 // class hasn't been loaded.
 func (j *JVM) GetClass(name string) (*Class, error) {
-	// TODO: Make a GetOrLoadClass function, that can potentially load classes
-	// during the "optimize" pass if they're needed.
+	j.lockClasses()
 	toReturn := j.Classes[name]
+	j.unlockClasses()
 	if toReturn == nil {
 		return nil, ClassNotFoundError(name)
 	}
 	return toReturn, nil
 }
 
+// Like GetClass, but if the named class hasn't been loaded, this will search
+// the JVM's ClassPath for it and load it. Returns a ClassNotFoundError if the
+// class isn't loaded and can't be found.
+func (j *JVM) GetOrLoadClass(name string) (*Class, error) {
+	return j.getOrLoadClass(name, nil)
+}
+
+// Like GetOrLoadClass, but takes the names of the classes whose superclasses
+// are currently being resolved. Returns a ClassCircularityError if the named
+// class is one of them, since it would then be its own superclass.
+func (j *JVM) getOrLoadClass(name string, loading []string) (*Class, error) {
+	toReturn, e := j.GetClass(name)
+	if e == nil {
+		return toReturn, nil
+	}
+	for _, n := range loading {
+		if n == name {
+			return nil, ClassCircularityError(name)
+		}
+	}
+	classFile, e := j.FindClassFile(name)
+	if e != nil {
+		return nil, e
+	}
+	// The class may be loaded by another thread while we're reading the file,
+	// in which case loadClass returns the other thread's copy.
+	toReturn, e = j.loadClass(classFile, loading)
+	if e != nil {
+		return nil, fmt.Errorf("Failed loading class %s: %w", name, e)
+	}
+	return toReturn, nil
+}
+
+// Shorthand for acquiring the lock on the map of loaded classes.
+func (j *JVM) lockClasses() {
+	(&(j.classesLock)).Lock()
+}
+
+// Shorthand for releasing the lock on the map of loaded classes.
+func (j *JVM) unlockClasses() {
+	(&(j.classesLock)).Unlock()
+}
+
 // Shorthand for acquiring the lock on the list of active threads.
 func (j *JVM) lockThreadList() {
 	(&(j.threadsLock)).Lock()
@@ -474,9 +883,9 @@ func (j *JVM) unlockThreadList() {
 
 // Shorthand for calling GetMethod on the named class.
 func (j *JVM) GetMethod(className, methodKey string) (*Method, error) {
-	c := j.Classes[className]
-	if c == nil {
-		return nil, ClassNotFoundError(className)
+	c, e := j.GetClass(className)
+	if e != nil {
+		return nil, e
 	}
 	return c.GetMethod(methodKey)
 }
@@ -486,56 +895,132 @@ func (j *JVM) GetMethod(className, methodKey string) (*Method, error) {
 // that was created. However, this thread handle may be ignored, as the thread
 // is still internally tracked and we can wait for its completion using
 // WaitForAllThreads. The Thread return value is so that we can wait for
-// one-off threads independently when needed.
+// one-off threads independently when needed. The thread will be given a name
+// of the form "Thread-<number>".
 func (j *JVM) StartThread(className, methodKey string) (*Thread, error) {
+	return j.StartNamedThread(className, methodKey, "")
+}
+
+// Like StartThread, but sets the new thread's name. If the name is empty, a
+// default name is chosen. Any args are passed to the method, and are placed
+// in its local variables in the same way as arguments to a static method.
+func (j *JVM) StartNamedThread(className, methodKey, name string,
+	args ...Object) (*Thread, error) {
 	method, e := j.GetMethod(className, methodKey)
 	if e != nil {
 		return nil, e
 	}
+	newThread, e := j.NewThread(method, name, args...)
+	if e != nil {
+		return nil, e
+	}
+	e = newThread.Start()
+	if e != nil {
+		return nil, e
+	}
+	return newThread, nil
+}
+
+// Returns a new default thread name, of the form "Thread-<number>".
+func (j *JVM) NextThreadName() string {
+	j.lockThreadList()
+	defer j.unlockThreadList()
+	toReturn := fmt.Sprintf("Thread-%d", j.threadCount)
+	j.threadCount++
+	return toReturn
+}
+
+// Creates a new thread that will run the given method, but doesn't start it.
+// This allows setting fields such as Daemon before calling the new thread's
+// Start method. The name and args are the same as for StartNamedThread.
+func (j *JVM) NewThread(method *Method, name string,
+	args ...Object) (*Thread, error) {
+	if method.Native != nil {
+		return nil, fmt.Errorf("Can't start a thread in native method %s",
+			method.Name)
+	}
 	// We may need to optimize this method in case this is the first time it's
 	// being invoked.
-	e = method.Optimize()
+	e := method.Optimize()
 	if e != nil {
 		return nil, fmt.Errorf("Failed preparing thread's start method for "+
 			"execution: %s", e)
 	}
-	j.lockThreadList()
-	threadIndex := len(j.threads)
-	newThread := &Thread{
+	localVariables := make([]Object, method.MaxLocals)
+	localIndex := 0
+	for _, arg := range args {
+		if localIndex >= len(localVariables) {
+			return nil, fmt.Errorf("Too many arguments for %s", method.Name)
+		}
+		localVariables[localIndex] = arg
+		localIndex++
+		// Longs and doubles occupy two local variable slots.
+		switch arg.(type) {
+		case Long, Double:
+			localIndex++
+		}
+	}
+	if name == "" {
+		name = j.NextThreadName()
+	}
+	toReturn := &Thread{
+		Name:             name,
 		CurrentMethod:    method,
 		ParentJVM:        j,
 		InstructionIndex: 0,
-		LocalVariables:   make([]Object, method.MaxLocals),
+		LocalVariables:   localVariables,
 		Stack:            NewStack(),
-		threadComplete:   make(chan error),
-		threadIndex:      threadIndex,
+		// This is buffered so that daemon threads, which nobody may wait for,
+		// can still exit.
+		threadComplete: make(chan error, 1),
+		done:           make(chan bool),
 	}
-	e = newThread.Run()
+	return toReturn, nil
+}
+
+// Starts running a thread created by JVM.NewThread, and adds it to the JVM's
+// list of active threads.
+func (t *Thread) Start() error {
+	j := t.ParentJVM
+	j.lockThreadList()
+	defer j.unlockThreadList()
+	t.threadIndex = len(j.threads)
+	e := t.Run()
 	if e != nil {
 		// Don't append the new thread if it failed to start.
-		j.unlockThreadList()
-		return nil, e
+		return e
 	}
-	j.threads = append(j.threads, newThread)
-	j.unlockThreadList()
-	return newThread, nil
+	j.threads = append(j.threads, t)
+	return nil
 }
 
-// Waits for all threads. May return any error from any thread if the thread
-// has any error other than ThreadExitedError. Will return nil if all threads
-// exited successfully.
+// Returns the most recently started thread that isn't a daemon thread, or nil
+// if only daemon threads are running.
+func (j *JVM) lastNonDaemonThread() *Thread {
+	j.lockThreadList()
+	defer j.unlockThreadList()
+	for i := len(j.threads) - 1; i >= 0; i-- {
+		if !j.threads[i].Daemon {
+			return j.threads[i]
+		}
+	}
+	return nil
+}
+
+// Waits for all threads, other than daemon threads, to exit. Like Java, this
+// doesn't wait for daemon threads, which may still be running after this
+// returns. May return any error from any thread if the thread has any error
+// other than ThreadExitedError. Will return nil if all threads exited
+// successfully.
 func (j *JVM) WaitForAllThreads() error {
 	var currentThread *Thread
 	var toReturn error
 	var currentError error
 	for {
-		j.lockThreadList()
-		if len(j.threads) <= 0 {
-			j.unlockThreadList()
+		currentThread = j.lastNonDaemonThread()
+		if currentThread == nil {
 			break
 		}
-		currentThread = j.threads[len(j.threads)-1]
-		j.unlockThreadList()
 		currentError = currentThread.WaitForCompletion()
 		// Only returns errors that aren't ThreadExitedErrors
 		if currentError != ThreadExitedError {
@@ -610,13 +1095,25 @@ func getClinitMethodKey() string {
 }
 
 // Takes a path to a class file, parses and loads the class, then looks for the
-// main function in the class and starts executing it.
-func (j *JVM) StartMainClass(classFileName string) error {
+// main function in the class and starts executing it. The args are passed to
+// main as a String[] array.
+func (j *JVM) StartMainClass(classFileName string, args []string) error {
 	className, e := j.LoadClassFromFile(classFileName)
 	if e != nil {
 		return e
 	}
-	// TODO: Provide the string[] args argument somehow.
-	_, e = j.StartThread(className, getMainMethodKey())
+	return j.StartMainClassByName(className, args)
+}
+
+// Like StartMainClass, but takes the fully-qualified name of a class (e.g.
+// "com/example/Main") rather than a file path. Loads the class from the class
+// path if it hasn't already been loaded.
+func (j *JVM) StartMainClassByName(className string, args []string) error {
+	_, e := j.GetOrLoadClass(className)
+	if e != nil {
+		return e
+	}
+	_, e = j.StartNamedThread(className, getMainMethodKey(), "main",
+		NewStringArray(args))
 	return e
 }
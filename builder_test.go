@@ -0,0 +1,147 @@
+package bs_jvm
+
+import (
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// Returns a class built using the builder package. Its run() method passes
+// the results of calling its other methods to the native report(I)V method.
+func getGeneratedTestClass(t *testing.T) *class_file.Class {
+	b := builder.NewClassBuilder("Generated", "java/lang/Object")
+	b.AddMethod(0x0109, "report", "(I)V")
+
+	// Sums the integers from 1 to n in a loop.
+	m := b.AddMethod(0x0009, "sum", "(I)I")
+	loop := m.NewLabel()
+	done := m.NewLabel()
+	m.EmitInt(0)
+	m.EmitLocal(builder.Istore, 1)
+	m.PlaceLabel(loop)
+	m.EmitLocal(builder.Iload, 0)
+	m.EmitBranch(builder.Ifle, done)
+	m.EmitLocal(builder.Iload, 1)
+	m.EmitLocal(builder.Iload, 0)
+	m.Emit(builder.Iadd)
+	m.EmitLocal(builder.Istore, 1)
+	m.EmitIinc(0, -1)
+	m.EmitBranch(builder.Goto, loop)
+	m.PlaceLabel(done)
+	m.EmitLocal(builder.Iload, 1)
+	m.Emit(builder.Ireturn)
+
+	// Returns 10 for 1, 20 for 2 or -5, 30 for 1000, and 0 otherwise.
+	m = b.AddMethod(0x0009, "classify", "(I)I")
+	one := m.NewLabel()
+	two := m.NewLabel()
+	large := m.NewLabel()
+	other := m.NewLabel()
+	lookup := m.NewLabel()
+	m.EmitLocal(builder.Iload, 0)
+	m.EmitTableSwitch(1, lookup, one, two)
+	m.PlaceLabel(lookup)
+	m.EmitLocal(builder.Iload, 0)
+	m.EmitLookupSwitch(other, []int32{1000, -5}, []*builder.Label{large,
+		two})
+	m.PlaceLabel(one)
+	m.EmitInt(10)
+	m.Emit(builder.Ireturn)
+	m.PlaceLabel(two)
+	m.EmitInt(20)
+	m.Emit(builder.Ireturn)
+	m.PlaceLabel(large)
+	m.EmitInt(30)
+	m.Emit(builder.Ireturn)
+	m.PlaceLabel(other)
+	m.EmitInt(0)
+	m.Emit(builder.Ireturn)
+
+	// Returns a * b + 1, using long arithmetic.
+	m = b.AddMethod(0x0009, "mulAdd", "(JI)J")
+	m.EmitLocal(builder.Lload, 0)
+	m.EmitLocal(builder.Iload, 2)
+	m.Emit(builder.I2l)
+	m.Emit(builder.Lmul)
+	m.EmitLong(1)
+	m.Emit(builder.Ladd)
+	m.Emit(builder.Lreturn)
+
+	m = b.AddMethod(0x0009, "run", "()V")
+	report := func() {
+		m.EmitInvoke(builder.Invokestatic, "Generated", "report", "(I)V")
+	}
+	m.EmitInt(100)
+	m.EmitInvoke(builder.Invokestatic, "Generated", "sum", "(I)I")
+	report()
+	for _, v := range []int32{1, 2, -5, 1000, 7} {
+		m.EmitInt(v)
+		m.EmitInvoke(builder.Invokestatic, "Generated", "classify", "(I)I")
+		report()
+	}
+	m.EmitLong(100000)
+	m.EmitInt(3)
+	m.EmitInvoke(builder.Invokestatic, "Generated", "mulAdd", "(JI)J")
+	m.Emit(builder.L2i)
+	report()
+	m.Emit(builder.Return)
+
+	toReturn, e := b.Build()
+	if e != nil {
+		t.Logf("Failed building the Generated class: %s\n", e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+func TestBuilderGeneratedCode(t *testing.T) {
+	jvm := NewJVM()
+	classFile := getGeneratedTestClass(t)
+	loadStubClasses(t, jvm, classFile)
+	c, e := jvm.GetClass("Generated")
+	if e != nil {
+		t.Logf("Failed getting the Generated class: %s\n", e)
+		t.FailNow()
+	}
+	var results []Int
+	for _, m := range classFile.Methods {
+		method := c.Methods[GetMethodKey(m)]
+		if string(m.Name) == "report" {
+			method.Native = func(thread *Thread) error {
+				v, e := thread.Stack.Pop()
+				results = append(results, v)
+				return e
+			}
+			continue
+		}
+		// The generated stack map frames must satisfy the verifier.
+		e = method.Verify()
+		if e != nil {
+			t.Logf("Failed verifying %s: %s\n", m.Name, e)
+			t.FailNow()
+		}
+	}
+	_, e = jvm.StartNamedThread("Generated",
+		GetMethodKey(getStubMethod("run", 0)), "run")
+	if e != nil {
+		t.Logf("Failed starting the run method: %s\n", e)
+		t.FailNow()
+	}
+	e = jvm.WaitForAllThreads()
+	if e != nil {
+		t.Logf("The run method failed: %s\n", e)
+		t.FailNow()
+	}
+	expected := []Int{5050, 10, 20, 20, 30, 0, 300001}
+	if len(results) != len(expected) {
+		t.Logf("Expected %d results, got %v\n", len(expected), results)
+		t.FailNow()
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Logf("Expected result %d to be %d, got %d\n", i, expected[i],
+				results[i])
+			t.Fail()
+		}
+	}
+}
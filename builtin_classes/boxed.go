@@ -0,0 +1,858 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.Number and the classes that
+// wrap primitive values, such as java.lang.Integer. Instances of these classes
+// hold a *bs_jvm.BoxedValue in their NativeData.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// An initialized version of the builtin Number class.
+var numberClass *bs_jvm.Class
+
+// Holds the initialized versions of Number and the classes wrapping primitive
+// values.
+var boxedClasses []*bs_jvm.Class
+
+// Identifies a value in boxCache.
+type boxCacheKey struct {
+	t class_file.PrimitiveFieldType
+	v bs_jvm.Object
+}
+
+// Like Java, valueOf returns the same instance for commonly used values, such
+// as Integers between -128 and 127.
+var boxCache = make(map[boxCacheKey]*bs_jvm.ClassInstance)
+
+// Protects boxCache from concurrent access.
+var boxCacheLock sync.Mutex
+
+// Returns true if valueOf must return a cached instance for the value.
+func isCachedBoxValue(t class_file.PrimitiveFieldType, v bs_jvm.Object) bool {
+	switch t {
+	case 'Z', 'B':
+		return true
+	case 'C':
+		return v.(bs_jvm.Int) <= 127
+	case 'S', 'I':
+		return (v.(bs_jvm.Int) >= -128) && (v.(bs_jvm.Int) <= 127)
+	case 'J':
+		return (v.(bs_jvm.Long) >= -128) && (v.(bs_jvm.Long) <= 127)
+	}
+	return false
+}
+
+// Returns an instance of the class wrapping the given type of primitive
+// value, like the class' valueOf method.
+func boxValue(t *bs_jvm.Thread, valueType class_file.PrimitiveFieldType,
+	v bs_jvm.Object) (*bs_jvm.ClassInstance, error) {
+	if !isCachedBoxValue(valueType, v) {
+		return t.ParentJVM.Box(valueType, v)
+	}
+	key := boxCacheKey{
+		t: valueType,
+		v: v,
+	}
+	boxCacheLock.Lock()
+	defer boxCacheLock.Unlock()
+	toReturn := boxCache[key]
+	if toReturn != nil {
+		return toReturn, nil
+	}
+	toReturn, e := t.ParentJVM.Box(valueType, v)
+	if e != nil {
+		return nil, e
+	}
+	boxCache[key] = toReturn
+	return toReturn, nil
+}
+
+// Pops an instance of one of the classes wrapping a primitive value, which
+// must not be null.
+func popBoxedValue(t *bs_jvm.Thread) (*bs_jvm.BoxedValue, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := bs_jvm.GetBoxedValue(tmp)
+	if toReturn == nil {
+		return nil, bs_jvm.TypeError("Expected a boxed primitive, got " +
+			tmp.TypeName())
+	}
+	return toReturn, nil
+}
+
+// Converts a primitive value to the given primitive type, in the same way as
+// a cast in Java.
+func convertPrimitive(v bs_jvm.Object,
+	to class_file.PrimitiveFieldType) bs_jvm.Object {
+	var i int64
+	var f float64
+	switch x := v.(type) {
+	case bs_jvm.Int:
+		i = int64(x)
+		f = float64(x)
+	case bs_jvm.Long:
+		i = int64(x)
+		f = float64(x)
+	case bs_jvm.Float:
+		i = int64(x)
+		f = float64(x)
+	case bs_jvm.Double:
+		i = int64(x)
+		f = float64(x)
+	}
+	switch to {
+	case 'B':
+		return bs_jvm.Int(int8(i))
+	case 'C':
+		return bs_jvm.Int(uint16(i))
+	case 'S':
+		return bs_jvm.Int(int16(i))
+	case 'J':
+		return bs_jvm.Long(i)
+	case 'F':
+		return bs_jvm.Float(f)
+	case 'D':
+		return bs_jvm.Double(f)
+	}
+	return bs_jvm.Int(i)
+}
+
+// Returns a native method implementing methods such as intValue(), which
+// return the wrapped value converted to the given type.
+func getUnboxMethod(to class_file.PrimitiveFieldType) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		v, e := popBoxedValue(t)
+		if e != nil {
+			return e
+		}
+		return t.Stack.PushUnconditional(convertPrimitive(v.Value, to))
+	}
+}
+
+// Returns a native method implementing the static valueOf method that takes a
+// primitive value.
+func getValueOfMethod(
+	valueType class_file.PrimitiveFieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		toReturn, e := boxValue(t, valueType, args[0])
+		if e != nil {
+			return e
+		}
+		return t.Stack.PushRef(toReturn)
+	}
+}
+
+// Returns a native method implementing the constructor that takes a primitive
+// value.
+func getBoxedConstructor(
+	valueType class_file.PrimitiveFieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+		if e != nil {
+			return e
+		}
+		instance, ok := tmp.(*bs_jvm.ClassInstance)
+		if !ok {
+			return bs_jvm.TypeError("Expected a class instance, got " +
+				tmp.TypeName())
+		}
+		instance.NativeData = &bs_jvm.BoxedValue{
+			Type:  valueType,
+			Value: args[0],
+		}
+		return nil
+	}
+}
+
+// Throws a NumberFormatException for a string that couldn't be parsed, with
+// the same message as Java.
+func throwNumberFormatError(t *bs_jvm.Thread, s string, radix int) error {
+	message := fmt.Sprintf("For input string: \"%s\"", s)
+	if radix != 10 {
+		message += fmt.Sprintf(" under radix %d", radix)
+	}
+	return t.Throw("java/lang/NumberFormatException", message)
+}
+
+// Parses a string as an integer of the given type, like Integer.parseInt.
+// Returns a Java exception if the string is invalid.
+func parseInteger(t *bs_jvm.Thread, s *bs_jvm.StringObject,
+	valueType class_file.PrimitiveFieldType, radix int) (bs_jvm.Object,
+	error) {
+	if s == nil {
+		return nil, t.Throw("java/lang/NumberFormatException",
+			"Cannot parse null string: null")
+	}
+	if radix < 2 {
+		return nil, t.Throw("java/lang/NumberFormatException",
+			fmt.Sprintf("radix %d less than Character.MIN_RADIX", radix))
+	}
+	if radix > 36 {
+		return nil, t.Throw("java/lang/NumberFormatException",
+			fmt.Sprintf("radix %d greater than Character.MAX_RADIX", radix))
+	}
+	str := s.Value()
+	bits := 32
+	if valueType == 'J' {
+		bits = 64
+	}
+	// Go accepts underscores in some cases, but Java never does.
+	if strings.IndexByte(str, '_') >= 0 {
+		return nil, throwNumberFormatError(t, str, radix)
+	}
+	v, e := strconv.ParseInt(str, radix, bits)
+	if e != nil {
+		return nil, throwNumberFormatError(t, str, radix)
+	}
+	if valueType == 'J' {
+		return bs_jvm.Long(v), nil
+	}
+	// Like Java, bytes and shorts are parsed as ints, and then checked.
+	if ((valueType == 'B') && (int64(int8(v)) != v)) ||
+		((valueType == 'S') && (int64(int16(v)) != v)) {
+		return nil, t.Throw("java/lang/NumberFormatException",
+			fmt.Sprintf("Value out of range. Value:\"%s\" Radix:%d", str,
+				radix))
+	}
+	return bs_jvm.Int(v), nil
+}
+
+// Matches the decimal floating-point strings accepted by Double.parseDouble,
+// after surrounding whitespace has been removed.
+var floatStringRegex = regexp.MustCompile(
+	`^[+-]?(NaN|Infinity|((\d+\.?\d*|\.\d+)([eE][+-]?\d+)?[fFdD]?))$`)
+
+// Parses a string as a float or double, like Double.parseDouble. Returns a
+// Java exception if the string is invalid.
+func parseFloat(t *bs_jvm.Thread, s *bs_jvm.StringObject,
+	valueType class_file.PrimitiveFieldType) (bs_jvm.Object, error) {
+	if s == nil {
+		return nil, bs_jvm.NullReferenceError("Can't parse a null string")
+	}
+	str := strings.TrimFunc(s.Value(), func(r rune) bool {
+		return r <= ' '
+	})
+	if str == "" {
+		return nil, t.Throw("java/lang/NumberFormatException",
+			"empty String")
+	}
+	if !floatStringRegex.MatchString(str) {
+		return nil, throwNumberFormatError(t, s.Value(), 10)
+	}
+	str = strings.TrimRight(str, "fFdD")
+	str = strings.Replace(str, "Infinity", "Inf", 1)
+	bits := 64
+	if valueType == 'F' {
+		bits = 32
+	}
+	// Overflow results in an infinite value, as in Java, so ignore range
+	// errors.
+	v, _ := strconv.ParseFloat(str, bits)
+	if valueType == 'F' {
+		return bs_jvm.Float(v), nil
+	}
+	return bs_jvm.Double(v), nil
+}
+
+// Parses a string as a value of the given type, like Integer.parseInt or
+// Boolean.parseBoolean. Returns a Java exception if the string is invalid.
+func parsePrimitive(t *bs_jvm.Thread, s *bs_jvm.StringObject,
+	valueType class_file.PrimitiveFieldType, radix int) (bs_jvm.Object,
+	error) {
+	switch valueType {
+	case 'Z':
+		if (s != nil) && strings.EqualFold(s.Value(), "true") {
+			return bs_jvm.Int(1), nil
+		}
+		return bs_jvm.Int(0), nil
+	case 'F', 'D':
+		return parseFloat(t, s, valueType)
+	}
+	return parseInteger(t, s, valueType, radix)
+}
+
+// Pops a String that may be null.
+func popNullableString(t *bs_jvm.Thread) (*bs_jvm.StringObject, error) {
+	tmp, e := t.Stack.PopRef()
+	if e != nil {
+		return nil, e
+	}
+	if bs_jvm.IsNull(tmp) {
+		return nil, nil
+	}
+	s, ok := tmp.(*bs_jvm.StringObject)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a String, got " +
+			tmp.TypeName())
+	}
+	return s, nil
+}
+
+// Returns a native method implementing a static method that parses a string,
+// such as Integer.parseInt or Integer.valueOf(String). If withRadix is set,
+// the method takes the radix as its second arg. If box is set, the method
+// returns an instance of the wrapping class rather than a primitive value.
+func getParseMethod(valueType class_file.PrimitiveFieldType, withRadix,
+	box bool) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		radix := bs_jvm.Int(10)
+		var e error
+		if withRadix {
+			radix, e = t.Stack.Pop()
+			if e != nil {
+				return e
+			}
+		}
+		s, e := popNullableString(t)
+		if e != nil {
+			return e
+		}
+		v, e := parsePrimitive(t, s, valueType, int(radix))
+		if e != nil {
+			return e
+		}
+		if !box {
+			return t.Stack.PushUnconditional(v)
+		}
+		toReturn, e := boxValue(t, valueType, v)
+		if e != nil {
+			return e
+		}
+		return t.Stack.PushRef(toReturn)
+	}
+}
+
+// Implements the toString() method of the classes wrapping primitive values.
+func boxedToStringMethod(t *bs_jvm.Thread) error {
+	v, e := popBoxedValue(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(v.JavaString()))
+}
+
+// Returns a native method implementing the static toString method that takes
+// a primitive value, e.g. Integer.toString(int).
+func getStaticToStringMethod(
+	valueType class_file.PrimitiveFieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		v := &bs_jvm.BoxedValue{
+			Type:  valueType,
+			Value: args[0],
+		}
+		return t.Stack.PushRef(bs_jvm.NewStringObject(v.JavaString()))
+	}
+}
+
+// Returns a native method implementing a static method that converts an int
+// or long to an unsigned string in the given base, e.g. Integer.toHexString.
+func getUnsignedStringMethod(valueType class_file.PrimitiveFieldType,
+	base int) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		var v uint64
+		if valueType == 'J' {
+			v = uint64(args[0].(bs_jvm.Long))
+		} else {
+			v = uint64(uint32(args[0].(bs_jvm.Int)))
+		}
+		s := strconv.FormatUint(v, base)
+		return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+	}
+}
+
+// Implements the hashCode() method of the classes wrapping primitive values.
+func boxedHashCodeMethod(t *bs_jvm.Thread) error {
+	v, e := popBoxedValue(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(v.HashCode())
+}
+
+// Returns the bits of a float or double, with a single canonical NaN, like
+// Java's Double.doubleToLongBits.
+func floatBits(v bs_jvm.Object) uint64 {
+	switch x := v.(type) {
+	case bs_jvm.Float:
+		if x != x {
+			return 0x7fc00000
+		}
+		return uint64(math.Float32bits(float32(x)))
+	case bs_jvm.Double:
+		if x != x {
+			return 0x7ff8000000000000
+		}
+		return math.Float64bits(float64(x))
+	}
+	return 0
+}
+
+// Compares two boxed values of the same type, returning a negative number if
+// a < b, 0 if they're equal, and a positive number if a > b. Like Java's
+// Double.compare, -0.0 is less than 0.0, and NaN is greater than every other
+// value, including positive infinity.
+func compareBoxedValues(a, b *bs_jvm.BoxedValue) bs_jvm.Int {
+	var x, y float64
+	switch v := a.Value.(type) {
+	case bs_jvm.Int:
+		w := b.Value.(bs_jvm.Int)
+		// Like Java, Byte, Short, and Character return the difference
+		// between the values.
+		if (a.Type == 'B') || (a.Type == 'S') || (a.Type == 'C') {
+			return v - w
+		}
+		x, y = float64(v), float64(w)
+	case bs_jvm.Long:
+		w := b.Value.(bs_jvm.Long)
+		if v < w {
+			return -1
+		}
+		if v > w {
+			return 1
+		}
+		return 0
+	case bs_jvm.Float:
+		x, y = float64(v), float64(b.Value.(bs_jvm.Float))
+	case bs_jvm.Double:
+		x, y = float64(v), float64(b.Value.(bs_jvm.Double))
+	}
+	if x < y {
+		return -1
+	}
+	if x > y {
+		return 1
+	}
+	if (a.Type != 'F') && (a.Type != 'D') {
+		return 0
+	}
+	// The values are equal, or at least one is NaN. Comparing the canonical
+	// bits handles NaN and signed zeros.
+	xBits, yBits := int64(floatBits(a.Value)), int64(floatBits(b.Value))
+	if a.Type == 'F' {
+		xBits, yBits = int64(int32(xBits)), int64(int32(yBits))
+	}
+	if xBits < yBits {
+		return -1
+	}
+	if xBits > yBits {
+		return 1
+	}
+	return 0
+}
+
+// Implements the equals(Object) method of the classes wrapping primitive
+// values. Like Java, floats and doubles are compared by their bits, so NaN is
+// equal to itself, but 0.0 isn't equal to -0.0.
+func boxedEqualsMethod(t *bs_jvm.Thread) error {
+	other, e := t.Stack.PopRef()
+	if e != nil {
+		return e
+	}
+	v, e := popBoxedValue(t)
+	if e != nil {
+		return e
+	}
+	otherValue := bs_jvm.GetBoxedValue(other)
+	if (otherValue == nil) || (otherValue.Type != v.Type) {
+		return pushBoolean(t, false)
+	}
+	if (v.Type == 'F') || (v.Type == 'D') {
+		return pushBoolean(t, floatBits(v.Value) ==
+			floatBits(otherValue.Value))
+	}
+	return pushBoolean(t, v.Value == otherValue.Value)
+}
+
+// Implements the compareTo method of the classes wrapping primitive values,
+// as well as the compareTo(Object) bridge method required by Comparable.
+func boxedCompareToMethod(t *bs_jvm.Thread) error {
+	other, e := popBoxedValue(t)
+	if e != nil {
+		return e
+	}
+	v, e := popBoxedValue(t)
+	if e != nil {
+		return e
+	}
+	if other.Type != v.Type {
+		return bs_jvm.TypeError(fmt.Sprintf("Can't compare %s to %s",
+			bs_jvm.BoxedClassName(v.Type), bs_jvm.BoxedClassName(other.Type)))
+	}
+	return t.Stack.Push(compareBoxedValues(v, other))
+}
+
+// Returns a native method implementing the static compare method, e.g.
+// Integer.compare(int, int).
+func getStaticCompareMethod(
+	valueType class_file.PrimitiveFieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType, valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		a := &bs_jvm.BoxedValue{
+			Type:  valueType,
+			Value: args[0],
+		}
+		b := &bs_jvm.BoxedValue{
+			Type:  valueType,
+			Value: args[1],
+		}
+		return t.Stack.Push(compareBoxedValues(a, b))
+	}
+}
+
+// Returns a native method implementing a static method of Float or Double
+// that tests a value, such as Double.isNaN(double).
+func getFloatTestMethod(valueType class_file.PrimitiveFieldType,
+	test func(v float64) bool) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{valueType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		return pushBoolean(t, test(args[0].(bs_jvm.PrimitiveType).FloatValue()))
+	}
+}
+
+// Returns a native method implementing an instance method of Float or Double
+// that tests the wrapped value, such as isNaN().
+func getBoxedFloatTestMethod(test func(v float64) bool) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		v, e := popBoxedValue(t)
+		if e != nil {
+			return e
+		}
+		return pushBoolean(t, test(v.Value.(bs_jvm.PrimitiveType).FloatValue()))
+	}
+}
+
+// Returns a native method implementing a static method of Character that
+// tests a char, such as Character.isDigit(char).
+func getCharacterTestMethod(test func(r rune) bool) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		c, e := t.Stack.Pop()
+		if e != nil {
+			return e
+		}
+		return pushBoolean(t, test(rune(uint16(c))))
+	}
+}
+
+// Returns a native method implementing a static method of Character that
+// converts a char, such as Character.toUpperCase(char).
+func getCharacterMapMethod(mapping func(r rune) rune) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		c, e := t.Stack.Pop()
+		if e != nil {
+			return e
+		}
+		r := mapping(rune(uint16(c)))
+		if r > 0xffff {
+			// Like Java, leave chars unchanged if they'd need to become a
+			// surrogate pair.
+			r = rune(uint16(c))
+		}
+		return t.Stack.Push(bs_jvm.Int(r))
+	}
+}
+
+// Returns true if the rune is whitespace according to Java's
+// Character.isWhitespace. Unlike unicode.IsSpace, this excludes non-breaking
+// spaces, and includes the separator control characters.
+func isJavaWhitespaceRune(r rune) bool {
+	switch r {
+	case 0xa0, 0x2007, 0x202f:
+		return false
+	case 0x1c, 0x1d, 0x1e, 0x1f:
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// Returns true if the rune is a letter or a digit, like Java's
+// Character.isLetterOrDigit.
+func isLetterOrDigitRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Creates an instance of a class wrapping a primitive value. Unlike
+// JVM.Box, this doesn't require the class to be registered with the JVM.
+func newBoxedInstance(c *bs_jvm.Class, valueType class_file.PrimitiveFieldType,
+	v bs_jvm.Object) (*bs_jvm.ClassInstance, error) {
+	toReturn, e := c.CreateInstance()
+	if e != nil {
+		return nil, e
+	}
+	toReturn.NativeData = &bs_jvm.BoxedValue{
+		Type:  valueType,
+		Value: v,
+	}
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/Number. If it has already
+// been initialized, returns the existing copy.
+func GetNumberClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if numberClass != nil {
+		return numberClass, nil
+	}
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/Number")
+	// public abstract super
+	toReturn.AccessFlags = 0x0421
+	methods := []struct {
+		name      string
+		valueType class_file.PrimitiveFieldType
+	}{
+		{"byteValue", 'B'},
+		{"shortValue", 'S'},
+		{"intValue", 'I'},
+		{"longValue", 'J'},
+		{"floatValue", 'F'},
+		{"doubleValue", 'D'},
+	}
+	for _, m := range methods {
+		AddMethod(toReturn, m.name, 1, noArgs, m.valueType,
+			getUnboxMethod(m.valueType))
+	}
+	numberClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a new class wrapping the given type of primitive value, with the
+// methods common to all such classes.
+func newBoxedClass(jvm *bs_jvm.JVM, valueType class_file.PrimitiveFieldType,
+	super, comparable *bs_jvm.Class) *bs_jvm.Class {
+	className := bs_jvm.BoxedClassName(valueType)
+	classType := class_file.ClassInstanceType(className)
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	intType := class_file.PrimitiveFieldType('I')
+	booleanType := class_file.PrimitiveFieldType('Z')
+	valueArg := []class_file.FieldType{valueType}
+	stringArg := []class_file.FieldType{stringType}
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, className)
+	toReturn.Super = super
+	// public final super
+	toReturn.AccessFlags = 0x0031
+	toReturn.Interfaces = []*bs_jvm.Class{comparable}
+	AddConstructor(toReturn, 1, valueArg, getBoxedConstructor(valueType))
+	// public static
+	AddMethod(toReturn, "valueOf", 9, valueArg, classType,
+		getValueOfMethod(valueType))
+	AddMethod(toReturn, "toString", 9, valueArg, stringType,
+		getStaticToStringMethod(valueType))
+	AddMethod(toReturn, "compare", 9, []class_file.FieldType{valueType,
+		valueType}, intType, getStaticCompareMethod(valueType))
+	if valueType != 'C' {
+		AddMethod(toReturn, "valueOf", 9, stringArg, classType,
+			getParseMethod(valueType, false, true))
+	}
+	AddMethod(toReturn, "toString", 1, noArgs, stringType,
+		boxedToStringMethod)
+	AddMethod(toReturn, "hashCode", 1, noArgs, intType, boxedHashCodeMethod)
+	AddMethod(toReturn, "equals", 1, []class_file.FieldType{objectType},
+		booleanType, boxedEqualsMethod)
+	AddMethod(toReturn, "compareTo", 1, []class_file.FieldType{classType},
+		intType, boxedCompareToMethod)
+	// public synthetic bridge
+	AddMethod(toReturn, "compareTo", 0x1041, []class_file.FieldType{
+		objectType}, intType, boxedCompareToMethod)
+	return toReturn
+}
+
+// Adds the static MIN_VALUE and MAX_VALUE fields to a class wrapping a
+// primitive value.
+func addLimitFields(c *bs_jvm.Class, valueType class_file.PrimitiveFieldType,
+	min, max bs_jvm.Object) {
+	// public static final
+	access := class_file.FieldAccessFlags(0x0019)
+	AppendStaticField(c, "MIN_VALUE", access, valueType, min)
+	AppendStaticField(c, "MAX_VALUE", access, valueType, max)
+}
+
+// Adds methods specific to Byte, Short, Integer, or Long, such as parseInt.
+func addIntegerMethods(c *bs_jvm.Class, valueType class_file.PrimitiveFieldType,
+	parseName string) {
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	stringArg := []class_file.FieldType{stringType}
+	radixArgs := []class_file.FieldType{stringType,
+		class_file.PrimitiveFieldType('I')}
+	AddMethod(c, parseName, 9, stringArg, valueType,
+		getParseMethod(valueType, false, false))
+	AddMethod(c, parseName, 9, radixArgs, valueType,
+		getParseMethod(valueType, true, false))
+	AddMethod(c, "valueOf", 9, radixArgs, class_file.ClassInstanceType(
+		c.Name), getParseMethod(valueType, true, true))
+	if (valueType != 'I') && (valueType != 'J') {
+		return
+	}
+	valueArg := []class_file.FieldType{valueType}
+	AddMethod(c, "toHexString", 9, valueArg, stringType,
+		getUnsignedStringMethod(valueType, 16))
+	AddMethod(c, "toOctalString", 9, valueArg, stringType,
+		getUnsignedStringMethod(valueType, 8))
+	AddMethod(c, "toBinaryString", 9, valueArg, stringType,
+		getUnsignedStringMethod(valueType, 2))
+}
+
+// Adds methods specific to Float or Double, such as parseDouble and isNaN.
+func addFloatMethods(c *bs_jvm.Class, valueType class_file.PrimitiveFieldType,
+	parseName string) {
+	stringArg := []class_file.FieldType{class_file.ClassInstanceType(
+		"java/lang/String")}
+	valueArg := []class_file.FieldType{valueType}
+	noArgs := []class_file.FieldType{}
+	booleanType := class_file.PrimitiveFieldType('Z')
+	AddMethod(c, parseName, 9, stringArg, valueType,
+		getParseMethod(valueType, false, false))
+	isNaN := func(v float64) bool {
+		return math.IsNaN(v)
+	}
+	isInfinite := func(v float64) bool {
+		return math.IsInf(v, 0)
+	}
+	AddMethod(c, "isNaN", 9, valueArg, booleanType,
+		getFloatTestMethod(valueType, isNaN))
+	AddMethod(c, "isInfinite", 9, valueArg, booleanType,
+		getFloatTestMethod(valueType, isInfinite))
+	AddMethod(c, "isNaN", 1, noArgs, booleanType,
+		getBoxedFloatTestMethod(isNaN))
+	AddMethod(c, "isInfinite", 1, noArgs, booleanType,
+		getBoxedFloatTestMethod(isInfinite))
+}
+
+// Adds the static methods specific to Character, such as isDigit.
+func addCharacterMethods(c *bs_jvm.Class) {
+	charArg := []class_file.FieldType{class_file.PrimitiveFieldType('C')}
+	booleanType := class_file.PrimitiveFieldType('Z')
+	charType := class_file.PrimitiveFieldType('C')
+	tests := map[string]func(r rune) bool{
+		"isDigit":         unicode.IsDigit,
+		"isLetter":        unicode.IsLetter,
+		"isUpperCase":     unicode.IsUpper,
+		"isLowerCase":     unicode.IsLower,
+		"isWhitespace":    isJavaWhitespaceRune,
+		"isLetterOrDigit": isLetterOrDigitRune,
+	}
+	for name, test := range tests {
+		AddMethod(c, name, 9, charArg, booleanType,
+			getCharacterTestMethod(test))
+	}
+	AddMethod(c, "toUpperCase", 9, charArg, charType,
+		getCharacterMapMethod(unicode.ToUpper))
+	AddMethod(c, "toLowerCase", 9, charArg, charType,
+		getCharacterMapMethod(unicode.ToLower))
+}
+
+// Returns BS-JVM classes implementing java/lang/Number and the classes that
+// wrap each primitive type, such as java/lang/Integer. If they have already
+// been initialized, returns the existing copies.
+func GetBoxedClasses(jvm *bs_jvm.JVM) ([]*bs_jvm.Class, error) {
+	if boxedClasses != nil {
+		return boxedClasses, nil
+	}
+	number, e := GetNumberClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	comparable, e := GetComparableClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	object := getObjectClass(jvm)
+	noArgs := []class_file.FieldType{}
+
+	booleanClass := newBoxedClass(jvm, 'Z', object, comparable)
+	AddMethod(booleanClass, "booleanValue", 1, noArgs,
+		class_file.PrimitiveFieldType('Z'), getUnboxMethod('Z'))
+	AddMethod(booleanClass, "parseBoolean", 9, []class_file.FieldType{
+		class_file.ClassInstanceType("java/lang/String")},
+		class_file.PrimitiveFieldType('Z'), getParseMethod('Z', false, false))
+	// Boolean.TRUE and Boolean.FALSE are the same instances returned by
+	// valueOf.
+	for i, name := range []string{"FALSE", "TRUE"} {
+		v := bs_jvm.Int(i)
+		instance, e := newBoxedInstance(booleanClass, 'Z', v)
+		if e != nil {
+			return nil, e
+		}
+		boxCache[boxCacheKey{t: 'Z', v: v}] = instance
+		// public static final
+		AppendStaticField(booleanClass, name, 0x0019,
+			class_file.ClassInstanceType("java/lang/Boolean"), instance)
+	}
+
+	characterClass := newBoxedClass(jvm, 'C', object, comparable)
+	AddMethod(characterClass, "charValue", 1, noArgs,
+		class_file.PrimitiveFieldType('C'), getUnboxMethod('C'))
+	addLimitFields(characterClass, 'C', bs_jvm.Int(0), bs_jvm.Int(0xffff))
+	addCharacterMethods(characterClass)
+
+	byteClass := newBoxedClass(jvm, 'B', number, comparable)
+	addLimitFields(byteClass, 'B', bs_jvm.Int(math.MinInt8),
+		bs_jvm.Int(math.MaxInt8))
+	addIntegerMethods(byteClass, 'B', "parseByte")
+	shortClass := newBoxedClass(jvm, 'S', number, comparable)
+	addLimitFields(shortClass, 'S', bs_jvm.Int(math.MinInt16),
+		bs_jvm.Int(math.MaxInt16))
+	addIntegerMethods(shortClass, 'S', "parseShort")
+	integerClass := newBoxedClass(jvm, 'I', number, comparable)
+	addLimitFields(integerClass, 'I', bs_jvm.Int(math.MinInt32),
+		bs_jvm.Int(math.MaxInt32))
+	addIntegerMethods(integerClass, 'I', "parseInt")
+	longClass := newBoxedClass(jvm, 'J', number, comparable)
+	addLimitFields(longClass, 'J', bs_jvm.Long(math.MinInt64),
+		bs_jvm.Long(math.MaxInt64))
+	addIntegerMethods(longClass, 'J', "parseLong")
+
+	floatClass := newBoxedClass(jvm, 'F', number, comparable)
+	addLimitFields(floatClass, 'F',
+		bs_jvm.Float(math.SmallestNonzeroFloat32),
+		bs_jvm.Float(math.MaxFloat32))
+	addFloatMethods(floatClass, 'F', "parseFloat")
+	doubleClass := newBoxedClass(jvm, 'D', number, comparable)
+	addLimitFields(doubleClass, 'D',
+		bs_jvm.Double(math.SmallestNonzeroFloat64),
+		bs_jvm.Double(math.MaxFloat64))
+	addFloatMethods(doubleClass, 'D', "parseDouble")
+
+	boxedClasses = []*bs_jvm.Class{number, booleanClass, characterClass,
+		byteClass, shortClass, integerClass, longClass, floatClass,
+		doubleClass}
+	return boxedClasses, nil
+}
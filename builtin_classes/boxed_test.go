@@ -0,0 +1,240 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"math"
+	"testing"
+)
+
+// Emits code comparing the two references on top of the stack, and reporting
+// "same" or "different".
+func emitReportSame(m *builder.MethodBuilder, className string) {
+	different := m.NewLabel()
+	done := m.NewLabel()
+	m.EmitBranch(builder.If_acmpne, different)
+	m.EmitString("same")
+	m.EmitBranch(builder.Goto, done)
+	m.PlaceLabel(different)
+	m.EmitString("different")
+	m.PlaceLabel(done)
+	emitReport(m, className, "Ljava/lang/String;")
+}
+
+// Emits a call to the valueOf method of the class wrapping the given type of
+// primitive, e.g. Integer.valueOf(int) for "I".
+func emitValueOf(m *builder.MethodBuilder, descriptor string) {
+	classNames := map[string]string{
+		"Z": "java/lang/Boolean",
+		"C": "java/lang/Character",
+		"I": "java/lang/Integer",
+		"J": "java/lang/Long",
+		"D": "java/lang/Double",
+	}
+	className := classNames[descriptor]
+	m.EmitInvoke(builder.Invokestatic, className, "valueOf",
+		"("+descriptor+")L"+className+";")
+}
+
+// Emits a call to Integer.parseInt(String, int).
+func emitParseInt(m *builder.MethodBuilder, s string, radix int32) {
+	m.EmitString(s)
+	m.EmitInt(radix)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/Integer", "parseInt",
+		"(Ljava/lang/String;I)I")
+}
+
+func TestBoxedMethods(t *testing.T) {
+	tests := []struct {
+		name      string
+		emit      func(m *builder.MethodBuilder, className string)
+		expected  []string
+		exception string
+		message   string
+	}{
+		{"ValueOfCache", func(m *builder.MethodBuilder, className string) {
+			// Only values in [-128, 127] must be cached. Characters are
+			// unsigned, so only [0, 127] are cached for them.
+			for _, v := range []int32{-129, -128, 127, 128} {
+				m.EmitInt(v)
+				emitValueOf(m, "I")
+				m.EmitInt(v)
+				emitValueOf(m, "I")
+				emitReportSame(m, className)
+			}
+			for _, v := range []int64{-128, 128} {
+				m.EmitLong(v)
+				emitValueOf(m, "J")
+				m.EmitLong(v)
+				emitValueOf(m, "J")
+				emitReportSame(m, className)
+			}
+			for _, v := range []int32{127, 128} {
+				m.EmitInt(v)
+				emitValueOf(m, "C")
+				m.EmitInt(v)
+				emitValueOf(m, "C")
+				emitReportSame(m, className)
+			}
+			m.EmitInt(1)
+			emitValueOf(m, "Z")
+			m.EmitInt(1)
+			emitValueOf(m, "Z")
+			emitReportSame(m, className)
+			m.EmitDouble(0)
+			emitValueOf(m, "D")
+			m.EmitDouble(0)
+			emitValueOf(m, "D")
+			emitReportSame(m, className)
+		}, []string{"different", "same", "same", "different", "same",
+			"different", "same", "different", "same", "different"}, "", ""},
+		{"ParseInt", func(m *builder.MethodBuilder, className string) {
+			emitParseInt(m, "-ff", 16)
+			emitReport(m, className, "I")
+			emitParseInt(m, "zZ", 36)
+			emitReport(m, className, "I")
+			emitParseInt(m, "+1010", 2)
+			emitReport(m, className, "I")
+			emitParseInt(m, "-2147483648", 10)
+			emitReport(m, className, "I")
+			m.EmitString("-9223372036854775808")
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Long",
+				"parseLong", "(Ljava/lang/String;)J")
+			emitReport(m, className, "J")
+			m.EmitString("-128")
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Byte",
+				"parseByte", "(Ljava/lang/String;)B")
+			emitReport(m, className, "B")
+		}, []string{"-255", "1295", "10", "-2147483648",
+			"-9223372036854775808", "-128"}, "", ""},
+		{"ParseIntOverflow", func(m *builder.MethodBuilder,
+			className string) {
+			emitParseInt(m, "2147483648", 10)
+		}, nil, "java/lang/NumberFormatException",
+			"For input string: \"2147483648\""},
+		{"ParseIntBadDigit", func(m *builder.MethodBuilder,
+			className string) {
+			emitParseInt(m, "19", 8)
+		}, nil, "java/lang/NumberFormatException",
+			"For input string: \"19\" under radix 8"},
+		{"ParseIntEmpty", func(m *builder.MethodBuilder, className string) {
+			emitParseInt(m, "", 10)
+		}, nil, "java/lang/NumberFormatException", "For input string: \"\""},
+		{"ParseIntUnderscore", func(m *builder.MethodBuilder,
+			className string) {
+			emitParseInt(m, "1_000", 10)
+		}, nil, "java/lang/NumberFormatException",
+			"For input string: \"1_000\""},
+		{"ParseIntSmallRadix", func(m *builder.MethodBuilder,
+			className string) {
+			emitParseInt(m, "0", 1)
+		}, nil, "java/lang/NumberFormatException",
+			"radix 1 less than Character.MIN_RADIX"},
+		{"ParseIntLargeRadix", func(m *builder.MethodBuilder,
+			className string) {
+			emitParseInt(m, "0", 37)
+		}, nil, "java/lang/NumberFormatException",
+			"radix 37 greater than Character.MAX_RADIX"},
+		{"ParseIntNull", func(m *builder.MethodBuilder, className string) {
+			m.Emit(builder.Aconst_null)
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Integer",
+				"parseInt", "(Ljava/lang/String;)I")
+		}, nil, "java/lang/NumberFormatException",
+			"Cannot parse null string: null"},
+		{"ParseByteRange", func(m *builder.MethodBuilder,
+			className string) {
+			m.EmitString("80")
+			m.EmitInt(16)
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Byte",
+				"parseByte", "(Ljava/lang/String;I)B")
+		}, nil, "java/lang/NumberFormatException",
+			"Value out of range. Value:\"80\" Radix:16"},
+		{"Compare", func(m *builder.MethodBuilder, className string) {
+			// Integer.compare must not overflow, but Character.compare
+			// returns the difference between the values.
+			m.EmitInt(math.MinInt32)
+			m.EmitInt(math.MaxInt32)
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Integer",
+				"compare", "(II)I")
+			emitReport(m, className, "I")
+			m.EmitInt('a')
+			m.EmitInt('d')
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Character",
+				"compare", "(CC)I")
+			emitReport(m, className, "I")
+			m.EmitLong(5)
+			m.EmitLong(5)
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Long",
+				"compare", "(JJ)I")
+			emitReport(m, className, "I")
+			// Unlike the comparison instructions, -0.0 is less than 0.0,
+			// and NaN is greater than infinity.
+			m.EmitDouble(0)
+			m.EmitDouble(math.Copysign(0, -1))
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Double",
+				"compare", "(DD)I")
+			emitReport(m, className, "I")
+			m.EmitDouble(math.NaN())
+			m.EmitDouble(math.Inf(1))
+			m.EmitInvoke(builder.Invokestatic, "java/lang/Double",
+				"compare", "(DD)I")
+			emitReport(m, className, "I")
+			m.EmitInt(3)
+			emitValueOf(m, "I")
+			m.EmitInt(7)
+			emitValueOf(m, "I")
+			m.EmitInvoke(builder.Invokevirtual, "java/lang/Integer",
+				"compareTo", "(Ljava/lang/Integer;)I")
+			emitReport(m, className, "I")
+		}, []string{"-1", "-3", "0", "1", "1", "-1"}, "", ""},
+		{"Equals", func(m *builder.MethodBuilder, className string) {
+			emitEquals := func() {
+				m.EmitInvoke(builder.Invokevirtual, "java/lang/Object",
+					"equals", "(Ljava/lang/Object;)Z")
+				emitReport(m, className, "Z")
+			}
+			// Uncached instances with the same value are equal.
+			m.EmitInt(1000)
+			emitValueOf(m, "I")
+			m.EmitInt(1000)
+			emitValueOf(m, "I")
+			emitEquals()
+			// Boxed values of different types are never equal.
+			m.EmitInt(1)
+			emitValueOf(m, "I")
+			m.EmitLong(1)
+			emitValueOf(m, "J")
+			emitEquals()
+			m.EmitInt(1)
+			emitValueOf(m, "I")
+			m.Emit(builder.Aconst_null)
+			emitEquals()
+			// Doubles are compared by their bits.
+			m.EmitDouble(math.NaN())
+			emitValueOf(m, "D")
+			m.EmitDouble(math.NaN())
+			emitValueOf(m, "D")
+			emitEquals()
+			m.EmitDouble(0)
+			emitValueOf(m, "D")
+			m.EmitDouble(math.Copysign(0, -1))
+			emitValueOf(m, "D")
+			emitEquals()
+		}, []string{"true", "false", "false", "true", "false"}, "", ""},
+	}
+	for _, test := range tests {
+		className := "BoxedTest" + test.name
+		b := newTestClassBuilder(className)
+		m := b.AddMethod(0x0009, "run", "()V")
+		test.emit(m, className)
+		m.Emit(builder.Return)
+		results, e := runTestClass(t, b)
+		if test.exception != "" {
+			checkThrown(t, e, test.exception, test.message)
+		} else if e != nil {
+			t.Logf("%s failed: %s\n", className, e)
+			t.Fail()
+			continue
+		}
+		checkReported(t, results, test.expected)
+	}
+}
@@ -11,8 +11,9 @@ import (
 
 // Returns a new *bs_jvm.Class instance with the given name, but all fields
 // initialized but empty (maps and slices will be allocated, but not filled).
-// The "File" field will be nil. Intended to be used as a helper function
-// within the builtin_classes package.
+// The "File" field will be nil. The class' superclass will be the builtin
+// java/lang/Object class. Intended to be used as a helper function within the
+// builtin_classes package.
 func GetEmptyClass(jvm *bs_jvm.JVM, className string) *bs_jvm.Class {
 	toReturn := &bs_jvm.Class{
 		ParentJVM:         jvm,
@@ -25,6 +26,11 @@ func GetEmptyClass(jvm *bs_jvm.JVM, className string) *bs_jvm.Class {
 		StaticFieldNames:  make([]string, 0, 10),
 		FieldNames:        make([]string, 0, 10),
 		File:              nil,
+		// public
+		AccessFlags: 1,
+	}
+	if className != "java/lang/Object" {
+		toReturn.Super = getObjectClass(jvm)
 	}
 	return toReturn
 }
@@ -71,6 +77,7 @@ func AddMethod(c *bs_jvm.Class, name string,
 		ContainingClass: c,
 		Name:            name,
 		Types:           descriptor,
+		AccessFlags:     access,
 		OptimizeDone:    true,
 		Native:          f,
 	}
@@ -101,7 +108,66 @@ func AddSingleArgVoidMethod(c *bs_jvm.Class, name string,
 func GetBuiltinClasses(jvm *bs_jvm.JVM) ([]*bs_jvm.Class, error) {
 	toReturn := make([]*bs_jvm.Class, 0, 10)
 	// Create new builtin classes and add them here as needed.
-	tmp, e := GetSystemClass(jvm)
+	tmp, e := GetObjectClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Object class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetCloneableClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Cloneable class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetSerializableClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Serializable class: %w",
+			e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetClassClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Class class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetCharSequenceClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing CharSequence class: %w",
+			e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetComparableClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Comparable class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetStringClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing String class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	boxed, e := GetBoxedClasses(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Number classes: %w", e)
+	}
+	toReturn = append(toReturn, boxed...)
+	tmp, e = GetAppendableClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Appendable class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetStringBuilderClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing StringBuilder class: %w",
+			e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetStringBufferClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing StringBuffer class: %w",
+			e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetSystemClass(jvm)
 	if e != nil {
 		return nil, fmt.Errorf("Failed initializing System class: %w", e)
 	}
@@ -116,5 +182,42 @@ func GetBuiltinClasses(jvm *bs_jvm.JVM) ([]*bs_jvm.Class, error) {
 		return nil, fmt.Errorf("Failed initializing PrintStream class: %w", e)
 	}
 	toReturn = append(toReturn, tmp)
+	tmp, e = GetInputStreamClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing InputStream class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetFormatterClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Formatter class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetRunnableClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Runnable class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetThreadClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Thread class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetLambdaMetafactoryClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing LambdaMetafactory "+
+			"class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	tmp, e = GetStringConcatFactoryClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing StringConcatFactory "+
+			"class: %w", e)
+	}
+	toReturn = append(toReturn, tmp)
+	throwables, e := GetThrowableClasses(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed initializing Throwable classes: %w", e)
+	}
+	toReturn = append(toReturn, throwables...)
 	return toReturn, nil
 }
@@ -0,0 +1,156 @@
+package builtin_classes
+
+import (
+	"bytes"
+	"errors"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// The builtin classes are shared by every JVM that uses them, so all tests in
+// this package use the same JVM. Access this using getTestJVM.
+var testJVM *bs_jvm.JVM
+
+// Returns the JVM used by tests, with the builtin classes loaded.
+func getTestJVM(t *testing.T) *bs_jvm.JVM {
+	if testJVM != nil {
+		return testJVM
+	}
+	jvm := bs_jvm.NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	builtins, e := GetBuiltinClasses(jvm)
+	if e != nil {
+		t.Logf("Failed getting builtin classes: %s\n", e)
+		t.FailNow()
+	}
+	for _, c := range builtins {
+		jvm.Classes[string(c.Name)] = c
+	}
+	testJVM = jvm
+	return jvm
+}
+
+// Returns a ClassBuilder for a test class with a native static
+// report(String) method, which the test code calls to record its results.
+func newTestClassBuilder(name string) *builder.ClassBuilder {
+	toReturn := builder.NewClassBuilder(name, "java/lang/Object")
+	toReturn.AddMethod(0x0109, "report", "(Ljava/lang/String;)V")
+	return toReturn
+}
+
+// Emits code that converts the value on top of the stack to a string using
+// String.valueOf, and passes it to the class' report method. The descriptor
+// is the type of the value, e.g. "I" or "Ljava/lang/Object;".
+func emitReport(m *builder.MethodBuilder, className, descriptor string) {
+	if (descriptor[0] == 'L') || (descriptor[0] == '[') {
+		descriptor = "Ljava/lang/Object;"
+	} else if (descriptor == "B") || (descriptor == "S") {
+		descriptor = "I"
+	}
+	m.EmitInvoke(builder.Invokestatic, "java/lang/String", "valueOf",
+		"("+descriptor+")Ljava/lang/String;")
+	m.EmitInvoke(builder.Invokestatic, className, "report",
+		"(Ljava/lang/String;)V")
+}
+
+// Returns the named method in the class, which must be unique.
+func getNamedMethod(t *testing.T, c *bs_jvm.Class,
+	name string) *bs_jvm.Method {
+	for _, m := range c.Methods {
+		if m.Name == name {
+			return m
+		}
+	}
+	t.Logf("Couldn't find method %s in %s\n", name, c.Name)
+	t.FailNow()
+	return nil
+}
+
+// Loads the class built by b into the test JVM, and returns it.
+func loadTestClass(t *testing.T, b *builder.ClassBuilder) *bs_jvm.Class {
+	jvm := getTestJVM(t)
+	classFile, e := b.Build()
+	if e != nil {
+		t.Logf("Failed building class %s: %s\n", b.Name(), e)
+		t.FailNow()
+	}
+	e = jvm.LoadClass(classFile)
+	if e != nil {
+		t.Logf("Failed loading class %s: %s\n", b.Name(), e)
+		t.FailNow()
+	}
+	toReturn, e := jvm.GetClass(b.Name())
+	if e != nil {
+		t.Logf("Failed getting class %s: %s\n", b.Name(), e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+// Loads the class built by b into the test JVM, and runs its static run()
+// method. Returns the strings passed to the class' report method, and the
+// error the thread exited with, e.g. a ThrownException, if any.
+func runTestClass(t *testing.T, b *builder.ClassBuilder) ([]string, error) {
+	return runLoadedTestClass(t, loadTestClass(t, b))
+}
+
+// Like runTestClass, but takes a test class that has already been loaded,
+// e.g. so that the test can provide implementations of other native methods.
+func runLoadedTestClass(t *testing.T, c *bs_jvm.Class) ([]string, error) {
+	jvm := getTestJVM(t)
+	var results []string
+	report := getNamedMethod(t, c, "report")
+	report.Native = func(thread *bs_jvm.Thread) error {
+		s, e := popStringObject(thread)
+		if e != nil {
+			return e
+		}
+		results = append(results, s.Value())
+		return nil
+	}
+	_, e := jvm.StartNamedThread(string(c.Name), "void run()", "test")
+	if e != nil {
+		t.Logf("Failed starting %s.run(): %s\n", c.Name, e)
+		t.FailNow()
+	}
+	return results, jvm.WaitForAllThreads()
+}
+
+// Checks that the strings reported by a test class match the expected
+// strings.
+func checkReported(t *testing.T, results, expected []string) {
+	if len(results) != len(expected) {
+		t.Logf("Expected %d results, got %d: %q\n", len(expected),
+			len(results), results)
+		t.FailNow()
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Logf("Result %d: expected %q, got %q\n", i, expected[i],
+				results[i])
+			t.Fail()
+		}
+	}
+}
+
+// Checks that the error returned by runTestClass is the named exception, with
+// the given message.
+func checkThrown(t *testing.T, e error, className, message string) {
+	var thrown *bs_jvm.ThrownException
+	if !errors.As(e, &thrown) {
+		t.Logf("Expected a %s, got %v\n", className, e)
+		t.FailNow()
+	}
+	name := string(thrown.Exception.C.Name)
+	s := "null"
+	info := bs_jvm.GetThrowableInfo(thrown.Exception)
+	tmp, ok := info.Message.(*bs_jvm.StringObject)
+	if ok {
+		s = tmp.Value()
+	}
+	if (name != className) || (s != message) {
+		t.Logf("Expected %s(%q), got %s(%q)\n", className, message, name, s)
+		t.Fail()
+	}
+}
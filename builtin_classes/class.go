@@ -0,0 +1,112 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.Class. Instances of Class
+// are the *bs_jvm.Class objects themselves, rather than ClassInstances.
+import (
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"strings"
+)
+
+// An initialized version of the builtin Class class.
+var classClass *bs_jvm.Class
+
+// Pops a Class reference that must not be null.
+func popClassObject(t *bs_jvm.Thread) (*bs_jvm.Class, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	c, ok := tmp.(*bs_jvm.Class)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a Class, got " +
+			tmp.TypeName())
+	}
+	return c, nil
+}
+
+// Implements the getName() method.
+func getClassNameMethod(t *bs_jvm.Thread) error {
+	c, e := popClassObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(c.JavaName()))
+}
+
+// Implements the getSimpleName() method, which returns the class' name
+// without its package or enclosing classes. Array classes are named after
+// their type, e.g. "String[]".
+func getSimpleNameMethod(t *bs_jvm.Thread) error {
+	c, e := popClassObject(t)
+	if e != nil {
+		return e
+	}
+	name := string(c.Name)
+	if c.IsArray() {
+		name = c.ArrayType().String()
+	}
+	name = name[strings.LastIndexByte(name, '/')+1:]
+	name = name[strings.LastIndexByte(name, '$')+1:]
+	return t.Stack.PushRef(bs_jvm.NewStringObject(name))
+}
+
+// Implements the toString() method.
+func classToStringMethod(t *bs_jvm.Thread) error {
+	c, e := popClassObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := t.ObjectToString(c)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the isInterface() method.
+func isInterfaceMethod(t *bs_jvm.Thread) error {
+	c, e := popClassObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, c.IsInterface())
+}
+
+// Implements the getSuperclass() method. Like Java, this returns null for
+// interfaces and java/lang/Object.
+func getSuperclassMethod(t *bs_jvm.Thread) error {
+	c, e := popClassObject(t)
+	if e != nil {
+		return e
+	}
+	if c.IsInterface() || (c.Super == nil) {
+		return t.Stack.PushRef(nil)
+	}
+	return t.Stack.PushRef(c.Super)
+}
+
+// Returns a BS-JVM class implementing java/lang/Class. If it has already been
+// initialized, returns the existing copy.
+func GetClassClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if classClass != nil {
+		return classClass, nil
+	}
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/Class")
+	// public final super
+	toReturn.AccessFlags = 0x0031
+	AddMethod(toReturn, "getName", 1, noArgs, stringType, getClassNameMethod)
+	AddMethod(toReturn, "getSimpleName", 1, noArgs, stringType,
+		getSimpleNameMethod)
+	AddMethod(toReturn, "toString", 1, noArgs, stringType,
+		classToStringMethod)
+	// public native
+	AddMethod(toReturn, "isInterface", 0x0101, noArgs,
+		class_file.PrimitiveFieldType('Z'), isInterfaceMethod)
+	AddMethod(toReturn, "getSuperclass", 0x0101, noArgs,
+		class_file.ClassInstanceType("java/lang/Class"), getSuperclassMethod)
+	classClass = toReturn
+	return toReturn, nil
+}
@@ -0,0 +1,323 @@
+package builtin_classes
+
+// This file contains code implementing java.util.Formatter and the
+// java.lang.Appendable interface it writes to. The format strings themselves
+// are handled by bs_jvm.Thread.FormatString, which is also used by
+// String.format and PrintStream.printf.
+import (
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+)
+
+// An initialized version of the builtin Appendable interface.
+var appendableClass *bs_jvm.Class
+
+// An initialized version of the builtin Formatter class.
+var formatterClass *bs_jvm.Class
+
+// This holds internal data for the builtin Formatter class.
+type internalFormatter struct {
+	// The Appendable to which formatted output is written.
+	destination bs_jvm.Object
+	// Will be true after close() has been called.
+	closed bool
+}
+
+// Pops the format string and the Object[] of args passed to methods such as
+// printf or String.format. The returned slice of args is nil if the array was
+// null.
+func popFormatArgs(t *bs_jvm.Thread) (string, []bs_jvm.Object, error) {
+	tmp, e := t.Stack.PopRef()
+	if e != nil {
+		return "", nil, e
+	}
+	var args []bs_jvm.Object
+	if !bs_jvm.IsNull(tmp) {
+		array, ok := tmp.(*bs_jvm.ReferenceArray)
+		if !ok {
+			return "", nil, bs_jvm.TypeError("Expected an Object array, " +
+				"got " + tmp.TypeName())
+		}
+		args = array.Elements
+	}
+	format, e := popStringObject(t)
+	if e != nil {
+		return "", nil, e
+	}
+	return format.Value(), args, nil
+}
+
+// Appends the string to an Appendable. Our builtin StringBuilder and
+// PrintStream classes are written to directly. Otherwise, this invokes the
+// Appendable's append(CharSequence) method.
+func appendToAppendable(t *bs_jvm.Thread, destination bs_jvm.Object,
+	s string) error {
+	instance, ok := destination.(*bs_jvm.ClassInstance)
+	if !ok {
+		return bs_jvm.TypeError("Expected an Appendable, got " +
+			destination.TypeName())
+	}
+	if instance.C == stringBuilderClass {
+		state := instance.NativeData.(*internalStringBuilder)
+		state.chars = append(state.chars,
+			bs_jvm.NewStringObject(s).UTF16()...)
+		return nil
+	}
+	if instance.C == printStreamClass {
+		instance.NativeData.(*internalPrintStream).write(s)
+		return nil
+	}
+	method, e := instance.C.ResolveMethod(bs_jvm.GetMethodKey(
+		&class_file.Method{
+			Name: []byte("append"),
+			Descriptor: &class_file.MethodDescriptor{
+				ArgumentTypes: []class_file.FieldType{
+					class_file.ClassInstanceType("java/lang/CharSequence"),
+				},
+				ReturnType: class_file.ClassInstanceType(
+					"java/lang/Appendable"),
+			},
+		}))
+	if e != nil {
+		return e
+	}
+	method, e = instance.C.SelectMethod(method)
+	if e != nil {
+		return e
+	}
+	_, e = t.Invoke(method, instance, bs_jvm.NewStringObject(s))
+	return e
+}
+
+// Pops a Formatter instance, returning its internal data. Throws a
+// FormatterClosedException if the Formatter has been closed.
+func popInternalFormatter(t *bs_jvm.Thread) (*bs_jvm.ClassInstance,
+	*internalFormatter, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, nil, e
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, nil, bs_jvm.TypeError("Didn't get class instance")
+	}
+	f, ok := instance.NativeData.(*internalFormatter)
+	if !ok {
+		return nil, nil, bs_jvm.TypeError("Didn't get an initialized " +
+			"Formatter instance")
+	}
+	if f.closed {
+		return nil, nil, throwFormatterClosed(t)
+	}
+	return instance, f, nil
+}
+
+// Throws a FormatterClosedException. Like Java, the exception's message is
+// null.
+func throwFormatterClosed(t *bs_jvm.Thread) error {
+	exception, e := t.NewThrowable("java/util/FormatterClosedException", "")
+	if e != nil {
+		return e
+	}
+	bs_jvm.GetThrowableInfo(exception).Message = nil
+	return &bs_jvm.ThrownException{
+		Exception: exception,
+	}
+}
+
+// Sets the destination of a newly constructed Formatter. If the destination
+// is null, the Formatter writes to a new StringBuilder, like Java.
+func initFormatter(t *bs_jvm.Thread, destination bs_jvm.Object) error {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return bs_jvm.TypeError("Didn't get class instance")
+	}
+	if bs_jvm.IsNull(destination) {
+		sb, e := stringBuilderClass.CreateInstance()
+		if e != nil {
+			return e
+		}
+		initStringBuilder(sb, nil)
+		destination = sb
+	}
+	instance.NativeData = &internalFormatter{
+		destination: destination,
+	}
+	return nil
+}
+
+// Implements the Formatter() constructor.
+func noArgsFormatterConstructor(t *bs_jvm.Thread) error {
+	return initFormatter(t, nil)
+}
+
+// Implements the Formatter(Appendable) constructor.
+func appendableFormatterConstructor(t *bs_jvm.Thread) error {
+	destination, e := t.Stack.PopRef()
+	if e != nil {
+		return e
+	}
+	return initFormatter(t, destination)
+}
+
+// Implements the Formatter(PrintStream) constructor. Unlike the Appendable
+// constructor, the PrintStream must not be null.
+func printStreamFormatterConstructor(t *bs_jvm.Thread) error {
+	destination, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	return initFormatter(t, destination)
+}
+
+// Implements the format(String, Object...) method, which returns the
+// Formatter.
+func formatterFormatMethod(t *bs_jvm.Thread) error {
+	format, args, e := popFormatArgs(t)
+	if e != nil {
+		return e
+	}
+	instance, f, e := popInternalFormatter(t)
+	if e != nil {
+		return e
+	}
+	s, e := t.FormatString(format, args)
+	if e != nil {
+		return e
+	}
+	e = appendToAppendable(t, f.destination, s)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(instance)
+}
+
+// Implements the out() method, which returns the Formatter's destination.
+func formatterOutMethod(t *bs_jvm.Thread) error {
+	_, f, e := popInternalFormatter(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(f.destination)
+}
+
+// Implements the toString() method, which returns the result of calling
+// toString() on the Formatter's destination.
+func formatterToStringMethod(t *bs_jvm.Thread) error {
+	_, f, e := popInternalFormatter(t)
+	if e != nil {
+		return e
+	}
+	s, e := t.ObjectToString(f.destination)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the flush() method.
+func formatterFlushMethod(t *bs_jvm.Thread) error {
+	_, f, e := popInternalFormatter(t)
+	if e != nil {
+		return e
+	}
+	instance := f.destination.(*bs_jvm.ClassInstance)
+	if instance.C == printStreamClass {
+		instance.NativeData.(*internalPrintStream).flush()
+	}
+	return nil
+}
+
+// Implements the close() method. Unlike the Formatter's other methods, this
+// may be called after the Formatter has already been closed.
+func formatterCloseMethod(t *bs_jvm.Thread) error {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return bs_jvm.TypeError("Didn't get class instance")
+	}
+	f, ok := instance.NativeData.(*internalFormatter)
+	if !ok {
+		return bs_jvm.TypeError("Didn't get an initialized Formatter " +
+			"instance")
+	}
+	f.closed = true
+	return nil
+}
+
+// Returns a BS-JVM class implementing the java/lang/Appendable interface. If
+// it has already been initialized, returns the existing copy.
+func GetAppendableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if appendableClass != nil {
+		return appendableClass, nil
+	}
+	selfType := class_file.ClassInstanceType("java/lang/Appendable")
+	charSequenceType := class_file.ClassInstanceType("java/lang/CharSequence")
+	intType := class_file.PrimitiveFieldType('I')
+	toReturn := GetEmptyClass(jvm, "java/lang/Appendable")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	// public abstract
+	AddMethod(toReturn, "append", 0x0401, []class_file.FieldType{
+		charSequenceType}, selfType, nil)
+	AddMethod(toReturn, "append", 0x0401, []class_file.FieldType{
+		charSequenceType, intType, intType}, selfType, nil)
+	AddMethod(toReturn, "append", 0x0401, []class_file.FieldType{
+		class_file.PrimitiveFieldType('C')}, selfType, nil)
+	appendableClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/util/Formatter. If it has already
+// been initialized, returns the existing copy.
+func GetFormatterClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if formatterClass != nil {
+		return formatterClass, nil
+	}
+	// Formatters may write to StringBuilders and PrintStreams directly, so
+	// make sure their classes are initialized.
+	_, e := GetStringBuilderClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	_, e = GetPrintStreamClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	selfType := class_file.ClassInstanceType("java/util/Formatter")
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	appendableType := class_file.ClassInstanceType("java/lang/Appendable")
+	voidType := class_file.PrimitiveFieldType('V')
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/util/Formatter")
+	// public final super
+	toReturn.AccessFlags = 0x0031
+	AddConstructor(toReturn, 1, noArgs, noArgsFormatterConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{appendableType},
+		appendableFormatterConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{
+		class_file.ClassInstanceType("java/io/PrintStream")},
+		printStreamFormatterConstructor)
+	// public varargs
+	AddMethod(toReturn, "format", 0x0081, []class_file.FieldType{
+		class_file.ClassInstanceType("java/lang/String"),
+		&class_file.ArrayType{
+			ContentType: objectType,
+			Dimensions:  1,
+		}}, selfType, formatterFormatMethod)
+	AddMethod(toReturn, "out", 1, noArgs, appendableType, formatterOutMethod)
+	AddMethod(toReturn, "toString", 1, noArgs,
+		class_file.ClassInstanceType("java/lang/String"),
+		formatterToStringMethod)
+	AddMethod(toReturn, "flush", 1, noArgs, voidType, formatterFlushMethod)
+	AddMethod(toReturn, "close", 1, noArgs, voidType, formatterCloseMethod)
+	formatterClass = toReturn
+	return toReturn, nil
+}
@@ -0,0 +1,165 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// Emits code creating an Object[] array containing the values pushed by the
+// given functions, which must push references.
+func emitObjectArray(m *builder.MethodBuilder, elements ...func()) {
+	m.EmitInt(int32(len(elements)))
+	m.EmitType(builder.Anewarray, "java/lang/Object")
+	for i, emitElement := range elements {
+		m.Emit(builder.Dup)
+		m.EmitInt(int32(i))
+		emitElement()
+		m.Emit(builder.Aastore)
+	}
+}
+
+// Emits a call to format(String, Object...) on the Formatter on top of the
+// stack, leaving the Formatter on the stack.
+func emitFormat(m *builder.MethodBuilder, format string,
+	args ...func()) {
+	m.EmitString(format)
+	emitObjectArray(m, args...)
+	m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter", "format",
+		"(Ljava/lang/String;[Ljava/lang/Object;)Ljava/util/Formatter;")
+}
+
+// Emits code creating a Formatter using its no-args constructor.
+func emitNewFormatter(m *builder.MethodBuilder) {
+	m.EmitType(builder.New, "java/util/Formatter")
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokespecial, "java/util/Formatter", "<init>",
+		"()V")
+}
+
+// Loads an Appendable class implemented in bytecode, whose
+// append(CharSequence) method passes its argument to the test class' report
+// method, so that Formatters can't write to it directly.
+func loadTestAppendable(t *testing.T, name, testClassName string) {
+	b := builder.NewClassBuilder(name, "java/lang/Object")
+	b.AddInterface("java/lang/Appendable")
+	m := b.AddMethod(1, "<init>", "()V")
+	m.EmitLocal(builder.Aload, 0)
+	m.EmitInvoke(builder.Invokespecial, "java/lang/Object", "<init>", "()V")
+	m.Emit(builder.Return)
+	m = b.AddMethod(1, "append",
+		"(Ljava/lang/CharSequence;)Ljava/lang/Appendable;")
+	m.EmitLocal(builder.Aload, 1)
+	emitReport(m, testClassName, "Ljava/lang/CharSequence;")
+	m.EmitLocal(builder.Aload, 0)
+	m.Emit(builder.Areturn)
+	loadTestClass(t, b)
+}
+
+func TestFormatterMethods(t *testing.T) {
+	closedOperations := []struct {
+		name       string
+		descriptor string
+	}{
+		{"out", "()Ljava/lang/Appendable;"},
+		{"toString", "()Ljava/lang/String;"},
+		{"flush", "()V"},
+	}
+	type formatterTest struct {
+		name      string
+		emit      func(m *builder.MethodBuilder, className string)
+		expected  []string
+		exception string
+		message   string
+	}
+	tests := []formatterTest{
+		{"Appendable", func(m *builder.MethodBuilder, className string) {
+			appendable := className + "Destination"
+			loadTestAppendable(t, appendable, className)
+			m.EmitType(builder.New, appendable)
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokespecial, appendable, "<init>", "()V")
+			m.EmitLocal(builder.Astore, 0)
+			m.EmitType(builder.New, "java/util/Formatter")
+			m.Emit(builder.Dup)
+			m.EmitLocal(builder.Aload, 0)
+			m.EmitInvoke(builder.Invokespecial, "java/util/Formatter",
+				"<init>", "(Ljava/lang/Appendable;)V")
+			emitFormat(m, "%d-%s", func() {
+				m.EmitInt(5)
+				emitValueOf(m, "I")
+			}, func() {
+				m.EmitString("x")
+			})
+			emitFormat(m, "%c", func() {
+				m.EmitInt('!')
+				emitValueOf(m, "C")
+			})
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter", "out",
+				"()Ljava/lang/Appendable;")
+			m.EmitLocal(builder.Aload, 0)
+			emitReportSame(m, className)
+		}, []string{"5-x", "!", "same"}, "", ""},
+		{"StringBuilder", func(m *builder.MethodBuilder, className string) {
+			// The no-args constructor writes to a new StringBuilder.
+			emitNewFormatter(m)
+			emitFormat(m, "%05d|", func() {
+				m.EmitInt(42)
+				emitValueOf(m, "I")
+			})
+			emitFormat(m, "%b", func() {
+				m.Emit(builder.Aconst_null)
+			})
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter",
+				"toString", "()Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter", "out",
+				"()Ljava/lang/Appendable;")
+			emitReport(m, className, "Ljava/lang/Appendable;")
+		}, []string{"00042|false", "00042|false"}, "", ""},
+		{"Closed", func(m *builder.MethodBuilder, className string) {
+			// Closing a Formatter more than once is allowed, but formatting
+			// after closing it isn't.
+			emitNewFormatter(m)
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter",
+				"close", "()V")
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter",
+				"close", "()V")
+			m.EmitString("closed")
+			emitReport(m, className, "Ljava/lang/String;")
+			emitFormat(m, "x")
+		}, []string{"closed"}, "java/util/FormatterClosedException",
+			"null"},
+	}
+	for _, op := range closedOperations {
+		op := op
+		tests = append(tests, formatterTest{"Closed" + op.name, func(
+			m *builder.MethodBuilder,
+			className string) {
+			emitNewFormatter(m)
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter",
+				"close", "()V")
+			m.EmitInvoke(builder.Invokevirtual, "java/util/Formatter",
+				op.name, op.descriptor)
+		}, nil, "java/util/FormatterClosedException", "null"})
+	}
+	for _, test := range tests {
+		className := "FormatterTest" + test.name
+		b := newTestClassBuilder(className)
+		m := b.AddMethod(0x0009, "run", "()V")
+		test.emit(m, className)
+		m.Emit(builder.Return)
+		results, e := runTestClass(t, b)
+		if test.exception != "" {
+			checkThrown(t, e, test.exception, test.message)
+		} else if e != nil {
+			t.Logf("%s failed: %s\n", className, e)
+			t.Fail()
+			continue
+		}
+		checkReported(t, results, test.expected)
+	}
+}
@@ -0,0 +1,209 @@
+package builtin_classes
+
+// This file contains code implementing java.io.InputStream, which is used for
+// System.in.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"io"
+	"sync"
+)
+
+// An initialized version of our builtin InputStream class.
+var inputStreamClass *bs_jvm.Class
+
+// This holds internal data for the builtin InputStream class.
+type internalInputStream struct {
+	// The io.Reader from which we are reading data.
+	r io.Reader
+	// Prevents concurrent threads from reading at the same time.
+	lock sync.Mutex
+}
+
+// Creates a new InputStream instance that reads from the given io.Reader.
+func newInputStream(c *bs_jvm.Class, r io.Reader) *bs_jvm.ClassInstance {
+	return &bs_jvm.ClassInstance{
+		C: c,
+		NativeData: &internalInputStream{
+			r: r,
+		},
+	}
+}
+
+// Reads into the buffer, which must not be empty, blocking until at least one
+// byte is available. Returns the number of bytes read, or -1 at the end of
+// the stream.
+func (s *internalInputStream) read(buffer []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		n, e := s.r.Read(buffer)
+		if n > 0 {
+			return n, nil
+		}
+		if e == io.EOF {
+			return -1, nil
+		}
+		if e != nil {
+			return 0, e
+		}
+	}
+}
+
+// Pops an InputStream instance, returning its internal data.
+func popInternalInputStream(t *bs_jvm.Thread) (*internalInputStream, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, fmt.Errorf("Failed popping InputStream instance: %w", e)
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, bs_jvm.TypeError("Didn't get class instance")
+	}
+	s, ok := instance.NativeData.(*internalInputStream)
+	if !ok {
+		return nil, bs_jvm.TypeError("Didn't get InputStream instance")
+	}
+	return s, nil
+}
+
+// Implements the read() method, which returns a single byte, or -1 at the end
+// of the stream.
+func readByteMethod(t *bs_jvm.Thread) error {
+	s, e := popInternalInputStream(t)
+	if e != nil {
+		return e
+	}
+	var buffer [1]byte
+	n, e := s.read(buffer[:])
+	if e != nil {
+		return t.Throw("java/io/IOException", e.Error())
+	}
+	if n < 0 {
+		return t.Stack.Push(-1)
+	}
+	return t.Stack.Push(bs_jvm.Int(buffer[0]))
+}
+
+// Reads into b[offset:offset+length] from the InputStream, and pushes the
+// number of bytes read, or -1 at the end of the stream. The InputStream must
+// be on top of the stack, with the method's other args already popped.
+func readIntoArray(t *bs_jvm.Thread, b []bs_jvm.Byte, offset,
+	length bs_jvm.Int) error {
+	s, e := popInternalInputStream(t)
+	if e != nil {
+		return e
+	}
+	if (offset < 0) || (length < 0) || (int(length) > len(b)-int(offset)) {
+		return t.Throw("java/lang/IndexOutOfBoundsException",
+			fmt.Sprintf("Range [%d, %d + %d) out of bounds for length %d",
+				offset, offset, length, len(b)))
+	}
+	if length == 0 {
+		return t.Stack.Push(0)
+	}
+	buffer := make([]byte, length)
+	n, e := s.read(buffer)
+	if e != nil {
+		return t.Throw("java/io/IOException", e.Error())
+	}
+	for i := 0; i < n; i++ {
+		b[int(offset)+i] = bs_jvm.Byte(buffer[i])
+	}
+	return t.Stack.Push(bs_jvm.Int(n))
+}
+
+// Pops a byte array that must not be null.
+func popByteArray(t *bs_jvm.Thread) ([]bs_jvm.Byte, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	array, ok := tmp.(*bs_jvm.ByteArray)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a byte array, got " +
+			tmp.TypeName())
+	}
+	return array.Elements, nil
+}
+
+// Implements the read(byte[]) method.
+func readArrayMethod(t *bs_jvm.Thread) error {
+	b, e := popByteArray(t)
+	if e != nil {
+		return e
+	}
+	return readIntoArray(t, b, 0, bs_jvm.Int(len(b)))
+}
+
+// Implements the read(byte[], int, int) method.
+func readArrayRangeMethod(t *bs_jvm.Thread) error {
+	length, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	offset, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	b, e := popByteArray(t)
+	if e != nil {
+		return e
+	}
+	return readIntoArray(t, b, offset, length)
+}
+
+// Implements the available() method. We can't tell how much data an arbitrary
+// io.Reader can provide without blocking, so this always returns 0, which is
+// allowed by Java.
+func availableMethod(t *bs_jvm.Thread) error {
+	_, e := popInternalInputStream(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(0)
+}
+
+// Implements the close() method, closing the underlying io.Reader if it
+// supports closing.
+func closeInputStreamMethod(t *bs_jvm.Thread) error {
+	s, e := popInternalInputStream(t)
+	if e != nil {
+		return e
+	}
+	closer, ok := s.r.(io.Closer)
+	if !ok {
+		return nil
+	}
+	e = closer.Close()
+	if e != nil {
+		return t.Throw("java/io/IOException", e.Error())
+	}
+	return nil
+}
+
+// Returns a BS-JVM class implementing java/io/InputStream. If a class has
+// already been initialized, returns the existing copy.
+func GetInputStreamClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if inputStreamClass != nil {
+		return inputStreamClass, nil
+	}
+	intType := class_file.PrimitiveFieldType('I')
+	byteArrayType := &class_file.ArrayType{
+		ContentType: class_file.PrimitiveFieldType('B'),
+		Dimensions:  1,
+	}
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/io/InputStream")
+	AddMethod(toReturn, "read", 1, noArgs, intType, readByteMethod)
+	AddMethod(toReturn, "read", 1, []class_file.FieldType{byteArrayType},
+		intType, readArrayMethod)
+	AddMethod(toReturn, "read", 1, []class_file.FieldType{byteArrayType,
+		intType, intType}, intType, readArrayRangeMethod)
+	AddMethod(toReturn, "available", 1, noArgs, intType, availableMethod)
+	AddMethod(toReturn, "close", 1, noArgs,
+		class_file.PrimitiveFieldType('V'), closeInputStreamMethod)
+	inputStreamClass = toReturn
+	return toReturn, nil
+}
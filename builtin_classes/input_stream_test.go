@@ -0,0 +1,108 @@
+package builtin_classes
+
+import (
+	"bytes"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// Loads and runs the class built by b, with its native static stream() method
+// returning an InputStream that reads the given data.
+func runInputStreamTest(t *testing.T, b *builder.ClassBuilder,
+	data string) ([]string, error) {
+	c := loadTestClass(t, b)
+	stream := newInputStream(inputStreamClass, bytes.NewReader([]byte(data)))
+	getNamedMethod(t, c, "stream").Native = func(
+		thread *bs_jvm.Thread) error {
+		return thread.Stack.PushRef(stream)
+	}
+	return runLoadedTestClass(t, c)
+}
+
+func TestInputStreamRead(t *testing.T) {
+	className := "InputStreamReadTest"
+	b := newTestClassBuilder(className)
+	b.AddMethod(0x0109, "stream", "()Ljava/io/InputStream;")
+	m := b.AddMethod(0x0009, "run", "()V")
+	emitStream := func() {
+		m.EmitInvoke(builder.Invokestatic, className, "stream",
+			"()Ljava/io/InputStream;")
+	}
+	// Emits a call to read(byte[], int, int) into the array in local 0, and
+	// reports the result.
+	emitReadRange := func(offset, length int32) {
+		emitStream()
+		m.EmitLocal(builder.Aload, 0)
+		m.EmitInt(offset)
+		m.EmitInt(length)
+		m.EmitInvoke(builder.Invokevirtual, "java/io/InputStream", "read",
+			"([BII)I")
+		emitReport(m, className, "I")
+	}
+	m.EmitInt(4)
+	// T_BYTE
+	m.Emit(builder.Newarray, 8)
+	m.EmitLocal(builder.Astore, 0)
+	emitStream()
+	m.EmitInvoke(builder.Invokevirtual, "java/io/InputStream", "read", "()I")
+	emitReport(m, className, "I")
+	emitReadRange(1, 2)
+	for i := int32(0); i < 4; i++ {
+		m.EmitLocal(builder.Aload, 0)
+		m.EmitInt(i)
+		m.Emit(builder.Baload)
+		emitReport(m, className, "B")
+	}
+	// Reading nothing returns 0 even at the end of the stream.
+	emitReadRange(0, 0)
+	emitStream()
+	m.EmitInvoke(builder.Invokevirtual, "java/io/InputStream", "read", "()I")
+	emitReport(m, className, "I")
+	emitStream()
+	m.EmitLocal(builder.Aload, 0)
+	m.EmitInvoke(builder.Invokevirtual, "java/io/InputStream", "read",
+		"([B)I")
+	emitReport(m, className, "I")
+	// The range is checked even at the end of the stream.
+	emitReadRange(3, 2)
+	m.Emit(builder.Return)
+	results, e := runInputStreamTest(t, b, "abc")
+	checkThrown(t, e, "java/lang/IndexOutOfBoundsException",
+		"Range [3, 3 + 2) out of bounds for length 4")
+	checkReported(t, results, []string{"97", "2", "0", "98", "99", "0", "0",
+		"-1", "-1"})
+}
+
+func TestInputStreamReadBounds(t *testing.T) {
+	tests := []struct {
+		offset  int32
+		length  int32
+		message string
+	}{
+		{-1, 1, "Range [-1, -1 + 1) out of bounds for length 4"},
+		{0, -1, "Range [0, 0 + -1) out of bounds for length 4"},
+		{0, 5, "Range [0, 0 + 5) out of bounds for length 4"},
+		{4, 1, "Range [4, 4 + 1) out of bounds for length 4"},
+	}
+	for i, test := range tests {
+		className := "InputStreamBoundsTest" + string(rune('A'+i))
+		b := newTestClassBuilder(className)
+		b.AddMethod(0x0109, "stream", "()Ljava/io/InputStream;")
+		m := b.AddMethod(0x0009, "run", "()V")
+		m.EmitInvoke(builder.Invokestatic, className, "stream",
+			"()Ljava/io/InputStream;")
+		m.EmitInt(4)
+		m.Emit(builder.Newarray, 8)
+		m.EmitInt(test.offset)
+		m.EmitInt(test.length)
+		m.EmitInvoke(builder.Invokevirtual, "java/io/InputStream", "read",
+			"([BII)I")
+		emitReport(m, className, "I")
+		m.Emit(builder.Return)
+		results, e := runInputStreamTest(t, b, "abcdef")
+		checkThrown(t, e, "java/lang/IndexOutOfBoundsException",
+			test.message)
+		checkReported(t, results, nil)
+	}
+}
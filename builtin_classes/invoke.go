@@ -0,0 +1,738 @@
+package builtin_classes
+
+// This file contains code implementing the bootstrap methods that javac uses
+// with the invokedynamic instruction: java.lang.invoke.LambdaMetafactory and
+// java.lang.invoke.StringConcatFactory.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// An initialized version of the builtin LambdaMetafactory class.
+var lambdaMetafactoryClass *bs_jvm.Class
+
+// An initialized version of the builtin StringConcatFactory class.
+var stringConcatFactoryClass *bs_jvm.Class
+
+// Used to give each class generated for a lambda a unique name.
+var lambdaClassCount uint32
+
+// Flags that may be passed to LambdaMetafactory.altMetafactory.
+const (
+	lambdaFlagMarkers = 2
+	lambdaFlagBridges = 4
+)
+
+// Holds the information needed to create a lambda's call site.
+type lambdaInfo struct {
+	// The class containing the lambda.
+	lookup *bs_jvm.MethodHandlesLookup
+	// The name of the functional interface's method.
+	name string
+	// The type of the call site, which takes the values captured by the
+	// lambda and returns the functional interface.
+	factoryType *class_file.MethodDescriptor
+	// The erased type of the functional interface's method.
+	methodType *class_file.MethodDescriptor
+	// The method invoked by the lambda.
+	implementation *bs_jvm.ResolvedMethodHandle
+	// Any additional interfaces implemented by the lambda.
+	markers []*bs_jvm.Class
+	// Any additional types of the functional interface's method, which the
+	// lambda must also implement.
+	bridges []*class_file.MethodDescriptor
+}
+
+// Returns the parsed descriptor of a MethodType object.
+func getMethodTypeDescriptor(o bs_jvm.Object) (*class_file.MethodDescriptor,
+	error) {
+	methodType, ok := o.(*bs_jvm.MethodType)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a MethodType, got " +
+			o.TypeName())
+	}
+	return class_file.ParseMethodDescriptor([]byte(*methodType))
+}
+
+// Pops a MethodType from the stack, returning its parsed descriptor.
+func popMethodType(t *bs_jvm.Thread) (*class_file.MethodDescriptor, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	return getMethodTypeDescriptor(tmp)
+}
+
+// Pops a MethodHandles$Lookup object from the stack.
+func popLookup(t *bs_jvm.Thread) (*bs_jvm.MethodHandlesLookup, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	toReturn, ok := tmp.(*bs_jvm.MethodHandlesLookup)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a Lookup, got " +
+			tmp.TypeName())
+	}
+	return toReturn, nil
+}
+
+// Pops an array of objects from the stack, returning its elements.
+func popObjectArray(t *bs_jvm.Thread) ([]bs_jvm.Object, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	array, ok := tmp.(*bs_jvm.ReferenceArray)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected an array, got " +
+			tmp.TypeName())
+	}
+	return array.Elements, nil
+}
+
+// Pops the arguments shared by metafactory and altMetafactory: the lookup,
+// the name of the interface method, and the call site's type.
+func popLambdaInfo(t *bs_jvm.Thread) (*lambdaInfo, error) {
+	factoryType, e := popMethodType(t)
+	if e != nil {
+		return nil, e
+	}
+	name, e := popStringNotNull(t)
+	if e != nil {
+		return nil, e
+	}
+	lookup, e := popLookup(t)
+	if e != nil {
+		return nil, e
+	}
+	return &lambdaInfo{
+		lookup:      lookup,
+		name:        name,
+		factoryType: factoryType,
+	}, nil
+}
+
+// Returns a character indicating how a value of the given type is stored on
+// the stack: 'I' for ints and smaller types, 'J', 'F' or 'D' for the other
+// primitives, 'L' for references, or 'V' for void.
+func stackCategory(t class_file.FieldType) byte {
+	p, isPrimitive := t.(class_file.PrimitiveFieldType)
+	if !isPrimitive {
+		return 'L'
+	}
+	switch p {
+	case 'B', 'C', 'S', 'Z', 'I':
+		return 'I'
+	}
+	return byte(p)
+}
+
+// Maps each primitive type to the types it can be converted to using a
+// widening primitive conversion.
+var primitiveWidenings = map[class_file.PrimitiveFieldType]string{
+	'B': "SIJFD",
+	'S': "IJFD",
+	'C': "IJFD",
+	'I': "JFD",
+	'J': "FD",
+	'F': "D",
+}
+
+// Returns true if a primitive value of type from can be used as type to,
+// either unchanged or using a widening primitive conversion.
+func canWidenPrimitive(from, to class_file.PrimitiveFieldType) bool {
+	return (from == to) || strings.ContainsRune(primitiveWidenings[from],
+		rune(to))
+}
+
+// Returns the primitive type wrapped by the named class, or 0 if the class
+// doesn't wrap a primitive type.
+func getWrappedType(className string) class_file.PrimitiveFieldType {
+	for _, t := range []byte("ZBCSIJFD") {
+		p := class_file.PrimitiveFieldType(t)
+		if bs_jvm.BoxedClassName(p) == className {
+			return p
+		}
+	}
+	return 0
+}
+
+// Converts a value passed between a lambda's interface method and its
+// implementation, returning the converted value.
+type lambdaConversion func(t *bs_jvm.Thread, v bs_jvm.Object) (bs_jvm.Object,
+	error)
+
+// Unboxes a value passed to or returned by a lambda, widening it to the given
+// primitive type if necessary.
+func unboxLambdaValue(t *bs_jvm.Thread, v bs_jvm.Object,
+	to class_file.PrimitiveFieldType) (bs_jvm.Object, error) {
+	if bs_jvm.IsNull(v) {
+		return nil, bs_jvm.NullReferenceError("Can't unbox a null " +
+			"reference to " + to.String())
+	}
+	boxed := bs_jvm.GetBoxedValue(v)
+	if (boxed == nil) || !canWidenPrimitive(boxed.Type, to) {
+		return nil, t.Throw("java/lang/ClassCastException",
+			fmt.Sprintf("%s can't be unboxed to %s", bs_jvm.JavaClassName(v),
+				to))
+	}
+	return convertPrimitive(boxed.Value, to), nil
+}
+
+// Returns the conversion that LambdaMetafactory applies to a value of type
+// from, so that it can be used as type to. This may be a widening primitive
+// conversion, boxing, or unboxing followed by widening. Returns nil if the
+// value can be used unchanged, or an error if the conversion isn't allowed.
+// Like the rest of this JVM, casts between reference types aren't checked.
+func getLambdaConversion(jvm *bs_jvm.JVM, from,
+	to class_file.FieldType) (lambdaConversion, error) {
+	fromPrimitive, fromIsPrimitive := from.(class_file.PrimitiveFieldType)
+	toPrimitive, toIsPrimitive := to.(class_file.PrimitiveFieldType)
+	if !fromIsPrimitive && !toIsPrimitive {
+		return nil, nil
+	}
+	invalid := bs_jvm.TypeError(fmt.Sprintf("Can't convert %s to %s", from,
+		to))
+	if fromIsPrimitive && toIsPrimitive {
+		if !canWidenPrimitive(fromPrimitive, toPrimitive) {
+			return nil, invalid
+		}
+		if stackCategory(from) == stackCategory(to) {
+			return nil, nil
+		}
+		return func(t *bs_jvm.Thread, v bs_jvm.Object) (bs_jvm.Object,
+			error) {
+			return convertPrimitive(v, toPrimitive), nil
+		}, nil
+	}
+	if fromIsPrimitive {
+		// Boxed values may be used as any supertype of their class, e.g.
+		// Object or Number.
+		wrapper := class_file.ClassInstanceType(bs_jvm.BoxedClassName(
+			fromPrimitive))
+		if (wrapper == "") || !jvm.IsAssignableType(wrapper, to) {
+			return nil, invalid
+		}
+		return func(t *bs_jvm.Thread, v bs_jvm.Object) (bs_jvm.Object,
+			error) {
+			return boxValue(t, fromPrimitive, v)
+		}, nil
+	}
+	// If the reference's type is known to be a wrapper class, its value must
+	// be able to be widened. Otherwise, e.g. for erased Objects, it's checked
+	// when unboxing.
+	className, ok := from.(class_file.ClassInstanceType)
+	if !ok || (toPrimitive == 'V') {
+		return nil, invalid
+	}
+	wrapped := getWrappedType(string(className))
+	if (wrapped != 0) && !canWidenPrimitive(wrapped, toPrimitive) {
+		return nil, invalid
+	}
+	return func(t *bs_jvm.Thread, v bs_jvm.Object) (bs_jvm.Object, error) {
+		return unboxLambdaValue(t, v, toPrimitive)
+	}, nil
+}
+
+// Holds the conversions applied to the args and result of one of a lambda's
+// interface methods. Nil conversions leave values unchanged.
+type lambdaConversions struct {
+	// The conversions for the values captured by the lambda, followed by the
+	// interface method's args.
+	args   []lambdaConversion
+	result lambdaConversion
+	// This is true if the interface method returns void, but the
+	// implementation doesn't.
+	discardResult bool
+}
+
+// Returns the conversions needed to invoke the lambda's implementation with
+// the captured values and the args of an interface method with the given
+// type, and to return its result from the interface method. Returns an error
+// if the types aren't compatible.
+func getLambdaConversions(jvm *bs_jvm.JVM, info *lambdaInfo,
+	methodType *class_file.MethodDescriptor) (*lambdaConversions, error) {
+	implArgs := info.implementation.ArgumentTypes()
+	lambdaArgs := append(append([]class_file.FieldType{},
+		info.factoryType.ArgumentTypes...), methodType.ArgumentTypes...)
+	name := info.implementation.Method.Name
+	if len(implArgs) != len(lambdaArgs) {
+		return nil, bs_jvm.TypeError(fmt.Sprintf("Lambda implementation %s "+
+			"takes %d args, but needs to take %d", name, len(implArgs),
+			len(lambdaArgs)))
+	}
+	var e error
+	toReturn := &lambdaConversions{
+		args: make([]lambdaConversion, len(implArgs)),
+	}
+	for i := range implArgs {
+		toReturn.args[i], e = getLambdaConversion(jvm, lambdaArgs[i],
+			implArgs[i])
+		if e != nil {
+			return nil, fmt.Errorf("Bad arg %d for lambda implementation %s: "+
+				"%w", i, name, e)
+		}
+	}
+	returnCategory := stackCategory(methodType.ReturnType)
+	implReturnCategory := stackCategory(info.implementation.ReturnType())
+	if returnCategory == 'V' {
+		toReturn.discardResult = implReturnCategory != 'V'
+		return toReturn, nil
+	}
+	if implReturnCategory == 'V' {
+		return nil, bs_jvm.TypeError(fmt.Sprintf("Lambda implementation %s "+
+			"doesn't return a value", name))
+	}
+	toReturn.result, e = getLambdaConversion(jvm,
+		info.implementation.ReturnType(), methodType.ReturnType)
+	if e != nil {
+		return nil, fmt.Errorf("Bad return type for lambda implementation "+
+			"%s: %w", name, e)
+	}
+	return toReturn, nil
+}
+
+// Returns a native method implementing a lambda's interface method with the
+// given type. It invokes the implementation with the values captured by the
+// lambda, followed by the interface method's args, after applying the given
+// conversions.
+func getLambdaMethod(methodType *class_file.MethodDescriptor,
+	implementation *bs_jvm.ResolvedMethodHandle,
+	conversions *lambdaConversions) bs_jvm.NativeMethod {
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(methodType.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+		if e != nil {
+			return e
+		}
+		instance, ok := tmp.(*bs_jvm.ClassInstance)
+		if !ok {
+			return bs_jvm.TypeError("Expected a lambda, got " + tmp.TypeName())
+		}
+		captured, ok := instance.NativeData.([]bs_jvm.Object)
+		if !ok {
+			return bs_jvm.TypeError("Got uninitialized lambda instance")
+		}
+		args = append(append([]bs_jvm.Object{}, captured...), args...)
+		for i, convert := range conversions.args {
+			if convert == nil {
+				continue
+			}
+			args[i], e = convert(t, args[i])
+			if e != nil {
+				return e
+			}
+		}
+		if conversions.discardResult {
+			_, e = implementation.Invoke(t, args)
+			return e
+		}
+		if conversions.result == nil {
+			return implementation.TailCall(t, args)
+		}
+		result, e := implementation.Invoke(t, args)
+		if e != nil {
+			return e
+		}
+		result, e = conversions.result(t, result)
+		if e != nil {
+			return e
+		}
+		return t.Stack.PushUnconditional(result)
+	}
+}
+
+// Creates a class implementing the lambda's functional interface, and pushes
+// a call site that creates instances of it.
+func pushLambdaCallSite(t *bs_jvm.Thread, info *lambdaInfo) error {
+	returnType := info.factoryType.ReturnType
+	interfaceName, ok := returnType.(class_file.ClassInstanceType)
+	if !ok {
+		return bs_jvm.TypeError("Lambdas must return an interface, not " +
+			returnType.String())
+	}
+	iface, e := t.ParentJVM.GetOrLoadClass(string(interfaceName))
+	if e != nil {
+		return e
+	}
+	if !iface.IsInterface() {
+		return bs_jvm.IncompatibleClassChangeError(fmt.Sprintf("Lambdas "+
+			"can't implement %s, which isn't an interface", iface.Name))
+	}
+	className := fmt.Sprintf("%s$$Lambda$%d", info.lookup.C.Name,
+		atomic.AddUint32(&lambdaClassCount, 1))
+	lambdaClass := GetEmptyClass(t.ParentJVM, className)
+	// final synthetic
+	lambdaClass.AccessFlags = 0x1010
+	lambdaClass.Interfaces = append([]*bs_jvm.Class{iface}, info.markers...)
+	methodTypes := append([]*class_file.MethodDescriptor{info.methodType},
+		info.bridges...)
+	for _, methodType := range methodTypes {
+		conversions, e := getLambdaConversions(t.ParentJVM, info, methodType)
+		if e != nil {
+			return e
+		}
+		AddMethod(lambdaClass, info.name, 1, methodType.ArgumentTypes,
+			methodType.ReturnType,
+			getLambdaMethod(methodType, info.implementation, conversions))
+	}
+	factoryType := info.factoryType
+	factory := &bs_jvm.Method{
+		ContainingClass: lambdaClass,
+		Name:            "get$Lambda",
+		Types:           factoryType,
+		// private static
+		AccessFlags:  0x000a,
+		OptimizeDone: true,
+		Native: func(t *bs_jvm.Thread) error {
+			captured, e := t.PopValues(factoryType.ArgumentTypes)
+			if e != nil {
+				return e
+			}
+			instance, e := lambdaClass.CreateInstance()
+			if e != nil {
+				return e
+			}
+			instance.NativeData = captured
+			return t.Stack.PushRef(instance)
+		},
+	}
+	return t.Stack.PushRef(&bs_jvm.CallSite{Target: factory})
+}
+
+// Implements the static metafactory(MethodHandles$Lookup, String, MethodType,
+// MethodType, MethodHandle, MethodType) bootstrap method, used for lambdas
+// and method references.
+func metafactoryMethod(t *bs_jvm.Thread) error {
+	// The instantiated method type is only needed for checking types, which
+	// is already done using the implementation's type.
+	_, e := popMethodType(t)
+	if e != nil {
+		return e
+	}
+	tmp, e := t.Stack.PopRef()
+	if e != nil {
+		return e
+	}
+	implementation, e := bs_jvm.ResolveMethodHandle(tmp)
+	if e != nil {
+		return e
+	}
+	methodType, e := popMethodType(t)
+	if e != nil {
+		return e
+	}
+	info, e := popLambdaInfo(t)
+	if e != nil {
+		return e
+	}
+	info.methodType = methodType
+	info.implementation = implementation
+	return pushLambdaCallSite(t, info)
+}
+
+// Returns the int at the given index in the args passed to altMetafactory.
+func getAltMetafactoryInt(args []bs_jvm.Object, index int) (int, error) {
+	if index >= len(args) {
+		return 0, bs_jvm.IllegalArgumentError("Missing altMetafactory args")
+	}
+	v, ok := args[index].(bs_jvm.Int)
+	if !ok {
+		return 0, bs_jvm.IllegalArgumentError("Expected an int, got " +
+			args[index].TypeName())
+	}
+	return int(v), nil
+}
+
+// Implements the static altMetafactory(MethodHandles$Lookup, String,
+// MethodType, Object...) bootstrap method, used for lambdas that implement
+// additional interfaces or bridge methods.
+func altMetafactoryMethod(t *bs_jvm.Thread) error {
+	args, e := popObjectArray(t)
+	if e != nil {
+		return e
+	}
+	info, e := popLambdaInfo(t)
+	if e != nil {
+		return e
+	}
+	// The args start with the same args as metafactory, followed by flags.
+	flags, e := getAltMetafactoryInt(args, 3)
+	if e != nil {
+		return e
+	}
+	info.methodType, e = getMethodTypeDescriptor(args[0])
+	if e != nil {
+		return e
+	}
+	info.implementation, e = bs_jvm.ResolveMethodHandle(args[1])
+	if e != nil {
+		return e
+	}
+	index := 4
+	if (flags & lambdaFlagMarkers) != 0 {
+		count, e := getAltMetafactoryInt(args, index)
+		if e != nil {
+			return e
+		}
+		index++
+		for i := 0; i < count; i++ {
+			if (index + i) >= len(args) {
+				return bs_jvm.IllegalArgumentError("Missing marker interfaces")
+			}
+			marker, ok := args[index+i].(*bs_jvm.Class)
+			if !ok {
+				return bs_jvm.TypeError("Expected a marker interface, got " +
+					args[index+i].TypeName())
+			}
+			info.markers = append(info.markers, marker)
+		}
+		index += count
+	}
+	if (flags & lambdaFlagBridges) != 0 {
+		count, e := getAltMetafactoryInt(args, index)
+		if e != nil {
+			return e
+		}
+		index++
+		for i := 0; i < count; i++ {
+			if (index + i) >= len(args) {
+				return bs_jvm.IllegalArgumentError("Missing bridge types")
+			}
+			bridge, e := getMethodTypeDescriptor(args[index+i])
+			if e != nil {
+				return e
+			}
+			info.bridges = append(info.bridges, bridge)
+		}
+	}
+	return pushLambdaCallSite(t, info)
+}
+
+// Converts a value of the given type to a string, like String.valueOf.
+func valueToString(t *bs_jvm.Thread, valueType class_file.FieldType,
+	v bs_jvm.Object) (string, error) {
+	p, isPrimitive := valueType.(class_file.PrimitiveFieldType)
+	if !isPrimitive {
+		return t.ObjectToString(v)
+	}
+	switch p {
+	case 'Z':
+		if v.(bs_jvm.Int) != 0 {
+			return "true", nil
+		}
+		return "false", nil
+	case 'C':
+		return string(rune(uint16(v.(bs_jvm.Int)))), nil
+	case 'B', 'S', 'I':
+		return strconv.Itoa(int(v.(bs_jvm.Int))), nil
+	case 'J':
+		return strconv.FormatInt(int64(v.(bs_jvm.Long)), 10), nil
+	case 'F':
+		return v.(bs_jvm.Float).JavaString(), nil
+	case 'D':
+		return v.(bs_jvm.Double).JavaString(), nil
+	}
+	return "", bs_jvm.TypeError("Invalid primitive type: " + p.String())
+}
+
+// Converts a constant passed to makeConcatWithConstants to a string.
+func concatConstantToString(t *bs_jvm.Thread, v bs_jvm.Object) (string,
+	error) {
+	switch v.(type) {
+	case bs_jvm.Int:
+		return valueToString(t, class_file.PrimitiveFieldType('I'), v)
+	case bs_jvm.Long:
+		return valueToString(t, class_file.PrimitiveFieldType('J'), v)
+	case bs_jvm.Float:
+		return valueToString(t, class_file.PrimitiveFieldType('F'), v)
+	case bs_jvm.Double:
+		return valueToString(t, class_file.PrimitiveFieldType('D'), v)
+	}
+	return t.ObjectToString(v)
+}
+
+// Pushes a call site that concatenates its args according to the recipe. In
+// the recipe, each \1 character is replaced by the next argument, and each \2
+// character is replaced by the next constant.
+func pushConcatCallSite(t *bs_jvm.Thread,
+	concatType *class_file.MethodDescriptor, recipe string,
+	constants []bs_jvm.Object) error {
+	argTypes := concatType.ArgumentTypes
+	if strings.Count(recipe, "\x01") != len(argTypes) {
+		return bs_jvm.IllegalArgumentError(fmt.Sprintf("Concatenation "+
+			"recipe doesn't use all %d args", len(argTypes)))
+	}
+	if strings.Count(recipe, "\x02") > len(constants) {
+		return bs_jvm.IllegalArgumentError("Concatenation recipe uses " +
+			"missing constants")
+	}
+	returnType, ok := concatType.ReturnType.(class_file.ClassInstanceType)
+	if !ok || (returnType != "java/lang/String") {
+		return bs_jvm.TypeError("Concatenation must return a String, not " +
+			concatType.ReturnType.String())
+	}
+	constantStrings := make([]string, len(constants))
+	var e error
+	for i, c := range constants {
+		constantStrings[i], e = concatConstantToString(t, c)
+		if e != nil {
+			return e
+		}
+	}
+	target := &bs_jvm.Method{
+		ContainingClass: stringConcatFactoryClass,
+		Name:            "concat",
+		Types:           concatType,
+		// private static
+		AccessFlags:  0x000a,
+		OptimizeDone: true,
+		Native: func(t *bs_jvm.Thread) error {
+			args, e := t.PopValues(argTypes)
+			if e != nil {
+				return e
+			}
+			var result strings.Builder
+			argIndex := 0
+			constantIndex := 0
+			for i := 0; i < len(recipe); i++ {
+				switch recipe[i] {
+				case 1:
+					s, e := valueToString(t, argTypes[argIndex],
+						args[argIndex])
+					if e != nil {
+						return e
+					}
+					result.WriteString(s)
+					argIndex++
+				case 2:
+					result.WriteString(constantStrings[constantIndex])
+					constantIndex++
+				default:
+					result.WriteByte(recipe[i])
+				}
+			}
+			return t.Stack.PushRef(bs_jvm.NewStringObject(result.String()))
+		},
+	}
+	return t.Stack.PushRef(&bs_jvm.CallSite{Target: target})
+}
+
+// Implements the static makeConcat(MethodHandles$Lookup, String, MethodType)
+// bootstrap method, which concatenates all of its args.
+func makeConcatMethod(t *bs_jvm.Thread) error {
+	concatType, e := popMethodType(t)
+	if e != nil {
+		return e
+	}
+	_, e = popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	_, e = popLookup(t)
+	if e != nil {
+		return e
+	}
+	recipe := strings.Repeat("\x01", len(concatType.ArgumentTypes))
+	return pushConcatCallSite(t, concatType, recipe, nil)
+}
+
+// Implements the static makeConcatWithConstants(MethodHandles$Lookup, String,
+// MethodType, String, Object...) bootstrap method, used for string
+// concatenation by javac 9 and later.
+func makeConcatWithConstantsMethod(t *bs_jvm.Thread) error {
+	constants, e := popObjectArray(t)
+	if e != nil {
+		return e
+	}
+	recipe, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	concatType, e := popMethodType(t)
+	if e != nil {
+		return e
+	}
+	_, e = popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	_, e = popLookup(t)
+	if e != nil {
+		return e
+	}
+	return pushConcatCallSite(t, concatType, recipe, constants)
+}
+
+// Returns the types of the first three args taken by every bootstrap method.
+func getBootstrapArgTypes() []class_file.FieldType {
+	return []class_file.FieldType{
+		class_file.ClassInstanceType("java/lang/invoke/MethodHandles$Lookup"),
+		class_file.ClassInstanceType("java/lang/String"),
+		class_file.ClassInstanceType("java/lang/invoke/MethodType"),
+	}
+}
+
+// Returns a BS-JVM class implementing java/lang/invoke/LambdaMetafactory. If
+// it has already been initialized, returns the existing copy.
+func GetLambdaMetafactoryClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if lambdaMetafactoryClass != nil {
+		return lambdaMetafactoryClass, nil
+	}
+	methodTypeType := class_file.ClassInstanceType(
+		"java/lang/invoke/MethodType")
+	methodHandleType := class_file.ClassInstanceType(
+		"java/lang/invoke/MethodHandle")
+	callSiteType := class_file.ClassInstanceType("java/lang/invoke/CallSite")
+	objectArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.ClassInstanceType("java/lang/Object"),
+	}
+	toReturn := GetEmptyClass(jvm, "java/lang/invoke/LambdaMetafactory")
+	// public final
+	toReturn.AccessFlags = 0x0011
+	// public static
+	AddMethod(toReturn, "metafactory", 0x0009,
+		append(getBootstrapArgTypes(), methodTypeType, methodHandleType,
+			methodTypeType), callSiteType, metafactoryMethod)
+	// public static varargs
+	AddMethod(toReturn, "altMetafactory", 0x0089,
+		append(getBootstrapArgTypes(), objectArrayType), callSiteType,
+		altMetafactoryMethod)
+	lambdaMetafactoryClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/invoke/StringConcatFactory.
+// If it has already been initialized, returns the existing copy.
+func GetStringConcatFactoryClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if stringConcatFactoryClass != nil {
+		return stringConcatFactoryClass, nil
+	}
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	callSiteType := class_file.ClassInstanceType("java/lang/invoke/CallSite")
+	objectArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.ClassInstanceType("java/lang/Object"),
+	}
+	toReturn := GetEmptyClass(jvm, "java/lang/invoke/StringConcatFactory")
+	// public final
+	toReturn.AccessFlags = 0x0011
+	// public static
+	AddMethod(toReturn, "makeConcat", 0x0009, getBootstrapArgTypes(),
+		callSiteType, makeConcatMethod)
+	// public static varargs
+	AddMethod(toReturn, "makeConcatWithConstants", 0x0089,
+		append(getBootstrapArgTypes(), stringType, objectArrayType),
+		callSiteType, makeConcatWithConstantsMethod)
+	stringConcatFactoryClass = toReturn
+	return toReturn, nil
+}
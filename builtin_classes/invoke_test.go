@@ -0,0 +1,166 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"strings"
+	"testing"
+)
+
+// Returns a ClassBuilder for an interface with a single abstract method.
+func newTestInterfaceBuilder(name, methodName,
+	descriptor string) *builder.ClassBuilder {
+	toReturn := builder.NewClassBuilder(name, "java/lang/Object")
+	// public interface abstract
+	toReturn.Access = 0x0601
+	// public abstract
+	toReturn.AddMethod(0x0401, methodName, descriptor)
+	return toReturn
+}
+
+// Emits an invokedynamic instruction that creates a lambda implementing the
+// interface's method, which has the given name and type, by calling the
+// given static method.
+func emitLambda(b *builder.ClassBuilder, m *builder.MethodBuilder, iface,
+	name, methodType, implClass, implName, implType string) {
+	metafactory := b.Pool.MethodHandle(6, b.Pool.Method(
+		"java/lang/invoke/LambdaMetafactory", "metafactory",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;"+
+			"Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;"+
+			"Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)"+
+			"Ljava/lang/invoke/CallSite;"))
+	implementation := b.Pool.MethodHandle(6, b.Pool.Method(implClass,
+		implName, implType))
+	index := b.AddBootstrapMethod(metafactory, b.Pool.MethodType(methodType),
+		implementation, b.Pool.MethodType(methodType))
+	m.EmitInvokeDynamic(index, name, "()L"+iface+";")
+}
+
+func TestLambdaConversions(t *testing.T) {
+	objectFunction := "(Ljava/lang/Object;)Ljava/lang/Object;"
+	loadTestClass(t, newTestInterfaceBuilder("LambdaFunction", "apply",
+		objectFunction))
+	loadTestClass(t, newTestInterfaceBuilder("LambdaIntToLong", "apply",
+		"(I)J"))
+	loadTestClass(t, newTestInterfaceBuilder("LambdaIntToObject", "apply",
+		"(I)Ljava/lang/Object;"))
+	b := newTestClassBuilder("Lambdas")
+	m := b.AddMethod(0x0009, "abs", "(I)I")
+	done := m.NewLabel()
+	m.EmitLocal(builder.Iload, 0)
+	m.Emit(builder.Dup)
+	m.EmitBranch(builder.Ifge, done)
+	m.Emit(builder.Ineg)
+	m.PlaceLabel(done)
+	m.Emit(builder.Ireturn)
+	m = b.AddMethod(0x0009, "negate", "(J)J")
+	m.EmitLocal(builder.Lload, 0)
+	m.Emit(builder.Lneg)
+	m.Emit(builder.Lreturn)
+	m = b.AddMethod(0x0009, "square", "(S)I")
+	m.EmitLocal(builder.Iload, 0)
+	m.EmitLocal(builder.Iload, 0)
+	m.Emit(builder.Imul)
+	m.Emit(builder.Ireturn)
+	m = b.AddMethod(0x0009, "describe", "(Ljava/lang/Number;)"+
+		"Ljava/lang/String;")
+	m.EmitLocal(builder.Aload, 0)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/String", "valueOf",
+		"(Ljava/lang/Object;)Ljava/lang/String;")
+	m.Emit(builder.Areturn)
+
+	m = b.AddMethod(0x0009, "run", "()V")
+	// Function<Integer, Integer> f = Lambdas::abs, which requires unboxing
+	// the arg and boxing the result.
+	emitLambda(b, m, "LambdaFunction", "apply", objectFunction, "Lambdas",
+		"abs", "(I)I")
+	m.EmitInt(-5)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/Integer", "valueOf",
+		"(I)Ljava/lang/Integer;")
+	m.EmitInvoke(builder.Invokeinterface, "LambdaFunction", "apply",
+		objectFunction)
+	emitReport(m, "Lambdas", "Ljava/lang/Object;")
+	// Function<String, Integer> f = Integer::parseInt
+	emitLambda(b, m, "LambdaFunction", "apply", objectFunction,
+		"java/lang/Integer", "parseInt", "(Ljava/lang/String;)I")
+	m.EmitString("123")
+	m.EmitInvoke(builder.Invokeinterface, "LambdaFunction", "apply",
+		objectFunction)
+	emitReport(m, "Lambdas", "Ljava/lang/Object;")
+	// Unboxed Shorts may be widened to ints.
+	emitLambda(b, m, "LambdaFunction", "apply", objectFunction, "Lambdas",
+		"abs", "(I)I")
+	m.EmitInt(-7)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/Short", "valueOf",
+		"(S)Ljava/lang/Short;")
+	m.EmitInvoke(builder.Invokeinterface, "LambdaFunction", "apply",
+		objectFunction)
+	emitReport(m, "Lambdas", "Ljava/lang/Object;")
+	// The int arg is widened to a long.
+	emitLambda(b, m, "LambdaIntToLong", "apply", "(I)J", "Lambdas", "negate",
+		"(J)J")
+	m.EmitInt(-100)
+	m.EmitInvoke(builder.Invokeinterface, "LambdaIntToLong", "apply", "(I)J")
+	emitReport(m, "Lambdas", "J")
+	// The int result is widened to a long.
+	emitLambda(b, m, "LambdaIntToLong", "apply", "(I)J", "Lambdas", "abs",
+		"(I)I")
+	m.EmitInt(-3)
+	m.EmitInvoke(builder.Invokeinterface, "LambdaIntToLong", "apply", "(I)J")
+	emitReport(m, "Lambdas", "J")
+	// The int arg is boxed, and passed as a Number.
+	emitLambda(b, m, "LambdaIntToObject", "apply", "(I)Ljava/lang/Object;",
+		"Lambdas", "describe", "(Ljava/lang/Number;)Ljava/lang/String;")
+	m.EmitInt(9)
+	m.EmitInvoke(builder.Invokeinterface, "LambdaIntToObject", "apply",
+		"(I)Ljava/lang/Object;")
+	emitReport(m, "Lambdas", "Ljava/lang/Object;")
+	m.Emit(builder.Return)
+	results, e := runTestClass(t, b)
+	if e != nil {
+		t.Logf("Failed running lambdas: %s\n", e)
+		t.FailNow()
+	}
+	checkReported(t, results, []string{"5", "123", "7", "100", "3", "9"})
+
+	// An Integer can't be unboxed and narrowed to a short.
+	b = newTestClassBuilder("BadLambdaUnbox")
+	m = b.AddMethod(0x0009, "run", "()V")
+	emitLambda(b, m, "LambdaFunction", "apply", objectFunction, "Lambdas",
+		"square", "(S)I")
+	m.EmitInt(2)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/Integer", "valueOf",
+		"(I)Ljava/lang/Integer;")
+	m.EmitInvoke(builder.Invokeinterface, "LambdaFunction", "apply",
+		objectFunction)
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	_, e = runTestClass(t, b)
+	checkThrown(t, e, "java/lang/ClassCastException",
+		"java.lang.Integer can't be unboxed to short")
+
+	b = newTestClassBuilder("NullLambdaUnbox")
+	m = b.AddMethod(0x0009, "run", "()V")
+	emitLambda(b, m, "LambdaFunction", "apply", objectFunction, "Lambdas",
+		"abs", "(I)I")
+	m.Emit(builder.Aconst_null)
+	m.EmitInvoke(builder.Invokeinterface, "LambdaFunction", "apply",
+		objectFunction)
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	_, e = runTestClass(t, b)
+	checkThrown(t, e, "java/lang/NullPointerException",
+		"Can't unbox a null reference to int")
+
+	// Narrowing primitive conversions aren't allowed when linking lambdas.
+	b = newTestClassBuilder("BadLambdaNarrowing")
+	m = b.AddMethod(0x0009, "run", "()V")
+	emitLambda(b, m, "LambdaIntToLong", "apply", "(J)J", "Lambdas", "abs",
+		"(I)I")
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	_, e = runTestClass(t, b)
+	if (e == nil) || !strings.Contains(e.Error(), "Can't convert long to int") {
+		t.Logf("Expected an error linking a narrowing lambda, got %v\n", e)
+		t.Fail()
+	}
+}
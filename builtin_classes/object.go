@@ -0,0 +1,266 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.Object.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+	"time"
+)
+
+// An initialized version of the builtin Object class.
+var objectClass *bs_jvm.Class
+
+// An initialized version of the builtin Cloneable interface.
+var cloneableClass *bs_jvm.Class
+
+// An initialized version of the builtin Serializable interface.
+var serializableClass *bs_jvm.Class
+
+// Implements the Object() constructor, which doesn't need to do anything.
+func objectConstructor(t *bs_jvm.Thread) error {
+	_, e := t.Stack.PopRef()
+	return e
+}
+
+// Pops the object that a method of java/lang/Object was invoked on, and
+// returns its monitor.
+func popObjectMonitor(t *bs_jvm.Thread) (*bs_jvm.Monitor, error) {
+	o, e := t.Stack.PopRef()
+	if e != nil {
+		return nil, fmt.Errorf("Failed popping object: %w", e)
+	}
+	return bs_jvm.GetMonitor(o)
+}
+
+// Converts a timeout in milliseconds, which must not be negative, to a
+// Duration. Timeouts too long to represent, such as Long.MAX_VALUE, are
+// clamped to the longest possible Duration, which is over 290 years.
+func millisToDuration(millis bs_jvm.Long) time.Duration {
+	if millis > bs_jvm.Long(math.MaxInt64/int64(time.Millisecond)) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(millis) * time.Millisecond
+}
+
+// Waits on the monitor of the object on top of the stack, with the given
+// timeout in milliseconds. A timeout of 0 waits indefinitely.
+func waitWithTimeout(t *bs_jvm.Thread, timeout bs_jvm.Long) error {
+	monitor, e := popObjectMonitor(t)
+	if e != nil {
+		return e
+	}
+	if timeout < 0 {
+		return bs_jvm.IllegalArgumentError("timeout value is negative")
+	}
+	return monitor.Wait(t, millisToDuration(timeout))
+}
+
+// Implements the wait() method.
+func waitMethod(t *bs_jvm.Thread) error {
+	return waitWithTimeout(t, 0)
+}
+
+// Implements the wait(long) method.
+func waitTimeoutMethod(t *bs_jvm.Thread) error {
+	timeout, e := t.Stack.PopLong()
+	if e != nil {
+		return e
+	}
+	return waitWithTimeout(t, timeout)
+}
+
+// Implements the wait(long, int) method. Like Java, this rounds up to the
+// nearest millisecond if any nanoseconds are given.
+func waitTimeoutNanosMethod(t *bs_jvm.Thread) error {
+	nanos, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	timeout, e := t.Stack.PopLong()
+	if e != nil {
+		return e
+	}
+	if (nanos < 0) || (nanos > 999999) {
+		return bs_jvm.IllegalArgumentError("nanosecond timeout value out " +
+			"of range")
+	}
+	if (nanos > 0) && (timeout < math.MaxInt64) {
+		timeout++
+	}
+	return waitWithTimeout(t, timeout)
+}
+
+// Implements the notify() method.
+func notifyMethod(t *bs_jvm.Thread) error {
+	monitor, e := popObjectMonitor(t)
+	if e != nil {
+		return e
+	}
+	return monitor.Notify(t)
+}
+
+// Implements the notifyAll() method.
+func notifyAllMethod(t *bs_jvm.Thread) error {
+	monitor, e := popObjectMonitor(t)
+	if e != nil {
+		return e
+	}
+	return monitor.NotifyAll(t)
+}
+
+// Implements the hashCode() method, which returns the object's identity hash
+// code.
+func objectHashCodeMethod(t *bs_jvm.Thread) error {
+	o, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(bs_jvm.IdentityHashCode(o))
+}
+
+// Implements the equals(Object) method, which compares identities.
+func objectEqualsMethod(t *bs_jvm.Thread) error {
+	other, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	o, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	if o == other {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Implements the toString() method. Like Java, this combines the name of the
+// object's class with its hash code, as returned by hashCode().
+func objectToStringMethod(t *bs_jvm.Thread) error {
+	o, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	hash, e := t.ObjectHashCode(o)
+	if e != nil {
+		return e
+	}
+	s := fmt.Sprintf("%s@%x", bs_jvm.JavaClassName(o), uint32(hash))
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the getClass() method.
+func getClassMethod(t *bs_jvm.Thread) error {
+	o, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	c, e := t.ParentJVM.ClassOf(o)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(c)
+}
+
+// Implements the clone() method. Arrays can always be cloned, but other
+// objects must implement Cloneable. The copy is shallow, as in Java.
+func cloneMethod(t *bs_jvm.Thread) error {
+	o, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	if array, ok := o.(bs_jvm.Array); ok {
+		toReturn, e := bs_jvm.CopyArray(array)
+		if e != nil {
+			return e
+		}
+		return t.Stack.PushRef(toReturn)
+	}
+	instance, ok := o.(*bs_jvm.ClassInstance)
+	if !ok || !instance.C.IsSubclassOf(cloneableClass) {
+		return t.Throw("java/lang/CloneNotSupportedException",
+			bs_jvm.JavaClassName(o))
+	}
+	fieldValues := make([]bs_jvm.Object, len(instance.FieldValues))
+	copy(fieldValues, instance.FieldValues)
+	return t.Stack.PushRef(&bs_jvm.ClassInstance{
+		C:           instance.C,
+		FieldValues: fieldValues,
+		NativeData:  instance.NativeData,
+	})
+}
+
+// Returns the builtin Object class, creating it if necessary. Unlike the
+// other builtin classes, this can't fail, because GetEmptyClass needs it.
+func getObjectClass(jvm *bs_jvm.JVM) *bs_jvm.Class {
+	if objectClass != nil {
+		return objectClass
+	}
+	voidType := class_file.PrimitiveFieldType('V')
+	longType := class_file.PrimitiveFieldType('J')
+	intType := class_file.PrimitiveFieldType('I')
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/Object")
+	AddConstructor(toReturn, 1, noArgs, objectConstructor)
+	// public native
+	AddMethod(toReturn, "hashCode", 0x0101, noArgs, intType,
+		objectHashCodeMethod)
+	AddMethod(toReturn, "equals", 1, []class_file.FieldType{objectType},
+		class_file.PrimitiveFieldType('Z'), objectEqualsMethod)
+	AddMethod(toReturn, "toString", 1, noArgs,
+		class_file.ClassInstanceType("java/lang/String"), objectToStringMethod)
+	// protected native
+	AddMethod(toReturn, "clone", 0x0104, noArgs, objectType, cloneMethod)
+	// public final
+	access := class_file.MethodAccessFlags(0x0011)
+	AddMethod(toReturn, "wait", access, noArgs, voidType, waitMethod)
+	AddMethod(toReturn, "wait", access, []class_file.FieldType{longType},
+		voidType, waitTimeoutMethod)
+	AddMethod(toReturn, "wait", access, []class_file.FieldType{longType,
+		intType}, voidType, waitTimeoutNanosMethod)
+	AddMethod(toReturn, "notify", access, noArgs, voidType, notifyMethod)
+	AddMethod(toReturn, "notifyAll", access, noArgs, voidType,
+		notifyAllMethod)
+	// public final native
+	AddMethod(toReturn, "getClass", 0x0111, noArgs,
+		class_file.ClassInstanceType("java/lang/Class"), getClassMethod)
+	objectClass = toReturn
+	return toReturn
+}
+
+// Returns a BS-JVM class implementing java/lang/Object. If it has already been
+// initialized, returns the existing copy.
+func GetObjectClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	return getObjectClass(jvm), nil
+}
+
+// Returns a BS-JVM class implementing the java/lang/Cloneable interface, which
+// has no methods. If it has already been initialized, returns the existing
+// copy.
+func GetCloneableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if cloneableClass != nil {
+		return cloneableClass, nil
+	}
+	toReturn := GetEmptyClass(jvm, "java/lang/Cloneable")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	cloneableClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing the java/io/Serializable interface,
+// which has no methods. If it has already been initialized, returns the
+// existing copy.
+func GetSerializableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if serializableClass != nil {
+		return serializableClass, nil
+	}
+	toReturn := GetEmptyClass(jvm, "java/io/Serializable")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	serializableClass = toReturn
+	return toReturn, nil
+}
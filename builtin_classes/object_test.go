@@ -0,0 +1,115 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArrayClasses(t *testing.T) {
+	jvm := getTestJVM(t)
+	intArray := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.PrimitiveFieldType('I'),
+	}
+	c, e := jvm.GetArrayClass(intArray)
+	if e != nil {
+		t.Logf("Failed getting the int[] class: %s\n", e)
+		t.FailNow()
+	}
+	if (string(c.Name) != "[I") || !c.IsArray() || c.IsInterface() {
+		t.Logf("Got incorrect int[] class: %s\n", c)
+		t.Fail()
+	}
+	if c.Super != objectClass {
+		t.Logf("The int[] class doesn't extend Object: %s\n", c.Super)
+		t.Fail()
+	}
+	if !c.IsSubclassOf(cloneableClass) ||
+		!c.IsSubclassOf(serializableClass) {
+		t.Logf("The int[] class doesn't implement Cloneable and " +
+			"Serializable\n")
+		t.Fail()
+	}
+
+	className := "ArrayClassTest"
+	b := newTestClassBuilder(className)
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitInt(3)
+	// T_INT
+	m.Emit(builder.Newarray, 10)
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Object", "toString",
+		"()Ljava/lang/String;")
+	emitReport(m, className, "Ljava/lang/String;")
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Object", "getClass",
+		"()Ljava/lang/Class;")
+	m.Emit(builder.Dup)
+	emitReport(m, className, "Ljava/lang/Class;")
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Class", "getName",
+		"()Ljava/lang/String;")
+	emitReport(m, className, "Ljava/lang/String;")
+	// Every int[] array must have the same class.
+	m.EmitInt(1)
+	m.Emit(builder.Newarray, 10)
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Object", "getClass",
+		"()Ljava/lang/Class;")
+	different := m.NewLabel()
+	m.EmitBranch(builder.If_acmpne, different)
+	m.EmitString("same")
+	emitReport(m, className, "Ljava/lang/String;")
+	m.PlaceLabel(different)
+	m.EmitInt(2)
+	m.EmitType(builder.Anewarray, "java/lang/String")
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Object", "getClass",
+		"()Ljava/lang/Class;")
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Class", "getName",
+		"()Ljava/lang/String;")
+	emitReport(m, className, "Ljava/lang/String;")
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Class", "getSimpleName",
+		"()Ljava/lang/String;")
+	emitReport(m, className, "Ljava/lang/String;")
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/Class", "getSuperclass",
+		"()Ljava/lang/Class;")
+	emitReport(m, className, "Ljava/lang/Class;")
+	m.Emit(builder.Return)
+	results, e := runTestClass(t, b)
+	if e != nil {
+		t.Logf("%s failed: %s\n", className, e)
+		t.FailNow()
+	}
+	if (len(results) == 0) || !strings.HasPrefix(results[0], "[I@") {
+		t.Logf("Got incorrect string for an int[] array: %q\n", results)
+		t.FailNow()
+	}
+	checkReported(t, results[1:], []string{"class [I", "[I", "same",
+		"[Ljava.lang.String;", "String[]", "class java.lang.Object"})
+}
+
+func TestMillisToDuration(t *testing.T) {
+	tests := []struct {
+		millis   bs_jvm.Long
+		expected time.Duration
+	}{
+		{0, 0},
+		{1500, 1500 * time.Millisecond},
+		{math.MaxInt64 / 1000000, (math.MaxInt64 / 1000000) * 1000000},
+		{math.MaxInt64/1000000 + 1, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64},
+	}
+	for _, test := range tests {
+		d := millisToDuration(test.millis)
+		if d != test.expected {
+			t.Logf("Expected %d ms to be %s, got %s\n", test.millis,
+				test.expected, d)
+			t.Fail()
+		}
+	}
+}
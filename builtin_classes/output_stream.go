@@ -6,6 +6,8 @@ import (
 	"github.com/yalue/bs_jvm"
 	"github.com/yalue/bs_jvm/class_file"
 	"io"
+	"sync"
+	"unicode/utf16"
 )
 
 // An initialized version of our builtin PrintStream class.
@@ -15,8 +17,35 @@ var printStreamClass *bs_jvm.Class
 type internalPrintStream struct {
 	// The io.Writer to which we are writing data.
 	w io.Writer
-	// Holds an error if one occurred.
+	// Holds an error if one occurred. Like Java, once an error occurs, it
+	// remains set.
 	lastError error
+	// Prevents output from concurrent threads from being interleaved.
+	lock sync.Mutex
+}
+
+// Creates a new PrintStream instance that writes to the given io.Writer.
+func newPrintStream(c *bs_jvm.Class, w io.Writer) *bs_jvm.ClassInstance {
+	// For an instance of the builtin PrintStream class, we don't need to use
+	// any fields; we'll instead just write directly to w.
+	return &bs_jvm.ClassInstance{
+		C: c,
+		NativeData: &internalPrintStream{
+			w: w,
+		},
+	}
+}
+
+// Writes the string to the underlying io.Writer. Like Java's PrintStream,
+// this doesn't return errors, but records them to be returned by
+// checkError().
+func (p *internalPrintStream) write(s string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, e := io.WriteString(p.w, s)
+	if e != nil {
+		p.lastError = e
+	}
 }
 
 // Pops an instance of the PrintStream class from the thread's stack. Returns
@@ -42,57 +71,174 @@ func popPrintStreamInstance(t *bs_jvm.Thread) (*bs_jvm.ClassInstance, error) {
 	return instance, nil
 }
 
-// Implements the "print" method for a single char.
-func printCharMethod(t *bs_jvm.Thread) error {
+// Pops a PrintStream instance, returning its internal data.
+func popInternalPrintStream(t *bs_jvm.Thread) (*internalPrintStream, error) {
 	instance, e := popPrintStreamInstance(t)
 	if e != nil {
-		return fmt.Errorf("print(char) failed: %w", e)
+		return nil, e
+	}
+	return instance.NativeData.(*internalPrintStream), nil
+}
+
+// Returns a native method implementing print or println for a single argument
+// of the given type. The argument is converted to a string in the same way as
+// String.valueOf.
+func getPrintMethod(argType class_file.FieldType,
+	newline bool) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{argType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		p, e := popInternalPrintStream(t)
+		if e != nil {
+			return e
+		}
+		chars, e := valueToUTF16(t, argType, args[0])
+		if e != nil {
+			return e
+		}
+		s := string(utf16.Decode(chars))
+		if newline {
+			s += "\n"
+		}
+		p.write(s)
+		return nil
+	}
+}
+
+// Implements the println() method taking no arguments.
+func printlnMethod(t *bs_jvm.Thread) error {
+	p, e := popInternalPrintStream(t)
+	if e != nil {
+		return e
+	}
+	p.write("\n")
+	return nil
+}
+
+// Implements the write(int) method, which writes the low byte of its arg.
+func printStreamWriteMethod(t *bs_jvm.Thread) error {
+	b, e := t.Stack.Pop()
+	if e != nil {
+		return e
 	}
-	toPrint, e := t.Stack.Pop()
+	p, e := popInternalPrintStream(t)
 	if e != nil {
-		return fmt.Errorf("print(char) failed popping char to print: %w", e)
+		return e
 	}
-	p := instance.NativeData.(*internalPrintStream)
-	_, p.lastError = fmt.Fprintf(p.w, "%c", toPrint)
-	// The printStream class shouldn't raise any exceptions, but just set the
-	// internal flag if necessary.
+	p.write(string([]byte{byte(b)}))
 	return nil
 }
 
-// Implements the "println" method for a single string.
-func printlnStringMethod(t *bs_jvm.Thread) error {
+// Implements the printf and format methods. Both return the PrintStream.
+func printfMethod(t *bs_jvm.Thread) error {
+	format, args, e := popFormatArgs(t)
+	if e != nil {
+		return e
+	}
 	instance, e := popPrintStreamInstance(t)
 	if e != nil {
-		return fmt.Errorf("println(String) failed: %w", e)
+		return e
 	}
-	tmp, e := t.Stack.PopRef()
+	s, e := t.FormatString(format, args)
 	if e != nil {
-		return fmt.Errorf("println(String) failed popping String: %w", e)
+		return e
 	}
-	toPrint, ok := tmp.(*bs_jvm.StringObject)
+	instance.NativeData.(*internalPrintStream).write(s)
+	return t.Stack.PushRef(instance)
+}
+
+// Flushes the PrintStream's underlying writer if it supports flushing.
+func (p *internalPrintStream) flush() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	flusher, ok := p.w.(interface{ Flush() error })
 	if !ok {
-		return bs_jvm.TypeError("Didn't get String instance")
+		return
 	}
-	p := instance.NativeData.(*internalPrintStream)
-	_, p.lastError = fmt.Fprintf(p.w, "%s\n", toPrint.Value())
+	e := flusher.Flush()
+	if e != nil {
+		p.lastError = e
+	}
+}
+
+// Implements the flush() method.
+func printStreamFlushMethod(t *bs_jvm.Thread) error {
+	p, e := popInternalPrintStream(t)
+	if e != nil {
+		return e
+	}
+	p.flush()
 	return nil
 }
 
+// Implements the checkError() method, which flushes the stream and returns
+// true if any error has occurred.
+func checkErrorMethod(t *bs_jvm.Thread) error {
+	p, e := popInternalPrintStream(t)
+	if e != nil {
+		return e
+	}
+	p.flush()
+	p.lock.Lock()
+	hasError := p.lastError != nil
+	p.lock.Unlock()
+	return pushBoolean(t, hasError)
+}
+
 // Returns a BS-JVM class implementing java/io/PrintStream. If a class has
 // already been initialized, returns the existing copy.
 func GetPrintStreamClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
 	if printStreamClass != nil {
 		return printStreamClass, nil
 	}
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	printStreamType := class_file.ClassInstanceType("java/io/PrintStream")
+	printArgTypes := []class_file.FieldType{
+		class_file.PrimitiveFieldType('Z'),
+		class_file.PrimitiveFieldType('C'),
+		class_file.PrimitiveFieldType('I'),
+		class_file.PrimitiveFieldType('J'),
+		class_file.PrimitiveFieldType('F'),
+		class_file.PrimitiveFieldType('D'),
+		&class_file.ArrayType{
+			ContentType: class_file.PrimitiveFieldType('C'),
+			Dimensions:  1,
+		},
+		stringType,
+		objectType,
+	}
+	formatArgs := []class_file.FieldType{
+		stringType,
+		&class_file.ArrayType{
+			ContentType: objectType,
+			Dimensions:  1,
+		},
+	}
 
 	toReturn := GetEmptyClass(jvm, "java/io/PrintStream")
-	AddSingleArgVoidMethod(toReturn, "print",
-		class_file.PrimitiveFieldType('C'), printCharMethod)
-	AddSingleArgVoidMethod(toReturn, "println",
-		class_file.ClassInstanceType("java/lang/String"), printlnStringMethod)
-
-	// TODO: Continue implementing the PrintStream builtin class
-	//  - checkError, clearError, print, printf, println, etc.
+	for _, argType := range printArgTypes {
+		AddSingleArgVoidMethod(toReturn, "print", argType,
+			getPrintMethod(argType, false))
+		AddSingleArgVoidMethod(toReturn, "println", argType,
+			getPrintMethod(argType, true))
+	}
+	AddMethod(toReturn, "println", 1, []class_file.FieldType{},
+		class_file.PrimitiveFieldType('V'), printlnMethod)
+	AddSingleArgVoidMethod(toReturn, "write",
+		class_file.PrimitiveFieldType('I'), printStreamWriteMethod)
+	// public varargs
+	AddMethod(toReturn, "printf", 0x0081, formatArgs, printStreamType,
+		printfMethod)
+	AddMethod(toReturn, "format", 0x0081, formatArgs, printStreamType,
+		printfMethod)
+	AddMethod(toReturn, "flush", 1, []class_file.FieldType{},
+		class_file.PrimitiveFieldType('V'), printStreamFlushMethod)
+	AddMethod(toReturn, "checkError", 1, []class_file.FieldType{},
+		class_file.PrimitiveFieldType('Z'), checkErrorMethod)
 	printStreamClass = toReturn
 	return toReturn, nil
 }
@@ -0,0 +1,144 @@
+package builtin_classes
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"math"
+	"testing"
+)
+
+// An io.Writer that fails on one of its writes, and records the data from
+// the others.
+type failingWriter struct {
+	// The index of the write that fails, starting from 0.
+	failOn int
+	writes int
+	data   bytes.Buffer
+}
+
+func (w *failingWriter) Write(data []byte) (int, error) {
+	w.writes++
+	if w.writes-1 == w.failOn {
+		return 0, fmt.Errorf("Write %d failed", w.failOn)
+	}
+	return w.data.Write(data)
+}
+
+// Returns a test class builder with a native static stream() method returning
+// a PrintStream.
+func newPrintStreamTestBuilder(name string) *builder.ClassBuilder {
+	toReturn := newTestClassBuilder(name)
+	toReturn.AddMethod(0x0109, "stream", "()Ljava/io/PrintStream;")
+	return toReturn
+}
+
+// Loads and runs the class built by b, with its stream() method returning a
+// PrintStream that writes to w.
+func runPrintStreamTest(t *testing.T, b *builder.ClassBuilder,
+	w *failingWriter) ([]string, error) {
+	c := loadTestClass(t, b)
+	stream := newPrintStream(printStreamClass, w)
+	getNamedMethod(t, c, "stream").Native = func(
+		thread *bs_jvm.Thread) error {
+		return thread.Stack.PushRef(stream)
+	}
+	return runLoadedTestClass(t, c)
+}
+
+func TestPrintStreamPrint(t *testing.T) {
+	className := "PrintStreamPrintTest"
+	b := newPrintStreamTestBuilder(className)
+	m := b.AddMethod(0x0009, "run", "()V")
+	// Emits code calling the given method of the PrintStream, after using
+	// emitArg to push its argument.
+	print := func(name, descriptor string, emitArg func()) {
+		m.EmitInvoke(builder.Invokestatic, className, "stream",
+			"()Ljava/io/PrintStream;")
+		emitArg()
+		m.EmitInvoke(builder.Invokevirtual, "java/io/PrintStream", name,
+			"("+descriptor+")V")
+	}
+	print("print", "Z", func() { m.EmitInt(1) })
+	print("println", "C", func() { m.EmitInt('x') })
+	print("print", "I", func() { m.EmitInt(-5) })
+	print("println", "J", func() { m.EmitLong(1 << 40) })
+	print("print", "[C", func() { emitCharArray(m, "hi") })
+	print("println", "Ljava/lang/String;", func() {
+		m.Emit(builder.Aconst_null)
+	})
+	print("println", "Ljava/lang/Object;", func() {
+		m.EmitString("\U0001F600")
+	})
+	print("println", "", func() {})
+	// Float.toString and Double.toString switch to scientific notation
+	// outside of [0.001, 10^7), and use the fewest digits needed to
+	// distinguish the value from its neighbors.
+	floats := []float32{1.0e-5, 0.1, 16777216, 100, float32(math.Inf(-1)),
+		math.SmallestNonzeroFloat32}
+	for _, v := range floats {
+		v := v
+		print("println", "F", func() { m.EmitFloat(v) })
+	}
+	// Adding these constants in Go would produce exactly 0.3.
+	x, y := 0.1, 0.2
+	doubles := []float64{0.001, 1.0e-4, 1.0e7, 9999999, 123456789, x + y,
+		math.SmallestNonzeroFloat64, math.Copysign(0, -1), math.NaN()}
+	for _, v := range doubles {
+		v := v
+		print("println", "D", func() { m.EmitDouble(v) })
+	}
+	// Only the low byte of the int is written.
+	print("write", "I", func() { m.EmitInt(0x141) })
+	m.Emit(builder.Return)
+	w := &failingWriter{
+		failOn: -1,
+	}
+	_, e := runPrintStreamTest(t, b, w)
+	if e != nil {
+		t.Logf("%s failed: %s\n", className, e)
+		t.FailNow()
+	}
+	expected := "truex\n-51099511627776\nhinull\n\U0001F600\n\n" +
+		"1.0E-5\n0.1\n1.6777216E7\n100.0\n-Infinity\n1.4E-45\n" +
+		"0.001\n1.0E-4\n1.0E7\n9999999.0\n1.23456789E8\n" +
+		"0.30000000000000004\n4.9E-324\n-0.0\nNaN\nA"
+	if w.data.String() != expected {
+		t.Logf("Expected output %q, got %q\n", expected, w.data.String())
+		t.Fail()
+	}
+}
+
+func TestPrintStreamCheckError(t *testing.T) {
+	className := "PrintStreamCheckErrorTest"
+	b := newPrintStreamTestBuilder(className)
+	m := b.AddMethod(0x0009, "run", "()V")
+	// Only the second string fails to be written, but checkError() must
+	// keep returning true after it.
+	for _, s := range []string{"a", "b", "c"} {
+		m.EmitInvoke(builder.Invokestatic, className, "stream",
+			"()Ljava/io/PrintStream;")
+		m.Emit(builder.Dup)
+		m.EmitString(s)
+		m.EmitInvoke(builder.Invokevirtual, "java/io/PrintStream", "print",
+			"(Ljava/lang/String;)V")
+		m.EmitInvoke(builder.Invokevirtual, "java/io/PrintStream",
+			"checkError", "()Z")
+		emitReport(m, className, "Z")
+	}
+	m.Emit(builder.Return)
+	w := &failingWriter{
+		failOn: 1,
+	}
+	results, e := runPrintStreamTest(t, b, w)
+	if e != nil {
+		t.Logf("%s failed: %s\n", className, e)
+		t.FailNow()
+	}
+	checkReported(t, results, []string{"false", "true", "true"})
+	if w.data.String() != "ac" {
+		t.Logf("Expected output \"ac\", got %q\n", w.data.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,1281 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.String, along with the
+// CharSequence and Comparable interfaces it implements. Instances of String
+// are *bs_jvm.StringObjects rather than ClassInstances.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Initialized versions of the builtin String, CharSequence, and Comparable
+// classes.
+var stringClass *bs_jvm.Class
+var charSequenceClass *bs_jvm.Class
+var comparableClass *bs_jvm.Class
+
+// Pops a String reference that must not be null.
+func popStringObject(t *bs_jvm.Thread) (*bs_jvm.StringObject, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	s, ok := tmp.(*bs_jvm.StringObject)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a String, got " +
+			tmp.TypeName())
+	}
+	return s, nil
+}
+
+// Pops a CharSequence reference that must not be null, and returns its
+// contents as UTF-16 code units. Calls toString() if the CharSequence isn't a
+// String.
+func popCharSequence(t *bs_jvm.Thread) ([]uint16, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	s, ok := tmp.(*bs_jvm.StringObject)
+	if ok {
+		return s.UTF16(), nil
+	}
+	value, e := t.ObjectToString(tmp)
+	if e != nil {
+		return nil, e
+	}
+	return utf16.Encode([]rune(value)), nil
+}
+
+// Pops a char[] array that must not be null, and returns its elements.
+func popCharArray(t *bs_jvm.Thread) ([]bs_jvm.Char, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, e
+	}
+	array, ok := tmp.(*bs_jvm.CharArray)
+	if !ok {
+		return nil, bs_jvm.TypeError("Expected a char array, got " +
+			tmp.TypeName())
+	}
+	return array.Elements, nil
+}
+
+// Converts a slice of chars to UTF-16 code units, copying them.
+func charsToUTF16(chars []bs_jvm.Char) []uint16 {
+	toReturn := make([]uint16, len(chars))
+	for i, c := range chars {
+		toReturn[i] = uint16(c)
+	}
+	return toReturn
+}
+
+// Pushes a new String containing the given UTF-16 code units.
+func pushUTF16(t *bs_jvm.Thread, chars []uint16) error {
+	return t.Stack.PushRef(bs_jvm.NewStringObjectFromUTF16(chars))
+}
+
+// Pushes a boolean result.
+func pushBoolean(t *bs_jvm.Thread, v bool) error {
+	if v {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Throws a StringIndexOutOfBoundsException with the given message.
+func throwStringIndexError(t *bs_jvm.Thread, format string,
+	args ...interface{}) error {
+	return t.Throw("java/lang/StringIndexOutOfBoundsException",
+		fmt.Sprintf(format, args...))
+}
+
+// Converts a Unicode code point to one or two UTF-16 code units.
+func codePointToUTF16(c bs_jvm.Int) []uint16 {
+	if (c >= 0x10000) && (c <= unicode.MaxRune) {
+		high, low := utf16.EncodeRune(rune(c))
+		return []uint16{uint16(high), uint16(low)}
+	}
+	return []uint16{uint16(c)}
+}
+
+// Returns true if s[offset:] starts with prefix. Returns false if offset is
+// out of bounds.
+func hasPrefixAt(s, prefix []uint16, offset int) bool {
+	if (offset < 0) || ((offset + len(prefix)) > len(s)) {
+		return false
+	}
+	for i, c := range prefix {
+		if s[offset+i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the index of the first occurrence of needle in s at or after the
+// given index, or -1 if it doesn't occur. Like Java, an empty needle is found
+// at the end of s if the index is past the end.
+func indexOfUTF16(s, needle []uint16, from int) int {
+	if from < 0 {
+		from = 0
+	}
+	if from > len(s) {
+		from = len(s)
+	}
+	for i := from; (i + len(needle)) <= len(s); i++ {
+		if hasPrefixAt(s, needle, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the index of the last occurrence of needle in s, or -1 if it
+// doesn't occur.
+func lastIndexOfUTF16(s, needle []uint16) int {
+	for i := len(s) - len(needle); i >= 0; i-- {
+		if hasPrefixAt(s, needle, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns a copy of s with f applied to each code point. Unpaired surrogates
+// are copied unchanged.
+func mapCodePoints(s []uint16, f func(rune) rune) []uint16 {
+	toReturn := make([]uint16, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := rune(s[i])
+		if utf16.IsSurrogate(c) && ((i + 1) < len(s)) {
+			pair := utf16.DecodeRune(c, rune(s[i+1]))
+			if pair != unicode.ReplacementChar {
+				c = pair
+				i++
+			}
+		}
+		if utf16.IsSurrogate(c) {
+			toReturn = append(toReturn, uint16(c))
+			continue
+		}
+		toReturn = append(toReturn, utf16.Encode([]rune{f(c)})...)
+	}
+	return toReturn
+}
+
+// Compares two strings lexicographically by UTF-16 code unit, like Java's
+// String.compareTo. If ignoreCase is set, each pair of characters is
+// converted to upper and then lower case before comparing them.
+func compareUTF16(a, b []uint16, ignoreCase bool) bs_jvm.Int {
+	for i := 0; (i < len(a)) && (i < len(b)); i++ {
+		ca, cb := rune(a[i]), rune(b[i])
+		if ignoreCase && (ca != cb) {
+			ca = unicode.ToLower(unicode.ToUpper(ca))
+			cb = unicode.ToLower(unicode.ToUpper(cb))
+		}
+		if ca != cb {
+			return bs_jvm.Int(ca - cb)
+		}
+	}
+	return bs_jvm.Int(len(a) - len(b))
+}
+
+// Returns true if Java's Character.isWhitespace would return true for c.
+func isJavaWhitespace(c uint16) bool {
+	// Java doesn't consider non-breaking spaces to be whitespace.
+	if (c == 0x00a0) || (c == 0x2007) || (c == 0x202f) {
+		return false
+	}
+	return unicode.IsSpace(rune(c)) || ((c >= 0x1c) && (c <= 0x1f))
+}
+
+// Returns s with the leading and trailing code units matching f removed.
+func trimUTF16(s []uint16, f func(uint16) bool) []uint16 {
+	start := 0
+	for (start < len(s)) && f(s[start]) {
+		start++
+	}
+	end := len(s)
+	for (end > start) && f(s[end-1]) {
+		end--
+	}
+	return s[start:end]
+}
+
+// Returns the regular expression to use for String.split. Like Java, regexes
+// consisting of a single literal character are matched directly. Other
+// regexes are compiled using Go's regexp package, which uses RE2 syntax
+// rather than Java's. The syntax is mostly the same, but RE2 doesn't support
+// Java features such as lookaround assertions, backreferences, possessive
+// quantifiers, or Java-specific character classes like \p{javaLowerCase}.
+// Regexes using them fail to compile, as if they were invalid.
+func getSplitRegex(regex string) (*regexp.Regexp, error) {
+	literal := ""
+	if (len(regex) == 1) && !strings.Contains(".$|()[{^?*+\\", regex) {
+		literal = regex
+	} else if (len(regex) == 2) && (regex[0] == '\\') &&
+		!unicode.IsLetter(rune(regex[1])) && !unicode.IsDigit(rune(regex[1])) {
+		literal = regex[1:]
+	}
+	if literal != "" {
+		return regexp.MustCompile(regexp.QuoteMeta(literal)), nil
+	}
+	return regexp.Compile(regex)
+}
+
+// Converts UTF-16 code units to a UTF-8 string that can be matched using the
+// regexp package. Also returns the index of the code unit corresponding to
+// each byte of the string, followed by len(chars), so matches can be mapped
+// back to the original code units. Unpaired surrogates can't be represented in
+// UTF-8, so they're converted to U+FFFD, but since the results are sliced from
+// the original code units, they're preserved in the results.
+func getMatchableString(chars []uint16) (string, []int) {
+	var builder strings.Builder
+	offsets := make([]int, 0, len(chars)+1)
+	for i := 0; i < len(chars); i++ {
+		start := i
+		r := rune(chars[i])
+		if utf16.IsSurrogate(r) && ((i + 1) < len(chars)) {
+			pair := utf16.DecodeRune(r, rune(chars[i+1]))
+			if pair != unicode.ReplacementChar {
+				r = pair
+				i++
+			}
+		}
+		n, _ := builder.WriteRune(r)
+		for j := 0; j < n; j++ {
+			offsets = append(offsets, start)
+		}
+	}
+	offsets = append(offsets, len(chars))
+	return builder.String(), offsets
+}
+
+// Splits chars around matches of re, following the rules of Java's
+// String.split. If limit is positive, at most limit strings are returned. If
+// it's zero, trailing empty strings are removed. The returned slices share
+// chars' memory, but their capacity is limited so that appending to them
+// can't overwrite the following parts.
+func splitUTF16(chars []uint16, re *regexp.Regexp, limit int) [][]uint16 {
+	if len(chars) == 0 {
+		return [][]uint16{chars}
+	}
+	s, offsets := getMatchableString(chars)
+	toReturn := make([][]uint16, 0, 8)
+	start := 0
+	for _, match := range re.FindAllStringIndex(s, -1) {
+		if (limit > 0) && (len(toReturn) == (limit - 1)) {
+			break
+		}
+		// A zero-width match at the start never produces an empty leading
+		// string.
+		if match[1] == 0 {
+			continue
+		}
+		end := offsets[match[0]]
+		toReturn = append(toReturn, chars[start:end:end])
+		start = offsets[match[1]]
+	}
+	if len(toReturn) == 0 {
+		return [][]uint16{chars}
+	}
+	toReturn = append(toReturn, chars[start:])
+	if limit == 0 {
+		for (len(toReturn) > 0) && (len(toReturn[len(toReturn)-1]) == 0) {
+			toReturn = toReturn[:len(toReturn)-1]
+		}
+	}
+	return toReturn
+}
+
+// Implements the String() constructor.
+func noArgsStringConstructor(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s.Initialize([]uint16{})
+	return nil
+}
+
+// Implements the String(String) constructor.
+func copyStringConstructor(t *bs_jvm.Thread) error {
+	original, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	// Strings are never modified, so the contents can be shared.
+	s.Initialize(original.UTF16())
+	return nil
+}
+
+// Implements the String(char[]) constructor.
+func charArrayStringConstructor(t *bs_jvm.Thread) error {
+	chars, e := popCharArray(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s.Initialize(charsToUTF16(chars))
+	return nil
+}
+
+// Implements the String(char[], int, int) constructor.
+func charArrayRangeStringConstructor(t *bs_jvm.Thread) error {
+	count, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	offset, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	chars, e := popCharArray(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	if (offset < 0) || (count < 0) ||
+		(int(offset) > (len(chars) - int(count))) {
+		return throwStringIndexError(t, "offset %d, count %d, length %d",
+			offset, count, len(chars))
+	}
+	s.Initialize(charsToUTF16(chars[offset : offset+count]))
+	return nil
+}
+
+// Implements the String(byte[]) constructor. The bytes are decoded as UTF-8.
+func byteArrayStringConstructor(t *bs_jvm.Thread) error {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	array, ok := tmp.(*bs_jvm.ByteArray)
+	if !ok {
+		return bs_jvm.TypeError("Expected a byte array, got " +
+			tmp.TypeName())
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	data := make([]byte, len(array.Elements))
+	for i, b := range array.Elements {
+		data[i] = byte(b)
+	}
+	s.Initialize(utf16.Encode([]rune(string(data))))
+	return nil
+}
+
+// Implements the length() method.
+func stringLengthMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(bs_jvm.Int(s.Length()))
+}
+
+// Implements the isEmpty() method.
+func stringIsEmptyMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, s.Length() == 0)
+}
+
+// Implements the charAt(int) method.
+func charAtMethod(t *bs_jvm.Thread) error {
+	index, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	if (index < 0) || (int(index) >= s.Length()) {
+		return throwStringIndexError(t, "Index %d out of bounds for length "+
+			"%d", index, s.Length())
+	}
+	return t.Stack.Push(bs_jvm.Int(s.UTF16()[index]))
+}
+
+// Implements the codePointAt(int) method.
+func codePointAtMethod(t *bs_jvm.Thread) error {
+	index, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	chars := s.UTF16()
+	if (index < 0) || (int(index) >= len(chars)) {
+		return throwStringIndexError(t, "Index %d out of bounds for length "+
+			"%d", index, len(chars))
+	}
+	c := rune(chars[index])
+	if (int(index) + 1) < len(chars) {
+		pair := utf16.DecodeRune(c, rune(chars[index+1]))
+		if pair != unicode.ReplacementChar {
+			c = pair
+		}
+	}
+	return t.Stack.Push(bs_jvm.Int(c))
+}
+
+// Pushes the substring of s from begin to end, throwing an exception if the
+// indices are out of bounds.
+func pushSubstring(t *bs_jvm.Thread, s *bs_jvm.StringObject,
+	begin, end bs_jvm.Int) error {
+	if (begin < 0) || (begin > end) || (int(end) > s.Length()) {
+		return throwStringIndexError(t, "begin %d, end %d, length %d", begin,
+			end, s.Length())
+	}
+	if (begin == 0) && (int(end) == s.Length()) {
+		return t.Stack.PushRef(s)
+	}
+	return pushUTF16(t, s.UTF16()[begin:end])
+}
+
+// Implements the substring(int) method.
+func substringMethod(t *bs_jvm.Thread) error {
+	begin, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushSubstring(t, s, begin, bs_jvm.Int(s.Length()))
+}
+
+// Implements the substring(int, int) and subSequence(int, int) methods.
+func substringRangeMethod(t *bs_jvm.Thread) error {
+	end, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	begin, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushSubstring(t, s, begin, end)
+}
+
+// Implements the indexOf(int) method.
+func indexOfCharMethod(t *bs_jvm.Thread) error {
+	c, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := indexOfUTF16(s.UTF16(), codePointToUTF16(c), 0)
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the indexOf(int, int) method.
+func indexOfCharFromMethod(t *bs_jvm.Thread) error {
+	from, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	c, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := indexOfUTF16(s.UTF16(), codePointToUTF16(c), int(from))
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the indexOf(String) method.
+func indexOfStringMethod(t *bs_jvm.Thread) error {
+	needle, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := indexOfUTF16(s.UTF16(), needle.UTF16(), 0)
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the indexOf(String, int) method.
+func indexOfStringFromMethod(t *bs_jvm.Thread) error {
+	from, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	needle, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := indexOfUTF16(s.UTF16(), needle.UTF16(), int(from))
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the lastIndexOf(int) method.
+func lastIndexOfCharMethod(t *bs_jvm.Thread) error {
+	c, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := lastIndexOfUTF16(s.UTF16(), codePointToUTF16(c))
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the lastIndexOf(String) method.
+func lastIndexOfStringMethod(t *bs_jvm.Thread) error {
+	needle, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	index := lastIndexOfUTF16(s.UTF16(), needle.UTF16())
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Implements the contains(CharSequence) method.
+func containsMethod(t *bs_jvm.Thread) error {
+	needle, e := popCharSequence(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, indexOfUTF16(s.UTF16(), needle, 0) >= 0)
+}
+
+// Implements the startsWith(String) method.
+func startsWithMethod(t *bs_jvm.Thread) error {
+	prefix, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, hasPrefixAt(s.UTF16(), prefix.UTF16(), 0))
+}
+
+// Implements the startsWith(String, int) method.
+func startsWithOffsetMethod(t *bs_jvm.Thread) error {
+	offset, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	prefix, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, hasPrefixAt(s.UTF16(), prefix.UTF16(),
+		int(offset)))
+}
+
+// Implements the endsWith(String) method.
+func endsWithMethod(t *bs_jvm.Thread) error {
+	suffix, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	offset := s.Length() - suffix.Length()
+	return pushBoolean(t, hasPrefixAt(s.UTF16(), suffix.UTF16(), offset))
+}
+
+// Implements the equals(Object) method.
+func stringEqualsMethod(t *bs_jvm.Thread) error {
+	tmp, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	other, ok := tmp.(*bs_jvm.StringObject)
+	return pushBoolean(t, ok && s.Equals(other))
+}
+
+// Implements the equalsIgnoreCase(String) method.
+func equalsIgnoreCaseMethod(t *bs_jvm.Thread) error {
+	tmp, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	other, ok := tmp.(*bs_jvm.StringObject)
+	if !ok || (other.Length() != s.Length()) {
+		return pushBoolean(t, false)
+	}
+	return pushBoolean(t, compareUTF16(s.UTF16(), other.UTF16(), true) == 0)
+}
+
+// Implements the hashCode() method.
+func stringHashCodeMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(s.HashCode())
+}
+
+// Implements the compareTo(String) method, as well as the compareTo(Object)
+// bridge method required by Comparable.
+func stringCompareToMethod(t *bs_jvm.Thread) error {
+	other, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(compareUTF16(s.UTF16(), other.UTF16(), false))
+}
+
+// Implements the compareToIgnoreCase(String) method.
+func compareToIgnoreCaseMethod(t *bs_jvm.Thread) error {
+	other, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(compareUTF16(s.UTF16(), other.UTF16(), true))
+}
+
+// Implements the concat(String) method.
+func concatMethod(t *bs_jvm.Thread) error {
+	other, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	if other.Length() == 0 {
+		return t.Stack.PushRef(s)
+	}
+	result := make([]uint16, 0, s.Length()+other.Length())
+	result = append(result, s.UTF16()...)
+	result = append(result, other.UTF16()...)
+	return pushUTF16(t, result)
+}
+
+// Implements the replace(char, char) method.
+func replaceCharMethod(t *bs_jvm.Thread) error {
+	newChar, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	oldChar, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	result := make([]uint16, s.Length())
+	for i, c := range s.UTF16() {
+		if c == uint16(oldChar) {
+			c = uint16(newChar)
+		}
+		result[i] = c
+	}
+	return pushUTF16(t, result)
+}
+
+// Implements the replace(CharSequence, CharSequence) method.
+func replaceSequenceMethod(t *bs_jvm.Thread) error {
+	replacement, e := popCharSequence(t)
+	if e != nil {
+		return e
+	}
+	target, e := popCharSequence(t)
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	chars := s.UTF16()
+	result := make([]uint16, 0, len(chars))
+	i := 0
+	for i <= len(chars) {
+		if hasPrefixAt(chars, target, i) {
+			result = append(result, replacement...)
+			if len(target) != 0 {
+				i += len(target)
+				continue
+			}
+		}
+		// An empty target matches before every character, and at the end.
+		if i < len(chars) {
+			result = append(result, chars[i])
+		}
+		i++
+	}
+	return pushUTF16(t, result)
+}
+
+// Splits the string on top of the stack, given the regular expression and
+// limit, and pushes the resulting String[] array.
+func splitWithLimit(t *bs_jvm.Thread, regex string, limit bs_jvm.Int) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	re, e := getSplitRegex(regex)
+	if e != nil {
+		return t.Throw("java/util/regex/PatternSyntaxException",
+			"Unsupported or invalid regular expression: "+e.Error())
+	}
+	parts := splitUTF16(s.UTF16(), re, int(limit))
+	elements := make([]bs_jvm.Object, len(parts))
+	for i, part := range parts {
+		elements[i] = bs_jvm.NewStringObjectFromUTF16(part)
+	}
+	return t.Stack.PushRef(&bs_jvm.ReferenceArray{
+		Type: &class_file.ArrayType{
+			Dimensions:  1,
+			ContentType: class_file.ClassInstanceType("java/lang/String"),
+		},
+		Elements: elements,
+	})
+}
+
+// Implements the split(String) method.
+func splitMethod(t *bs_jvm.Thread) error {
+	regex, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	return splitWithLimit(t, regex, 0)
+}
+
+// Implements the split(String, int) method.
+func splitLimitMethod(t *bs_jvm.Thread) error {
+	limit, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	regex, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	return splitWithLimit(t, regex, limit)
+}
+
+// Implements the toCharArray() method.
+func toCharArrayMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	elements := make([]bs_jvm.Char, s.Length())
+	for i, c := range s.UTF16() {
+		elements[i] = bs_jvm.Char(c)
+	}
+	return t.Stack.PushRef(&bs_jvm.CharArray{
+		Elements: elements,
+	})
+}
+
+// Implements the intern() method.
+func internMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(t.ParentJVM.InternString(s))
+}
+
+// Implements the toString() method, which returns the string itself.
+func stringToStringMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(s)
+}
+
+// Implements the trim() method, which removes leading and trailing characters
+// no greater than a space.
+func trimMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, trimUTF16(s.UTF16(), func(c uint16) bool {
+		return c <= ' '
+	}))
+}
+
+// Implements the strip() method, which removes leading and trailing
+// whitespace.
+func stripMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, trimUTF16(s.UTF16(), isJavaWhitespace))
+}
+
+// Implements the isBlank() method.
+func isBlankMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushBoolean(t, len(trimUTF16(s.UTF16(), isJavaWhitespace)) == 0)
+}
+
+// Implements the toUpperCase() method.
+func toUpperCaseMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, mapCodePoints(s.UTF16(), unicode.ToUpper))
+}
+
+// Implements the toLowerCase() method.
+func toLowerCaseMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, mapCodePoints(s.UTF16(), unicode.ToLower))
+}
+
+// Implements the getBytes() method, which encodes the string as UTF-8.
+func getBytesMethod(t *bs_jvm.Thread) error {
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	data := []byte(s.Value())
+	elements := make([]bs_jvm.Byte, len(data))
+	for i, b := range data {
+		elements[i] = bs_jvm.Byte(b)
+	}
+	return t.Stack.PushRef(&bs_jvm.ByteArray{
+		Elements: elements,
+	})
+}
+
+// Implements the repeat(int) method.
+func repeatMethod(t *bs_jvm.Thread) error {
+	count, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	if count < 0 {
+		return t.Throw("java/lang/IllegalArgumentException",
+			fmt.Sprintf("count is negative: %d", count))
+	}
+	result := make([]uint16, 0, s.Length()*int(count))
+	for i := bs_jvm.Int(0); i < count; i++ {
+		result = append(result, s.UTF16()...)
+	}
+	return pushUTF16(t, result)
+}
+
+// Implements the static valueOf(Object) method.
+func valueOfObjectMethod(t *bs_jvm.Thread) error {
+	o, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	if s, ok := o.(*bs_jvm.StringObject); ok {
+		return t.Stack.PushRef(s)
+	}
+	s, e := t.ObjectToString(o)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the static valueOf(int) method.
+func valueOfIntMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s := strconv.Itoa(int(v))
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the static valueOf(long) method.
+func valueOfLongMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.PopLong()
+	if e != nil {
+		return e
+	}
+	s := strconv.FormatInt(int64(v), 10)
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the static valueOf(char) method.
+func valueOfCharMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, []uint16{uint16(v)})
+}
+
+// Implements the static valueOf(boolean) method.
+func valueOfBooleanMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	s := "false"
+	if v != 0 {
+		s = "true"
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements the static valueOf(float) method.
+func valueOfFloatMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.PopFloat()
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(v.JavaString()))
+}
+
+// Implements the static valueOf(double) method.
+func valueOfDoubleMethod(t *bs_jvm.Thread) error {
+	v, e := t.Stack.PopDouble()
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(v.JavaString()))
+}
+
+// Implements the static valueOf(char[]) and copyValueOf(char[]) methods.
+func valueOfCharArrayMethod(t *bs_jvm.Thread) error {
+	chars, e := popCharArray(t)
+	if e != nil {
+		return e
+	}
+	return pushUTF16(t, charsToUTF16(chars))
+}
+
+// Implements the static join(CharSequence, CharSequence...) method.
+func stringJoinMethod(t *bs_jvm.Thread) error {
+	elements, e := popObjectArray(t)
+	if e != nil {
+		return e
+	}
+	delimiter, e := popCharSequence(t)
+	if e != nil {
+		return e
+	}
+	result := make([]uint16, 0, 16*len(elements))
+	for i, element := range elements {
+		if i != 0 {
+			result = append(result, delimiter...)
+		}
+		if s, ok := element.(*bs_jvm.StringObject); ok {
+			result = append(result, s.UTF16()...)
+			continue
+		}
+		s, e := t.ObjectToString(element)
+		if e != nil {
+			return e
+		}
+		result = append(result, utf16.Encode([]rune(s))...)
+	}
+	return pushUTF16(t, result)
+}
+
+// Implements both the static format(String, Object...) method and the
+// formatted(Object...) instance method. In either case, the format string is
+// followed by the array of args on the stack.
+func stringFormatMethod(t *bs_jvm.Thread) error {
+	format, args, e := popFormatArgs(t)
+	if e != nil {
+		return e
+	}
+	s, e := t.FormatString(format, args)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Returns a BS-JVM class implementing the java/lang/CharSequence interface.
+// If it has already been initialized, returns the existing copy.
+func GetCharSequenceClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if charSequenceClass != nil {
+		return charSequenceClass, nil
+	}
+	intType := class_file.PrimitiveFieldType('I')
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/CharSequence")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	// public abstract
+	access := class_file.MethodAccessFlags(0x0401)
+	AddMethod(toReturn, "length", access, noArgs, intType, nil)
+	AddMethod(toReturn, "charAt", access, []class_file.FieldType{intType},
+		class_file.PrimitiveFieldType('C'), nil)
+	AddMethod(toReturn, "subSequence", access, []class_file.FieldType{
+		intType, intType},
+		class_file.ClassInstanceType("java/lang/CharSequence"), nil)
+	AddMethod(toReturn, "toString", access, noArgs,
+		class_file.ClassInstanceType("java/lang/String"), nil)
+	charSequenceClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing the java/lang/Comparable interface. If
+// it has already been initialized, returns the existing copy.
+func GetComparableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if comparableClass != nil {
+		return comparableClass, nil
+	}
+	toReturn := GetEmptyClass(jvm, "java/lang/Comparable")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	// public abstract
+	AddMethod(toReturn, "compareTo", 0x0401, []class_file.FieldType{
+		class_file.ClassInstanceType("java/lang/Object")},
+		class_file.PrimitiveFieldType('I'), nil)
+	comparableClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/String. If it has already been
+// initialized, returns the existing copy.
+func GetStringClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if stringClass != nil {
+		return stringClass, nil
+	}
+	charSequence, e := GetCharSequenceClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	comparable, e := GetComparableClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	charSequenceType := class_file.ClassInstanceType("java/lang/CharSequence")
+	charArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.PrimitiveFieldType('C'),
+	}
+	byteArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.PrimitiveFieldType('B'),
+	}
+	stringArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: stringType,
+	}
+	charSequenceArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: charSequenceType,
+	}
+	objectArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: objectType,
+	}
+	intType := class_file.PrimitiveFieldType('I')
+	longType := class_file.PrimitiveFieldType('J')
+	floatType := class_file.PrimitiveFieldType('F')
+	doubleType := class_file.PrimitiveFieldType('D')
+	charType := class_file.PrimitiveFieldType('C')
+	booleanType := class_file.PrimitiveFieldType('Z')
+	byteArgs := []class_file.FieldType{byteArrayType}
+	charArgs := []class_file.FieldType{charArrayType}
+	intArg := []class_file.FieldType{intType}
+	twoInts := []class_file.FieldType{intType, intType}
+	stringArg := []class_file.FieldType{stringType}
+	objectArg := []class_file.FieldType{objectType}
+	charSequenceArg := []class_file.FieldType{charSequenceType}
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/String")
+	// public final super
+	toReturn.AccessFlags = 0x0031
+	toReturn.Interfaces = []*bs_jvm.Class{charSequence, comparable}
+	toReturn.Allocate = func() bs_jvm.Object {
+		return &bs_jvm.StringObject{}
+	}
+	AddConstructor(toReturn, 1, noArgs, noArgsStringConstructor)
+	AddConstructor(toReturn, 1, stringArg, copyStringConstructor)
+	AddConstructor(toReturn, 1, charArgs, charArrayStringConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{charArrayType, intType,
+		intType}, charArrayRangeStringConstructor)
+	AddConstructor(toReturn, 1, byteArgs, byteArrayStringConstructor)
+	AddMethod(toReturn, "length", 1, noArgs, intType, stringLengthMethod)
+	AddMethod(toReturn, "isEmpty", 1, noArgs, booleanType,
+		stringIsEmptyMethod)
+	AddMethod(toReturn, "charAt", 1, intArg, charType, charAtMethod)
+	AddMethod(toReturn, "codePointAt", 1, intArg, intType, codePointAtMethod)
+	AddMethod(toReturn, "substring", 1, intArg, stringType, substringMethod)
+	AddMethod(toReturn, "substring", 1, twoInts, stringType,
+		substringRangeMethod)
+	AddMethod(toReturn, "subSequence", 1, twoInts, charSequenceType,
+		substringRangeMethod)
+	AddMethod(toReturn, "indexOf", 1, intArg, intType, indexOfCharMethod)
+	AddMethod(toReturn, "indexOf", 1, twoInts, intType,
+		indexOfCharFromMethod)
+	AddMethod(toReturn, "indexOf", 1, stringArg, intType,
+		indexOfStringMethod)
+	AddMethod(toReturn, "indexOf", 1, []class_file.FieldType{stringType,
+		intType}, intType, indexOfStringFromMethod)
+	AddMethod(toReturn, "lastIndexOf", 1, intArg, intType,
+		lastIndexOfCharMethod)
+	AddMethod(toReturn, "lastIndexOf", 1, stringArg, intType,
+		lastIndexOfStringMethod)
+	AddMethod(toReturn, "contains", 1, charSequenceArg, booleanType,
+		containsMethod)
+	AddMethod(toReturn, "startsWith", 1, stringArg, booleanType,
+		startsWithMethod)
+	AddMethod(toReturn, "startsWith", 1, []class_file.FieldType{stringType,
+		intType}, booleanType, startsWithOffsetMethod)
+	AddMethod(toReturn, "endsWith", 1, stringArg, booleanType,
+		endsWithMethod)
+	AddMethod(toReturn, "equals", 1, objectArg, booleanType,
+		stringEqualsMethod)
+	AddMethod(toReturn, "equalsIgnoreCase", 1, stringArg, booleanType,
+		equalsIgnoreCaseMethod)
+	AddMethod(toReturn, "hashCode", 1, noArgs, intType, stringHashCodeMethod)
+	AddMethod(toReturn, "compareTo", 1, stringArg, intType,
+		stringCompareToMethod)
+	// public bridge synthetic
+	AddMethod(toReturn, "compareTo", 0x1041, objectArg, intType,
+		stringCompareToMethod)
+	AddMethod(toReturn, "compareToIgnoreCase", 1, stringArg, intType,
+		compareToIgnoreCaseMethod)
+	AddMethod(toReturn, "concat", 1, stringArg, stringType, concatMethod)
+	AddMethod(toReturn, "replace", 1, []class_file.FieldType{charType,
+		charType}, stringType, replaceCharMethod)
+	AddMethod(toReturn, "replace", 1, []class_file.FieldType{
+		charSequenceType, charSequenceType}, stringType,
+		replaceSequenceMethod)
+	AddMethod(toReturn, "split", 1, stringArg, stringArrayType, splitMethod)
+	AddMethod(toReturn, "split", 1, []class_file.FieldType{stringType,
+		intType}, stringArrayType, splitLimitMethod)
+	AddMethod(toReturn, "toCharArray", 1, noArgs, charArrayType,
+		toCharArrayMethod)
+	// public native
+	AddMethod(toReturn, "intern", 0x0101, noArgs, stringType, internMethod)
+	AddMethod(toReturn, "toString", 1, noArgs, stringType,
+		stringToStringMethod)
+	AddMethod(toReturn, "trim", 1, noArgs, stringType, trimMethod)
+	AddMethod(toReturn, "strip", 1, noArgs, stringType, stripMethod)
+	AddMethod(toReturn, "isBlank", 1, noArgs, booleanType, isBlankMethod)
+	AddMethod(toReturn, "toUpperCase", 1, noArgs, stringType,
+		toUpperCaseMethod)
+	AddMethod(toReturn, "toLowerCase", 1, noArgs, stringType,
+		toLowerCaseMethod)
+	AddMethod(toReturn, "getBytes", 1, noArgs, byteArrayType, getBytesMethod)
+	AddMethod(toReturn, "repeat", 1, intArg, stringType, repeatMethod)
+	// public varargs
+	AddMethod(toReturn, "formatted", 0x0081, []class_file.FieldType{
+		objectArrayType}, stringType, stringFormatMethod)
+	// public static
+	access := class_file.MethodAccessFlags(0x0009)
+	AddMethod(toReturn, "valueOf", access, objectArg, stringType,
+		valueOfObjectMethod)
+	AddMethod(toReturn, "valueOf", access, intArg, stringType,
+		valueOfIntMethod)
+	AddMethod(toReturn, "valueOf", access, []class_file.FieldType{longType},
+		stringType, valueOfLongMethod)
+	AddMethod(toReturn, "valueOf", access, []class_file.FieldType{charType},
+		stringType, valueOfCharMethod)
+	AddMethod(toReturn, "valueOf", access,
+		[]class_file.FieldType{booleanType}, stringType, valueOfBooleanMethod)
+	AddMethod(toReturn, "valueOf", access, []class_file.FieldType{floatType},
+		stringType, valueOfFloatMethod)
+	AddMethod(toReturn, "valueOf", access,
+		[]class_file.FieldType{doubleType}, stringType, valueOfDoubleMethod)
+	AddMethod(toReturn, "valueOf", access, charArgs, stringType,
+		valueOfCharArrayMethod)
+	AddMethod(toReturn, "copyValueOf", access, charArgs, stringType,
+		valueOfCharArrayMethod)
+	// public static varargs
+	AddMethod(toReturn, "join", 0x0089, []class_file.FieldType{
+		charSequenceType, charSequenceArrayType}, stringType, stringJoinMethod)
+	AddMethod(toReturn, "format", 0x0089, []class_file.FieldType{stringType,
+		objectArrayType}, stringType, stringFormatMethod)
+	stringClass = toReturn
+	return toReturn, nil
+}
@@ -0,0 +1,621 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.StringBuilder and
+// java.lang.StringBuffer, which share the same implementation. StringBuffer's
+// methods are synchronized, so the JVM holds the instance's monitor while they
+// run.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Initialized versions of the builtin StringBuilder and StringBuffer classes.
+var stringBuilderClass *bs_jvm.Class
+var stringBufferClass *bs_jvm.Class
+
+// Holds internal state for StringBuilder and StringBuffer instances.
+type internalStringBuilder struct {
+	// The UTF-16 code units making up the string being built.
+	chars []uint16
+}
+
+// Pops an instance of StringBuilder or StringBuffer. If initialized is true,
+// returns an error if the instance's constructor hasn't run yet.
+func popStringBuilderInstance(t *bs_jvm.Thread,
+	initialized bool) (*bs_jvm.ClassInstance, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, fmt.Errorf("Failed popping StringBuilder instance: %w", e)
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, bs_jvm.TypeError("Didn't get class instance")
+	}
+	if (instance.C != stringBuilderClass) &&
+		(instance.C != stringBufferClass) {
+		return nil, bs_jvm.TypeError("Didn't get StringBuilder or " +
+			"StringBuffer instance")
+	}
+	if initialized && (instance.NativeData == nil) {
+		return nil, bs_jvm.NullReferenceError("Got uninitialized " +
+			string(instance.C.Name) + " instance")
+	}
+	return instance, nil
+}
+
+// Pops an initialized StringBuilder or StringBuffer, and returns both the
+// instance and its internal state.
+func popInternalStringBuilder(t *bs_jvm.Thread) (*bs_jvm.ClassInstance,
+	*internalStringBuilder, error) {
+	instance, e := popStringBuilderInstance(t, true)
+	if e != nil {
+		return nil, nil, e
+	}
+	return instance, instance.NativeData.(*internalStringBuilder), nil
+}
+
+// Returns true if the type is char[].
+func isCharArrayType(valueType class_file.FieldType) bool {
+	arrayType, ok := valueType.(*class_file.ArrayType)
+	if !ok || (arrayType.Dimensions != 1) {
+		return false
+	}
+	return arrayType.ContentType == class_file.PrimitiveFieldType('C')
+}
+
+// Converts a value to UTF-16 code units, in the same way as String.valueOf.
+// Like StringBuilder.append(char[]), the contents of a char[] are used
+// directly rather than converting the array object to a string. Must only be
+// called from a native method, since an object's toString() may need to run.
+func valueToUTF16(t *bs_jvm.Thread, valueType class_file.FieldType,
+	v bs_jvm.Object) ([]uint16, error) {
+	if isCharArrayType(valueType) {
+		if bs_jvm.IsNull(v) {
+			return nil, bs_jvm.NullReferenceError("Got a null char array")
+		}
+		return charsToUTF16(v.(*bs_jvm.CharArray).Elements), nil
+	}
+	if s, ok := v.(*bs_jvm.StringObject); ok {
+		return s.UTF16(), nil
+	}
+	if valueType == class_file.PrimitiveFieldType('C') {
+		return []uint16{uint16(v.(bs_jvm.Int))}, nil
+	}
+	s, e := valueToString(t, valueType, v)
+	if e != nil {
+		return nil, e
+	}
+	return utf16.Encode([]rune(s)), nil
+}
+
+// Sets the contents of a newly constructed StringBuilder or StringBuffer.
+func initStringBuilder(instance *bs_jvm.ClassInstance, chars []uint16) {
+	// Copy the initial contents, since the builder modifies them in place.
+	instance.NativeData = &internalStringBuilder{
+		chars: append(make([]uint16, 0, len(chars)+16), chars...),
+	}
+}
+
+// Implements the no-args constructor.
+func noArgsStringBuilderConstructor(t *bs_jvm.Thread) error {
+	instance, e := popStringBuilderInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initStringBuilder(instance, nil)
+	return nil
+}
+
+// Implements the constructor taking an initial capacity.
+func capacityStringBuilderConstructor(t *bs_jvm.Thread) error {
+	capacity, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	instance, e := popStringBuilderInstance(t, false)
+	if e != nil {
+		return e
+	}
+	if capacity < 0 {
+		return bs_jvm.NegativeArraySizeError(capacity)
+	}
+	instance.NativeData = &internalStringBuilder{
+		chars: make([]uint16, 0, capacity),
+	}
+	return nil
+}
+
+// Implements the constructors taking a String or CharSequence.
+func charSequenceStringBuilderConstructor(t *bs_jvm.Thread) error {
+	chars, e := popCharSequence(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popStringBuilderInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initStringBuilder(instance, chars)
+	return nil
+}
+
+// Returns the implementation of the append method taking a single arg of the
+// given type.
+func getAppendMethod(argType class_file.FieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{argType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		instance, state, e := popInternalStringBuilder(t)
+		if e != nil {
+			return e
+		}
+		chars, e := valueToUTF16(t, argType, args[0])
+		if e != nil {
+			return e
+		}
+		state.chars = append(state.chars, chars...)
+		return t.Stack.PushRef(instance)
+	}
+}
+
+// Appends chars[start:end] to the StringBuilder on top of the stack, and
+// pushes the StringBuilder back as the result. The method's other args must
+// already have been popped.
+func appendRange(t *bs_jvm.Thread, chars []uint16, start,
+	end bs_jvm.Int) error {
+	instance, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if (start < 0) || (start > end) || (int(end) > len(chars)) {
+		return t.Throw("java/lang/IndexOutOfBoundsException",
+			fmt.Sprintf("start %d, end %d, length %d", start, end,
+				len(chars)))
+	}
+	state.chars = append(state.chars, chars[start:end]...)
+	return t.Stack.PushRef(instance)
+}
+
+// Implements the append(CharSequence, int, int) method.
+func appendCharSequenceRangeMethod(t *bs_jvm.Thread) error {
+	end, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	start, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	tmp, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	chars, e := valueToUTF16(t, class_file.ClassInstanceType(
+		"java/lang/CharSequence"), tmp)
+	if e != nil {
+		return e
+	}
+	return appendRange(t, chars, start, end)
+}
+
+// Implements the append(char[], int, int) method. Unlike the CharSequence
+// version, the second int is a length rather than an end index.
+func appendCharArrayRangeMethod(t *bs_jvm.Thread) error {
+	length, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	offset, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	chars, e := popCharArray(t)
+	if e != nil {
+		return e
+	}
+	return appendRange(t, charsToUTF16(chars), offset, offset+length)
+}
+
+// Implements the appendCodePoint(int) method.
+func appendCodePointMethod(t *bs_jvm.Thread) error {
+	c, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	instance, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if (c < 0) || (c > unicode.MaxRune) {
+		return t.Throw("java/lang/IllegalArgumentException",
+			fmt.Sprintf("Not a valid Unicode code point: 0x%X", uint32(c)))
+	}
+	state.chars = append(state.chars, codePointToUTF16(c)...)
+	return t.Stack.PushRef(instance)
+}
+
+// Returns the implementation of the insert method taking an offset followed
+// by an arg of the given type.
+func getInsertMethod(argType class_file.FieldType) bs_jvm.NativeMethod {
+	argTypes := []class_file.FieldType{class_file.PrimitiveFieldType('I'),
+		argType}
+	return func(t *bs_jvm.Thread) error {
+		args, e := t.PopValues(argTypes)
+		if e != nil {
+			return e
+		}
+		instance, state, e := popInternalStringBuilder(t)
+		if e != nil {
+			return e
+		}
+		chars, e := valueToUTF16(t, argType, args[1])
+		if e != nil {
+			return e
+		}
+		offset := int(args[0].(bs_jvm.Int))
+		if (offset < 0) || (offset > len(state.chars)) {
+			return throwStringIndexError(t, "offset %d, length %d", offset,
+				len(state.chars))
+		}
+		result := make([]uint16, 0, len(state.chars)+len(chars))
+		result = append(result, state.chars[:offset]...)
+		result = append(result, chars...)
+		state.chars = append(result, state.chars[offset:]...)
+		return t.Stack.PushRef(instance)
+	}
+}
+
+// Implements the length() method.
+func stringBuilderLengthMethod(t *bs_jvm.Thread) error {
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.Push(bs_jvm.Int(len(state.chars)))
+}
+
+// Implements the charAt(int) method.
+func stringBuilderCharAtMethod(t *bs_jvm.Thread) error {
+	index, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if (index < 0) || (int(index) >= len(state.chars)) {
+		return throwStringIndexError(t, "index %d,length %d", index,
+			len(state.chars))
+	}
+	return t.Stack.Push(bs_jvm.Int(state.chars[index]))
+}
+
+// Implements the setCharAt(int, char) method.
+func setCharAtMethod(t *bs_jvm.Thread) error {
+	c, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	index, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if (index < 0) || (int(index) >= len(state.chars)) {
+		return throwStringIndexError(t, "index %d,length %d", index,
+			len(state.chars))
+	}
+	state.chars[index] = uint16(c)
+	return nil
+}
+
+// Implements the setLength(int) method. Like Java, extending the length pads
+// the contents with null characters.
+func setLengthMethod(t *bs_jvm.Thread) error {
+	length, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if length < 0 {
+		return throwStringIndexError(t, "String index out of range: %d",
+			length)
+	}
+	for len(state.chars) < int(length) {
+		state.chars = append(state.chars, 0)
+	}
+	state.chars = state.chars[:length]
+	return nil
+}
+
+// Removes state.chars[start:end] and pushes the instance, after checking the
+// bounds. The end is clamped to the current length.
+func deleteRange(t *bs_jvm.Thread, instance *bs_jvm.ClassInstance,
+	state *internalStringBuilder, start, end int) error {
+	if end > len(state.chars) {
+		end = len(state.chars)
+	}
+	if (start < 0) || (start > end) {
+		return throwStringIndexError(t, "start %d, end %d, length %d",
+			start, end, len(state.chars))
+	}
+	state.chars = append(state.chars[:start], state.chars[end:]...)
+	return t.Stack.PushRef(instance)
+}
+
+// Implements the delete(int, int) method.
+func deleteMethod(t *bs_jvm.Thread) error {
+	end, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	start, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	instance, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	return deleteRange(t, instance, state, int(start), int(end))
+}
+
+// Implements the deleteCharAt(int) method.
+func deleteCharAtMethod(t *bs_jvm.Thread) error {
+	index, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	instance, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	if (index < 0) || (int(index) >= len(state.chars)) {
+		return throwStringIndexError(t, "index %d,length %d", index,
+			len(state.chars))
+	}
+	return deleteRange(t, instance, state, int(index), int(index)+1)
+}
+
+// Implements the reverse() method. Like Java, surrogate pairs are kept in
+// their original order, so the code points are what's actually reversed.
+func reverseMethod(t *bs_jvm.Thread) error {
+	instance, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	chars := state.chars
+	result := make([]uint16, 0, cap(chars))
+	for i := len(chars) - 1; i >= 0; i-- {
+		isPair := (i > 0) && utf16.IsSurrogate(rune(chars[i])) &&
+			(utf16.DecodeRune(rune(chars[i-1]), rune(chars[i])) !=
+				unicode.ReplacementChar)
+		if isPair {
+			result = append(result, chars[i-1], chars[i])
+			i--
+			continue
+		}
+		result = append(result, chars[i])
+	}
+	state.chars = result
+	return t.Stack.PushRef(instance)
+}
+
+// Pushes a new String containing state.chars[start:end], throwing an
+// exception if the indices are out of bounds.
+func pushStringBuilderSubstring(t *bs_jvm.Thread,
+	state *internalStringBuilder, start, end bs_jvm.Int) error {
+	if (start < 0) || (start > end) || (int(end) > len(state.chars)) {
+		return throwStringIndexError(t, "start %d, end %d, length %d",
+			start, end, len(state.chars))
+	}
+	// The builder's contents may change later, so the String needs a copy.
+	chars := make([]uint16, end-start)
+	copy(chars, state.chars[start:end])
+	return pushUTF16(t, chars)
+}
+
+// Implements the toString() method.
+func stringBuilderToStringMethod(t *bs_jvm.Thread) error {
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	return pushStringBuilderSubstring(t, state, 0, bs_jvm.Int(len(
+		state.chars)))
+}
+
+// Implements the substring(int) method.
+func stringBuilderSubstringMethod(t *bs_jvm.Thread) error {
+	start, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	return pushStringBuilderSubstring(t, state, start, bs_jvm.Int(len(
+		state.chars)))
+}
+
+// Implements the substring(int, int) and subSequence(int, int) methods.
+func stringBuilderSubstringRangeMethod(t *bs_jvm.Thread) error {
+	end, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	start, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	return pushStringBuilderSubstring(t, state, start, end)
+}
+
+// Implements the indexOf(String) method.
+func stringBuilderIndexOfMethod(t *bs_jvm.Thread) error {
+	needle, e := popStringObject(t)
+	if e != nil {
+		return e
+	}
+	_, state, e := popInternalStringBuilder(t)
+	if e != nil {
+		return e
+	}
+	index := indexOfUTF16(state.chars, needle.UTF16(), 0)
+	return t.Stack.Push(bs_jvm.Int(index))
+}
+
+// Creates a class with the given name implementing the methods shared by
+// StringBuilder and StringBuffer. If synchronized is true, all of the
+// class' methods other than constructors are synchronized.
+func newStringBuilderClass(jvm *bs_jvm.JVM, className string,
+	synchronized bool) (*bs_jvm.Class, error) {
+	charSequence, e := GetCharSequenceClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	appendable, e := GetAppendableClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	selfType := class_file.ClassInstanceType(className)
+	objectType := class_file.ClassInstanceType("java/lang/Object")
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	charSequenceType := class_file.ClassInstanceType("java/lang/CharSequence")
+	charArrayType := &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: class_file.PrimitiveFieldType('C'),
+	}
+	intType := class_file.PrimitiveFieldType('I')
+	charType := class_file.PrimitiveFieldType('C')
+	voidType := class_file.PrimitiveFieldType('V')
+	intArg := []class_file.FieldType{intType}
+	twoInts := []class_file.FieldType{intType, intType}
+	noArgs := []class_file.FieldType{}
+	// The types accepted by the single-arg append and two-arg insert methods.
+	valueTypes := []class_file.FieldType{
+		objectType,
+		stringType,
+		charSequenceType,
+		charArrayType,
+		class_file.PrimitiveFieldType('Z'),
+		charType,
+		intType,
+		class_file.PrimitiveFieldType('J'),
+		class_file.PrimitiveFieldType('F'),
+		class_file.PrimitiveFieldType('D'),
+	}
+	toReturn := GetEmptyClass(jvm, className)
+	// public final super
+	toReturn.AccessFlags = 0x0031
+	toReturn.Interfaces = []*bs_jvm.Class{charSequence, appendable}
+	AddConstructor(toReturn, 1, noArgs, noArgsStringBuilderConstructor)
+	AddConstructor(toReturn, 1, intArg, capacityStringBuilderConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{stringType},
+		charSequenceStringBuilderConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{charSequenceType},
+		charSequenceStringBuilderConstructor)
+	// public, optionally synchronized
+	access := class_file.MethodAccessFlags(0x0001)
+	if synchronized {
+		access |= 0x0020
+	}
+	for _, valueType := range valueTypes {
+		AddMethod(toReturn, "append", access,
+			[]class_file.FieldType{valueType}, selfType,
+			getAppendMethod(valueType))
+		AddMethod(toReturn, "insert", access, []class_file.FieldType{intType,
+			valueType}, selfType, getInsertMethod(valueType))
+	}
+	bufferType := class_file.ClassInstanceType("java/lang/StringBuffer")
+	AddMethod(toReturn, "append", access, []class_file.FieldType{bufferType},
+		selfType, getAppendMethod(bufferType))
+	AddMethod(toReturn, "append", access, []class_file.FieldType{
+		charSequenceType, intType, intType}, selfType,
+		appendCharSequenceRangeMethod)
+	AddMethod(toReturn, "append", access, []class_file.FieldType{
+		charArrayType, intType, intType}, selfType,
+		appendCharArrayRangeMethod)
+	// Bridges for the Appendable methods, optionally synchronized.
+	appendableType := class_file.ClassInstanceType("java/lang/Appendable")
+	AddMethod(toReturn, "append", access|0x1040, []class_file.FieldType{
+		charSequenceType}, appendableType, getAppendMethod(charSequenceType))
+	AddMethod(toReturn, "append", access|0x1040, []class_file.FieldType{
+		charSequenceType, intType, intType}, appendableType,
+		appendCharSequenceRangeMethod)
+	AddMethod(toReturn, "append", access|0x1040, []class_file.FieldType{
+		charType}, appendableType, getAppendMethod(charType))
+	AddMethod(toReturn, "appendCodePoint", access, intArg, selfType,
+		appendCodePointMethod)
+	AddMethod(toReturn, "length", access, noArgs, intType,
+		stringBuilderLengthMethod)
+	AddMethod(toReturn, "charAt", access, intArg, charType,
+		stringBuilderCharAtMethod)
+	AddMethod(toReturn, "setCharAt", access, []class_file.FieldType{intType,
+		charType}, voidType, setCharAtMethod)
+	AddMethod(toReturn, "setLength", access, intArg, voidType,
+		setLengthMethod)
+	AddMethod(toReturn, "delete", access, twoInts, selfType, deleteMethod)
+	AddMethod(toReturn, "deleteCharAt", access, intArg, selfType,
+		deleteCharAtMethod)
+	AddMethod(toReturn, "reverse", access, noArgs, selfType, reverseMethod)
+	AddMethod(toReturn, "toString", access, noArgs, stringType,
+		stringBuilderToStringMethod)
+	AddMethod(toReturn, "substring", access, intArg, stringType,
+		stringBuilderSubstringMethod)
+	AddMethod(toReturn, "substring", access, twoInts, stringType,
+		stringBuilderSubstringRangeMethod)
+	AddMethod(toReturn, "subSequence", access, twoInts, charSequenceType,
+		stringBuilderSubstringRangeMethod)
+	AddMethod(toReturn, "indexOf", access, []class_file.FieldType{stringType},
+		intType, stringBuilderIndexOfMethod)
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/StringBuilder. If it has
+// already been initialized, returns the existing copy.
+func GetStringBuilderClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if stringBuilderClass != nil {
+		return stringBuilderClass, nil
+	}
+	toReturn, e := newStringBuilderClass(jvm, "java/lang/StringBuilder",
+		false)
+	if e != nil {
+		return nil, e
+	}
+	stringBuilderClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/StringBuffer, which is the
+// same as StringBuilder except that its methods are synchronized. If it has
+// already been initialized, returns the existing copy.
+func GetStringBufferClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if stringBufferClass != nil {
+		return stringBufferClass, nil
+	}
+	toReturn, e := newStringBuilderClass(jvm, "java/lang/StringBuffer", true)
+	if e != nil {
+		return nil, e
+	}
+	stringBufferClass = toReturn
+	return toReturn, nil
+}
@@ -0,0 +1,239 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// Emits code creating a new instance of the StringBuilder or StringBuffer
+// class, with the given initial contents.
+func emitNewStringBuilder(m *builder.MethodBuilder, className, s string) {
+	m.EmitType(builder.New, className)
+	m.Emit(builder.Dup)
+	m.EmitString(s)
+	m.EmitInvoke(builder.Invokespecial, className, "<init>",
+		"(Ljava/lang/String;)V")
+}
+
+// Emits code creating a char[] array containing the characters in s, which
+// must all be in the basic multilingual plane.
+func emitCharArray(m *builder.MethodBuilder, s string) {
+	chars := []rune(s)
+	m.EmitInt(int32(len(chars)))
+	// T_CHAR
+	m.Emit(builder.Newarray, 5)
+	for i, c := range chars {
+		m.Emit(builder.Dup)
+		m.EmitInt(int32(i))
+		m.EmitInt(int32(c))
+		m.Emit(builder.Castore)
+	}
+}
+
+func TestStringBuilderMethods(t *testing.T) {
+	// Each test is run using both StringBuilder and StringBuffer. The emit
+	// functions are given the name of the class being tested, and the name
+	// of the test class, which they must report their results to.
+	tests := []struct {
+		name      string
+		emit      func(m *builder.MethodBuilder, sb, className string)
+		expected  []string
+		exception string
+		message   string
+	}{
+		{"Append", func(m *builder.MethodBuilder, sb, className string) {
+			self := "L" + sb + ";"
+			emitNewStringBuilder(m, sb, "")
+			m.Emit(builder.Aconst_null)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append",
+				"(Ljava/lang/Object;)"+self)
+			m.Emit(builder.Aconst_null)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append",
+				"(Ljava/lang/String;)"+self)
+			emitCharArray(m, "hi")
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "([C)"+self)
+			m.EmitInt(1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(Z)"+self)
+			m.EmitInt('c')
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(C)"+self)
+			m.EmitInt(42)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(I)"+self)
+			m.EmitLong(-1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(J)"+self)
+			m.EmitFloat(1.5)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(F)"+self)
+			m.EmitDouble(1e10)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "(D)"+self)
+			m.EmitString("abcd")
+			m.EmitInt(1)
+			m.EmitInt(3)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append",
+				"(Ljava/lang/CharSequence;II)"+self)
+			// Unlike the CharSequence version, this takes an offset and a
+			// length.
+			emitCharArray(m, "xyz")
+			m.EmitInt(1)
+			m.EmitInt(2)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append", "([CII)"+self)
+			m.EmitInt(0x1f600)
+			m.EmitInvoke(builder.Invokevirtual, sb, "appendCodePoint",
+				"(I)"+self)
+			emitNewStringBuilder(m, "java/lang/StringBuffer", "!")
+			m.EmitInvoke(builder.Invokevirtual, sb, "append",
+				"(Ljava/lang/StringBuffer;)"+self)
+			emitReport(m, className, self)
+		}, []string{"nullnullhitruec42-11.51.0E10bcyz\U0001F600!"}, "", ""},
+		{"AppendRange", func(m *builder.MethodBuilder, sb,
+			className string) {
+			emitNewStringBuilder(m, sb, "")
+			m.EmitString("abc")
+			m.EmitInt(2)
+			m.EmitInt(4)
+			m.EmitInvoke(builder.Invokevirtual, sb, "append",
+				"(Ljava/lang/CharSequence;II)L"+sb+";")
+			emitReport(m, className, "L"+sb+";")
+		}, nil, "java/lang/IndexOutOfBoundsException",
+			"start 2, end 4, length 3"},
+		{"Insert", func(m *builder.MethodBuilder, sb, className string) {
+			self := "L" + sb + ";"
+			emitNewStringBuilder(m, sb, "ace")
+			m.EmitInt(1)
+			m.EmitInt('b')
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert", "(IC)"+self)
+			m.EmitInt(3)
+			m.EmitString("d")
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert",
+				"(ILjava/lang/String;)"+self)
+			// Inserting at the end is allowed.
+			m.EmitInt(5)
+			m.EmitInt(7)
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert", "(II)"+self)
+			m.EmitInt(0)
+			m.EmitInt(1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert", "(IZ)"+self)
+			emitReport(m, className, self)
+		}, []string{"trueabcde7"}, "", ""},
+		{"InsertPastEnd", func(m *builder.MethodBuilder, sb,
+			className string) {
+			emitNewStringBuilder(m, sb, "ab")
+			m.EmitInt(3)
+			m.EmitString("x")
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert",
+				"(ILjava/lang/String;)L"+sb+";")
+			m.Emit(builder.Pop)
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"offset 3, length 2"},
+		{"InsertNegative", func(m *builder.MethodBuilder, sb,
+			className string) {
+			emitNewStringBuilder(m, sb, "ab")
+			m.EmitInt(-1)
+			m.EmitString("x")
+			m.EmitInvoke(builder.Invokevirtual, sb, "insert",
+				"(ILjava/lang/String;)L"+sb+";")
+			m.Emit(builder.Pop)
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"offset -1, length 2"},
+		{"Reverse", func(m *builder.MethodBuilder, sb, className string) {
+			// Surrogate pairs keep their order, but unpaired surrogates are
+			// reversed like any other character, even if that makes them
+			// form a new pair. The strings use modified UTF-8 to encode
+			// unpaired surrogates.
+			for _, s := range []string{"a\U0001F600b",
+				"x\xed\xb0\x80\xed\xa0\x80y"} {
+				emitNewStringBuilder(m, sb, s)
+				m.EmitInvoke(builder.Invokevirtual, sb, "reverse",
+					"()L"+sb+";")
+				m.EmitInvoke(builder.Invokevirtual, sb, "toString",
+					"()Ljava/lang/String;")
+				m.Emit(builder.Dup)
+				emitReport(m, className, "Ljava/lang/String;")
+				m.EmitInt(1)
+				emitStringCall(m, "codePointAt", "(I)I")
+				emitReport(m, className, "I")
+			}
+		}, []string{"b\U0001F600a", "128512", "y\U00010000x", "65536"}, "",
+			""},
+		{"SetLength", func(m *builder.MethodBuilder, sb, className string) {
+			// Shortening the contents and then extending them again must
+			// pad them with null characters, rather than restoring the
+			// original contents.
+			emitNewStringBuilder(m, sb, "abc")
+			m.Emit(builder.Dup)
+			m.EmitInt(1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "setLength", "(I)V")
+			m.Emit(builder.Dup)
+			m.EmitInt(3)
+			m.EmitInvoke(builder.Invokevirtual, sb, "setLength", "(I)V")
+			m.Emit(builder.Dup)
+			m.EmitInvoke(builder.Invokevirtual, sb, "length", "()I")
+			emitReport(m, className, "I")
+			emitReport(m, className, "L"+sb+";")
+		}, []string{"3", "a\x00\x00"}, "", ""},
+		{"SetLengthNegative", func(m *builder.MethodBuilder, sb,
+			className string) {
+			emitNewStringBuilder(m, sb, "abc")
+			m.EmitInt(-1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "setLength", "(I)V")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"String index out of range: -1"},
+		{"DeleteCharAt", func(m *builder.MethodBuilder, sb,
+			className string) {
+			emitNewStringBuilder(m, sb, "abc")
+			m.EmitInt(1)
+			m.EmitInvoke(builder.Invokevirtual, sb, "deleteCharAt",
+				"(I)L"+sb+";")
+			m.Emit(builder.Dup)
+			emitReport(m, className, "L"+sb+";")
+			m.EmitInt(2)
+			m.EmitInvoke(builder.Invokevirtual, sb, "deleteCharAt",
+				"(I)L"+sb+";")
+			m.Emit(builder.Pop)
+		}, []string{"ac"}, "java/lang/StringIndexOutOfBoundsException",
+			"index 2,length 2"},
+	}
+	for _, sb := range []string{"java/lang/StringBuilder",
+		"java/lang/StringBuffer"} {
+		for _, test := range tests {
+			className := "StringBuilderTest" + test.name
+			if sb == "java/lang/StringBuffer" {
+				className = "StringBufferTest" + test.name
+			}
+			b := newTestClassBuilder(className)
+			m := b.AddMethod(0x0009, "run", "()V")
+			test.emit(m, sb, className)
+			m.Emit(builder.Return)
+			results, e := runTestClass(t, b)
+			if test.exception != "" {
+				checkThrown(t, e, test.exception, test.message)
+			} else if e != nil {
+				t.Logf("%s failed: %s\n", className, e)
+				t.Fail()
+				continue
+			}
+			checkReported(t, results, test.expected)
+		}
+	}
+}
+
+func TestStringBufferSynchronized(t *testing.T) {
+	jvm := getTestJVM(t)
+	for _, name := range []string{"java/lang/StringBuilder",
+		"java/lang/StringBuffer"} {
+		c, e := jvm.GetClass(name)
+		if e != nil {
+			t.Logf("Failed getting %s: %s\n", name, e)
+			t.FailNow()
+		}
+		synchronized := name == "java/lang/StringBuffer"
+		for key, m := range c.Methods {
+			if m.Name == "<init>" {
+				continue
+			}
+			if m.AccessFlags.IsSynchronized() != synchronized {
+				t.Logf("%s.%s has incorrect access flags: %s\n", name, key,
+					m.AccessFlags)
+				t.Fail()
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"reflect"
+	"regexp"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestSplitUTF16(t *testing.T) {
+	// Unpaired surrogates must be preserved, and supplementary characters
+	// must be matched as a single character.
+	chars := []uint16{'a', 0xd800, ',', 0xdc00, 'b', ','}
+	chars = append(chars, utf16.Encode([]rune("\U0001F600,c"))...)
+	expected := [][]uint16{
+		{'a', 0xd800},
+		{0xdc00, 'b'},
+		utf16.Encode([]rune("\U0001F600")),
+		{'c'},
+	}
+	results := splitUTF16(chars, regexp.MustCompile(","), 0)
+	if !reflect.DeepEqual(results, expected) {
+		t.Logf("Expected %v, got %v\n", expected, results)
+		t.Fail()
+	}
+	chars = utf16.Encode([]rune("x\U0001F600y\U0001F600"))
+	expected = [][]uint16{{'x'}, {'y'}}
+	results = splitUTF16(chars, regexp.MustCompile("\U0001F600"), 0)
+	if !reflect.DeepEqual(results, expected) {
+		t.Logf("Expected %v, got %v\n", expected, results)
+		t.Fail()
+	}
+	// Appending to one part must not overwrite the next.
+	chars = []uint16{'a', ',', 'b'}
+	results = splitUTF16(chars, regexp.MustCompile(","), 0)
+	_ = append(results[0], 'z')
+	if chars[1] != ',' {
+		t.Logf("Appending to a split result modified the original string\n")
+		t.Fail()
+	}
+}
+
+func TestSplitUnsupportedRegex(t *testing.T) {
+	b := newTestClassBuilder("SplitLookahead")
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitString("a1b")
+	m.EmitString("(?=1)")
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/String", "split",
+		"(Ljava/lang/String;)[Ljava/lang/String;")
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	_, e := runTestClass(t, b)
+	_, reError := regexp.Compile("(?=1)")
+	checkThrown(t, e, "java/util/regex/PatternSyntaxException",
+		"Unsupported or invalid regular expression: "+reError.Error())
+}
+
+// Emits code calling the named method on the String on top of the stack.
+func emitStringCall(m *builder.MethodBuilder, name, descriptor string) {
+	m.EmitInvoke(builder.Invokevirtual, "java/lang/String", name, descriptor)
+}
+
+// Emits code splitting s around matches of regex, and reporting the number of
+// strings in the result, followed by the strings joined with "|". If limit is
+// nil, split(String) is used rather than split(String, int).
+func emitSplit(m *builder.MethodBuilder, className, s, regex string,
+	limit *int32) {
+	m.EmitString(s)
+	m.EmitString(regex)
+	if limit == nil {
+		emitStringCall(m, "split", "(Ljava/lang/String;)[Ljava/lang/String;")
+	} else {
+		m.EmitInt(*limit)
+		emitStringCall(m, "split",
+			"(Ljava/lang/String;I)[Ljava/lang/String;")
+	}
+	m.EmitLocal(builder.Astore, 0)
+	m.EmitLocal(builder.Aload, 0)
+	m.Emit(builder.Arraylength)
+	emitReport(m, className, "I")
+	m.EmitString("|")
+	m.EmitLocal(builder.Aload, 0)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/String", "join",
+		"(Ljava/lang/CharSequence;[Ljava/lang/CharSequence;)"+
+			"Ljava/lang/String;")
+	emitReport(m, className, "Ljava/lang/String;")
+}
+
+func TestStringMethods(t *testing.T) {
+	// Each test emits code that reports its results, or throws an exception.
+	// Strings containing "\xed" use modified UTF-8 to encode surrogates.
+	limit := func(v int32) *int32 {
+		return &v
+	}
+	tests := []struct {
+		name      string
+		emit      func(m *builder.MethodBuilder, className string)
+		expected  []string
+		exception string
+		message   string
+	}{
+		{"CharAt", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("abc")
+			m.EmitInt(2)
+			emitStringCall(m, "charAt", "(I)C")
+			emitReport(m, className, "C")
+		}, []string{"c"}, "", ""},
+		{"CharAtEnd", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("abc")
+			m.EmitInt(3)
+			emitStringCall(m, "charAt", "(I)C")
+			emitReport(m, className, "C")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"Index 3 out of bounds for length 3"},
+		{"CharAtNegative", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("abc")
+			m.EmitInt(-1)
+			emitStringCall(m, "charAt", "(I)C")
+			emitReport(m, className, "C")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"Index -1 out of bounds for length 3"},
+		{"Substring", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("hello")
+			m.EmitInt(1)
+			m.EmitInt(3)
+			emitStringCall(m, "substring", "(II)Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+			m.EmitString("hello")
+			m.EmitInt(5)
+			emitStringCall(m, "substring", "(I)Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+		}, []string{"el", ""}, "", ""},
+		{"SubstringPastEnd", func(m *builder.MethodBuilder,
+			className string) {
+			m.EmitString("hello")
+			m.EmitInt(6)
+			emitStringCall(m, "substring", "(I)Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"begin 6, end 5, length 5"},
+		{"SubstringReversed", func(m *builder.MethodBuilder,
+			className string) {
+			m.EmitString("hello")
+			m.EmitInt(3)
+			m.EmitInt(2)
+			emitStringCall(m, "substring", "(II)Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"begin 3, end 2, length 5"},
+		{"SubstringNegative", func(m *builder.MethodBuilder,
+			className string) {
+			m.EmitString("hello")
+			m.EmitInt(-1)
+			m.EmitInt(2)
+			emitStringCall(m, "substring", "(II)Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"begin -1, end 2, length 5"},
+		{"IndexOf", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("banana")
+			m.EmitInt('a')
+			m.EmitInt(2)
+			emitStringCall(m, "indexOf", "(II)I")
+			emitReport(m, className, "I")
+			m.EmitString("banana")
+			m.EmitString("na")
+			m.EmitInt(-5)
+			emitStringCall(m, "indexOf", "(Ljava/lang/String;I)I")
+			emitReport(m, className, "I")
+			m.EmitString("banana")
+			m.EmitString("a")
+			m.EmitInt(10)
+			emitStringCall(m, "indexOf", "(Ljava/lang/String;I)I")
+			emitReport(m, className, "I")
+			// Like Java, the empty string is found at the end of the string,
+			// even if the index is past the end.
+			m.EmitString("banana")
+			m.EmitString("")
+			m.EmitInt(10)
+			emitStringCall(m, "indexOf", "(Ljava/lang/String;I)I")
+			emitReport(m, className, "I")
+			m.EmitString("a\U0001F600b")
+			m.EmitInt(0x1f600)
+			emitStringCall(m, "indexOf", "(I)I")
+			emitReport(m, className, "I")
+		}, []string{"3", "2", "-1", "6", "1"}, "", ""},
+		{"SplitTrailing", func(m *builder.MethodBuilder, className string) {
+			emitSplit(m, className, "a,b,,", ",", nil)
+			emitSplit(m, className, "a,b,,", ",", limit(-1))
+			emitSplit(m, className, ",", ",", nil)
+		}, []string{"2", "a|b", "4", "a|b||", "0", ""}, "", ""},
+		{"SplitLeading", func(m *builder.MethodBuilder, className string) {
+			// A leading empty string is only included if the match at the
+			// start of the string isn't empty.
+			emitSplit(m, className, ",a", ",", nil)
+			emitSplit(m, className, "abc", "", nil)
+			emitSplit(m, className, "", ",", nil)
+		}, []string{"2", "|a", "3", "a|b|c", "1", ""}, "", ""},
+		{"SplitLimit", func(m *builder.MethodBuilder, className string) {
+			emitSplit(m, className, "a1b22c", "[0-9]+", limit(2))
+			emitSplit(m, className, "a.b", "\\.", nil)
+		}, []string{"2", "a|b22c", "2", "a|b"}, "", ""},
+		{"CompareToIgnoreCase", func(m *builder.MethodBuilder,
+			className string) {
+			pairs := [][2]string{{"Hello", "hELLO"}, {"a", "B"},
+				{"abc", "ABCD"}, {"b", "A"}, {"İ", "i"}}
+			for _, pair := range pairs {
+				m.EmitString(pair[0])
+				m.EmitString(pair[1])
+				emitStringCall(m, "compareToIgnoreCase",
+					"(Ljava/lang/String;)I")
+				emitReport(m, className, "I")
+			}
+		}, []string{"0", "-1", "-1", "1", "0"}, "", ""},
+		{"CodePointAt", func(m *builder.MethodBuilder, className string) {
+			for i := int32(0); i < 4; i++ {
+				// "a", followed by U+1F600 and an unpaired high surrogate.
+				m.EmitString("a\xed\xa0\xbd\xed\xb8\x80\xed\xa0\xbd")
+				m.EmitInt(i)
+				emitStringCall(m, "codePointAt", "(I)I")
+				emitReport(m, className, "I")
+			}
+		}, []string{"97", "128512", "56832", "55357"}, "", ""},
+		{"CodePointAtEnd", func(m *builder.MethodBuilder, className string) {
+			m.EmitString("a\U0001F600")
+			m.EmitInt(3)
+			emitStringCall(m, "codePointAt", "(I)I")
+			emitReport(m, className, "I")
+		}, nil, "java/lang/StringIndexOutOfBoundsException",
+			"Index 3 out of bounds for length 3"},
+		{"ToUpperCase", func(m *builder.MethodBuilder, className string) {
+			// U+10428 is the lower case version of U+10400, and both are
+			// encoded using surrogate pairs.
+			m.EmitString("x\U00010428y")
+			emitStringCall(m, "toUpperCase", "()Ljava/lang/String;")
+			emitReport(m, className, "Ljava/lang/String;")
+			// Unpaired surrogates are left unchanged.
+			m.EmitString("a\xed\xa0\x81b")
+			emitStringCall(m, "toUpperCase", "()Ljava/lang/String;")
+			m.Emit(builder.Dup)
+			emitStringCall(m, "length", "()I")
+			emitReport(m, className, "I")
+			m.EmitInt(1)
+			emitStringCall(m, "codePointAt", "(I)I")
+			emitReport(m, className, "I")
+		}, []string{"X\U00010400Y", "3", "55297"}, "", ""},
+	}
+	for _, test := range tests {
+		className := "StringTest" + test.name
+		b := newTestClassBuilder(className)
+		m := b.AddMethod(0x0009, "run", "()V")
+		test.emit(m, className)
+		m.Emit(builder.Return)
+		results, e := runTestClass(t, b)
+		if test.exception != "" {
+			checkThrown(t, e, test.exception, test.message)
+			continue
+		}
+		if e != nil {
+			t.Logf("%s failed: %s\n", className, e)
+			t.Fail()
+			continue
+		}
+		checkReported(t, results, test.expected)
+	}
+}
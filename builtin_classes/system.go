@@ -10,27 +10,29 @@ import (
 	"os"
 )
 
-// Returns a BS-JVM class implementing java/lang/System.
+// Returns a BS-JVM class implementing java/lang/System. System.out and
+// System.err write to the process' stdout and stderr, and System.in reads
+// from its stdin.
 func GetSystemClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
 	ps, e := GetPrintStreamClass(jvm)
 	if e != nil {
 		return nil, fmt.Errorf("Failed getting PrintStream class: %w", e)
 	}
-	// For an instance of the builtin PrintStream class, we don't need to use
-	// any fields; we'll instead just set the output to os.Stdout.
-	out := &bs_jvm.ClassInstance{
-		C: ps,
-		NativeData: &internalPrintStream{
-			w:         os.Stdout,
-			lastError: nil,
-		},
+	is, e := GetInputStreamClass(jvm)
+	if e != nil {
+		return nil, fmt.Errorf("Failed getting InputStream class: %w", e)
 	}
 	toReturn := GetEmptyClass(jvm, "java/lang/System")
 	publicStatic := class_file.FieldAccessFlags(1 | 8)
-	AppendStaticField(toReturn, "out", publicStatic,
-		class_file.ClassInstanceType("java/lang/PrintStream"), out)
-	// TODO (next): Start populating java/lang/System. Start with printf,
-	// probably (or whatever's needed for the RandomDots test).
-	//  - Will need some way to represent a native method.
+	printStreamType := class_file.ClassInstanceType("java/io/PrintStream")
+	AppendStaticField(toReturn, "out", publicStatic, printStreamType,
+		newPrintStream(ps, os.Stdout))
+	AppendStaticField(toReturn, "err", publicStatic, printStreamType,
+		newPrintStream(ps, os.Stderr))
+	AppendStaticField(toReturn, "in", publicStatic,
+		class_file.ClassInstanceType("java/io/InputStream"),
+		newInputStream(is, os.Stdin))
+	// TODO: Continue populating java/lang/System, e.g. with arraycopy and
+	// currentTimeMillis.
 	return toReturn, nil
 }
@@ -0,0 +1,521 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.Thread and java.lang.Runnable.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+	"sync"
+)
+
+// An initialized version of the builtin Thread class.
+var threadClass *bs_jvm.Class
+
+// An initialized version of the builtin Runnable interface.
+var runnableClass *bs_jvm.Class
+
+// Holds internal state for instances of the Thread class.
+type internalThread struct {
+	// Protects the other fields, since they may be accessed by any thread.
+	mutex sync.Mutex
+	// The thread's name.
+	name string
+	// If true, the JVM won't wait for this thread to exit.
+	daemon bool
+	// The Runnable passed to the constructor, or nil if there wasn't one.
+	target bs_jvm.Object
+	// The JVM thread running this Thread. This will be nil until the thread
+	// is started.
+	thread *bs_jvm.Thread
+	// This will be true if start() has been called.
+	started bool
+}
+
+// Returns the key for the run() method in both Runnable and Thread.
+func getRunMethodKey() string {
+	return bs_jvm.GetMethodKey(&class_file.Method{
+		Access: 1,
+		Name:   []byte("run"),
+		Descriptor: &class_file.MethodDescriptor{
+			ArgumentTypes: []class_file.FieldType{},
+			ReturnType:    class_file.PrimitiveFieldType('V'),
+		},
+	})
+}
+
+// Returns the Thread instance's internal state. Returns an error if the
+// instance hasn't been initialized by one of Thread's constructors.
+func getInternalThread(instance *bs_jvm.ClassInstance) (*internalThread,
+	error) {
+	toReturn, ok := instance.NativeData.(*internalThread)
+	if !ok {
+		return nil, bs_jvm.NullReferenceError("Got uninitialized Thread " +
+			"instance")
+	}
+	return toReturn, nil
+}
+
+// Pops an instance of Thread, or one of its subclasses, from the stack. If
+// checkInitialized is true, this also returns an error if the instance hasn't
+// been initialized.
+func popThreadInstance(t *bs_jvm.Thread,
+	checkInitialized bool) (*bs_jvm.ClassInstance, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, fmt.Errorf("Failed popping Thread instance: %w", e)
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, bs_jvm.TypeError("Didn't get class instance")
+	}
+	if !instance.C.IsSubclassOf(threadClass) {
+		return nil, bs_jvm.TypeError("Didn't get Thread instance")
+	}
+	if checkInitialized {
+		_, e = getInternalThread(instance)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return instance, nil
+}
+
+// Pops a Thread instance from the stack, and returns its internal state.
+func popInternalThread(t *bs_jvm.Thread) (*internalThread, error) {
+	instance, e := popThreadInstance(t, true)
+	if e != nil {
+		return nil, e
+	}
+	return getInternalThread(instance)
+}
+
+// Initializes a newly constructed Thread instance. The target may be nil, and
+// the name may be empty, in which case a default name is used. Like Java, new
+// threads are daemon threads if the thread creating them is a daemon thread.
+func initThread(t *bs_jvm.Thread, instance *bs_jvm.ClassInstance,
+	target bs_jvm.Object, name string) {
+	if name == "" {
+		name = t.ParentJVM.NextThreadName()
+	}
+	instance.NativeData = &internalThread{
+		name:   name,
+		daemon: t.Daemon,
+		target: target,
+	}
+}
+
+// Implements the Thread() constructor.
+func noArgsThreadConstructor(t *bs_jvm.Thread) error {
+	instance, e := popThreadInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initThread(t, instance, nil, "")
+	return nil
+}
+
+// Implements the Thread(Runnable) constructor.
+func runnableThreadConstructor(t *bs_jvm.Thread) error {
+	target, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThreadInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initThread(t, instance, target, "")
+	return nil
+}
+
+// Pops a String reference that must not be null.
+func popStringNotNull(t *bs_jvm.Thread) (string, error) {
+	s, e := popStringObject(t)
+	if e != nil {
+		return "", e
+	}
+	return s.Value(), nil
+}
+
+// Implements the Thread(Runnable, String) constructor.
+func runnableNameThreadConstructor(t *bs_jvm.Thread) error {
+	name, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	target, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThreadInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initThread(t, instance, target, name)
+	return nil
+}
+
+// Implements the Thread(String) constructor.
+func nameThreadConstructor(t *bs_jvm.Thread) error {
+	name, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThreadInstance(t, false)
+	if e != nil {
+		return e
+	}
+	initThread(t, instance, nil, name)
+	return nil
+}
+
+// Returns the run() method that should be invoked on the given object, which
+// must implement Runnable.
+func selectRunMethod(o bs_jvm.Object) (*bs_jvm.Method, error) {
+	instance, ok := o.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, bs_jvm.TypeError(o.TypeName() + " isn't Runnable")
+	}
+	if !instance.C.IsSubclassOf(runnableClass) {
+		return nil, bs_jvm.IncompatibleClassChangeError(fmt.Sprintf("%s "+
+			"doesn't implement java/lang/Runnable", instance.C.Name))
+	}
+	resolved, e := runnableClass.ResolveInterfaceMethod(getRunMethodKey())
+	if e != nil {
+		return nil, e
+	}
+	return instance.C.SelectMethod(resolved)
+}
+
+// Implements the run() method, which calls the target's run() method if a
+// target was given to the constructor. Subclasses of Thread may override
+// this.
+func threadRunMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	state.mutex.Lock()
+	target := state.target
+	state.mutex.Unlock()
+	if target == nil {
+		return nil
+	}
+	method, e := selectRunMethod(target)
+	if e != nil {
+		return e
+	}
+	e = t.Stack.PushRef(target)
+	if e != nil {
+		return e
+	}
+	// The target's run() method returns directly to the caller of this one.
+	return t.Call(method)
+}
+
+// Implements the start() method.
+func threadStartMethod(t *bs_jvm.Thread) error {
+	instance, e := popThreadInstance(t, true)
+	if e != nil {
+		return e
+	}
+	state, _ := getInternalThread(instance)
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.started {
+		return t.Throw("java/lang/IllegalThreadStateException",
+			"Thread already started")
+	}
+	// Start the new thread in the run() method that would be invoked on the
+	// Thread instance. If that's the builtin run() method, skip directly to
+	// the target's run() method instead, since threads can't start in native
+	// methods.
+	toRun, e := instance.C.SelectMethod(threadClass.Methods[getRunMethodKey()])
+	if e != nil {
+		return e
+	}
+	receiver := bs_jvm.Object(instance)
+	if toRun.Native != nil {
+		if state.target == nil {
+			// There's nothing to run, so the new thread would exit
+			// immediately.
+			state.started = true
+			return nil
+		}
+		receiver = state.target
+		toRun, e = selectRunMethod(receiver)
+		if e != nil {
+			return e
+		}
+	}
+	newThread, e := t.ParentJVM.NewThread(toRun, state.name, receiver)
+	if e != nil {
+		return fmt.Errorf("Failed creating thread %s: %w", state.name, e)
+	}
+	newThread.Daemon = state.daemon
+	newThread.JavaThread = instance
+	e = newThread.Start()
+	if e != nil {
+		return fmt.Errorf("Failed starting thread %s: %w", state.name, e)
+	}
+	state.thread = newThread
+	state.started = true
+	return nil
+}
+
+// Returns the JVM thread running the Thread, or nil if it hasn't been started.
+func (s *internalThread) getThread() *bs_jvm.Thread {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.thread
+}
+
+// Waits for the Thread on top of the stack to exit, or for the timeout to
+// expire. A timeout of 0 waits indefinitely.
+func joinWithTimeout(t *bs_jvm.Thread, timeout bs_jvm.Long) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	if timeout < 0 {
+		return bs_jvm.IllegalArgumentError("timeout value is negative")
+	}
+	thread := state.getThread()
+	if thread == nil {
+		// Joining a thread that hasn't started returns immediately.
+		return nil
+	}
+	return t.BlockOn(thread.Done(), millisToDuration(timeout), "join")
+}
+
+// Implements the join() method.
+func joinMethod(t *bs_jvm.Thread) error {
+	return joinWithTimeout(t, 0)
+}
+
+// Implements the join(long) method.
+func joinTimeoutMethod(t *bs_jvm.Thread) error {
+	timeout, e := t.Stack.PopLong()
+	if e != nil {
+		return e
+	}
+	return joinWithTimeout(t, timeout)
+}
+
+// Implements the static sleep(long) method.
+func sleepMethod(t *bs_jvm.Thread) error {
+	millis, e := t.Stack.PopLong()
+	if e != nil {
+		return e
+	}
+	if millis < 0 {
+		return bs_jvm.IllegalArgumentError("timeout value is negative")
+	}
+	return t.Sleep(millisToDuration(millis))
+}
+
+// Implements the static currentThread() method. Creates a Thread instance
+// for threads that weren't started by Java code, such as the main thread.
+func currentThreadMethod(t *bs_jvm.Thread) error {
+	if t.JavaThread == nil {
+		instance, e := threadClass.CreateInstance()
+		if e != nil {
+			return e
+		}
+		instance.NativeData = &internalThread{
+			name:    t.GetName(),
+			daemon:  t.Daemon,
+			thread:  t,
+			started: true,
+		}
+		t.JavaThread = instance
+	}
+	return t.Stack.PushRef(t.JavaThread)
+}
+
+// Implements the getName() method.
+func getNameMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	state.mutex.Lock()
+	name := state.name
+	state.mutex.Unlock()
+	return t.Stack.PushRef(bs_jvm.NewStringObject(name))
+}
+
+// Implements the setName(String) method.
+func setNameMethod(t *bs_jvm.Thread) error {
+	name, e := popStringNotNull(t)
+	if e != nil {
+		return e
+	}
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	state.mutex.Lock()
+	state.name = name
+	if state.thread != nil {
+		state.thread.SetName(name)
+	}
+	state.mutex.Unlock()
+	return nil
+}
+
+// Implements the interrupt() method. Interrupting a thread that hasn't been
+// started has no effect.
+func interruptMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	thread := state.getThread()
+	if thread != nil {
+		thread.Interrupt()
+	}
+	return nil
+}
+
+// Implements the isInterrupted() method.
+func isInterruptedMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	thread := state.getThread()
+	if (thread != nil) && thread.IsInterrupted() {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Implements the static interrupted() method, which clears the current
+// thread's interrupted status.
+func interruptedMethod(t *bs_jvm.Thread) error {
+	if t.ClearInterrupt() {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Implements the isAlive() method.
+func isAliveMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	thread := state.getThread()
+	if (thread != nil) && !thread.HasExited() {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Implements the setDaemon(boolean) method.
+func setDaemonMethod(t *bs_jvm.Thread) error {
+	daemon, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.started {
+		return t.Throw("java/lang/IllegalThreadStateException",
+			"Can't change daemon status after starting a thread")
+	}
+	state.daemon = daemon != 0
+	return nil
+}
+
+// Implements the isDaemon() method.
+func isDaemonMethod(t *bs_jvm.Thread) error {
+	state, e := popInternalThread(t)
+	if e != nil {
+		return e
+	}
+	state.mutex.Lock()
+	daemon := state.daemon
+	state.mutex.Unlock()
+	if daemon {
+		return t.Stack.Push(1)
+	}
+	return t.Stack.Push(0)
+}
+
+// Returns a BS-JVM class implementing the java/lang/Runnable interface. If it
+// has already been initialized, returns the existing copy.
+func GetRunnableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if runnableClass != nil {
+		return runnableClass, nil
+	}
+	toReturn := GetEmptyClass(jvm, "java/lang/Runnable")
+	// public interface abstract
+	toReturn.AccessFlags = 0x0601
+	// public abstract
+	AddMethod(toReturn, "run", 0x0401, []class_file.FieldType{},
+		class_file.PrimitiveFieldType('V'), nil)
+	runnableClass = toReturn
+	return toReturn, nil
+}
+
+// Returns a BS-JVM class implementing java/lang/Thread. If it has already been
+// initialized, returns the existing copy.
+func GetThreadClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if threadClass != nil {
+		return threadClass, nil
+	}
+	runnable, e := GetRunnableClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	runnableType := class_file.ClassInstanceType("java/lang/Runnable")
+	threadType := class_file.ClassInstanceType("java/lang/Thread")
+	voidType := class_file.PrimitiveFieldType('V')
+	booleanType := class_file.PrimitiveFieldType('Z')
+	longType := class_file.PrimitiveFieldType('J')
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/Thread")
+	toReturn.Interfaces = []*bs_jvm.Class{runnable}
+	AddConstructor(toReturn, 1, noArgs, noArgsThreadConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{runnableType},
+		runnableThreadConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{runnableType,
+		stringType}, runnableNameThreadConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{stringType},
+		nameThreadConstructor)
+	AddMethod(toReturn, "run", 1, noArgs, voidType, threadRunMethod)
+	// public synchronized
+	AddMethod(toReturn, "start", 0x0021, noArgs, voidType, threadStartMethod)
+	// public final
+	AddMethod(toReturn, "join", 0x0011, noArgs, voidType, joinMethod)
+	AddMethod(toReturn, "join", 0x0011, []class_file.FieldType{longType},
+		voidType, joinTimeoutMethod)
+	// public static
+	AddMethod(toReturn, "sleep", 0x0009, []class_file.FieldType{longType},
+		voidType, sleepMethod)
+	AddMethod(toReturn, "currentThread", 0x0009, noArgs, threadType,
+		currentThreadMethod)
+	AddMethod(toReturn, "interrupted", 0x0009, noArgs, booleanType,
+		interruptedMethod)
+	AddMethod(toReturn, "getName", 0x0011, noArgs, stringType, getNameMethod)
+	AddMethod(toReturn, "setName", 0x0011, []class_file.FieldType{stringType},
+		voidType, setNameMethod)
+	AddMethod(toReturn, "interrupt", 1, noArgs, voidType, interruptMethod)
+	AddMethod(toReturn, "isInterrupted", 1, noArgs, booleanType,
+		isInterruptedMethod)
+	AddMethod(toReturn, "isAlive", 0x0011, noArgs, booleanType,
+		isAliveMethod)
+	AddMethod(toReturn, "setDaemon", 0x0011,
+		[]class_file.FieldType{booleanType}, voidType, setDaemonMethod)
+	AddMethod(toReturn, "isDaemon", 0x0011, noArgs, booleanType,
+		isDaemonMethod)
+	threadClass = toReturn
+	return toReturn, nil
+}
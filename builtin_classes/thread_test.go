@@ -0,0 +1,44 @@
+package builtin_classes
+
+import (
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSleepForever(t *testing.T) {
+	jvm := getTestJVM(t)
+	className := "SleepForeverTest"
+	b := newTestClassBuilder(className)
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitLong(math.MaxInt64)
+	m.EmitInvoke(builder.Invokestatic, "java/lang/Thread", "sleep", "(J)V")
+	m.EmitString("woke")
+	emitReport(m, className, "Ljava/lang/String;")
+	m.Emit(builder.Return)
+	c := loadTestClass(t, b)
+	woke := make(chan bool, 1)
+	getNamedMethod(t, c, "report").Native = func(thread *bs_jvm.Thread) error {
+		woke <- true
+		_, e := thread.Stack.PopRef()
+		return e
+	}
+	thread, e := jvm.StartNamedThread(className, "void run()", "sleeper")
+	if e != nil {
+		t.Logf("Failed starting thread: %s\n", e)
+		t.FailNow()
+	}
+	// Sleeping for Long.MAX_VALUE milliseconds must not overflow into a
+	// negative duration and return immediately.
+	select {
+	case <-woke:
+		t.Logf("Thread.sleep(Long.MAX_VALUE) returned\n")
+		t.Fail()
+	case <-time.After(20 * time.Millisecond):
+	}
+	thread.Interrupt()
+	e = jvm.WaitForAllThreads()
+	checkThrown(t, e, "java/lang/InterruptedException", "sleep interrupted")
+}
@@ -0,0 +1,341 @@
+package builtin_classes
+
+// This file contains code implementing java.lang.Throwable and the standard
+// exception and error classes that the JVM itself may throw.
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm"
+	"github.com/yalue/bs_jvm/class_file"
+)
+
+// An initialized version of the builtin Throwable class.
+var throwableClass *bs_jvm.Class
+
+// Holds the initialized versions of Throwable and all of its builtin
+// subclasses.
+var throwableClasses []*bs_jvm.Class
+
+// Lists the builtin subclasses of Throwable, along with each one's superclass.
+// Each superclass must appear before its subclasses.
+var throwableSubclasses = []struct {
+	name       string
+	superclass string
+}{
+	{"java/lang/Exception", "java/lang/Throwable"},
+	{"java/lang/Error", "java/lang/Throwable"},
+	{"java/lang/RuntimeException", "java/lang/Exception"},
+	{"java/lang/ArithmeticException", "java/lang/RuntimeException"},
+	{"java/lang/NullPointerException", "java/lang/RuntimeException"},
+	{"java/lang/IndexOutOfBoundsException", "java/lang/RuntimeException"},
+	{"java/lang/ArrayIndexOutOfBoundsException",
+		"java/lang/IndexOutOfBoundsException"},
+	{"java/lang/StringIndexOutOfBoundsException",
+		"java/lang/IndexOutOfBoundsException"},
+	{"java/lang/IllegalArgumentException", "java/lang/RuntimeException"},
+	{"java/lang/NumberFormatException",
+		"java/lang/IllegalArgumentException"},
+	{"java/lang/IllegalThreadStateException",
+		"java/lang/IllegalArgumentException"},
+	{"java/util/regex/PatternSyntaxException",
+		"java/lang/IllegalArgumentException"},
+	{"java/util/IllegalFormatException",
+		"java/lang/IllegalArgumentException"},
+	{"java/util/DuplicateFormatFlagsException",
+		"java/util/IllegalFormatException"},
+	{"java/util/FormatFlagsConversionMismatchException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatArgumentIndexException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatCodePointException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatConversionException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatFlagsException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatPrecisionException",
+		"java/util/IllegalFormatException"},
+	{"java/util/IllegalFormatWidthException",
+		"java/util/IllegalFormatException"},
+	{"java/util/MissingFormatArgumentException",
+		"java/util/IllegalFormatException"},
+	{"java/util/MissingFormatWidthException",
+		"java/util/IllegalFormatException"},
+	{"java/util/UnknownFormatConversionException",
+		"java/util/IllegalFormatException"},
+	{"java/lang/IllegalStateException", "java/lang/RuntimeException"},
+	{"java/util/FormatterClosedException",
+		"java/lang/IllegalStateException"},
+	{"java/lang/ClassCastException", "java/lang/RuntimeException"},
+	{"java/lang/NegativeArraySizeException", "java/lang/RuntimeException"},
+	{"java/lang/ArrayStoreException", "java/lang/RuntimeException"},
+	{"java/lang/UnsupportedOperationException",
+		"java/lang/RuntimeException"},
+	{"java/lang/IllegalMonitorStateException", "java/lang/RuntimeException"},
+	{"java/lang/InterruptedException", "java/lang/Exception"},
+	{"java/lang/CloneNotSupportedException", "java/lang/Exception"},
+	{"java/io/IOException", "java/lang/Exception"},
+	{"java/lang/ReflectiveOperationException", "java/lang/Exception"},
+	{"java/lang/ClassNotFoundException",
+		"java/lang/ReflectiveOperationException"},
+	{"java/lang/LinkageError", "java/lang/Error"},
+	{"java/lang/IncompatibleClassChangeError", "java/lang/LinkageError"},
+	{"java/lang/AbstractMethodError",
+		"java/lang/IncompatibleClassChangeError"},
+	{"java/lang/IllegalAccessError",
+		"java/lang/IncompatibleClassChangeError"},
+	{"java/lang/NoSuchMethodError",
+		"java/lang/IncompatibleClassChangeError"},
+	{"java/lang/NoSuchFieldError", "java/lang/IncompatibleClassChangeError"},
+	{"java/lang/NoClassDefFoundError", "java/lang/LinkageError"},
+	{"java/lang/ExceptionInInitializerError", "java/lang/LinkageError"},
+	{"java/lang/VerifyError", "java/lang/LinkageError"},
+	{"java/lang/ClassCircularityError", "java/lang/LinkageError"},
+	{"java/lang/BootstrapMethodError", "java/lang/LinkageError"},
+	{"java/lang/VirtualMachineError", "java/lang/Error"},
+	{"java/lang/StackOverflowError", "java/lang/VirtualMachineError"},
+	{"java/lang/OutOfMemoryError", "java/lang/VirtualMachineError"},
+}
+
+// Pops an instance of Throwable, or one of its subclasses, from the stack.
+func popThrowableInstance(t *bs_jvm.Thread) (*bs_jvm.ClassInstance, error) {
+	tmp, e := bs_jvm.PopRefNotNull(t.Stack)
+	if e != nil {
+		return nil, fmt.Errorf("Failed popping Throwable instance: %w", e)
+	}
+	instance, ok := tmp.(*bs_jvm.ClassInstance)
+	if !ok {
+		return nil, bs_jvm.TypeError("Didn't get class instance")
+	}
+	if !instance.C.IsSubclassOf(throwableClass) {
+		return nil, bs_jvm.TypeError("Didn't get Throwable instance")
+	}
+	return instance, nil
+}
+
+// Pops a reference that may be null, converting any type of null reference to
+// a nil Object.
+func popNullableRef(t *bs_jvm.Thread) (bs_jvm.Object, error) {
+	toReturn, e := t.Stack.PopRef()
+	if e != nil {
+		return nil, e
+	}
+	if bs_jvm.IsNull(toReturn) {
+		return nil, nil
+	}
+	return toReturn, nil
+}
+
+// Sets the message and cause of a newly constructed Throwable, and fills in
+// its stack trace.
+func initThrowable(t *bs_jvm.Thread, instance *bs_jvm.ClassInstance,
+	message, cause bs_jvm.Object) {
+	info := bs_jvm.GetThrowableInfo(instance)
+	info.Message = message
+	info.Cause = cause
+	t.FillInStackTrace(instance)
+}
+
+// Implements the Throwable() constructor.
+func noArgsThrowableConstructor(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	initThrowable(t, instance, nil, nil)
+	return nil
+}
+
+// Implements the Throwable(String) constructor.
+func messageThrowableConstructor(t *bs_jvm.Thread) error {
+	message, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	initThrowable(t, instance, message, nil)
+	return nil
+}
+
+// Implements the Throwable(String, Throwable) constructor.
+func messageAndCauseThrowableConstructor(t *bs_jvm.Thread) error {
+	cause, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	message, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	initThrowable(t, instance, message, cause)
+	return nil
+}
+
+// Implements the Throwable(Throwable) constructor, which uses the cause's
+// string representation as the message.
+func causeThrowableConstructor(t *bs_jvm.Thread) error {
+	cause, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	var message bs_jvm.Object
+	causeInstance, ok := cause.(*bs_jvm.ClassInstance)
+	if ok {
+		message = bs_jvm.NewStringObject(bs_jvm.ThrowableToString(
+			causeInstance))
+	}
+	initThrowable(t, instance, message, cause)
+	return nil
+}
+
+// Implements getMessage() and getLocalizedMessage().
+func getMessageMethod(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.GetThrowableInfo(instance).Message)
+}
+
+// Implements getCause().
+func getCauseMethod(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(bs_jvm.GetThrowableInfo(instance).Cause)
+}
+
+// Implements initCause(Throwable), which returns the Throwable it was
+// invoked on.
+func initCauseMethod(t *bs_jvm.Thread) error {
+	cause, e := popNullableRef(t)
+	if e != nil {
+		return e
+	}
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	if cause == bs_jvm.Object(instance) {
+		return t.Throw("java/lang/IllegalArgumentException",
+			"Self-causation not permitted")
+	}
+	info := bs_jvm.GetThrowableInfo(instance)
+	if info.Cause != nil {
+		return t.Throw("java/lang/IllegalStateException",
+			"Can't overwrite cause")
+	}
+	info.Cause = cause
+	return t.Stack.PushRef(instance)
+}
+
+// Implements toString().
+func throwableToStringMethod(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	s := bs_jvm.ThrowableToString(instance)
+	return t.Stack.PushRef(bs_jvm.NewStringObject(s))
+}
+
+// Implements printStackTrace(), which writes to the JVM's ErrorSink.
+func printStackTraceMethod(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	sink := t.ParentJVM.ErrorSink
+	if sink == nil {
+		return nil
+	}
+	// Like the other print methods, errors writing the output are ignored.
+	bs_jvm.WriteStackTrace(sink, instance)
+	return nil
+}
+
+// Implements fillInStackTrace(), which returns the Throwable it was invoked
+// on.
+func fillInStackTraceMethod(t *bs_jvm.Thread) error {
+	instance, e := popThrowableInstance(t)
+	if e != nil {
+		return e
+	}
+	t.FillInStackTrace(instance)
+	return t.Stack.PushRef(instance)
+}
+
+// Returns a BS-JVM class implementing java/lang/Throwable. If it has already
+// been initialized, returns the existing copy.
+func GetThrowableClass(jvm *bs_jvm.JVM) (*bs_jvm.Class, error) {
+	if throwableClass != nil {
+		return throwableClass, nil
+	}
+	stringType := class_file.ClassInstanceType("java/lang/String")
+	throwableType := class_file.ClassInstanceType("java/lang/Throwable")
+	voidType := class_file.PrimitiveFieldType('V')
+	noArgs := []class_file.FieldType{}
+	toReturn := GetEmptyClass(jvm, "java/lang/Throwable")
+	AddConstructor(toReturn, 1, noArgs, noArgsThrowableConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{stringType},
+		messageThrowableConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{stringType,
+		throwableType}, messageAndCauseThrowableConstructor)
+	AddConstructor(toReturn, 1, []class_file.FieldType{throwableType},
+		causeThrowableConstructor)
+	AddMethod(toReturn, "getMessage", 1, noArgs, stringType,
+		getMessageMethod)
+	AddMethod(toReturn, "getLocalizedMessage", 1, noArgs, stringType,
+		getMessageMethod)
+	AddMethod(toReturn, "getCause", 1, noArgs, throwableType, getCauseMethod)
+	AddMethod(toReturn, "initCause", 1, []class_file.FieldType{throwableType},
+		throwableType, initCauseMethod)
+	AddMethod(toReturn, "toString", 1, noArgs, stringType,
+		throwableToStringMethod)
+	AddMethod(toReturn, "printStackTrace", 1, noArgs, voidType,
+		printStackTraceMethod)
+	AddMethod(toReturn, "fillInStackTrace", 1, noArgs, throwableType,
+		fillInStackTraceMethod)
+	throwableClass = toReturn
+	return toReturn, nil
+}
+
+// Returns java/lang/Throwable followed by all of its builtin subclasses, such
+// as java/lang/NullPointerException. The subclasses don't define any methods
+// of their own, so they inherit Throwable's constructors and methods.
+func GetThrowableClasses(jvm *bs_jvm.JVM) ([]*bs_jvm.Class, error) {
+	if throwableClasses != nil {
+		return throwableClasses, nil
+	}
+	throwable, e := GetThrowableClass(jvm)
+	if e != nil {
+		return nil, e
+	}
+	byName := make(map[string]*bs_jvm.Class)
+	byName[string(throwable.Name)] = throwable
+	toReturn := []*bs_jvm.Class{throwable}
+	for _, c := range throwableSubclasses {
+		superclass := byName[c.superclass]
+		if superclass == nil {
+			return nil, fmt.Errorf("Superclass %s of %s isn't defined yet",
+				c.superclass, c.name)
+		}
+		subclass := GetEmptyClass(jvm, c.name)
+		subclass.Super = superclass
+		byName[c.name] = subclass
+		toReturn = append(toReturn, subclass)
+	}
+	throwableClasses = toReturn
+	return toReturn, nil
+}
@@ -6,6 +6,7 @@ package bs_jvm
 import (
 	"fmt"
 	"github.com/yalue/bs_jvm/class_file"
+	"sync"
 )
 
 // Holds metadata used by the JVM when accessing fields of a class, static or
@@ -58,6 +59,59 @@ type Class struct {
 	// A reference to the class_file.Class object defining this class. May be
 	// nil for builtin classes.
 	File *class_file.Class
+	// The direct superclass of this class. This is nil for java/lang/Object,
+	// or if java/lang/Object hasn't been provided. (The superclass of an
+	// interface is always java/lang/Object.) The superclass' instance fields
+	// are laid out at the start of FieldTypes and FieldNames.
+	Super *Class
+	// The interfaces directly implemented by this class, or extended by this
+	// interface, in the order they're listed in the class file.
+	Interfaces []*Class
+	// Determines whether this is an interface, abstract, etc.
+	AccessFlags class_file.ClassAccessFlags
+	// Caches the results of method selection for invokevirtual and
+	// invokeinterface, mapping resolved methods to the method that's actually
+	// invoked for instances of this class. Access it using SelectMethod.
+	selectedMethods     map[*Method]*Method
+	selectedMethodsLock sync.Mutex
+	// The entries in the class file's BootstrapMethods attribute, which are
+	// referred to by invokedynamic instructions. Empty if the class doesn't
+	// have a BootstrapMethods attribute.
+	BootstrapMethods []class_file.BootstrapMethod
+	// Maps constant pool indices to the CONSTANT_Dynamic entries loaded by
+	// ldc instructions in this class. Access it using getDynamicConstant.
+	dynamicConstants     map[uint16]*dynamicConstant
+	dynamicConstantsLock sync.Mutex
+	// The host of the nest this class belongs to, which may be the class
+	// itself. Determined the first time it's needed; access it using NestHost.
+	nestHost     *Class
+	nestHostLock sync.Mutex
+	// Protects the class' initialization state, other than reads of
+	// initState using getInitState.
+	initLock sync.Mutex
+	// Holds a classInitState value. Only access this using getInitState and
+	// setInitState.
+	initState uint32
+	// The thread running the class' static initializer, while the state is
+	// classInitializing.
+	initThread *Thread
+	// Signaled when the class' initialization finishes or fails. Created the
+	// first time a thread needs to wait for another thread to initialize the
+	// class.
+	initDone *sync.Cond
+	// If non-nil, this is used instead of CreateInstance to allocate new
+	// objects of this class. Builtin classes whose instances aren't
+	// ClassInstances, such as java/lang/String, set this. Access it using
+	// NewObject.
+	Allocate func() Object
+	// The monitor used by static synchronized methods, and when synchronizing
+	// on the class object.
+	objectMonitor
+	// Nonzero if this is one of the classes representing a primitive type,
+	// such as int.class. Holds the type's descriptor character.
+	primitiveType class_file.PrimitiveFieldType
+	// Non-nil if this is the class of an array, such as int[].class.
+	arrayType *class_file.ArrayType
 }
 
 func (c *Class) String() string {
@@ -78,14 +132,59 @@ func (c *Class) TypeName() string {
 // interface.) Returns an error if the field can't be resolved.
 func (c *Class) ResolveStaticField(name string) (*Class, int, error) {
 	info := c.FieldInfo[name]
-	// TODO: Actually look up fields in superclasses, etc.
-	if info == nil {
-		return nil, 0, FieldError("Could not find field " + name)
+	if info != nil {
+		if !info.FileField.Access.IsStatic() {
+			return nil, 0, FieldError("Field " + name + " is not static")
+		}
+		return c, info.Index, nil
+	}
+	// As described in the JVM spec, search superinterfaces before the
+	// superclass.
+	for _, iface := range c.Interfaces {
+		toReturn, index, e := iface.ResolveStaticField(name)
+		if e == nil {
+			return toReturn, index, nil
+		}
 	}
-	if !info.FileField.Access.IsStatic() {
-		return nil, 0, FieldError("Field " + name + " is not static")
+	if c.Super != nil {
+		return c.Super.ResolveStaticField(name)
 	}
-	return c, info.Index, nil
+	return nil, 0, FieldError("Could not find field " + name)
+}
+
+// Like ResolveStaticField, but for non-static fields. Returns the class that
+// declares the field, and the field's index into the FieldValues array of any
+// instance of c (or of a subclass of c). Returns an error if the field can't
+// be resolved.
+func (c *Class) ResolveField(name string) (*Class, int, error) {
+	for current := c; current != nil; current = current.Super {
+		info := current.FieldInfo[name]
+		if info == nil {
+			continue
+		}
+		if info.FileField.Access.IsStatic() {
+			return nil, 0, FieldError("Field " + name + " is static")
+		}
+		return current, info.Index, nil
+	}
+	return nil, 0, FieldError("Could not find field " + name)
+}
+
+// Returns true if c is the same class as other, or if other is one of c's
+// superclasses or superinterfaces.
+func (c *Class) IsSubclassOf(other *Class) bool {
+	if c == other {
+		return true
+	}
+	for _, iface := range c.Interfaces {
+		if iface.IsSubclassOf(other) {
+			return true
+		}
+	}
+	if c.Super != nil {
+		return c.Super.IsSubclassOf(other)
+	}
+	return false
 }
 
 // Returns the named method from the class. Returns a MethodNotFoundError if
@@ -160,12 +259,28 @@ func (c *Class) CreateInstance() (*ClassInstance, error) {
 	}, nil
 }
 
+// Allocates a new, uninitialized object of this class, as the "new"
+// instruction does. Returns a ClassInstance created by CreateInstance unless
+// the class provides its own Allocate function.
+func (c *Class) NewObject() (Object, error) {
+	if c.Allocate != nil {
+		return c.Allocate(), nil
+	}
+	return c.CreateInstance()
+}
+
 // Iterates over the class' field information, initializes the
 // StaticFieldValues, FieldCount, and FieldInfo members of the Class struct.
+// The superclass must already be set, so that inherited instance fields can be
+// laid out before the ones declared in this class.
 func (c *Class) getFieldInfo() error {
 	c.FieldInfo = make(map[string]*ClassField)
 	staticCount := 0
-	nonStaticCount := 0
+	inheritedCount := 0
+	if c.Super != nil {
+		inheritedCount = len(c.Super.FieldTypes)
+	}
+	nonStaticCount := inheritedCount
 	for _, f := range c.File.Fields {
 		isStatic := f.Access.IsStatic()
 		name := string(f.Name)
@@ -191,6 +306,10 @@ func (c *Class) getFieldInfo() error {
 	c.StaticFieldNames = make([]string, staticCount)
 	c.FieldTypes = make([]class_file.FieldType, nonStaticCount)
 	c.FieldNames = make([]string, nonStaticCount)
+	if c.Super != nil {
+		copy(c.FieldTypes, c.Super.FieldTypes)
+		copy(c.FieldNames, c.Super.FieldNames)
+	}
 	for _, f := range c.FieldInfo {
 		if f.FileField.Access.IsStatic() {
 			c.StaticFieldTypes[f.Index] = f.FileField.Descriptor
@@ -203,9 +322,62 @@ func (c *Class) getFieldInfo() error {
 	return nil
 }
 
+// Looks up the superclass and interfaces named in the class file, loading
+// them from the class path if necessary, and sets c.Super and c.Interfaces.
+// The loading slice holds the names of the classes whose superclasses are
+// already being resolved, in order to detect circular class hierarchies.
+func (c *Class) resolveSuperclasses(loading []string) error {
+	j := c.ParentJVM
+	// Copy the slice so that loading other classes can't modify the caller's
+	// copy.
+	loading = append(append([]string{}, loading...), string(c.Name))
+	superName, e := c.File.GetSuperClassName()
+	if e != nil {
+		return fmt.Errorf("Couldn't get superclass name: %w", e)
+	}
+	// Every class other than java/lang/Object itself is a subclass of it, even
+	// if the class file doesn't name a superclass.
+	if (superName == nil) && (string(c.Name) != "java/lang/Object") {
+		superName = []byte("java/lang/Object")
+	}
+	if superName != nil {
+		c.Super, e = j.getOrLoadClass(string(superName), loading)
+		if e != nil {
+			_, notFound := e.(ClassNotFoundError)
+			// We don't require an implementation of java/lang/Object, since
+			// it has no fields and other classes can work without it.
+			if !notFound || (string(superName) != "java/lang/Object") {
+				return fmt.Errorf("Failed loading superclass %s: %w",
+					superName, e)
+			}
+			c.Super = nil
+		}
+	}
+	interfaceNames, e := c.File.GetInterfaceNames()
+	if e != nil {
+		return e
+	}
+	c.Interfaces = make([]*Class, len(interfaceNames))
+	for i, name := range interfaceNames {
+		c.Interfaces[i], e = j.getOrLoadClass(string(name), loading)
+		if e != nil {
+			return fmt.Errorf("Failed loading interface %s: %w", name, e)
+		}
+	}
+	return nil
+}
+
 // Takes a class loaded by the class_file package and converts it to the Class
-// type needed by the JVM. Does *not* modify the state of the JVM.
+// type needed by the JVM. Does *not* modify the state of the JVM, other than
+// loading the class' superclasses if necessary.
 func NewClass(j *JVM, class *class_file.Class) (*Class, error) {
+	return newClass(j, class, nil)
+}
+
+// Like NewClass, but takes the names of the classes whose superclasses are
+// being resolved. See resolveSuperclasses.
+func newClass(j *JVM, class *class_file.Class, loading []string) (*Class,
+	error) {
 	className, e := class.GetName()
 	if e != nil {
 		return nil, fmt.Errorf("Error getting class name: %s", e)
@@ -221,6 +393,9 @@ func NewClass(j *JVM, class *class_file.Class) (*Class, error) {
 		StaticFieldNames:  nil,
 		FieldNames:        nil,
 		File:              class,
+		Super:             nil,
+		Interfaces:        nil,
+		AccessFlags:       class.Access,
 	}
 	var key string
 	var method *Method
@@ -232,6 +407,14 @@ func NewClass(j *JVM, class *class_file.Class) (*Class, error) {
 		}
 		toReturn.Methods[key] = method
 	}
+	toReturn.BootstrapMethods, e = getBootstrapMethods(class)
+	if e != nil {
+		return nil, e
+	}
+	e = (&toReturn).resolveSuperclasses(loading)
+	if e != nil {
+		return nil, e
+	}
 	e = (&toReturn).getFieldInfo()
 	if e != nil {
 		return nil, fmt.Errorf("Failed populating field info: %s", e)
@@ -462,7 +462,7 @@ func parseSingleTypeAnnotation(data io.Reader) (TypeAnnotation, error) {
 		}
 		return &toReturn, nil
 	case 0x47, 0x48, 0x49, 0x4a, 0x4b:
-		// target_info is a type_parameter_target struct.
+		// target_info is a type_argument_target struct.
 		var toReturn TypeArgumentAnnotation
 		toReturn.target = tag
 		var offset uint16
@@ -482,6 +482,7 @@ func parseSingleTypeAnnotation(data io.Reader) (TypeAnnotation, error) {
 		if e != nil {
 			return nil, e
 		}
+		return &toReturn, nil
 	case 0x40, 0x41:
 		// target_info is a localvar_target struct.
 		var toReturn LocalVariableTypeAnnotation
@@ -542,3 +543,225 @@ func ParseAnnotationDefaultAttribute(a *Attribute) (ElementValue, error) {
 	data := bytes.NewReader(a.Info)
 	return parseElementValue(data)
 }
+
+// Writes a single ElementValue, including its tag. This is the inverse of
+// parseElementValue.
+func writeElementValue(data io.Writer, v ElementValue) error {
+	e := binary.Write(data, binary.BigEndian, v.Tag())
+	if e != nil {
+		return e
+	}
+	switch value := v.(type) {
+	case *basicElementValue:
+		return binary.Write(data, binary.BigEndian, value.index)
+	case *EnumElementValue:
+		binary.Write(data, binary.BigEndian, value.TypeNameIndex)
+		return binary.Write(data, binary.BigEndian, value.ConstNameIndex)
+	case *AnnotationElementValue:
+		return writeSingleAnnotation(data, value.Value)
+	case *ArrayElementValue:
+		e = writeCount(data, len(value.Values), "array element values")
+		if e != nil {
+			return e
+		}
+		for _, nested := range value.Values {
+			e = writeElementValue(data, nested)
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Can't write element value with tag %s", v.Tag())
+}
+
+// Writes a 16-bit length of an element-value pairs table, followed by the
+// table itself.
+func writeElementValuePairsTable(data io.Writer,
+	pairs []ElementValuePair) error {
+	e := writeCount(data, len(pairs), "element-value pairs")
+	if e != nil {
+		return e
+	}
+	for _, pair := range pairs {
+		binary.Write(data, binary.BigEndian, pair.ElementNameIndex)
+		e = writeElementValue(data, pair.Value)
+		if e != nil {
+			return fmt.Errorf("Failed writing element value: %w", e)
+		}
+	}
+	return nil
+}
+
+func writeSingleAnnotation(data io.Writer, a *Annotation) error {
+	e := binary.Write(data, binary.BigEndian, a.NameIndex)
+	if e != nil {
+		return e
+	}
+	return writeElementValuePairsTable(data, a.ElementValuePairs)
+}
+
+// Writes a uint16 count of annotations, followed by the annotations
+// themselves. This is the inverse of parseAnnotationGroup.
+func writeAnnotationGroup(data io.Writer, annotations []*Annotation) error {
+	e := writeCount(data, len(annotations), "annotations")
+	if e != nil {
+		return e
+	}
+	for _, a := range annotations {
+		e = writeSingleAnnotation(data, a)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Returns a RuntimeVisibleAnnotations or RuntimeInvisibleAnnotations
+// attribute, depending on the given name, containing the annotations. This is
+// the inverse of ParseRuntimeAnnotationsAttribute.
+func EncodeRuntimeAnnotationsAttribute(name string,
+	annotations []*Annotation) (*Attribute, error) {
+	switch name {
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		break
+	default:
+		return nil, fmt.Errorf("Invalid runtime annotations attribute name: "+
+			"%s", name)
+	}
+	data := &bytes.Buffer{}
+	e := writeAnnotationGroup(data, annotations)
+	if e != nil {
+		return nil, e
+	}
+	return &Attribute{
+		Name: []byte(name),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Returns a RuntimeVisibleParameterAnnotations or
+// RuntimeInvisibleParameterAnnotations attribute, depending on the given
+// name, containing the annotations for each parameter. This is the inverse of
+// ParseParameterAnnotationsAttribute.
+func EncodeParameterAnnotationsAttribute(name string,
+	parameters [][]*Annotation) (*Attribute, error) {
+	switch name {
+	case "RuntimeVisibleParameterAnnotations",
+		"RuntimeInvisibleParameterAnnotations":
+		break
+	default:
+		return nil, fmt.Errorf("Invalid parameter annotations attribute "+
+			"name: %s", name)
+	}
+	if len(parameters) > 0xff {
+		return nil, fmt.Errorf("Too many parameters: %d", len(parameters))
+	}
+	data := &bytes.Buffer{}
+	data.WriteByte(uint8(len(parameters)))
+	for i, annotations := range parameters {
+		e := writeAnnotationGroup(data, annotations)
+		if e != nil {
+			return nil, fmt.Errorf("Failed writing param %d annotations: %w",
+				i, e)
+		}
+	}
+	return &Attribute{
+		Name: []byte(name),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Writes a type path, including its length.
+func writeTypePath(data io.Writer, path []TypePathElement) error {
+	if len(path) > 0xff {
+		return fmt.Errorf("Type path is too long: %d", len(path))
+	}
+	binary.Write(data, binary.BigEndian, uint8(len(path)))
+	return binary.Write(data, binary.BigEndian, path)
+}
+
+// Writes a single type annotation. This is the inverse of
+// parseSingleTypeAnnotation.
+func writeSingleTypeAnnotation(data io.Writer, a TypeAnnotation) error {
+	target := a.Target()
+	binary.Write(data, binary.BigEndian, target)
+	switch v := a.(type) {
+	case *basicTypeAnnotation:
+		// There's no target_info to write.
+	case *SingleFieldTypeAnnotation:
+		switch target {
+		case 0, 1, 0x16:
+			if v.Data > 0xff {
+				return fmt.Errorf("Invalid type annotation index: %d", v.Data)
+			}
+			binary.Write(data, binary.BigEndian, uint8(v.Data))
+		default:
+			binary.Write(data, binary.BigEndian, v.Data)
+		}
+	case *TypeParameterBoundAnnotation:
+		binary.Write(data, binary.BigEndian, v.TypeParameterIndex)
+		binary.Write(data, binary.BigEndian, v.BoundIndex)
+	case *TypeArgumentAnnotation:
+		binary.Write(data, binary.BigEndian, v.Offset)
+		binary.Write(data, binary.BigEndian, v.TypeArgumentIndex)
+	case *LocalVariableTypeAnnotation:
+		e := writeCount(data, len(v.Table), "local variable targets")
+		if e != nil {
+			return e
+		}
+		binary.Write(data, binary.BigEndian, v.Table)
+	default:
+		return fmt.Errorf("Can't write type annotation with target type %d",
+			target)
+	}
+	e := writeTypePath(data, a.TypePath())
+	if e != nil {
+		return e
+	}
+	binary.Write(data, binary.BigEndian, a.TypeIndex())
+	return writeElementValuePairsTable(data, a.ElementValuePairs())
+}
+
+// Returns a RuntimeVisibleTypeAnnotations or RuntimeInvisibleTypeAnnotations
+// attribute, depending on the given name, containing the annotations. This is
+// the inverse of ParseTypeAnnotationsAttribute.
+func EncodeTypeAnnotationsAttribute(name string,
+	annotations []TypeAnnotation) (*Attribute, error) {
+	switch name {
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		break
+	default:
+		return nil, fmt.Errorf("Invalid type annotations attribute name: %s",
+			name)
+	}
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(annotations), "type annotations")
+	if e != nil {
+		return nil, e
+	}
+	for _, a := range annotations {
+		e = writeSingleTypeAnnotation(data, a)
+		if e != nil {
+			return nil, fmt.Errorf("Failed writing type annotation: %w", e)
+		}
+	}
+	return &Attribute{
+		Name: []byte(name),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Returns an AnnotationDefault attribute containing the given ElementValue.
+// This is the inverse of ParseAnnotationDefaultAttribute.
+func EncodeAnnotationDefaultAttribute(v ElementValue) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeElementValue(data, v)
+	if e != nil {
+		return nil, e
+	}
+	return &Attribute{
+		Name: []byte("AnnotationDefault"),
+		Info: data.Bytes(),
+	}, nil
+}
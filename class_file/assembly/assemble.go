@@ -0,0 +1,1162 @@
+// This package converts between class files and a Jasmin-style text format,
+// so that class files can be written or edited without a Java compiler.
+//
+// Each source file contains one class. Comments start with a semicolon at the
+// start of a line or following whitespace. The class is described by the
+// following directives, which must come before any fields or methods:
+//
+//	.bytecode 52.0
+//	.source Example.java
+//	.class public super Example
+//	.super java/lang/Object
+//	.implements java/lang/Runnable
+//
+// Fields may have a constant value:
+//
+//	.field public static final LIMIT I = 10
+//
+// Methods contain labels, instructions, and directives, and end with
+// ".end method". The max_stack, max_locals, and StackMapTable are computed
+// automatically, but ".limit stack" and ".limit locals" may be used to make
+// them larger:
+//
+//	.method public static count(I)I
+//	    .limit locals 2
+//	    .throws java/lang/Exception
+//	    .catch java/lang/ArithmeticException from Start to End using Handler
+//	    .line 12
+//	Start:
+//	    iconst_0
+//	    ...
+//	.end method
+//
+// Instructions are written using their mnemonics from the JVM spec. Branches
+// take a label. Fields are written as "owner/name descriptor" and methods as
+// "owner/name(arguments)return". Methods in interfaces are prefixed with
+// "interface", except for invokeinterface. Constants loaded by ldc, ldc_w, and
+// ldc2_w may be numbers, quoted strings, "class <name>", "methodtype
+// <descriptor>", "methodhandle <kind> <field or method>", or "dynamic <name>
+// <descriptor> <bootstrap method index>". Numbers may have a suffix: L for
+// longs, f for floats, and d for doubles. Unsuffixed numbers loaded by ldc2_w
+// are longs or doubles, and ints or floats otherwise. The wide forms of
+// instructions are used automatically when necessary.
+//
+// Switches list their targets on the following lines, ending with the
+// default target:
+//
+//	tableswitch 1
+//	    One
+//	    Two
+//	    default : Other
+//	lookupswitch
+//	    -5 : One
+//	    100 : Two
+//	    default : Other
+//
+// Bootstrap methods for invokedynamic are added using ".bootstrap", followed
+// by a method handle and any static arguments, and are numbered in order
+// starting from 0. The invokedynamic instruction is followed by the call
+// site's name and descriptor, and the index of its bootstrap method:
+//
+//	.bootstrap invokestatic Example/bootstrap(<descriptor>) 10 "text"
+//	...
+//	    invokedynamic get()I 0
+package assembly
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Maps each access flag keyword to its value, for a class, field, or method.
+type flagKeywords map[string]uint16
+
+var classFlags = flagKeywords{
+	"public":     0x0001,
+	"private":    0x0002,
+	"protected":  0x0004,
+	"static":     0x0008,
+	"final":      0x0010,
+	"super":      0x0020,
+	"interface":  0x0200,
+	"abstract":   0x0400,
+	"synthetic":  0x1000,
+	"annotation": 0x2000,
+	"enum":       0x4000,
+}
+
+var fieldFlags = flagKeywords{
+	"public":    0x0001,
+	"private":   0x0002,
+	"protected": 0x0004,
+	"static":    0x0008,
+	"final":     0x0010,
+	"volatile":  0x0040,
+	"transient": 0x0080,
+	"synthetic": 0x1000,
+	"enum":      0x4000,
+}
+
+var methodFlags = flagKeywords{
+	"public":       0x0001,
+	"private":      0x0002,
+	"protected":    0x0004,
+	"static":       0x0008,
+	"final":        0x0010,
+	"synchronized": 0x0020,
+	"bridge":       0x0040,
+	"varargs":      0x0080,
+	"native":       0x0100,
+	"abstract":     0x0400,
+	"strict":       0x0800,
+	"synthetic":    0x1000,
+}
+
+// Returns the combined value of the given access flag keywords.
+func (k flagKeywords) parse(keywords []string) (uint16, error) {
+	toReturn := uint16(0)
+	for _, keyword := range keywords {
+		flag, ok := k[keyword]
+		if !ok {
+			return 0, fmt.Errorf("Invalid access flag: %s", keyword)
+		}
+		toReturn |= flag
+	}
+	return toReturn, nil
+}
+
+// The names of method handle kinds, indexed by kind.
+var methodHandleKinds = []string{"", "getfield", "getstatic", "putfield",
+	"putstatic", "invokevirtual", "invokestatic", "invokespecial",
+	"newinvokespecial", "invokeinterface"}
+
+// The names of the array types used by newarray, indexed by type.
+var arrayTypes = []string{"", "", "", "", "boolean", "char", "float",
+	"double", "byte", "short", "int", "long"}
+
+// Returns the index of the string in the list, or -1 if it isn't in the list.
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if (v != "") && (v == s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Splits a line into tokens, separated by whitespace. Quoted strings are a
+// single token, including the quotes. Comments are removed.
+func tokenize(line string) ([]string, error) {
+	var toReturn []string
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		if (c == ' ') || (c == '\t') || (c == '\r') {
+			i++
+			continue
+		}
+		// Comments start after whitespace, so semicolons in descriptors
+		// aren't a problem.
+		if c == ';' {
+			break
+		}
+		start := i
+		if c == '"' {
+			i++
+			for (i < len(line)) && (line[i] != '"') {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("Unterminated string")
+			}
+			i++
+			toReturn = append(toReturn, line[start:i])
+			continue
+		}
+		for (i < len(line)) && (line[i] != ' ') && (line[i] != '\t') &&
+			(line[i] != '\r') {
+			i++
+		}
+		toReturn = append(toReturn, line[start:i])
+	}
+	return toReturn, nil
+}
+
+// Parses an integer that must fit in the given number of bits.
+func parseInt(s string, bits int) (int64, error) {
+	toReturn, e := strconv.ParseInt(s, 0, bits)
+	if e != nil {
+		return 0, fmt.Errorf("Invalid %d-bit integer: %s", bits, s)
+	}
+	return toReturn, nil
+}
+
+// Parses a numeric literal, returning its type ('I', 'J', 'F', or 'D') and its
+// value. Unsuffixed integers have the type intKind, and unsuffixed
+// floating-point numbers have the type floatKind.
+func parseNumber(s string, intKind, floatKind byte) (byte, int64, float64,
+	error) {
+	lower := strings.ToLower(s)
+	isHex := strings.HasPrefix(strings.TrimLeft(lower, "+-"), "0x")
+	kind := byte(0)
+	last := lower[len(lower)-1]
+	if last == 'l' {
+		kind = 'J'
+	} else if !isHex && (last == 'f') && !strings.HasSuffix(lower, "inf") {
+		kind = 'F'
+	} else if !isHex && (last == 'd') {
+		kind = 'D'
+	}
+	if kind != 0 {
+		s = s[:len(s)-1]
+		lower = lower[:len(lower)-1]
+	}
+	if kind == 0 {
+		kind = intKind
+		if !isHex && strings.ContainsAny(lower, ".eni") {
+			kind = floatKind
+		}
+	}
+	switch kind {
+	case 'I':
+		v, e := parseInt(s, 32)
+		return kind, v, 0, e
+	case 'J':
+		v, e := parseInt(s, 64)
+		return kind, v, 0, e
+	}
+	bits := 64
+	if kind == 'F' {
+		bits = 32
+	}
+	v, e := strconv.ParseFloat(s, bits)
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("Invalid floating-point number: %s", s)
+	}
+	return kind, 0, v, nil
+}
+
+// Splits a field reference of the form "owner/name" into its parts.
+func splitMemberName(s string) (string, string, error) {
+	slash := strings.LastIndexByte(s, '/')
+	if (slash <= 0) || (slash == (len(s) - 1)) {
+		return "", "", fmt.Errorf("Expected owner/name, got %s", s)
+	}
+	return s[:slash], s[slash+1:], nil
+}
+
+// Splits a method of the form "name(arguments)return" into its name and
+// descriptor.
+func splitMethodDescriptor(s string) (string, string, error) {
+	paren := strings.IndexByte(s, '(')
+	if paren <= 0 {
+		return "", "", fmt.Errorf("Expected a method name and descriptor, "+
+			"got %s", s)
+	}
+	return s[:paren], s[paren:], nil
+}
+
+// The parts of a method reference.
+type methodName struct {
+	isInterface bool
+	owner       string
+	name        string
+	descriptor  string
+}
+
+// Holds the state of a method being assembled.
+type methodState struct {
+	m *builder.MethodBuilder
+	// The labels in the method, by name.
+	labels map[string]*builder.Label
+	// The line on which each label was first used.
+	labelLines map[string]int
+	placed     map[string]bool
+	exceptions []uint16
+}
+
+// Holds the state of the assembler.
+type parser struct {
+	scanner *bufio.Scanner
+	// The current line number in the source, starting at 1.
+	line int
+	// The directives used to create the class builder, which is created when
+	// the first field, method, or bootstrap method is encountered.
+	major, minor   uint16
+	versionSet     bool
+	className      string
+	superName      string
+	superSet       bool
+	access         uint16
+	sourceFile     string
+	interfaces     []string
+	class          *builder.ClassBuilder
+	method         *methodState
+	methodNameLine int
+}
+
+// Reads the next line containing tokens. Returns nil tokens at the end of the
+// input.
+func (p *parser) nextLine() ([]string, error) {
+	for p.scanner.Scan() {
+		p.line++
+		tokens, e := tokenize(p.scanner.Text())
+		if e != nil {
+			return nil, e
+		}
+		if len(tokens) != 0 {
+			return tokens, nil
+		}
+	}
+	return nil, p.scanner.Err()
+}
+
+// Returns the class builder, creating it if this is the first time it's
+// needed.
+func (p *parser) getClass() (*builder.ClassBuilder, error) {
+	if p.class != nil {
+		return p.class, nil
+	}
+	if p.className == "" {
+		return nil, fmt.Errorf("Missing .class directive")
+	}
+	superName := p.superName
+	if !p.superSet && (p.className != "java/lang/Object") {
+		superName = "java/lang/Object"
+	}
+	p.class = builder.NewClassBuilder(p.className, superName)
+	p.class.Access = class_file.ClassAccessFlags(p.access)
+	if p.versionSet {
+		p.class.MajorVersion = p.major
+		p.class.MinorVersion = p.minor
+	}
+	if p.sourceFile != "" {
+		p.class.SetSourceFile(p.sourceFile)
+	}
+	for _, name := range p.interfaces {
+		p.class.AddInterface(name)
+	}
+	return p.class, nil
+}
+
+// Checks that the directive has the given number of arguments.
+func checkArgCount(tokens []string, count int) error {
+	if len(tokens) != (count + 1) {
+		return fmt.Errorf("%s expects %d arguments, got %d", tokens[0], count,
+			len(tokens)-1)
+	}
+	return nil
+}
+
+// Processes a directive that isn't in a method.
+func (p *parser) classDirective(tokens []string) error {
+	var e error
+	switch tokens[0] {
+	case ".bytecode":
+		e = checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		parts := strings.SplitN(tokens[1], ".", 2)
+		major, e := parseInt(parts[0], 17)
+		if (e != nil) || (major < 0) {
+			return fmt.Errorf("Invalid class file version: %s", tokens[1])
+		}
+		minor := int64(0)
+		if len(parts) == 2 {
+			minor, e = parseInt(parts[1], 17)
+			if (e != nil) || (minor < 0) {
+				return fmt.Errorf("Invalid class file version: %s", tokens[1])
+			}
+		}
+		p.major, p.minor, p.versionSet = uint16(major), uint16(minor), true
+		if p.class != nil {
+			p.class.MajorVersion = p.major
+			p.class.MinorVersion = p.minor
+		}
+		return nil
+	case ".source":
+		e = checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		if p.class != nil {
+			p.class.SetSourceFile(tokens[1])
+		} else {
+			p.sourceFile = tokens[1]
+		}
+		return nil
+	case ".class", ".interface":
+		if (p.class != nil) || (p.className != "") {
+			return fmt.Errorf("Only one class may be defined per file")
+		}
+		if len(tokens) < 2 {
+			return fmt.Errorf("Missing class name")
+		}
+		p.access, e = classFlags.parse(tokens[1 : len(tokens)-1])
+		if e != nil {
+			return e
+		}
+		if tokens[0] == ".interface" {
+			p.access |= 0x0600
+		}
+		p.className = tokens[len(tokens)-1]
+		return nil
+	case ".super":
+		e = checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		if (p.class != nil) || p.superSet {
+			return fmt.Errorf(".super must appear once, before any fields " +
+				"or methods")
+		}
+		p.superName, p.superSet = tokens[1], true
+		return nil
+	case ".implements":
+		e = checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		if p.class != nil {
+			p.class.AddInterface(tokens[1])
+		} else {
+			p.interfaces = append(p.interfaces, tokens[1])
+		}
+		return nil
+	case ".field":
+		return p.field(tokens)
+	case ".method":
+		return p.startMethod(tokens)
+	case ".bootstrap":
+		return p.bootstrap(tokens)
+	}
+	return fmt.Errorf("Unexpected %s outside of a method", tokens[0])
+}
+
+// Processes a .field directive.
+func (p *parser) field(tokens []string) error {
+	class, e := p.getClass()
+	if e != nil {
+		return e
+	}
+	var value []string
+	for i, t := range tokens {
+		if t == "=" {
+			value = tokens[i+1:]
+			tokens = tokens[:i]
+			if len(value) != 1 {
+				return fmt.Errorf("Expected a single constant value")
+			}
+			break
+		}
+	}
+	if len(tokens) < 3 {
+		return fmt.Errorf("Expected a field name and descriptor")
+	}
+	access, e := fieldFlags.parse(tokens[1 : len(tokens)-2])
+	if e != nil {
+		return e
+	}
+	descriptor := tokens[len(tokens)-1]
+	f := class.AddField(class_file.FieldAccessFlags(access),
+		tokens[len(tokens)-2], descriptor)
+	if value == nil {
+		return nil
+	}
+	index, e := p.fieldValue(descriptor, value[0])
+	if e != nil {
+		return e
+	}
+	f.SetConstantValue(index)
+	return nil
+}
+
+// Returns the index of a constant containing the initial value of a field
+// with the given descriptor.
+func (p *parser) fieldValue(descriptor, value string) (uint16, error) {
+	pool := p.class.Pool
+	if descriptor == "Ljava/lang/String;" {
+		s, e := strconv.Unquote(value)
+		if e != nil {
+			return 0, fmt.Errorf("Invalid string: %s", value)
+		}
+		return pool.StringConstant(s), nil
+	}
+	expected := descriptor[0]
+	switch expected {
+	case 'B', 'C', 'S', 'Z':
+		expected = 'I'
+	}
+	kind, i, f, e := parseNumber(value, expected, expected)
+	if e != nil {
+		return 0, e
+	}
+	if kind != expected {
+		return 0, fmt.Errorf("A field with descriptor %s can't have the "+
+			"value %s", descriptor, value)
+	}
+	switch kind {
+	case 'I':
+		return pool.Integer(int32(i)), nil
+	case 'J':
+		return pool.Long(i), nil
+	case 'F':
+		return pool.Float(float32(f)), nil
+	}
+	return pool.Double(f), nil
+}
+
+// Parses a field reference from the start of the tokens, of the form
+// "owner/name descriptor". Returns the constant index and the remaining
+// tokens.
+func (p *parser) fieldReference(tokens []string) (uint16, []string, error) {
+	if len(tokens) < 2 {
+		return 0, nil, fmt.Errorf("Expected a field name and descriptor")
+	}
+	owner, name, e := splitMemberName(tokens[0])
+	if e != nil {
+		return 0, nil, e
+	}
+	return p.class.Pool.Field(owner, name, tokens[1]), tokens[2:], nil
+}
+
+// Parses a method from the start of the tokens, of the form
+// "owner/name(arguments)return", optionally preceded by "interface". Returns
+// the parts of the method and the remaining tokens.
+func parseMethod(tokens []string) (*methodName, []string, error) {
+	toReturn := &methodName{}
+	if (len(tokens) != 0) && (tokens[0] == "interface") {
+		toReturn.isInterface = true
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("Expected a method")
+	}
+	member, descriptor, e := splitMethodDescriptor(tokens[0])
+	if e != nil {
+		return nil, nil, e
+	}
+	toReturn.owner, toReturn.name, e = splitMemberName(member)
+	if e != nil {
+		return nil, nil, e
+	}
+	toReturn.descriptor = descriptor
+	return toReturn, tokens[1:], nil
+}
+
+// Parses a method reference from the start of the tokens, and returns its
+// constant index and the remaining tokens.
+func (p *parser) methodReference(tokens []string) (uint16, []string, error) {
+	m, tokens, e := parseMethod(tokens)
+	if e != nil {
+		return 0, nil, e
+	}
+	if m.isInterface {
+		return p.class.Pool.InterfaceMethod(m.owner, m.name, m.descriptor),
+			tokens, nil
+	}
+	return p.class.Pool.Method(m.owner, m.name, m.descriptor), tokens, nil
+}
+
+// Parses a constant from the start of the tokens, and returns its index and
+// the remaining tokens. Unsuffixed numbers have the type intKind or floatKind.
+func (p *parser) constant(tokens []string, intKind, floatKind byte) (uint16,
+	[]string, error) {
+	if len(tokens) == 0 {
+		return 0, nil, fmt.Errorf("Expected a constant")
+	}
+	pool := p.class.Pool
+	switch tokens[0] {
+	case "class":
+		if len(tokens) < 2 {
+			return 0, nil, fmt.Errorf("Expected a class name")
+		}
+		return pool.Class(tokens[1]), tokens[2:], nil
+	case "methodtype":
+		if len(tokens) < 2 {
+			return 0, nil, fmt.Errorf("Expected a method descriptor")
+		}
+		return pool.MethodType(tokens[1]), tokens[2:], nil
+	case "methodhandle":
+		if len(tokens) < 2 {
+			return 0, nil, fmt.Errorf("Expected a method handle kind")
+		}
+		return p.methodHandle(tokens[1:])
+	case "dynamic":
+		if len(tokens) < 4 {
+			return 0, nil, fmt.Errorf("Expected a dynamic constant's name, " +
+				"descriptor, and bootstrap method index")
+		}
+		bootstrapIndex, e := parseInt(tokens[3], 17)
+		if (e != nil) || (bootstrapIndex < 0) || (bootstrapIndex > 0xffff) {
+			return 0, nil, fmt.Errorf("Invalid bootstrap method index: %s",
+				tokens[3])
+		}
+		return pool.Dynamic(uint16(bootstrapIndex), tokens[1], tokens[2]),
+			tokens[4:], nil
+	}
+	if tokens[0][0] == '"' {
+		s, e := strconv.Unquote(tokens[0])
+		if e != nil {
+			return 0, nil, fmt.Errorf("Invalid string: %s", tokens[0])
+		}
+		return pool.StringConstant(s), tokens[1:], nil
+	}
+	kind, i, f, e := parseNumber(tokens[0], intKind, floatKind)
+	if e != nil {
+		return 0, nil, e
+	}
+	switch kind {
+	case 'I':
+		return pool.Integer(int32(i)), tokens[1:], nil
+	case 'J':
+		return pool.Long(i), tokens[1:], nil
+	case 'F':
+		return pool.Float(float32(f)), tokens[1:], nil
+	}
+	return pool.Double(f), tokens[1:], nil
+}
+
+// Parses a method handle of the form "<kind> <field or method>", returning
+// the constant index and the remaining tokens.
+func (p *parser) methodHandle(tokens []string) (uint16, []string, error) {
+	kind := indexOf(methodHandleKinds, tokens[0])
+	if kind < 0 {
+		return 0, nil, fmt.Errorf("Invalid method handle kind: %s", tokens[0])
+	}
+	var index uint16
+	var e error
+	if kind <= 4 {
+		index, tokens, e = p.fieldReference(tokens[1:])
+	} else {
+		index, tokens, e = p.methodReference(tokens[1:])
+	}
+	if e != nil {
+		return 0, nil, e
+	}
+	handle := p.class.Pool.MethodHandle(
+		class_file.MethodHandleReferenceKind(kind), index)
+	return handle, tokens, nil
+}
+
+// Processes a .bootstrap directive.
+func (p *parser) bootstrap(tokens []string) error {
+	_, e := p.getClass()
+	if e != nil {
+		return e
+	}
+	if len(tokens) < 2 {
+		return fmt.Errorf("Expected a bootstrap method handle")
+	}
+	handle, tokens, e := p.methodHandle(tokens[1:])
+	if e != nil {
+		return e
+	}
+	var arguments []uint16
+	for len(tokens) != 0 {
+		var argument uint16
+		argument, tokens, e = p.constant(tokens, 'I', 'D')
+		if e != nil {
+			return e
+		}
+		arguments = append(arguments, argument)
+	}
+	p.class.AddBootstrapMethod(handle, arguments...)
+	return nil
+}
+
+// Processes a .method directive.
+func (p *parser) startMethod(tokens []string) error {
+	class, e := p.getClass()
+	if e != nil {
+		return e
+	}
+	if len(tokens) < 2 {
+		return fmt.Errorf("Expected a method name and descriptor")
+	}
+	access, e := methodFlags.parse(tokens[1 : len(tokens)-1])
+	if e != nil {
+		return e
+	}
+	name, descriptor, e := splitMethodDescriptor(tokens[len(tokens)-1])
+	if e != nil {
+		return e
+	}
+	p.method = &methodState{
+		m: class.AddMethod(class_file.MethodAccessFlags(access), name,
+			descriptor),
+		labels:     make(map[string]*builder.Label),
+		labelLines: make(map[string]int),
+		placed:     make(map[string]bool),
+	}
+	p.methodNameLine = p.line
+	return nil
+}
+
+// Finishes the current method, at a .end method directive.
+func (p *parser) endMethod() error {
+	for name, line := range p.method.labelLines {
+		if !p.method.placed[name] {
+			return fmt.Errorf("Label %s, used on line %d, is never defined",
+				name, line)
+		}
+	}
+	if len(p.method.exceptions) != 0 {
+		a, e := class_file.EncodeExceptionsAttribute(p.method.exceptions)
+		if e != nil {
+			return e
+		}
+		p.method.m.AddAttribute(a)
+	}
+	p.method = nil
+	return nil
+}
+
+// Returns the label with the given name, creating it if necessary.
+func (p *parser) label(name string) *builder.Label {
+	toReturn := p.method.labels[name]
+	if toReturn == nil {
+		toReturn = p.method.m.NewLabel()
+		p.method.labels[name] = toReturn
+		p.method.labelLines[name] = p.line
+	}
+	return toReturn
+}
+
+// Processes a line in a method.
+func (p *parser) methodLine(tokens []string) error {
+	first := tokens[0]
+	if strings.HasSuffix(first, ":") && (first[0] != '"') {
+		name := strings.TrimSuffix(first, ":")
+		if p.method.placed[name] {
+			return fmt.Errorf("Label %s is defined more than once", name)
+		}
+		p.method.m.PlaceLabel(p.label(name))
+		p.method.placed[name] = true
+		if len(tokens) == 1 {
+			return nil
+		}
+		tokens = tokens[1:]
+	}
+	if tokens[0][0] == '.' {
+		return p.methodDirective(tokens)
+	}
+	return p.instruction(tokens)
+}
+
+// Processes a directive in a method.
+func (p *parser) methodDirective(tokens []string) error {
+	m := p.method.m
+	switch tokens[0] {
+	case ".end":
+		if (len(tokens) != 2) || (tokens[1] != "method") {
+			return fmt.Errorf("Expected .end method")
+		}
+		return p.endMethod()
+	case ".limit":
+		e := checkArgCount(tokens, 2)
+		if e != nil {
+			return e
+		}
+		limit, e := parseInt(tokens[2], 17)
+		if (e != nil) || (limit < 0) || (limit > 0xffff) {
+			return fmt.Errorf("Invalid limit: %s", tokens[2])
+		}
+		switch tokens[1] {
+		case "stack":
+			m.MaxStack = uint16(limit)
+		case "locals":
+			m.MaxLocals = uint16(limit)
+		default:
+			return fmt.Errorf("Expected .limit stack or .limit locals")
+		}
+		return nil
+	case ".line":
+		e := checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		line, e := parseInt(tokens[1], 17)
+		if (e != nil) || (line < 0) || (line > 0xffff) {
+			return fmt.Errorf("Invalid line number: %s", tokens[1])
+		}
+		m.AddLineNumber(uint16(line))
+		return nil
+	case ".throws":
+		e := checkArgCount(tokens, 1)
+		if e != nil {
+			return e
+		}
+		p.method.exceptions = append(p.method.exceptions,
+			p.class.Pool.Class(tokens[1]))
+		return nil
+	case ".catch":
+		if (len(tokens) != 8) || (tokens[2] != "from") ||
+			(tokens[4] != "to") || (tokens[6] != "using") {
+			return fmt.Errorf("Expected .catch <class> from <label> to " +
+				"<label> using <label>")
+		}
+		catchType := tokens[1]
+		if catchType == "all" {
+			catchType = ""
+		}
+		m.AddExceptionHandler(p.label(tokens[3]), p.label(tokens[5]),
+			p.label(tokens[7]), catchType)
+		return nil
+	}
+	return fmt.Errorf("Unexpected %s in a method", tokens[0])
+}
+
+// Returns true if the opcode is a branch instruction.
+func isBranch(op builder.Opcode) bool {
+	return ((op >= builder.Ifeq) && (op <= builder.Jsr)) ||
+		((op >= builder.Ifnull) && (op <= builder.Jsr_w))
+}
+
+// Processes a single instruction.
+func (p *parser) instruction(tokens []string) error {
+	m := p.method.m
+	op, ok := builder.LookupOpcode(tokens[0])
+	if !ok {
+		return fmt.Errorf("Unknown instruction: %s", tokens[0])
+	}
+	args := tokens[1:]
+	expectArgs := func(count int) error {
+		if len(args) != count {
+			return fmt.Errorf("%s expects %d operands, got %d", op, count,
+				len(args))
+		}
+		return nil
+	}
+	var e error
+	switch {
+	case op == builder.Tableswitch:
+		return p.tableSwitch(args)
+	case op == builder.Lookupswitch:
+		return p.lookupSwitch(args)
+	case op == builder.Wide:
+		return fmt.Errorf("The wide form of an instruction is used " +
+			"automatically when necessary")
+	case isBranch(op):
+		e = expectArgs(1)
+		if e == nil {
+			m.EmitBranch(op, p.label(args[0]))
+		}
+		return e
+	case ((op >= builder.Iload) && (op <= builder.Aload)) ||
+		((op >= builder.Istore) && (op <= builder.Astore)) ||
+		(op == builder.Ret):
+		e = expectArgs(1)
+		if e != nil {
+			return e
+		}
+		index, e := parseInt(args[0], 17)
+		if (e != nil) || (index < 0) || (index > 0xffff) {
+			return fmt.Errorf("Invalid local variable index: %s", args[0])
+		}
+		if index <= 0xff {
+			m.Emit(op, byte(index))
+		} else if op == builder.Ret {
+			return fmt.Errorf("Unsupported ret index: %d", index)
+		} else {
+			m.EmitLocal(op, uint16(index))
+		}
+		return nil
+	case op == builder.Iinc:
+		e = expectArgs(2)
+		if e != nil {
+			return e
+		}
+		index, e := parseInt(args[0], 17)
+		if (e != nil) || (index < 0) || (index > 0xffff) {
+			return fmt.Errorf("Invalid local variable index: %s", args[0])
+		}
+		delta, e := parseInt(args[1], 16)
+		if e != nil {
+			return e
+		}
+		m.EmitIinc(uint16(index), int16(delta))
+		return nil
+	case (op == builder.Bipush) || (op == builder.Sipush):
+		e = expectArgs(1)
+		if e != nil {
+			return e
+		}
+		bits := 8
+		if op == builder.Sipush {
+			bits = 16
+		}
+		v, e := parseInt(args[0], bits)
+		if e != nil {
+			return e
+		}
+		if bits == 8 {
+			m.Emit(op, byte(v))
+		} else {
+			m.Emit(op, byte(v>>8), byte(v))
+		}
+		return nil
+	case (op >= builder.Ldc) && (op <= builder.Ldc2_w):
+		intKind, floatKind := byte('I'), byte('F')
+		if op == builder.Ldc2_w {
+			intKind, floatKind = 'J', 'D'
+		}
+		index, rest, e := p.constant(args, intKind, floatKind)
+		if e != nil {
+			return e
+		}
+		args = rest
+		e = expectArgs(0)
+		if e != nil {
+			return e
+		}
+		if op != builder.Ldc {
+			m.Emit(op, byte(index>>8), byte(index))
+			return nil
+		}
+		if index > 0xff {
+			return fmt.Errorf("The constant's index (%d) is too large for "+
+				"ldc; use ldc_w", index)
+		}
+		m.Emit(op, byte(index))
+		return nil
+	case (op >= builder.Getstatic) && (op <= builder.Putfield):
+		index, rest, e := p.fieldReference(args)
+		if e != nil {
+			return e
+		}
+		args = rest
+		e = expectArgs(0)
+		if e == nil {
+			m.Emit(op, byte(index>>8), byte(index))
+		}
+		return e
+	case (op >= builder.Invokevirtual) && (op <= builder.Invokeinterface):
+		return p.invoke(op, args)
+	case op == builder.Invokedynamic:
+		e = expectArgs(2)
+		if e != nil {
+			return e
+		}
+		name, descriptor, e := splitMethodDescriptor(args[0])
+		if e != nil {
+			return e
+		}
+		bootstrap, e := parseInt(args[1], 17)
+		if (e != nil) || (bootstrap < 0) || (bootstrap > 0xffff) {
+			return fmt.Errorf("Invalid bootstrap method index: %s", args[1])
+		}
+		m.EmitInvokeDynamic(uint16(bootstrap), name, descriptor)
+		return nil
+	case (op == builder.New) || (op == builder.Anewarray) ||
+		(op == builder.Checkcast) || (op == builder.Instanceof):
+		e = expectArgs(1)
+		if e == nil {
+			m.EmitType(op, args[0])
+		}
+		return e
+	case op == builder.Newarray:
+		e = expectArgs(1)
+		if e != nil {
+			return e
+		}
+		arrayType := indexOf(arrayTypes, args[0])
+		if arrayType < 0 {
+			return fmt.Errorf("Invalid array type: %s", args[0])
+		}
+		m.Emit(op, byte(arrayType))
+		return nil
+	case op == builder.Multianewarray:
+		e = expectArgs(2)
+		if e != nil {
+			return e
+		}
+		dimensions, e := parseInt(args[1], 16)
+		if (e != nil) || (dimensions < 1) || (dimensions > 255) {
+			return fmt.Errorf("Invalid number of dimensions: %s", args[1])
+		}
+		m.EmitMultiANewArray(args[0], uint8(dimensions))
+		return nil
+	}
+	e = expectArgs(0)
+	if e == nil {
+		m.Emit(op)
+	}
+	return e
+}
+
+// Emits an invokevirtual, invokespecial, invokestatic, or invokeinterface
+// instruction.
+func (p *parser) invoke(op builder.Opcode, args []string) error {
+	if op == builder.Invokeinterface {
+		// The count operand is optional, since the builder computes it.
+		if (len(args) != 0) && (args[0] == "interface") {
+			args = args[1:]
+		}
+		method, rest, e := parseMethod(args)
+		if e != nil {
+			return e
+		}
+		if len(rest) > 1 {
+			return fmt.Errorf("Unexpected operand: %s", rest[1])
+		}
+		if len(rest) == 1 {
+			count, e := parseInt(rest[0], 16)
+			if (e != nil) || (count < 1) || (count > 255) {
+				return fmt.Errorf("Invalid invokeinterface count: %s",
+					rest[0])
+			}
+		}
+		p.method.m.EmitInvoke(op, method.owner, method.name,
+			method.descriptor)
+		return nil
+	}
+	index, rest, e := p.methodReference(args)
+	if e != nil {
+		return e
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("Unexpected operand: %s", rest[0])
+	}
+	p.method.m.Emit(op, byte(index>>8), byte(index))
+	return nil
+}
+
+// Reads the targets of a switch instruction from the following lines, until
+// the default target. Calls handleCase with the tokens on each line before
+// the default target.
+func (p *parser) switchTargets(handleCase func([]string) error) (
+	*builder.Label, error) {
+	for {
+		tokens, e := p.nextLine()
+		if e != nil {
+			return nil, e
+		}
+		if tokens == nil {
+			return nil, fmt.Errorf("Missing the switch's default target")
+		}
+		if tokens[0] == "default" {
+			if (len(tokens) != 3) || (tokens[1] != ":") {
+				return nil, fmt.Errorf("Expected default : <label>")
+			}
+			return p.label(tokens[2]), nil
+		}
+		e = handleCase(tokens)
+		if e != nil {
+			return nil, e
+		}
+	}
+}
+
+// Emits a tableswitch, reading its targets from the following lines.
+func (p *parser) tableSwitch(args []string) error {
+	if (len(args) < 1) || (len(args) > 2) {
+		return fmt.Errorf("Expected tableswitch <low> [<high>]")
+	}
+	low, e := parseInt(args[0], 32)
+	if e != nil {
+		return e
+	}
+	var targets []*builder.Label
+	defaultTarget, e := p.switchTargets(func(tokens []string) error {
+		if len(tokens) != 1 {
+			return fmt.Errorf("Expected a single tableswitch target")
+		}
+		targets = append(targets, p.label(tokens[0]))
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	if len(args) == 2 {
+		high, e := parseInt(args[1], 32)
+		if e != nil {
+			return e
+		}
+		if (high - low + 1) != int64(len(targets)) {
+			return fmt.Errorf("Expected %d tableswitch targets, got %d",
+				high-low+1, len(targets))
+		}
+	}
+	if (low + int64(len(targets)) - 1) > math.MaxInt32 {
+		return fmt.Errorf("Too many tableswitch targets")
+	}
+	p.method.m.EmitTableSwitch(int32(low), defaultTarget, targets...)
+	return nil
+}
+
+// Emits a lookupswitch, reading its keys and targets from the following
+// lines.
+func (p *parser) lookupSwitch(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Unexpected operand: %s", args[1])
+	}
+	var keys []int32
+	var targets []*builder.Label
+	defaultTarget, e := p.switchTargets(func(tokens []string) error {
+		if (len(tokens) != 3) || (tokens[1] != ":") {
+			return fmt.Errorf("Expected <key> : <label>")
+		}
+		key, e := parseInt(tokens[0], 32)
+		if e != nil {
+			return e
+		}
+		keys = append(keys, int32(key))
+		targets = append(targets, p.label(tokens[2]))
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	p.method.m.EmitLookupSwitch(defaultTarget, keys, targets)
+	return nil
+}
+
+// Processes the next line of the source. Returns io.EOF at the end of the
+// input.
+func (p *parser) processLine() error {
+	tokens, e := p.nextLine()
+	if e != nil {
+		return e
+	}
+	if tokens == nil {
+		return io.EOF
+	}
+	if p.method != nil {
+		return p.methodLine(tokens)
+	}
+	return p.classDirective(tokens)
+}
+
+// Reads a class in the assembly format from the given reader, and returns a
+// builder containing the class. Errors include the line on which they
+// occurred.
+func Assemble(source io.Reader) (*builder.ClassBuilder, error) {
+	p := &parser{
+		scanner: bufio.NewScanner(source),
+	}
+	for {
+		e := p.processLine()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, fmt.Errorf("Line %d: %w", p.line, e)
+		}
+	}
+	if p.method != nil {
+		return nil, fmt.Errorf("Line %d: Missing .end method",
+			p.methodNameLine)
+	}
+	return p.getClass()
+}
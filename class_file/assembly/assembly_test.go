@@ -0,0 +1,209 @@
+package assembly
+
+import (
+	"bytes"
+	"github.com/yalue/bs_jvm/class_file"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Assembles the given source, returning the class file.
+func assembleString(t *testing.T, source string) *class_file.Class {
+	b, e := Assemble(strings.NewReader(source))
+	if e != nil {
+		t.Logf("Failed assembling the source: %s\n", e)
+		t.FailNow()
+	}
+	toReturn, e := b.Build()
+	if e != nil {
+		t.Logf("Failed building the assembled class: %s\n", e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+// Disassembles the class, returning the text.
+func disassembleString(t *testing.T, c *class_file.Class) string {
+	var output bytes.Buffer
+	e := Disassemble(&output, c)
+	if e != nil {
+		t.Logf("Failed disassembling the class: %s\n", e)
+		t.FailNow()
+	}
+	return output.String()
+}
+
+// Checks that assembling and disassembling the text reproduces it exactly.
+func checkRoundTrip(t *testing.T, text string) {
+	reassembled := disassembleString(t, assembleString(t, text))
+	if reassembled == text {
+		return
+	}
+	original := strings.Split(text, "\n")
+	lines := strings.Split(reassembled, "\n")
+	for i := range original {
+		if (i >= len(lines)) || (lines[i] != original[i]) {
+			t.Logf("The reassembled class differs on line %d\n", i+1)
+			break
+		}
+	}
+	t.Logf("Reassembled class:\n%s\n", reassembled)
+	t.FailNow()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"RandomDots", "RandomDotsSimple"} {
+		content, e := ioutil.ReadFile("../test_data/" + name + ".class")
+		if e != nil {
+			t.Logf("Failed reading %s.class: %s\n", name, e)
+			t.FailNow()
+		}
+		class, e := class_file.ParseClass(bytes.NewReader(content))
+		if e != nil {
+			t.Logf("Failed parsing %s.class: %s\n", name, e)
+			t.FailNow()
+		}
+		text := disassembleString(t, class)
+		if !strings.Contains(text, ".class public super "+name+"\n") {
+			t.Logf("Missing the class directive in %s:\n%s\n", name, text)
+			t.FailNow()
+		}
+		checkRoundTrip(t, text)
+	}
+}
+
+// Contains a variety of operands and directives that don't appear in the
+// test class files.
+const testSource = `
+; A comment, followed by a blank line.
+
+.bytecode 52.0
+.class public final Example ; The class' name.
+.implements java/lang/Runnable
+.field public static final LIMIT J = 100
+.field static NAME Ljava/lang/String; = "a \"name\"; with a semicolon"
+.field private ratio F = 1.5f
+.bootstrap invokestatic Example/bootstrap()V 7 2.5 methodtype ()V
+
+.method public <init>()V
+    aload_0
+    invokespecial java/lang/Object/<init>()V
+    return
+.end method
+
+.method public run()V
+    .throws java/lang/Exception
+    .catch java/lang/ArithmeticException from Start to End using Handler
+Start:
+    .line 10
+    sipush 1000
+    istore 300
+    iinc 300 -2000
+    iload 300
+    iconst_1
+    idiv
+    lookupswitch
+        -5 : End
+        100 : End
+        default : End
+End:
+    ldc "text"
+    pop
+    ldc2_w 3
+    pop2
+    ldc_w class [I
+    pop
+    ldc2_w dynamic answer J 0
+    pop2
+    invokedynamic get()I 0
+    tableswitch 0
+        Done
+        default : Done
+Handler:
+    astore_1
+    aload_0
+    invokeinterface java/lang/Runnable/run()V 1
+Done:
+    return
+.end method
+
+.method public abstract unused()V
+.end method
+`
+
+func TestAssembleSource(t *testing.T) {
+	class := assembleString(t, testSource)
+	text := disassembleString(t, class)
+	t.Logf("Disassembled test source:\n%s\n", text)
+	expectedLines := []string{
+		".class public final Example",
+		".super java/lang/Object",
+		".field public static final LIMIT J = 100L",
+		`.field static NAME Ljava/lang/String; = "a \"name\"; with a ` +
+			`semicolon"`,
+		".field private ratio F = 1.5f",
+		"    istore 300",
+		"    iinc 300 -2000",
+		"    ldc2_w 3L",
+		"    ldc_w class [I",
+		"    ldc2_w dynamic answer J 0",
+		"    invokedynamic get()I 0",
+		"    invokeinterface java/lang/Runnable/run()V 1",
+		"    .catch java/lang/ArithmeticException from L0 to L44 using L84",
+		"    .throws java/lang/Exception",
+		"    .line 10",
+		".method public abstract unused()V",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(text, line+"\n") {
+			t.Logf("Missing expected line: %s\n", line)
+			t.Fail()
+		}
+	}
+	if !strings.Contains(text, " 7 2.5d methodtype ()V\n") {
+		t.Logf("Missing the bootstrap method's arguments\n")
+		t.Fail()
+	}
+	checkRoundTrip(t, text)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	header := ".class public Broken\n.method static f()V\n"
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{".super java/lang/Object\n", "Missing .class"},
+		{".class A\n.class B\n", "Line 2: Only one class"},
+		{".class bogus A\n", "Line 1: Invalid access flag: bogus"},
+		{header + "    iload x\n", "Line 3: Invalid local variable index"},
+		{header + "    bogus\n", "Line 3: Unknown instruction: bogus"},
+		{header + "    goto Nowhere\n.end method\n", "Line 4: Label Nowhere, " +
+			"used on line 3, is never defined"},
+		{header + "A:\nA:\n", "Line 4: Label A is defined more than once"},
+		{header + "    return\n", "Line 2: Missing .end method"},
+		{header + "    iconst_0 1\n", "Line 3: iconst_0 expects 0 operands"},
+		{header + "    bipush 200\n", "Line 3: Invalid 8-bit integer: 200"},
+		{header + "    ldc \"unterminated\n", "Line 3: Unterminated string"},
+		{header + "    ldc dynamic x I\n", "Line 3: Expected a dynamic " +
+			"constant's name"},
+		{header + "    tableswitch 0 2\n    A\n    default : A\nA:\n",
+			"Line 5: Expected 3 tableswitch targets, got 1"},
+		{".class A\n.field static x I = 1.5f\n", "Line 2: A field with " +
+			"descriptor I can't have the value 1.5f"},
+	}
+	for _, test := range tests {
+		_, e := Assemble(strings.NewReader(test.source))
+		if e == nil {
+			t.Logf("Didn't get an error assembling:\n%s\n", test.source)
+			t.Fail()
+			continue
+		}
+		t.Logf("Got expected error: %s\n", e)
+		if !strings.Contains(e.Error(), test.expected) {
+			t.Logf("Expected the error to contain \"%s\"\n", test.expected)
+			t.Fail()
+		}
+	}
+}
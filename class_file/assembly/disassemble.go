@@ -0,0 +1,640 @@
+package assembly
+
+// This file contains the code for converting a class file into the assembly
+// format accepted by Assemble.
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Holds the state used when disassembling a class.
+type disassembler struct {
+	class  *class_file.Class
+	output *bytes.Buffer
+}
+
+// Writes a single line of output.
+func (d *disassembler) printf(format string, args ...interface{}) {
+	fmt.Fprintf(d.output, format, args...)
+	d.output.WriteByte('\n')
+}
+
+// Returns the contents of the UTF-8 constant at the given index.
+func (d *disassembler) utf8(index uint16) (string, error) {
+	toReturn, e := d.class.GetUTF8Constant(index)
+	if e != nil {
+		return "", e
+	}
+	return string(toReturn), nil
+}
+
+// Returns the name of the class constant at the given index.
+func (d *disassembler) className(index uint16) (string, error) {
+	toReturn, e := d.class.GetClassConstantName(index)
+	if e != nil {
+		return "", e
+	}
+	return string(toReturn), nil
+}
+
+// Returns the name and descriptor in the name and type constant at the given
+// index.
+func (d *disassembler) nameAndType(index uint16) (string, string, error) {
+	c, e := d.class.GetConstant(index)
+	if e != nil {
+		return "", "", e
+	}
+	info, ok := c.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return "", "", fmt.Errorf("Constant %d isn't a name and type", index)
+	}
+	name, e := d.utf8(info.NameIndex)
+	if e != nil {
+		return "", "", e
+	}
+	descriptor, e := d.utf8(info.DescriptorIndex)
+	if e != nil {
+		return "", "", e
+	}
+	return name, descriptor, nil
+}
+
+// Formats a field or method reference using its class and name and type
+// constants. Fields are separated from their descriptor by a space.
+func (d *disassembler) member(classIndex, nameAndTypeIndex uint16,
+	isField bool) (string, error) {
+	class, e := d.className(classIndex)
+	if e != nil {
+		return "", e
+	}
+	name, descriptor, e := d.nameAndType(nameAndTypeIndex)
+	if e != nil {
+		return "", e
+	}
+	if isField {
+		return class + "/" + name + " " + descriptor, nil
+	}
+	return class + "/" + name + descriptor, nil
+}
+
+// Formats the field, method, or interface method reference at the given
+// index. Interface methods are prefixed with "interface" unless
+// omitInterface is set.
+func (d *disassembler) reference(index uint16, omitInterface bool) (string,
+	error) {
+	c, e := d.class.GetConstant(index)
+	if e != nil {
+		return "", e
+	}
+	switch v := c.(type) {
+	case *class_file.ConstantFieldInfo:
+		return d.member(v.ClassIndex, v.NameAndTypeIndex, true)
+	case *class_file.ConstantMethodInfo:
+		return d.member(v.ClassIndex, v.NameAndTypeIndex, false)
+	case *class_file.ConstantInterfaceMethodInfo:
+		toReturn, e := d.member(v.ClassIndex, v.NameAndTypeIndex, false)
+		if omitInterface {
+			return toReturn, e
+		}
+		return "interface " + toReturn, e
+	}
+	return "", fmt.Errorf("Constant %d isn't a field or method reference",
+		index)
+}
+
+// Formats a floating-point number, followed by the given suffix.
+func formatFloat(v float64, bits int, suffix string) string {
+	if math.IsNaN(v) {
+		return "NaN" + suffix
+	}
+	return strconv.FormatFloat(v, 'g', -1, bits) + suffix
+}
+
+// Formats a method handle as "<kind> <field or method>".
+func (d *disassembler) methodHandle(index uint16) (string, error) {
+	c, e := d.class.GetConstant(index)
+	if e != nil {
+		return "", e
+	}
+	handle, ok := c.(*class_file.ConstantMethodHandleInfo)
+	if !ok {
+		return "", fmt.Errorf("Constant %d isn't a method handle", index)
+	}
+	kind := int(handle.ReferenceKind)
+	if (kind < 1) || (kind >= len(methodHandleKinds)) {
+		return "", fmt.Errorf("Invalid method handle kind: %d", kind)
+	}
+	reference, e := d.reference(handle.Index, false)
+	if e != nil {
+		return "", e
+	}
+	return methodHandleKinds[kind] + " " + reference, nil
+}
+
+// Formats the loadable constant at the given index, in the form accepted by
+// ldc and .bootstrap.
+func (d *disassembler) constant(index uint16) (string, error) {
+	c, e := d.class.GetConstant(index)
+	if e != nil {
+		return "", e
+	}
+	switch v := c.(type) {
+	case *class_file.ConstantIntegerInfo:
+		return strconv.Itoa(int(v.Value)), nil
+	case *class_file.ConstantLongInfo:
+		return strconv.FormatInt(v.Value, 10) + "L", nil
+	case *class_file.ConstantFloatInfo:
+		return formatFloat(float64(v.Value), 32, "f"), nil
+	case *class_file.ConstantDoubleInfo:
+		return formatFloat(v.Value, 64, "d"), nil
+	case *class_file.ConstantStringInfo:
+		s, e := d.utf8(v.StringIndex)
+		return strconv.Quote(s), e
+	case *class_file.ConstantClassInfo:
+		name, e := d.utf8(v.NameIndex)
+		return "class " + name, e
+	case *class_file.ConstantMethodTypeInfo:
+		descriptor, e := d.utf8(v.DescriptorIndex)
+		return "methodtype " + descriptor, e
+	case *class_file.ConstantMethodHandleInfo:
+		handle, e := d.methodHandle(index)
+		return "methodhandle " + handle, e
+	case *class_file.ConstantDynamicInfo:
+		name, descriptor, e := d.nameAndType(v.NameAndTypeIndex)
+		return fmt.Sprintf("dynamic %s %s %d", name, descriptor,
+			v.BootstrapMethodAttributeIndex), e
+	}
+	return "", fmt.Errorf("Constant %d can't be loaded by ldc", index)
+}
+
+// Writes the flags and name of a class, field, or method declaration,
+// omitting the flags if there are none.
+func declaration(directive, flags, name string) string {
+	if flags == "" {
+		return directive + " " + name
+	}
+	return directive + " " + flags + " " + name
+}
+
+// Writes the class-level directives.
+func (d *disassembler) header() error {
+	c := d.class
+	d.printf(".bytecode %d.%d", c.MajorVersion, c.MinorVersion)
+	for _, a := range c.Attributes {
+		if string(a.Name) != "SourceFile" {
+			continue
+		}
+		index, e := class_file.ParseSourceFileAttribute(a)
+		if e != nil {
+			return e
+		}
+		name, e := d.utf8(index)
+		if e != nil {
+			return e
+		}
+		d.printf(".source %s", name)
+	}
+	name, e := c.GetName()
+	if e != nil {
+		return e
+	}
+	d.printf("%s", declaration(".class", c.Access.String(), string(name)))
+	if c.SuperClass != 0 {
+		superName, e := c.GetSuperClassName()
+		if e != nil {
+			return e
+		}
+		d.printf(".super %s", superName)
+	}
+	interfaces, e := c.GetInterfaceNames()
+	if e != nil {
+		return e
+	}
+	for _, name := range interfaces {
+		d.printf(".implements %s", name)
+	}
+	return nil
+}
+
+// Writes a .bootstrap directive for each of the class' bootstrap methods.
+func (d *disassembler) bootstrapMethods() error {
+	for _, a := range d.class.Attributes {
+		if string(a.Name) != "BootstrapMethods" {
+			continue
+		}
+		methods, e := class_file.ParseBootstrapMethodsAttribute(a)
+		if e != nil {
+			return e
+		}
+		d.printf("")
+		for _, m := range methods {
+			parts := make([]string, 0, len(m.Arguments)+2)
+			handle, e := d.methodHandle(m.Reference)
+			if e != nil {
+				return e
+			}
+			parts = append(parts, ".bootstrap", handle)
+			for _, index := range m.Arguments {
+				argument, e := d.constant(index)
+				if e != nil {
+					return e
+				}
+				parts = append(parts, argument)
+			}
+			d.printf("%s", strings.Join(parts, " "))
+		}
+	}
+	return nil
+}
+
+// Writes a .field directive.
+func (d *disassembler) field(f *class_file.Field) error {
+	descriptor, e := d.utf8(f.DescriptorIndex)
+	if e != nil {
+		return e
+	}
+	text := declaration(".field", f.Access.String(), string(f.Name)) + " " +
+		descriptor
+	for _, a := range f.Attributes {
+		if string(a.Name) != "ConstantValue" {
+			continue
+		}
+		if len(a.Info) != 2 {
+			return fmt.Errorf("Invalid ConstantValue attribute for field %s",
+				f.Name)
+		}
+		value, e := d.constant((uint16(a.Info[0]) << 8) | uint16(a.Info[1]))
+		if e != nil {
+			return e
+		}
+		text += " = " + value
+	}
+	d.printf("%s", text)
+	return nil
+}
+
+// Holds a single decoded instruction.
+type instruction struct {
+	offset int
+	text   string
+	// The lines following the instruction, used for switch targets.
+	targets []string
+}
+
+// Returns the name of the label at the given offset.
+func labelName(offset int) string {
+	return fmt.Sprintf("L%d", offset)
+}
+
+// Reads a big-endian signed 32-bit integer.
+func readInt32(data []byte) int32 {
+	return int32((uint32(data[0]) << 24) | (uint32(data[1]) << 16) |
+		(uint32(data[2]) << 8) | uint32(data[3]))
+}
+
+// Decodes a tableswitch or lookupswitch instruction at the given offset.
+// Records the targets in the labels map, and returns the instruction and the
+// offset of the following instruction.
+func decodeSwitch(code []byte, offset int, labels map[int]bool) (
+	*instruction, int, error) {
+	op := builder.Opcode(code[offset])
+	toReturn := &instruction{
+		offset: offset,
+		text:   op.String(),
+	}
+	// The operands are aligned to a multiple of 4 bytes.
+	i := (offset + 4) &^ 3
+	readValue := func() (int32, error) {
+		if (i + 4) > len(code) {
+			return 0, fmt.Errorf("The %s at offset %d is truncated", op,
+				offset)
+		}
+		i += 4
+		return readInt32(code[i-4:]), nil
+	}
+	target := func(relative int32) string {
+		labels[offset+int(relative)] = true
+		return labelName(offset + int(relative))
+	}
+	defaultTarget, e := readValue()
+	if e != nil {
+		return nil, 0, e
+	}
+	first, e := readValue()
+	if e != nil {
+		return nil, 0, e
+	}
+	if op == builder.Tableswitch {
+		last, e := readValue()
+		if e != nil {
+			return nil, 0, e
+		}
+		if last < first {
+			return nil, 0, fmt.Errorf("Invalid tableswitch at offset %d",
+				offset)
+		}
+		toReturn.text += fmt.Sprintf(" %d %d", first, last)
+		for n := int64(first); n <= int64(last); n++ {
+			relative, e := readValue()
+			if e != nil {
+				return nil, 0, e
+			}
+			toReturn.targets = append(toReturn.targets, target(relative))
+		}
+	} else {
+		if first < 0 {
+			return nil, 0, fmt.Errorf("Invalid lookupswitch at offset %d",
+				offset)
+		}
+		for n := int32(0); n < first; n++ {
+			key, e := readValue()
+			if e != nil {
+				return nil, 0, e
+			}
+			relative, e := readValue()
+			if e != nil {
+				return nil, 0, e
+			}
+			toReturn.targets = append(toReturn.targets,
+				fmt.Sprintf("%d : %s", key, target(relative)))
+		}
+	}
+	toReturn.targets = append(toReturn.targets, "default : "+
+		target(defaultTarget))
+	return toReturn, i, nil
+}
+
+// Decodes the instruction at the given offset. Records any branch targets in
+// the labels map, and returns the instruction and the offset of the following
+// instruction.
+func (d *disassembler) decode(code []byte, offset int,
+	labels map[int]bool) (*instruction, int, error) {
+	op := builder.Opcode(code[offset])
+	if !op.IsValid() {
+		return nil, 0, fmt.Errorf("Invalid opcode 0x%02x at offset %d",
+			code[offset], offset)
+	}
+	if (op == builder.Tableswitch) || (op == builder.Lookupswitch) {
+		return decodeSwitch(code, offset, labels)
+	}
+	operandBytes := op.OperandBytes()
+	wide := op == builder.Wide
+	if wide {
+		if (offset + 1) >= len(code) {
+			return nil, 0, fmt.Errorf("Truncated wide instruction at %d",
+				offset)
+		}
+		op = builder.Opcode(code[offset+1])
+		operandBytes = 3
+		if op == builder.Iinc {
+			operandBytes = 5
+		}
+	}
+	next := offset + 1 + operandBytes
+	if next > len(code) {
+		return nil, 0, fmt.Errorf("The %s at offset %d is truncated", op,
+			offset)
+	}
+	operands := code[offset+1 : next]
+	if wide {
+		operands = operands[1:]
+	}
+	u16 := func() uint16 {
+		return (uint16(operands[0]) << 8) | uint16(operands[1])
+	}
+	toReturn := &instruction{
+		offset: offset,
+		text:   op.String(),
+	}
+	var operandText string
+	var e error
+	switch {
+	case isBranch(op):
+		relative := int(int16(u16()))
+		if operandBytes == 4 {
+			relative = int(readInt32(operands))
+		}
+		labels[offset+relative] = true
+		operandText = labelName(offset + relative)
+	case op == builder.Iinc:
+		if wide {
+			operandText = fmt.Sprintf("%d %d", u16(),
+				int16((uint16(operands[2])<<8)|uint16(operands[3])))
+		} else {
+			operandText = fmt.Sprintf("%d %d", operands[0],
+				int8(operands[1]))
+		}
+	case wide:
+		operandText = strconv.Itoa(int(u16()))
+	case ((op >= builder.Iload) && (op <= builder.Aload)) ||
+		((op >= builder.Istore) && (op <= builder.Astore)) ||
+		(op == builder.Ret):
+		operandText = strconv.Itoa(int(operands[0]))
+	case op == builder.Bipush:
+		operandText = strconv.Itoa(int(int8(operands[0])))
+	case op == builder.Sipush:
+		operandText = strconv.Itoa(int(int16(u16())))
+	case op == builder.Ldc:
+		operandText, e = d.constant(uint16(operands[0]))
+	case (op == builder.Ldc_w) || (op == builder.Ldc2_w):
+		operandText, e = d.constant(u16())
+	case (op >= builder.Getstatic) && (op <= builder.Invokestatic):
+		operandText, e = d.reference(u16(), false)
+	case op == builder.Invokeinterface:
+		operandText, e = d.reference(u16(), true)
+		operandText += fmt.Sprintf(" %d", operands[2])
+	case op == builder.Invokedynamic:
+		operandText, e = d.invokeDynamic(u16())
+	case (op == builder.New) || (op == builder.Anewarray) ||
+		(op == builder.Checkcast) || (op == builder.Instanceof):
+		operandText, e = d.className(u16())
+	case op == builder.Newarray:
+		arrayType := int(operands[0])
+		if (arrayType >= len(arrayTypes)) || (arrayTypes[arrayType] == "") {
+			return nil, 0, fmt.Errorf("Invalid newarray type: %d", arrayType)
+		}
+		operandText = arrayTypes[arrayType]
+	case op == builder.Multianewarray:
+		operandText, e = d.className(u16())
+		operandText += fmt.Sprintf(" %d", operands[2])
+	}
+	if e != nil {
+		return nil, 0, fmt.Errorf("Invalid operand for the %s at offset "+
+			"%d: %w", op, offset, e)
+	}
+	if operandText != "" {
+		toReturn.text += " " + operandText
+	}
+	return toReturn, next, nil
+}
+
+// Formats the operands of an invokedynamic instruction referring to the
+// constant at the given index.
+func (d *disassembler) invokeDynamic(index uint16) (string, error) {
+	c, e := d.class.GetConstant(index)
+	if e != nil {
+		return "", e
+	}
+	info, ok := c.(*class_file.ConstantInvokeDynamicInfo)
+	if !ok {
+		return "", fmt.Errorf("Constant %d isn't an invokedynamic constant",
+			index)
+	}
+	name, descriptor, e := d.nameAndType(info.NameAndTypeIndex)
+	if e != nil {
+		return "", e
+	}
+	return fmt.Sprintf("%s%s %d", name, descriptor,
+		info.BootstrapMethodAttributeIndex), nil
+}
+
+// Writes the directives and instructions in a method's code attribute.
+func (d *disassembler) code(code *class_file.CodeAttribute) error {
+	labels := make(map[int]bool)
+	var instructions []*instruction
+	offset := 0
+	for offset < len(code.Code) {
+		instruction, next, e := d.decode(code.Code, offset, labels)
+		if e != nil {
+			return e
+		}
+		instructions = append(instructions, instruction)
+		offset = next
+	}
+	d.printf("    .limit stack %d", code.MaxStack)
+	d.printf("    .limit locals %d", code.MaxLocals)
+	for _, entry := range code.ExceptionTable {
+		catchType := "all"
+		if entry.CatchType != 0 {
+			name, e := d.className(entry.CatchType)
+			if e != nil {
+				return e
+			}
+			catchType = name
+		}
+		for _, pc := range []uint16{entry.StartPC, entry.EndPC,
+			entry.HandlerPC} {
+			labels[int(pc)] = true
+		}
+		d.printf("    .catch %s from %s to %s using %s", catchType,
+			labelName(int(entry.StartPC)), labelName(int(entry.EndPC)),
+			labelName(int(entry.HandlerPC)))
+	}
+	lines := make(map[int][]uint16)
+	for _, a := range code.Attributes {
+		if string(a.Name) != "LineNumberTable" {
+			continue
+		}
+		entries, e := class_file.ParseLineNumberTableAttribute(a)
+		if e != nil {
+			return e
+		}
+		for _, entry := range entries {
+			pc := int(entry.StartPC)
+			lines[pc] = append(lines[pc], entry.LineNumber)
+		}
+	}
+	for _, instruction := range instructions {
+		if labels[instruction.offset] {
+			d.printf("%s:", labelName(instruction.offset))
+		}
+		for _, line := range lines[instruction.offset] {
+			d.printf("    .line %d", line)
+		}
+		d.printf("    %s", instruction.text)
+		for _, target := range instruction.targets {
+			d.printf("        %s", target)
+		}
+	}
+	// Labels may refer to the end of the code, e.g. in exception handlers.
+	if labels[len(code.Code)] {
+		d.printf("%s:", labelName(len(code.Code)))
+	}
+	for target := range labels {
+		if (target < 0) || (target > len(code.Code)) {
+			return fmt.Errorf("Invalid branch target: %d", target)
+		}
+	}
+	return nil
+}
+
+// Writes a method, from its .method directive to .end method.
+func (d *disassembler) method(m *class_file.Method) error {
+	descriptor, e := d.utf8(m.DescriptorIndex)
+	if e != nil {
+		return e
+	}
+	d.printf("%s", declaration(".method", m.Access.String(),
+		string(m.Name)+descriptor))
+	for _, a := range m.Attributes {
+		switch string(a.Name) {
+		case "Exceptions":
+			indices, e := class_file.ParseExceptionsAttribute(a)
+			if e != nil {
+				return e
+			}
+			for _, index := range indices {
+				name, e := d.className(index)
+				if e != nil {
+					return e
+				}
+				d.printf("    .throws %s", name)
+			}
+		case "Code":
+			code, e := class_file.ParseCodeAttribute(a, d.class)
+			if e != nil {
+				return e
+			}
+			e = d.code(code)
+			if e != nil {
+				return fmt.Errorf("Couldn't disassemble method %s: %w",
+					m.Name, e)
+			}
+		}
+	}
+	d.printf(".end method")
+	return nil
+}
+
+// Writes the class to the given writer in the format accepted by Assemble.
+// Attributes that can't be represented in the format, such as annotations,
+// are omitted. Stack map frames are omitted, since Assemble computes them.
+func Disassemble(w io.Writer, c *class_file.Class) error {
+	d := &disassembler{
+		class:  c,
+		output: &bytes.Buffer{},
+	}
+	e := d.header()
+	if e != nil {
+		return e
+	}
+	e = d.bootstrapMethods()
+	if e != nil {
+		return e
+	}
+	if len(c.Fields) != 0 {
+		d.printf("")
+	}
+	for _, f := range c.Fields {
+		e = d.field(f)
+		if e != nil {
+			return e
+		}
+	}
+	for _, m := range c.Methods {
+		d.printf("")
+		e = d.method(m)
+		if e != nil {
+			return e
+		}
+	}
+	_, e = w.Write(d.output.Bytes())
+	return e
+}
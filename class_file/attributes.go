@@ -21,6 +21,10 @@ func readUint16BigEndian(data []byte) uint16 {
 type Attribute struct {
 	// A UTF-8 string representing the attribute's name.
 	Name []byte
+	// The index of the UTF-8 constant containing the attribute's name. When
+	// the class is written, this is only used if it still refers to a
+	// constant matching Name.
+	NameIndex uint16
 	// The actual bytes of the attribute.
 	Info []byte
 }
@@ -47,6 +51,21 @@ func (a *Attribute) ToConstantValueAttribute(c *Class) (
 	return &ConstantValueAttribute{Value: value}, nil
 }
 
+// Returns a ConstantValue attribute referring to the constant in a. The
+// constant must be one of the entries in the class' constant pool.
+func EncodeConstantValueAttribute(a *ConstantValueAttribute, c *Class) (
+	*Attribute, error) {
+	for i, constant := range c.Constants {
+		if (i != 0) && (constant == a.Value) {
+			return &Attribute{
+				Name: []byte("ConstantValue"),
+				Info: []byte{byte(i >> 8), byte(i)},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("The constant value isn't in the constant pool")
+}
+
 // Contains parsed data from a code attribute
 type CodeAttribute struct {
 	MaxStack       uint16
@@ -103,6 +122,34 @@ func ParseCodeAttribute(a *Attribute, c *Class) (*CodeAttribute, error) {
 	return &toReturn, nil
 }
 
+// Returns a Code attribute containing the information in code. This is the
+// inverse of ParseCodeAttribute. The names of the code's attributes must be in
+// the class' constant pool.
+func EncodeCodeAttribute(code *CodeAttribute, c *Class) (*Attribute, error) {
+	// Like ParseCodeAttribute, enforce the limits on code length from the
+	// spec.
+	if (len(code.Code) == 0) || (len(code.Code) > 0xffff) {
+		return nil, fmt.Errorf("Invalid code length: %d", len(code.Code))
+	}
+	data := &bytes.Buffer{}
+	binary.Write(data, binary.BigEndian, code.MaxStack)
+	binary.Write(data, binary.BigEndian, code.MaxLocals)
+	binary.Write(data, binary.BigEndian, uint32(len(code.Code)))
+	data.Write(code.Code)
+	e := writeExceptionTable(data, code.ExceptionTable)
+	if e != nil {
+		return nil, e
+	}
+	e = c.writeAttributesTable(data, code.Attributes)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't write code attributes: %w", e)
+	}
+	return &Attribute{
+		Name: []byte("Code"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Parses an Exceptions attribute. Returns an error if one occurs, otherwise
 // returns a slice of exception table indices.
 func ParseExceptionsAttribute(a *Attribute) ([]uint16, error) {
@@ -123,6 +170,21 @@ func ParseExceptionsAttribute(a *Attribute) ([]uint16, error) {
 	return toReturn, nil
 }
 
+// Returns an Exceptions attribute containing the given exception class
+// indices. This is the inverse of ParseExceptionsAttribute.
+func EncodeExceptionsAttribute(indices []uint16) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(indices), "exceptions")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, indices)
+	return &Attribute{
+		Name: []byte("Exceptions"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Contains parsed inner class information from an InnerClasses attribute.
 type InnerClass struct {
 	InnerClassInfoIndex   uint16
@@ -150,6 +212,21 @@ func ParseInnerClassesAttribute(a *Attribute) ([]InnerClass, error) {
 	return toReturn, nil
 }
 
+// Returns an InnerClasses attribute containing the given inner classes. This
+// is the inverse of ParseInnerClassesAttribute.
+func EncodeInnerClassesAttribute(classes []InnerClass) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(classes), "InnerClasses")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, classes)
+	return &Attribute{
+		Name: []byte("InnerClasses"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Returns the class index and method index, respectively, contained in an
 // EnclosingMethod attribute.
 func ParseEnclosingMethodAttribute(a *Attribute) (uint16, uint16, error) {
@@ -169,6 +246,16 @@ func ParseEnclosingMethodAttribute(a *Attribute) (uint16, uint16, error) {
 	return classIndex, methodIndex, nil
 }
 
+// Returns an EnclosingMethod attribute containing the given class and method
+// indices. This is the inverse of ParseEnclosingMethodAttribute.
+func EncodeEnclosingMethodAttribute(classIndex, methodIndex uint16) *Attribute {
+	return &Attribute{
+		Name: []byte("EnclosingMethod"),
+		Info: []byte{byte(classIndex >> 8), byte(classIndex),
+			byte(methodIndex >> 8), byte(methodIndex)},
+	}
+}
+
 // Returns the signature index contained in a signature attribute.
 func ParseSignatureAttribute(a *Attribute) (uint16, error) {
 	if string(a.Name) != "Signature" {
@@ -183,6 +270,15 @@ func ParseSignatureAttribute(a *Attribute) (uint16, error) {
 	return toReturn, nil
 }
 
+// Returns a Signature attribute containing the given signature index. This is
+// the inverse of ParseSignatureAttribute.
+func EncodeSignatureAttribute(index uint16) *Attribute {
+	return &Attribute{
+		Name: []byte("Signature"),
+		Info: []byte{byte(index >> 8), byte(index)},
+	}
+}
+
 // Returns the source file index contained in a source file attribute.
 func ParseSourceFileAttribute(a *Attribute) (uint16, error) {
 	if string(a.Name) != "SourceFile" {
@@ -197,6 +293,15 @@ func ParseSourceFileAttribute(a *Attribute) (uint16, error) {
 	return toReturn, nil
 }
 
+// Returns a SourceFile attribute containing the given source file index. This
+// is the inverse of ParseSourceFileAttribute.
+func EncodeSourceFileAttribute(index uint16) *Attribute {
+	return &Attribute{
+		Name: []byte("SourceFile"),
+		Info: []byte{byte(index >> 8), byte(index)},
+	}
+}
+
 // Holds a single entry from a line number table
 type LineNumberEntry struct {
 	StartPC    uint16
@@ -223,6 +328,22 @@ func ParseLineNumberTableAttribute(a *Attribute) ([]LineNumberEntry, error) {
 	return toReturn, nil
 }
 
+// Returns a LineNumberTable attribute containing the given entries. This is
+// the inverse of ParseLineNumberTableAttribute.
+func EncodeLineNumberTableAttribute(entries []LineNumberEntry) (*Attribute,
+	error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(entries), "line number table entries")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, entries)
+	return &Attribute{
+		Name: []byte("LineNumberTable"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Holds a single entry from a local variable table
 type LocalVariableEntry struct {
 	StartPC         uint16
@@ -252,6 +373,22 @@ func ParseLocalVariableTableAttribute(a *Attribute) ([]LocalVariableEntry,
 	return toReturn, nil
 }
 
+// Returns a LocalVariableTable attribute containing the given entries. This
+// is the inverse of ParseLocalVariableTableAttribute.
+func EncodeLocalVariableTableAttribute(entries []LocalVariableEntry) (
+	*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(entries), "local variable table entries")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, entries)
+	return &Attribute{
+		Name: []byte("LocalVariableTable"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Holds a single entry from a local variable type table
 type LocalVariableTypeEntry struct {
 	StartPC        uint16
@@ -283,6 +420,22 @@ func ParseLocalVariableTypeTableAttribute(a *Attribute) (
 	return toReturn, nil
 }
 
+// Returns a LocalVariableTypeTable attribute containing the given entries.
+// This is the inverse of ParseLocalVariableTypeTableAttribute.
+func EncodeLocalVariableTypeTableAttribute(
+	entries []LocalVariableTypeEntry) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(entries), "local variable type table entries")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, entries)
+	return &Attribute{
+		Name: []byte("LocalVariableTypeTable"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Holds a single entry from a bootstrap methods attribute.
 type BootstrapMethod struct {
 	// The index of a method handle info entry in the constant table.
@@ -326,6 +479,29 @@ func ParseBootstrapMethodsAttribute(a *Attribute) ([]BootstrapMethod, error) {
 	return toReturn, nil
 }
 
+// Returns a BootstrapMethods attribute containing the given methods. This is
+// the inverse of ParseBootstrapMethodsAttribute.
+func EncodeBootstrapMethodsAttribute(methods []BootstrapMethod) (*Attribute,
+	error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(methods), "bootstrap methods")
+	if e != nil {
+		return nil, e
+	}
+	for _, m := range methods {
+		binary.Write(data, binary.BigEndian, m.Reference)
+		e = writeCount(data, len(m.Arguments), "bootstrap method arguments")
+		if e != nil {
+			return nil, e
+		}
+		binary.Write(data, binary.BigEndian, m.Arguments)
+	}
+	return &Attribute{
+		Name: []byte("BootstrapMethods"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // A single entry in a MethodParameters attribute.
 type MethodParameter struct {
 	NameIndex   uint16
@@ -340,7 +516,7 @@ func ParseMethodParametersAttribute(a *Attribute) ([]MethodParameter, error) {
 	}
 	data := bytes.NewReader(a.Info)
 	var count uint8
-	e := binary.Read(data, binary.BigEndian, &data)
+	e := binary.Read(data, binary.BigEndian, &count)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't read method parameter count: %s", e)
 	}
@@ -352,6 +528,175 @@ func ParseMethodParametersAttribute(a *Attribute) ([]MethodParameter, error) {
 	return toReturn, nil
 }
 
+// Returns a MethodParameters attribute containing the given parameters. This
+// is the inverse of ParseMethodParametersAttribute.
+func EncodeMethodParametersAttribute(parameters []MethodParameter) (
+	*Attribute, error) {
+	if len(parameters) > 0xff {
+		return nil, fmt.Errorf("Too many method parameters: %d",
+			len(parameters))
+	}
+	data := &bytes.Buffer{}
+	data.WriteByte(uint8(len(parameters)))
+	binary.Write(data, binary.BigEndian, parameters)
+	return &Attribute{
+		Name: []byte("MethodParameters"),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Returns the index of the nest host's ConstantClassInfo, contained in a
+// NestHost attribute.
+func ParseNestHostAttribute(a *Attribute) (uint16, error) {
+	if string(a.Name) != "NestHost" {
+		return 0, fmt.Errorf("Expected a NestHost attribute")
+	}
+	var toReturn uint16
+	data := bytes.NewReader(a.Info)
+	e := binary.Read(data, binary.BigEndian, &toReturn)
+	if e != nil {
+		return 0, fmt.Errorf("Failed reading nest host index: %s", e)
+	}
+	return toReturn, nil
+}
+
+// Returns a NestHost attribute containing the given class index. This is the
+// inverse of ParseNestHostAttribute.
+func EncodeNestHostAttribute(index uint16) *Attribute {
+	return &Attribute{
+		Name: []byte("NestHost"),
+		Info: []byte{byte(index >> 8), byte(index)},
+	}
+}
+
+// Parses a NestMembers attribute, returning the indices of the
+// ConstantClassInfo entries for the other members of the nest.
+func ParseNestMembersAttribute(a *Attribute) ([]uint16, error) {
+	if string(a.Name) != "NestMembers" {
+		return nil, fmt.Errorf("Expected a NestMembers attribute")
+	}
+	toReturn, e := readIndexList(bytes.NewReader(a.Info))
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading nest members: %w", e)
+	}
+	return toReturn, nil
+}
+
+// Returns a NestMembers attribute containing the given class indices. This is
+// the inverse of ParseNestMembersAttribute.
+func EncodeNestMembersAttribute(indices []uint16) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeIndexList(data, indices, "nest members")
+	if e != nil {
+		return nil, e
+	}
+	return &Attribute{
+		Name: []byte("NestMembers"),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Parses a PermittedSubclasses attribute, returning the indices of the
+// ConstantClassInfo entries for the classes allowed to extend or implement a
+// sealed class or interface.
+func ParsePermittedSubclassesAttribute(a *Attribute) ([]uint16, error) {
+	if string(a.Name) != "PermittedSubclasses" {
+		return nil, fmt.Errorf("Expected a PermittedSubclasses attribute")
+	}
+	toReturn, e := readIndexList(bytes.NewReader(a.Info))
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading permitted subclasses: %w", e)
+	}
+	return toReturn, nil
+}
+
+// Returns a PermittedSubclasses attribute containing the given class indices.
+// This is the inverse of ParsePermittedSubclassesAttribute.
+func EncodePermittedSubclassesAttribute(indices []uint16) (*Attribute,
+	error) {
+	data := &bytes.Buffer{}
+	e := writeIndexList(data, indices, "permitted subclasses")
+	if e != nil {
+		return nil, e
+	}
+	return &Attribute{
+		Name: []byte("PermittedSubclasses"),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// A single component of a record class, from a Record attribute.
+type RecordComponent struct {
+	NameIndex       uint16
+	DescriptorIndex uint16
+	// May include Signature and annotation attributes.
+	Attributes []*Attribute
+}
+
+// Parses a Record attribute and returns a slice of the record's components.
+func ParseRecordAttribute(a *Attribute, c *Class) ([]RecordComponent,
+	error) {
+	if string(a.Name) != "Record" {
+		return nil, fmt.Errorf("Expected a Record attribute")
+	}
+	data := bytes.NewReader(a.Info)
+	var count uint16
+	e := binary.Read(data, binary.BigEndian, &count)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading number of record components: "+
+			"%s", e)
+	}
+	toReturn := make([]RecordComponent, count)
+	for i := range toReturn {
+		component := &(toReturn[i])
+		e = binary.Read(data, binary.BigEndian, &(component.NameIndex))
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading component name: %s", e)
+		}
+		e = binary.Read(data, binary.BigEndian, &(component.DescriptorIndex))
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading component descriptor: %s",
+				e)
+		}
+		e = binary.Read(data, binary.BigEndian, &count)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading component attribute "+
+				"count: %s", e)
+		}
+		component.Attributes, e = c.parseAttributesTable(data, count)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading component attributes: %s",
+				e)
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns a Record attribute containing the given components. This is the
+// inverse of ParseRecordAttribute. The names of the components' attributes
+// must be in the class' constant pool.
+func EncodeRecordAttribute(components []RecordComponent, c *Class) (
+	*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(components), "record components")
+	if e != nil {
+		return nil, e
+	}
+	for _, component := range components {
+		binary.Write(data, binary.BigEndian, component.NameIndex)
+		binary.Write(data, binary.BigEndian, component.DescriptorIndex)
+		e = c.writeAttributesTable(data, component.Attributes)
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't write record component "+
+				"attributes: %w", e)
+		}
+	}
+	return &Attribute{
+		Name: []byte("Record"),
+		Info: data.Bytes(),
+	}, nil
+}
+
 // Assumes the data reader is at the start of a class file attribute struct.
 // Parses and returns the struct, or an error if one occurs.
 func (c *Class) parseSingleAttribute(data io.Reader) (*Attribute, error) {
@@ -361,6 +706,7 @@ func (c *Class) parseSingleAttribute(data io.Reader) (*Attribute, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading attribute name index: %s", e)
 	}
+	toReturn.NameIndex = index
 	toReturn.Name, e = c.GetUTF8Constant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Invalid attribute name: %s", e)
@@ -395,3 +741,35 @@ func (c *Class) parseAttributesTable(data io.Reader,
 	}
 	return attributes, nil
 }
+
+// Writes a single attribute structure. This is the inverse of
+// parseSingleAttribute.
+func (c *Class) writeSingleAttribute(data io.Writer, a *Attribute) error {
+	nameIndex, e := c.getUTF8ConstantIndex(a.NameIndex, a.Name)
+	if e != nil {
+		return fmt.Errorf("Invalid name for attribute %s: %w", a.Name, e)
+	}
+	if uint64(len(a.Info)) > 0xffffffff {
+		return fmt.Errorf("Attribute %s is too long", a.Name)
+	}
+	binary.Write(data, binary.BigEndian, nameIndex)
+	binary.Write(data, binary.BigEndian, uint32(len(a.Info)))
+	_, e = data.Write(a.Info)
+	return e
+}
+
+// Writes the count of attributes followed by the attributes themselves.
+func (c *Class) writeAttributesTable(data io.Writer,
+	attributes []*Attribute) error {
+	e := writeCount(data, len(attributes), "attributes")
+	if e != nil {
+		return e
+	}
+	for _, a := range attributes {
+		e = c.writeSingleAttribute(data, a)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package builder
+
+import (
+	"bytes"
+	"github.com/yalue/bs_jvm/class_file"
+	"testing"
+)
+
+// Builds the class, then parses the resulting class file.
+func buildTestClass(t *testing.T, b *ClassBuilder) *class_file.Class {
+	data, e := b.Bytes()
+	if e != nil {
+		t.Logf("Failed building class: %s\n", e)
+		t.FailNow()
+	}
+	toReturn, e := class_file.ParseClass(bytes.NewReader(data))
+	if e != nil {
+		t.Logf("Failed parsing the built class: %s\n", e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+// Returns the parsed Code attribute of the named method in the class.
+func getTestCode(t *testing.T, c *class_file.Class,
+	name string) *class_file.CodeAttribute {
+	for _, m := range c.Methods {
+		if string(m.Name) != name {
+			continue
+		}
+		for _, a := range m.Attributes {
+			if string(a.Name) != "Code" {
+				continue
+			}
+			toReturn, e := class_file.ParseCodeAttribute(a, c)
+			if e != nil {
+				t.Logf("Failed parsing %s's code: %s\n", name, e)
+				t.FailNow()
+			}
+			return toReturn
+		}
+	}
+	t.Logf("Couldn't find the code for method %s\n", name)
+	t.FailNow()
+	return nil
+}
+
+// Returns the parsed stack map frames from the code, or nil if it has no
+// StackMapTable.
+func getTestFrames(t *testing.T,
+	code *class_file.CodeAttribute) []class_file.StackMapFrame {
+	for _, a := range code.Attributes {
+		if string(a.Name) != "StackMapTable" {
+			continue
+		}
+		toReturn, e := class_file.ParseStackMapTableAttribute(a)
+		if e != nil {
+			t.Logf("Failed parsing stack map table: %s\n", e)
+			t.FailNow()
+		}
+		return toReturn
+	}
+	return nil
+}
+
+// Checks the max stack, max locals, and code of the given Code attribute.
+func checkTestCode(t *testing.T, code *class_file.CodeAttribute, maxStack,
+	maxLocals uint16, expected []byte) {
+	if (code.MaxStack != maxStack) || (code.MaxLocals != maxLocals) {
+		t.Logf("Expected max stack %d and max locals %d, got %d and %d\n",
+			maxStack, maxLocals, code.MaxStack, code.MaxLocals)
+		t.Fail()
+	}
+	if !bytes.Equal(code.Code, expected) {
+		t.Logf("Expected code % x, got % x\n", expected, code.Code)
+		t.Fail()
+	}
+}
+
+func TestConstantPool(t *testing.T) {
+	p := NewConstantPool()
+	a := p.UTF8("hello")
+	if p.UTF8("hello") != a {
+		t.Logf("Adding the same UTF-8 constant twice changed its index\n")
+		t.Fail()
+	}
+	longIndex := p.Long(1337)
+	intIndex := p.Integer(1337)
+	if intIndex != (longIndex + 2) {
+		t.Logf("Expected a long to use two entries: long at %d, int at %d\n",
+			longIndex, intIndex)
+		t.Fail()
+	}
+	classIndex := p.Class("hello")
+	class, ok := p.Get(classIndex).(*class_file.ConstantClassInfo)
+	if !ok || (class.NameIndex != a) {
+		t.Logf("The class constant didn't reuse the UTF-8 constant\n")
+		t.Fail()
+	}
+	if p.Method("A", "b", "()V") == p.InterfaceMethod("A", "b", "()V") {
+		t.Logf("Method and interface method constants were merged\n")
+		t.Fail()
+	}
+	if p.Dynamic(0, "x", "J") == p.InvokeDynamic(0, "x", "()J") {
+		t.Logf("Dynamic and InvokeDynamic constants were merged\n")
+		t.Fail()
+	}
+	if p.Module("hello") == p.Package("hello") {
+		t.Logf("Module and package constants were merged\n")
+		t.Fail()
+	}
+	if p.Err() != nil {
+		t.Logf("Got unexpected constant pool error: %s\n", p.Err())
+		t.Fail()
+	}
+}
+
+func TestEmitInstructions(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	m := b.AddMethod(0x0009, "constants", "()V")
+	m.EmitInt(-1)
+	m.EmitInt(100)
+	m.EmitInt(1000)
+	m.EmitInt(100000)
+	m.EmitLong(1)
+	m.Emit(Pop2)
+	m.Emit(Pop2)
+	m.Emit(Pop2)
+	m.Emit(Return)
+	m = b.AddMethod(0x0009, "locals", "(I)V")
+	m.EmitLocal(Iload, 0)
+	m.EmitLocal(Istore, 300)
+	m.EmitIinc(300, 1000)
+	m.EmitLocal(Iload, 300)
+	m.EmitLocal(Istore, 10)
+	m.Emit(Return)
+	m = b.AddMethod(0x0009, "dynamic", "()V")
+	longIndex := b.Pool.Dynamic(0, "long", "J")
+	m.EmitLdc(longIndex)
+	m.Emit(Pop2)
+	stringIndex := b.Pool.Dynamic(0, "string", "Ljava/lang/String;")
+	m.EmitLdc(stringIndex)
+	m.Emit(Pop)
+	m.Emit(Return)
+	class := buildTestClass(t, b)
+
+	integerIndex := byte(b.Pool.Integer(100000))
+	checkTestCode(t, getTestCode(t, class, "constants"), 6, 0, []byte{
+		0x02,      // iconst_m1
+		0x10, 100, // bipush 100
+		0x11, 0x03, 0xe8, // sipush 1000
+		0x12, integerIndex, // ldc 100000
+		0x0a, // lconst_1
+		0x58, 0x58, 0x58, 0xb1,
+	})
+	checkTestCode(t, getTestCode(t, class, "locals"), 1, 301, []byte{
+		0x1a,                   // iload_0
+		0xc4, 0x36, 0x01, 0x2c, // wide istore 300
+		0xc4, 0x84, 0x01, 0x2c, 0x03, 0xe8, // wide iinc 300, 1000
+		0xc4, 0x15, 0x01, 0x2c, // wide iload 300
+		0x36, 0x0a, // istore 10
+		0xb1,
+	})
+	checkTestCode(t, getTestCode(t, class, "dynamic"), 2, 0, []byte{
+		0x14, byte(longIndex >> 8), byte(longIndex), // ldc2_w long
+		0x58,                    // pop2
+		0x12, byte(stringIndex), // ldc string
+		0x57, 0xb1,
+	})
+}
+
+func TestLoopFrames(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	m := b.AddMethod(0x0009, "sum", "(I)I")
+	loop := m.NewLabel()
+	done := m.NewLabel()
+	m.EmitInt(0)
+	m.EmitLocal(Istore, 1)
+	m.PlaceLabel(loop)
+	m.EmitLocal(Iload, 0)
+	m.EmitBranch(Ifle, done)
+	m.EmitLocal(Iload, 1)
+	m.EmitLocal(Iload, 0)
+	m.Emit(Iadd)
+	m.EmitLocal(Istore, 1)
+	m.EmitIinc(0, -1)
+	m.EmitBranch(Goto, loop)
+	m.PlaceLabel(done)
+	m.EmitLocal(Iload, 1)
+	m.Emit(Ireturn)
+	code := getTestCode(t, buildTestClass(t, b), "sum")
+	checkTestCode(t, code, 2, 2, []byte{
+		0x03, 0x3c, // iconst_0, istore_1
+		0x1a, 0x9e, 0x00, 0x0d, // 2: iload_0, ifle 16
+		0x1b, 0x1a, 0x60, 0x3c, // iload_1, iload_0, iadd, istore_1
+		0x84, 0x00, 0xff, // iinc 0, -1
+		0xa7, 0xff, 0xf5, // 13: goto 2
+		0x1b, 0xac, // 16: iload_1, ireturn
+	})
+	frames := getTestFrames(t, code)
+	if len(frames) != 2 {
+		t.Logf("Expected 2 stack map frames, got %d\n", len(frames))
+		t.FailNow()
+	}
+	appendFrame, ok := frames[0].(*class_file.AppendStackMapFrame)
+	if !ok || (appendFrame.OffsetDelta() != 2) ||
+		(len(appendFrame.Locals) != 1) || (appendFrame.Locals[0].Tag != 1) {
+		t.Logf("Expected an append frame adding an int at offset 2, got %s\n",
+			frames[0])
+		t.Fail()
+	}
+	_, ok = frames[1].(*class_file.SameStackMapFrame)
+	if !ok || (frames[1].OffsetDelta() != 13) {
+		t.Logf("Expected a same frame at offset 16, got %s\n", frames[1])
+		t.Fail()
+	}
+
+	// Old class file versions don't use stack map frames.
+	b.MajorVersion = 49
+	code = getTestCode(t, buildTestClass(t, b), "sum")
+	if getTestFrames(t, code) != nil {
+		t.Logf("Got a stack map table for a version 49 class\n")
+		t.Fail()
+	}
+}
+
+func TestSwitches(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	m := b.AddMethod(0x0009, "classify", "(I)I")
+	one := m.NewLabel()
+	two := m.NewLabel()
+	other := m.NewLabel()
+	m.EmitLocal(Iload, 0)
+	m.EmitTableSwitch(1, other, one, two)
+	m.PlaceLabel(one)
+	m.EmitLocal(Iload, 0)
+	m.EmitLookupSwitch(other, []int32{100, -5}, []*Label{two, one})
+	m.PlaceLabel(two)
+	m.EmitInt(2)
+	m.Emit(Ireturn)
+	m.PlaceLabel(other)
+	m.EmitInt(0)
+	m.Emit(Ireturn)
+	code := getTestCode(t, buildTestClass(t, b), "classify")
+	checkTestCode(t, code, 1, 1, []byte{
+		0x1a,       // iload_0
+		0xaa, 0, 0, // 1: tableswitch, padded to offset 4
+		0, 0, 0, 0x35, // default: 54
+		0, 0, 0, 1, // low
+		0, 0, 0, 2, // high
+		0, 0, 0, 0x17, // 1: 24
+		0, 0, 0, 0x33, // 2: 52
+		0x1a,       // 24: iload_0
+		0xab, 0, 0, // 25: lookupswitch, padded to offset 28
+		0, 0, 0, 0x1d, // default: 54
+		0, 0, 0, 2, // pair count
+		0xff, 0xff, 0xff, 0xfb, 0xff, 0xff, 0xff, 0xff, // -5: 24
+		0, 0, 0, 100, 0, 0, 0, 0x1b, // 100: 52
+		0x05, 0xac, // 52: iconst_2, ireturn
+		0x03, 0xac, // 54: iconst_0, ireturn
+	})
+	frames := getTestFrames(t, code)
+	if len(frames) != 3 {
+		t.Logf("Expected 3 stack map frames, got %d\n", len(frames))
+		t.Fail()
+	}
+}
+
+func TestReferenceFrames(t *testing.T) {
+	b := NewClassBuilder("Test", "Base")
+	b.CommonSuperclass = func(a, b string) string {
+		return "Base"
+	}
+	m := b.AddMethod(0x0001, "pick", "(ZLSub;)LBase;")
+	other := m.NewLabel()
+	done := m.NewLabel()
+	m.EmitLocal(Iload, 1)
+	m.EmitBranch(Ifeq, other)
+	m.EmitLocal(Aload, 0)
+	m.EmitBranch(Goto, done)
+	m.PlaceLabel(other)
+	m.EmitLocal(Aload, 2)
+	m.PlaceLabel(done)
+	m.Emit(Areturn)
+
+	// Branch while two uninitialized objects are on the stack.
+	m = b.AddMethod(0x0001, "<init>", "(Z)V")
+	initialize := m.NewLabel()
+	m.EmitLocal(Aload, 0)
+	m.EmitInvoke(Invokespecial, "Base", "<init>", "()V")
+	m.EmitType(New, "Sub")
+	m.Emit(Dup)
+	m.EmitLocal(Iload, 1)
+	m.EmitBranch(Ifeq, initialize)
+	m.PlaceLabel(initialize)
+	m.EmitInvoke(Invokespecial, "Sub", "<init>", "()V")
+	m.Emit(Pop)
+	m.Emit(Return)
+	class := buildTestClass(t, b)
+
+	frames := getTestFrames(t, getTestCode(t, class, "pick"))
+	if len(frames) != 2 {
+		t.Logf("Expected 2 stack map frames, got %d\n", len(frames))
+		t.FailNow()
+	}
+	oneItem, ok := frames[1].(*class_file.OneItemStackMapFrame)
+	if !ok {
+		t.Logf("Expected a one-item frame, got %s\n", frames[1])
+		t.FailNow()
+	}
+	name, e := class.GetUTF8Constant(
+		class.Constants[oneItem.Info.Other].(*class_file.ConstantClassInfo).
+			NameIndex)
+	if (e != nil) || (string(name) != "Base") {
+		t.Logf("Expected the merged type to be Base, got %s (%v)\n", name, e)
+		t.Fail()
+	}
+
+	frames = getTestFrames(t, getTestCode(t, class, "<init>"))
+	if len(frames) != 1 {
+		t.Logf("Expected 1 stack map frame, got %d\n", len(frames))
+		t.FailNow()
+	}
+	full, ok := frames[0].(*class_file.FullStackMapFrame)
+	if !ok || (len(full.Stack) != 2) {
+		t.Logf("Expected a full frame with 2 stack entries, got %s\n",
+			frames[0])
+		t.FailNow()
+	}
+	for _, v := range full.Stack {
+		if (v.Tag != 8) || (v.Other != 4) {
+			t.Logf("Expected an uninitialized object from offset 4, got "+
+				"%s\n", v.Tag)
+			t.Fail()
+		}
+	}
+}
+
+func TestMergeArrayTypes(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	b.CommonSuperclass = func(a, b string) string {
+		if (a == "java/lang/Object") || (b == "java/lang/Object") {
+			return "java/lang/Object"
+		}
+		return "Base"
+	}
+	a := &analyzer{
+		m: b.AddMethod(0x0009, "run", "()V"),
+	}
+	tests := [][3]string{
+		{"[Ljava/lang/String;", "[Ljava/lang/Object;",
+			"[Ljava/lang/Object;"},
+		{"[LSubA;", "[LSubB;", "[LBase;"},
+		{"[[LSubA;", "[[LSubB;", "[[LBase;"},
+		{"[[I", "[Ljava/lang/String;", "[Ljava/lang/Object;"},
+		{"[[I", "[[C", "[Ljava/lang/Object;"},
+		{"[I", "[C", "java/lang/Object"},
+		{"[I", "[Ljava/lang/String;", "java/lang/Object"},
+		{"[LSubA;", "SubB", "java/lang/Object"},
+	}
+	for _, test := range tests {
+		merged := a.mergeClassNames(test[0], test[1])
+		if merged != test[2] {
+			t.Logf("Expected %s and %s to merge to %s, got %s\n", test[0],
+				test[1], test[2], merged)
+			t.Fail()
+		}
+	}
+}
+
+func TestExceptionHandlerFrames(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	m := b.AddMethod(0x0009, "divide", "(II)I")
+	start := m.NewLabel()
+	end := m.NewLabel()
+	handler := m.NewLabel()
+	m.PlaceLabel(start)
+	m.EmitLocal(Iload, 0)
+	m.EmitLocal(Iload, 1)
+	m.Emit(Idiv)
+	m.Emit(Ireturn)
+	m.PlaceLabel(end)
+	m.PlaceLabel(handler)
+	m.EmitLocal(Astore, 2)
+	m.EmitInt(-1)
+	m.Emit(Ireturn)
+	m.AddExceptionHandler(start, end, handler,
+		"java/lang/ArithmeticException")
+	class := buildTestClass(t, b)
+	code := getTestCode(t, class, "divide")
+	if (code.MaxStack != 2) || (code.MaxLocals != 3) {
+		t.Logf("Got incorrect max stack %d or max locals %d\n",
+			code.MaxStack, code.MaxLocals)
+		t.Fail()
+	}
+	if (len(code.ExceptionTable) != 1) ||
+		(code.ExceptionTable[0].HandlerPC != 4) {
+		t.Logf("Got incorrect exception table: %v\n", code.ExceptionTable)
+		t.FailNow()
+	}
+	frames := getTestFrames(t, code)
+	if len(frames) != 1 {
+		t.Logf("Expected 1 stack map frame, got %d\n", len(frames))
+		t.FailNow()
+	}
+	oneItem, ok := frames[0].(*class_file.OneItemStackMapFrame)
+	if !ok || (oneItem.OffsetDelta() != 4) ||
+		(oneItem.Info.Other != code.ExceptionTable[0].CatchType) {
+		t.Logf("Expected a one-item frame containing the exception, got "+
+			"%s\n", frames[0])
+		t.Fail()
+	}
+}
+
+func TestLongBranches(t *testing.T) {
+	b := NewClassBuilder("Test", "java/lang/Object")
+	m := b.AddMethod(0x0009, "far", "()V")
+	top := m.NewLabel()
+	done := m.NewLabel()
+	m.PlaceLabel(top)
+	for i := 0; i < 40000; i++ {
+		m.Emit(Nop)
+	}
+	m.EmitInt(0)
+	m.EmitBranch(Ifne, done)
+	m.EmitBranch(Goto_w, top)
+	m.PlaceLabel(done)
+	m.Emit(Return)
+	code := getTestCode(t, buildTestClass(t, b), "far")
+	expected := []byte{0xc8, 0xff, 0xff, 0x63, 0xbc}
+	if !bytes.Equal(code.Code[40004:40009], expected) {
+		t.Logf("Expected goto_w bytes % x, got % x\n", expected,
+			code.Code[40004:40009])
+		t.Fail()
+	}
+
+	// The same branch using goto must fail.
+	b = NewClassBuilder("Test", "java/lang/Object")
+	m = b.AddMethod(0x0009, "far", "()V")
+	top = m.NewLabel()
+	m.PlaceLabel(top)
+	for i := 0; i < 40000; i++ {
+		m.Emit(Nop)
+	}
+	m.EmitBranch(Goto, top)
+	_, e := b.Build()
+	if e == nil {
+		t.Logf("Didn't get an error for an out-of-range goto\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := map[string]func(m *MethodBuilder){
+		"unplaced label": func(m *MethodBuilder) {
+			m.EmitBranch(Goto, m.NewLabel())
+		},
+		"stack underflow": func(m *MethodBuilder) {
+			m.Emit(Pop)
+			m.Emit(Return)
+		},
+		"missing return": func(m *MethodBuilder) {
+			m.Emit(Nop)
+		},
+		"unreachable code": func(m *MethodBuilder) {
+			m.Emit(Return)
+			m.Emit(Return)
+		},
+		"wrong operand count": func(m *MethodBuilder) {
+			m.Emit(Bipush)
+			m.Emit(Return)
+		},
+		"inconsistent stack": func(m *MethodBuilder) {
+			done := m.NewLabel()
+			m.EmitInt(1)
+			m.EmitInt(1)
+			m.EmitBranch(Ifeq, done)
+			m.Emit(Pop)
+			m.PlaceLabel(done)
+			m.Emit(Return)
+		},
+		"loading an unset local": func(m *MethodBuilder) {
+			m.EmitLocal(Iload, 0)
+			m.Emit(Return)
+		},
+	}
+	for name, emit := range tests {
+		b := NewClassBuilder("Test", "java/lang/Object")
+		emit(b.AddMethod(0x0009, "test", "()V"))
+		_, e := b.Build()
+		if e == nil {
+			t.Logf("Didn't get an error for %s\n", name)
+			t.Fail()
+			continue
+		}
+		t.Logf("Got expected error for %s: %s\n", name, e)
+	}
+	b := NewClassBuilder("Test", "java/lang/Object")
+	b.AddMethod(0x0009, "test", "(X)V")
+	_, e := b.Build()
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid method descriptor\n")
+		t.Fail()
+	}
+}
@@ -0,0 +1,231 @@
+// This package contains an API for generating class files without a Java
+// compiler. A ClassBuilder holds the class' constant pool, fields, and
+// methods, and MethodBuilders assemble bytecode using labels for branch
+// targets. The max_stack, max_locals, and StackMapTable for each method are
+// computed automatically when the class is built.
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+)
+
+// Used to build a single class file.
+type ClassBuilder struct {
+	// The class' constant pool. Constants can be added to this directly, e.g.
+	// to get indices for use as instruction operands.
+	Pool         *ConstantPool
+	MajorVersion uint16
+	MinorVersion uint16
+	Access       class_file.ClassAccessFlags
+	// This is used when computing stack map frames, to find the most specific
+	// superclass of two different classes, e.g. when a local variable may
+	// contain either class after two branches join. If it's nil,
+	// java/lang/Object is used as the superclass of any two different
+	// classes.
+	CommonSuperclass func(a, b string) string
+	name             string
+	thisClass        uint16
+	superClass       uint16
+	interfaces       []uint16
+	fields           []*FieldBuilder
+	methods          []*MethodBuilder
+	bootstrapMethods []class_file.BootstrapMethod
+	attributes       []*class_file.Attribute
+	// The first error that occurred when adding something to the class.
+	err error
+}
+
+// Returns a new ClassBuilder for a public class with the given name and
+// superclass. Names use slashes, e.g. "java/lang/Object". The superclass name
+// may only be empty for java/lang/Object itself. The class file version
+// defaults to 52 (Java 8).
+func NewClassBuilder(name, superName string) *ClassBuilder {
+	toReturn := &ClassBuilder{
+		Pool:         NewConstantPool(),
+		MajorVersion: 52,
+		Access:       0x0021,
+		name:         name,
+	}
+	toReturn.thisClass = toReturn.Pool.Class(name)
+	if superName != "" {
+		toReturn.superClass = toReturn.Pool.Class(superName)
+	}
+	return toReturn
+}
+
+// Returns the name of the class being built.
+func (b *ClassBuilder) Name() string {
+	return b.name
+}
+
+// Records an error to be returned by Build, if an error hasn't already
+// occurred.
+func (b *ClassBuilder) fail(e error) {
+	if b.err == nil {
+		b.err = e
+	}
+}
+
+// Adds an interface implemented by the class.
+func (b *ClassBuilder) AddInterface(name string) {
+	b.interfaces = append(b.interfaces, b.Pool.Class(name))
+}
+
+// Adds an attribute to the class.
+func (b *ClassBuilder) AddAttribute(a *class_file.Attribute) {
+	b.attributes = append(b.attributes, a)
+}
+
+// Adds a SourceFile attribute containing the given file name.
+func (b *ClassBuilder) SetSourceFile(name string) {
+	b.AddAttribute(class_file.EncodeSourceFileAttribute(b.Pool.UTF8(name)))
+}
+
+// Adds an entry to the class' BootstrapMethods attribute, and returns its
+// index, for use with ConstantPool.InvokeDynamic. The handle is the index of
+// a method handle constant, and the arguments are indices of constants.
+func (b *ClassBuilder) AddBootstrapMethod(handle uint16,
+	arguments ...uint16) uint16 {
+	b.bootstrapMethods = append(b.bootstrapMethods,
+		class_file.BootstrapMethod{
+			Reference: handle,
+			Arguments: arguments,
+		})
+	return uint16(len(b.bootstrapMethods) - 1)
+}
+
+// Used to add information to a single field in a class.
+type FieldBuilder struct {
+	class *ClassBuilder
+	field *class_file.Field
+}
+
+// Adds a field to the class, with the given access flags, name, and
+// descriptor, e.g. "I" or "Ljava/lang/String;".
+func (b *ClassBuilder) AddField(access class_file.FieldAccessFlags, name,
+	descriptor string) *FieldBuilder {
+	fieldType, e := class_file.ParseFieldType([]byte(descriptor))
+	if e != nil {
+		b.fail(fmt.Errorf("Invalid descriptor for field %s: %w", name, e))
+	}
+	toReturn := &FieldBuilder{
+		class: b,
+		field: &class_file.Field{
+			Access:          access,
+			Name:            []byte(name),
+			NameIndex:       b.Pool.UTF8(name),
+			Descriptor:      fieldType,
+			DescriptorIndex: b.Pool.UTF8(descriptor),
+		},
+	}
+	b.fields = append(b.fields, toReturn)
+	return toReturn
+}
+
+// Adds an attribute to the field.
+func (f *FieldBuilder) AddAttribute(a *class_file.Attribute) {
+	f.field.Attributes = append(f.field.Attributes, a)
+}
+
+// Adds a ConstantValue attribute to the field, which must be static. The
+// index refers to the constant containing the field's initial value.
+func (f *FieldBuilder) SetConstantValue(index uint16) {
+	f.AddAttribute(&class_file.Attribute{
+		Name: []byte("ConstantValue"),
+		Info: []byte{byte(index >> 8), byte(index)},
+	})
+}
+
+// Adds a method to the class, with the given access flags, name, and
+// descriptor, e.g. "([Ljava/lang/String;)V". Bytecode is added to the method
+// using the returned MethodBuilder. Native and abstract methods must not
+// contain any bytecode.
+func (b *ClassBuilder) AddMethod(access class_file.MethodAccessFlags, name,
+	descriptor string) *MethodBuilder {
+	parsed, e := class_file.ParseMethodDescriptor([]byte(descriptor))
+	if e != nil {
+		b.fail(fmt.Errorf("Invalid descriptor for method %s: %w", name, e))
+	}
+	toReturn := &MethodBuilder{
+		class: b,
+		method: &class_file.Method{
+			Access:          access,
+			Name:            []byte(name),
+			NameIndex:       b.Pool.UTF8(name),
+			Descriptor:      parsed,
+			DescriptorIndex: b.Pool.UTF8(descriptor),
+		},
+	}
+	b.methods = append(b.methods, toReturn)
+	return toReturn
+}
+
+// Sets the NameIndex of each attribute, adding the names to the constant pool
+// if necessary.
+func (b *ClassBuilder) setAttributeNames(attributes []*class_file.Attribute) {
+	for _, a := range attributes {
+		a.NameIndex = b.Pool.UTF8(string(a.Name))
+	}
+}
+
+// Returns the class file containing everything that has been added to the
+// builder. Returns the first error that occurred while adding things to the
+// class, or an error if any method's bytecode is invalid.
+func (b *ClassBuilder) Build() (*class_file.Class, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	toReturn := &class_file.Class{
+		MinorVersion: b.MinorVersion,
+		MajorVersion: b.MajorVersion,
+		Access:       b.Access,
+		ThisClass:    b.thisClass,
+		SuperClass:   b.superClass,
+		Interfaces:   b.interfaces,
+		Fields:       make([]*class_file.Field, len(b.fields)),
+		Methods:      make([]*class_file.Method, len(b.methods)),
+	}
+	for i, f := range b.fields {
+		b.setAttributeNames(f.field.Attributes)
+		toReturn.Fields[i] = f.field
+	}
+	for i, m := range b.methods {
+		method, e := m.build()
+		if e != nil {
+			return nil, fmt.Errorf("Failed building method %s: %w",
+				m.method.Name, e)
+		}
+		toReturn.Methods[i] = method
+	}
+	attributes := append([]*class_file.Attribute{}, b.attributes...)
+	if len(b.bootstrapMethods) != 0 {
+		a, e := class_file.EncodeBootstrapMethodsAttribute(b.bootstrapMethods)
+		if e != nil {
+			return nil, e
+		}
+		attributes = append(attributes, a)
+	}
+	b.setAttributeNames(attributes)
+	toReturn.Attributes = attributes
+	if b.Pool.Err() != nil {
+		return nil, b.Pool.Err()
+	}
+	toReturn.Constants = b.Pool.Constants()
+	return toReturn, nil
+}
+
+// Builds the class, and returns the contents of its class file.
+func (b *ClassBuilder) Bytes() ([]byte, error) {
+	class, e := b.Build()
+	if e != nil {
+		return nil, e
+	}
+	data := &bytes.Buffer{}
+	_, e = class.WriteTo(data)
+	if e != nil {
+		return nil, e
+	}
+	return data.Bytes(), nil
+}
@@ -0,0 +1,263 @@
+package builder
+
+// This file contains the ConstantPool type, which is used to build a class'
+// constant pool without adding duplicate entries.
+
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+)
+
+// Used to build a class file's constant pool. Every method adding a constant
+// returns the index of an existing identical constant if there is one.
+// Methods that add constants return 0 if the pool is full; the error is
+// returned by Err, and by ClassBuilder.Build.
+type ConstantPool struct {
+	// The constants in the pool. The first entry is always nil, as are the
+	// entries following longs and doubles.
+	constants []class_file.Constant
+	// Maps a string uniquely identifying each constant to its index.
+	indices map[string]uint16
+	// The first error that occurred when adding a constant.
+	err error
+}
+
+// Returns a new, empty, constant pool.
+func NewConstantPool() *ConstantPool {
+	return &ConstantPool{
+		constants: []class_file.Constant{nil},
+		indices:   make(map[string]uint16),
+	}
+}
+
+// Returns the first error that occurred when adding constants to the pool, or
+// nil if none occurred.
+func (p *ConstantPool) Err() error {
+	return p.err
+}
+
+// Returns the constants in the pool, in the form used by class_file.Class.
+// The returned slice must not be modified.
+func (p *ConstantPool) Constants() []class_file.Constant {
+	return p.constants
+}
+
+// Returns the constant at the given index, or nil if the index is invalid.
+func (p *ConstantPool) Get(index uint16) class_file.Constant {
+	if int(index) >= len(p.constants) {
+		return nil
+	}
+	return p.constants[index]
+}
+
+// Returns the index of the constant with the given key, adding the constant
+// to the pool if it isn't already there.
+func (p *ConstantPool) add(key string, c class_file.Constant) uint16 {
+	index, ok := p.indices[key]
+	if ok {
+		return index
+	}
+	size := 1
+	if c.Tag().CountsDouble() {
+		size = 2
+	}
+	if (len(p.constants) + size) > 0xffff {
+		if p.err == nil {
+			p.err = fmt.Errorf("The constant pool is full")
+		}
+		return 0
+	}
+	index = uint16(len(p.constants))
+	p.constants = append(p.constants, c)
+	if size == 2 {
+		p.constants = append(p.constants, nil)
+	}
+	p.indices[key] = index
+	return index
+}
+
+// Adds a UTF-8 constant containing the given string.
+func (p *ConstantPool) UTF8(s string) uint16 {
+	if len(s) > 0xffff {
+		if p.err == nil {
+			p.err = fmt.Errorf("UTF-8 constant is too long: %d bytes", len(s))
+		}
+		return 0
+	}
+	return p.add("utf8:"+s, &class_file.ConstantUTF8Info{
+		Bytes: []byte(s),
+	})
+}
+
+// Adds an integer constant.
+func (p *ConstantPool) Integer(v int32) uint16 {
+	return p.add(fmt.Sprintf("int:%d", v), &class_file.ConstantIntegerInfo{
+		Value: v,
+	})
+}
+
+// Adds a float constant. Floats are compared using their bits, so NaNs with
+// different bits are different constants.
+func (p *ConstantPool) Float(v float32) uint16 {
+	return p.add(fmt.Sprintf("float:%x", math.Float32bits(v)),
+		&class_file.ConstantFloatInfo{
+			Value: v,
+		})
+}
+
+// Adds a long constant, which occupies two entries in the pool.
+func (p *ConstantPool) Long(v int64) uint16 {
+	return p.add(fmt.Sprintf("long:%d", v), &class_file.ConstantLongInfo{
+		Value: v,
+	})
+}
+
+// Adds a double constant, which occupies two entries in the pool. Like
+// floats, doubles are compared using their bits.
+func (p *ConstantPool) Double(v float64) uint16 {
+	return p.add(fmt.Sprintf("double:%x", math.Float64bits(v)),
+		&class_file.ConstantDoubleInfo{
+			Value: v,
+		})
+}
+
+// Adds a class constant. The name uses slashes, e.g. "java/lang/Object", or
+// is an array descriptor, e.g. "[I".
+func (p *ConstantPool) Class(name string) uint16 {
+	nameIndex := p.UTF8(name)
+	return p.add("class:"+name, &class_file.ConstantClassInfo{
+		NameIndex: nameIndex,
+	})
+}
+
+// Adds a java/lang/String constant with the given value.
+func (p *ConstantPool) StringConstant(s string) uint16 {
+	stringIndex := p.UTF8(s)
+	return p.add("string:"+s, &class_file.ConstantStringInfo{
+		StringIndex: stringIndex,
+	})
+}
+
+// Adds a name and type constant, with the given field or method descriptor.
+func (p *ConstantPool) NameAndType(name, descriptor string) uint16 {
+	nameIndex := p.UTF8(name)
+	descriptorIndex := p.UTF8(descriptor)
+	return p.add("nameandtype:"+name+":"+descriptor,
+		&class_file.ConstantNameAndTypeInfo{
+			NameIndex:       nameIndex,
+			DescriptorIndex: descriptorIndex,
+		})
+}
+
+// Adds a constant referring to a field in the named class.
+func (p *ConstantPool) Field(class, name, descriptor string) uint16 {
+	classIndex := p.Class(class)
+	nameAndType := p.NameAndType(name, descriptor)
+	return p.add("field:"+class+"."+name+":"+descriptor,
+		&class_file.ConstantFieldInfo{
+			ClassIndex:       classIndex,
+			NameAndTypeIndex: nameAndType,
+		})
+}
+
+// Adds a constant referring to a method in the named class.
+func (p *ConstantPool) Method(class, name, descriptor string) uint16 {
+	classIndex := p.Class(class)
+	nameAndType := p.NameAndType(name, descriptor)
+	return p.add("method:"+class+"."+name+":"+descriptor,
+		&class_file.ConstantMethodInfo{
+			ClassIndex:       classIndex,
+			NameAndTypeIndex: nameAndType,
+		})
+}
+
+// Adds a constant referring to a method in the named interface.
+func (p *ConstantPool) InterfaceMethod(class, name, descriptor string) uint16 {
+	classIndex := p.Class(class)
+	nameAndType := p.NameAndType(name, descriptor)
+	return p.add("interfacemethod:"+class+"."+name+":"+descriptor,
+		&class_file.ConstantInterfaceMethodInfo{
+			ClassIndex:       classIndex,
+			NameAndTypeIndex: nameAndType,
+		})
+}
+
+// Adds a method handle constant. The index refers to the field or method
+// constant the handle refers to.
+func (p *ConstantPool) MethodHandle(kind class_file.MethodHandleReferenceKind,
+	index uint16) uint16 {
+	return p.add(fmt.Sprintf("methodhandle:%d:%d", kind, index),
+		&class_file.ConstantMethodHandleInfo{
+			ReferenceKind: kind,
+			Index:         index,
+		})
+}
+
+// Adds a method type constant with the given method descriptor.
+func (p *ConstantPool) MethodType(descriptor string) uint16 {
+	descriptorIndex := p.UTF8(descriptor)
+	return p.add("methodtype:"+descriptor, &class_file.ConstantMethodTypeInfo{
+		DescriptorIndex: descriptorIndex,
+	})
+}
+
+// Adds an InvokeDynamic constant. The bootstrap index is an index into the
+// class' bootstrap methods; see ClassBuilder.AddBootstrapMethod.
+func (p *ConstantPool) InvokeDynamic(bootstrapIndex uint16, name,
+	descriptor string) uint16 {
+	nameAndType := p.NameAndType(name, descriptor)
+	return p.add(fmt.Sprintf("invokedynamic:%d:%s:%s", bootstrapIndex, name,
+		descriptor), &class_file.ConstantInvokeDynamicInfo{
+		BootstrapMethodAttributeIndex: bootstrapIndex,
+		NameAndTypeIndex:              nameAndType,
+	})
+}
+
+// Adds a dynamic constant, whose value is computed by the bootstrap method at
+// the given index in the class' bootstrap methods. The descriptor is a field
+// descriptor giving the constant's type.
+func (p *ConstantPool) Dynamic(bootstrapIndex uint16, name,
+	descriptor string) uint16 {
+	nameAndType := p.NameAndType(name, descriptor)
+	return p.add(fmt.Sprintf("dynamic:%d:%s:%s", bootstrapIndex, name,
+		descriptor), &class_file.ConstantDynamicInfo{
+		BootstrapMethodAttributeIndex: bootstrapIndex,
+		NameAndTypeIndex:              nameAndType,
+	})
+}
+
+// Adds a constant referring to the named module.
+func (p *ConstantPool) Module(name string) uint16 {
+	nameIndex := p.UTF8(name)
+	return p.add("module:"+name, &class_file.ConstantModuleInfo{
+		NameIndex: nameIndex,
+	})
+}
+
+// Adds a constant referring to the named package, e.g. "java/lang".
+func (p *ConstantPool) Package(name string) uint16 {
+	nameIndex := p.UTF8(name)
+	return p.add("package:"+name, &class_file.ConstantPackageInfo{
+		NameIndex: nameIndex,
+	})
+}
+
+// Returns true if the constant is a dynamic constant with type long or double.
+// These are loaded using ldc2_w, despite only taking one entry in the pool.
+func (p *ConstantPool) isCategory2Dynamic(c class_file.Constant) bool {
+	d, ok := c.(*class_file.ConstantDynamicInfo)
+	if !ok {
+		return false
+	}
+	n, ok := p.Get(d.NameAndTypeIndex).(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return false
+	}
+	descriptor, ok := p.Get(n.DescriptorIndex).(*class_file.ConstantUTF8Info)
+	if !ok {
+		return false
+	}
+	return (string(descriptor.Bytes) == "J") ||
+		(string(descriptor.Bytes) == "D")
+}
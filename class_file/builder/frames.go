@@ -0,0 +1,1112 @@
+package builder
+
+// This file contains the data flow analysis used to compute each method's
+// max_stack, max_locals, and StackMapTable. The analysis follows every path
+// through the code, merging the types of values where paths join, in the same
+// way as the JVM's type-checking verifier.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"sort"
+)
+
+// The type of a single local variable or stack entry.
+type valueType struct {
+	tag class_file.VerificationTypeInfoTag
+	// The class name or array descriptor, for object types.
+	name string
+	// The offset of the new instruction that created the object, for
+	// uninitialized types.
+	offset int
+}
+
+// The verification type info tags, from the JVM spec.
+const (
+	tagTop               = class_file.VerificationTypeInfoTag(0)
+	tagInt               = class_file.VerificationTypeInfoTag(1)
+	tagFloat             = class_file.VerificationTypeInfoTag(2)
+	tagDouble            = class_file.VerificationTypeInfoTag(3)
+	tagLong              = class_file.VerificationTypeInfoTag(4)
+	tagNull              = class_file.VerificationTypeInfoTag(5)
+	tagUninitializedThis = class_file.VerificationTypeInfoTag(6)
+	tagObject            = class_file.VerificationTypeInfoTag(7)
+	tagUninitialized     = class_file.VerificationTypeInfoTag(8)
+)
+
+var topValue = valueType{tag: tagTop}
+
+// Returns the type of a reference to the given class or array.
+func objectValue(name string) valueType {
+	return valueType{
+		tag:  tagObject,
+		name: name,
+	}
+}
+
+// Returns true if the value takes up two local variable slots.
+func (v valueType) isCategory2() bool {
+	return (v.tag == tagLong) || (v.tag == tagDouble)
+}
+
+// Returns true if the value is a reference, including null or an
+// uninitialized object.
+func (v valueType) isReference() bool {
+	return v.tag >= tagNull
+}
+
+func (v valueType) String() string {
+	switch v.tag {
+	case tagObject:
+		return v.name
+	case tagUninitialized:
+		return fmt.Sprintf("uninitialized (offset %d)", v.offset)
+	}
+	return v.tag.String()
+}
+
+// Returns the type of a value with the given primitive descriptor character,
+// which must be one of the types that can be on the stack.
+func primitiveValue(c byte) valueType {
+	switch c {
+	case 'J':
+		return valueType{tag: tagLong}
+	case 'F':
+		return valueType{tag: tagFloat}
+	case 'D':
+		return valueType{tag: tagDouble}
+	}
+	return valueType{tag: tagInt}
+}
+
+// Returns the type of a value of the given field type. Booleans, bytes, chars,
+// and shorts are ints.
+func fieldTypeValue(t class_file.FieldType) valueType {
+	switch v := t.(type) {
+	case class_file.PrimitiveFieldType:
+		return primitiveValue(byte(v))
+	case class_file.ClassInstanceType:
+		return objectValue(string(v))
+	}
+	return objectValue(class_file.FieldTypeDescriptor(t))
+}
+
+// Returns the number of local variable slots used by the given type.
+func fieldTypeSlots(t class_file.FieldType) int {
+	if fieldTypeValue(t).isCategory2() {
+		return 2
+	}
+	return 1
+}
+
+// Returns the array descriptor for an array containing the named class or
+// array type.
+func arrayOf(name string) string {
+	if name[0] == '[' {
+		return "[" + name
+	}
+	return "[L" + name + ";"
+}
+
+// Returns the type of an element of the given array type.
+func arrayElement(v valueType) valueType {
+	if (v.tag != tagObject) || (len(v.name) < 2) || (v.name[0] != '[') {
+		return objectValue("java/lang/Object")
+	}
+	t, e := class_file.ParseFieldType([]byte(v.name[1:]))
+	if e != nil {
+		return objectValue("java/lang/Object")
+	}
+	return fieldTypeValue(t)
+}
+
+// Holds the types of the local variables and stack at a single point in the
+// code. Local variables are indexed by slot, with the second slot of a long or
+// double containing top. Each stack entry is a single value, even if it is a
+// long or double.
+type frame struct {
+	locals []valueType
+	stack  []valueType
+}
+
+func (f *frame) copy() *frame {
+	return &frame{
+		locals: append([]valueType{}, f.locals...),
+		stack:  append([]valueType{}, f.stack...),
+	}
+}
+
+// Returns the number of slots used by the values on the stack.
+func (f *frame) stackSlots() int {
+	toReturn := 0
+	for _, v := range f.stack {
+		if v.isCategory2() {
+			toReturn += 2
+		} else {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+func (f *frame) push(values ...valueType) {
+	f.stack = append(f.stack, values...)
+}
+
+func (f *frame) pop() (valueType, error) {
+	if len(f.stack) == 0 {
+		return topValue, fmt.Errorf("Stack underflow")
+	}
+	toReturn := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return toReturn, nil
+}
+
+// Pops a value that must take up a single stack slot.
+func (f *frame) popCategory1() (valueType, error) {
+	toReturn, e := f.pop()
+	if e != nil {
+		return toReturn, e
+	}
+	if toReturn.isCategory2() {
+		return toReturn, fmt.Errorf("Expected a category 1 value on the " +
+			"stack, but got a long or double")
+	}
+	return toReturn, nil
+}
+
+// Pops and discards the given number of values.
+func (f *frame) popValues(count int) error {
+	if len(f.stack) < count {
+		return fmt.Errorf("Stack underflow")
+	}
+	f.stack = f.stack[:len(f.stack)-count]
+	return nil
+}
+
+// Sets the type of a local variable, invalidating any long or double that
+// overlapped it.
+func (f *frame) store(index int, v valueType) {
+	if (index > 0) && f.locals[index-1].isCategory2() {
+		f.locals[index-1] = topValue
+	}
+	f.locals[index] = v
+	if v.isCategory2() {
+		f.locals[index+1] = topValue
+	}
+}
+
+// Replaces every occurrence of an uninitialized type with the initialized
+// type, after a constructor is called.
+func (f *frame) initialize(from, to valueType) {
+	for i := range f.locals {
+		if f.locals[i] == from {
+			f.locals[i] = to
+		}
+	}
+	for i := range f.stack {
+		if f.stack[i] == from {
+			f.stack[i] = to
+		}
+	}
+}
+
+// Information about an instruction that accesses a local variable.
+type localAccess struct {
+	index int
+	// The type of the variable, one of 'I', 'J', 'F', 'D', or 'A'.
+	kind      byte
+	store     bool
+	increment bool
+}
+
+// Returns the number of local variable slots needed by the access.
+func (l localAccess) slots() int {
+	if (l.kind == 'J') || (l.kind == 'D') {
+		return 2
+	}
+	return 1
+}
+
+// Decodes the instruction at the given offset if it loads, stores, or
+// increments a local variable. Returns false if it doesn't.
+func decodeLocalAccess(code []byte, offset int) (localAccess, bool) {
+	const kinds = "IJFDA"
+	op := Opcode(code[offset])
+	wide := op == Wide
+	if wide {
+		op = Opcode(code[offset+1])
+	}
+	operand := func() int {
+		if wide {
+			return int(binary.BigEndian.Uint16(code[offset+2:]))
+		}
+		return int(code[offset+1])
+	}
+	var toReturn localAccess
+	switch {
+	case (op >= Iload) && (op <= Aload):
+		toReturn.index = operand()
+		toReturn.kind = kinds[op-Iload]
+	case (op >= Iload_0) && (op <= Aload_3):
+		toReturn.index = int(op-Iload_0) % 4
+		toReturn.kind = kinds[(op-Iload_0)/4]
+	case (op >= Istore) && (op <= Astore):
+		toReturn.index = operand()
+		toReturn.kind = kinds[op-Istore]
+		toReturn.store = true
+	case (op >= Istore_0) && (op <= Astore_3):
+		toReturn.index = int(op-Istore_0) % 4
+		toReturn.kind = kinds[(op-Istore_0)/4]
+		toReturn.store = true
+	case op == Iinc:
+		toReturn.index = operand()
+		toReturn.kind = 'I'
+		toReturn.increment = true
+	default:
+		return toReturn, false
+	}
+	return toReturn, true
+}
+
+// Holds the state of the data flow analysis of a single method.
+type analyzer struct {
+	m        *MethodBuilder
+	code     []byte
+	handlers []class_file.ExceptionTableEntry
+	// Maps each instruction's offset to the offset of the next instruction.
+	next map[int]int
+	// The types at the start of each reachable instruction.
+	frames map[int]*frame
+	// The offsets that need stack map frames: branch targets and exception
+	// handlers.
+	targets map[int]bool
+	// The frame at the start of the method, from the method's descriptor.
+	initial   *frame
+	maxStack  int
+	maxLocals int
+}
+
+// Analyzes the code in the method, computing max_stack, max_locals, and the
+// types at each branch target. Must be called after the method's labels are
+// resolved. Returns an error if the code is invalid.
+func analyzeMethod(m *MethodBuilder,
+	handlers []class_file.ExceptionTableEntry) (*analyzer, error) {
+	a := &analyzer{
+		m:        m,
+		code:     m.code,
+		handlers: handlers,
+		next:     make(map[int]int),
+		frames:   make(map[int]*frame),
+		targets:  make(map[int]bool),
+	}
+	if len(m.code) == 0 {
+		return nil, fmt.Errorf("The method contains no code")
+	}
+	for i, offset := range m.instructions {
+		if (i + 1) < len(m.instructions) {
+			a.next[offset] = m.instructions[i+1]
+		} else {
+			a.next[offset] = len(m.code)
+		}
+	}
+	e := a.findTargets()
+	if e != nil {
+		return nil, e
+	}
+	a.computeMaxLocals()
+	a.initial = a.initialFrame()
+	e = a.run()
+	if e != nil {
+		return nil, e
+	}
+	return a, nil
+}
+
+// Returns true if the offset is the start of an instruction.
+func (a *analyzer) isInstruction(offset int) bool {
+	_, ok := a.next[offset]
+	return ok
+}
+
+// Fills in the targets map, and checks that every target is the start of an
+// instruction.
+func (a *analyzer) findTargets() error {
+	for _, f := range a.m.fixups {
+		if !a.isInstruction(f.target.offset) {
+			return fmt.Errorf("The instruction at offset %d branches to "+
+				"offset %d, which isn't the start of an instruction",
+				f.instruction, f.target.offset)
+		}
+		a.targets[f.target.offset] = true
+	}
+	for i, h := range a.handlers {
+		end := int(h.EndPC)
+		if !a.isInstruction(int(h.StartPC)) ||
+			!a.isInstruction(int(h.HandlerPC)) ||
+			(!a.isInstruction(end) && (end != len(a.code))) {
+			return fmt.Errorf("Exception handler %d doesn't start and end "+
+				"at instruction boundaries", i)
+		}
+		a.targets[int(h.HandlerPC)] = true
+	}
+	return nil
+}
+
+// Sets maxLocals to the number of slots needed for the method's arguments and
+// every local variable accessed by the code.
+func (a *analyzer) computeMaxLocals() {
+	method := a.m.method
+	a.maxLocals = 0
+	if !method.Access.IsStatic() {
+		a.maxLocals = 1
+	}
+	for _, t := range method.Descriptor.ArgumentTypes {
+		a.maxLocals += fieldTypeSlots(t)
+	}
+	for offset := range a.next {
+		access, ok := decodeLocalAccess(a.code, offset)
+		if !ok {
+			continue
+		}
+		end := access.index + access.slots()
+		if end > a.maxLocals {
+			a.maxLocals = end
+		}
+	}
+}
+
+// Returns the frame at the start of the method, containing its arguments.
+func (a *analyzer) initialFrame() *frame {
+	method := a.m.method
+	toReturn := &frame{
+		locals: make([]valueType, a.maxLocals),
+	}
+	for i := range toReturn.locals {
+		toReturn.locals[i] = topValue
+	}
+	index := 0
+	if !method.Access.IsStatic() {
+		className := a.m.class.name
+		if (string(method.Name) == "<init>") &&
+			(className != "java/lang/Object") {
+			toReturn.locals[0] = valueType{tag: tagUninitializedThis}
+		} else {
+			toReturn.locals[0] = objectValue(className)
+		}
+		index = 1
+	}
+	for _, t := range method.Descriptor.ArgumentTypes {
+		toReturn.store(index, fieldTypeValue(t))
+		index += fieldTypeSlots(t)
+	}
+	return toReturn
+}
+
+// Returns the most specific common type of the two values, or top if they
+// aren't compatible.
+func (a *analyzer) mergeValues(x, y valueType) valueType {
+	if x == y {
+		return x
+	}
+	if (x.tag == tagNull) && (y.tag == tagObject) {
+		return y
+	}
+	if (y.tag == tagNull) && (x.tag == tagObject) {
+		return x
+	}
+	if (x.tag != tagObject) || (y.tag != tagObject) {
+		return topValue
+	}
+	return objectValue(a.mergeClassNames(x.name, y.name))
+}
+
+// Returns the name of the most specific common superclass of the two classes,
+// either of which may be an array type such as "[Ljava/lang/String;". Arrays
+// of references are merged element-wise, so String[] and Object[] merge to
+// Object[]. Arrays of different primitive types only have Object in common.
+func (a *analyzer) mergeClassNames(x, y string) string {
+	if x == y {
+		return x
+	}
+	xIsArray := x[0] == '['
+	yIsArray := y[0] == '['
+	if xIsArray && yIsArray {
+		xElement := arrayElementName(x)
+		yElement := arrayElementName(y)
+		if (xElement == "") || (yElement == "") {
+			return "java/lang/Object"
+		}
+		element := a.mergeClassNames(xElement, yElement)
+		if element[0] == '[' {
+			return "[" + element
+		}
+		return "[L" + element + ";"
+	}
+	hook := a.m.class.CommonSuperclass
+	if xIsArray || yIsArray || (hook == nil) {
+		return "java/lang/Object"
+	}
+	return hook(x, y)
+}
+
+// Returns the class name of the elements of the named array type, which is
+// itself an array type for multidimensional arrays. Returns an empty string if
+// the elements are primitive.
+func arrayElementName(name string) string {
+	element := name[1:]
+	switch element[0] {
+	case '[':
+		return element
+	case 'L':
+		return element[1 : len(element)-1]
+	}
+	return ""
+}
+
+// Merges the given frame into the frame at the offset, recording the frame if
+// the offset hasn't been reached before. Returns true if the frame at the
+// offset changed, meaning that the instruction needs to be analyzed again.
+func (a *analyzer) mergeFrame(offset int, f *frame) (bool, error) {
+	existing := a.frames[offset]
+	if existing == nil {
+		a.frames[offset] = f.copy()
+		return true, nil
+	}
+	if len(existing.stack) != len(f.stack) {
+		return false, fmt.Errorf("Inconsistent stack heights at offset %d: "+
+			"%d and %d", offset, len(existing.stack), len(f.stack))
+	}
+	changed := false
+	for i, v := range existing.stack {
+		merged := a.mergeValues(v, f.stack[i])
+		if merged.tag == tagTop {
+			return false, fmt.Errorf("Incompatible types on the stack at "+
+				"offset %d: %s and %s", offset, v, f.stack[i])
+		}
+		if merged != v {
+			existing.stack[i] = merged
+			changed = true
+		}
+	}
+	for i, v := range existing.locals {
+		merged := a.mergeValues(v, f.locals[i])
+		if merged != v {
+			existing.locals[i] = merged
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// Follows every path through the code, until the types at the start of every
+// reachable instruction stop changing.
+func (a *analyzer) run() error {
+	a.frames[0] = a.initial.copy()
+	worklist := []int{0}
+	for len(worklist) != 0 {
+		offset := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		before := a.frames[offset]
+		after := before.copy()
+		successors, fallsThrough, e := a.execute(offset, after)
+		if e != nil {
+			return fmt.Errorf("Invalid %s instruction at offset %d: %w",
+				Opcode(a.code[offset]), offset, e)
+		}
+		for _, f := range []*frame{before, after} {
+			if f.stackSlots() > a.maxStack {
+				a.maxStack = f.stackSlots()
+			}
+		}
+		if fallsThrough {
+			if a.next[offset] >= len(a.code) {
+				return fmt.Errorf("Execution can continue past the end of " +
+					"the code")
+			}
+			successors = append(successors, a.next[offset])
+		}
+		var targetFrames []*frame
+		for range successors {
+			targetFrames = append(targetFrames, after)
+		}
+		// Exception handlers may be reached with the locals from either before
+		// or after the instruction.
+		for _, h := range a.handlers {
+			if (offset < int(h.StartPC)) || (offset >= int(h.EndPC)) {
+				continue
+			}
+			catchType := objectValue("java/lang/Throwable")
+			if h.CatchType != 0 {
+				catchType = objectValue(a.className(h.CatchType))
+			}
+			for _, f := range []*frame{before, after} {
+				successors = append(successors, int(h.HandlerPC))
+				targetFrames = append(targetFrames, &frame{
+					locals: f.locals,
+					stack:  []valueType{catchType},
+				})
+			}
+			if a.maxStack < 1 {
+				a.maxStack = 1
+			}
+		}
+		for i, target := range successors {
+			changed, e := a.mergeFrame(target, targetFrames[i])
+			if e != nil {
+				return e
+			}
+			if changed {
+				worklist = append(worklist, target)
+			}
+		}
+	}
+	for offset := range a.next {
+		if a.frames[offset] == nil {
+			return fmt.Errorf("The instruction at offset %d is unreachable",
+				offset)
+		}
+	}
+	return nil
+}
+
+// Returns the UTF-8 string in the constant at the given index, or an empty
+// string if it isn't a UTF-8 constant.
+func (a *analyzer) utf8(index uint16) string {
+	c, ok := a.m.class.Pool.Get(index).(*class_file.ConstantUTF8Info)
+	if !ok {
+		return ""
+	}
+	return string(c.Bytes)
+}
+
+// Returns the name in the class constant at the given index, or an empty
+// string if it isn't a class constant.
+func (a *analyzer) className(index uint16) string {
+	c, ok := a.m.class.Pool.Get(index).(*class_file.ConstantClassInfo)
+	if !ok {
+		return ""
+	}
+	return a.utf8(c.NameIndex)
+}
+
+// Returns the name and descriptor in the name and type constant at the given
+// index.
+func (a *analyzer) nameAndType(index uint16) (string, string, error) {
+	c := a.m.class.Pool.Get(index)
+	n, ok := c.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return "", "", fmt.Errorf("Constant %d isn't a name and type", index)
+	}
+	return a.utf8(n.NameIndex), a.utf8(n.DescriptorIndex), nil
+}
+
+// Returns the name and descriptor of the field, method, or invokedynamic
+// constant at the given index.
+func (a *analyzer) memberNameAndType(index uint16) (string, string, error) {
+	switch c := a.m.class.Pool.Get(index).(type) {
+	case *class_file.ConstantFieldInfo:
+		return a.nameAndType(c.NameAndTypeIndex)
+	case *class_file.ConstantMethodInfo:
+		return a.nameAndType(c.NameAndTypeIndex)
+	case *class_file.ConstantInterfaceMethodInfo:
+		return a.nameAndType(c.NameAndTypeIndex)
+	case *class_file.ConstantInvokeDynamicInfo:
+		return a.nameAndType(c.NameAndTypeIndex)
+	}
+	return "", "", fmt.Errorf("Constant %d isn't a field or method reference",
+		index)
+}
+
+// Returns the type of the value pushed by ldc, ldc_w, or ldc2_w for the
+// constant at the given index.
+func (a *analyzer) constantValue(index uint16) (valueType, error) {
+	switch c := a.m.class.Pool.Get(index).(type) {
+	case *class_file.ConstantIntegerInfo:
+		return valueType{tag: tagInt}, nil
+	case *class_file.ConstantFloatInfo:
+		return valueType{tag: tagFloat}, nil
+	case *class_file.ConstantLongInfo:
+		return valueType{tag: tagLong}, nil
+	case *class_file.ConstantDoubleInfo:
+		return valueType{tag: tagDouble}, nil
+	case *class_file.ConstantStringInfo:
+		return objectValue("java/lang/String"), nil
+	case *class_file.ConstantClassInfo:
+		return objectValue("java/lang/Class"), nil
+	case *class_file.ConstantMethodHandleInfo:
+		return objectValue("java/lang/invoke/MethodHandle"), nil
+	case *class_file.ConstantMethodTypeInfo:
+		return objectValue("java/lang/invoke/MethodType"), nil
+	case *class_file.ConstantDynamicInfo:
+		_, descriptor, e := a.nameAndType(c.NameAndTypeIndex)
+		if e != nil {
+			return topValue, e
+		}
+		t, e := class_file.ParseFieldType([]byte(descriptor))
+		if e != nil {
+			return topValue, fmt.Errorf("Invalid dynamic constant type %s: "+
+				"%w", descriptor, e)
+		}
+		return fieldTypeValue(t), nil
+	}
+	return topValue, fmt.Errorf("Constant %d can't be loaded", index)
+}
+
+// Returns the 16-bit operand following the opcode at the given offset.
+func (a *analyzer) operandU16(offset int) uint16 {
+	return binary.BigEndian.Uint16(a.code[offset+1:])
+}
+
+// Returns the offset of the target of the branch instruction at the given
+// offset.
+func (a *analyzer) branchTarget(offset int) int {
+	op := Opcode(a.code[offset])
+	if (op == Goto_w) || (op == Jsr_w) {
+		return offset + int(int32(binary.BigEndian.Uint32(a.code[offset+1:])))
+	}
+	return offset + int(int16(a.operandU16(offset)))
+}
+
+// Returns the targets of the tableswitch or lookupswitch instruction at the
+// given offset, including the default target.
+func (a *analyzer) switchTargets(offset int) []int {
+	readInt := func(i int) int {
+		return int(int32(binary.BigEndian.Uint32(a.code[i:])))
+	}
+	base := offset + 1
+	for (base % 4) != 0 {
+		base++
+	}
+	toReturn := []int{offset + readInt(base)}
+	if Opcode(a.code[offset]) == Tableswitch {
+		count := readInt(base+8) - readInt(base+4) + 1
+		for i := 0; i < count; i++ {
+			toReturn = append(toReturn, offset+readInt(base+12+4*i))
+		}
+		return toReturn
+	}
+	count := readInt(base + 4)
+	for i := 0; i < count; i++ {
+		toReturn = append(toReturn, offset+readInt(base+12+8*i))
+	}
+	return toReturn
+}
+
+// Updates the frame with the effects of the instruction accessing a local
+// variable.
+func (a *analyzer) executeLocalAccess(access localAccess, f *frame) error {
+	if access.store {
+		v, e := f.pop()
+		if e != nil {
+			return e
+		}
+		if (access.kind == 'A') != v.isReference() {
+			return fmt.Errorf("Can't store %s in local %d", v, access.index)
+		}
+		if (access.kind != 'A') && (v != primitiveValue(access.kind)) {
+			return fmt.Errorf("Can't store %s in local %d", v, access.index)
+		}
+		f.store(access.index, v)
+		return nil
+	}
+	v := f.locals[access.index]
+	if access.kind == 'A' {
+		if !v.isReference() {
+			return fmt.Errorf("Local %d contains %s, not a reference",
+				access.index, v)
+		}
+		f.push(v)
+		return nil
+	}
+	if v != primitiveValue(access.kind) {
+		return fmt.Errorf("Local %d contains %s, not %s", access.index, v,
+			primitiveValue(access.kind))
+	}
+	if !access.increment {
+		f.push(v)
+	}
+	return nil
+}
+
+// Updates the frame with the effects of an invoke instruction.
+func (a *analyzer) executeInvoke(offset int, f *frame) error {
+	op := Opcode(a.code[offset])
+	name, descriptor, e := a.memberNameAndType(a.operandU16(offset))
+	if e != nil {
+		return e
+	}
+	parsed, e := class_file.ParseMethodDescriptor([]byte(descriptor))
+	if e != nil {
+		return e
+	}
+	e = f.popValues(len(parsed.ArgumentTypes))
+	if e != nil {
+		return e
+	}
+	if (op != Invokestatic) && (op != Invokedynamic) {
+		receiver, e := f.pop()
+		if e != nil {
+			return e
+		}
+		if !receiver.isReference() {
+			return fmt.Errorf("The method's receiver is %s, not a reference",
+				receiver)
+		}
+		if (op == Invokespecial) && (name == "<init>") {
+			switch receiver.tag {
+			case tagUninitializedThis:
+				f.initialize(receiver, objectValue(a.m.class.name))
+			case tagUninitialized:
+				newIndex := a.operandU16(receiver.offset)
+				f.initialize(receiver, objectValue(a.className(newIndex)))
+			default:
+				return fmt.Errorf("Calling a constructor on %s", receiver)
+			}
+		}
+	}
+	if parsed.ReturnType != class_file.PrimitiveFieldType('V') {
+		f.push(fieldTypeValue(parsed.ReturnType))
+	}
+	return nil
+}
+
+// Updates the frame with the effects of dup, swap, and similar instructions.
+func (a *analyzer) executeStackManipulation(op Opcode, f *frame) error {
+	v1, e := f.pop()
+	if e != nil {
+		return e
+	}
+	// pop2 and the dup2 instructions treat a single long or double the same
+	// as two category 1 values, but the others require category 1 values.
+	requiresCategory1 := (op == Swap) || ((op != Pop2) && (op < Dup2))
+	if requiresCategory1 && v1.isCategory2() {
+		return fmt.Errorf("Expected a category 1 value on the stack, but "+
+			"got %s", v1)
+	}
+	if op == Swap {
+		v2, e := f.popCategory1()
+		if e != nil {
+			return e
+		}
+		f.push(v1, v2)
+		return nil
+	}
+	// Collect the values that are duplicated or skipped over, as a list of
+	// values and a number of slots.
+	duplicated := []valueType{v1}
+	slots := 1
+	if v1.isCategory2() {
+		slots = 2
+	}
+	wantDuplicated := 1
+	wantSkipped := 0
+	switch op {
+	case Pop2, Dup2, Dup2_x1, Dup2_x2:
+		wantDuplicated = 2
+	}
+	switch op {
+	case Dup_x1, Dup2_x1:
+		wantSkipped = 1
+	case Dup_x2, Dup2_x2:
+		wantSkipped = 2
+	}
+	for slots < wantDuplicated {
+		v, e := f.popCategory1()
+		if e != nil {
+			return e
+		}
+		duplicated = append([]valueType{v}, duplicated...)
+		slots++
+	}
+	var skipped []valueType
+	slots = 0
+	for slots < wantSkipped {
+		v, e := f.pop()
+		if e != nil {
+			return e
+		}
+		skipped = append([]valueType{v}, skipped...)
+		slots++
+		if v.isCategory2() {
+			slots++
+		}
+	}
+	if slots > wantSkipped {
+		return fmt.Errorf("Can't split a long or double on the stack")
+	}
+	if (op == Pop) || (op == Pop2) {
+		return nil
+	}
+	f.push(duplicated...)
+	f.push(skipped...)
+	f.push(duplicated...)
+	return nil
+}
+
+// Updates the frame with the effects of executing the instruction at the given
+// offset. Returns the offsets of any branch targets, and whether execution
+// can continue at the next instruction.
+func (a *analyzer) execute(offset int, f *frame) ([]int, bool, error) {
+	op := Opcode(a.code[offset])
+	access, ok := decodeLocalAccess(a.code, offset)
+	if ok {
+		return nil, true, a.executeLocalAccess(access, f)
+	}
+	pushKind := func(pops int, kind byte) ([]int, bool, error) {
+		e := f.popValues(pops)
+		if e != nil {
+			return nil, false, e
+		}
+		if kind != 0 {
+			f.push(primitiveValue(kind))
+		}
+		return nil, true, nil
+	}
+	switch {
+	case op == Nop:
+		return nil, true, nil
+	case op == Aconst_null:
+		f.push(valueType{tag: tagNull})
+		return nil, true, nil
+	case ((op >= Iconst_m1) && (op <= Iconst_5)) || (op == Bipush) ||
+		(op == Sipush):
+		return pushKind(0, 'I')
+	case (op == Lconst_0) || (op == Lconst_1):
+		return pushKind(0, 'J')
+	case (op >= Fconst_0) && (op <= Fconst_2):
+		return pushKind(0, 'F')
+	case (op == Dconst_0) || (op == Dconst_1):
+		return pushKind(0, 'D')
+	case (op >= Ldc) && (op <= Ldc2_w):
+		index := uint16(a.code[offset+1])
+		if op != Ldc {
+			index = a.operandU16(offset)
+		}
+		v, e := a.constantValue(index)
+		if e != nil {
+			return nil, false, e
+		}
+		if v.isCategory2() != (op == Ldc2_w) {
+			return nil, false, fmt.Errorf("Wrong instruction for loading "+
+				"constant %d", index)
+		}
+		f.push(v)
+		return nil, true, nil
+	case op == Aaload:
+		e := f.popValues(1)
+		if e != nil {
+			return nil, false, e
+		}
+		array, e := f.pop()
+		if e != nil {
+			return nil, false, e
+		}
+		f.push(arrayElement(array))
+		return nil, true, nil
+	case (op >= Iaload) && (op <= Saload):
+		return pushKind(2, "IJFD_III"[op-Iaload])
+	case (op >= Iastore) && (op <= Sastore):
+		return pushKind(3, 0)
+	case (op >= Pop) && (op <= Swap):
+		return nil, true, a.executeStackManipulation(op, f)
+	case (op >= Iadd) && (op <= Drem):
+		return pushKind(2, "IJFD"[(op-Iadd)%4])
+	case (op >= Ineg) && (op <= Dneg):
+		return pushKind(1, "IJFD"[op-Ineg])
+	case (op >= Ishl) && (op <= Lxor):
+		return pushKind(2, "IJ"[(op-Ishl)%2])
+	case (op >= I2l) && (op <= I2s):
+		return pushKind(1, "JFDIFDIJDIJFIII"[op-I2l])
+	case (op >= Lcmp) && (op <= Dcmpg):
+		return pushKind(2, 'I')
+	case ((op >= Ifeq) && (op <= Ifle)) || (op == Ifnull) ||
+		(op == Ifnonnull):
+		e := f.popValues(1)
+		return []int{a.branchTarget(offset)}, true, e
+	case (op >= If_icmpeq) && (op <= If_acmpne):
+		e := f.popValues(2)
+		return []int{a.branchTarget(offset)}, true, e
+	case (op == Goto) || (op == Goto_w):
+		return []int{a.branchTarget(offset)}, false, nil
+	case (op == Tableswitch) || (op == Lookupswitch):
+		e := f.popValues(1)
+		return a.switchTargets(offset), false, e
+	case (op >= Ireturn) && (op <= Areturn):
+		e := f.popValues(1)
+		return nil, false, e
+	case op == Return:
+		return nil, false, nil
+	case (op >= Getstatic) && (op <= Putfield):
+		_, descriptor, e := a.memberNameAndType(a.operandU16(offset))
+		if e != nil {
+			return nil, false, e
+		}
+		fieldType, e := class_file.ParseFieldType([]byte(descriptor))
+		if e != nil {
+			return nil, false, e
+		}
+		pops := map[Opcode]int{Getstatic: 0, Putstatic: 1, Getfield: 1,
+			Putfield: 2}[op]
+		e = f.popValues(pops)
+		if e != nil {
+			return nil, false, e
+		}
+		if (op == Getstatic) || (op == Getfield) {
+			f.push(fieldTypeValue(fieldType))
+		}
+		return nil, true, nil
+	case (op >= Invokevirtual) && (op <= Invokedynamic):
+		return nil, true, a.executeInvoke(offset, f)
+	case op == New:
+		f.push(valueType{
+			tag:    tagUninitialized,
+			offset: offset,
+		})
+		return nil, true, nil
+	case op == Newarray:
+		e := f.popValues(1)
+		if e != nil {
+			return nil, false, e
+		}
+		arrayType := a.code[offset+1]
+		if (arrayType < 4) || (arrayType > 11) {
+			return nil, false, fmt.Errorf("Invalid array type: %d",
+				arrayType)
+		}
+		f.push(objectValue("[" + string("ZCFDBSIJ"[arrayType-4])))
+		return nil, true, nil
+	case op == Anewarray:
+		e := f.popValues(1)
+		if e != nil {
+			return nil, false, e
+		}
+		f.push(objectValue(arrayOf(a.className(a.operandU16(offset)))))
+		return nil, true, nil
+	case (op == Arraylength) || (op == Instanceof):
+		return pushKind(1, 'I')
+	case op == Athrow:
+		e := f.popValues(1)
+		return nil, false, e
+	case op == Checkcast:
+		e := f.popValues(1)
+		if e != nil {
+			return nil, false, e
+		}
+		f.push(objectValue(a.className(a.operandU16(offset))))
+		return nil, true, nil
+	case (op == Monitorenter) || (op == Monitorexit):
+		return pushKind(1, 0)
+	case op == Multianewarray:
+		e := f.popValues(int(a.code[offset+3]))
+		if e != nil {
+			return nil, false, e
+		}
+		f.push(objectValue(a.className(a.operandU16(offset))))
+		return nil, true, nil
+	case (op == Jsr) || (op == Jsr_w) || (op == Ret):
+		return nil, false, fmt.Errorf("Subroutines aren't supported")
+	}
+	return nil, false, fmt.Errorf("Unsupported instruction")
+}
+
+// Returns the verification type info for the value, adding a constant for
+// the class name if necessary.
+func (a *analyzer) typeInfo(v valueType) class_file.VerificationTypeInfo {
+	toReturn := class_file.VerificationTypeInfo{
+		Tag: v.tag,
+	}
+	switch v.tag {
+	case tagObject:
+		toReturn.Other = a.m.class.Pool.Class(v.name)
+	case tagUninitialized:
+		toReturn.Other = uint16(v.offset)
+	}
+	return toReturn
+}
+
+// Converts a list of values to verification type info.
+func (a *analyzer) typeInfoList(
+	values []valueType) []class_file.VerificationTypeInfo {
+	toReturn := make([]class_file.VerificationTypeInfo, len(values))
+	for i, v := range values {
+		toReturn[i] = a.typeInfo(v)
+	}
+	return toReturn
+}
+
+// Returns the locals in the form used by stack map frames, where a long or
+// double is a single entry. Trailing top entries are removed.
+func stackMapLocals(locals []valueType) []valueType {
+	var toReturn []valueType
+	for i := 0; i < len(locals); i++ {
+		toReturn = append(toReturn, locals[i])
+		if locals[i].isCategory2() {
+			i++
+		}
+	}
+	for (len(toReturn) != 0) && (toReturn[len(toReturn)-1] == topValue) {
+		toReturn = toReturn[:len(toReturn)-1]
+	}
+	return toReturn
+}
+
+// Returns true if a starts with all of the values in b.
+func hasPrefix(a, b []valueType) bool {
+	if len(a) < len(b) {
+		return false
+	}
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the method's stack map frames, using the most compact form for
+// each frame. Must be called after the analysis completes.
+func (a *analyzer) stackMapFrames() ([]class_file.StackMapFrame, error) {
+	var offsets []int
+	for offset := range a.targets {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+	toReturn := make([]class_file.StackMapFrame, 0, len(offsets))
+	previousLocals := stackMapLocals(a.initial.locals)
+	previousOffset := -1
+	for _, offset := range offsets {
+		f := a.frames[offset]
+		delta := uint16(offset - previousOffset - 1)
+		previousOffset = offset
+		locals := stackMapLocals(f.locals)
+		var frame class_file.StackMapFrame
+		var e error
+		sameLocals := (len(locals) == len(previousLocals)) &&
+			hasPrefix(locals, previousLocals)
+		difference := len(locals) - len(previousLocals)
+		switch {
+		case sameLocals && (len(f.stack) == 0):
+			frame = class_file.NewSameFrame(delta)
+		case sameLocals && (len(f.stack) == 1):
+			frame = class_file.NewOneItemFrame(delta, a.typeInfo(f.stack[0]))
+		case (len(f.stack) == 0) && (difference > 0) && (difference <= 3) &&
+			hasPrefix(locals, previousLocals):
+			frame, e = class_file.NewAppendFrame(delta,
+				a.typeInfoList(locals[len(previousLocals):]))
+		case (len(f.stack) == 0) && (difference < 0) && (difference >= -3) &&
+			hasPrefix(previousLocals, locals):
+			frame, e = class_file.NewChopFrame(delta, -difference)
+		default:
+			frame = class_file.NewFullFrame(delta, a.typeInfoList(locals),
+				a.typeInfoList(f.stack))
+		}
+		if e != nil {
+			return nil, e
+		}
+		toReturn = append(toReturn, frame)
+		previousLocals = locals
+	}
+	return toReturn, nil
+}
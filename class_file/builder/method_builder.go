@@ -0,0 +1,587 @@
+package builder
+
+// This file contains the MethodBuilder type, which is used to assemble a
+// method's bytecode.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+	"sort"
+)
+
+// A location in a method's bytecode, used as a branch target or to mark the
+// bounds of an exception handler. Labels are created using
+// MethodBuilder.NewLabel and placed using MethodBuilder.PlaceLabel, and may be
+// used by instructions before they're placed.
+type Label struct {
+	method *MethodBuilder
+	// The label's offset in the code, or -1 if it hasn't been placed yet.
+	offset int
+}
+
+// Returns the label's offset in the method's code, or -1 if it hasn't been
+// placed.
+func (l *Label) Offset() int {
+	return l.offset
+}
+
+// Records a branch offset to be filled in after the target label is placed.
+type labelFixup struct {
+	// The offset of the branch instruction, which the branch offset is
+	// relative to.
+	instruction int
+	// The offset of the branch offset operand.
+	operand int
+	// True if the branch offset is 32 bits rather than 16 bits.
+	wide   bool
+	target *Label
+}
+
+// Holds an exception handler added to the method, before its labels have been
+// resolved.
+type exceptionHandler struct {
+	start     *Label
+	end       *Label
+	handler   *Label
+	catchType string
+}
+
+// Used to assemble the code for a single method. Instructions are appended to
+// the code in the order they are emitted. Errors that occur when emitting
+// instructions are returned by ClassBuilder.Build.
+type MethodBuilder struct {
+	// The minimum max_stack and max_locals for the method. The computed
+	// values are used instead if they're larger.
+	MaxStack    uint16
+	MaxLocals   uint16
+	class       *ClassBuilder
+	method      *class_file.Method
+	code        []byte
+	lineNumbers []class_file.LineNumberEntry
+	// The offset of each instruction in the code, in order.
+	instructions []int
+	fixups       []labelFixup
+	handlers     []exceptionHandler
+}
+
+// Records an error that occurred when emitting an instruction.
+func (m *MethodBuilder) fail(e error) {
+	m.class.fail(fmt.Errorf("Error in method %s at offset %d: %w",
+		m.method.Name, len(m.code), e))
+}
+
+// Adds an attribute to the method. The Code attribute is added automatically,
+// and must not be added using this function.
+func (m *MethodBuilder) AddAttribute(a *class_file.Attribute) {
+	m.method.Attributes = append(m.method.Attributes, a)
+}
+
+// Records that the next instruction emitted starts the given line in the
+// source file. The method's LineNumberTable is generated from these.
+func (m *MethodBuilder) AddLineNumber(line uint16) {
+	m.lineNumbers = append(m.lineNumbers, class_file.LineNumberEntry{
+		StartPC:    uint16(len(m.code)),
+		LineNumber: line,
+	})
+}
+
+// Returns the offset at which the next instruction will be emitted.
+func (m *MethodBuilder) Offset() int {
+	return len(m.code)
+}
+
+// Returns a new label, which must be placed before the class is built.
+func (m *MethodBuilder) NewLabel() *Label {
+	return &Label{
+		method: m,
+		offset: -1,
+	}
+}
+
+// Places the label at the current offset in the code, so that it refers to
+// the next instruction emitted.
+func (m *MethodBuilder) PlaceLabel(l *Label) {
+	if l.method != m {
+		m.fail(fmt.Errorf("Can't place a label from a different method"))
+		return
+	}
+	if l.offset >= 0 {
+		m.fail(fmt.Errorf("The label was already placed at offset %d",
+			l.offset))
+		return
+	}
+	l.offset = len(m.code)
+}
+
+// Appends an instruction to the code, without checking its operands.
+func (m *MethodBuilder) appendInstruction(data ...byte) {
+	m.instructions = append(m.instructions, len(m.code))
+	m.code = append(m.code, data...)
+}
+
+// Returns true if the opcode takes a branch offset as an operand.
+func isBranch(op Opcode) bool {
+	return ((op >= Ifeq) && (op <= Jsr)) || ((op >= Ifnull) && (op <= Jsr_w))
+}
+
+// Emits an instruction with the given operand bytes. The number of operand
+// bytes must match the instruction. This can't be used for branches or
+// variable-length instructions; use EmitBranch, EmitTableSwitch,
+// EmitLookupSwitch, EmitLocal, or EmitIinc instead.
+func (m *MethodBuilder) Emit(op Opcode, operands ...byte) {
+	info := opcodeTable[op]
+	if info == nil {
+		m.fail(fmt.Errorf("Invalid opcode: 0x%02x", uint8(op)))
+		return
+	}
+	if isBranch(op) {
+		m.fail(fmt.Errorf("Branches such as %s must use EmitBranch", op))
+		return
+	}
+	if info.operandBytes < 0 {
+		m.fail(fmt.Errorf("Can't emit %s using Emit", op))
+		return
+	}
+	if len(operands) != info.operandBytes {
+		m.fail(fmt.Errorf("%s requires %d operand bytes, got %d", op,
+			info.operandBytes, len(operands)))
+		return
+	}
+	m.appendInstruction(append([]byte{byte(op)}, operands...)...)
+}
+
+// Emits an instruction that takes a single 16-bit operand, such as a constant
+// pool index.
+func (m *MethodBuilder) emitU16(op Opcode, operand uint16) {
+	m.Emit(op, byte(operand>>8), byte(operand))
+}
+
+// Adds a fixup for a branch offset at the end of the code, and appends
+// placeholder bytes for it.
+func (m *MethodBuilder) addFixup(instruction int, target *Label, wide bool) {
+	if target.method != m {
+		m.fail(fmt.Errorf("Can't branch to a label in a different method"))
+	}
+	m.fixups = append(m.fixups, labelFixup{
+		instruction: instruction,
+		operand:     len(m.code),
+		wide:        wide,
+		target:      target,
+	})
+	if wide {
+		m.code = append(m.code, 0, 0, 0, 0)
+	} else {
+		m.code = append(m.code, 0, 0)
+	}
+}
+
+// Emits a branch instruction, e.g. goto or ifeq, jumping to the given label.
+// The branch offset is computed when the class is built. Returns an error if
+// the offset doesn't fit in 16 bits, unless the instruction is goto_w.
+func (m *MethodBuilder) EmitBranch(op Opcode, target *Label) {
+	if !isBranch(op) {
+		m.fail(fmt.Errorf("%s isn't a branch instruction", op))
+		return
+	}
+	start := len(m.code)
+	m.appendInstruction(byte(op))
+	m.addFixup(start, target, (op == Goto_w) || (op == Jsr_w))
+}
+
+// Appends the padding following a tableswitch or lookupswitch opcode, so the
+// following operands are 4-byte aligned.
+func (m *MethodBuilder) appendSwitchPadding() {
+	for (len(m.code) % 4) != 0 {
+		m.code = append(m.code, 0)
+	}
+}
+
+// Appends a 32-bit big-endian value to the code.
+func (m *MethodBuilder) appendInt32(v int32) {
+	m.code = append(m.code, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Emits a tableswitch instruction. The targets correspond to the values low,
+// low + 1, and so on, and there must be at least one of them.
+func (m *MethodBuilder) EmitTableSwitch(low int32, defaultTarget *Label,
+	targets ...*Label) {
+	if len(targets) == 0 {
+		m.fail(fmt.Errorf("A tableswitch requires at least one target"))
+		return
+	}
+	high := int64(low) + int64(len(targets)) - 1
+	if high > math.MaxInt32 {
+		m.fail(fmt.Errorf("Too many tableswitch targets: %d", len(targets)))
+		return
+	}
+	start := len(m.code)
+	m.appendInstruction(byte(Tableswitch))
+	m.appendSwitchPadding()
+	m.addFixup(start, defaultTarget, true)
+	m.appendInt32(low)
+	m.appendInt32(int32(high))
+	for _, t := range targets {
+		m.addFixup(start, t, true)
+	}
+}
+
+// Used to sort lookupswitch pairs by key.
+type lookupSwitchPair struct {
+	key    int32
+	target *Label
+}
+
+// Emits a lookupswitch instruction, jumping to targets[i] if the value is
+// keys[i]. The keys don't need to be sorted, but must not contain duplicates.
+func (m *MethodBuilder) EmitLookupSwitch(defaultTarget *Label, keys []int32,
+	targets []*Label) {
+	if len(keys) != len(targets) {
+		m.fail(fmt.Errorf("Got %d lookupswitch keys, but %d targets",
+			len(keys), len(targets)))
+		return
+	}
+	pairs := make([]lookupSwitchPair, len(keys))
+	for i := range keys {
+		pairs[i] = lookupSwitchPair{
+			key:    keys[i],
+			target: targets[i],
+		}
+	}
+	sort.Slice(pairs, func(a, b int) bool {
+		return pairs[a].key < pairs[b].key
+	})
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i].key == pairs[i-1].key {
+			m.fail(fmt.Errorf("Duplicate lookupswitch key: %d", pairs[i].key))
+			return
+		}
+	}
+	start := len(m.code)
+	m.appendInstruction(byte(Lookupswitch))
+	m.appendSwitchPadding()
+	m.addFixup(start, defaultTarget, true)
+	m.appendInt32(int32(len(pairs)))
+	for _, p := range pairs {
+		m.appendInt32(p.key)
+		m.addFixup(start, p.target, true)
+	}
+}
+
+// Emits a load or store of a local variable, e.g. iload or astore, using the
+// shortest form of the instruction for the index: iload_0 for index 0, iload
+// for indices up to 255, and wide iload otherwise. The opcode must be one of
+// the forms taking an index operand.
+func (m *MethodBuilder) EmitLocal(op Opcode, index uint16) {
+	var shortForm Opcode
+	if (op >= Iload) && (op <= Aload) {
+		shortForm = Iload_0 + (op-Iload)*4
+	} else if (op >= Istore) && (op <= Astore) {
+		shortForm = Istore_0 + (op-Istore)*4
+	} else {
+		m.fail(fmt.Errorf("%s isn't a local variable load or store", op))
+		return
+	}
+	if index <= 3 {
+		m.appendInstruction(byte(shortForm + Opcode(index)))
+		return
+	}
+	if index <= 0xff {
+		m.appendInstruction(byte(op), byte(index))
+		return
+	}
+	m.appendInstruction(byte(Wide), byte(op), byte(index>>8), byte(index))
+}
+
+// Emits an iinc instruction, adding delta to the int in the given local
+// variable. Uses the wide form of iinc if necessary.
+func (m *MethodBuilder) EmitIinc(index uint16, delta int16) {
+	if (index <= 0xff) && (delta >= -128) && (delta <= 127) {
+		m.appendInstruction(byte(Iinc), byte(index), byte(delta))
+		return
+	}
+	m.appendInstruction(byte(Wide), byte(Iinc), byte(index>>8), byte(index),
+		byte(delta>>8), byte(delta))
+}
+
+// Emits an instruction loading the given constant, using ldc2_w for longs and
+// doubles, and ldc or ldc_w otherwise.
+func (m *MethodBuilder) EmitLdc(index uint16) {
+	c := m.class.Pool.Get(index)
+	if c == nil {
+		m.fail(fmt.Errorf("Invalid constant index for ldc: %d", index))
+		return
+	}
+	if c.Tag().CountsDouble() || m.class.Pool.isCategory2Dynamic(c) {
+		m.emitU16(Ldc2_w, index)
+		return
+	}
+	if index <= 0xff {
+		m.Emit(Ldc, byte(index))
+		return
+	}
+	m.emitU16(Ldc_w, index)
+}
+
+// Emits the shortest instruction pushing the given int onto the stack.
+func (m *MethodBuilder) EmitInt(v int32) {
+	if (v >= -1) && (v <= 5) {
+		m.Emit(Iconst_m1 + Opcode(v+1))
+		return
+	}
+	if (v >= math.MinInt8) && (v <= math.MaxInt8) {
+		m.Emit(Bipush, byte(v))
+		return
+	}
+	if (v >= math.MinInt16) && (v <= math.MaxInt16) {
+		m.emitU16(Sipush, uint16(v))
+		return
+	}
+	m.EmitLdc(m.class.Pool.Integer(v))
+}
+
+// Emits an instruction pushing the given long onto the stack.
+func (m *MethodBuilder) EmitLong(v int64) {
+	if (v == 0) || (v == 1) {
+		m.Emit(Lconst_0 + Opcode(v))
+		return
+	}
+	m.EmitLdc(m.class.Pool.Long(v))
+}
+
+// Emits an instruction pushing the given float onto the stack.
+func (m *MethodBuilder) EmitFloat(v float32) {
+	bits := math.Float32bits(v)
+	if (bits == math.Float32bits(0)) || (v == 1) || (v == 2) {
+		m.Emit(Fconst_0 + Opcode(v))
+		return
+	}
+	m.EmitLdc(m.class.Pool.Float(v))
+}
+
+// Emits an instruction pushing the given double onto the stack.
+func (m *MethodBuilder) EmitDouble(v float64) {
+	bits := math.Float64bits(v)
+	if (bits == math.Float64bits(0)) || (v == 1) {
+		m.Emit(Dconst_0 + Opcode(v))
+		return
+	}
+	m.EmitLdc(m.class.Pool.Double(v))
+}
+
+// Emits an ldc or ldc_w instruction pushing the given string onto the stack.
+func (m *MethodBuilder) EmitString(s string) {
+	m.EmitLdc(m.class.Pool.StringConstant(s))
+}
+
+// Emits getstatic, putstatic, getfield, or putfield, accessing the field with
+// the given class, name, and descriptor.
+func (m *MethodBuilder) EmitField(op Opcode, class, name, descriptor string) {
+	if (op < Getstatic) || (op > Putfield) {
+		m.fail(fmt.Errorf("%s isn't a field access instruction", op))
+		return
+	}
+	m.emitU16(op, m.class.Pool.Field(class, name, descriptor))
+}
+
+// Emits invokevirtual, invokespecial, invokestatic, or invokeinterface,
+// calling the method with the given class, name, and descriptor. The count
+// operand of invokeinterface is computed from the descriptor.
+func (m *MethodBuilder) EmitInvoke(op Opcode, class, name, descriptor string) {
+	if (op < Invokevirtual) || (op > Invokeinterface) {
+		m.fail(fmt.Errorf("%s isn't an invoke instruction", op))
+		return
+	}
+	if op != Invokeinterface {
+		m.emitU16(op, m.class.Pool.Method(class, name, descriptor))
+		return
+	}
+	parsed, e := class_file.ParseMethodDescriptor([]byte(descriptor))
+	if e != nil {
+		m.fail(fmt.Errorf("Invalid descriptor for %s.%s: %w", class, name, e))
+		return
+	}
+	// The count includes the object reference.
+	count := 1
+	for _, t := range parsed.ArgumentTypes {
+		count += fieldTypeSlots(t)
+	}
+	index := m.class.Pool.InterfaceMethod(class, name, descriptor)
+	m.Emit(op, byte(index>>8), byte(index), byte(count), 0)
+}
+
+// Emits an invokedynamic instruction. The bootstrap index is the value
+// returned by ClassBuilder.AddBootstrapMethod.
+func (m *MethodBuilder) EmitInvokeDynamic(bootstrapIndex uint16, name,
+	descriptor string) {
+	index := m.class.Pool.InvokeDynamic(bootstrapIndex, name, descriptor)
+	m.Emit(Invokedynamic, byte(index>>8), byte(index), 0, 0)
+}
+
+// Emits new, anewarray, checkcast, or instanceof, with the given class name
+// or array descriptor.
+func (m *MethodBuilder) EmitType(op Opcode, className string) {
+	switch op {
+	case New, Anewarray, Checkcast, Instanceof:
+	default:
+		m.fail(fmt.Errorf("%s doesn't take a class operand", op))
+		return
+	}
+	m.emitU16(op, m.class.Pool.Class(className))
+}
+
+// Emits a multianewarray instruction, creating an array with the given
+// descriptor, e.g. "[[I", using the given number of dimensions from the stack.
+func (m *MethodBuilder) EmitMultiANewArray(descriptor string,
+	dimensions uint8) {
+	index := m.class.Pool.Class(descriptor)
+	m.Emit(Multianewarray, byte(index>>8), byte(index), dimensions)
+}
+
+// Adds an exception handler to the method, covering the code from the start
+// label up to (but not including) the end label. The catch type is the name of
+// the exception class handled, or an empty string to handle any exception.
+func (m *MethodBuilder) AddExceptionHandler(start, end, handler *Label,
+	catchType string) {
+	for _, l := range []*Label{start, end, handler} {
+		if l.method != m {
+			m.fail(fmt.Errorf("Exception handlers can't use labels from a " +
+				"different method"))
+			return
+		}
+	}
+	m.handlers = append(m.handlers, exceptionHandler{
+		start:     start,
+		end:       end,
+		handler:   handler,
+		catchType: catchType,
+	})
+}
+
+// Fills in the branch offsets in the code. Returns an error if any label
+// wasn't placed, or if a branch offset doesn't fit in 16 bits.
+func (m *MethodBuilder) resolveLabels() error {
+	for _, f := range m.fixups {
+		if f.target.offset < 0 {
+			return fmt.Errorf("The instruction at offset %d branches to a "+
+				"label that was never placed", f.instruction)
+		}
+		delta := f.target.offset - f.instruction
+		if f.wide {
+			binary.BigEndian.PutUint32(m.code[f.operand:], uint32(delta))
+			continue
+		}
+		if (delta < math.MinInt16) || (delta > math.MaxInt16) {
+			return fmt.Errorf("The branch at offset %d is too far from its "+
+				"target (offset %d); use goto_w", f.instruction,
+				f.target.offset)
+		}
+		binary.BigEndian.PutUint16(m.code[f.operand:], uint16(delta))
+	}
+	return nil
+}
+
+// Returns the method's exception table. Must be called after resolveLabels.
+func (m *MethodBuilder) exceptionTable() ([]class_file.ExceptionTableEntry,
+	error) {
+	toReturn := make([]class_file.ExceptionTableEntry, len(m.handlers))
+	for i, h := range m.handlers {
+		if (h.start.offset < 0) || (h.end.offset < 0) ||
+			(h.handler.offset < 0) {
+			return nil, fmt.Errorf("Exception handler %d uses a label that "+
+				"was never placed", i)
+		}
+		if h.start.offset >= h.end.offset {
+			return nil, fmt.Errorf("Exception handler %d covers no code", i)
+		}
+		if h.handler.offset >= len(m.code) {
+			return nil, fmt.Errorf("Exception handler %d is at the end of "+
+				"the code", i)
+		}
+		toReturn[i] = class_file.ExceptionTableEntry{
+			StartPC:   uint16(h.start.offset),
+			EndPC:     uint16(h.end.offset),
+			HandlerPC: uint16(h.handler.offset),
+		}
+		if h.catchType != "" {
+			toReturn[i].CatchType = m.class.Pool.Class(h.catchType)
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns the finished method, with its Code attribute.
+func (m *MethodBuilder) build() (*class_file.Method, error) {
+	toReturn := *(m.method)
+	attributes := append([]*class_file.Attribute{}, m.method.Attributes...)
+	m.class.setAttributeNames(attributes)
+	toReturn.Attributes = attributes
+	if toReturn.Access.IsNative() || toReturn.Access.IsAbstract() {
+		if len(m.code) != 0 {
+			return nil, fmt.Errorf("Native or abstract methods can't " +
+				"contain code")
+		}
+		return &toReturn, nil
+	}
+	if len(m.code) > 0xffff {
+		return nil, fmt.Errorf("The method's code is too long: %d bytes",
+			len(m.code))
+	}
+	e := m.resolveLabels()
+	if e != nil {
+		return nil, e
+	}
+	exceptionTable, e := m.exceptionTable()
+	if e != nil {
+		return nil, e
+	}
+	a, e := analyzeMethod(m, exceptionTable)
+	if e != nil {
+		return nil, e
+	}
+	code := &class_file.CodeAttribute{
+		MaxStack:       uint16(a.maxStack),
+		MaxLocals:      uint16(a.maxLocals),
+		Code:           m.code,
+		ExceptionTable: exceptionTable,
+	}
+	if m.MaxStack > code.MaxStack {
+		code.MaxStack = m.MaxStack
+	}
+	if m.MaxLocals > code.MaxLocals {
+		code.MaxLocals = m.MaxLocals
+	}
+	if len(m.lineNumbers) != 0 {
+		lines, e := class_file.EncodeLineNumberTableAttribute(m.lineNumbers)
+		if e != nil {
+			return nil, e
+		}
+		m.class.setAttributeNames([]*class_file.Attribute{lines})
+		code.Attributes = append(code.Attributes, lines)
+	}
+	// Class files before version 50 don't use stack map frames.
+	if m.class.MajorVersion >= 50 {
+		frames, e := a.stackMapFrames()
+		if e != nil {
+			return nil, e
+		}
+		if len(frames) != 0 {
+			stackMap, e := class_file.EncodeStackMapTableAttribute(frames)
+			if e != nil {
+				return nil, e
+			}
+			m.class.setAttributeNames([]*class_file.Attribute{stackMap})
+			code.Attributes = append(code.Attributes, stackMap)
+		}
+	}
+	pool := m.class.Pool
+	codeAttribute, e := class_file.EncodeCodeAttribute(code,
+		&class_file.Class{Constants: pool.Constants()})
+	if e != nil {
+		return nil, e
+	}
+	m.class.setAttributeNames([]*class_file.Attribute{codeAttribute})
+	toReturn.Attributes = append(toReturn.Attributes, codeAttribute)
+	return &toReturn, nil
+}
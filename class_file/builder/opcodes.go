@@ -0,0 +1,474 @@
+package builder
+
+// This file contains the opcodes that can be emitted by a MethodBuilder, along
+// with the information about them needed to assemble method code.
+
+import (
+	"fmt"
+)
+
+// A single JVM opcode byte.
+type Opcode uint8
+
+// The names of these constants match the instruction mnemonics in the JVM
+// spec, with the first letter capitalized.
+const (
+	Nop             Opcode = 0x00
+	Aconst_null     Opcode = 0x01
+	Iconst_m1       Opcode = 0x02
+	Iconst_0        Opcode = 0x03
+	Iconst_1        Opcode = 0x04
+	Iconst_2        Opcode = 0x05
+	Iconst_3        Opcode = 0x06
+	Iconst_4        Opcode = 0x07
+	Iconst_5        Opcode = 0x08
+	Lconst_0        Opcode = 0x09
+	Lconst_1        Opcode = 0x0a
+	Fconst_0        Opcode = 0x0b
+	Fconst_1        Opcode = 0x0c
+	Fconst_2        Opcode = 0x0d
+	Dconst_0        Opcode = 0x0e
+	Dconst_1        Opcode = 0x0f
+	Bipush          Opcode = 0x10
+	Sipush          Opcode = 0x11
+	Ldc             Opcode = 0x12
+	Ldc_w           Opcode = 0x13
+	Ldc2_w          Opcode = 0x14
+	Iload           Opcode = 0x15
+	Lload           Opcode = 0x16
+	Fload           Opcode = 0x17
+	Dload           Opcode = 0x18
+	Aload           Opcode = 0x19
+	Iload_0         Opcode = 0x1a
+	Iload_1         Opcode = 0x1b
+	Iload_2         Opcode = 0x1c
+	Iload_3         Opcode = 0x1d
+	Lload_0         Opcode = 0x1e
+	Lload_1         Opcode = 0x1f
+	Lload_2         Opcode = 0x20
+	Lload_3         Opcode = 0x21
+	Fload_0         Opcode = 0x22
+	Fload_1         Opcode = 0x23
+	Fload_2         Opcode = 0x24
+	Fload_3         Opcode = 0x25
+	Dload_0         Opcode = 0x26
+	Dload_1         Opcode = 0x27
+	Dload_2         Opcode = 0x28
+	Dload_3         Opcode = 0x29
+	Aload_0         Opcode = 0x2a
+	Aload_1         Opcode = 0x2b
+	Aload_2         Opcode = 0x2c
+	Aload_3         Opcode = 0x2d
+	Iaload          Opcode = 0x2e
+	Laload          Opcode = 0x2f
+	Faload          Opcode = 0x30
+	Daload          Opcode = 0x31
+	Aaload          Opcode = 0x32
+	Baload          Opcode = 0x33
+	Caload          Opcode = 0x34
+	Saload          Opcode = 0x35
+	Istore          Opcode = 0x36
+	Lstore          Opcode = 0x37
+	Fstore          Opcode = 0x38
+	Dstore          Opcode = 0x39
+	Astore          Opcode = 0x3a
+	Istore_0        Opcode = 0x3b
+	Istore_1        Opcode = 0x3c
+	Istore_2        Opcode = 0x3d
+	Istore_3        Opcode = 0x3e
+	Lstore_0        Opcode = 0x3f
+	Lstore_1        Opcode = 0x40
+	Lstore_2        Opcode = 0x41
+	Lstore_3        Opcode = 0x42
+	Fstore_0        Opcode = 0x43
+	Fstore_1        Opcode = 0x44
+	Fstore_2        Opcode = 0x45
+	Fstore_3        Opcode = 0x46
+	Dstore_0        Opcode = 0x47
+	Dstore_1        Opcode = 0x48
+	Dstore_2        Opcode = 0x49
+	Dstore_3        Opcode = 0x4a
+	Astore_0        Opcode = 0x4b
+	Astore_1        Opcode = 0x4c
+	Astore_2        Opcode = 0x4d
+	Astore_3        Opcode = 0x4e
+	Iastore         Opcode = 0x4f
+	Lastore         Opcode = 0x50
+	Fastore         Opcode = 0x51
+	Dastore         Opcode = 0x52
+	Aastore         Opcode = 0x53
+	Bastore         Opcode = 0x54
+	Castore         Opcode = 0x55
+	Sastore         Opcode = 0x56
+	Pop             Opcode = 0x57
+	Pop2            Opcode = 0x58
+	Dup             Opcode = 0x59
+	Dup_x1          Opcode = 0x5a
+	Dup_x2          Opcode = 0x5b
+	Dup2            Opcode = 0x5c
+	Dup2_x1         Opcode = 0x5d
+	Dup2_x2         Opcode = 0x5e
+	Swap            Opcode = 0x5f
+	Iadd            Opcode = 0x60
+	Ladd            Opcode = 0x61
+	Fadd            Opcode = 0x62
+	Dadd            Opcode = 0x63
+	Isub            Opcode = 0x64
+	Lsub            Opcode = 0x65
+	Fsub            Opcode = 0x66
+	Dsub            Opcode = 0x67
+	Imul            Opcode = 0x68
+	Lmul            Opcode = 0x69
+	Fmul            Opcode = 0x6a
+	Dmul            Opcode = 0x6b
+	Idiv            Opcode = 0x6c
+	Ldiv            Opcode = 0x6d
+	Fdiv            Opcode = 0x6e
+	Ddiv            Opcode = 0x6f
+	Irem            Opcode = 0x70
+	Lrem            Opcode = 0x71
+	Frem            Opcode = 0x72
+	Drem            Opcode = 0x73
+	Ineg            Opcode = 0x74
+	Lneg            Opcode = 0x75
+	Fneg            Opcode = 0x76
+	Dneg            Opcode = 0x77
+	Ishl            Opcode = 0x78
+	Lshl            Opcode = 0x79
+	Ishr            Opcode = 0x7a
+	Lshr            Opcode = 0x7b
+	Iushr           Opcode = 0x7c
+	Lushr           Opcode = 0x7d
+	Iand            Opcode = 0x7e
+	Land            Opcode = 0x7f
+	Ior             Opcode = 0x80
+	Lor             Opcode = 0x81
+	Ixor            Opcode = 0x82
+	Lxor            Opcode = 0x83
+	Iinc            Opcode = 0x84
+	I2l             Opcode = 0x85
+	I2f             Opcode = 0x86
+	I2d             Opcode = 0x87
+	L2i             Opcode = 0x88
+	L2f             Opcode = 0x89
+	L2d             Opcode = 0x8a
+	F2i             Opcode = 0x8b
+	F2l             Opcode = 0x8c
+	F2d             Opcode = 0x8d
+	D2i             Opcode = 0x8e
+	D2l             Opcode = 0x8f
+	D2f             Opcode = 0x90
+	I2b             Opcode = 0x91
+	I2c             Opcode = 0x92
+	I2s             Opcode = 0x93
+	Lcmp            Opcode = 0x94
+	Fcmpl           Opcode = 0x95
+	Fcmpg           Opcode = 0x96
+	Dcmpl           Opcode = 0x97
+	Dcmpg           Opcode = 0x98
+	Ifeq            Opcode = 0x99
+	Ifne            Opcode = 0x9a
+	Iflt            Opcode = 0x9b
+	Ifge            Opcode = 0x9c
+	Ifgt            Opcode = 0x9d
+	Ifle            Opcode = 0x9e
+	If_icmpeq       Opcode = 0x9f
+	If_icmpne       Opcode = 0xa0
+	If_icmplt       Opcode = 0xa1
+	If_icmpge       Opcode = 0xa2
+	If_icmpgt       Opcode = 0xa3
+	If_icmple       Opcode = 0xa4
+	If_acmpeq       Opcode = 0xa5
+	If_acmpne       Opcode = 0xa6
+	Goto            Opcode = 0xa7
+	Jsr             Opcode = 0xa8
+	Ret             Opcode = 0xa9
+	Tableswitch     Opcode = 0xaa
+	Lookupswitch    Opcode = 0xab
+	Ireturn         Opcode = 0xac
+	Lreturn         Opcode = 0xad
+	Freturn         Opcode = 0xae
+	Dreturn         Opcode = 0xaf
+	Areturn         Opcode = 0xb0
+	Return          Opcode = 0xb1
+	Getstatic       Opcode = 0xb2
+	Putstatic       Opcode = 0xb3
+	Getfield        Opcode = 0xb4
+	Putfield        Opcode = 0xb5
+	Invokevirtual   Opcode = 0xb6
+	Invokespecial   Opcode = 0xb7
+	Invokestatic    Opcode = 0xb8
+	Invokeinterface Opcode = 0xb9
+	Invokedynamic   Opcode = 0xba
+	New             Opcode = 0xbb
+	Newarray        Opcode = 0xbc
+	Anewarray       Opcode = 0xbd
+	Arraylength     Opcode = 0xbe
+	Athrow          Opcode = 0xbf
+	Checkcast       Opcode = 0xc0
+	Instanceof      Opcode = 0xc1
+	Monitorenter    Opcode = 0xc2
+	Monitorexit     Opcode = 0xc3
+	Wide            Opcode = 0xc4
+	Multianewarray  Opcode = 0xc5
+	Ifnull          Opcode = 0xc6
+	Ifnonnull       Opcode = 0xc7
+	Goto_w          Opcode = 0xc8
+	Jsr_w           Opcode = 0xc9
+	Breakpoint      Opcode = 0xca
+	Impdep1         Opcode = 0xfe
+	Impdep2         Opcode = 0xff
+)
+
+// Holds information about a single opcode.
+type opcodeInfo struct {
+	name string
+	// The number of bytes following the opcode, or -1 if the instruction's
+	// length varies (tableswitch, lookupswitch, and wide).
+	operandBytes int
+}
+
+// Holds information about each opcode, indexed by the opcode's value. Contains
+// nil for invalid opcodes.
+var opcodeTable = [256]*opcodeInfo{
+	0x00: {"nop", 0},
+	0x01: {"aconst_null", 0},
+	0x02: {"iconst_m1", 0},
+	0x03: {"iconst_0", 0},
+	0x04: {"iconst_1", 0},
+	0x05: {"iconst_2", 0},
+	0x06: {"iconst_3", 0},
+	0x07: {"iconst_4", 0},
+	0x08: {"iconst_5", 0},
+	0x09: {"lconst_0", 0},
+	0x0a: {"lconst_1", 0},
+	0x0b: {"fconst_0", 0},
+	0x0c: {"fconst_1", 0},
+	0x0d: {"fconst_2", 0},
+	0x0e: {"dconst_0", 0},
+	0x0f: {"dconst_1", 0},
+	0x10: {"bipush", 1},
+	0x11: {"sipush", 2},
+	0x12: {"ldc", 1},
+	0x13: {"ldc_w", 2},
+	0x14: {"ldc2_w", 2},
+	0x15: {"iload", 1},
+	0x16: {"lload", 1},
+	0x17: {"fload", 1},
+	0x18: {"dload", 1},
+	0x19: {"aload", 1},
+	0x1a: {"iload_0", 0},
+	0x1b: {"iload_1", 0},
+	0x1c: {"iload_2", 0},
+	0x1d: {"iload_3", 0},
+	0x1e: {"lload_0", 0},
+	0x1f: {"lload_1", 0},
+	0x20: {"lload_2", 0},
+	0x21: {"lload_3", 0},
+	0x22: {"fload_0", 0},
+	0x23: {"fload_1", 0},
+	0x24: {"fload_2", 0},
+	0x25: {"fload_3", 0},
+	0x26: {"dload_0", 0},
+	0x27: {"dload_1", 0},
+	0x28: {"dload_2", 0},
+	0x29: {"dload_3", 0},
+	0x2a: {"aload_0", 0},
+	0x2b: {"aload_1", 0},
+	0x2c: {"aload_2", 0},
+	0x2d: {"aload_3", 0},
+	0x2e: {"iaload", 0},
+	0x2f: {"laload", 0},
+	0x30: {"faload", 0},
+	0x31: {"daload", 0},
+	0x32: {"aaload", 0},
+	0x33: {"baload", 0},
+	0x34: {"caload", 0},
+	0x35: {"saload", 0},
+	0x36: {"istore", 1},
+	0x37: {"lstore", 1},
+	0x38: {"fstore", 1},
+	0x39: {"dstore", 1},
+	0x3a: {"astore", 1},
+	0x3b: {"istore_0", 0},
+	0x3c: {"istore_1", 0},
+	0x3d: {"istore_2", 0},
+	0x3e: {"istore_3", 0},
+	0x3f: {"lstore_0", 0},
+	0x40: {"lstore_1", 0},
+	0x41: {"lstore_2", 0},
+	0x42: {"lstore_3", 0},
+	0x43: {"fstore_0", 0},
+	0x44: {"fstore_1", 0},
+	0x45: {"fstore_2", 0},
+	0x46: {"fstore_3", 0},
+	0x47: {"dstore_0", 0},
+	0x48: {"dstore_1", 0},
+	0x49: {"dstore_2", 0},
+	0x4a: {"dstore_3", 0},
+	0x4b: {"astore_0", 0},
+	0x4c: {"astore_1", 0},
+	0x4d: {"astore_2", 0},
+	0x4e: {"astore_3", 0},
+	0x4f: {"iastore", 0},
+	0x50: {"lastore", 0},
+	0x51: {"fastore", 0},
+	0x52: {"dastore", 0},
+	0x53: {"aastore", 0},
+	0x54: {"bastore", 0},
+	0x55: {"castore", 0},
+	0x56: {"sastore", 0},
+	0x57: {"pop", 0},
+	0x58: {"pop2", 0},
+	0x59: {"dup", 0},
+	0x5a: {"dup_x1", 0},
+	0x5b: {"dup_x2", 0},
+	0x5c: {"dup2", 0},
+	0x5d: {"dup2_x1", 0},
+	0x5e: {"dup2_x2", 0},
+	0x5f: {"swap", 0},
+	0x60: {"iadd", 0},
+	0x61: {"ladd", 0},
+	0x62: {"fadd", 0},
+	0x63: {"dadd", 0},
+	0x64: {"isub", 0},
+	0x65: {"lsub", 0},
+	0x66: {"fsub", 0},
+	0x67: {"dsub", 0},
+	0x68: {"imul", 0},
+	0x69: {"lmul", 0},
+	0x6a: {"fmul", 0},
+	0x6b: {"dmul", 0},
+	0x6c: {"idiv", 0},
+	0x6d: {"ldiv", 0},
+	0x6e: {"fdiv", 0},
+	0x6f: {"ddiv", 0},
+	0x70: {"irem", 0},
+	0x71: {"lrem", 0},
+	0x72: {"frem", 0},
+	0x73: {"drem", 0},
+	0x74: {"ineg", 0},
+	0x75: {"lneg", 0},
+	0x76: {"fneg", 0},
+	0x77: {"dneg", 0},
+	0x78: {"ishl", 0},
+	0x79: {"lshl", 0},
+	0x7a: {"ishr", 0},
+	0x7b: {"lshr", 0},
+	0x7c: {"iushr", 0},
+	0x7d: {"lushr", 0},
+	0x7e: {"iand", 0},
+	0x7f: {"land", 0},
+	0x80: {"ior", 0},
+	0x81: {"lor", 0},
+	0x82: {"ixor", 0},
+	0x83: {"lxor", 0},
+	0x84: {"iinc", 2},
+	0x85: {"i2l", 0},
+	0x86: {"i2f", 0},
+	0x87: {"i2d", 0},
+	0x88: {"l2i", 0},
+	0x89: {"l2f", 0},
+	0x8a: {"l2d", 0},
+	0x8b: {"f2i", 0},
+	0x8c: {"f2l", 0},
+	0x8d: {"f2d", 0},
+	0x8e: {"d2i", 0},
+	0x8f: {"d2l", 0},
+	0x90: {"d2f", 0},
+	0x91: {"i2b", 0},
+	0x92: {"i2c", 0},
+	0x93: {"i2s", 0},
+	0x94: {"lcmp", 0},
+	0x95: {"fcmpl", 0},
+	0x96: {"fcmpg", 0},
+	0x97: {"dcmpl", 0},
+	0x98: {"dcmpg", 0},
+	0x99: {"ifeq", 2},
+	0x9a: {"ifne", 2},
+	0x9b: {"iflt", 2},
+	0x9c: {"ifge", 2},
+	0x9d: {"ifgt", 2},
+	0x9e: {"ifle", 2},
+	0x9f: {"if_icmpeq", 2},
+	0xa0: {"if_icmpne", 2},
+	0xa1: {"if_icmplt", 2},
+	0xa2: {"if_icmpge", 2},
+	0xa3: {"if_icmpgt", 2},
+	0xa4: {"if_icmple", 2},
+	0xa5: {"if_acmpeq", 2},
+	0xa6: {"if_acmpne", 2},
+	0xa7: {"goto", 2},
+	0xa8: {"jsr", 2},
+	0xa9: {"ret", 1},
+	0xaa: {"tableswitch", -1},
+	0xab: {"lookupswitch", -1},
+	0xac: {"ireturn", 0},
+	0xad: {"lreturn", 0},
+	0xae: {"freturn", 0},
+	0xaf: {"dreturn", 0},
+	0xb0: {"areturn", 0},
+	0xb1: {"return", 0},
+	0xb2: {"getstatic", 2},
+	0xb3: {"putstatic", 2},
+	0xb4: {"getfield", 2},
+	0xb5: {"putfield", 2},
+	0xb6: {"invokevirtual", 2},
+	0xb7: {"invokespecial", 2},
+	0xb8: {"invokestatic", 2},
+	0xb9: {"invokeinterface", 4},
+	0xba: {"invokedynamic", 4},
+	0xbb: {"new", 2},
+	0xbc: {"newarray", 1},
+	0xbd: {"anewarray", 2},
+	0xbe: {"arraylength", 0},
+	0xbf: {"athrow", 0},
+	0xc0: {"checkcast", 2},
+	0xc1: {"instanceof", 2},
+	0xc2: {"monitorenter", 0},
+	0xc3: {"monitorexit", 0},
+	0xc4: {"wide", -1},
+	0xc5: {"multianewarray", 3},
+	0xc6: {"ifnull", 2},
+	0xc7: {"ifnonnull", 2},
+	0xc8: {"goto_w", 4},
+	0xc9: {"jsr_w", 4},
+	0xca: {"breakpoint", 0},
+	0xfe: {"impdep1", 0},
+	0xff: {"impdep2", 0},
+}
+
+func (o Opcode) String() string {
+	info := opcodeTable[o]
+	if info == nil {
+		return fmt.Sprintf("invalid opcode 0x%02x", uint8(o))
+	}
+	return info.name
+}
+
+// Returns false if the opcode isn't defined by the JVM spec.
+func (o Opcode) IsValid() bool {
+	return opcodeTable[o] != nil
+}
+
+// Returns the number of operand bytes following the opcode, or -1 if the
+// length of the instruction varies (tableswitch, lookupswitch, and wide) or the
+// opcode is invalid.
+func (o Opcode) OperandBytes() int {
+	info := opcodeTable[o]
+	if info == nil {
+		return -1
+	}
+	return info.operandBytes
+}
+
+// Returns the opcode with the given mnemonic, e.g. "iadd". Returns false if
+// the name isn't a valid mnemonic.
+func LookupOpcode(name string) (Opcode, bool) {
+	for i, info := range opcodeTable {
+		if (info != nil) && (info.name == name) {
+			return Opcode(i), true
+		}
+	}
+	return 0, false
+}
@@ -2,6 +2,7 @@
 package class_file
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -50,6 +51,11 @@ func (f ClassAccessFlags) String() string {
 	return strings.TrimRight(toReturn, " ")
 }
 
+// Returns true if the access flags indicate an interface rather than a class.
+func (f ClassAccessFlags) IsInterface() bool {
+	return (f & 0x0200) != 0
+}
+
 // Holds relevant data from a parsed class file.
 type Class struct {
 	MinorVersion uint16
@@ -73,7 +79,7 @@ func (c *Class) GetConstant(index uint16) (Constant, error) {
 	if index == 0 {
 		return nil, fmt.Errorf("Constant indices must be greater than 0")
 	}
-	if int(index) > len(c.Constants) {
+	if int(index) >= len(c.Constants) {
 		return nil, fmt.Errorf("Invalid constant index: %d", index)
 	}
 	toReturn := c.Constants[index]
@@ -97,9 +103,10 @@ func (c *Class) GetUTF8Constant(index uint16) ([]byte, error) {
 	return toReturn.Bytes, nil
 }
 
-// Returns the class' name as a slice of UTF-8 bytes.
-func (c *Class) GetName() ([]byte, error) {
-	infoConstant, e := c.GetConstant(c.ThisClass)
+// Returns the name referred to by the class info constant at the given index,
+// as a slice of UTF-8 bytes.
+func (c *Class) GetClassConstantName(index uint16) ([]byte, error) {
+	infoConstant, e := c.GetConstant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't get class info constant: %s", e)
 	}
@@ -111,6 +118,101 @@ func (c *Class) GetName() ([]byte, error) {
 	return c.GetUTF8Constant(classInfo.NameIndex)
 }
 
+// Returns the class' name as a slice of UTF-8 bytes.
+func (c *Class) GetName() ([]byte, error) {
+	return c.GetClassConstantName(c.ThisClass)
+}
+
+// Returns the name of the class' direct superclass. Returns nil, with no
+// error, if the class has no superclass, which is only the case for
+// java/lang/Object.
+func (c *Class) GetSuperClassName() ([]byte, error) {
+	if c.SuperClass == 0 {
+		return nil, nil
+	}
+	return c.GetClassConstantName(c.SuperClass)
+}
+
+// Returns the names of the interfaces directly implemented by the class, in
+// the order they're listed in the class file.
+func (c *Class) GetInterfaceNames() ([][]byte, error) {
+	toReturn := make([][]byte, len(c.Interfaces))
+	for i, index := range c.Interfaces {
+		name, e := c.GetClassConstantName(index)
+		if e != nil {
+			return nil, fmt.Errorf("Couldn't get name of interface %d: %w", i,
+				e)
+		}
+		toReturn[i] = name
+	}
+	return toReturn, nil
+}
+
+// Returns the index of a UTF-8 constant containing the given value. Returns
+// the given index if it already refers to a matching constant, so that
+// unmodified class files are written back using the same constants. Otherwise
+// searches the constant pool for a matching constant, and returns an error if
+// there isn't one.
+func (c *Class) getUTF8ConstantIndex(index uint16, value []byte) (uint16,
+	error) {
+	existing, e := c.GetUTF8Constant(index)
+	if (e == nil) && bytes.Equal(existing, value) {
+		return index, nil
+	}
+	for i, constant := range c.Constants {
+		utf8, ok := constant.(*ConstantUTF8Info)
+		if ok && bytes.Equal(utf8.Bytes, value) {
+			return uint16(i), nil
+		}
+	}
+	return 0, fmt.Errorf("The constant pool doesn't contain %q", value)
+}
+
+// Writes a 16-bit count of items in a table, or returns an error if the count
+// is too large to fit in 16 bits. The description is used in the error message.
+func writeCount(data io.Writer, count int, description string) error {
+	if count > 0xffff {
+		return fmt.Errorf("Too many %s: %d", description, count)
+	}
+	return binary.Write(data, binary.BigEndian, uint16(count))
+}
+
+// Writes the class file to the given writer. An unmodified class returned by
+// ParseClass will be written identically to the original file. Returns the
+// number of bytes written, or an error if one occurs, e.g. if a field, method,
+// or attribute's name isn't in the constant pool.
+func (c *Class) WriteTo(w io.Writer) (int64, error) {
+	data := &bytes.Buffer{}
+	binary.Write(data, binary.BigEndian, uint32(0xcafebabe))
+	binary.Write(data, binary.BigEndian, c.MinorVersion)
+	binary.Write(data, binary.BigEndian, c.MajorVersion)
+	e := c.writeConstantsTable(data)
+	if e != nil {
+		return 0, fmt.Errorf("Failed writing constant pool: %w", e)
+	}
+	binary.Write(data, binary.BigEndian, c.Access)
+	binary.Write(data, binary.BigEndian, c.ThisClass)
+	binary.Write(data, binary.BigEndian, c.SuperClass)
+	e = writeCount(data, len(c.Interfaces), "interfaces")
+	if e != nil {
+		return 0, e
+	}
+	binary.Write(data, binary.BigEndian, c.Interfaces)
+	e = c.writeFieldTable(data)
+	if e != nil {
+		return 0, fmt.Errorf("Failed writing fields: %w", e)
+	}
+	e = c.writeMethodTable(data)
+	if e != nil {
+		return 0, fmt.Errorf("Failed writing methods: %w", e)
+	}
+	e = c.writeAttributesTable(data, c.Attributes)
+	if e != nil {
+		return 0, fmt.Errorf("Failed writing attributes: %w", e)
+	}
+	return data.WriteTo(w)
+}
+
 // Parses a class file; returns an error if the file is not valid. The file can
 // be closed after this function returns.
 func ParseClass(data io.Reader) (*Class, error) {
@@ -2,8 +2,12 @@ package class_file
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -134,4 +138,806 @@ func TestParseStackMapFrameAttributes(t *testing.T) {
 	}
 }
 
-// TODO: Add a test for annotations.
+// An io.Writer that discards data, but returns an error from a single call to
+// Write. Used to make sure errors from every write are checked.
+type failOnceWriter struct {
+	// The number of calls to Write so far.
+	writes int
+	// The index of the call to Write that fails.
+	failAt int
+}
+
+func (w *failOnceWriter) Write(data []byte) (int, error) {
+	w.writes++
+	if w.writes == (w.failAt + 1) {
+		return 0, fmt.Errorf("Test write error")
+	}
+	return len(data), nil
+}
+
+func TestWriteStackMapFrameErrors(t *testing.T) {
+	info := VerificationTypeInfo{Tag: 1}
+	appendFrame, _ := NewAppendFrame(300, []VerificationTypeInfo{info})
+	chopFrame, _ := NewChopFrame(300, 2)
+	frames := []StackMapFrame{
+		NewSameFrame(3),
+		NewSameFrame(300),
+		NewOneItemFrame(3, info),
+		NewOneItemFrame(300, info),
+		chopFrame,
+		appendFrame,
+		NewFullFrame(300, []VerificationTypeInfo{info}, nil),
+	}
+	for _, f := range frames {
+		counter := &failOnceWriter{failAt: -1}
+		e := writeStackMapFrame(counter, f)
+		if e != nil {
+			t.Logf("Failed writing %s: %s\n", f, e)
+			t.FailNow()
+		}
+		for i := 0; i < counter.writes; i++ {
+			e = writeStackMapFrame(&failOnceWriter{failAt: i}, f)
+			if e == nil {
+				t.Logf("Didn't get an error when write %d of %s failed\n",
+					i, f)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestWriteClass(t *testing.T) {
+	for _, name := range []string{"RandomDots", "RandomDotsSimple"} {
+		original, e := ioutil.ReadFile("test_data/" + name + ".class")
+		if e != nil {
+			t.Logf("Failed reading %s.class: %s\n", name, e)
+			t.FailNow()
+		}
+		class, e := ParseClass(bytes.NewReader(original))
+		if e != nil {
+			t.Logf("Failed parsing %s.class: %s\n", name, e)
+			t.FailNow()
+		}
+		written := &bytes.Buffer{}
+		n, e := class.WriteTo(written)
+		if e != nil {
+			t.Logf("Failed writing %s.class: %s\n", name, e)
+			t.FailNow()
+		}
+		if (n != int64(len(original))) ||
+			!bytes.Equal(written.Bytes(), original) {
+			t.Logf("Writing %s.class didn't reproduce the original file\n",
+				name)
+			t.Fail()
+		}
+	}
+
+	// Strip the class' attributes and make sure the result can be parsed.
+	class := getParsedClass(t)
+	class.Attributes = nil
+	for _, m := range class.Methods {
+		m.NameIndex = 0
+	}
+	written := &bytes.Buffer{}
+	_, e := class.WriteTo(written)
+	if e != nil {
+		t.Logf("Failed writing modified class: %s\n", e)
+		t.FailNow()
+	}
+	modified, e := ParseClass(written)
+	if e != nil {
+		t.Logf("Failed parsing modified class: %s\n", e)
+		t.FailNow()
+	}
+	if (len(modified.Attributes) != 0) || (len(modified.Methods) != 3) {
+		t.Logf("The modified class wasn't written correctly\n")
+		t.Fail()
+	}
+
+	// Names that aren't in the constant pool can't be written.
+	class.Methods[0].Name = []byte("notInTheConstantPool")
+	_, e = class.WriteTo(&bytes.Buffer{})
+	if e == nil {
+		t.Logf("Didn't get an error when writing an invalid method name\n")
+		t.Fail()
+	}
+	t.Logf("Got expected error: %s\n", e)
+}
+
+func TestModernConstants(t *testing.T) {
+	class := getParsedClass(t)
+	base := uint16(len(class.Constants))
+	class.Constants = append(class.Constants,
+		&ConstantUTF8Info{Bytes: []byte("answer")},
+		&ConstantUTF8Info{Bytes: []byte("J")},
+		&ConstantNameAndTypeInfo{NameIndex: base, DescriptorIndex: base + 1},
+		&ConstantDynamicInfo{
+			BootstrapMethodAttributeIndex: 3,
+			NameAndTypeIndex:              base + 2,
+		},
+		&ConstantUTF8Info{Bytes: []byte("java.base")},
+		&ConstantModuleInfo{NameIndex: base + 4},
+		&ConstantUTF8Info{Bytes: []byte("java/lang")},
+		&ConstantPackageInfo{NameIndex: base + 6})
+	written := &bytes.Buffer{}
+	_, e := class.WriteTo(written)
+	if e != nil {
+		t.Logf("Failed writing class with new constants: %s\n", e)
+		t.FailNow()
+	}
+	parsed, e := ParseClass(written)
+	if e != nil {
+		t.Logf("Failed parsing class with new constants: %s\n", e)
+		t.FailNow()
+	}
+	expected := []string{
+		fmt.Sprintf("dynamic constant, bootstrap method attribute index 3, "+
+			"name and type index %d", base+2),
+		fmt.Sprintf("module, name index %d", base+4),
+		fmt.Sprintf("package, name index %d", base+6),
+	}
+	for i, index := range []uint16{base + 3, base + 5, base + 7} {
+		constant, e := parsed.GetConstant(index)
+		if e != nil {
+			t.Logf("Failed getting constant %d: %s\n", index, e)
+			t.FailNow()
+		}
+		t.Logf("Constant %d: %s\n", index, constant)
+		if constant.String() != expected[i] {
+			t.Logf("Expected constant %d to be %s\n", index, expected[i])
+			t.Fail()
+		}
+	}
+
+	// Tags that were never assigned still can't be parsed.
+	_, e = parseSingleConstant(bytes.NewReader([]byte{13, 0, 0}))
+	if e == nil {
+		t.Logf("Didn't get an error parsing an invalid constant tag\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+}
+
+// Parses the given attribute, then encodes the parsed information into a new
+// attribute. Returns nil if the attribute isn't one of the types that can be
+// parsed.
+func reencodeAttribute(t *testing.T, a *Attribute, c *Class) *Attribute {
+	var toReturn *Attribute
+	var e error
+	switch string(a.Name) {
+	case "ConstantValue":
+		var parsed *ConstantValueAttribute
+		parsed, e = a.ToConstantValueAttribute(c)
+		if e == nil {
+			toReturn, e = EncodeConstantValueAttribute(parsed, c)
+		}
+	case "Code":
+		var parsed *CodeAttribute
+		parsed, e = ParseCodeAttribute(a, c)
+		if e == nil {
+			toReturn, e = EncodeCodeAttribute(parsed, c)
+		}
+	case "Exceptions":
+		var parsed []uint16
+		parsed, e = ParseExceptionsAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeExceptionsAttribute(parsed)
+		}
+	case "InnerClasses":
+		var parsed []InnerClass
+		parsed, e = ParseInnerClassesAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeInnerClassesAttribute(parsed)
+		}
+	case "EnclosingMethod":
+		var classIndex, methodIndex uint16
+		classIndex, methodIndex, e = ParseEnclosingMethodAttribute(a)
+		toReturn = EncodeEnclosingMethodAttribute(classIndex, methodIndex)
+	case "Signature":
+		var parsed uint16
+		parsed, e = ParseSignatureAttribute(a)
+		toReturn = EncodeSignatureAttribute(parsed)
+	case "SourceFile":
+		var parsed uint16
+		parsed, e = ParseSourceFileAttribute(a)
+		toReturn = EncodeSourceFileAttribute(parsed)
+	case "LineNumberTable":
+		var parsed []LineNumberEntry
+		parsed, e = ParseLineNumberTableAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeLineNumberTableAttribute(parsed)
+		}
+	case "LocalVariableTable":
+		var parsed []LocalVariableEntry
+		parsed, e = ParseLocalVariableTableAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeLocalVariableTableAttribute(parsed)
+		}
+	case "LocalVariableTypeTable":
+		var parsed []LocalVariableTypeEntry
+		parsed, e = ParseLocalVariableTypeTableAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeLocalVariableTypeTableAttribute(parsed)
+		}
+	case "BootstrapMethods":
+		var parsed []BootstrapMethod
+		parsed, e = ParseBootstrapMethodsAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeBootstrapMethodsAttribute(parsed)
+		}
+	case "MethodParameters":
+		var parsed []MethodParameter
+		parsed, e = ParseMethodParametersAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeMethodParametersAttribute(parsed)
+		}
+	case "StackMapTable":
+		var parsed []StackMapFrame
+		parsed, e = ParseStackMapTableAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeStackMapTableAttribute(parsed)
+		}
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		var parsed []*Annotation
+		parsed, e = ParseRuntimeAnnotationsAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeRuntimeAnnotationsAttribute(string(a.Name),
+				parsed)
+		}
+	case "RuntimeVisibleParameterAnnotations",
+		"RuntimeInvisibleParameterAnnotations":
+		var parsed [][]*Annotation
+		parsed, e = ParseParameterAnnotationsAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeParameterAnnotationsAttribute(string(a.Name),
+				parsed)
+		}
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		var parsed []TypeAnnotation
+		parsed, e = ParseTypeAnnotationsAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeTypeAnnotationsAttribute(string(a.Name),
+				parsed)
+		}
+	case "AnnotationDefault":
+		var parsed ElementValue
+		parsed, e = ParseAnnotationDefaultAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeAnnotationDefaultAttribute(parsed)
+		}
+	case "Module":
+		var parsed *ModuleAttribute
+		parsed, e = ParseModuleAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeModuleAttribute(parsed)
+		}
+	case "ModulePackages":
+		var parsed []uint16
+		parsed, e = ParseModulePackagesAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeModulePackagesAttribute(parsed)
+		}
+	case "ModuleMainClass":
+		var parsed uint16
+		parsed, e = ParseModuleMainClassAttribute(a)
+		toReturn = EncodeModuleMainClassAttribute(parsed)
+	case "NestHost":
+		var parsed uint16
+		parsed, e = ParseNestHostAttribute(a)
+		toReturn = EncodeNestHostAttribute(parsed)
+	case "NestMembers":
+		var parsed []uint16
+		parsed, e = ParseNestMembersAttribute(a)
+		if e == nil {
+			toReturn, e = EncodeNestMembersAttribute(parsed)
+		}
+	case "PermittedSubclasses":
+		var parsed []uint16
+		parsed, e = ParsePermittedSubclassesAttribute(a)
+		if e == nil {
+			toReturn, e = EncodePermittedSubclassesAttribute(parsed)
+		}
+	case "Record":
+		var parsed []RecordComponent
+		parsed, e = ParseRecordAttribute(a, c)
+		if e == nil {
+			toReturn, e = EncodeRecordAttribute(parsed, c)
+		}
+	default:
+		return nil
+	}
+	if e != nil {
+		t.Logf("Failed re-encoding %s attribute: %s\n", a.Name, e)
+		t.FailNow()
+	}
+	return toReturn
+}
+
+// Checks that re-encoding the attribute reproduces its original contents.
+func checkAttributeRoundTrip(t *testing.T, a *Attribute, c *Class) {
+	encoded := reencodeAttribute(t, a, c)
+	if encoded == nil {
+		t.Logf("Skipping unsupported attribute %s\n", a.Name)
+		return
+	}
+	if !bytes.Equal(encoded.Name, a.Name) {
+		t.Logf("Encoding a %s attribute produced a %s attribute\n", a.Name,
+			encoded.Name)
+		t.Fail()
+	}
+	if !bytes.Equal(encoded.Info, a.Info) {
+		t.Logf("Re-encoding the %s attribute changed its contents\n", a.Name)
+		t.Fail()
+	}
+}
+
+func TestEncodeAttributes(t *testing.T) {
+	class := getParsedClass(t)
+	attributes := append([]*Attribute{}, class.Attributes...)
+	for _, f := range class.Fields {
+		attributes = append(attributes, f.Attributes...)
+	}
+	for _, m := range class.Methods {
+		attributes = append(attributes, m.Attributes...)
+		code, e := m.GetCodeAttribute(class)
+		if e != nil {
+			t.Logf("Failed getting code for %s: %s\n", m.Name, e)
+			t.FailNow()
+		}
+		attributes = append(attributes, code.Attributes...)
+	}
+	for _, a := range attributes {
+		checkAttributeRoundTrip(t, a, class)
+	}
+}
+
+func TestModernAttributes(t *testing.T) {
+	class := getParsedClass(t)
+	class.Constants = append(class.Constants,
+		&ConstantUTF8Info{Bytes: []byte("Signature")})
+	signatureIndex := uint16(len(class.Constants) - 1)
+	// A module named by constant 1, requiring module 2 with version 3,
+	// exporting package 4 to everyone, opening package 5 to modules 6 and 7,
+	// using service 8, and providing service 8 using class 9.
+	module := []byte{0x00, 0x01, 0x00, 0x20, 0x00, 0x00,
+		0x00, 0x01, 0x00, 0x02, 0x00, 0x20, 0x00, 0x03,
+		0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x00, 0x05, 0x00, 0x00, 0x00, 0x02, 0x00, 0x06, 0x00, 0x07,
+		0x00, 0x01, 0x00, 0x08,
+		0x00, 0x01, 0x00, 0x08, 0x00, 0x01, 0x00, 0x09}
+	// Two record components, the second having a Signature attribute.
+	record := []byte{0x00, 0x02,
+		0x00, 0x01, 0x00, 0x02, 0x00, 0x00,
+		0x00, 0x03, 0x00, 0x04, 0x00, 0x01,
+		byte(signatureIndex >> 8), byte(signatureIndex),
+		0x00, 0x00, 0x00, 0x02, 0x00, 0x05}
+	indices := []byte{0x00, 0x02, 0x00, 0x0a, 0x00, 0x0b}
+	attributes := []*Attribute{
+		{Name: []byte("Module"), Info: module},
+		{Name: []byte("ModulePackages"), Info: indices},
+		{Name: []byte("ModuleMainClass"), Info: []byte{0x00, 0x0c}},
+		{Name: []byte("NestHost"), Info: []byte{0x00, 0x0d}},
+		{Name: []byte("NestMembers"), Info: indices},
+		{Name: []byte("PermittedSubclasses"), Info: indices},
+		{Name: []byte("Record"), Info: record},
+	}
+	for _, a := range attributes {
+		checkAttributeRoundTrip(t, a, class)
+	}
+
+	parsedModule, e := ParseModuleAttribute(attributes[0])
+	if e != nil {
+		t.Logf("Failed parsing Module attribute: %s\n", e)
+		t.FailNow()
+	}
+	if (len(parsedModule.Opens) != 1) ||
+		(len(parsedModule.Opens[0].ToIndices) != 2) ||
+		(parsedModule.Requires[0].RequiresVersionIndex != 3) ||
+		(parsedModule.Provides[0].WithIndices[0] != 9) {
+		t.Logf("Got incorrect Module attribute: %+v\n", parsedModule)
+		t.Fail()
+	}
+	members, e := ParseNestMembersAttribute(attributes[4])
+	if (e != nil) || (len(members) != 2) || (members[1] != 11) {
+		t.Logf("Got incorrect nest members: %v (error %v)\n", members, e)
+		t.Fail()
+	}
+	components, e := ParseRecordAttribute(attributes[6], class)
+	if e != nil {
+		t.Logf("Failed parsing Record attribute: %s\n", e)
+		t.FailNow()
+	}
+	if (len(components) != 2) || (len(components[1].Attributes) != 1) {
+		t.Logf("Got incorrect record components: %+v\n", components)
+		t.FailNow()
+	}
+	signature, e := ParseSignatureAttribute(components[1].Attributes[0])
+	if (e != nil) || (signature != 5) {
+		t.Logf("Got incorrect record component signature: %d (error %v)\n",
+			signature, e)
+		t.Fail()
+	}
+
+	// Truncated attributes can't be parsed.
+	_, e = ParseModuleAttribute(&Attribute{
+		Name: []byte("Module"),
+		Info: module[:len(module)-2],
+	})
+	if e == nil {
+		t.Logf("Didn't get an error parsing a truncated Module attribute\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+}
+
+func TestEncodeAnnotations(t *testing.T) {
+	class := getParsedClass(t)
+	// An annotation with an int, an enum, an array containing a class, and a
+	// nested annotation with no values.
+	annotation := []byte{0x00, 0x01, 0x00, 0x07, 0x00, 0x04,
+		0x00, 0x03, 'I', 0x00, 0x02,
+		0x00, 0x05, 'e', 0x00, 0x06, 0x00, 0x07,
+		0x00, 0x08, '[', 0x00, 0x01, 'c', 0x00, 0x09,
+		0x00, 0x0a, '@', 0x00, 0x0b, 0x00, 0x00}
+	// Type annotations with each form of target_info: a type parameter, a
+	// supertype, a bound, an empty target, a local variable, and a type
+	// argument. The first has a type path with one entry.
+	typeAnnotations := []byte{0x00, 0x06,
+		0x00, 0x01, 0x01, 0x03, 0x00, 0x00, 0x07, 0x00, 0x00,
+		0x10, 0xff, 0xff, 0x00, 0x00, 0x07, 0x00, 0x00,
+		0x11, 0x01, 0x02, 0x00, 0x00, 0x07, 0x00, 0x00,
+		0x13, 0x00, 0x00, 0x07, 0x00, 0x00,
+		0x40, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00,
+		0x07, 0x00, 0x00,
+		0x47, 0x00, 0x10, 0x01, 0x00, 0x00, 0x07, 0x00, 0x00}
+	attributes := []*Attribute{
+		{
+			Name: []byte("RuntimeVisibleAnnotations"),
+			Info: annotation,
+		},
+		{
+			Name: []byte("RuntimeInvisibleParameterAnnotations"),
+			Info: append([]byte{0x02, 0x00, 0x00}, annotation...),
+		},
+		{
+			Name: []byte("RuntimeVisibleTypeAnnotations"),
+			Info: typeAnnotations,
+		},
+		{
+			Name: []byte("AnnotationDefault"),
+			Info: []byte{'s', 0x00, 0x03},
+		},
+		{
+			Name: []byte("MethodParameters"),
+			Info: []byte{0x02, 0x00, 0x01, 0x00, 0x10, 0x00, 0x02, 0x80,
+				0x00},
+		},
+	}
+	for _, a := range attributes {
+		checkAttributeRoundTrip(t, a, class)
+	}
+}
+
+func TestParseSignatures(t *testing.T) {
+	fields := []struct {
+		signature, expected string
+	}{
+		{"TT;", "T"},
+		{"Ljava/util/List<Ljava/lang/String;>;",
+			"java/util/List<java/lang/String>"},
+		{"[[Ljava/util/List<*>;", "java/util/List<?>[][]"},
+		{"Ljava/util/Map<TK;TV;>.Entry<TK;+[I>;",
+			"java/util/Map<K, V>.Entry<K, ? extends int[]>"},
+		{"Ljava/util/Comparator<-TT;>;",
+			"java/util/Comparator<? super T>"},
+	}
+	for _, f := range fields {
+		parsed, e := ParseFieldSignature([]byte(f.signature))
+		if e != nil {
+			t.Logf("Failed parsing field signature %s: %s\n", f.signature, e)
+			t.FailNow()
+		}
+		if parsed.String() != f.expected {
+			t.Logf("Parsed %s as %s, expected %s\n", f.signature, parsed,
+				f.expected)
+			t.Fail()
+		}
+		if FieldTypeSignature(parsed) != f.signature {
+			t.Logf("Re-encoded %s as %s\n", f.signature,
+				FieldTypeSignature(parsed))
+			t.Fail()
+		}
+	}
+
+	classSignature := "<K:Ljava/lang/Object;V::Ljava/lang/Comparable<TV;>;" +
+		":Ljava/io/Serializable;>Ljava/util/AbstractMap<TK;TV;>;" +
+		"Ljava/lang/Cloneable;"
+	class, e := ParseClassSignature([]byte(classSignature))
+	if e != nil {
+		t.Logf("Failed parsing class signature: %s\n", e)
+		t.FailNow()
+	}
+	parameters := TypeParametersString(class.TypeParameters)
+	expected := "<K extends java/lang/Object, V extends " +
+		"java/lang/Comparable<V> & java/io/Serializable>"
+	if parameters != expected {
+		t.Logf("Got type parameters %s, expected %s\n", parameters, expected)
+		t.Fail()
+	}
+	if class.TypeParameters[1].ClassBound != nil {
+		t.Logf("V shouldn't have a class bound\n")
+		t.Fail()
+	}
+	if (class.Superclass.String() != "java/util/AbstractMap<K, V>") ||
+		(len(class.Interfaces) != 1) {
+		t.Logf("Got incorrect superclass or interfaces\n")
+		t.Fail()
+	}
+	if class.Signature() != classSignature {
+		t.Logf("Re-encoded class signature as %s\n", class.Signature())
+		t.Fail()
+	}
+
+	methodSignature := "<T:Ljava/lang/Exception;>(Ljava/util/List<TT;>;I)" +
+		"[TT;^TT;^Ljava/io/IOException;"
+	method, e := ParseMethodSignature([]byte(methodSignature))
+	if e != nil {
+		t.Logf("Failed parsing method signature: %s\n", e)
+		t.FailNow()
+	}
+	if (method.ArgumentsString() != "java/util/List<T>, int") ||
+		(method.ReturnString() != "T[]") || (len(method.Throws) != 2) {
+		t.Logf("Got incorrect method signature: (%s) %s\n",
+			method.ArgumentsString(), method.ReturnString())
+		t.Fail()
+	}
+	if method.Signature() != methodSignature {
+		t.Logf("Re-encoded method signature as %s\n", method.Signature())
+		t.Fail()
+	}
+	method, e = ParseMethodSignature([]byte("()V"))
+	if (e != nil) || (method.ReturnString() != "void") {
+		t.Logf("Failed parsing a simple method signature: %v\n", e)
+		t.Fail()
+	}
+
+	// None of these are valid field, class, or method signatures.
+	invalid := []string{"", "I", "TT", "Ljava/util/List<>;", "Ljava/a.b/C;",
+		"Ljava/util/List;X", "<T>Ljava/lang/Object;", "(V)V", "()I^I"}
+	parsers := []func([]byte) error{
+		func(s []byte) error {
+			_, e := ParseFieldSignature(s)
+			return e
+		},
+		func(s []byte) error {
+			_, e := ParseClassSignature(s)
+			return e
+		},
+		func(s []byte) error {
+			_, e := ParseMethodSignature(s)
+			return e
+		},
+	}
+	for _, s := range invalid {
+		for _, parse := range parsers {
+			e = parse([]byte(s))
+			if e == nil {
+				t.Logf("Didn't get an error parsing signature %q\n", s)
+				t.Fail()
+				continue
+			}
+			t.Logf("Got expected error for %q: %s\n", s, e)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	class := getParsedClass(t)
+	e := Validate(class, nil)
+	if e != nil {
+		t.Logf("Failed validating the test class: %s\n", e)
+		t.FailNow()
+	}
+	e = Validate(class, &ValidateOptions{
+		MinMajorVersion: 45,
+		MaxMajorVersion: class.MajorVersion - 1,
+	})
+	if e == nil {
+		t.Logf("Didn't get an error for an unsupported version\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+
+	// The maximum version should still default to 61 when only the minimum
+	// is set, and vice versa.
+	e = Validate(class, &ValidateOptions{
+		MinMajorVersion: class.MajorVersion + 1,
+	})
+	if e == nil {
+		t.Logf("Didn't get an error for a version below the minimum\n")
+		t.FailNow()
+	}
+	e = Validate(class, &ValidateOptions{
+		MinMajorVersion: class.MajorVersion,
+	})
+	if e != nil {
+		t.Logf("Failed validating with only a minimum version: %s\n", e)
+		t.FailNow()
+	}
+	e = Validate(class, &ValidateOptions{
+		MaxMajorVersion: class.MajorVersion,
+	})
+	if e != nil {
+		t.Logf("Failed validating with only a maximum version: %s\n", e)
+		t.FailNow()
+	}
+
+	// Introduce several unrelated problems, all of which should be reported.
+	base := uint16(len(class.Constants))
+	class.Constants = append(class.Constants,
+		&ConstantIntegerInfo{Value: 1337},
+		&ConstantClassInfo{NameIndex: base},
+		&ConstantUTF8Info{Bytes: []byte("bad.name")},
+		&ConstantClassInfo{NameIndex: base + 2},
+		&ConstantUTF8Info{Bytes: []byte("IJ")},
+		&ConstantNameAndTypeInfo{
+			NameIndex:       base + 2,
+			DescriptorIndex: base + 4,
+		})
+	class.Access |= 0x0410
+	class.Fields[0].Access |= 0x0050
+	class.Methods = append(class.Methods, class.Methods[0])
+	class.Attributes = append(class.Attributes,
+		EncodeNestHostAttribute(base))
+	e = Validate(class, nil)
+	if e == nil {
+		t.Logf("Didn't get an error validating an invalid class\n")
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	validationError, ok := e.(*ValidationError)
+	if !ok {
+		t.Logf("Expected a *ValidationError, got %T\n", e)
+		t.FailNow()
+	}
+	expected := []string{
+		fmt.Sprintf("Constant %d: expected constant %d to be of type utf-8 "+
+			"value, got integer", base+1, base),
+		fmt.Sprintf("Constant %d: invalid class name \"bad.name\"", base+3),
+		fmt.Sprintf("Constant %d: invalid name \"bad.name\"", base+5),
+		fmt.Sprintf("Constant %d: Unexpected data in field descriptor IJ",
+			base+5),
+		"Class: a class can't be both final and abstract",
+		"final and volatile",
+		"defined more than once",
+		"Class: NestHost attribute: expected constant",
+	}
+	if len(validationError.Problems) != len(expected) {
+		t.Logf("Expected %d problems, got %d\n", len(expected),
+			len(validationError.Problems))
+		t.Fail()
+	}
+	for _, s := range expected {
+		if !strings.Contains(e.Error(), s) {
+			t.Logf("The error didn't contain \"%s\"\n", s)
+			t.Fail()
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	class := getParsedClass(t)
+	// JSON can't contain NaN, longs may not fit in a double, and invalid
+	// attributes shouldn't prevent the rest of the class from being encoded.
+	class.Constants = append(class.Constants,
+		&ConstantLongInfo{Value: math.MaxInt64}, nil,
+		&ConstantFloatInfo{Value: float32(math.NaN())})
+	class.Attributes = append(class.Attributes, &Attribute{
+		Name: []byte("LineNumberTable"),
+		Info: []byte{0x00},
+	})
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	e := encoder.Encode(class)
+	if e != nil {
+		t.Logf("Failed encoding the class as JSON: %s\n", e)
+		t.FailNow()
+	}
+	data := buffer.Bytes()
+	if !bytes.Contains(data, []byte("\"java/lang/Object.<init>:()V\"")) {
+		t.Logf("The JSON didn't contain an unescaped <init> method name\n")
+		t.Fail()
+	}
+	var decoded struct {
+		MajorVersion int `json:"major_version"`
+		ThisClass    struct {
+			Index int    `json:"index"`
+			Value string `json:"value"`
+		} `json:"this_class"`
+		Constants []struct {
+			Index int         `json:"index"`
+			Tag   string      `json:"tag"`
+			Value interface{} `json:"value"`
+		} `json:"constants"`
+		Methods []struct {
+			Name struct {
+				Value string `json:"value"`
+			} `json:"name"`
+			Attributes []struct {
+				Value struct {
+					MaxStack   int `json:"max_stack"`
+					Attributes []struct {
+						Name struct {
+							Value string `json:"value"`
+						} `json:"name"`
+						Value []map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"methods"`
+		Attributes []struct {
+			Error string `json:"error"`
+			Info  []byte `json:"info"`
+		} `json:"attributes"`
+	}
+	e = json.Unmarshal(data, &decoded)
+	if e != nil {
+		t.Logf("Failed decoding the JSON: %s\n", e)
+		t.FailNow()
+	}
+	if decoded.MajorVersion != 52 {
+		t.Logf("Expected major version 52, got %d\n", decoded.MajorVersion)
+		t.Fail()
+	}
+	if (decoded.ThisClass.Index != 6) ||
+		(decoded.ThisClass.Value != "RandomDots") {
+		t.Logf("Got incorrect this_class: %v\n", decoded.ThisClass)
+		t.Fail()
+	}
+	constant := decoded.Constants[0]
+	if (constant.Index != 1) || (constant.Tag != "Methodref") ||
+		(constant.Value != "java/lang/Object.<init>:()V") {
+		t.Logf("Got incorrect first constant: %v\n", constant)
+		t.Fail()
+	}
+	constant = decoded.Constants[len(decoded.Constants)-2]
+	if (constant.Tag != "Long") ||
+		(constant.Value != "9223372036854775807") {
+		t.Logf("Got incorrect long constant: %v\n", constant)
+		t.Fail()
+	}
+	constant = decoded.Constants[len(decoded.Constants)-1]
+	if (constant.Tag != "Float") || (constant.Value != "NaN") {
+		t.Logf("Got incorrect NaN constant: %v\n", constant)
+		t.Fail()
+	}
+	getDot := decoded.Methods[1]
+	if getDot.Name.Value != "getDot" {
+		t.Logf("Expected the second method to be getDot, got %s\n",
+			getDot.Name.Value)
+		t.FailNow()
+	}
+	code := getDot.Attributes[0].Value
+	if (code.MaxStack != 2) || (len(code.Attributes) != 2) {
+		t.Logf("Got incorrect code attribute for getDot: %v\n", code)
+		t.FailNow()
+	}
+	lines := code.Attributes[0].Value
+	if (len(lines) != 14) || (lines[1]["start_pc"] != 3.0) ||
+		(lines[1]["line_number"] != 10.0) {
+		t.Logf("Got incorrect line numbers for getDot: %v\n", lines)
+		t.Fail()
+	}
+	frames := code.Attributes[1].Value
+	if (len(frames) != 7) || (frames[0]["kind"] != "append") ||
+		(frames[0]["offset_delta"] != 44.0) {
+		t.Logf("Got incorrect stack map frames for getDot: %v\n", frames)
+		t.Fail()
+	}
+	invalid := decoded.Attributes[len(decoded.Attributes)-1]
+	if (invalid.Error == "") || (len(invalid.Info) != 1) {
+		t.Logf("Didn't get an error and raw data for an invalid attribute\n")
+		t.Fail()
+	}
+}
@@ -40,8 +40,14 @@ func (t ConstantTag) String() string {
 		return "method handle"
 	case 16:
 		return "method type"
+	case 17:
+		return "dynamic constant"
 	case 18:
 		return "InvokeDynamic information"
+	case 19:
+		return "module"
+	case 20:
+		return "package"
 	}
 	return fmt.Sprintf("unknown tag %d", uint8(t))
 }
@@ -315,6 +321,55 @@ func (n *ConstantInvokeDynamicInfo) String() string {
 		n.Tag(), n.BootstrapMethodAttributeIndex, n.NameAndTypeIndex)
 }
 
+// A constant whose value is computed by invoking a bootstrap method, the first
+// time it's loaded.
+type ConstantDynamicInfo struct {
+	// An index into the bootstrap method array in the bootstrap methods table
+	// (in the class file's attributes).
+	BootstrapMethodAttributeIndex uint16
+	// An index into the constants of a ConstantNameAndTypeInfo structure. The
+	// descriptor is a field descriptor giving the type of the constant.
+	NameAndTypeIndex uint16
+}
+
+func (n *ConstantDynamicInfo) Tag() ConstantTag {
+	return ConstantTag(17)
+}
+
+func (n *ConstantDynamicInfo) String() string {
+	return fmt.Sprintf(
+		"%s, bootstrap method attribute index %d, name and type index %d",
+		n.Tag(), n.BootstrapMethodAttributeIndex, n.NameAndTypeIndex)
+}
+
+// Refers to a module, in the constant pool of a module-info class.
+type ConstantModuleInfo struct {
+	// An index into the constants of a UTF-8 module name.
+	NameIndex uint16
+}
+
+func (n *ConstantModuleInfo) Tag() ConstantTag {
+	return ConstantTag(19)
+}
+
+func (n *ConstantModuleInfo) String() string {
+	return fmt.Sprintf("%s, name index %d", n.Tag(), n.NameIndex)
+}
+
+// Refers to a package exported or opened by a module.
+type ConstantPackageInfo struct {
+	// An index into the constants of a UTF-8 package name, in internal form.
+	NameIndex uint16
+}
+
+func (n *ConstantPackageInfo) Tag() ConstantTag {
+	return ConstantTag(20)
+}
+
+func (n *ConstantPackageInfo) String() string {
+	return fmt.Sprintf("%s, name index %d", n.Tag(), n.NameIndex)
+}
+
 // Parses and returns a single class file constant in the table.
 func parseSingleConstant(data io.Reader) (Constant, error) {
 	var tag ConstantTag
@@ -425,6 +480,14 @@ func parseSingleConstant(data io.Reader) (Constant, error) {
 			return nil, fmt.Errorf("Failed reading method type constant: %s",
 				e)
 		}
+		toReturn = &value
+	case 17:
+		var value ConstantDynamicInfo
+		e = binary.Read(data, binary.BigEndian, &value)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading dynamic constant: %s", e)
+		}
+		toReturn = &value
 	case 18:
 		var value ConstantInvokeDynamicInfo
 		e = binary.Read(data, binary.BigEndian, &value)
@@ -432,6 +495,21 @@ func parseSingleConstant(data io.Reader) (Constant, error) {
 			return nil, fmt.Errorf(
 				"Failed reading invokedynamic information constant: %s", e)
 		}
+		toReturn = &value
+	case 19:
+		var value ConstantModuleInfo
+		e = binary.Read(data, binary.BigEndian, &value)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading module constant: %s", e)
+		}
+		toReturn = &value
+	case 20:
+		var value ConstantPackageInfo
+		e = binary.Read(data, binary.BigEndian, &value)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading package constant: %s", e)
+		}
+		toReturn = &value
 	default:
 		return nil, fmt.Errorf("Unknown class file constant: %s", tag)
 	}
@@ -463,3 +541,60 @@ func parseConstantsTable(data io.Reader, count uint16) ([]Constant, error) {
 	}
 	return constants, nil
 }
+
+// Writes a single constant, including its tag. This is the inverse of
+// parseSingleConstant.
+func writeSingleConstant(data io.Writer, constant Constant) error {
+	e := binary.Write(data, binary.BigEndian, constant.Tag())
+	if e != nil {
+		return e
+	}
+	switch c := constant.(type) {
+	case *ConstantUTF8Info:
+		if len(c.Bytes) > 0xffff {
+			return fmt.Errorf("UTF-8 constant is too long: %d bytes",
+				len(c.Bytes))
+		}
+		e = binary.Write(data, binary.BigEndian, uint16(len(c.Bytes)))
+		if e != nil {
+			return e
+		}
+		_, e = data.Write(c.Bytes)
+		return e
+	case *ConstantIntegerInfo, *ConstantFloatInfo, *ConstantLongInfo,
+		*ConstantDoubleInfo, *ConstantClassInfo, *ConstantStringInfo,
+		*ConstantFieldInfo, *ConstantMethodInfo,
+		*ConstantInterfaceMethodInfo, *ConstantNameAndTypeInfo,
+		*ConstantMethodHandleInfo, *ConstantMethodTypeInfo,
+		*ConstantDynamicInfo, *ConstantInvokeDynamicInfo,
+		*ConstantModuleInfo, *ConstantPackageInfo:
+		// All of these structs are identical to their representation in the
+		// class file.
+		return binary.Write(data, binary.BigEndian, c)
+	}
+	return fmt.Errorf("Can't write constant: %s", constant)
+}
+
+// Writes the count of constants in the constant pool followed by the
+// constants themselves. This is the inverse of parseConstantsTable, so the
+// entry following each 8-byte constant must be nil.
+func (c *Class) writeConstantsTable(data io.Writer) error {
+	e := writeCount(data, len(c.Constants), "constants")
+	if e != nil {
+		return e
+	}
+	for i := 1; i < len(c.Constants); i++ {
+		constant := c.Constants[i]
+		if constant == nil {
+			return fmt.Errorf("Constant %d is missing", i)
+		}
+		e = writeSingleConstant(data, constant)
+		if e != nil {
+			return fmt.Errorf("Failed writing constant %d: %w", i, e)
+		}
+		if constant.Tag().CountsDouble() {
+			i++
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package class_file
 
 import (
 	"fmt"
+	"strings"
 )
 
 // This file contains code used for parsing field or method descriptors, so
@@ -66,6 +67,21 @@ func (t *ArrayType) String() string {
 	return fmt.Sprintf("%s%s", t.ContentType.String(), tmp)
 }
 
+// Returns the descriptor string for the given field type, e.g. "I" or
+// "[Ljava/lang/String;". This is the inverse of ParseFieldType.
+func FieldTypeDescriptor(t FieldType) string {
+	switch v := t.(type) {
+	case PrimitiveFieldType:
+		return string([]byte{byte(v)})
+	case ClassInstanceType:
+		return "L" + string(v) + ";"
+	case *ArrayType:
+		return strings.Repeat("[", int(v.Dimensions)) +
+			FieldTypeDescriptor(v.ContentType)
+	}
+	return t.String()
+}
+
 // Parses a field descriptor referring to an instance of a class. Returns a
 // FieldType and the remaining descriptor bytes, or an error if one occurs.
 func parseClassInstanceDescriptor(descriptor []byte) (FieldType, []byte,
@@ -171,6 +187,16 @@ func (d *MethodDescriptor) ReturnString() string {
 	return d.ReturnType.String()
 }
 
+// Returns the method descriptor string, e.g. "(I[B)V". This is the inverse of
+// ParseMethodDescriptor.
+func (d *MethodDescriptor) Descriptor() string {
+	toReturn := "("
+	for _, t := range d.ArgumentTypes {
+		toReturn += FieldTypeDescriptor(t)
+	}
+	return toReturn + ")" + FieldTypeDescriptor(d.ReturnType)
+}
+
 func ParseMethodDescriptor(descriptor []byte) (*MethodDescriptor, error) {
 	var e error
 	if (len(descriptor) == 0) || (descriptor[0] != '(') {
@@ -25,3 +25,13 @@ func parseExceptionTable(data io.Reader, count uint16) ([]ExceptionTableEntry,
 	}
 	return toReturn, nil
 }
+
+// Writes the count of entries in the exception table followed by the entries
+// themselves.
+func writeExceptionTable(data io.Writer, table []ExceptionTableEntry) error {
+	e := writeCount(data, len(table), "exception table entries")
+	if e != nil {
+		return e
+	}
+	return binary.Write(data, binary.BigEndian, table)
+}
@@ -45,6 +45,11 @@ func (f FieldAccessFlags) String() string {
 	return strings.TrimRight(toReturn, " ")
 }
 
+// Returns true if the access flags indicate that the field is private.
+func (f FieldAccessFlags) IsPrivate() bool {
+	return (f & 0x0002) != 0
+}
+
 // Returns true if the access flags indicate that the field is static.
 func (f FieldAccessFlags) IsStatic() bool {
 	return (f & 0x0008) != 0
@@ -56,6 +61,11 @@ type Field struct {
 	Access FieldAccessFlags
 	// The UTF-8 string containing the field's name
 	Name []byte
+	// The indices of the UTF-8 constants containing the field's name and
+	// descriptor. When the class is written, these are only used if they
+	// still refer to constants matching Name and Descriptor.
+	NameIndex       uint16
+	DescriptorIndex uint16
 	// Contains the type of the field.
 	Descriptor FieldType
 	// A table of attributes for this specific field
@@ -79,6 +89,7 @@ func (c *Class) parseSingleField(data io.Reader) (*Field, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading field name index: %s", e)
 	}
+	toReturn.NameIndex = index
 	toReturn.Name, e = c.GetUTF8Constant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Invalid field name: %s", e)
@@ -87,6 +98,7 @@ func (c *Class) parseSingleField(data io.Reader) (*Field, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading field descriptor index: %s", e)
 	}
+	toReturn.DescriptorIndex = index
 	descriptorBytes, e := c.GetUTF8Constant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't get field descriptor string: %s", e)
@@ -125,3 +137,40 @@ func (c *Class) parseFieldTable(data io.Reader, count uint16) ([]*Field,
 	}
 	return fields, nil
 }
+
+// Writes a single field structure. This is the inverse of parseSingleField.
+func (c *Class) writeSingleField(data io.Writer, f *Field) error {
+	nameIndex, e := c.getUTF8ConstantIndex(f.NameIndex, f.Name)
+	if e != nil {
+		return fmt.Errorf("Invalid name for field %s: %w", f.Name, e)
+	}
+	descriptorIndex, e := c.getUTF8ConstantIndex(f.DescriptorIndex,
+		[]byte(FieldTypeDescriptor(f.Descriptor)))
+	if e != nil {
+		return fmt.Errorf("Invalid descriptor for field %s: %w", f.Name, e)
+	}
+	binary.Write(data, binary.BigEndian, f.Access)
+	binary.Write(data, binary.BigEndian, nameIndex)
+	binary.Write(data, binary.BigEndian, descriptorIndex)
+	e = c.writeAttributesTable(data, f.Attributes)
+	if e != nil {
+		return fmt.Errorf("Failed writing attributes for field %s: %w",
+			f.Name, e)
+	}
+	return nil
+}
+
+// Writes the count of fields in the class followed by the fields themselves.
+func (c *Class) writeFieldTable(data io.Writer) error {
+	e := writeCount(data, len(c.Fields), "fields")
+	if e != nil {
+		return e
+	}
+	for _, f := range c.Fields {
+		e = c.writeSingleField(data, f)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,969 @@
+package class_file
+
+// This file contains the code for encoding a parsed class file as JSON, so it
+// can be used by other tools. Constant pool indices are encoded along with the
+// value or name they refer to.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Refers to an entry in the constant pool. The value is the resolved name,
+// string, or number, and is omitted if the index is invalid.
+type jsonIndex struct {
+	Index uint16      `json:"index"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Holds a set of access flags, both as their numeric value and as a list of
+// keywords.
+type jsonFlags struct {
+	Value uint16   `json:"value"`
+	Flags []string `json:"flags"`
+}
+
+// Holds a single entry in the constant pool. The value is the constant's
+// resolved value or name, and only the other fields relevant to the
+// constant's tag are set.
+type jsonConstant struct {
+	Index         uint16      `json:"index"`
+	Tag           string      `json:"tag"`
+	Value         interface{} `json:"value,omitempty"`
+	Class         *jsonIndex  `json:"class,omitempty"`
+	NameAndType   *jsonIndex  `json:"name_and_type,omitempty"`
+	Name          *jsonIndex  `json:"name,omitempty"`
+	Descriptor    *jsonIndex  `json:"descriptor,omitempty"`
+	String        *jsonIndex  `json:"string,omitempty"`
+	ReferenceKind string      `json:"reference_kind,omitempty"`
+	Reference     *jsonIndex  `json:"reference,omitempty"`
+	// This is a pointer so that a bootstrap method index of 0 isn't omitted.
+	BootstrapMethod *uint16 `json:"bootstrap_method,omitempty"`
+}
+
+// Holds a field or a method.
+type jsonMember struct {
+	AccessFlags jsonFlags        `json:"access_flags"`
+	Name        jsonIndex        `json:"name"`
+	Descriptor  jsonIndex        `json:"descriptor"`
+	Attributes  []*jsonAttribute `json:"attributes"`
+}
+
+// Holds an attribute. The type of the value depends on the attribute's name.
+// Attributes that aren't recognized, or that can't be parsed, contain their
+// raw bytes instead, and the parsing error if there was one.
+type jsonAttribute struct {
+	Name   jsonIndex   `json:"name"`
+	Length int         `json:"length"`
+	Value  interface{} `json:"value,omitempty"`
+	Info   []byte      `json:"info,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type jsonExceptionTableEntry struct {
+	StartPC   uint16     `json:"start_pc"`
+	EndPC     uint16     `json:"end_pc"`
+	HandlerPC uint16     `json:"handler_pc"`
+	CatchType *jsonIndex `json:"catch_type"`
+}
+
+type jsonCode struct {
+	MaxStack       uint16                    `json:"max_stack"`
+	MaxLocals      uint16                    `json:"max_locals"`
+	Code           []byte                    `json:"code"`
+	ExceptionTable []jsonExceptionTableEntry `json:"exception_table"`
+	Attributes     []*jsonAttribute          `json:"attributes"`
+}
+
+type jsonLineNumber struct {
+	StartPC    uint16 `json:"start_pc"`
+	LineNumber uint16 `json:"line_number"`
+}
+
+// Holds an entry in either a LocalVariableTable or LocalVariableTypeTable.
+type jsonLocalVariable struct {
+	StartPC    uint16     `json:"start_pc"`
+	Length     uint16     `json:"length"`
+	Name       jsonIndex  `json:"name"`
+	Descriptor *jsonIndex `json:"descriptor,omitempty"`
+	Signature  *jsonIndex `json:"signature,omitempty"`
+	Index      uint16     `json:"index"`
+}
+
+type jsonVerificationType struct {
+	Tag    string     `json:"tag"`
+	Class  *jsonIndex `json:"class,omitempty"`
+	Offset *uint16    `json:"offset,omitempty"`
+}
+
+type jsonStackMapFrame struct {
+	FrameType     uint8                  `json:"frame_type"`
+	Kind          string                 `json:"kind"`
+	OffsetDelta   uint16                 `json:"offset_delta"`
+	Locals        []jsonVerificationType `json:"locals,omitempty"`
+	Stack         []jsonVerificationType `json:"stack,omitempty"`
+	ChoppedLocals int                    `json:"chopped_locals,omitempty"`
+}
+
+type jsonElementValue struct {
+	Tag          string              `json:"tag"`
+	Constant     *jsonIndex          `json:"constant,omitempty"`
+	EnumType     *jsonIndex          `json:"enum_type,omitempty"`
+	EnumConstant *jsonIndex          `json:"enum_constant,omitempty"`
+	Annotation   *jsonAnnotation     `json:"annotation,omitempty"`
+	Values       []*jsonElementValue `json:"values,omitempty"`
+}
+
+type jsonElementValuePair struct {
+	Name  jsonIndex         `json:"name"`
+	Value *jsonElementValue `json:"value"`
+}
+
+type jsonAnnotation struct {
+	Type     jsonIndex              `json:"type"`
+	Elements []jsonElementValuePair `json:"elements"`
+}
+
+type jsonTypePathElement struct {
+	Kind          uint8 `json:"kind"`
+	ArgumentIndex uint8 `json:"argument_index"`
+}
+
+type jsonLocalVariableTarget struct {
+	StartPC uint16 `json:"start_pc"`
+	Length  uint16 `json:"length"`
+	Index   uint16 `json:"index"`
+}
+
+// Holds the target_info of a type annotation. Only the fields relevant to the
+// target type are set.
+type jsonTargetInfo struct {
+	Index         *uint16                   `json:"index,omitempty"`
+	TypeParameter *uint8                    `json:"type_parameter,omitempty"`
+	Bound         *uint8                    `json:"bound,omitempty"`
+	Offset        *uint16                   `json:"offset,omitempty"`
+	TypeArgument  *uint8                    `json:"type_argument,omitempty"`
+	Table         []jsonLocalVariableTarget `json:"table,omitempty"`
+}
+
+type jsonTypeAnnotation struct {
+	TargetType uint8                  `json:"target_type"`
+	TargetInfo jsonTargetInfo         `json:"target_info"`
+	TypePath   []jsonTypePathElement  `json:"type_path"`
+	Type       jsonIndex              `json:"type"`
+	Elements   []jsonElementValuePair `json:"elements"`
+}
+
+type jsonInnerClass struct {
+	InnerClass  jsonIndex  `json:"inner_class"`
+	OuterClass  *jsonIndex `json:"outer_class"`
+	InnerName   *jsonIndex `json:"inner_name"`
+	AccessFlags jsonFlags  `json:"access_flags"`
+}
+
+type jsonEnclosingMethod struct {
+	Class  jsonIndex  `json:"class"`
+	Method *jsonIndex `json:"method"`
+}
+
+type jsonBootstrapMethod struct {
+	MethodHandle jsonIndex   `json:"method_handle"`
+	Arguments    []jsonIndex `json:"arguments"`
+}
+
+type jsonMethodParameter struct {
+	Name        *jsonIndex `json:"name"`
+	AccessFlags jsonFlags  `json:"access_flags"`
+}
+
+type jsonModuleRequires struct {
+	Module  jsonIndex  `json:"module"`
+	Flags   uint16     `json:"flags"`
+	Version *jsonIndex `json:"version"`
+}
+
+type jsonModuleExports struct {
+	Package jsonIndex   `json:"package"`
+	Flags   uint16      `json:"flags"`
+	To      []jsonIndex `json:"to"`
+}
+
+type jsonModuleProvides struct {
+	Service jsonIndex   `json:"service"`
+	With    []jsonIndex `json:"with"`
+}
+
+type jsonModule struct {
+	Name     jsonIndex            `json:"name"`
+	Flags    uint16               `json:"flags"`
+	Version  *jsonIndex           `json:"version"`
+	Requires []jsonModuleRequires `json:"requires"`
+	Exports  []jsonModuleExports  `json:"exports"`
+	Opens    []jsonModuleExports  `json:"opens"`
+	Uses     []jsonIndex          `json:"uses"`
+	Provides []jsonModuleProvides `json:"provides"`
+}
+
+type jsonRecordComponent struct {
+	Name       jsonIndex        `json:"name"`
+	Descriptor jsonIndex        `json:"descriptor"`
+	Attributes []*jsonAttribute `json:"attributes"`
+}
+
+type jsonClass struct {
+	MinorVersion uint16           `json:"minor_version"`
+	MajorVersion uint16           `json:"major_version"`
+	AccessFlags  jsonFlags        `json:"access_flags"`
+	ThisClass    jsonIndex        `json:"this_class"`
+	SuperClass   *jsonIndex       `json:"super_class"`
+	Interfaces   []jsonIndex      `json:"interfaces"`
+	Constants    []jsonConstant   `json:"constants"`
+	Fields       []jsonMember     `json:"fields"`
+	Methods      []jsonMember     `json:"methods"`
+	Attributes   []*jsonAttribute `json:"attributes"`
+}
+
+// The names used for constant tags in the JSON output, matching the names in
+// the JVM spec.
+var jsonConstantTags = map[ConstantTag]string{
+	1:  "Utf8",
+	3:  "Integer",
+	4:  "Float",
+	5:  "Long",
+	6:  "Double",
+	7:  "Class",
+	8:  "String",
+	9:  "Fieldref",
+	10: "Methodref",
+	11: "InterfaceMethodref",
+	12: "NameAndType",
+	15: "MethodHandle",
+	16: "MethodType",
+	17: "Dynamic",
+	18: "InvokeDynamic",
+	19: "Module",
+	20: "Package",
+}
+
+// The names used for method handle reference kinds in the JSON output.
+var jsonReferenceKinds = []string{"", "REF_getField", "REF_getStatic",
+	"REF_putField", "REF_putStatic", "REF_invokeVirtual", "REF_invokeStatic",
+	"REF_invokeSpecial", "REF_newInvokeSpecial", "REF_invokeInterface"}
+
+// Returns the name of the method handle kind, as used in the JSON output.
+func jsonReferenceKind(kind MethodHandleReferenceKind) string {
+	if (kind == 0) || (int(kind) >= len(jsonReferenceKinds)) {
+		return fmt.Sprintf("unknown kind %d", kind)
+	}
+	return jsonReferenceKinds[kind]
+}
+
+// Returns a value for a floating-point number that can be encoded as JSON,
+// which doesn't support NaN or infinite values.
+func jsonFloat(v float64) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return v
+}
+
+// Returns the access flags in the form used in the JSON output.
+func newJSONFlags(value uint16, keywords string) jsonFlags {
+	return jsonFlags{
+		Value: value,
+		Flags: append([]string{}, strings.Fields(keywords)...),
+	}
+}
+
+// Returns "name:descriptor" for the name and type constant at the given
+// index, or an empty string if it's invalid.
+func (c *Class) jsonNameAndType(index uint16) string {
+	constant, e := c.GetConstant(index)
+	if e != nil {
+		return ""
+	}
+	info, ok := constant.(*ConstantNameAndTypeInfo)
+	if !ok {
+		return ""
+	}
+	name, e := c.GetUTF8Constant(info.NameIndex)
+	if e != nil {
+		return ""
+	}
+	descriptor, e := c.GetUTF8Constant(info.DescriptorIndex)
+	if e != nil {
+		return ""
+	}
+	return string(name) + ":" + string(descriptor)
+}
+
+// Returns the name of the member referred to by a field or method reference,
+// as "class.name:descriptor", or an empty string if it's invalid.
+func (c *Class) jsonMemberName(classIndex, nameAndTypeIndex uint16) string {
+	className, e := c.GetClassConstantName(classIndex)
+	if e != nil {
+		return ""
+	}
+	nameAndType := c.jsonNameAndType(nameAndTypeIndex)
+	if nameAndType == "" {
+		return ""
+	}
+	return string(className) + "." + nameAndType
+}
+
+// Returns the value referred to by the constant at the given index: a string
+// or number for UTF-8, string, and numeric constants, and a name or
+// description for others. Long values are returned as decimal strings, since
+// many JSON decoders convert all numbers to doubles, which can't represent
+// every long. Returns nil if the index is invalid.
+func (c *Class) jsonResolve(index uint16) interface{} {
+	constant, e := c.GetConstant(index)
+	if e != nil {
+		return nil
+	}
+	var toReturn string
+	switch v := constant.(type) {
+	case *ConstantUTF8Info:
+		return string(v.Bytes)
+	case *ConstantIntegerInfo:
+		return v.Value
+	case *ConstantFloatInfo:
+		return jsonFloat(float64(v.Value))
+	case *ConstantLongInfo:
+		return strconv.FormatInt(v.Value, 10)
+	case *ConstantDoubleInfo:
+		return jsonFloat(v.Value)
+	case *ConstantClassInfo:
+		return c.jsonResolve(v.NameIndex)
+	case *ConstantStringInfo:
+		return c.jsonResolve(v.StringIndex)
+	case *ConstantFieldInfo:
+		toReturn = c.jsonMemberName(v.ClassIndex, v.NameAndTypeIndex)
+	case *ConstantMethodInfo:
+		toReturn = c.jsonMemberName(v.ClassIndex, v.NameAndTypeIndex)
+	case *ConstantInterfaceMethodInfo:
+		toReturn = c.jsonMemberName(v.ClassIndex, v.NameAndTypeIndex)
+	case *ConstantNameAndTypeInfo:
+		toReturn = c.jsonNameAndType(index)
+	case *ConstantMethodHandleInfo:
+		reference, ok := c.jsonResolve(v.Index).(string)
+		if ok {
+			toReturn = jsonReferenceKind(v.ReferenceKind) + " " + reference
+		}
+	case *ConstantMethodTypeInfo:
+		return c.jsonResolve(v.DescriptorIndex)
+	case *ConstantInvokeDynamicInfo:
+		nameAndType := c.jsonNameAndType(v.NameAndTypeIndex)
+		if nameAndType != "" {
+			toReturn = fmt.Sprintf("#%d:%s", v.BootstrapMethodAttributeIndex,
+				nameAndType)
+		}
+	case *ConstantDynamicInfo:
+		nameAndType := c.jsonNameAndType(v.NameAndTypeIndex)
+		if nameAndType != "" {
+			toReturn = fmt.Sprintf("#%d:%s", v.BootstrapMethodAttributeIndex,
+				nameAndType)
+		}
+	case *ConstantModuleInfo:
+		return c.jsonResolve(v.NameIndex)
+	case *ConstantPackageInfo:
+		return c.jsonResolve(v.NameIndex)
+	}
+	if toReturn == "" {
+		return nil
+	}
+	return toReturn
+}
+
+// Returns a reference to the constant at the given index.
+func (c *Class) jsonIndex(index uint16) jsonIndex {
+	return jsonIndex{
+		Index: index,
+		Value: c.jsonResolve(index),
+	}
+}
+
+// Returns references to each of the constants at the given indices.
+func (c *Class) jsonIndices(indices []uint16) []jsonIndex {
+	toReturn := make([]jsonIndex, len(indices))
+	for i, index := range indices {
+		toReturn[i] = c.jsonIndex(index)
+	}
+	return toReturn
+}
+
+// Returns a reference to the constant at the given index, or nil if the index
+// is 0, for optional references.
+func (c *Class) jsonOptionalIndex(index uint16) *jsonIndex {
+	if index == 0 {
+		return nil
+	}
+	toReturn := c.jsonIndex(index)
+	return &toReturn
+}
+
+// Returns the JSON representation of a single constant.
+func (c *Class) jsonConstant(index uint16, constant Constant) jsonConstant {
+	toReturn := jsonConstant{
+		Index: index,
+		Tag:   jsonConstantTags[constant.Tag()],
+		Value: c.jsonResolve(index),
+	}
+	if toReturn.Tag == "" {
+		toReturn.Tag = constant.Tag().String()
+	}
+	reference := func(i uint16) *jsonIndex {
+		r := c.jsonIndex(i)
+		return &r
+	}
+	switch v := constant.(type) {
+	case *ConstantClassInfo:
+		toReturn.Name = reference(v.NameIndex)
+	case *ConstantStringInfo:
+		toReturn.String = reference(v.StringIndex)
+	case *ConstantFieldInfo:
+		toReturn.Class = reference(v.ClassIndex)
+		toReturn.NameAndType = reference(v.NameAndTypeIndex)
+	case *ConstantMethodInfo:
+		toReturn.Class = reference(v.ClassIndex)
+		toReturn.NameAndType = reference(v.NameAndTypeIndex)
+	case *ConstantInterfaceMethodInfo:
+		toReturn.Class = reference(v.ClassIndex)
+		toReturn.NameAndType = reference(v.NameAndTypeIndex)
+	case *ConstantNameAndTypeInfo:
+		toReturn.Name = reference(v.NameIndex)
+		toReturn.Descriptor = reference(v.DescriptorIndex)
+	case *ConstantMethodHandleInfo:
+		toReturn.ReferenceKind = jsonReferenceKind(v.ReferenceKind)
+		toReturn.Reference = reference(v.Index)
+	case *ConstantMethodTypeInfo:
+		toReturn.Descriptor = reference(v.DescriptorIndex)
+	case *ConstantInvokeDynamicInfo:
+		bootstrapIndex := v.BootstrapMethodAttributeIndex
+		toReturn.BootstrapMethod = &bootstrapIndex
+		toReturn.NameAndType = reference(v.NameAndTypeIndex)
+	case *ConstantDynamicInfo:
+		bootstrapIndex := v.BootstrapMethodAttributeIndex
+		toReturn.BootstrapMethod = &bootstrapIndex
+		toReturn.NameAndType = reference(v.NameAndTypeIndex)
+	case *ConstantModuleInfo:
+		toReturn.Name = reference(v.NameIndex)
+	case *ConstantPackageInfo:
+		toReturn.Name = reference(v.NameIndex)
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a verification type.
+func (c *Class) jsonVerificationTypes(
+	types []VerificationTypeInfo) []jsonVerificationType {
+	toReturn := make([]jsonVerificationType, len(types))
+	for i, t := range types {
+		toReturn[i].Tag = t.Tag.String()
+		switch t.Tag {
+		case 7:
+			toReturn[i].Class = c.jsonOptionalIndex(t.Other)
+		case 8:
+			offset := t.Other
+			toReturn[i].Offset = &offset
+		}
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a stack map frame.
+func (c *Class) jsonStackMapFrame(f StackMapFrame) jsonStackMapFrame {
+	toReturn := jsonStackMapFrame{
+		FrameType:   uint8(f.FrameType()),
+		Kind:        f.FrameType().String(),
+		OffsetDelta: f.OffsetDelta(),
+	}
+	switch v := f.(type) {
+	case *OneItemStackMapFrame:
+		toReturn.Stack = c.jsonVerificationTypes([]VerificationTypeInfo{
+			v.Info})
+	case *OneItemStackMapFrameExtended:
+		toReturn.Stack = c.jsonVerificationTypes([]VerificationTypeInfo{
+			v.Info})
+	case *ChopStackMapFrame:
+		toReturn.ChoppedLocals = 251 - int(v.FrameType())
+	case *AppendStackMapFrame:
+		toReturn.Locals = c.jsonVerificationTypes(v.Locals)
+	case *FullStackMapFrame:
+		toReturn.Locals = c.jsonVerificationTypes(v.Locals)
+		toReturn.Stack = c.jsonVerificationTypes(v.Stack)
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of an annotation's element value.
+func (c *Class) jsonElementValue(v ElementValue) *jsonElementValue {
+	toReturn := &jsonElementValue{
+		Tag: string([]byte{byte(v.Tag())}),
+	}
+	switch value := v.(type) {
+	case *EnumElementValue:
+		enumType := c.jsonIndex(value.TypeNameIndex)
+		enumConstant := c.jsonIndex(value.ConstNameIndex)
+		toReturn.EnumType = &enumType
+		toReturn.EnumConstant = &enumConstant
+	case *AnnotationElementValue:
+		toReturn.Annotation = c.jsonAnnotation(value.Value)
+	case *ArrayElementValue:
+		toReturn.Values = make([]*jsonElementValue, len(value.Values))
+		for i, element := range value.Values {
+			toReturn.Values[i] = c.jsonElementValue(element)
+		}
+	default:
+		constant := c.jsonIndex(v.Index())
+		toReturn.Constant = &constant
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a list of element-value pairs.
+func (c *Class) jsonElementValuePairs(
+	pairs []ElementValuePair) []jsonElementValuePair {
+	toReturn := make([]jsonElementValuePair, len(pairs))
+	for i, pair := range pairs {
+		toReturn[i].Name = c.jsonIndex(pair.ElementNameIndex)
+		toReturn[i].Value = c.jsonElementValue(pair.Value)
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of an annotation.
+func (c *Class) jsonAnnotation(a *Annotation) *jsonAnnotation {
+	return &jsonAnnotation{
+		Type:     c.jsonIndex(a.NameIndex),
+		Elements: c.jsonElementValuePairs(a.ElementValuePairs),
+	}
+}
+
+// Returns the JSON representation of a list of annotations.
+func (c *Class) jsonAnnotations(annotations []*Annotation) []*jsonAnnotation {
+	toReturn := make([]*jsonAnnotation, len(annotations))
+	for i, a := range annotations {
+		toReturn[i] = c.jsonAnnotation(a)
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a type annotation.
+func (c *Class) jsonTypeAnnotation(a TypeAnnotation) jsonTypeAnnotation {
+	toReturn := jsonTypeAnnotation{
+		TargetType: uint8(a.Target()),
+		TypePath:   make([]jsonTypePathElement, len(a.TypePath())),
+		Type:       c.jsonIndex(a.TypeIndex()),
+		Elements:   c.jsonElementValuePairs(a.ElementValuePairs()),
+	}
+	for i, element := range a.TypePath() {
+		toReturn.TypePath[i].Kind = element.TypePathKind
+		toReturn.TypePath[i].ArgumentIndex = element.TypeArgumentIndex
+	}
+	info := &toReturn.TargetInfo
+	switch v := a.(type) {
+	case *SingleFieldTypeAnnotation:
+		info.Index = &v.Data
+	case *TypeParameterBoundAnnotation:
+		info.TypeParameter = &v.TypeParameterIndex
+		info.Bound = &v.BoundIndex
+	case *TypeArgumentAnnotation:
+		info.Offset = &v.Offset
+		info.TypeArgument = &v.TypeArgumentIndex
+	case *LocalVariableTypeAnnotation:
+		info.Table = make([]jsonLocalVariableTarget, len(v.Table))
+		for i, entry := range v.Table {
+			info.Table[i] = jsonLocalVariableTarget(entry)
+		}
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a Code attribute.
+func (c *Class) jsonCode(a *Attribute) (*jsonCode, error) {
+	code, e := ParseCodeAttribute(a, c)
+	if e != nil {
+		return nil, e
+	}
+	toReturn := &jsonCode{
+		MaxStack:   code.MaxStack,
+		MaxLocals:  code.MaxLocals,
+		Code:       code.Code,
+		Attributes: c.jsonAttributes(code.Attributes),
+	}
+	toReturn.ExceptionTable = make([]jsonExceptionTableEntry,
+		len(code.ExceptionTable))
+	for i, entry := range code.ExceptionTable {
+		toReturn.ExceptionTable[i] = jsonExceptionTableEntry{
+			StartPC:   entry.StartPC,
+			EndPC:     entry.EndPC,
+			HandlerPC: entry.HandlerPC,
+			CatchType: c.jsonOptionalIndex(entry.CatchType),
+		}
+	}
+	return toReturn, nil
+}
+
+// Returns the parsed contents of an attribute, for the JSON output. Returns
+// nil if the attribute isn't recognized.
+func (c *Class) jsonAttributeValue(a *Attribute) (interface{}, error) {
+	switch string(a.Name) {
+	case "Code":
+		return c.jsonCode(a)
+	case "ConstantValue":
+		if len(a.Info) != 2 {
+			return nil, fmt.Errorf("Constant value attributes must be 2 " +
+				"bytes")
+		}
+		return c.jsonIndex(readUint16BigEndian(a.Info)), nil
+	case "StackMapTable":
+		frames, e := ParseStackMapTableAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonStackMapFrame, len(frames))
+		for i, f := range frames {
+			toReturn[i] = c.jsonStackMapFrame(f)
+		}
+		return toReturn, nil
+	case "Exceptions":
+		indices, e := ParseExceptionsAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndices(indices), nil
+	case "SourceFile":
+		index, e := ParseSourceFileAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndex(index), nil
+	case "Signature":
+		index, e := ParseSignatureAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndex(index), nil
+	case "LineNumberTable":
+		entries, e := ParseLineNumberTableAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonLineNumber, len(entries))
+		for i, entry := range entries {
+			toReturn[i] = jsonLineNumber(entry)
+		}
+		return toReturn, nil
+	case "LocalVariableTable":
+		entries, e := ParseLocalVariableTableAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonLocalVariable, len(entries))
+		for i, entry := range entries {
+			toReturn[i] = jsonLocalVariable{
+				StartPC:    entry.StartPC,
+				Length:     entry.Length,
+				Name:       c.jsonIndex(entry.NameIndex),
+				Descriptor: c.jsonOptionalIndex(entry.DescriptorIndex),
+				Index:      entry.Index,
+			}
+		}
+		return toReturn, nil
+	case "LocalVariableTypeTable":
+		entries, e := ParseLocalVariableTypeTableAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonLocalVariable, len(entries))
+		for i, entry := range entries {
+			toReturn[i] = jsonLocalVariable{
+				StartPC:   entry.StartPC,
+				Length:    entry.Length,
+				Name:      c.jsonIndex(entry.NameIndex),
+				Signature: c.jsonOptionalIndex(entry.SignatureIndex),
+				Index:     entry.Index,
+			}
+		}
+		return toReturn, nil
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		annotations, e := ParseRuntimeAnnotationsAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonAnnotations(annotations), nil
+	case "RuntimeVisibleParameterAnnotations",
+		"RuntimeInvisibleParameterAnnotations":
+		parameters, e := ParseParameterAnnotationsAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([][]*jsonAnnotation, len(parameters))
+		for i, annotations := range parameters {
+			toReturn[i] = c.jsonAnnotations(annotations)
+		}
+		return toReturn, nil
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		annotations, e := ParseTypeAnnotationsAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonTypeAnnotation, len(annotations))
+		for i, annotation := range annotations {
+			toReturn[i] = c.jsonTypeAnnotation(annotation)
+		}
+		return toReturn, nil
+	case "AnnotationDefault":
+		value, e := ParseAnnotationDefaultAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonElementValue(value), nil
+	case "InnerClasses":
+		classes, e := ParseInnerClassesAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonInnerClass, len(classes))
+		for i, class := range classes {
+			toReturn[i] = jsonInnerClass{
+				InnerClass: c.jsonIndex(class.InnerClassInfoIndex),
+				OuterClass: c.jsonOptionalIndex(class.OuterClassInfoIndex),
+				InnerName:  c.jsonOptionalIndex(class.InnerNameIndex),
+				AccessFlags: newJSONFlags(
+					uint16(class.InnerClassAccessFlags),
+					class.InnerClassAccessFlags.String()),
+			}
+		}
+		return toReturn, nil
+	case "EnclosingMethod":
+		classIndex, methodIndex, e := ParseEnclosingMethodAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return &jsonEnclosingMethod{
+			Class:  c.jsonIndex(classIndex),
+			Method: c.jsonOptionalIndex(methodIndex),
+		}, nil
+	case "BootstrapMethods":
+		methods, e := ParseBootstrapMethodsAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonBootstrapMethod, len(methods))
+		for i, m := range methods {
+			toReturn[i].MethodHandle = c.jsonIndex(m.Reference)
+			toReturn[i].Arguments = make([]jsonIndex, len(m.Arguments))
+			for j, argument := range m.Arguments {
+				toReturn[i].Arguments[j] = c.jsonIndex(argument)
+			}
+		}
+		return toReturn, nil
+	case "MethodParameters":
+		parameters, e := ParseMethodParametersAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonMethodParameter, len(parameters))
+		for i, parameter := range parameters {
+			flags := MethodAccessFlags(parameter.AccessFlags)
+			toReturn[i] = jsonMethodParameter{
+				Name: c.jsonOptionalIndex(parameter.NameIndex),
+				AccessFlags: newJSONFlags(parameter.AccessFlags,
+					flags.String()),
+			}
+		}
+		return toReturn, nil
+	case "Module":
+		module, e := ParseModuleAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonModule(module), nil
+	case "ModulePackages":
+		indices, e := ParseModulePackagesAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndices(indices), nil
+	case "ModuleMainClass":
+		index, e := ParseModuleMainClassAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndex(index), nil
+	case "NestHost":
+		index, e := ParseNestHostAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndex(index), nil
+	case "NestMembers":
+		indices, e := ParseNestMembersAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndices(indices), nil
+	case "PermittedSubclasses":
+		indices, e := ParsePermittedSubclassesAttribute(a)
+		if e != nil {
+			return nil, e
+		}
+		return c.jsonIndices(indices), nil
+	case "Record":
+		components, e := ParseRecordAttribute(a, c)
+		if e != nil {
+			return nil, e
+		}
+		toReturn := make([]jsonRecordComponent, len(components))
+		for i, component := range components {
+			toReturn[i] = jsonRecordComponent{
+				Name:       c.jsonIndex(component.NameIndex),
+				Descriptor: c.jsonIndex(component.DescriptorIndex),
+				Attributes: c.jsonAttributes(component.Attributes),
+			}
+		}
+		return toReturn, nil
+	}
+	return nil, nil
+}
+
+// Returns the JSON representation of a Module attribute.
+func (c *Class) jsonModule(m *ModuleAttribute) *jsonModule {
+	toReturn := &jsonModule{
+		Name:     c.jsonIndex(m.NameIndex),
+		Flags:    m.Flags,
+		Version:  c.jsonOptionalIndex(m.VersionIndex),
+		Requires: make([]jsonModuleRequires, len(m.Requires)),
+		Exports:  make([]jsonModuleExports, len(m.Exports)),
+		Opens:    make([]jsonModuleExports, len(m.Opens)),
+		Uses:     c.jsonIndices(m.Uses),
+		Provides: make([]jsonModuleProvides, len(m.Provides)),
+	}
+	for i, r := range m.Requires {
+		toReturn.Requires[i] = jsonModuleRequires{
+			Module:  c.jsonIndex(r.RequiresIndex),
+			Flags:   r.RequiresFlags,
+			Version: c.jsonOptionalIndex(r.RequiresVersionIndex),
+		}
+	}
+	for i, export := range m.Exports {
+		toReturn.Exports[i] = jsonModuleExports{
+			Package: c.jsonIndex(export.Index),
+			Flags:   export.Flags,
+			To:      c.jsonIndices(export.ToIndices),
+		}
+	}
+	for i, opens := range m.Opens {
+		toReturn.Opens[i] = jsonModuleExports{
+			Package: c.jsonIndex(opens.Index),
+			Flags:   opens.Flags,
+			To:      c.jsonIndices(opens.ToIndices),
+		}
+	}
+	for i, p := range m.Provides {
+		toReturn.Provides[i] = jsonModuleProvides{
+			Service: c.jsonIndex(p.Index),
+			With:    c.jsonIndices(p.WithIndices),
+		}
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a list of attributes.
+func (c *Class) jsonAttributes(attributes []*Attribute) []*jsonAttribute {
+	toReturn := make([]*jsonAttribute, len(attributes))
+	for i, a := range attributes {
+		value, e := c.jsonAttributeValue(a)
+		converted := &jsonAttribute{
+			Name: jsonIndex{
+				Index: a.NameIndex,
+				Value: string(a.Name),
+			},
+			Length: len(a.Info),
+			Value:  value,
+		}
+		if e != nil {
+			converted.Error = e.Error()
+		}
+		// Include the raw bytes if we couldn't parse the attribute.
+		if (value == nil) || (e != nil) {
+			converted.Value = nil
+			converted.Info = a.Info
+		}
+		toReturn[i] = converted
+	}
+	return toReturn
+}
+
+// Returns the JSON representation of a field or method.
+func (c *Class) jsonMember(access uint16, flags string, nameIndex,
+	descriptorIndex uint16, attributes []*Attribute) jsonMember {
+	return jsonMember{
+		AccessFlags: newJSONFlags(access, flags),
+		Name:        c.jsonIndex(nameIndex),
+		Descriptor:  c.jsonIndex(descriptorIndex),
+		Attributes:  c.jsonAttributes(attributes),
+	}
+}
+
+// Encodes the class as JSON, including its constants, fields, methods, and
+// parsed attributes. References to constants are encoded as objects containing
+// the index and the resolved name or value. Attributes that can't be parsed
+// are encoded using their raw bytes, along with the error, rather than causing
+// the entire encoding to fail. Characters such as '<' in names like <init> are
+// not escaped, so callers that re-encode the output should also disable HTML
+// escaping.
+func (c *Class) MarshalJSON() ([]byte, error) {
+	toEncode := jsonClass{
+		MinorVersion: c.MinorVersion,
+		MajorVersion: c.MajorVersion,
+		AccessFlags:  newJSONFlags(uint16(c.Access), c.Access.String()),
+		ThisClass:    c.jsonIndex(c.ThisClass),
+		SuperClass:   c.jsonOptionalIndex(c.SuperClass),
+		Interfaces:   make([]jsonIndex, len(c.Interfaces)),
+		Constants:    make([]jsonConstant, 0, len(c.Constants)),
+		Fields:       make([]jsonMember, len(c.Fields)),
+		Methods:      make([]jsonMember, len(c.Methods)),
+		Attributes:   c.jsonAttributes(c.Attributes),
+	}
+	for i, index := range c.Interfaces {
+		toEncode.Interfaces[i] = c.jsonIndex(index)
+	}
+	for i, constant := range c.Constants {
+		// Skip the unused entry 0 and the entries following longs and
+		// doubles.
+		if (i == 0) || (constant == nil) {
+			continue
+		}
+		toEncode.Constants = append(toEncode.Constants,
+			c.jsonConstant(uint16(i), constant))
+	}
+	for i, f := range c.Fields {
+		toEncode.Fields[i] = c.jsonMember(uint16(f.Access), f.Access.String(),
+			f.NameIndex, f.DescriptorIndex, f.Attributes)
+	}
+	for i, m := range c.Methods {
+		toEncode.Methods[i] = c.jsonMember(uint16(m.Access),
+			m.Access.String(), m.NameIndex, m.DescriptorIndex, m.Attributes)
+	}
+	var toReturn bytes.Buffer
+	encoder := json.NewEncoder(&toReturn)
+	encoder.SetEscapeHTML(false)
+	e := encoder.Encode(&toEncode)
+	if e != nil {
+		return nil, e
+	}
+	// Unlike json.Marshal, the Encoder appends a newline.
+	return bytes.TrimSuffix(toReturn.Bytes(), []byte("\n")), nil
+}
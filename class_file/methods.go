@@ -55,12 +55,37 @@ func (f MethodAccessFlags) String() string {
 	return strings.TrimRight(toReturn, " ")
 }
 
+// Returns true if the access flags indicate that the method is static.
+func (f MethodAccessFlags) IsStatic() bool {
+	return (f & 0x0008) != 0
+}
+
+// Returns true if the access flags indicate that the method is synchronized.
+func (f MethodAccessFlags) IsSynchronized() bool {
+	return (f & 0x0020) != 0
+}
+
+// Returns true if the access flags indicate that the method is native.
+func (f MethodAccessFlags) IsNative() bool {
+	return (f & 0x0100) != 0
+}
+
+// Returns true if the access flags indicate that the method is abstract.
+func (f MethodAccessFlags) IsAbstract() bool {
+	return (f & 0x0400) != 0
+}
+
 // Contains information about a single method in the class file.
 type Method struct {
 	// Access permissions and properties, e.g. "public static"
 	Access MethodAccessFlags
 	// The UTF-8 string containing the method's name
 	Name []byte
+	// The indices of the UTF-8 constants containing the method's name and
+	// descriptor. When the class is written, these are only used if they
+	// still refer to constants matching Name and Descriptor.
+	NameIndex       uint16
+	DescriptorIndex uint16
 	// Contains information about the method's arguments and return type.
 	Descriptor *MethodDescriptor
 	// A table of attributes for this specific method
@@ -73,10 +98,10 @@ func (m *Method) String() string {
 		len(m.Attributes))
 }
 
-// Returns this method's code attribute, which must exist by the JVM spec.
-// Returns an error if one occurs. This will scan the method's attributes table
-// and parse the Code attribute, so this shouldn't be called frequently to
-// preserve performance.
+// Returns this method's code attribute, which must exist by the JVM spec
+// unless the method is native or abstract. Returns an error if one occurs.
+// This will scan the method's attributes table and parse the Code attribute,
+// so this shouldn't be called frequently to preserve performance.
 func (m *Method) GetCodeAttribute(class *Class) (*CodeAttribute, error) {
 	found := false
 	var e error
@@ -110,6 +135,7 @@ func (c *Class) parseSingleMethod(data io.Reader) (*Method, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading method name index: %s", e)
 	}
+	toReturn.NameIndex = index
 	toReturn.Name, e = c.GetUTF8Constant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Invalid method name: %s", e)
@@ -118,6 +144,7 @@ func (c *Class) parseSingleMethod(data io.Reader) (*Method, error) {
 	if e != nil {
 		return nil, fmt.Errorf("Failed reading method descriptor index: %s", e)
 	}
+	toReturn.DescriptorIndex = index
 	descriptorBytes, e := c.GetUTF8Constant(index)
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't get method descriptor string: %s", e)
@@ -156,3 +183,41 @@ func (c *Class) parseMethodTable(data io.Reader, count uint16) ([]*Method,
 	}
 	return methods, nil
 }
+
+// Writes a single method structure. This is the inverse of parseSingleMethod.
+func (c *Class) writeSingleMethod(data io.Writer, m *Method) error {
+	nameIndex, e := c.getUTF8ConstantIndex(m.NameIndex, m.Name)
+	if e != nil {
+		return fmt.Errorf("Invalid name for method %s: %w", m.Name, e)
+	}
+	descriptorIndex, e := c.getUTF8ConstantIndex(m.DescriptorIndex,
+		[]byte(m.Descriptor.Descriptor()))
+	if e != nil {
+		return fmt.Errorf("Invalid descriptor for method %s: %w", m.Name, e)
+	}
+	binary.Write(data, binary.BigEndian, m.Access)
+	binary.Write(data, binary.BigEndian, nameIndex)
+	binary.Write(data, binary.BigEndian, descriptorIndex)
+	e = c.writeAttributesTable(data, m.Attributes)
+	if e != nil {
+		return fmt.Errorf("Failed writing attributes for method %s: %w",
+			m.Name, e)
+	}
+	return nil
+}
+
+// Writes the count of methods in the class followed by the methods
+// themselves.
+func (c *Class) writeMethodTable(data io.Writer) error {
+	e := writeCount(data, len(c.Methods), "methods")
+	if e != nil {
+		return e
+	}
+	for _, m := range c.Methods {
+		e = c.writeSingleMethod(data, m)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
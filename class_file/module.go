@@ -0,0 +1,275 @@
+package class_file
+
+// This file contains definitions used when parsing the attributes found in
+// module-info class files: Module, ModulePackages, and ModuleMainClass.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A module required by a module, from a Module attribute.
+type ModuleRequires struct {
+	// The index of the required module's ConstantModuleInfo.
+	RequiresIndex uint16
+	// Flags such as ACC_TRANSITIVE (0x0020) and ACC_STATIC_PHASE (0x0040).
+	RequiresFlags uint16
+	// The index of a UTF-8 version string, or 0 if there's no version.
+	RequiresVersionIndex uint16
+}
+
+// A package exported or opened by a module, from a Module attribute.
+type ModuleExports struct {
+	// The index of the package's ConstantPackageInfo.
+	Index uint16
+	// Flags such as ACC_SYNTHETIC (0x1000) and ACC_MANDATED (0x8000).
+	Flags uint16
+	// Indices of the ConstantModuleInfo entries for the modules the package
+	// is exported or opened to. If this is empty, the package is exported or
+	// opened to every module.
+	ToIndices []uint16
+}
+
+// A service provided by a module, from a Module attribute.
+type ModuleProvides struct {
+	// The index of the service interface's ConstantClassInfo.
+	Index uint16
+	// Indices of the ConstantClassInfo entries for the implementations.
+	WithIndices []uint16
+}
+
+// Contains parsed data from a Module attribute.
+type ModuleAttribute struct {
+	// The index of the module's ConstantModuleInfo.
+	NameIndex uint16
+	// Flags such as ACC_OPEN (0x0020).
+	Flags uint16
+	// The index of a UTF-8 version string, or 0 if there's no version.
+	VersionIndex uint16
+	Requires     []ModuleRequires
+	Exports      []ModuleExports
+	Opens        []ModuleExports
+	// Indices of the ConstantClassInfo entries for the services the module
+	// uses.
+	Uses     []uint16
+	Provides []ModuleProvides
+}
+
+// Reads a count followed by that many constant indices.
+func readIndexList(data io.Reader) ([]uint16, error) {
+	var count uint16
+	e := binary.Read(data, binary.BigEndian, &count)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading count: %s", e)
+	}
+	toReturn := make([]uint16, count)
+	e = binary.Read(data, binary.BigEndian, toReturn)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading indices: %s", e)
+	}
+	return toReturn, nil
+}
+
+// Writes a count followed by the constant indices. This is the inverse of
+// readIndexList.
+func writeIndexList(data io.Writer, indices []uint16, name string) error {
+	e := writeCount(data, len(indices), name)
+	if e != nil {
+		return e
+	}
+	return binary.Write(data, binary.BigEndian, indices)
+}
+
+// Reads the exports or opens table of a Module attribute.
+func readModuleExports(data io.Reader) ([]ModuleExports, error) {
+	var count uint16
+	e := binary.Read(data, binary.BigEndian, &count)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading count: %s", e)
+	}
+	toReturn := make([]ModuleExports, count)
+	for i := range toReturn {
+		e = binary.Read(data, binary.BigEndian, &(toReturn[i].Index))
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading package index: %s", e)
+		}
+		e = binary.Read(data, binary.BigEndian, &(toReturn[i].Flags))
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading flags: %s", e)
+		}
+		toReturn[i].ToIndices, e = readIndexList(data)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading target modules: %w", e)
+		}
+	}
+	return toReturn, nil
+}
+
+// Writes the exports or opens table of a Module attribute. This is the inverse
+// of readModuleExports.
+func writeModuleExports(data io.Writer, exports []ModuleExports,
+	name string) error {
+	e := writeCount(data, len(exports), name)
+	if e != nil {
+		return e
+	}
+	for _, export := range exports {
+		binary.Write(data, binary.BigEndian, export.Index)
+		binary.Write(data, binary.BigEndian, export.Flags)
+		e = writeIndexList(data, export.ToIndices, name+" targets")
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Parses a Module attribute.
+func ParseModuleAttribute(a *Attribute) (*ModuleAttribute, error) {
+	if string(a.Name) != "Module" {
+		return nil, fmt.Errorf("Expected a Module attribute")
+	}
+	var toReturn ModuleAttribute
+	data := bytes.NewReader(a.Info)
+	header := []uint16{0, 0, 0}
+	e := binary.Read(data, binary.BigEndian, header)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading module name and flags: %s", e)
+	}
+	toReturn.NameIndex = header[0]
+	toReturn.Flags = header[1]
+	toReturn.VersionIndex = header[2]
+	var count uint16
+	e = binary.Read(data, binary.BigEndian, &count)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading number of requires: %s", e)
+	}
+	toReturn.Requires = make([]ModuleRequires, count)
+	e = binary.Read(data, binary.BigEndian, toReturn.Requires)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading requires table: %s", e)
+	}
+	toReturn.Exports, e = readModuleExports(data)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading exports table: %w", e)
+	}
+	toReturn.Opens, e = readModuleExports(data)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading opens table: %w", e)
+	}
+	toReturn.Uses, e = readIndexList(data)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading uses table: %w", e)
+	}
+	e = binary.Read(data, binary.BigEndian, &count)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading number of provides: %s", e)
+	}
+	toReturn.Provides = make([]ModuleProvides, count)
+	for i := range toReturn.Provides {
+		e = binary.Read(data, binary.BigEndian, &(toReturn.Provides[i].Index))
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading service index: %s", e)
+		}
+		toReturn.Provides[i].WithIndices, e = readIndexList(data)
+		if e != nil {
+			return nil, fmt.Errorf("Failed reading service "+
+				"implementations: %w", e)
+		}
+	}
+	return &toReturn, nil
+}
+
+// Returns a Module attribute containing the given information. This is the
+// inverse of ParseModuleAttribute.
+func EncodeModuleAttribute(m *ModuleAttribute) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	binary.Write(data, binary.BigEndian, m.NameIndex)
+	binary.Write(data, binary.BigEndian, m.Flags)
+	binary.Write(data, binary.BigEndian, m.VersionIndex)
+	e := writeCount(data, len(m.Requires), "requires")
+	if e != nil {
+		return nil, e
+	}
+	binary.Write(data, binary.BigEndian, m.Requires)
+	e = writeModuleExports(data, m.Exports, "exports")
+	if e != nil {
+		return nil, e
+	}
+	e = writeModuleExports(data, m.Opens, "opens")
+	if e != nil {
+		return nil, e
+	}
+	e = writeIndexList(data, m.Uses, "uses")
+	if e != nil {
+		return nil, e
+	}
+	e = writeCount(data, len(m.Provides), "provides")
+	if e != nil {
+		return nil, e
+	}
+	for _, p := range m.Provides {
+		binary.Write(data, binary.BigEndian, p.Index)
+		e = writeIndexList(data, p.WithIndices, "service implementations")
+		if e != nil {
+			return nil, e
+		}
+	}
+	return &Attribute{
+		Name: []byte("Module"),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Parses a ModulePackages attribute, returning the indices of the
+// ConstantPackageInfo entries for the module's packages.
+func ParseModulePackagesAttribute(a *Attribute) ([]uint16, error) {
+	if string(a.Name) != "ModulePackages" {
+		return nil, fmt.Errorf("Expected a ModulePackages attribute")
+	}
+	toReturn, e := readIndexList(bytes.NewReader(a.Info))
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading module packages: %w", e)
+	}
+	return toReturn, nil
+}
+
+// Returns a ModulePackages attribute containing the given package indices.
+// This is the inverse of ParseModulePackagesAttribute.
+func EncodeModulePackagesAttribute(indices []uint16) (*Attribute, error) {
+	data := &bytes.Buffer{}
+	e := writeIndexList(data, indices, "module packages")
+	if e != nil {
+		return nil, e
+	}
+	return &Attribute{
+		Name: []byte("ModulePackages"),
+		Info: data.Bytes(),
+	}, nil
+}
+
+// Returns the index of the main class' ConstantClassInfo, contained in a
+// ModuleMainClass attribute.
+func ParseModuleMainClassAttribute(a *Attribute) (uint16, error) {
+	if string(a.Name) != "ModuleMainClass" {
+		return 0, fmt.Errorf("Expected a ModuleMainClass attribute")
+	}
+	var toReturn uint16
+	data := bytes.NewReader(a.Info)
+	e := binary.Read(data, binary.BigEndian, &toReturn)
+	if e != nil {
+		return 0, fmt.Errorf("Failed reading main class index: %s", e)
+	}
+	return toReturn, nil
+}
+
+// Returns a ModuleMainClass attribute containing the given class index. This
+// is the inverse of ParseModuleMainClassAttribute.
+func EncodeModuleMainClassAttribute(index uint16) *Attribute {
+	return &Attribute{
+		Name: []byte("ModuleMainClass"),
+		Info: []byte{byte(index >> 8), byte(index)},
+	}
+}
@@ -0,0 +1,559 @@
+package class_file
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains code for parsing the generic signatures found in
+// Signature attributes, following the grammar in section 4.7.9.1 of the JVM
+// spec. Signatures are parsed into FieldTypes, like descriptors, but may
+// contain type variables and type arguments in addition to the types that can
+// appear in descriptors.
+
+// This is used to represent a type variable, e.g. "T", read from a signature.
+// Implements the FieldType interface.
+type TypeVariableSignature string
+
+func (t TypeVariableSignature) String() string {
+	return string(t)
+}
+
+// Holds a single type argument in a class type signature.
+type TypeArgument struct {
+	// This is '+' for "? extends Type", '-' for "? super Type", '*' for an
+	// unbounded "?", or 0 if the argument is exactly Type.
+	Wildcard byte
+	// The argument's type. This is nil if Wildcard is '*'.
+	Type FieldType
+}
+
+func (a *TypeArgument) String() string {
+	switch a.Wildcard {
+	case '*':
+		return "?"
+	case '+':
+		return "? extends " + a.Type.String()
+	case '-':
+		return "? super " + a.Type.String()
+	}
+	return a.Type.String()
+}
+
+// Holds the name and type arguments of a single class in a class type
+// signature.
+type SimpleClassTypeSignature struct {
+	// The class' name. For the outermost class, this includes the package,
+	// e.g. "java/util/Map". For inner classes, this is only the inner class'
+	// name, e.g. "Entry".
+	Name          string
+	TypeArguments []*TypeArgument
+}
+
+func (s *SimpleClassTypeSignature) String() string {
+	if len(s.TypeArguments) == 0 {
+		return s.Name
+	}
+	arguments := make([]string, len(s.TypeArguments))
+	for i, a := range s.TypeArguments {
+		arguments[i] = a.String()
+	}
+	return s.Name + "<" + strings.Join(arguments, ", ") + ">"
+}
+
+// This is used to represent a reference to an instance of a possibly-generic
+// class, read from a signature. Implements the FieldType interface.
+type ClassTypeSignature struct {
+	// The first entry is the outermost class. Any subsequent entries are
+	// inner classes, each nested in the one before it. For example,
+	// "Ljava/util/Map<TK;TV;>.Entry<TK;TV;>;" contains entries for
+	// java/util/Map and Entry.
+	Classes []*SimpleClassTypeSignature
+}
+
+func (t *ClassTypeSignature) String() string {
+	names := make([]string, len(t.Classes))
+	for i, c := range t.Classes {
+		names[i] = c.String()
+	}
+	return strings.Join(names, ".")
+}
+
+// Holds a type parameter declared by a generic class or method.
+type TypeParameter struct {
+	Name string
+	// The type parameter's class bound. May be nil if the parameter only has
+	// interface bounds.
+	ClassBound      FieldType
+	InterfaceBounds []FieldType
+}
+
+func (p *TypeParameter) String() string {
+	bounds := make([]string, 0, len(p.InterfaceBounds)+1)
+	if p.ClassBound != nil {
+		bounds = append(bounds, p.ClassBound.String())
+	}
+	for _, t := range p.InterfaceBounds {
+		bounds = append(bounds, t.String())
+	}
+	if len(bounds) == 0 {
+		return p.Name
+	}
+	return p.Name + " extends " + strings.Join(bounds, " & ")
+}
+
+// Returns the type parameters as a comma-separated list in angle brackets,
+// e.g. "<K, V extends java/lang/Object>". Returns an empty string if there are
+// no type parameters.
+func TypeParametersString(parameters []*TypeParameter) string {
+	if len(parameters) == 0 {
+		return ""
+	}
+	names := make([]string, len(parameters))
+	for i, p := range parameters {
+		names[i] = p.String()
+	}
+	return "<" + strings.Join(names, ", ") + ">"
+}
+
+// Holds the parsed contents of a class' generic signature.
+type ClassSignature struct {
+	TypeParameters []*TypeParameter
+	Superclass     *ClassTypeSignature
+	Interfaces     []*ClassTypeSignature
+}
+
+// Holds the parsed contents of a method's generic signature.
+type MethodSignature struct {
+	TypeParameters []*TypeParameter
+	ArgumentTypes  []FieldType
+	ReturnType     FieldType
+	// The exceptions thrown by the method. Each is either a
+	// *ClassTypeSignature or a TypeVariableSignature. May be empty even if the
+	// method throws exceptions, in which case they're listed in the
+	// method's Exceptions attribute.
+	Throws []FieldType
+}
+
+// Returns a list of the argument types, as a comma-separated string.
+func (s *MethodSignature) ArgumentsString() string {
+	arguments := make([]string, len(s.ArgumentTypes))
+	for i, t := range s.ArgumentTypes {
+		arguments[i] = t.String()
+	}
+	return strings.Join(arguments, ", ")
+}
+
+// Returns the method's return type, as a string.
+func (s *MethodSignature) ReturnString() string {
+	return s.ReturnType.String()
+}
+
+// Returns the signature string for the given type, e.g. "TT;" or
+// "Ljava/util/List<*>;". Unlike FieldTypeDescriptor, this supports the types
+// that can only appear in signatures. This is the inverse of
+// ParseFieldSignature for reference types.
+func FieldTypeSignature(t FieldType) string {
+	switch v := t.(type) {
+	case TypeVariableSignature:
+		return "T" + string(v) + ";"
+	case *ClassTypeSignature:
+		names := make([]string, len(v.Classes))
+		for i, c := range v.Classes {
+			names[i] = c.signature()
+		}
+		return "L" + strings.Join(names, ".") + ";"
+	case *ArrayType:
+		return strings.Repeat("[", int(v.Dimensions)) +
+			FieldTypeSignature(v.ContentType)
+	}
+	return FieldTypeDescriptor(t)
+}
+
+// Returns the part of a class type signature for a single class.
+func (s *SimpleClassTypeSignature) signature() string {
+	if len(s.TypeArguments) == 0 {
+		return s.Name
+	}
+	toReturn := s.Name + "<"
+	for _, a := range s.TypeArguments {
+		switch a.Wildcard {
+		case '*':
+			toReturn += "*"
+			continue
+		case '+', '-':
+			toReturn += string([]byte{a.Wildcard})
+		}
+		toReturn += FieldTypeSignature(a.Type)
+	}
+	return toReturn + ">"
+}
+
+// Returns the signature string for the type parameters, or an empty string if
+// there are none.
+func typeParametersSignature(parameters []*TypeParameter) string {
+	if len(parameters) == 0 {
+		return ""
+	}
+	toReturn := "<"
+	for _, p := range parameters {
+		toReturn += p.Name + ":"
+		if p.ClassBound != nil {
+			toReturn += FieldTypeSignature(p.ClassBound)
+		}
+		for _, t := range p.InterfaceBounds {
+			toReturn += ":" + FieldTypeSignature(t)
+		}
+	}
+	return toReturn + ">"
+}
+
+// Returns the class signature string. This is the inverse of
+// ParseClassSignature.
+func (s *ClassSignature) Signature() string {
+	toReturn := typeParametersSignature(s.TypeParameters) +
+		FieldTypeSignature(s.Superclass)
+	for _, t := range s.Interfaces {
+		toReturn += FieldTypeSignature(t)
+	}
+	return toReturn
+}
+
+// Returns the method signature string. This is the inverse of
+// ParseMethodSignature.
+func (s *MethodSignature) Signature() string {
+	toReturn := typeParametersSignature(s.TypeParameters) + "("
+	for _, t := range s.ArgumentTypes {
+		toReturn += FieldTypeSignature(t)
+	}
+	toReturn += ")" + FieldTypeSignature(s.ReturnType)
+	for _, t := range s.Throws {
+		toReturn += "^" + FieldTypeSignature(t)
+	}
+	return toReturn
+}
+
+// Parses an identifier at the start of the signature, returning it and the
+// remaining bytes. If allowSlashes is true, the identifier may be a class
+// name that includes its package, e.g. "java/lang/Object".
+func parseSignatureIdentifier(signature []byte, allowSlashes bool) (string,
+	[]byte, error) {
+	i := 0
+	for ; i < len(signature); i++ {
+		c := signature[i]
+		if (c == '/') && allowSlashes {
+			continue
+		}
+		if (c == '.') || (c == ';') || (c == '[') || (c == '/') ||
+			(c == '<') || (c == '>') || (c == ':') {
+			break
+		}
+	}
+	if i == 0 {
+		return "", nil, fmt.Errorf("Missing identifier in signature: %s",
+			signature)
+	}
+	return string(signature[:i]), signature[i:], nil
+}
+
+// Parses the type arguments of a class type signature, starting with the
+// opening '<'. Returns the arguments and the remaining bytes.
+func parseTypeArguments(signature []byte) ([]*TypeArgument, []byte,
+	error) {
+	signature = signature[1:]
+	toReturn := make([]*TypeArgument, 0, 2)
+	for {
+		if len(signature) == 0 {
+			return nil, nil, fmt.Errorf("Missing \">\" after type arguments")
+		}
+		if signature[0] == '>' {
+			break
+		}
+		argument := &TypeArgument{}
+		switch signature[0] {
+		case '*':
+			argument.Wildcard = '*'
+			toReturn = append(toReturn, argument)
+			signature = signature[1:]
+			continue
+		case '+', '-':
+			argument.Wildcard = signature[0]
+			signature = signature[1:]
+		}
+		var e error
+		argument.Type, signature, e = parseReferenceTypeSignature(signature)
+		if e != nil {
+			return nil, nil, fmt.Errorf("Bad type argument: %w", e)
+		}
+		toReturn = append(toReturn, argument)
+	}
+	if len(toReturn) == 0 {
+		return nil, nil, fmt.Errorf("Empty type argument list")
+	}
+	return toReturn, signature[1:], nil
+}
+
+// Parses a class type signature, starting with the 'L'. Returns the type and
+// the remaining bytes.
+func parseClassTypeSignature(signature []byte) (*ClassTypeSignature, []byte,
+	error) {
+	if (len(signature) == 0) || (signature[0] != 'L') {
+		return nil, nil, fmt.Errorf("Invalid class type signature: %s",
+			signature)
+	}
+	signature = signature[1:]
+	toReturn := &ClassTypeSignature{}
+	var e error
+	for {
+		c := &SimpleClassTypeSignature{}
+		// Only the outermost class' name includes the package.
+		c.Name, signature, e = parseSignatureIdentifier(signature,
+			len(toReturn.Classes) == 0)
+		if e != nil {
+			return nil, nil, e
+		}
+		if (len(signature) != 0) && (signature[0] == '<') {
+			c.TypeArguments, signature, e = parseTypeArguments(signature)
+			if e != nil {
+				return nil, nil, fmt.Errorf("Bad type arguments for %s: %w",
+					c.Name, e)
+			}
+		}
+		toReturn.Classes = append(toReturn.Classes, c)
+		if len(signature) == 0 {
+			return nil, nil, fmt.Errorf("Missing \";\" after class type " +
+				"signature")
+		}
+		if signature[0] == ';' {
+			break
+		}
+		if signature[0] != '.' {
+			return nil, nil, fmt.Errorf("Invalid class type signature "+
+				"suffix: %s", signature)
+		}
+		signature = signature[1:]
+	}
+	return toReturn, signature[1:], nil
+}
+
+// Parses a type variable signature, starting with the 'T'. Returns the type
+// and the remaining bytes.
+func parseTypeVariableSignature(signature []byte) (FieldType, []byte,
+	error) {
+	name, signature, e := parseSignatureIdentifier(signature[1:], false)
+	if e != nil {
+		return nil, nil, e
+	}
+	if (len(signature) == 0) || (signature[0] != ';') {
+		return nil, nil, fmt.Errorf("Missing \";\" after type variable %s",
+			name)
+	}
+	return TypeVariableSignature(name), signature[1:], nil
+}
+
+// Parses an array type signature, starting with the first '['. Returns an
+// *ArrayType and the remaining bytes.
+func parseArrayTypeSignature(signature []byte) (FieldType, []byte, error) {
+	dimensions := 0
+	for (dimensions < len(signature)) && (signature[dimensions] == '[') {
+		dimensions++
+	}
+	if dimensions > 255 {
+		return nil, nil, fmt.Errorf(
+			"Too many dimensions in array signature: %d", dimensions)
+	}
+	t, remaining, e := parseJavaTypeSignature(signature[dimensions:], false)
+	if e != nil {
+		return nil, nil, e
+	}
+	toReturn := &ArrayType{
+		Dimensions:  uint8(dimensions),
+		ContentType: t,
+	}
+	return toReturn, remaining, nil
+}
+
+// Parses a class, type variable, or array type signature. Returns the type
+// and the remaining bytes.
+func parseReferenceTypeSignature(signature []byte) (FieldType, []byte,
+	error) {
+	if len(signature) == 0 {
+		return nil, nil, fmt.Errorf("Empty signature string")
+	}
+	switch signature[0] {
+	case 'L':
+		return parseClassTypeSignature(signature)
+	case 'T':
+		return parseTypeVariableSignature(signature)
+	case '[':
+		return parseArrayTypeSignature(signature)
+	}
+	return nil, nil, fmt.Errorf("Invalid reference type signature: %s",
+		signature)
+}
+
+// Parses any type signature, including primitive types. Returns the type and
+// the remaining bytes.
+func parseJavaTypeSignature(signature []byte, allowVoid bool) (FieldType,
+	[]byte, error) {
+	if len(signature) == 0 {
+		return nil, nil, fmt.Errorf("Empty signature string")
+	}
+	switch signature[0] {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		return PrimitiveFieldType(signature[0]), signature[1:], nil
+	case 'V':
+		if !allowVoid {
+			return nil, nil, fmt.Errorf("A void type signature is not " +
+				"allowed here")
+		}
+		return PrimitiveFieldType('V'), signature[1:], nil
+	}
+	return parseReferenceTypeSignature(signature)
+}
+
+// Parses the type parameters at the start of a class or method signature,
+// if there are any. Returns the parameters and the remaining bytes.
+func parseTypeParameters(signature []byte) ([]*TypeParameter, []byte,
+	error) {
+	if (len(signature) == 0) || (signature[0] != '<') {
+		return nil, signature, nil
+	}
+	signature = signature[1:]
+	toReturn := make([]*TypeParameter, 0, 2)
+	var e error
+	for {
+		if len(signature) == 0 {
+			return nil, nil, fmt.Errorf("Missing \">\" after type parameters")
+		}
+		if signature[0] == '>' {
+			break
+		}
+		p := &TypeParameter{}
+		p.Name, signature, e = parseSignatureIdentifier(signature, false)
+		if e != nil {
+			return nil, nil, fmt.Errorf("Bad type parameter name: %w", e)
+		}
+		if (len(signature) == 0) || (signature[0] != ':') {
+			return nil, nil, fmt.Errorf("Missing class bound for type "+
+				"parameter %s", p.Name)
+		}
+		signature = signature[1:]
+		// The class bound may be empty, for example if the type parameter is
+		// only bounded by interfaces.
+		if (len(signature) != 0) && (signature[0] != ':') &&
+			(signature[0] != '>') {
+			p.ClassBound, signature, e = parseReferenceTypeSignature(signature)
+			if e != nil {
+				return nil, nil, fmt.Errorf("Bad class bound for type "+
+					"parameter %s: %w", p.Name, e)
+			}
+		}
+		for (len(signature) != 0) && (signature[0] == ':') {
+			var bound FieldType
+			bound, signature, e = parseReferenceTypeSignature(signature[1:])
+			if e != nil {
+				return nil, nil, fmt.Errorf("Bad interface bound for type "+
+					"parameter %s: %w", p.Name, e)
+			}
+			p.InterfaceBounds = append(p.InterfaceBounds, bound)
+		}
+		toReturn = append(toReturn, p)
+	}
+	if len(toReturn) == 0 {
+		return nil, nil, fmt.Errorf("Empty type parameter list")
+	}
+	return toReturn, signature[1:], nil
+}
+
+// Parses a field's generic signature, returning the field's type. The type
+// will be a *ClassTypeSignature, TypeVariableSignature, or *ArrayType.
+func ParseFieldSignature(signature []byte) (FieldType, error) {
+	toReturn, remaining, e := parseReferenceTypeSignature(signature)
+	if e != nil {
+		return nil, e
+	}
+	if len(remaining) != 0 {
+		return nil, fmt.Errorf("Unexpected data after field signature: %s",
+			remaining)
+	}
+	return toReturn, nil
+}
+
+// Parses a class' generic signature, containing its type parameters,
+// superclass, and interfaces.
+func ParseClassSignature(signature []byte) (*ClassSignature, error) {
+	var toReturn ClassSignature
+	var e error
+	toReturn.TypeParameters, signature, e = parseTypeParameters(signature)
+	if e != nil {
+		return nil, e
+	}
+	toReturn.Superclass, signature, e = parseClassTypeSignature(signature)
+	if e != nil {
+		return nil, fmt.Errorf("Bad superclass signature: %w", e)
+	}
+	for len(signature) != 0 {
+		var t *ClassTypeSignature
+		t, signature, e = parseClassTypeSignature(signature)
+		if e != nil {
+			return nil, fmt.Errorf("Bad interface signature: %w", e)
+		}
+		toReturn.Interfaces = append(toReturn.Interfaces, t)
+	}
+	return &toReturn, nil
+}
+
+// Parses a method's generic signature, containing its type parameters,
+// argument types, return type, and thrown exceptions.
+func ParseMethodSignature(signature []byte) (*MethodSignature, error) {
+	var toReturn MethodSignature
+	var e error
+	toReturn.TypeParameters, signature, e = parseTypeParameters(signature)
+	if e != nil {
+		return nil, e
+	}
+	if (len(signature) == 0) || (signature[0] != '(') {
+		return nil, fmt.Errorf("Invalid method signature")
+	}
+	signature = signature[1:]
+	toReturn.ArgumentTypes = make([]FieldType, 0, 4)
+	for {
+		if len(signature) == 0 {
+			return nil, fmt.Errorf("Invalid method signature: missing \")\"")
+		}
+		if signature[0] == ')' {
+			signature = signature[1:]
+			break
+		}
+		var argument FieldType
+		argument, signature, e = parseJavaTypeSignature(signature, false)
+		if e != nil {
+			return nil, fmt.Errorf("Bad method argument type: %w", e)
+		}
+		toReturn.ArgumentTypes = append(toReturn.ArgumentTypes, argument)
+	}
+	toReturn.ReturnType, signature, e = parseJavaTypeSignature(signature,
+		true)
+	if e != nil {
+		return nil, fmt.Errorf("Bad method return type: %w", e)
+	}
+	for len(signature) != 0 {
+		if signature[0] != '^' {
+			return nil, fmt.Errorf("Unexpected data after method "+
+				"signature: %s", signature)
+		}
+		var thrown FieldType
+		signature = signature[1:]
+		if (len(signature) != 0) && (signature[0] == 'T') {
+			thrown, signature, e = parseTypeVariableSignature(signature)
+		} else {
+			thrown, signature, e = parseClassTypeSignature(signature)
+		}
+		if e != nil {
+			return nil, fmt.Errorf("Bad thrown exception type: %w", e)
+		}
+		toReturn.Throws = append(toReturn.Throws, thrown)
+	}
+	return &toReturn, nil
+}
@@ -100,6 +100,7 @@ func parseVerificationTypeInfo(data io.Reader) (VerificationTypeInfo, error) {
 		return toReturn, fmt.Errorf("Couldn't read verification type info: %s",
 			e)
 	}
+	toReturn.Tag = tag
 	toReturn.Other = other
 	return toReturn, nil
 }
@@ -190,6 +191,75 @@ type FullStackMapFrame struct {
 	Stack  []VerificationTypeInfo
 }
 
+// Returns a frame with the same locals as the previous frame and an empty
+// stack, using the "same" form if the offset delta is small enough, and the
+// "same frame extended" form otherwise.
+func NewSameFrame(offsetDelta uint16) StackMapFrame {
+	if offsetDelta <= 63 {
+		var toReturn SameStackMapFrame
+		toReturn.tag = StackMapFrameType(offsetDelta)
+		return &toReturn
+	}
+	var toReturn SameStackMapFrameExtended
+	toReturn.tag = 251
+	toReturn.offsetDelta = offsetDelta
+	return &toReturn
+}
+
+// Returns a frame with the same locals as the previous frame and a single
+// value on the stack, choosing the extended form if necessary.
+func NewOneItemFrame(offsetDelta uint16,
+	info VerificationTypeInfo) StackMapFrame {
+	if offsetDelta <= 63 {
+		var toReturn OneItemStackMapFrame
+		toReturn.tag = StackMapFrameType(offsetDelta + 64)
+		toReturn.Info = info
+		return &toReturn
+	}
+	var toReturn OneItemStackMapFrameExtended
+	toReturn.tag = 247
+	toReturn.offsetDelta = offsetDelta
+	toReturn.Info = info
+	return &toReturn
+}
+
+// Returns a "chop" frame, which removes between 1 and 3 locals from the
+// previous frame and has an empty stack.
+func NewChopFrame(offsetDelta uint16, count int) (StackMapFrame, error) {
+	if (count < 1) || (count > 3) {
+		return nil, fmt.Errorf("Can't chop %d locals", count)
+	}
+	var toReturn ChopStackMapFrame
+	toReturn.tag = StackMapFrameType(251 - count)
+	toReturn.offsetDelta = offsetDelta
+	return &toReturn, nil
+}
+
+// Returns an "append" frame, which adds between 1 and 3 locals to the
+// previous frame and has an empty stack.
+func NewAppendFrame(offsetDelta uint16,
+	locals []VerificationTypeInfo) (StackMapFrame, error) {
+	if (len(locals) < 1) || (len(locals) > 3) {
+		return nil, fmt.Errorf("Can't append %d locals", len(locals))
+	}
+	var toReturn AppendStackMapFrame
+	toReturn.tag = StackMapFrameType(251 + len(locals))
+	toReturn.offsetDelta = offsetDelta
+	toReturn.Locals = locals
+	return &toReturn, nil
+}
+
+// Returns a full frame, containing the given locals and stack.
+func NewFullFrame(offsetDelta uint16, locals,
+	stack []VerificationTypeInfo) StackMapFrame {
+	var toReturn FullStackMapFrame
+	toReturn.tag = 255
+	toReturn.offsetDelta = offsetDelta
+	toReturn.Locals = locals
+	toReturn.Stack = stack
+	return &toReturn
+}
+
 // This method is used to save some typing and error message formatting.
 // Expects to be at the beginning of a 16-bit offset delta field.
 func readOffsetDelta(data io.Reader) (uint16, error) {
@@ -338,3 +408,110 @@ func ParseStackMapTableAttribute(a *Attribute) ([]StackMapFrame, error) {
 	}
 	return entries, nil
 }
+
+// Writes a single verification type info structure. This is the inverse of
+// parseVerificationTypeInfo.
+func writeVerificationTypeInfo(data io.Writer, v VerificationTypeInfo) error {
+	if v.Tag > 8 {
+		return fmt.Errorf("Invalid verification type info tag: %d", v.Tag)
+	}
+	e := binary.Write(data, binary.BigEndian, v.Tag)
+	if e != nil {
+		return e
+	}
+	if !v.OtherFieldValid() {
+		return nil
+	}
+	return binary.Write(data, binary.BigEndian, v.Other)
+}
+
+// Writes a count of verification type info structures followed by the
+// structures themselves, as used in full stack map frames.
+func writeVerificationTypeInfoList(data io.Writer,
+	list []VerificationTypeInfo) error {
+	e := writeCount(data, len(list), "verification types")
+	if e != nil {
+		return e
+	}
+	for _, v := range list {
+		e = writeVerificationTypeInfo(data, v)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Writes a single stack map frame. This is the inverse of
+// parseStackMapFrame.
+func writeStackMapFrame(data io.Writer, frame StackMapFrame) error {
+	tag := frame.FrameType()
+	e := binary.Write(data, binary.BigEndian, tag)
+	if e != nil {
+		return e
+	}
+	switch f := frame.(type) {
+	case *SameStackMapFrame:
+		// The offset delta is contained in the tag.
+		return nil
+	case *OneItemStackMapFrame:
+		return writeVerificationTypeInfo(data, f.Info)
+	case *OneItemStackMapFrameExtended:
+		e = binary.Write(data, binary.BigEndian, f.offsetDelta)
+		if e != nil {
+			return e
+		}
+		return writeVerificationTypeInfo(data, f.Info)
+	case *ChopStackMapFrame, *SameStackMapFrameExtended:
+		return binary.Write(data, binary.BigEndian, frame.OffsetDelta())
+	case *AppendStackMapFrame:
+		if len(f.Locals) != (int(tag) - 251) {
+			return fmt.Errorf("Append frame with tag %d can't contain %d "+
+				"locals", tag, len(f.Locals))
+		}
+		e = binary.Write(data, binary.BigEndian, f.offsetDelta)
+		if e != nil {
+			return e
+		}
+		for _, v := range f.Locals {
+			e = writeVerificationTypeInfo(data, v)
+			if e != nil {
+				return e
+			}
+		}
+		return nil
+	case *FullStackMapFrame:
+		e = binary.Write(data, binary.BigEndian, f.offsetDelta)
+		if e != nil {
+			return e
+		}
+		e = writeVerificationTypeInfoList(data, f.Locals)
+		if e != nil {
+			return e
+		}
+		return writeVerificationTypeInfoList(data, f.Stack)
+	}
+	return fmt.Errorf("Can't write stack map frame: %s", frame)
+}
+
+// Returns a StackMapTable attribute containing the given frames. This is the
+// inverse of ParseStackMapTableAttribute.
+func EncodeStackMapTableAttribute(frames []StackMapFrame) (*Attribute,
+	error) {
+	data := &bytes.Buffer{}
+	e := writeCount(data, len(frames), "stack map frames")
+	if e != nil {
+		return nil, e
+	}
+	for i, f := range frames {
+		e = writeStackMapFrame(data, f)
+		if e != nil {
+			return nil, fmt.Errorf("Failed writing stack map frame %d: %w", i,
+				e)
+		}
+	}
+	return &Attribute{
+		Name: []byte("StackMapTable"),
+		Info: data.Bytes(),
+	}, nil
+}
@@ -0,0 +1,922 @@
+package class_file
+
+// This file contains the Validate function, which performs the format checks
+// described in section 4.8 of the JVM spec. ParseClass only rejects class
+// files that it can't read, so classes that have been successfully parsed may
+// still contain problems that are detected here.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Holds options that control the checks made by Validate.
+type ValidateOptions struct {
+	// The range of major versions to accept. If MinMajorVersion is 0, it
+	// defaults to 45 (Java 1.1), and if MaxMajorVersion is 0, it defaults to
+	// 61 (Java 17).
+	MinMajorVersion uint16
+	MaxMajorVersion uint16
+	// If true, accept the minor version 65535 used by classes relying on
+	// preview features of Java 12 or later.
+	AllowPreview bool
+}
+
+// This is returned by Validate if a class file is invalid. Lists every
+// problem that was found, rather than only the first one.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid class file: %s",
+		strings.Join(e.Problems, "; "))
+}
+
+// Attributes that may appear at most once in any attributes table.
+var uniqueAttributes = map[string]bool{
+	"AnnotationDefault":                    true,
+	"BootstrapMethods":                     true,
+	"Code":                                 true,
+	"ConstantValue":                        true,
+	"EnclosingMethod":                      true,
+	"Exceptions":                           true,
+	"InnerClasses":                         true,
+	"MethodParameters":                     true,
+	"Module":                               true,
+	"ModuleMainClass":                      true,
+	"ModulePackages":                       true,
+	"NestHost":                             true,
+	"NestMembers":                          true,
+	"PermittedSubclasses":                  true,
+	"Record":                               true,
+	"RuntimeInvisibleAnnotations":          true,
+	"RuntimeInvisibleParameterAnnotations": true,
+	"RuntimeInvisibleTypeAnnotations":      true,
+	"RuntimeVisibleAnnotations":            true,
+	"RuntimeVisibleParameterAnnotations":   true,
+	"RuntimeVisibleTypeAnnotations":        true,
+	"Signature":                            true,
+	"SourceDebugExtension":                 true,
+	"SourceFile":                           true,
+	"StackMapTable":                        true,
+}
+
+// Holds the state used while validating a single class.
+type validator struct {
+	class   *Class
+	options ValidateOptions
+	// The number of entries in the class' BootstrapMethods attribute, or -1
+	// if it doesn't have a valid one.
+	bootstrapMethodCount int
+	problems             []string
+}
+
+// Records a problem with the class file.
+func (v *validator) fail(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Returns true if the name is a valid unqualified name, as described in
+// section 4.2.2 of the JVM spec. Method names may not contain '<' or '>'.
+// (The special <init> and <clinit> method names must be checked separately.)
+func isUnqualifiedName(name []byte, isMethod bool) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		switch c {
+		case '.', ';', '[', '/':
+			return false
+		case '<', '>':
+			if isMethod {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns true if the name is a valid class or interface name in its internal
+// form, e.g. "java/lang/Object".
+func isBinaryClassName(name []byte) bool {
+	for _, part := range bytes.Split(name, []byte("/")) {
+		if !isUnqualifiedName(part, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns an error if any class name in the field type is invalid.
+func checkFieldTypeNames(t FieldType) error {
+	switch v := t.(type) {
+	case ClassInstanceType:
+		if !isBinaryClassName([]byte(v)) {
+			return fmt.Errorf("Invalid class name in descriptor: %s",
+				string(v))
+		}
+	case *ArrayType:
+		return checkFieldTypeNames(v.ContentType)
+	}
+	return nil
+}
+
+// Returns an error if the bytes aren't exactly one valid field descriptor.
+func checkFieldDescriptor(descriptor []byte) error {
+	t, e := ParseFieldType(descriptor)
+	if e != nil {
+		return e
+	}
+	if FieldTypeDescriptor(t) != string(descriptor) {
+		return fmt.Errorf("Unexpected data in field descriptor %s",
+			descriptor)
+	}
+	return checkFieldTypeNames(t)
+}
+
+// Returns the number of local variable slots taken by the method's
+// arguments, not including the "this" reference.
+func argumentSlots(d *MethodDescriptor) int {
+	toReturn := 0
+	for _, t := range d.ArgumentTypes {
+		toReturn++
+		if (t == PrimitiveFieldType('J')) || (t == PrimitiveFieldType('D')) {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Returns an error if the method descriptor is invalid, including if its
+// arguments take up more than 255 local variable slots.
+func checkMethodDescriptor(d *MethodDescriptor) error {
+	if argumentSlots(d) > 255 {
+		return fmt.Errorf("Method descriptor %s has too many arguments",
+			d.Descriptor())
+	}
+	for _, t := range d.ArgumentTypes {
+		e := checkFieldTypeNames(t)
+		if e != nil {
+			return e
+		}
+	}
+	return checkFieldTypeNames(d.ReturnType)
+}
+
+// Parses and checks a method descriptor, which must contain no extra data.
+func parseStrictMethodDescriptor(descriptor []byte) (*MethodDescriptor,
+	error) {
+	toReturn, e := ParseMethodDescriptor(descriptor)
+	if e != nil {
+		return nil, e
+	}
+	if toReturn.Descriptor() != string(descriptor) {
+		return nil, fmt.Errorf("Unexpected data in method descriptor %s",
+			descriptor)
+	}
+	e = checkMethodDescriptor(toReturn)
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+// Returns the constant at the given index if it has the expected tag.
+// Otherwise, records a problem and returns nil.
+func (v *validator) constant(index uint16, tag ConstantTag,
+	location string) Constant {
+	toReturn, e := v.class.GetConstant(index)
+	if e != nil {
+		v.fail("%s: %s", location, e)
+		return nil
+	}
+	if toReturn.Tag() != tag {
+		v.fail("%s: expected constant %d to be of type %s, got %s",
+			location, index, tag, toReturn.Tag())
+		return nil
+	}
+	return toReturn
+}
+
+// Returns the contents of the UTF-8 constant at the given index. Returns
+// false, after recording a problem, if the constant isn't a UTF-8 constant.
+func (v *validator) utf8(index uint16, location string) ([]byte, bool) {
+	c := v.constant(index, ConstantTag(1), location)
+	if c == nil {
+		return nil, false
+	}
+	return c.(*ConstantUTF8Info).Bytes, true
+}
+
+// Returns the name and descriptor from the name and type constant at the
+// given index. Returns false, after recording a problem, if either is invalid.
+func (v *validator) nameAndType(index uint16, location string) ([]byte,
+	[]byte, bool) {
+	c := v.constant(index, ConstantTag(12), location)
+	if c == nil {
+		return nil, nil, false
+	}
+	nameAndType := c.(*ConstantNameAndTypeInfo)
+	name, ok := v.utf8(nameAndType.NameIndex, location)
+	if !ok {
+		return nil, nil, false
+	}
+	descriptor, ok := v.utf8(nameAndType.DescriptorIndex, location)
+	if !ok {
+		return nil, nil, false
+	}
+	return name, descriptor, true
+}
+
+// Records a problem if the class file's version is below the given major
+// version, which is required to use the named feature.
+func (v *validator) requireVersion(major uint16, feature, location string) {
+	if v.class.MajorVersion < major {
+		v.fail("%s: %s requires class file version %d or above", location,
+			feature, major)
+	}
+}
+
+// Checks a field, method, or interface method reference, given the tag of
+// the reference constant.
+func (v *validator) checkMemberReference(tag ConstantTag, classIndex,
+	nameAndTypeIndex uint16, location string) {
+	v.constant(classIndex, ConstantTag(7), location)
+	name, descriptor, ok := v.nameAndType(nameAndTypeIndex, location)
+	if !ok {
+		return
+	}
+	if tag == ConstantTag(9) {
+		if !isUnqualifiedName(name, false) {
+			v.fail("%s: invalid field name %q", location, name)
+		}
+		e := checkFieldDescriptor(descriptor)
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+		return
+	}
+	d, e := parseStrictMethodDescriptor(descriptor)
+	if e != nil {
+		v.fail("%s: %s", location, e)
+		return
+	}
+	// Only the <init> method may be referred to, and only from methodrefs.
+	if isUnqualifiedName(name, true) {
+		return
+	}
+	if (string(name) != "<init>") || (tag != ConstantTag(10)) {
+		v.fail("%s: invalid method name %q", location, name)
+		return
+	}
+	if d.ReturnType != PrimitiveFieldType('V') {
+		v.fail("%s: <init> must return void", location)
+	}
+}
+
+// Checks the constant referred to by a method handle constant.
+func (v *validator) checkMethodHandle(c *ConstantMethodHandleInfo,
+	location string) {
+	v.requireVersion(51, "a method handle constant", location)
+	kind := c.ReferenceKind
+	if (kind < 1) || (kind > 9) {
+		v.fail("%s: invalid method handle kind %d", location, kind)
+		return
+	}
+	target, e := v.class.GetConstant(c.Index)
+	if e != nil {
+		v.fail("%s: %s", location, e)
+		return
+	}
+	var expected string
+	var nameAndTypeIndex uint16
+	switch t := target.(type) {
+	case *ConstantFieldInfo:
+		if kind <= 4 {
+			nameAndTypeIndex = t.NameAndTypeIndex
+		} else {
+			expected = "a method"
+		}
+	case *ConstantMethodInfo:
+		if (kind == 5) || (kind == 6) || (kind == 7) || (kind == 8) {
+			nameAndTypeIndex = t.NameAndTypeIndex
+		} else if kind <= 4 {
+			expected = "a field"
+		} else {
+			expected = "an interface method"
+		}
+	case *ConstantInterfaceMethodInfo:
+		// Static and special method handles may only refer to interface
+		// methods in version 52 and above.
+		if (kind == 9) || (((kind == 6) || (kind == 7)) &&
+			(v.class.MajorVersion >= 52)) {
+			nameAndTypeIndex = t.NameAndTypeIndex
+		} else if kind <= 4 {
+			expected = "a field"
+		} else {
+			expected = "a class method"
+		}
+	default:
+		expected = "a field or method"
+	}
+	if expected != "" {
+		v.fail("%s: a %s method handle must refer to %s", location, kind,
+			expected)
+		return
+	}
+	name, _, ok := v.nameAndType(nameAndTypeIndex, location)
+	if !ok || (kind <= 4) {
+		return
+	}
+	isInit := string(name) == "<init>"
+	if (kind == 8) && !isInit {
+		v.fail("%s: a %s method handle must refer to <init>", location, kind)
+	}
+	if (kind != 8) && (isInit || (string(name) == "<clinit>")) {
+		v.fail("%s: a %s method handle can't refer to %s", location, kind,
+			name)
+	}
+}
+
+// Checks a bootstrap method index in a dynamic or invokedynamic constant.
+func (v *validator) checkBootstrapIndex(index uint16, location string) {
+	if int(index) >= v.bootstrapMethodCount {
+		v.fail("%s: invalid bootstrap method index %d", location, index)
+	}
+}
+
+// Checks that any constants referred to by the constant are of the correct
+// types, and that any names or descriptors it contains are valid.
+func (v *validator) checkConstant(constant Constant, location string) {
+	switch c := constant.(type) {
+	case *ConstantUTF8Info:
+		// Section 4.4.7 of the JVM spec: these bytes never appear in the
+		// modified UTF-8 encoding.
+		for _, b := range c.Bytes {
+			if (b == 0) || (b >= 0xf0) {
+				v.fail("%s: invalid byte 0x%02x in UTF-8 string", location, b)
+				break
+			}
+		}
+	case *ConstantClassInfo:
+		name, ok := v.utf8(c.NameIndex, location)
+		if !ok {
+			return
+		}
+		if (len(name) != 0) && (name[0] == '[') {
+			e := checkFieldDescriptor(name)
+			if e != nil {
+				v.fail("%s: %s", location, e)
+			}
+			return
+		}
+		if !isBinaryClassName(name) {
+			v.fail("%s: invalid class name %q", location, name)
+		}
+	case *ConstantStringInfo:
+		v.utf8(c.StringIndex, location)
+	case *ConstantFieldInfo:
+		v.checkMemberReference(c.Tag(), c.ClassIndex, c.NameAndTypeIndex,
+			location)
+	case *ConstantMethodInfo:
+		v.checkMemberReference(c.Tag(), c.ClassIndex, c.NameAndTypeIndex,
+			location)
+	case *ConstantInterfaceMethodInfo:
+		v.checkMemberReference(c.Tag(), c.ClassIndex, c.NameAndTypeIndex,
+			location)
+	case *ConstantNameAndTypeInfo:
+		name, ok := v.utf8(c.NameIndex, location)
+		if ok && !isUnqualifiedName(name, false) {
+			v.fail("%s: invalid name %q", location, name)
+		}
+		descriptor, ok := v.utf8(c.DescriptorIndex, location)
+		if !ok {
+			return
+		}
+		var e error
+		if (len(descriptor) != 0) && (descriptor[0] == '(') {
+			_, e = parseStrictMethodDescriptor(descriptor)
+		} else {
+			e = checkFieldDescriptor(descriptor)
+		}
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+	case *ConstantMethodHandleInfo:
+		v.checkMethodHandle(c, location)
+	case *ConstantMethodTypeInfo:
+		v.requireVersion(51, "a method type constant", location)
+		descriptor, ok := v.utf8(c.DescriptorIndex, location)
+		if !ok {
+			return
+		}
+		_, e := parseStrictMethodDescriptor(descriptor)
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+	case *ConstantDynamicInfo:
+		v.requireVersion(55, "a dynamic constant", location)
+		v.checkBootstrapIndex(c.BootstrapMethodAttributeIndex, location)
+		name, descriptor, ok := v.nameAndType(c.NameAndTypeIndex, location)
+		if !ok {
+			return
+		}
+		if !isUnqualifiedName(name, false) {
+			v.fail("%s: invalid dynamic constant name %q", location, name)
+		}
+		e := checkFieldDescriptor(descriptor)
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+	case *ConstantInvokeDynamicInfo:
+		v.requireVersion(51, "an invokedynamic constant", location)
+		v.checkBootstrapIndex(c.BootstrapMethodAttributeIndex, location)
+		name, descriptor, ok := v.nameAndType(c.NameAndTypeIndex, location)
+		if !ok {
+			return
+		}
+		if !isUnqualifiedName(name, true) {
+			v.fail("%s: invalid invokedynamic method name %q", location, name)
+		}
+		_, e := parseStrictMethodDescriptor(descriptor)
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+	case *ConstantModuleInfo:
+		v.requireVersion(53, "a module constant", location)
+		if (v.class.Access & 0x8000) == 0 {
+			v.fail("%s: module constants may only appear in modules",
+				location)
+		}
+		v.utf8(c.NameIndex, location)
+	case *ConstantPackageInfo:
+		v.requireVersion(53, "a package constant", location)
+		if (v.class.Access & 0x8000) == 0 {
+			v.fail("%s: package constants may only appear in modules",
+				location)
+		}
+		name, ok := v.utf8(c.NameIndex, location)
+		if ok && !isBinaryClassName(name) {
+			v.fail("%s: invalid package name %q", location, name)
+		}
+	}
+}
+
+// Checks every entry in the constant pool.
+func (v *validator) checkConstants() {
+	constants := v.class.Constants
+	for i := 1; i < len(constants); i++ {
+		location := fmt.Sprintf("Constant %d", i)
+		constant := constants[i]
+		if constant == nil {
+			v.fail("%s is missing", location)
+			continue
+		}
+		v.checkConstant(constant, location)
+		if !constant.Tag().CountsDouble() {
+			continue
+		}
+		// The entry following a long or double is unusable, but must exist.
+		i++
+		if i >= len(constants) {
+			v.fail("%s: a %s can't be the last constant", location,
+				constant.Tag())
+		} else if constants[i] != nil {
+			v.fail("Constant %d: must be unused, because it follows a %s",
+				i, constant.Tag())
+		}
+	}
+}
+
+// Records a problem for each index that doesn't refer to a class constant.
+func (v *validator) checkClassIndices(indices []uint16, location string) {
+	for _, index := range indices {
+		v.constant(index, ConstantTag(7), location)
+	}
+}
+
+// Attempts to parse the attribute if it's one of the attributes defined by
+// the JVM spec, returning an error if it's malformed. Also returns any
+// attributes nested within it that should be checked. Problems with the
+// constants the attribute refers to are recorded directly.
+func (v *validator) parseAttribute(a *Attribute, kind,
+	location string) ([]*Attribute, error) {
+	var e error
+	var index uint16
+	var indices []uint16
+	location = fmt.Sprintf("%s: %s attribute", location, a.Name)
+	switch string(a.Name) {
+	case "Code":
+		var code *CodeAttribute
+		code, e = ParseCodeAttribute(a, v.class)
+		if e == nil {
+			return code.Attributes, nil
+		}
+	case "ConstantValue":
+		_, e = a.ToConstantValueAttribute(v.class)
+	case "Exceptions":
+		indices, e = ParseExceptionsAttribute(a)
+		v.checkClassIndices(indices, location)
+	case "InnerClasses":
+		_, e = ParseInnerClassesAttribute(a)
+	case "EnclosingMethod":
+		_, _, e = ParseEnclosingMethodAttribute(a)
+	case "SourceFile":
+		index, e = ParseSourceFileAttribute(a)
+		if e == nil {
+			v.utf8(index, location)
+		}
+	case "LineNumberTable":
+		_, e = ParseLineNumberTableAttribute(a)
+	case "LocalVariableTable":
+		_, e = ParseLocalVariableTableAttribute(a)
+	case "LocalVariableTypeTable":
+		_, e = ParseLocalVariableTypeTableAttribute(a)
+	case "StackMapTable":
+		_, e = ParseStackMapTableAttribute(a)
+	case "BootstrapMethods":
+		_, e = ParseBootstrapMethodsAttribute(a)
+	case "MethodParameters":
+		_, e = ParseMethodParametersAttribute(a)
+	case "NestHost":
+		index, e = ParseNestHostAttribute(a)
+		if e == nil {
+			v.checkClassIndices([]uint16{index}, location)
+		}
+	case "NestMembers":
+		indices, e = ParseNestMembersAttribute(a)
+		v.checkClassIndices(indices, location)
+	case "PermittedSubclasses":
+		indices, e = ParsePermittedSubclassesAttribute(a)
+		v.checkClassIndices(indices, location)
+	case "Module":
+		_, e = ParseModuleAttribute(a)
+	case "ModulePackages":
+		_, e = ParseModulePackagesAttribute(a)
+	case "ModuleMainClass":
+		index, e = ParseModuleMainClassAttribute(a)
+		if e == nil {
+			v.checkClassIndices([]uint16{index}, location)
+		}
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		_, e = ParseRuntimeAnnotationsAttribute(a)
+	case "RuntimeVisibleParameterAnnotations",
+		"RuntimeInvisibleParameterAnnotations":
+		_, e = ParseParameterAnnotationsAttribute(a)
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		_, e = ParseTypeAnnotationsAttribute(a)
+	case "AnnotationDefault":
+		_, e = ParseAnnotationDefaultAttribute(a)
+	case "Record":
+		var components []RecordComponent
+		components, e = ParseRecordAttribute(a, v.class)
+		if e == nil {
+			var nested []*Attribute
+			for _, c := range components {
+				nested = append(nested, c.Attributes...)
+			}
+			return nested, nil
+		}
+	case "Signature":
+		e = v.checkSignature(a, kind)
+	}
+	return nil, e
+}
+
+// Checks that a Signature attribute refers to a valid signature. The kind
+// of signature depends on whether the attribute belongs to a class, field,
+// method, or record component.
+func (v *validator) checkSignature(a *Attribute, kind string) error {
+	index, e := ParseSignatureAttribute(a)
+	if e != nil {
+		return e
+	}
+	signature, e := v.class.GetUTF8Constant(index)
+	if e != nil {
+		return fmt.Errorf("Invalid signature: %w", e)
+	}
+	switch kind {
+	case "class":
+		_, e = ParseClassSignature(signature)
+	case "method":
+		_, e = ParseMethodSignature(signature)
+	case "field":
+		_, e = ParseFieldSignature(signature)
+	}
+	return e
+}
+
+// Checks a table of attributes belonging to a class, field, method, or Code
+// attribute. The kind is used when checking Signature attributes.
+func (v *validator) checkAttributes(attributes []*Attribute, kind,
+	location string) {
+	seen := make(map[string]bool)
+	for _, a := range attributes {
+		name := string(a.Name)
+		if seen[name] && uniqueAttributes[name] {
+			v.fail("%s: multiple %s attributes", location, name)
+		}
+		seen[name] = true
+		nested, e := v.parseAttribute(a, kind, location)
+		if e != nil {
+			v.fail("%s: invalid %s attribute: %s", location, name, e)
+			continue
+		}
+		// Record components may have their own field signatures, while Code
+		// attributes' attributes don't have signatures.
+		nestedKind := "field"
+		if name == "Code" {
+			nestedKind = "code"
+		}
+		if len(nested) != 0 {
+			v.checkAttributes(nested, nestedKind, location)
+		}
+	}
+}
+
+// Returns true if more than one of the public, private, or protected flags
+// is set.
+func hasConflictingVisibility(flags uint16) bool {
+	count := 0
+	for _, flag := range []uint16{0x0001, 0x0002, 0x0004} {
+		if (flags & flag) != 0 {
+			count++
+		}
+	}
+	return count > 1
+}
+
+// Checks the access flags of the class itself, following section 4.1 of the
+// JVM spec.
+func (v *validator) checkClassFlags() {
+	flags := uint16(v.class.Access)
+	if (flags & 0x8000) != 0 {
+		v.requireVersion(53, "a module", "Class")
+		if flags != 0x8000 {
+			v.fail("Class: a module can't have other access flags")
+		}
+		return
+	}
+	if (flags & 0x0200) != 0 {
+		if (flags & 0x0400) == 0 {
+			v.fail("Class: interfaces must be abstract")
+		}
+		if (flags & 0x4030) != 0 {
+			v.fail("Class: interfaces can't be final, super, or enums")
+		}
+		return
+	}
+	if (flags & 0x2000) != 0 {
+		v.fail("Class: only interfaces can be annotations")
+	}
+	if (flags & 0x0410) == 0x0410 {
+		v.fail("Class: a class can't be both final and abstract")
+	}
+}
+
+// Checks the class' name, superclass, and interfaces.
+func (v *validator) checkClassNames() {
+	c := v.class
+	isObject := false
+	thisClass := v.constant(c.ThisClass, ConstantTag(7), "this_class")
+	if thisClass != nil {
+		name, ok := v.utf8(thisClass.(*ConstantClassInfo).NameIndex,
+			"this_class")
+		if ok && (len(name) != 0) && (name[0] == '[') {
+			v.fail("this_class: %s is an array type", name)
+		}
+		isObject = string(name) == "java/lang/Object"
+	}
+	// Only java/lang/Object and modules have no superclass.
+	if c.SuperClass == 0 {
+		if !isObject && ((c.Access & 0x8000) == 0) {
+			v.fail("super_class: only java/lang/Object can omit its " +
+				"superclass")
+		}
+	} else {
+		name, e := c.GetClassConstantName(c.SuperClass)
+		if e != nil {
+			v.fail("super_class: %s", e)
+		} else if c.Access.IsInterface() &&
+			(string(name) != "java/lang/Object") {
+			v.fail("super_class: the superclass of an interface must be " +
+				"java/lang/Object")
+		} else if (len(name) != 0) && (name[0] == '[') {
+			v.fail("super_class: %s is an array type", name)
+		}
+	}
+	for i, index := range c.Interfaces {
+		v.constant(index, ConstantTag(7), fmt.Sprintf("Interface %d", i))
+	}
+}
+
+// Checks a single field, following section 4.5 of the JVM spec.
+func (v *validator) checkField(f *Field) {
+	location := fmt.Sprintf("Field %s", f.Name)
+	if !isUnqualifiedName(f.Name, false) {
+		v.fail("%s: invalid field name %q", location, f.Name)
+	}
+	if f.Descriptor == nil {
+		v.fail("%s: missing descriptor", location)
+	} else {
+		e := checkFieldTypeNames(f.Descriptor)
+		if e != nil {
+			v.fail("%s: %s", location, e)
+		}
+	}
+	flags := uint16(f.Access)
+	if hasConflictingVisibility(flags) {
+		v.fail("%s: conflicting public, private, or protected flags",
+			location)
+	}
+	if (flags & 0x0050) == 0x0050 {
+		v.fail("%s: a field can't be both final and volatile", location)
+	}
+	// Interface fields must be public static final, and may be synthetic.
+	if v.class.Access.IsInterface() && ((flags & 0xefff) != 0x0019) {
+		v.fail("%s: interface fields must be public, static, and final",
+			location)
+	}
+	v.checkAttributes(f.Attributes, "field", location)
+}
+
+// Checks the access flags of a method, following section 4.6 of the JVM
+// spec.
+func (v *validator) checkMethodFlags(m *Method, location string) {
+	flags := uint16(m.Access)
+	if hasConflictingVisibility(flags) {
+		v.fail("%s: conflicting public, private, or protected flags",
+			location)
+	}
+	name := string(m.Name)
+	if name == "<init>" {
+		// Only visibility, varargs, strict, and synthetic flags are allowed.
+		if (flags &^ 0x1887) != 0 {
+			v.fail("%s: invalid access flags for <init>: %s", location,
+				m.Access)
+		}
+	} else if name == "<clinit>" {
+		if (v.class.MajorVersion >= 51) && !m.Access.IsStatic() {
+			v.fail("%s: <clinit> must be static", location)
+		}
+		return
+	}
+	if v.class.Access.IsInterface() {
+		if v.class.MajorVersion < 52 {
+			if (flags & 0x0401) != 0x0401 {
+				v.fail("%s: interface methods must be public and abstract",
+					location)
+			}
+		} else if (flags & 0x0003) == 0 {
+			v.fail("%s: interface methods must be public or private",
+				location)
+		}
+		// Protected, final, synchronized, or native
+		if (flags & 0x0134) != 0 {
+			v.fail("%s: invalid access flags for an interface method: %s",
+				location, m.Access)
+		}
+	}
+	// Private, static, final, synchronized, or native
+	if m.Access.IsAbstract() && ((flags & 0x013a) != 0) {
+		v.fail("%s: invalid access flags for an abstract method: %s",
+			location, m.Access)
+	}
+}
+
+// Checks a single method, following section 4.6 of the JVM spec.
+func (v *validator) checkMethod(m *Method) {
+	location := fmt.Sprintf("Method %s", m.Name)
+	if m.Descriptor == nil {
+		v.fail("%s: missing descriptor", location)
+		return
+	}
+	location += m.Descriptor.Descriptor()
+	name := string(m.Name)
+	if name == "<init>" {
+		if v.class.Access.IsInterface() {
+			v.fail("%s: interfaces can't have <init> methods", location)
+		}
+		if m.Descriptor.ReturnType != PrimitiveFieldType('V') {
+			v.fail("%s: <init> must return void", location)
+		}
+	} else if (name != "<clinit>") && !isUnqualifiedName(m.Name, true) {
+		v.fail("%s: invalid method name %q", location, m.Name)
+	}
+	e := checkMethodDescriptor(m.Descriptor)
+	if e != nil {
+		v.fail("%s: %s", location, e)
+	} else if !m.Access.IsStatic() && (argumentSlots(m.Descriptor) > 254) {
+		v.fail("%s: too many arguments, including this", location)
+	}
+	v.checkMethodFlags(m, location)
+	// Only native and abstract methods don't have code.
+	hasCode := false
+	for _, a := range m.Attributes {
+		if string(a.Name) == "Code" {
+			hasCode = true
+			break
+		}
+	}
+	needsCode := !m.Access.IsNative() && !m.Access.IsAbstract()
+	if hasCode && !needsCode {
+		v.fail("%s: native and abstract methods can't have code", location)
+	} else if !hasCode && needsCode {
+		v.fail("%s: missing a Code attribute", location)
+	}
+	v.checkAttributes(m.Attributes, "method", location)
+}
+
+// Checks every field and method, including checking for duplicates.
+func (v *validator) checkMembers() {
+	fields := make(map[string]bool)
+	for _, f := range v.class.Fields {
+		v.checkField(f)
+		if f.Descriptor == nil {
+			continue
+		}
+		key := string(f.Name) + " " + FieldTypeDescriptor(f.Descriptor)
+		if fields[key] {
+			v.fail("Field %s: defined more than once", f.Name)
+		}
+		fields[key] = true
+	}
+	methods := make(map[string]bool)
+	for _, m := range v.class.Methods {
+		v.checkMethod(m)
+		if m.Descriptor == nil {
+			continue
+		}
+		key := string(m.Name) + m.Descriptor.Descriptor()
+		if methods[key] {
+			v.fail("Method %s: defined more than once", key)
+		}
+		methods[key] = true
+	}
+}
+
+// Checks the class file's version against the options.
+func (v *validator) checkVersion() {
+	c := v.class
+	minVersion := v.options.MinMajorVersion
+	maxVersion := v.options.MaxMajorVersion
+	if minVersion == 0 {
+		minVersion = 45
+	}
+	if maxVersion == 0 {
+		maxVersion = 61
+	}
+	if (c.MajorVersion < minVersion) || (c.MajorVersion > maxVersion) {
+		v.fail("Unsupported class file version %d.%d", c.MajorVersion,
+			c.MinorVersion)
+	}
+	// Starting with Java 12, minor versions are only used for preview
+	// features.
+	if (c.MajorVersion < 56) || (c.MinorVersion == 0) {
+		return
+	}
+	if (c.MinorVersion != 0xffff) || !v.options.AllowPreview {
+		v.fail("Unsupported minor version %d for major version %d",
+			c.MinorVersion, c.MajorVersion)
+	}
+}
+
+// Checks the class for the problems described in section 4.8 of the JVM spec:
+// unsupported versions, constants that refer to constants of the wrong type,
+// invalid names and descriptors, inconsistent access flags, duplicate fields
+// and methods, and malformed attributes. Returns a *ValidationError listing
+// every problem, or nil if none were found. The options may be nil, in which
+// case the defaults are used.
+func Validate(c *Class, options *ValidateOptions) error {
+	v := &validator{
+		class:                c,
+		bootstrapMethodCount: -1,
+	}
+	if options != nil {
+		v.options = *options
+	}
+	for _, a := range c.Attributes {
+		if string(a.Name) != "BootstrapMethods" {
+			continue
+		}
+		methods, e := ParseBootstrapMethodsAttribute(a)
+		if e == nil {
+			v.bootstrapMethodCount = len(methods)
+		}
+		break
+	}
+	v.checkVersion()
+	v.checkConstants()
+	v.checkClassFlags()
+	v.checkClassNames()
+	v.checkMembers()
+	v.checkAttributes(c.Attributes, "class", "Class")
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		Problems: v.problems,
+	}
+}
@@ -0,0 +1,196 @@
+package bs_jvm
+
+// This file contains code for initializing classes, which runs their static
+// initializers the first time they're used, as described in section 5.5 of
+// the JVM spec.
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// The states a class can be in with regards to its initialization.
+type classInitState uint32
+
+const (
+	// The class' static initializer hasn't been run yet.
+	classNotInitialized classInitState = iota
+	// A thread is currently initializing the class.
+	classInitializing
+	// The class has been successfully initialized.
+	classInitialized
+	// Initializing the class failed, so it can't be used.
+	classInitFailed
+)
+
+// Returns the current initialization state of the class. This can be called
+// without holding the class' initLock, but the state may change unless the
+// lock is held.
+func (c *Class) getInitState() classInitState {
+	return classInitState(atomic.LoadUint32(&c.initState))
+}
+
+// Sets the class' initialization state, and wakes up any threads waiting for
+// another thread to initialize it. Must be called while holding initLock.
+func (c *Class) setInitState(state classInitState) {
+	atomic.StoreUint32(&c.initState, uint32(state))
+	if state != classInitializing {
+		c.initThread = nil
+		if c.initDone != nil {
+			c.initDone.Broadcast()
+		}
+	}
+}
+
+// Returns true if the class has been successfully initialized.
+func (c *Class) IsInitialized() bool {
+	return c.getInitState() == classInitialized
+}
+
+// Returns true if the interface declares any methods that are neither
+// abstract nor static, i.e. default methods.
+func (c *Class) declaresDefaultMethods() bool {
+	for _, m := range c.Methods {
+		if !m.AccessFlags.IsStatic() && !m.AccessFlags.IsAbstract() {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends the superinterfaces of c that declare default methods to the list,
+// recursively, skipping any that are already in it. Returns the new list.
+func (c *Class) appendDefaultInterfaces(list []*Class) []*Class {
+	for _, iface := range c.Interfaces {
+		found := false
+		for _, existing := range list {
+			if existing == iface {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if iface.declaresDefaultMethods() {
+			list = append(list, iface)
+		}
+		list = iface.appendDefaultInterfaces(list)
+	}
+	return list
+}
+
+// Returns true if the class is java/lang/Error or one of its subclasses.
+func isErrorClass(c *Class) bool {
+	for ; c != nil; c = c.Super {
+		if string(c.Name) == "java/lang/Error" {
+			return true
+		}
+	}
+	return false
+}
+
+// Converts an error returned by a class' static initializer into the error
+// that should be thrown by the instruction that triggered initialization.
+// Java exceptions that aren't Errors are wrapped in an
+// ExceptionInInitializerError.
+func (t *Thread) getInitializerError(c *Class, e error) error {
+	exception := t.errorToThrowable(e)
+	if exception == nil {
+		return fmt.Errorf("Failed initializing class %s: %w", c.Name, e)
+	}
+	if isErrorClass(exception.C) {
+		return &ThrownException{
+			Exception: exception,
+		}
+	}
+	wrapper, e := t.NewThrowable("java/lang/ExceptionInInitializerError", "")
+	if e != nil {
+		// We can still throw the original exception if the wrapper isn't
+		// available.
+		return &ThrownException{
+			Exception: exception,
+		}
+	}
+	info := GetThrowableInfo(wrapper)
+	info.Message = nil
+	info.Cause = exception
+	return &ThrownException{
+		Exception: wrapper,
+	}
+}
+
+// Runs the class' superclasses' and superinterfaces' initialization, followed
+// by its <clinit> method, if it has one. Expects the class to already be
+// marked as being initialized by t.
+func (t *Thread) runInitializers(c *Class) error {
+	var e error
+	if !c.IsInterface() {
+		var supers []*Class
+		if c.Super != nil {
+			supers = append(supers, c.Super)
+		}
+		supers = c.appendDefaultInterfaces(supers)
+		for _, s := range supers {
+			e = t.InitializeClass(s)
+			if e != nil {
+				return e
+			}
+		}
+	}
+	clinit := c.Methods[getClinitMethodKey()]
+	if clinit == nil {
+		return nil
+	}
+	_, e = t.Invoke(clinit)
+	if e != nil {
+		return t.getInitializerError(c, e)
+	}
+	return nil
+}
+
+// Initializes the class if it hasn't been initialized yet, running its static
+// initializer on the given thread, after initializing its superclass. If
+// another thread is initializing the class, this waits for it to finish.
+// Returns nil without doing anything if the class was already initialized, or
+// if it's being initialized by t. Returns the exception thrown by the static
+// initializer, wrapped in an ExceptionInInitializerError if it isn't an
+// Error. Returns a NoClassDefFoundError if initializing the class failed
+// previously.
+func (t *Thread) InitializeClass(c *Class) error {
+	if c.getInitState() == classInitialized {
+		return nil
+	}
+	c.initLock.Lock()
+	for (c.getInitState() == classInitializing) && (c.initThread != t) {
+		if c.initDone == nil {
+			c.initDone = sync.NewCond(&c.initLock)
+		}
+		c.initDone.Wait()
+	}
+	switch c.getInitState() {
+	case classInitializing, classInitialized:
+		// Either another thread finished initializing the class, or this is a
+		// recursive request from the thread that's initializing it.
+		c.initLock.Unlock()
+		return nil
+	case classInitFailed:
+		c.initLock.Unlock()
+		return t.Throw("java/lang/NoClassDefFoundError", "Could not "+
+			"initialize class "+binaryClassName(string(c.Name)))
+	}
+	c.initThread = t
+	c.setInitState(classInitializing)
+	c.initLock.Unlock()
+
+	e := t.runInitializers(c)
+	c.initLock.Lock()
+	defer c.initLock.Unlock()
+	if e != nil {
+		c.setInitState(classInitFailed)
+		return e
+	}
+	c.setInitState(classInitialized)
+	return nil
+}
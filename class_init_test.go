@@ -0,0 +1,213 @@
+package bs_jvm
+
+import (
+	"bytes"
+	"errors"
+	"github.com/yalue/bs_jvm/class_file"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a stub class containing a native <clinit> method and a native static
+// helper() method, which do nothing until they're replaced.
+func getInitTestClassFile(name, superName string) *class_file.Class {
+	return getStubClassFile(name, superName, 0x0021, nil, nil,
+		[]*class_file.Method{
+			getStubMethod("<clinit>", 0x0108),
+			getStubMethod("helper", 0x0109),
+		})
+}
+
+// Adds a method to the "Main" class file that calls the static helper()
+// method in the named class, then returns.
+func addCallHelperMethod(main *class_file.Class, methodName,
+	className string) {
+	index := addTestMethodConstant(main, "helper", "()V")
+	reference := main.Constants[index].(*class_file.ConstantMethodInfo)
+	reference.ClassIndex = addTestClassConstant(main, className)
+	// invokestatic <className>.helper, return
+	code := []byte{0xb8, byte(index >> 8), byte(index), 0xb1}
+	main.Methods = append(main.Methods, getTestCodeMethod(methodName, code,
+		nil))
+}
+
+// Sets the native implementation of the named no-argument void method.
+func setInitTestNative(t *testing.T, jvm *JVM, className, methodName string,
+	native NativeMethod) {
+	c, e := jvm.GetClass(className)
+	if e != nil {
+		t.Logf("Failed getting class %s: %s\n", className, e)
+		t.FailNow()
+	}
+	c.Methods[GetMethodKey(getStubMethod(methodName, 0))].Native = native
+}
+
+// Runs the named method in the Main class, and returns the thread's error.
+func runInitTestMethod(t *testing.T, jvm *JVM, name string) error {
+	_, e := jvm.StartNamedThread("Main",
+		GetMethodKey(getStubMethod(name, 0)), name)
+	if e != nil {
+		t.Logf("Failed starting %s: %s\n", name, e)
+		t.FailNow()
+	}
+	return jvm.WaitForAllThreads()
+}
+
+func TestClassInitializationOrder(t *testing.T) {
+	jvm := NewJVM()
+	main := getStubClassFile("Main", "java/lang/Object", 0x0021, nil, nil,
+		nil)
+	addCallHelperMethod(main, "callHelper", "Sub")
+	loadStubClasses(t, jvm, getInitTestClassFile("Super", ""),
+		getInitTestClassFile("Sub", "Super"), main)
+	var order []string
+	for _, name := range []string{"Super", "Sub"} {
+		className := name
+		setInitTestNative(t, jvm, name, "<clinit>", func(t *Thread) error {
+			order = append(order, className)
+			return nil
+		})
+	}
+	setInitTestNative(t, jvm, "Sub", "helper", func(t *Thread) error {
+		return nil
+	})
+	if len(order) != 0 {
+		t.Logf("Static initializers ran when loading classes: %v\n", order)
+		t.FailNow()
+	}
+	for i := 0; i < 2; i++ {
+		e := runInitTestMethod(t, jvm, "callHelper")
+		if e != nil {
+			t.Logf("Failed calling helper: %s\n", e)
+			t.FailNow()
+		}
+	}
+	if (len(order) != 2) || (order[0] != "Super") || (order[1] != "Sub") {
+		t.Logf("Got incorrect initialization order: %v\n", order)
+		t.Fail()
+	}
+	c, _ := jvm.GetClass("Main")
+	if !c.IsInitialized() {
+		t.Logf("Main wasn't initialized when starting a thread in it\n")
+		t.Fail()
+	}
+}
+
+func TestClassInitializationFailure(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadStubClasses(t, jvm,
+		getStubClassFile("java/lang/Throwable", "java/lang/Object", 0x0021,
+			nil, nil, nil),
+		getStubClassFile("java/lang/ArithmeticException",
+			"java/lang/Throwable", 0x0021, nil, nil, nil),
+		getStubClassFile("java/lang/Error", "java/lang/Throwable", 0x0021,
+			nil, nil, nil),
+		getStubClassFile("java/lang/ExceptionInInitializerError",
+			"java/lang/Error", 0x0021, nil, nil, nil),
+		getStubClassFile("java/lang/NoClassDefFoundError",
+			"java/lang/Error", 0x0021, nil, nil, nil))
+	broken := getStubClassFile("Broken", "java/lang/Object", 0x0021, nil, nil,
+		nil)
+	// iconst_1, iconst_0, idiv, pop, return
+	broken.Methods = []*class_file.Method{
+		getTestCodeMethod("<clinit>", []byte{0x04, 0x03, 0x6c, 0x57, 0xb1},
+			nil),
+		getStubMethod("helper", 0x0109),
+	}
+	main := getStubClassFile("Main", "java/lang/Object", 0x0021, nil, nil,
+		nil)
+	addCallHelperMethod(main, "callHelper", "Broken")
+	loadStubClasses(t, jvm, broken, main)
+	helperCalled := false
+	setInitTestNative(t, jvm, "Broken", "helper", func(t *Thread) error {
+		helperCalled = true
+		return nil
+	})
+
+	var thrown *ThrownException
+	e := runInitTestMethod(t, jvm, "callHelper")
+	if !errors.As(e, &thrown) {
+		t.Logf("Expected a ThrownException, got %v\n", e)
+		t.FailNow()
+	}
+	className := string(thrown.Exception.C.Name)
+	if className != "java/lang/ExceptionInInitializerError" {
+		t.Logf("Expected an ExceptionInInitializerError, got %s\n", className)
+		t.FailNow()
+	}
+	cause, ok := GetThrowableInfo(thrown.Exception).Cause.(*ClassInstance)
+	if !ok || (string(cause.C.Name) != "java/lang/ArithmeticException") {
+		t.Logf("Didn't get the expected cause: %v\n", cause)
+		t.Fail()
+	}
+
+	// Later attempts to use the class must fail without rerunning <clinit>.
+	e = runInitTestMethod(t, jvm, "callHelper")
+	if !errors.As(e, &thrown) {
+		t.Logf("Expected a ThrownException, got %v\n", e)
+		t.FailNow()
+	}
+	className = string(thrown.Exception.C.Name)
+	if className != "java/lang/NoClassDefFoundError" {
+		t.Logf("Expected a NoClassDefFoundError, got %s\n", className)
+		t.Fail()
+	}
+	if helperCalled {
+		t.Logf("A method in the broken class was called\n")
+		t.Fail()
+	}
+}
+
+func TestConcurrentClassInitialization(t *testing.T) {
+	jvm := NewJVM()
+	main := getStubClassFile("Main", "java/lang/Object", 0x0021, nil, nil,
+		nil)
+	addCallHelperMethod(main, "callHelper", "Slow")
+	loadStubClasses(t, jvm, getInitTestClassFile("Slow", ""), main)
+	started := make(chan bool)
+	release := make(chan bool)
+	var initCount, helperCount int32
+	setInitTestNative(t, jvm, "Slow", "<clinit>", func(t *Thread) error {
+		atomic.AddInt32(&initCount, 1)
+		close(started)
+		<-release
+		return nil
+	})
+	setInitTestNative(t, jvm, "Slow", "helper", func(t *Thread) error {
+		atomic.AddInt32(&helperCount, 1)
+		return nil
+	})
+
+	key := GetMethodKey(getStubMethod("callHelper", 0))
+	_, e := jvm.StartNamedThread("Main", key, "first")
+	if e != nil {
+		t.Logf("Failed starting first thread: %s\n", e)
+		t.FailNow()
+	}
+	<-started
+	_, e = jvm.StartNamedThread("Main", key, "second")
+	if e != nil {
+		t.Logf("Failed starting second thread: %s\n", e)
+		t.FailNow()
+	}
+	// The second thread must wait for the first to finish initializing the
+	// class before calling helper().
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&helperCount) != 0 {
+		t.Logf("helper() was called before Slow was initialized\n")
+		t.Fail()
+	}
+	close(release)
+	e = jvm.WaitForAllThreads()
+	if e != nil {
+		t.Logf("Calling helper() failed: %s\n", e)
+		t.FailNow()
+	}
+	if (initCount != 1) || (helperCount != 2) {
+		t.Logf("Expected 1 <clinit> call and 2 helper() calls, got %d and "+
+			"%d\n", initCount, helperCount)
+		t.Fail()
+	}
+}
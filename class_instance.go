@@ -7,14 +7,14 @@ package bs_jvm
 type ClassInstance struct {
 	// The class this is an instance of.
 	C *Class
-	// The non-static fields of this class. Get indices into this using
-	// C.FieldInfo[fieldName].Index.
+	// The non-static fields of this class, including fields inherited from
+	// superclasses. Get indices into this using C.ResolveField(fieldName).
 	FieldValues []Object
 	// Used by builtin classes to refer to Go information. Otherwise, should be
 	// nil.
 	NativeData interface{}
-	// TODO: Needs to also keep track of superclass fields. Probably need to
-	// add a "superclass" ClassInstance reference.
+	// The monitor used when synchronizing on this object.
+	objectMonitor
 }
 
 func (o *ClassInstance) IsPrimitive() bool {
@@ -30,20 +30,15 @@ func (o *ClassInstance) String() string {
 }
 
 // Like Class.ResolveStaticField, but used for non-static fields of a class.
-// The named field must NOT be static in order for this to work. May return
-// a ClassInstance for a superclass. The returned int is an index into the
-// returned ClassInstance's FieldValues array. Returns an error if the field
-// can't be resolved.
-// NOTE: Make this work with static fields, too?
+// The named field must NOT be static in order for this to work. Since
+// inherited fields are stored in the same FieldValues array, this always
+// returns o itself. The returned int is an index into o's FieldValues array.
+// Returns an error if the field can't be resolved.
 func (o *ClassInstance) ResolveField(name string) (*ClassInstance, int,
 	error) {
-	info := o.C.FieldInfo[name]
-	// TODO: Actually look up fields in superclasses, etc.
-	if info == nil {
-		return nil, 0, FieldError("Could not find field " + name)
+	_, index, e := o.C.ResolveField(name)
+	if e != nil {
+		return nil, 0, e
 	}
-	if info.FileField.Access.IsStatic() {
-		return nil, 0, FieldError("Field " + name + " is static")
-	}
-	return o, info.Index, nil
+	return o, index, nil
 }
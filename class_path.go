@@ -0,0 +1,109 @@
+package bs_jvm
+
+// This file contains code for locating class files that haven't been loaded
+// yet, so that they can be loaded on demand.
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A ClassSource is a single location that may contain class files, such as a
+// directory. The JVM's class path is made up of a list of these.
+type ClassSource interface {
+	// Returns the raw content of the class file for the given fully-qualified
+	// class name (e.g. "java/lang/String"). Must return a ClassNotFoundError
+	// if this source doesn't contain the class.
+	ReadClassFile(className string) ([]byte, error)
+	// Returns a human-readable description of the class source.
+	String() string
+}
+
+// A ClassSource that looks up class files relative to a directory on the
+// filesystem, e.g. the class "a/b/C" will be loaded from <dir>/a/b/C.class.
+type DirectoryClassSource string
+
+func (d DirectoryClassSource) ReadClassFile(className string) ([]byte,
+	error) {
+	path := filepath.Join(string(d), filepath.FromSlash(className)+".class")
+	content, e := ioutil.ReadFile(path)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, ClassNotFoundError(className)
+		}
+		return nil, fmt.Errorf("Failed reading %s: %w", path, e)
+	}
+	return content, nil
+}
+
+func (d DirectoryClassSource) String() string {
+	return "directory " + string(d)
+}
+
+// Converts a single class path entry to a ClassSource. Directories are used
+// directly, and files are opened as JAR or zip archives.
+func NewClassSource(path string) (ClassSource, error) {
+	info, e := os.Stat(path)
+	if e != nil {
+		return nil, fmt.Errorf("Invalid class path entry %s: %w", path, e)
+	}
+	if info.IsDir() {
+		return DirectoryClassSource(path), nil
+	}
+	return OpenZipClassSource(path)
+}
+
+// Parses a class path string, such as the argument to java's -cp option, into
+// a list of class sources. Entries are separated by os.PathListSeparator.
+// Empty entries are ignored.
+func ParseClassPath(classPath string) ([]ClassSource, error) {
+	entries := strings.Split(classPath, string(os.PathListSeparator))
+	toReturn := make([]ClassSource, 0, len(entries))
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		source, e := NewClassSource(entry)
+		if e != nil {
+			return nil, e
+		}
+		toReturn = append(toReturn, source)
+	}
+	return toReturn, nil
+}
+
+// Searches the JVM's class path, in order, for the named class. Returns the
+// parsed class file, or a ClassNotFoundError if the class isn't in any of the
+// class path's sources.
+func (j *JVM) FindClassFile(className string) (*class_file.Class, error) {
+	for _, source := range j.ClassPath {
+		content, e := source.ReadClassFile(className)
+		if e != nil {
+			_, notFound := e.(ClassNotFoundError)
+			if notFound {
+				continue
+			}
+			return nil, e
+		}
+		classFile, e := class_file.ParseClass(bytes.NewReader(content))
+		if e != nil {
+			return nil, fmt.Errorf("Failed parsing class %s from %s: %w",
+				className, source, e)
+		}
+		name, e := classFile.GetName()
+		if e != nil {
+			return nil, fmt.Errorf("Failed getting class name: %w", e)
+		}
+		if string(name) != className {
+			return nil, fmt.Errorf("Expected %s from %s to contain class %s, "+
+				"but it contained %s", className, source, className, name)
+		}
+		return classFile, nil
+	}
+	return nil, ClassNotFoundError(className)
+}
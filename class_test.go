@@ -0,0 +1,199 @@
+package bs_jvm
+
+import (
+	"errors"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Adds a UTF-8 constant and a class info constant referring to it to the
+// class file, returning the index of the class info constant.
+func addTestClassConstant(c *class_file.Class, name string) uint16 {
+	c.Constants = append(c.Constants,
+		&class_file.ConstantUTF8Info{Bytes: []byte(name)},
+		&class_file.ConstantClassInfo{NameIndex: uint16(len(c.Constants))})
+	return uint16(len(c.Constants) - 1)
+}
+
+// Returns a minimal class file with the given name, superclass, interfaces,
+// fields, and methods. The superclass may be empty for a class with no
+// superclass. The methods must be abstract or native, since they won't have
+// Code attributes.
+func getStubClassFile(name, superName string,
+	access class_file.ClassAccessFlags, interfaces []string,
+	fields []*class_file.Field,
+	methods []*class_file.Method) *class_file.Class {
+	toReturn := &class_file.Class{
+		Constants:  []class_file.Constant{nil},
+		Access:     access,
+		Interfaces: make([]uint16, len(interfaces)),
+		Fields:     fields,
+		Methods:    methods,
+	}
+	toReturn.ThisClass = addTestClassConstant(toReturn, name)
+	if superName != "" {
+		toReturn.SuperClass = addTestClassConstant(toReturn, superName)
+	}
+	for i, iface := range interfaces {
+		toReturn.Interfaces[i] = addTestClassConstant(toReturn, iface)
+	}
+	return toReturn
+}
+
+// Returns a minimal class file with the given name, superclass, and fields,
+// but no methods.
+func getFieldTestClassFile(name, superName string,
+	fields []*class_file.Field) *class_file.Class {
+	return getStubClassFile(name, superName, 0x0021, nil, fields, nil)
+}
+
+func TestInheritedFields(t *testing.T) {
+	jvm := NewJVM()
+	parentFields := []*class_file.Field{
+		{
+			Name:       []byte("a"),
+			Descriptor: class_file.PrimitiveFieldType('I'),
+		},
+		{
+			Access:     0x0008,
+			Name:       []byte("s"),
+			Descriptor: class_file.PrimitiveFieldType('J'),
+		},
+		{
+			Name:       []byte("shadowed"),
+			Descriptor: class_file.PrimitiveFieldType('I'),
+		},
+	}
+	childFields := []*class_file.Field{
+		{
+			Name:       []byte("b"),
+			Descriptor: class_file.PrimitiveFieldType('D'),
+		},
+		{
+			Name:       []byte("shadowed"),
+			Descriptor: class_file.PrimitiveFieldType('F'),
+		},
+	}
+	e := jvm.LoadClass(getFieldTestClassFile("Parent", "java/lang/Object",
+		parentFields))
+	if e != nil {
+		t.Logf("Failed loading parent class: %s\n", e)
+		t.FailNow()
+	}
+	e = jvm.LoadClass(getFieldTestClassFile("Child", "Parent", childFields))
+	if e != nil {
+		t.Logf("Failed loading child class: %s\n", e)
+		t.FailNow()
+	}
+	parent, _ := jvm.GetClass("Parent")
+	child, _ := jvm.GetClass("Child")
+	if parent.Super != nil {
+		t.Logf("Parent shouldn't have a superclass without java/lang/Object\n")
+		t.Fail()
+	}
+	if child.Super != parent {
+		t.Logf("Child's superclass wasn't set to the parent class\n")
+		t.FailNow()
+	}
+	if !child.IsSubclassOf(parent) || parent.IsSubclassOf(child) {
+		t.Logf("IsSubclassOf returned incorrect results\n")
+		t.Fail()
+	}
+	if len(child.FieldTypes) != 4 {
+		t.Logf("Expected the child to have 4 instance fields, got %d\n",
+			len(child.FieldTypes))
+		t.FailNow()
+	}
+
+	// The parent's fields must be laid out first, in the same positions.
+	declaring, index, e := child.ResolveField("a")
+	if e != nil {
+		t.Logf("Failed resolving inherited field: %s\n", e)
+		t.FailNow()
+	}
+	_, parentIndex, _ := parent.ResolveField("a")
+	if (declaring != parent) || (index != parentIndex) {
+		t.Logf("Inherited field resolved to %s index %d, expected parent "+
+			"index %d\n", declaring.Name, index, parentIndex)
+		t.Fail()
+	}
+	declaring, index, e = child.ResolveField("shadowed")
+	if e != nil {
+		t.Logf("Failed resolving shadowed field: %s\n", e)
+		t.FailNow()
+	}
+	if (declaring != child) || (index < len(parent.FieldTypes)) {
+		t.Logf("Shadowed field resolved to the wrong class or index\n")
+		t.Fail()
+	}
+	_, _, e = child.ResolveField("s")
+	if e == nil {
+		t.Logf("Didn't get an error resolving a static field as non-static\n")
+		t.Fail()
+	}
+	declaring, _, e = child.ResolveStaticField("s")
+	if e != nil {
+		t.Logf("Failed resolving inherited static field: %s\n", e)
+		t.FailNow()
+	}
+	if declaring != parent {
+		t.Logf("Inherited static field resolved to the wrong class\n")
+		t.Fail()
+	}
+
+	instance, e := child.CreateInstance()
+	if e != nil {
+		t.Logf("Failed creating child instance: %s\n", e)
+		t.FailNow()
+	}
+	_, ok := instance.FieldValues[parentIndex].(Int)
+	if !ok {
+		t.Logf("Expected the inherited field to hold an int, got %s\n",
+			instance.FieldValues[parentIndex])
+		t.Fail()
+	}
+}
+
+func TestClassCircularity(t *testing.T) {
+	dir := t.TempDir()
+	writeClass := func(b *builder.ClassBuilder) {
+		content, e := b.Bytes()
+		if e != nil {
+			t.Logf("Failed building class %s: %s\n", b.Name(), e)
+			t.FailNow()
+		}
+		e = ioutil.WriteFile(filepath.Join(dir, b.Name()+".class"), content,
+			0644)
+		if e != nil {
+			t.Logf("Failed writing class %s: %s\n", b.Name(), e)
+			t.FailNow()
+		}
+	}
+	// A and B are each other's superclass, and I is its own superinterface.
+	writeClass(builder.NewClassBuilder("A", "B"))
+	writeClass(builder.NewClassBuilder("B", "A"))
+	b := builder.NewClassBuilder("I", "java/lang/Object")
+	b.AddInterface("I")
+	writeClass(b)
+	writeClass(builder.NewClassBuilder("C", "A"))
+	for _, name := range []string{"A", "B", "I", "C"} {
+		jvm := NewJVM()
+		jvm.ClassPath = append(jvm.ClassPath, DirectoryClassSource(dir))
+		_, e := jvm.GetOrLoadClass(name)
+		var circularityError ClassCircularityError
+		if !errors.As(e, &circularityError) {
+			t.Logf("Expected a ClassCircularityError loading %s, got %v\n",
+				name, e)
+			t.Fail()
+			continue
+		}
+		if len(jvm.Classes) != 0 {
+			t.Logf("Loading %s left %d classes loaded\n", name,
+				len(jvm.Classes))
+			t.Fail()
+		}
+	}
+}
@@ -8,25 +8,6 @@ import (
 	"github.com/yalue/bs_jvm/class_file"
 )
 
-// Used to refer to a string literal (object).
-type StringObject string
-
-func (s *StringObject) IsPrimitive() bool {
-	return false
-}
-
-func (s *StringObject) TypeName() string {
-	return "String"
-}
-
-func (s *StringObject) Value() string {
-	return string(*s)
-}
-
-func (s *StringObject) String() string {
-	return fmt.Sprintf("%q", string(*s))
-}
-
 // Holds a method type descriptor, which is a UTF-8 string. Implements the
 // Object interface.
 type MethodType string
@@ -201,9 +182,12 @@ func convertFieldOrMethodRefConstantToObject(class *Class,
 	if e != nil {
 		return nil, fmt.Errorf("Couldn't get class name for field info: %s", e)
 	}
-	// TODO: May need to "load" classes here if the referenced class isn't
-	// loaded yet.
-	fieldClass, e := class.ParentJVM.GetClass(string(className))
+	// Methods invoked on arrays, such as clone(), are resolved as if they
+	// were invoked on java/lang/Object.
+	if (len(className) != 0) && (className[0] == '[') {
+		className = []byte("java/lang/Object")
+	}
+	fieldClass, e := class.ParentJVM.GetOrLoadClass(string(className))
 	if e != nil {
 		return nil, e
 	}
@@ -323,14 +307,14 @@ func ConvertConstantToObject(class *Class,
 		if e != nil {
 			return nil, fmt.Errorf("Failed getting string constant: %s", e)
 		}
-		tmp := StringObject(stringValue)
-		return &tmp, nil
+		s := NewStringObjectFromUTF16(DecodeModifiedUTF8(stringValue))
+		return class.ParentJVM.InternString(s), nil
 	case *class_file.ConstantClassInfo:
 		className, e := class.File.GetUTF8Constant(v.NameIndex)
 		if e != nil {
 			return nil, fmt.Errorf("Failed getting class name: %s", e)
 		}
-		return class.ParentJVM.GetClass(string(className))
+		return class.ParentJVM.GetOrLoadClass(string(className))
 	case *class_file.ConstantMethodTypeInfo:
 		descriptor, e := class.File.GetUTF8Constant(v.DescriptorIndex)
 		if e != nil {
@@ -345,6 +329,8 @@ func ConvertConstantToObject(class *Class,
 		return convertFieldOrMethodRefConstantToObject(class, constant)
 	case *class_file.ConstantMethodInfo:
 		return convertFieldOrMethodRefConstantToObject(class, constant)
+	case *class_file.ConstantInterfaceMethodInfo:
+		return convertFieldOrMethodRefConstantToObject(class, constant)
 	}
 	return nil, fmt.Errorf("Object conversion for constant %s not implemented",
 		constant)
@@ -3,11 +3,16 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/yalue/bs_jvm"
 	"github.com/yalue/bs_jvm/builtin_classes"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/assembly"
+	"io"
 	"os"
+	"path/filepath"
 )
 
 func NewJVMWithBuiltins() (*bs_jvm.JVM, error) {
@@ -22,20 +27,72 @@ func NewJVMWithBuiltins() (*bs_jvm.JVM, error) {
 	return j, nil
 }
 
+// Parses the class file and prints it using the given function, without
+// loading it into a JVM.
+func printClassFile(filename string, print func(io.Writer,
+	*class_file.Class) error) int {
+	f, e := os.Open(filename)
+	if e != nil {
+		fmt.Printf("Failed opening %s: %s\n", filename, e)
+		return 1
+	}
+	defer f.Close()
+	class, e := class_file.ParseClass(f)
+	if e != nil {
+		fmt.Printf("Failed parsing class: %s\n", e)
+		return 1
+	}
+	e = print(os.Stdout, class)
+	if e != nil {
+		fmt.Printf("Failed printing class: %s\n", e)
+		return 1
+	}
+	return 0
+}
+
+// Writes the class to the given writer as indented JSON. HTML escaping is
+// disabled, so names such as <init> are written as-is.
+func printJSON(w io.Writer, c *class_file.Class) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
 func run() int {
-	var filename string
+	var filename, format string
 	flag.StringVar(&filename, "filename", "",
 		"The name of the class file to view.")
+	flag.StringVar(&format, "format", "text", "The output format: \"text\" "+
+		"lists the JVM's view of each method, \"raw\" prints the contents "+
+		"of the class file similarly to javap, \"json\" prints the parsed "+
+		"class file as JSON, and \"assembly\" prints the class in the "+
+		"format used by the assemble command.")
 	flag.Parse()
 	if filename == "" {
 		fmt.Println("Invalid arguments. Run with -help for more information.")
 		return 1
 	}
+	switch format {
+	case "text":
+	case "raw":
+		return printClassFile(filename, printRawClass)
+	case "json":
+		return printClassFile(filename, printJSON)
+	case "assembly":
+		return printClassFile(filename, assembly.Disassemble)
+	default:
+		fmt.Printf("Unknown output format: %s\n", format)
+		return 1
+	}
 	jvm, e := NewJVMWithBuiltins()
 	if e != nil {
 		fmt.Printf("Failed initializing JVM: %s\n", e)
 		return 1
 	}
+	// Allow resolving references to other classes in the same directory.
+	jvm.ClassPath = append(jvm.ClassPath,
+		bs_jvm.DirectoryClassSource(filepath.Dir(filename)))
 	className, e := jvm.LoadClassFromFile(filename)
 	if e != nil {
 		fmt.Printf("Failed loading class: %s\n", e)
@@ -0,0 +1,1163 @@
+package main
+
+// This file contains the "raw" output format, which prints the contents of a
+// class file in a format similar to "javap -v -p -c". It reads the class file
+// directly rather than loading it into a JVM, so it works even if the class
+// refers to classes or methods that can't be resolved.
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Holds the state used when printing a class in the raw format.
+type rawPrinter struct {
+	class  *class_file.Class
+	output *bytes.Buffer
+	// The current indentation, prepended to every line.
+	indent string
+	// The number of argument slots used by the method being printed.
+	argumentSlots int
+}
+
+// Writes a single line of output, at the current indentation.
+func (p *rawPrinter) printf(format string, args ...interface{}) {
+	if format != "" {
+		p.output.WriteString(p.indent)
+	}
+	fmt.Fprintf(p.output, format, args...)
+	p.output.WriteByte('\n')
+}
+
+// Writes lines using printf with an additional level of indentation.
+func (p *rawPrinter) nested(f func()) {
+	previous := p.indent
+	p.indent += "  "
+	f()
+	p.indent = previous
+}
+
+// Returns the contents of the UTF-8 constant at the given index, or a
+// description of the problem if it's invalid.
+func (p *rawPrinter) utf8(index uint16) string {
+	toReturn, e := p.class.GetUTF8Constant(index)
+	if e != nil {
+		return fmt.Sprintf("<invalid UTF-8 constant #%d>", index)
+	}
+	return string(toReturn)
+}
+
+// Returns the name of the class constant at the given index, or a description
+// of the problem if it's invalid.
+func (p *rawPrinter) className(index uint16) string {
+	toReturn, e := p.class.GetClassConstantName(index)
+	if e != nil {
+		return fmt.Sprintf("<invalid class constant #%d>", index)
+	}
+	return string(toReturn)
+}
+
+// Escapes any special characters in the string, such as newlines, so it can
+// be printed on a single line.
+func escape(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
+
+// Returns the name of a field or method, quoted if it's a special method name
+// such as <init>, matching javap.
+func memberName(name string) string {
+	if strings.HasPrefix(name, "<") {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// Returns "name:descriptor" for the name and type constant at the given index.
+func (p *rawPrinter) nameAndType(index uint16) string {
+	c, e := p.class.GetConstant(index)
+	if e != nil {
+		return fmt.Sprintf("<invalid constant #%d>", index)
+	}
+	info, ok := c.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return fmt.Sprintf("<constant #%d isn't a name and type>", index)
+	}
+	return memberName(p.utf8(info.NameIndex)) + ":" +
+		p.utf8(info.DescriptorIndex)
+}
+
+// The names of method handle kinds, as printed by javap.
+var referenceKindNames = []string{"", "REF_getField", "REF_getStatic",
+	"REF_putField", "REF_putStatic", "REF_invokeVirtual", "REF_invokeStatic",
+	"REF_invokeSpecial", "REF_newInvokeSpecial", "REF_invokeInterface"}
+
+// Returns the name of the method handle kind.
+func referenceKindName(kind class_file.MethodHandleReferenceKind) string {
+	if int(kind) < len(referenceKindNames) {
+		return referenceKindNames[kind]
+	}
+	return fmt.Sprintf("<invalid kind %d>", kind)
+}
+
+// Returns a symbolic description of the constant at the given index, such as
+// "java/io/PrintStream.println:(Ljava/lang/String;)V" for a method.
+func (p *rawPrinter) describe(index uint16) string {
+	c, e := p.class.GetConstant(index)
+	if e != nil {
+		return fmt.Sprintf("<invalid constant #%d>", index)
+	}
+	switch v := c.(type) {
+	case *class_file.ConstantUTF8Info:
+		return escape(string(v.Bytes))
+	case *class_file.ConstantIntegerInfo:
+		return strconv.Itoa(int(v.Value))
+	case *class_file.ConstantFloatInfo:
+		return strconv.FormatFloat(float64(v.Value), 'g', -1, 32) + "f"
+	case *class_file.ConstantLongInfo:
+		return strconv.FormatInt(v.Value, 10) + "l"
+	case *class_file.ConstantDoubleInfo:
+		return strconv.FormatFloat(v.Value, 'g', -1, 64) + "d"
+	case *class_file.ConstantClassInfo:
+		return p.utf8(v.NameIndex)
+	case *class_file.ConstantStringInfo:
+		return escape(p.utf8(v.StringIndex))
+	case *class_file.ConstantFieldInfo:
+		return p.className(v.ClassIndex) + "." +
+			p.nameAndType(v.NameAndTypeIndex)
+	case *class_file.ConstantMethodInfo:
+		return p.className(v.ClassIndex) + "." +
+			p.nameAndType(v.NameAndTypeIndex)
+	case *class_file.ConstantInterfaceMethodInfo:
+		return p.className(v.ClassIndex) + "." +
+			p.nameAndType(v.NameAndTypeIndex)
+	case *class_file.ConstantNameAndTypeInfo:
+		return p.nameAndType(index)
+	case *class_file.ConstantMethodHandleInfo:
+		return referenceKindName(v.ReferenceKind) + " " + p.describe(v.Index)
+	case *class_file.ConstantMethodTypeInfo:
+		return p.utf8(v.DescriptorIndex)
+	case *class_file.ConstantInvokeDynamicInfo:
+		return fmt.Sprintf("#%d:%s", v.BootstrapMethodAttributeIndex,
+			p.nameAndType(v.NameAndTypeIndex))
+	case *class_file.ConstantDynamicInfo:
+		return fmt.Sprintf("#%d:%s", v.BootstrapMethodAttributeIndex,
+			p.nameAndType(v.NameAndTypeIndex))
+	case *class_file.ConstantModuleInfo:
+		return p.utf8(v.NameIndex)
+	case *class_file.ConstantPackageInfo:
+		return p.utf8(v.NameIndex)
+	}
+	return fmt.Sprintf("<unknown constant type %d>", c.Tag())
+}
+
+// Returns the type name and operands of the constant, as shown in javap's
+// constant pool listing.
+func constantKind(c class_file.Constant) (string, string) {
+	switch v := c.(type) {
+	case *class_file.ConstantUTF8Info:
+		return "Utf8", escape(string(v.Bytes))
+	case *class_file.ConstantIntegerInfo:
+		return "Integer", strconv.Itoa(int(v.Value))
+	case *class_file.ConstantFloatInfo:
+		return "Float", strconv.FormatFloat(float64(v.Value), 'g', -1, 32) +
+			"f"
+	case *class_file.ConstantLongInfo:
+		return "Long", strconv.FormatInt(v.Value, 10) + "l"
+	case *class_file.ConstantDoubleInfo:
+		return "Double", strconv.FormatFloat(v.Value, 'g', -1, 64) + "d"
+	case *class_file.ConstantClassInfo:
+		return "Class", fmt.Sprintf("#%d", v.NameIndex)
+	case *class_file.ConstantStringInfo:
+		return "String", fmt.Sprintf("#%d", v.StringIndex)
+	case *class_file.ConstantFieldInfo:
+		return "Fieldref", fmt.Sprintf("#%d.#%d", v.ClassIndex,
+			v.NameAndTypeIndex)
+	case *class_file.ConstantMethodInfo:
+		return "Methodref", fmt.Sprintf("#%d.#%d", v.ClassIndex,
+			v.NameAndTypeIndex)
+	case *class_file.ConstantInterfaceMethodInfo:
+		return "InterfaceMethodref", fmt.Sprintf("#%d.#%d", v.ClassIndex,
+			v.NameAndTypeIndex)
+	case *class_file.ConstantNameAndTypeInfo:
+		return "NameAndType", fmt.Sprintf("#%d:#%d", v.NameIndex,
+			v.DescriptorIndex)
+	case *class_file.ConstantMethodHandleInfo:
+		return "MethodHandle", fmt.Sprintf("%d:#%d", v.ReferenceKind,
+			v.Index)
+	case *class_file.ConstantMethodTypeInfo:
+		return "MethodType", fmt.Sprintf("#%d", v.DescriptorIndex)
+	case *class_file.ConstantInvokeDynamicInfo:
+		return "InvokeDynamic", fmt.Sprintf("#%d:#%d",
+			v.BootstrapMethodAttributeIndex, v.NameAndTypeIndex)
+	case *class_file.ConstantDynamicInfo:
+		return "Dynamic", fmt.Sprintf("#%d:#%d",
+			v.BootstrapMethodAttributeIndex, v.NameAndTypeIndex)
+	case *class_file.ConstantModuleInfo:
+		return "Module", fmt.Sprintf("#%d", v.NameIndex)
+	case *class_file.ConstantPackageInfo:
+		return "Package", fmt.Sprintf("#%d", v.NameIndex)
+	}
+	return fmt.Sprintf("Unknown(%d)", c.Tag()), ""
+}
+
+// Formats a line containing an operand and a comment, aligning the comment.
+func withComment(text, comment string) string {
+	if len(text) < 40 {
+		text += strings.Repeat(" ", 40-len(text))
+	} else {
+		text += " "
+	}
+	return text + "// " + comment
+}
+
+// Prints the class' constant pool.
+func (p *rawPrinter) constantPool() {
+	p.printf("Constant pool:")
+	for i, c := range p.class.Constants {
+		if (i == 0) || (c == nil) {
+			continue
+		}
+		kind, operands := constantKind(c)
+		text := fmt.Sprintf("%5s = %-18s %s", "#"+strconv.Itoa(i), kind,
+			operands)
+		switch c.(type) {
+		case *class_file.ConstantUTF8Info, *class_file.ConstantIntegerInfo,
+			*class_file.ConstantFloatInfo, *class_file.ConstantLongInfo,
+			*class_file.ConstantDoubleInfo:
+			p.printf("%s", text)
+		default:
+			p.printf("%s", withComment(text, p.describe(uint16(i))))
+		}
+	}
+}
+
+// Formats a single verification type from a stack map frame.
+func (p *rawPrinter) verificationType(
+	v class_file.VerificationTypeInfo) string {
+	switch v.Tag {
+	case 0:
+		return "top"
+	case 1:
+		return "int"
+	case 2:
+		return "float"
+	case 3:
+		return "double"
+	case 4:
+		return "long"
+	case 5:
+		return "null"
+	case 6:
+		return "uninitialized_this"
+	case 7:
+		return "class " + p.className(v.Other)
+	case 8:
+		return fmt.Sprintf("uninitialized %d", v.Other)
+	}
+	return v.Tag.String()
+}
+
+// Formats a list of verification types, e.g. "[ int, long ]".
+func (p *rawPrinter) verificationTypes(
+	types []class_file.VerificationTypeInfo) string {
+	if len(types) == 0 {
+		return "[]"
+	}
+	names := make([]string, len(types))
+	for i, v := range types {
+		names[i] = p.verificationType(v)
+	}
+	return "[ " + strings.Join(names, ", ") + " ]"
+}
+
+// Prints the contents of a StackMapTable attribute.
+func (p *rawPrinter) stackMapTable(a *class_file.Attribute) {
+	frames, e := class_file.ParseStackMapTableAttribute(a)
+	if e != nil {
+		p.printf("StackMapTable: invalid: %s", e)
+		return
+	}
+	p.printf("StackMapTable: number_of_entries = %d", len(frames))
+	p.nested(func() {
+		for _, f := range frames {
+			p.printf("frame_type = %d /* %s */", f.FrameType(), f.FrameType())
+			p.nested(func() {
+				switch v := f.(type) {
+				case *class_file.SameStackMapFrame:
+				case *class_file.OneItemStackMapFrame:
+					p.printf("stack = %s", p.verificationTypes(
+						[]class_file.VerificationTypeInfo{v.Info}))
+				case *class_file.OneItemStackMapFrameExtended:
+					p.printf("offset_delta = %d", f.OffsetDelta())
+					p.printf("stack = %s", p.verificationTypes(
+						[]class_file.VerificationTypeInfo{v.Info}))
+				case *class_file.AppendStackMapFrame:
+					p.printf("offset_delta = %d", f.OffsetDelta())
+					p.printf("locals = %s", p.verificationTypes(v.Locals))
+				case *class_file.FullStackMapFrame:
+					p.printf("offset_delta = %d", f.OffsetDelta())
+					p.printf("locals = %s", p.verificationTypes(v.Locals))
+					p.printf("stack = %s", p.verificationTypes(v.Stack))
+				default:
+					p.printf("offset_delta = %d", f.OffsetDelta())
+				}
+			})
+		}
+	})
+}
+
+// Formats an annotation's element value.
+func (p *rawPrinter) elementValue(v class_file.ElementValue) string {
+	switch v.Tag() {
+	case 's':
+		return strconv.Quote(p.utf8(v.Index()))
+	case 'c':
+		return "class " + p.utf8(v.Index())
+	case 'e':
+		enum := v.(*class_file.EnumElementValue)
+		return p.utf8(enum.TypeNameIndex) + "." + p.utf8(enum.ConstNameIndex)
+	case '@':
+		return p.annotation(v.(*class_file.AnnotationElementValue).Value)
+	case '[':
+		values := v.(*class_file.ArrayElementValue).Values
+		formatted := make([]string, len(values))
+		for i, value := range values {
+			formatted[i] = p.elementValue(value)
+		}
+		return "[" + strings.Join(formatted, ",") + "]"
+	}
+	return p.describe(v.Index())
+}
+
+// Formats a list of element-value pairs, e.g. "(name=1,other=2)".
+func (p *rawPrinter) elementValuePairs(
+	pairs []class_file.ElementValuePair) string {
+	formatted := make([]string, len(pairs))
+	for i, pair := range pairs {
+		formatted[i] = p.utf8(pair.ElementNameIndex) + "=" +
+			p.elementValue(pair.Value)
+	}
+	return "(" + strings.Join(formatted, ",") + ")"
+}
+
+// Formats an annotation, e.g. "Ljava/lang/Deprecated;()".
+func (p *rawPrinter) annotation(a *class_file.Annotation) string {
+	return p.utf8(a.NameIndex) + p.elementValuePairs(a.ElementValuePairs)
+}
+
+// Prints the annotations in a list, numbered in order.
+func (p *rawPrinter) annotationList(annotations []*class_file.Annotation) {
+	p.nested(func() {
+		for i, a := range annotations {
+			p.printf("%d: %s", i, p.annotation(a))
+		}
+	})
+}
+
+// Prints a table of local variables, used by both the LocalVariableTable and
+// LocalVariableTypeTable attributes.
+func (p *rawPrinter) localVariables(name string, entries [][5]uint16) {
+	p.printf("%s:", name)
+	p.nested(func() {
+		p.printf("Start  Length  Slot  Name   Signature")
+		for _, v := range entries {
+			p.printf("%5d  %6d  %4d %5s   %s", v[0], v[1], v[4],
+				p.utf8(v[2]), p.utf8(v[3]))
+		}
+	})
+}
+
+// Prints an attribute of a class, field, method, or Code attribute.
+func (p *rawPrinter) attribute(a *class_file.Attribute) {
+	name := string(a.Name)
+	switch name {
+	case "Code":
+		p.code(a)
+	case "StackMapTable":
+		p.stackMapTable(a)
+	case "ConstantValue":
+		if len(a.Info) != 2 {
+			p.printf("ConstantValue: invalid length %d", len(a.Info))
+			return
+		}
+		index := (uint16(a.Info[0]) << 8) | uint16(a.Info[1])
+		c, e := p.class.GetConstant(index)
+		if e != nil {
+			p.printf("ConstantValue: <invalid constant #%d>", index)
+			return
+		}
+		kind, _ := constantKind(c)
+		if kind == "Integer" {
+			kind = "int"
+		}
+		p.printf("ConstantValue: %s %s", strings.ToLower(kind),
+			p.describe(index))
+	case "SourceFile":
+		index, e := class_file.ParseSourceFileAttribute(a)
+		if e != nil {
+			p.printf("SourceFile: invalid: %s", e)
+			return
+		}
+		p.printf("SourceFile: %q", p.utf8(index))
+	case "Signature":
+		index, e := class_file.ParseSignatureAttribute(a)
+		if e != nil {
+			p.printf("Signature: invalid: %s", e)
+			return
+		}
+		p.printf("%s", withComment(fmt.Sprintf("Signature: #%d", index),
+			p.utf8(index)))
+	case "Exceptions":
+		indices, e := class_file.ParseExceptionsAttribute(a)
+		if e != nil {
+			p.printf("Exceptions: invalid: %s", e)
+			return
+		}
+		p.printf("Exceptions:")
+		p.nested(func() {
+			for _, index := range indices {
+				p.printf("throws %s", p.className(index))
+			}
+		})
+	case "LineNumberTable":
+		entries, e := class_file.ParseLineNumberTableAttribute(a)
+		if e != nil {
+			p.printf("LineNumberTable: invalid: %s", e)
+			return
+		}
+		p.printf("LineNumberTable:")
+		p.nested(func() {
+			for _, entry := range entries {
+				p.printf("line %d: %d", entry.LineNumber, entry.StartPC)
+			}
+		})
+	case "LocalVariableTable":
+		entries, e := class_file.ParseLocalVariableTableAttribute(a)
+		if e != nil {
+			p.printf("LocalVariableTable: invalid: %s", e)
+			return
+		}
+		values := make([][5]uint16, len(entries))
+		for i, v := range entries {
+			values[i] = [5]uint16{v.StartPC, v.Length, v.NameIndex,
+				v.DescriptorIndex, v.Index}
+		}
+		p.localVariables(name, values)
+	case "LocalVariableTypeTable":
+		entries, e := class_file.ParseLocalVariableTypeTableAttribute(a)
+		if e != nil {
+			p.printf("LocalVariableTypeTable: invalid: %s", e)
+			return
+		}
+		values := make([][5]uint16, len(entries))
+		for i, v := range entries {
+			values[i] = [5]uint16{v.StartPC, v.Length, v.NameIndex,
+				v.SignatureIndex, v.Index}
+		}
+		p.localVariables(name, values)
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		annotations, e := class_file.ParseRuntimeAnnotationsAttribute(a)
+		if e != nil {
+			p.printf("%s: invalid: %s", name, e)
+			return
+		}
+		p.printf("%s:", name)
+		p.annotationList(annotations)
+	case "RuntimeVisibleParameterAnnotations",
+		"RuntimeInvisibleParameterAnnotations":
+		parameters, e := class_file.ParseParameterAnnotationsAttribute(a)
+		if e != nil {
+			p.printf("%s: invalid: %s", name, e)
+			return
+		}
+		p.printf("%s:", name)
+		p.nested(func() {
+			for i, annotations := range parameters {
+				p.printf("parameter %d:", i)
+				p.annotationList(annotations)
+			}
+		})
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		annotations, e := class_file.ParseTypeAnnotationsAttribute(a)
+		if e != nil {
+			p.printf("%s: invalid: %s", name, e)
+			return
+		}
+		p.printf("%s:", name)
+		p.nested(func() {
+			for i, v := range annotations {
+				p.printf("%d: %s%s /* target type 0x%02x */", i,
+					p.utf8(v.TypeIndex()),
+					p.elementValuePairs(v.ElementValuePairs()), v.Target())
+			}
+		})
+	case "AnnotationDefault":
+		value, e := class_file.ParseAnnotationDefaultAttribute(a)
+		if e != nil {
+			p.printf("AnnotationDefault: invalid: %s", e)
+			return
+		}
+		p.printf("AnnotationDefault:")
+		p.nested(func() {
+			p.printf("default_value: %s", p.elementValue(value))
+		})
+	case "InnerClasses":
+		classes, e := class_file.ParseInnerClassesAttribute(a)
+		if e != nil {
+			p.printf("InnerClasses: invalid: %s", e)
+			return
+		}
+		p.printf("InnerClasses:")
+		p.nested(func() {
+			for _, c := range classes {
+				text := fmt.Sprintf("#%d", c.InnerClassInfoIndex)
+				comment := p.className(c.InnerClassInfoIndex)
+				if c.OuterClassInfoIndex != 0 {
+					text += fmt.Sprintf(" of #%d", c.OuterClassInfoIndex)
+					comment += " of " + p.className(c.OuterClassInfoIndex)
+				}
+				if c.InnerNameIndex != 0 {
+					text = fmt.Sprintf("#%d=", c.InnerNameIndex) + text
+					comment = p.utf8(c.InnerNameIndex) + "=" + comment
+				}
+				flags := c.InnerClassAccessFlags.String()
+				if flags != "" {
+					text = flags + " " + text
+				}
+				p.printf("%s", withComment(text, comment))
+			}
+		})
+	case "BootstrapMethods":
+		methods, e := class_file.ParseBootstrapMethodsAttribute(a)
+		if e != nil {
+			p.printf("BootstrapMethods: invalid: %s", e)
+			return
+		}
+		p.printf("BootstrapMethods:")
+		p.nested(func() {
+			for i, m := range methods {
+				p.printf("%d: #%d %s", i, m.Reference, p.describe(m.Reference))
+				p.nested(func() {
+					p.printf("Method arguments:")
+					p.nested(func() {
+						for _, index := range m.Arguments {
+							p.printf("#%d %s", index, p.describe(index))
+						}
+					})
+				})
+			}
+		})
+	case "MethodParameters":
+		parameters, e := class_file.ParseMethodParametersAttribute(a)
+		if e != nil {
+			p.printf("MethodParameters: invalid: %s", e)
+			return
+		}
+		p.printf("MethodParameters:")
+		p.nested(func() {
+			p.printf("Name                           Flags")
+			for _, v := range parameters {
+				name := "<no name>"
+				if v.NameIndex != 0 {
+					name = p.utf8(v.NameIndex)
+				}
+				p.printf("%-30s %s", name,
+					class_file.MethodAccessFlags(v.AccessFlags))
+			}
+		})
+	case "Module":
+		module, e := class_file.ParseModuleAttribute(a)
+		if e != nil {
+			p.printf("Module: invalid: %s", e)
+			return
+		}
+		p.module(module)
+	case "ModuleMainClass":
+		index, e := class_file.ParseModuleMainClassAttribute(a)
+		if e != nil {
+			p.printf("ModuleMainClass: invalid: %s", e)
+			return
+		}
+		p.printf("%s", withComment(fmt.Sprintf("ModuleMainClass: #%d",
+			index), p.className(index)))
+	case "NestHost":
+		index, e := class_file.ParseNestHostAttribute(a)
+		if e != nil {
+			p.printf("NestHost: invalid: %s", e)
+			return
+		}
+		p.printf("NestHost: class %s", p.className(index))
+	case "ModulePackages", "NestMembers", "PermittedSubclasses":
+		var indices []uint16
+		var e error
+		switch name {
+		case "ModulePackages":
+			indices, e = class_file.ParseModulePackagesAttribute(a)
+		case "NestMembers":
+			indices, e = class_file.ParseNestMembersAttribute(a)
+		default:
+			indices, e = class_file.ParsePermittedSubclassesAttribute(a)
+		}
+		if e != nil {
+			p.printf("%s: invalid: %s", name, e)
+			return
+		}
+		p.printf("%s:", name)
+		p.nested(func() {
+			for _, index := range indices {
+				p.printf("%s", p.describe(index))
+			}
+		})
+	case "Record":
+		components, e := class_file.ParseRecordAttribute(a, p.class)
+		if e != nil {
+			p.printf("Record: invalid: %s", e)
+			return
+		}
+		p.printf("Record:")
+		p.nested(func() {
+			for _, c := range components {
+				declaration := p.utf8(c.DescriptorIndex)
+				t, _ := class_file.ParseFieldType([]byte(declaration))
+				signature := p.signature(c.Attributes)
+				if signature != nil {
+					generic, e := class_file.ParseFieldSignature(signature)
+					if e == nil {
+						t = generic
+					}
+				}
+				if t != nil {
+					declaration = javaName(t.String())
+				}
+				p.printf("%s %s;", declaration, p.utf8(c.NameIndex))
+				p.nested(func() {
+					p.printf("descriptor: %s", p.utf8(c.DescriptorIndex))
+					for _, a := range c.Attributes {
+						p.attribute(a)
+					}
+				})
+			}
+		})
+	default:
+		p.printf("%s: length = 0x%x", name, len(a.Info))
+	}
+}
+
+// Prints an index, along with an optional version string, for the module
+// itself or a required module.
+func (p *rawPrinter) moduleVersion(index uint16) {
+	if index == 0 {
+		p.printf("#0")
+		return
+	}
+	p.printf("%s", withComment(fmt.Sprintf("#%d", index), p.utf8(index)))
+}
+
+// Prints the exports or opens table of a Module attribute.
+func (p *rawPrinter) moduleExports(exports []class_file.ModuleExports,
+	kind string) {
+	p.printf("%s", withComment(fmt.Sprintf("%d", len(exports)), kind))
+	p.nested(func() {
+		for _, export := range exports {
+			p.printf("%s", withComment(fmt.Sprintf("#%d,%x", export.Index,
+				export.Flags), p.describe(export.Index)))
+			p.nested(func() {
+				for _, index := range export.ToIndices {
+					p.printf("%s", withComment(fmt.Sprintf("#%d", index),
+						"to "+p.describe(index)))
+				}
+			})
+		}
+	})
+}
+
+// Prints a Module attribute, in the same layout used by javap.
+func (p *rawPrinter) module(m *class_file.ModuleAttribute) {
+	p.printf("Module:")
+	p.nested(func() {
+		p.printf("%s", withComment(fmt.Sprintf("#%d,%x", m.NameIndex,
+			m.Flags), p.describe(m.NameIndex)))
+		p.moduleVersion(m.VersionIndex)
+		p.printf("%s", withComment(fmt.Sprintf("%d", len(m.Requires)),
+			"requires"))
+		p.nested(func() {
+			for _, r := range m.Requires {
+				p.printf("%s", withComment(fmt.Sprintf("#%d,%x",
+					r.RequiresIndex, r.RequiresFlags),
+					p.describe(r.RequiresIndex)))
+				p.moduleVersion(r.RequiresVersionIndex)
+			}
+		})
+		p.moduleExports(m.Exports, "exports")
+		p.moduleExports(m.Opens, "opens")
+		p.printf("%s", withComment(fmt.Sprintf("%d", len(m.Uses)), "uses"))
+		p.nested(func() {
+			for _, index := range m.Uses {
+				p.printf("%s", withComment(fmt.Sprintf("#%d", index),
+					p.describe(index)))
+			}
+		})
+		p.printf("%s", withComment(fmt.Sprintf("%d", len(m.Provides)),
+			"provides"))
+		p.nested(func() {
+			for _, provides := range m.Provides {
+				p.printf("%s", withComment(fmt.Sprintf("#%d",
+					provides.Index), p.describe(provides.Index)))
+				p.nested(func() {
+					for _, index := range provides.WithIndices {
+						p.printf("%s", withComment(fmt.Sprintf("#%d", index),
+							"with "+p.describe(index)))
+					}
+				})
+			}
+		})
+	})
+}
+
+// Reads a big-endian signed 32-bit integer.
+func readInt32(data []byte) int32 {
+	return int32((uint32(data[0]) << 24) | (uint32(data[1]) << 16) |
+		(uint32(data[2]) << 8) | uint32(data[3]))
+}
+
+// The names of the array types used by newarray, indexed by type.
+var arrayTypeNames = []string{"", "", "", "", "boolean", "char", "float",
+	"double", "byte", "short", "int", "long"}
+
+// Prints a tableswitch or lookupswitch instruction at the given offset.
+// Returns the offset of the next instruction.
+func (p *rawPrinter) switchInstruction(code []byte, offset int) (int, error) {
+	op := builder.Opcode(code[offset])
+	i := (offset + 4) &^ 3
+	readValue := func() (int32, error) {
+		if (i + 4) > len(code) {
+			return 0, fmt.Errorf("The %s at offset %d is truncated", op,
+				offset)
+		}
+		i += 4
+		return readInt32(code[i-4:]), nil
+	}
+	defaultTarget, e := readValue()
+	if e != nil {
+		return 0, e
+	}
+	first, e := readValue()
+	if e != nil {
+		return 0, e
+	}
+	var cases []string
+	if op == builder.Tableswitch {
+		last, e := readValue()
+		if e != nil {
+			return 0, e
+		}
+		p.printf("%5d: %s { // %d to %d", offset, op, first, last)
+		for n := int64(first); n <= int64(last); n++ {
+			relative, e := readValue()
+			if e != nil {
+				return 0, e
+			}
+			cases = append(cases, fmt.Sprintf("%11d: %d", n,
+				offset+int(relative)))
+		}
+	} else {
+		p.printf("%5d: %s { // %d", offset, op, first)
+		for n := int32(0); n < first; n++ {
+			key, e := readValue()
+			if e != nil {
+				return 0, e
+			}
+			relative, e := readValue()
+			if e != nil {
+				return 0, e
+			}
+			cases = append(cases, fmt.Sprintf("%11d: %d", key,
+				offset+int(relative)))
+		}
+	}
+	cases = append(cases, fmt.Sprintf("%11s: %d", "default",
+		offset+int(defaultTarget)))
+	p.nested(func() {
+		for _, c := range cases {
+			p.printf("%s", c)
+		}
+		p.printf("}")
+	})
+	return i, nil
+}
+
+// Returns true if the instruction's operand is a 16-bit constant pool index.
+func hasConstantOperand(op builder.Opcode) bool {
+	switch op {
+	case builder.Ldc_w, builder.Ldc2_w, builder.Getstatic, builder.Putstatic,
+		builder.Getfield, builder.Putfield, builder.Invokevirtual,
+		builder.Invokespecial, builder.Invokestatic, builder.Invokeinterface,
+		builder.Invokedynamic, builder.New, builder.Anewarray,
+		builder.Checkcast, builder.Instanceof, builder.Multianewarray:
+		return true
+	}
+	return false
+}
+
+// Prints the instruction at the given offset, and returns the offset of the
+// next instruction.
+func (p *rawPrinter) instruction(code []byte, offset int) (int, error) {
+	op := builder.Opcode(code[offset])
+	if !op.IsValid() {
+		return 0, fmt.Errorf("Invalid opcode 0x%02x at offset %d",
+			code[offset], offset)
+	}
+	if (op == builder.Tableswitch) || (op == builder.Lookupswitch) {
+		return p.switchInstruction(code, offset)
+	}
+	name := op.String()
+	operandBytes := op.OperandBytes()
+	start := offset + 1
+	if op == builder.Wide {
+		if start >= len(code) {
+			return 0, fmt.Errorf("Truncated wide instruction at %d", offset)
+		}
+		op = builder.Opcode(code[start])
+		name = "wide " + op.String()
+		start++
+		operandBytes = 2
+		if op == builder.Iinc {
+			operandBytes = 4
+		}
+	}
+	next := start + operandBytes
+	if next > len(code) {
+		return 0, fmt.Errorf("The %s at offset %d is truncated", op, offset)
+	}
+	operands := code[start:next]
+	u16 := func(i int) uint16 {
+		return (uint16(operands[i]) << 8) | uint16(operands[i+1])
+	}
+	text := fmt.Sprintf("%5d: %-13s", offset, name)
+	switch {
+	case op == builder.Ldc:
+		p.printf("%s", withComment(fmt.Sprintf("%s #%d", text, operands[0]),
+			p.describe(uint16(operands[0]))))
+		return next, nil
+	case hasConstantOperand(op):
+		operandText := fmt.Sprintf("#%d", u16(0))
+		if (op == builder.Invokeinterface) ||
+			(op == builder.Multianewarray) {
+			operandText += fmt.Sprintf(",  %d", operands[2])
+		} else if op == builder.Invokedynamic {
+			operandText += ",  0"
+		}
+		p.printf("%s", withComment(text+" "+operandText,
+			p.describe(u16(0))))
+		return next, nil
+	case ((op >= builder.Ifeq) && (op <= builder.Jsr)) ||
+		(op == builder.Ifnull) || (op == builder.Ifnonnull):
+		text += fmt.Sprintf(" %d", offset+int(int16(u16(0))))
+	case (op == builder.Goto_w) || (op == builder.Jsr_w):
+		text += fmt.Sprintf(" %d", offset+int(readInt32(operands)))
+	case op == builder.Iinc:
+		if operandBytes == 4 {
+			text += fmt.Sprintf(" %d, %d", u16(0), int16(u16(2)))
+		} else {
+			text += fmt.Sprintf(" %d, %d", operands[0], int8(operands[1]))
+		}
+	case op == builder.Bipush:
+		text += fmt.Sprintf(" %d", int8(operands[0]))
+	case op == builder.Sipush:
+		text += fmt.Sprintf(" %d", int16(u16(0)))
+	case op == builder.Newarray:
+		arrayType := int(operands[0])
+		if (arrayType < len(arrayTypeNames)) &&
+			(arrayTypeNames[arrayType] != "") {
+			text += " " + arrayTypeNames[arrayType]
+		} else {
+			text += fmt.Sprintf(" <invalid type %d>", arrayType)
+		}
+	case operandBytes == 2:
+		// Local variable indices in wide instructions.
+		text += fmt.Sprintf(" %d", u16(0))
+	case operandBytes == 1:
+		// Local variable indices.
+		text += fmt.Sprintf(" %d", operands[0])
+	}
+	p.printf("%s", strings.TrimRight(text, " "))
+	return next, nil
+}
+
+// Returns the number of local variable slots used by a method's arguments,
+// including "this" for non-static methods.
+func argumentSlots(m *class_file.Method) int {
+	toReturn := 0
+	if !m.Access.IsStatic() {
+		toReturn++
+	}
+	if m.Descriptor == nil {
+		return toReturn
+	}
+	for _, t := range m.Descriptor.ArgumentTypes {
+		switch t {
+		case class_file.PrimitiveFieldType('J'),
+			class_file.PrimitiveFieldType('D'):
+			toReturn += 2
+		default:
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Prints a Code attribute, including its instructions, exception table, and
+// nested attributes.
+func (p *rawPrinter) code(a *class_file.Attribute) {
+	code, e := class_file.ParseCodeAttribute(a, p.class)
+	if e != nil {
+		p.printf("Code: invalid: %s", e)
+		return
+	}
+	p.printf("Code:")
+	p.nested(func() {
+		p.printf("stack=%d, locals=%d, args_size=%d", code.MaxStack,
+			code.MaxLocals, p.argumentSlots)
+		offset := 0
+		for offset < len(code.Code) {
+			offset, e = p.instruction(code.Code, offset)
+			if e != nil {
+				p.printf("<%s>", e)
+				break
+			}
+		}
+		if len(code.ExceptionTable) != 0 {
+			p.printf("Exception table:")
+			p.nested(func() {
+				p.printf(" from    to  target type")
+				for _, entry := range code.ExceptionTable {
+					catchType := "any"
+					if entry.CatchType != 0 {
+						catchType = "Class " + p.className(entry.CatchType)
+					}
+					p.printf("%5d %5d %5d   %s", entry.StartPC, entry.EndPC,
+						entry.HandlerPC, catchType)
+				}
+			})
+		}
+		for _, attribute := range code.Attributes {
+			p.attribute(attribute)
+		}
+	})
+}
+
+// Converts a class name from its internal form to the form used in Java, e.g.
+// "java/lang/String" to "java.lang.String".
+func javaName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// Returns the contents of the Signature attribute in the list, or nil if
+// there isn't a valid one.
+func (p *rawPrinter) signature(attributes []*class_file.Attribute) []byte {
+	for _, a := range attributes {
+		if string(a.Name) != "Signature" {
+			continue
+		}
+		index, e := class_file.ParseSignatureAttribute(a)
+		if e != nil {
+			return nil
+		}
+		toReturn, e := p.class.GetUTF8Constant(index)
+		if e != nil {
+			return nil
+		}
+		return toReturn
+	}
+	return nil
+}
+
+// Returns a Java-like declaration of the field, e.g. "private static int x".
+// Uses the field's generic type, if it has a valid signature.
+func (p *rawPrinter) fieldDeclaration(f *class_file.Field) string {
+	toReturn := f.Access.String()
+	if toReturn != "" {
+		toReturn += " "
+	}
+	t := f.Descriptor
+	signature := p.signature(f.Attributes)
+	if signature != nil {
+		generic, e := class_file.ParseFieldSignature(signature)
+		if e == nil {
+			t = generic
+		}
+	}
+	if t != nil {
+		toReturn += javaName(t.String()) + " "
+	}
+	return toReturn + string(f.Name)
+}
+
+// Returns the method's return type and name, as shown in its declaration.
+// Like javap, constructors are named after their class, and don't have a
+// return type.
+func (p *rawPrinter) returnTypeAndName(returnType string, name []byte) string {
+	if string(name) == "<init>" {
+		return javaName(p.className(p.class.ThisClass))
+	}
+	return javaName(returnType) + " " + string(name)
+}
+
+// Returns a Java-like declaration of a method with the given generic
+// signature, e.g. "<T> T get(java.util.List<T>) throws E", without the access
+// flags.
+func (p *rawPrinter) genericMethodDeclaration(name []byte,
+	s *class_file.MethodSignature) string {
+	toReturn := ""
+	if len(s.TypeParameters) != 0 {
+		toReturn = javaName(class_file.TypeParametersString(
+			s.TypeParameters)) + " "
+	}
+	toReturn += fmt.Sprintf("%s(%s)", p.returnTypeAndName(s.ReturnString(),
+		name), javaName(s.ArgumentsString()))
+	if len(s.Throws) == 0 {
+		return toReturn
+	}
+	thrown := make([]string, len(s.Throws))
+	for i, t := range s.Throws {
+		thrown[i] = javaName(t.String())
+	}
+	return toReturn + " throws " + strings.Join(thrown, ", ")
+}
+
+// Returns a Java-like declaration of the method, e.g. "public void run()".
+// Uses the method's generic signature, if it has a valid one.
+func (p *rawPrinter) methodDeclaration(m *class_file.Method) string {
+	if string(m.Name) == "<clinit>" {
+		// Like javap, don't show static initializers' name or access flags.
+		return "static {}"
+	}
+	toReturn := m.Access.String()
+	if toReturn != "" {
+		toReturn += " "
+	}
+	signature := p.signature(m.Attributes)
+	if signature != nil {
+		generic, e := class_file.ParseMethodSignature(signature)
+		if e == nil {
+			return toReturn + p.genericMethodDeclaration(m.Name, generic)
+		}
+	}
+	if m.Descriptor == nil {
+		return toReturn + string(m.Name)
+	}
+	return fmt.Sprintf("%s%s(%s)", toReturn, p.returnTypeAndName(
+		m.Descriptor.ReturnString(), m.Name),
+		javaName(m.Descriptor.ArgumentsString()))
+}
+
+// Returns the type parameters, superclass, and interfaces from the class'
+// generic signature, e.g. "<T> extends java.util.ArrayList<T>". Returns an
+// empty string if the class doesn't have a valid signature.
+func (p *rawPrinter) classSignature() string {
+	signature := p.signature(p.class.Attributes)
+	if signature == nil {
+		return ""
+	}
+	s, e := class_file.ParseClassSignature(signature)
+	if e != nil {
+		return ""
+	}
+	toReturn := javaName(class_file.TypeParametersString(s.TypeParameters))
+	interfaces := make([]string, len(s.Interfaces))
+	for i, t := range s.Interfaces {
+		interfaces[i] = javaName(t.String())
+	}
+	// Interfaces list their superinterfaces after "extends", and their
+	// superclass is always Object.
+	if p.class.Access.IsInterface() {
+		if len(interfaces) != 0 {
+			toReturn += " extends " + strings.Join(interfaces, ", ")
+		}
+		return toReturn
+	}
+	toReturn += " extends " + javaName(s.Superclass.String())
+	if len(interfaces) != 0 {
+		toReturn += " implements " + strings.Join(interfaces, ", ")
+	}
+	return toReturn
+}
+
+// Prints the class' version, access flags, and names.
+func (p *rawPrinter) header() {
+	c := p.class
+	name := p.className(c.ThisClass)
+	kind := "class"
+	if c.Access.IsInterface() {
+		kind = "interface"
+	}
+	p.printf("%s %s%s", kind, name, p.classSignature())
+	p.nested(func() {
+		p.printf("minor version: %d", c.MinorVersion)
+		p.printf("major version: %d", c.MajorVersion)
+		p.printf("flags: (0x%04x) %s", uint16(c.Access), c.Access)
+		p.printf("%s", withComment(fmt.Sprintf("this_class: #%d",
+			c.ThisClass), name))
+		if c.SuperClass != 0 {
+			p.printf("%s", withComment(fmt.Sprintf("super_class: #%d",
+				c.SuperClass), p.className(c.SuperClass)))
+		} else {
+			p.printf("super_class: #0")
+		}
+		for _, index := range c.Interfaces {
+			p.printf("%s", withComment(fmt.Sprintf("interface: #%d", index),
+				p.className(index)))
+		}
+		p.printf("interfaces: %d, fields: %d, methods: %d, attributes: %d",
+			len(c.Interfaces), len(c.Fields), len(c.Methods),
+			len(c.Attributes))
+	})
+}
+
+// Writes the class to the given writer in the raw format.
+func printRawClass(w io.Writer, c *class_file.Class) error {
+	p := &rawPrinter{
+		class:  c,
+		output: &bytes.Buffer{},
+	}
+	p.header()
+	p.constantPool()
+	p.printf("{")
+	p.nested(func() {
+		for _, f := range c.Fields {
+			p.printf("%s;", p.fieldDeclaration(f))
+			p.nested(func() {
+				p.printf("descriptor: %s", p.utf8(f.DescriptorIndex))
+				p.printf("flags: (0x%04x) %s", uint16(f.Access), f.Access)
+				for _, a := range f.Attributes {
+					p.attribute(a)
+				}
+			})
+			p.printf("")
+		}
+		for _, m := range c.Methods {
+			p.printf("%s;", p.methodDeclaration(m))
+			p.nested(func() {
+				p.printf("descriptor: %s", p.utf8(m.DescriptorIndex))
+				p.printf("flags: (0x%04x) %s", uint16(m.Access), m.Access)
+				p.argumentSlots = argumentSlots(m)
+				for _, a := range m.Attributes {
+					p.attribute(a)
+				}
+			})
+			p.printf("")
+		}
+	})
+	p.printf("}")
+	for _, a := range c.Attributes {
+		p.attribute(a)
+	}
+	_, e := w.Write(p.output.Bytes())
+	return e
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"github.com/yalue/bs_jvm/class_file"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Compares the raw output for each of the test class files against the
+// expected output in test_data. Like javap, the output includes comments
+// describing each instruction's constant pool operand.
+func TestPrintRawClass(t *testing.T) {
+	for _, name := range []string{"RandomDots", "RandomDotsSimple"} {
+		f, e := os.Open("../class_file/test_data/" + name + ".class")
+		if e != nil {
+			t.Logf("Failed opening %s: %s\n", name, e)
+			t.FailNow()
+		}
+		class, e := class_file.ParseClass(f)
+		f.Close()
+		if e != nil {
+			t.Logf("Failed parsing %s: %s\n", name, e)
+			t.FailNow()
+		}
+		output := &bytes.Buffer{}
+		e = printRawClass(output, class)
+		if e != nil {
+			t.Logf("Failed printing %s: %s\n", name, e)
+			t.FailNow()
+		}
+		expected, e := ioutil.ReadFile("test_data/" + name + ".raw.txt")
+		if e != nil {
+			t.Logf("Failed reading expected output for %s: %s\n", name, e)
+			t.FailNow()
+		}
+		if output.String() == string(expected) {
+			continue
+		}
+		t.Fail()
+		actualLines := strings.Split(output.String(), "\n")
+		expectedLines := strings.Split(string(expected), "\n")
+		for i := range expectedLines {
+			if (i >= len(actualLines)) || (actualLines[i] != expectedLines[i]) {
+				t.Logf("Output for %s differs at line %d. Expected:\n%s\n",
+					name, i+1, expectedLines[i])
+				if i < len(actualLines) {
+					t.Logf("Got:\n%s\n", actualLines[i])
+				}
+				break
+			}
+		}
+	}
+}
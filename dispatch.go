@@ -0,0 +1,585 @@
+package bs_jvm
+
+// This file contains code for resolving symbolic method references and for
+// selecting which method to actually invoke, following sections 5.4.3.3,
+// 5.4.3.4, and 5.4.6 of the JVM spec, along with the nest-based access checks
+// for private methods and fields.
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"strings"
+	"sync"
+)
+
+// Returns true if this class is an interface.
+func (c *Class) IsInterface() bool {
+	return c.AccessFlags.IsInterface()
+}
+
+// Returns true if this class represents a primitive type, such as int.class.
+func (c *Class) IsPrimitiveType() bool {
+	return c.primitiveType != 0
+}
+
+// Returns the class representing the given primitive type, such as int.class,
+// creating it if this is the first time it's been needed. Like Java, the
+// class is named after the type's keyword, and has no superclass, fields, or
+// methods.
+func (j *JVM) GetPrimitiveClass(t class_file.PrimitiveFieldType) *Class {
+	j.primitiveClassesLock.Lock()
+	defer j.primitiveClassesLock.Unlock()
+	toReturn := j.primitiveClasses[t]
+	if toReturn != nil {
+		return toReturn
+	}
+	toReturn = &Class{
+		ParentJVM: j,
+		Name:      []byte(t.String()),
+		Methods:   make(map[string]*Method),
+		FieldInfo: make(map[string]*ClassField),
+		// public final abstract
+		AccessFlags:   0x0411,
+		primitiveType: t,
+	}
+	// There's nothing to initialize.
+	toReturn.setInitState(classInitialized)
+	if j.primitiveClasses == nil {
+		j.primitiveClasses = make(map[class_file.PrimitiveFieldType]*Class)
+	}
+	j.primitiveClasses[t] = toReturn
+	return toReturn
+}
+
+// Returns true if this is the class of an array type, such as int[].class.
+func (c *Class) IsArray() bool {
+	return c.arrayType != nil
+}
+
+// Returns the type of array this class represents, or nil if it isn't the
+// class of an array.
+func (c *Class) ArrayType() *class_file.ArrayType {
+	return c.arrayType
+}
+
+// Returns the class of the given array type, such as int[].class, creating it
+// if this is the first time it's been needed. Like Java, the class is named
+// after the array's descriptor, e.g. "[I", extends java/lang/Object, and
+// implements java/lang/Cloneable and java/io/Serializable. Returns an error if
+// any of those classes haven't been loaded.
+func (j *JVM) GetArrayClass(t *class_file.ArrayType) (*Class, error) {
+	name := class_file.FieldTypeDescriptor(t)
+	j.arrayClassesLock.Lock()
+	defer j.arrayClassesLock.Unlock()
+	toReturn := j.arrayClasses[name]
+	if toReturn != nil {
+		return toReturn, nil
+	}
+	super, e := j.GetClass("java/lang/Object")
+	if e != nil {
+		return nil, e
+	}
+	interfaces := make([]*Class, 0, 2)
+	for _, n := range []string{"java/lang/Cloneable", "java/io/Serializable"} {
+		c, e := j.GetClass(n)
+		if e != nil {
+			return nil, e
+		}
+		interfaces = append(interfaces, c)
+	}
+	toReturn = &Class{
+		ParentJVM:  j,
+		Name:       []byte(name),
+		Super:      super,
+		Interfaces: interfaces,
+		Methods:    make(map[string]*Method),
+		FieldInfo:  make(map[string]*ClassField),
+		// public final abstract
+		AccessFlags: 0x0411,
+		arrayType:   t,
+	}
+	// There's nothing to initialize.
+	toReturn.setInitState(classInitialized)
+	if j.arrayClasses == nil {
+		j.arrayClasses = make(map[string]*Class)
+	}
+	j.arrayClasses[name] = toReturn
+	return toReturn, nil
+}
+
+// Returns the class' name in the format used by Java's Class.getName, e.g.
+// "java.lang.String".
+func (c *Class) JavaName() string {
+	return binaryClassName(string(c.Name))
+}
+
+// Returns the name of the package containing the class, e.g. "java/lang" for
+// java/lang/String. Classes in the default package return an empty string.
+func (c *Class) PackageName() string {
+	name := string(c.Name)
+	slash := strings.LastIndexByte(name, '/')
+	if slash < 0 {
+		return ""
+	}
+	return name[:slash]
+}
+
+// Returns true if the class' NestMembers attribute contains the named class.
+func (c *Class) hasNestMember(name []byte) bool {
+	if c.File == nil {
+		return false
+	}
+	for _, a := range c.File.Attributes {
+		if string(a.Name) != "NestMembers" {
+			continue
+		}
+		indices, e := class_file.ParseNestMembersAttribute(a)
+		if e != nil {
+			return false
+		}
+		for _, index := range indices {
+			member, e := c.File.GetClassConstantName(index)
+			if (e == nil) && bytes.Equal(member, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Looks up the class named by c's NestHost attribute, following section 5.4.4
+// of the JVM spec. Returns c if it doesn't have a NestHost attribute, or if
+// the host can't be loaded, is in a different package, or doesn't list c as
+// one of its members.
+func (c *Class) findNestHost() *Class {
+	if c.File == nil {
+		return c
+	}
+	for _, a := range c.File.Attributes {
+		if string(a.Name) != "NestHost" {
+			continue
+		}
+		index, e := class_file.ParseNestHostAttribute(a)
+		if e != nil {
+			return c
+		}
+		name, e := c.File.GetClassConstantName(index)
+		if e != nil {
+			return c
+		}
+		host, e := c.ParentJVM.GetOrLoadClass(string(name))
+		if e != nil {
+			return c
+		}
+		if host.PackageName() != c.PackageName() {
+			return c
+		}
+		if !host.hasNestMember(c.Name) {
+			return c
+		}
+		return host
+	}
+	return c
+}
+
+// Returns the host of the nest that c belongs to. Classes that aren't
+// explicitly members of another class' nest are the hosts of their own nests.
+func (c *Class) NestHost() *Class {
+	c.nestHostLock.Lock()
+	defer c.nestHostLock.Unlock()
+	if c.nestHost == nil {
+		c.nestHost = c.findNestHost()
+	}
+	return c.nestHost
+}
+
+// Returns true if c and other belong to the same nest, and can therefore
+// access each other's private members.
+func (c *Class) IsNestmateOf(other *Class) bool {
+	if c == other {
+		return true
+	}
+	return c.NestHost() == other.NestHost()
+}
+
+// Checks whether code in one class may access a private member of another
+// class, which is only allowed if both classes belong to the same nest. Like
+// Java, the check is done when an instruction referring to the member runs,
+// rather than when the instruction's method is optimized, so it only throws
+// an IllegalAccessError if the instruction is executed. The result is cached,
+// so the check, which may need to load the classes' nest hosts, only runs
+// once. A nil *privateAccessCheck is used for members that aren't private,
+// which don't need to be checked.
+type privateAccessCheck struct {
+	// The class containing the instruction accessing the member.
+	from *Class
+	// The class declaring the member.
+	owner *Class
+	// Describes the member in error messages, e.g. "method Example.run".
+	member string
+	// Set the first time the check runs. Hold lock when accessing done or
+	// err.
+	done bool
+	err  error
+	lock sync.Mutex
+}
+
+// Returns the check needed before code in c invokes the resolved method m, or
+// nil if m isn't private.
+func (c *Class) getMethodAccessCheck(m *Method) *privateAccessCheck {
+	if !m.IsPrivate() {
+		return nil
+	}
+	return &privateAccessCheck{
+		from:   c,
+		owner:  m.ContainingClass,
+		member: fmt.Sprintf("method %s.%s", m.ContainingClass.Name, m.Name),
+	}
+}
+
+// Returns the check needed before code in c accesses the named field, declared
+// in the owner class, or nil if the field isn't private.
+func (c *Class) getFieldAccessCheck(owner *Class,
+	name string) *privateAccessCheck {
+	info := owner.FieldInfo[name]
+	if (info == nil) || !info.FileField.Access.IsPrivate() {
+		return nil
+	}
+	return &privateAccessCheck{
+		from:   c,
+		owner:  owner,
+		member: fmt.Sprintf("field %s.%s", owner.Name, name),
+	}
+}
+
+// Returns an IllegalAccessError if the class isn't allowed to access the
+// member. Always returns nil if a is nil.
+func (a *privateAccessCheck) check() error {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.done {
+		return a.err
+	}
+	if !a.from.IsNestmateOf(a.owner) {
+		a.err = IllegalAccessError(fmt.Sprintf("%s can't access private %s",
+			a.from.Name, a.member))
+	}
+	a.done = true
+	return a.err
+}
+
+// Returns true if the method is private.
+func (m *Method) IsPrivate() bool {
+	return (m.AccessFlags & 0x0002) != 0
+}
+
+// Returns true if the method is abstract.
+func (m *Method) IsAbstract() bool {
+	return m.AccessFlags.IsAbstract()
+}
+
+// Returns the key used to look up the method in its class' Methods map.
+func (m *Method) Key() string {
+	return fmt.Sprintf("%s %s(%s)", m.Types.ReturnString(), m.Name,
+		m.Types.ArgumentsString())
+}
+
+// Returns every superinterface of c, including those inherited from its
+// superclasses. Each interface appears only once.
+func (c *Class) allSuperinterfaces() []*Class {
+	toReturn := make([]*Class, 0, 8)
+	seen := make(map[*Class]bool)
+	var visit func(iface *Class)
+	visit = func(iface *Class) {
+		if seen[iface] {
+			return
+		}
+		seen[iface] = true
+		toReturn = append(toReturn, iface)
+		for _, parent := range iface.Interfaces {
+			visit(parent)
+		}
+	}
+	for current := c; current != nil; current = current.Super {
+		for _, iface := range current.Interfaces {
+			visit(iface)
+		}
+	}
+	return toReturn
+}
+
+// Returns the maximally-specific superinterface methods of c with the given
+// key. These are the non-private, non-static methods declared in c's
+// superinterfaces that aren't overridden by a method in a more specific
+// superinterface.
+func (c *Class) maximallySpecificMethods(key string) []*Method {
+	candidates := make([]*Method, 0, 2)
+	for _, iface := range c.allSuperinterfaces() {
+		m := iface.Methods[key]
+		if (m == nil) || m.IsPrivate() || m.IsStatic() {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	toReturn := make([]*Method, 0, len(candidates))
+	for _, m := range candidates {
+		overridden := false
+		for _, other := range candidates {
+			if other == m {
+				continue
+			}
+			if other.ContainingClass.IsSubclassOf(m.ContainingClass) {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			toReturn = append(toReturn, m)
+		}
+	}
+	return toReturn
+}
+
+// Picks a method from the maximally-specific superinterface methods of c, as
+// done during method resolution. Prefers the single non-abstract method, if
+// there is one. Returns nil if there aren't any candidates.
+func (c *Class) resolveSuperinterfaceMethod(key string) *Method {
+	candidates := c.maximallySpecificMethods(key)
+	if len(candidates) == 0 {
+		return nil
+	}
+	var nonAbstract *Method
+	nonAbstractCount := 0
+	for _, m := range candidates {
+		if !m.IsAbstract() {
+			nonAbstract = m
+			nonAbstractCount++
+		}
+	}
+	if nonAbstractCount == 1 {
+		return nonAbstract
+	}
+	return candidates[0]
+}
+
+// Resolves a method referred to by a Methodref constant naming this class.
+// Searches this class and its superclasses, and then its superinterfaces.
+// Returns a MethodNotFoundError if the method can't be found.
+func (c *Class) ResolveMethod(key string) (*Method, error) {
+	if c.IsInterface() {
+		return nil, IncompatibleClassChangeError(fmt.Sprintf("Expected %s "+
+			"to be a class, but it is an interface", c.Name))
+	}
+	for current := c; current != nil; current = current.Super {
+		m := current.Methods[key]
+		if m != nil {
+			return m, nil
+		}
+	}
+	m := c.resolveSuperinterfaceMethod(key)
+	if m == nil {
+		return nil, MethodNotFoundError(string(c.Name) + "." + key)
+	}
+	return m, nil
+}
+
+// Like ResolveMethod, but for an InterfaceMethodref constant. Searches this
+// interface, then java/lang/Object, and then the superinterfaces.
+func (c *Class) ResolveInterfaceMethod(key string) (*Method, error) {
+	if !c.IsInterface() {
+		return nil, IncompatibleClassChangeError(fmt.Sprintf("Expected %s "+
+			"to be an interface, but it is a class", c.Name))
+	}
+	m := c.Methods[key]
+	if m != nil {
+		return m, nil
+	}
+	// The superclass of an interface is always java/lang/Object, but only
+	// its public, non-static methods are inherited by interfaces.
+	if c.Super != nil {
+		m = c.Super.Methods[key]
+		if (m != nil) && ((m.AccessFlags & 1) != 0) && !m.IsStatic() {
+			return m, nil
+		}
+	}
+	m = c.resolveSuperinterfaceMethod(key)
+	if m == nil {
+		return nil, MethodNotFoundError(string(c.Name) + "." + key)
+	}
+	return m, nil
+}
+
+// Returns true if the method m, declared in a class, can override the
+// resolved method, as described in section 5.4.5 of the JVM spec.
+func canOverride(m, resolved *Method) bool {
+	if m == resolved {
+		return true
+	}
+	if m.IsPrivate() || m.IsStatic() {
+		return false
+	}
+	// Public and protected methods can always be overridden.
+	if (resolved.AccessFlags & (0x0001 | 0x0004)) != 0 {
+		return true
+	}
+	if resolved.IsPrivate() {
+		return false
+	}
+	// Package-private methods can only be overridden within the same package.
+	// (Technically, this can also happen transitively via an intermediate
+	// method, but we don't bother with that case.)
+	return m.ContainingClass.PackageName() ==
+		resolved.ContainingClass.PackageName()
+}
+
+// Does the uncached part of SelectMethod.
+func (c *Class) selectMethod(resolved *Method) (*Method, error) {
+	if resolved.IsPrivate() {
+		return resolved, nil
+	}
+	key := resolved.Key()
+	for current := c; current != nil; current = current.Super {
+		m := current.Methods[key]
+		if (m != nil) && canOverride(m, resolved) {
+			if m.IsAbstract() {
+				return nil, AbstractMethodError(fmt.Sprintf("%s.%s is "+
+					"abstract", current.Name, key))
+			}
+			return m, nil
+		}
+	}
+	var selected *Method
+	for _, m := range c.maximallySpecificMethods(key) {
+		if m.IsAbstract() {
+			continue
+		}
+		if selected != nil {
+			return nil, IncompatibleClassChangeError(fmt.Sprintf("Multiple "+
+				"default methods for %s in %s", key, c.Name))
+		}
+		selected = m
+	}
+	if selected == nil {
+		return nil, AbstractMethodError(fmt.Sprintf("No implementation of "+
+			"%s in %s", key, c.Name))
+	}
+	return selected, nil
+}
+
+// Returns the method that should actually be invoked when invokevirtual or
+// invokeinterface is used with the given resolved method, and a receiver that
+// is an instance of this class. Results are cached, so this is only slow the
+// first time it's called for each method.
+func (c *Class) SelectMethod(resolved *Method) (*Method, error) {
+	c.selectedMethodsLock.Lock()
+	defer c.selectedMethodsLock.Unlock()
+	toReturn := c.selectedMethods[resolved]
+	if toReturn != nil {
+		return toReturn, nil
+	}
+	toReturn, e := c.selectMethod(resolved)
+	if e != nil {
+		return nil, e
+	}
+	if c.selectedMethods == nil {
+		c.selectedMethods = make(map[*Method]*Method)
+	}
+	c.selectedMethods[resolved] = toReturn
+	return toReturn, nil
+}
+
+// Returns the class of the given non-null object, for use when selecting a
+// method to invoke on it. Returns nil if the object isn't an instance of a
+// class that the JVM knows about, in which case the statically resolved method
+// should be used.
+func (j *JVM) getRuntimeClass(o Object) *Class {
+	switch v := o.(type) {
+	case *ClassInstance:
+		return v.C
+	case *StringObject:
+		toReturn, e := j.GetClass("java/lang/String")
+		if e != nil {
+			return nil
+		}
+		return toReturn
+	case *Class:
+		toReturn, e := j.GetClass("java/lang/Class")
+		if e != nil {
+			return nil
+		}
+		return toReturn
+	case Array:
+		toReturn, e := j.GetArrayClass(v.ArrayType())
+		if e != nil {
+			return nil
+		}
+		return toReturn
+	}
+	return nil
+}
+
+// Returns the class of the given non-null object, like Java's
+// Object.getClass(). Returns an error if the object's class isn't available.
+func (j *JVM) ClassOf(o Object) (*Class, error) {
+	if IsNull(o) {
+		return nil, NullReferenceError("Can't get the class of null")
+	}
+	toReturn := j.getRuntimeClass(o)
+	if toReturn == nil {
+		return nil, ClassNotFoundError(JavaClassName(o))
+	}
+	return toReturn, nil
+}
+
+// Returns the method to invoke for an invokespecial instruction in the class
+// c, given the method that the instruction's constant resolved to. This
+// differs from the resolved method when invoking a superclass' version of an
+// overridden method, i.e. "super.method()" in Java.
+func (c *Class) SelectSpecialMethod(symbolicClass *Class,
+	resolved *Method) (*Method, error) {
+	target := symbolicClass
+	useSuper := (resolved.Name != "<init>") && !symbolicClass.IsInterface() &&
+		(symbolicClass != c) && c.IsSubclassOf(symbolicClass) &&
+		((c.AccessFlags & 0x0020) != 0)
+	if useSuper {
+		target = c.Super
+	}
+	key := resolved.Key()
+	for current := target; current != nil; current = current.Super {
+		m := current.Methods[key]
+		if (m != nil) && !m.IsStatic() {
+			return m, nil
+		}
+		// Interfaces only inherit public methods from java/lang/Object, which
+		// is checked below.
+		if current.IsInterface() {
+			break
+		}
+	}
+	if target.IsInterface() && (target.Super != nil) {
+		m := target.Super.Methods[key]
+		if (m != nil) && ((m.AccessFlags & 1) != 0) && !m.IsStatic() {
+			return m, nil
+		}
+	}
+	var selected *Method
+	for _, m := range target.maximallySpecificMethods(key) {
+		if m.IsAbstract() {
+			continue
+		}
+		if selected != nil {
+			return nil, IncompatibleClassChangeError(fmt.Sprintf("Multiple "+
+				"default methods for %s in %s", key, target.Name))
+		}
+		selected = m
+	}
+	if selected == nil {
+		return nil, AbstractMethodError(fmt.Sprintf("No implementation of "+
+			"%s in %s", key, target.Name))
+	}
+	return selected, nil
+}
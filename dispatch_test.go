@@ -0,0 +1,327 @@
+package bs_jvm
+
+import (
+	"bytes"
+	"errors"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+)
+
+// Returns a method with no arguments and a void return type, which doesn't
+// have any code. The access flags should include either native (0x0100) or
+// abstract (0x0400).
+func getStubMethod(name string,
+	access class_file.MethodAccessFlags) *class_file.Method {
+	return &class_file.Method{
+		Access: access,
+		Name:   []byte(name),
+		Descriptor: &class_file.MethodDescriptor{
+			ArgumentTypes: []class_file.FieldType{},
+			ReturnType:    class_file.PrimitiveFieldType('V'),
+		},
+	}
+}
+
+// Loads the given stub classes into the JVM, failing the test on error.
+func loadStubClasses(t *testing.T, jvm *JVM, classes ...*class_file.Class) {
+	for _, c := range classes {
+		e := jvm.LoadClass(c)
+		if e != nil {
+			t.Logf("Failed loading class: %s\n", e)
+			t.FailNow()
+		}
+	}
+}
+
+// Resolves the method with the given name in the named class, then selects
+// the method that would be invoked on an instance of the receiver class.
+// Returns the name of the class containing the selected method.
+func getSelectedMethodClass(t *testing.T, jvm *JVM, className, methodName,
+	receiverName string) (string, error) {
+	class, e := jvm.GetClass(className)
+	if e != nil {
+		t.Logf("Couldn't find class %s: %s\n", className, e)
+		t.FailNow()
+	}
+	receiver, e := jvm.GetClass(receiverName)
+	if e != nil {
+		t.Logf("Couldn't find class %s: %s\n", receiverName, e)
+		t.FailNow()
+	}
+	key := GetMethodKey(getStubMethod(methodName, 0))
+	var resolved *Method
+	if class.IsInterface() {
+		resolved, e = class.ResolveInterfaceMethod(key)
+	} else {
+		resolved, e = class.ResolveMethod(key)
+	}
+	if e != nil {
+		t.Logf("Failed resolving %s.%s: %s\n", className, methodName, e)
+		t.FailNow()
+	}
+	selected, e := receiver.SelectMethod(resolved)
+	if e != nil {
+		return "", e
+	}
+	return string(selected.ContainingClass.Name), nil
+}
+
+func TestMethodSelection(t *testing.T) {
+	jvm := NewJVM()
+	// public native
+	concrete := class_file.MethodAccessFlags(0x0101)
+	// public abstract
+	abstract := class_file.MethodAccessFlags(0x0401)
+	// public super
+	classAccess := class_file.ClassAccessFlags(0x0021)
+	// public interface abstract
+	interfaceAccess := class_file.ClassAccessFlags(0x0601)
+	loadStubClasses(t, jvm,
+		getStubClassFile("I", "java/lang/Object", interfaceAccess, nil, nil,
+			[]*class_file.Method{
+				getStubMethod("defaultMethod", concrete),
+				getStubMethod("overriddenDefault", concrete),
+			}),
+		getStubClassFile("J", "java/lang/Object", interfaceAccess,
+			[]string{"I"}, nil, []*class_file.Method{
+				getStubMethod("overriddenDefault", concrete),
+				getStubMethod("unimplemented", abstract),
+			}),
+		getStubClassFile("Parent", "java/lang/Object", classAccess|0x0400,
+			[]string{"I"}, nil, []*class_file.Method{
+				getStubMethod("foo", concrete),
+				getStubMethod("bar", abstract),
+				getStubMethod("secret", 0x0102),
+			}),
+		getStubClassFile("Child", "Parent", classAccess, []string{"J"}, nil,
+			[]*class_file.Method{
+				getStubMethod("bar", concrete),
+				getStubMethod("secret", 0x0102),
+			}),
+		getStubClassFile("Grandchild", "Child", classAccess, nil, nil,
+			[]*class_file.Method{
+				getStubMethod("foo", concrete),
+			}))
+
+	expected := []struct {
+		class, method, receiver, selected string
+	}{
+		{"Parent", "foo", "Parent", "Parent"},
+		{"Parent", "foo", "Child", "Parent"},
+		{"Parent", "foo", "Grandchild", "Grandchild"},
+		{"Child", "foo", "Grandchild", "Grandchild"},
+		{"Parent", "bar", "Child", "Child"},
+		{"Parent", "bar", "Grandchild", "Child"},
+		{"I", "defaultMethod", "Grandchild", "I"},
+		{"Parent", "defaultMethod", "Parent", "I"},
+		{"I", "overriddenDefault", "Parent", "I"},
+		{"I", "overriddenDefault", "Child", "J"},
+		{"J", "overriddenDefault", "Grandchild", "J"},
+		// Private methods are never overridden.
+		{"Parent", "secret", "Child", "Parent"},
+	}
+	for _, x := range expected {
+		selected, e := getSelectedMethodClass(t, jvm, x.class, x.method,
+			x.receiver)
+		if e != nil {
+			t.Logf("Failed selecting %s.%s for %s: %s\n", x.class, x.method,
+				x.receiver, e)
+			t.FailNow()
+		}
+		if selected != x.selected {
+			t.Logf("Selected %s.%s for %s.%s on %s, expected %s.%s\n",
+				selected, x.method, x.class, x.method, x.receiver, x.selected,
+				x.method)
+			t.Fail()
+		}
+	}
+
+	_, e := getSelectedMethodClass(t, jvm, "Parent", "bar", "Parent")
+	if e == nil {
+		t.Logf("Didn't get an error selecting an abstract method.\n")
+		t.Fail()
+	}
+	_, e = getSelectedMethodClass(t, jvm, "J", "unimplemented", "Child")
+	_, ok := e.(AbstractMethodError)
+	if !ok {
+		t.Logf("Expected an AbstractMethodError, got %v\n", e)
+		t.Fail()
+	}
+
+	// Calling super.foo() from Grandchild should select Parent's foo, even
+	// though the constant refers to Child.
+	child, _ := jvm.GetClass("Child")
+	grandchild, _ := jvm.GetClass("Grandchild")
+	key := GetMethodKey(getStubMethod("foo", 0))
+	resolved, e := child.ResolveMethod(key)
+	if e != nil {
+		t.Logf("Failed resolving Child.foo: %s\n", e)
+		t.FailNow()
+	}
+	selected, e := grandchild.SelectSpecialMethod(child, resolved)
+	if e != nil {
+		t.Logf("Failed selecting super.foo: %s\n", e)
+		t.FailNow()
+	}
+	if string(selected.ContainingClass.Name) != "Parent" {
+		t.Logf("super.foo() selected %s.foo\n", selected.ContainingClass.Name)
+		t.Fail()
+	}
+}
+
+func TestNestmateAccess(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder(
+		"java/lang/IllegalAccessError", "java/lang/Throwable"))
+	outer := builder.NewClassBuilder("pkg/Outer", "java/lang/Object")
+	// private static native
+	outer.AddMethod(0x010a, "secret", "()V")
+	// private native
+	outer.AddMethod(0x0102, "peek", "()V")
+	// private
+	outer.AddField(0x0002, "hidden", "I")
+	// private static
+	outer.AddField(0x000a, "count", "I")
+	// public static
+	outer.AddField(0x0009, "instance", "Lpkg/Outer;")
+	attribute, e := class_file.EncodeNestMembersAttribute(
+		[]uint16{outer.Pool.Class("pkg/Outer$Inner")})
+	if e != nil {
+		t.Logf("Failed encoding NestMembers attribute: %s\n", e)
+		t.FailNow()
+	}
+	outer.AddAttribute(attribute)
+	loadBuiltTestClass(t, jvm, outer)
+
+	// Each of these classes accesses Outer's private members, using each of
+	// the instructions that may refer to them. Impostor claims to be in
+	// Outer's nest, but Outer doesn't list it.
+	accessors := []struct {
+		// The class, the host named by its NestHost attribute, if any, and
+		// the host it should actually belong to.
+		class, hostAttribute, host string
+		allowed                    bool
+	}{
+		{"pkg/Outer$Inner", "pkg/Outer", "pkg/Outer", true},
+		{"pkg/Impostor", "pkg/Outer", "pkg/Impostor", false},
+		{"pkg/Other", "", "pkg/Other", false},
+	}
+	for _, x := range accessors {
+		b := builder.NewClassBuilder(x.class, "java/lang/Object")
+		if x.hostAttribute != "" {
+			b.AddAttribute(class_file.EncodeNestHostAttribute(
+				b.Pool.Class(x.hostAttribute)))
+		}
+		m := b.AddMethod(0x0009, "invokeStatic", "()V")
+		m.EmitInvoke(builder.Invokestatic, "pkg/Outer", "secret", "()V")
+		m.Emit(builder.Return)
+		m = b.AddMethod(0x0009, "invokeVirtual", "()V")
+		m.EmitField(builder.Getstatic, "pkg/Outer", "instance", "Lpkg/Outer;")
+		m.EmitInvoke(builder.Invokevirtual, "pkg/Outer", "peek", "()V")
+		m.Emit(builder.Return)
+		m = b.AddMethod(0x0009, "getField", "()V")
+		m.EmitField(builder.Getstatic, "pkg/Outer", "instance", "Lpkg/Outer;")
+		m.EmitField(builder.Getfield, "pkg/Outer", "hidden", "I")
+		m.Emit(builder.Pop)
+		m.Emit(builder.Return)
+		m = b.AddMethod(0x0009, "putField", "()V")
+		m.EmitField(builder.Getstatic, "pkg/Outer", "instance", "Lpkg/Outer;")
+		m.EmitInt(1)
+		m.EmitField(builder.Putfield, "pkg/Outer", "hidden", "I")
+		m.Emit(builder.Return)
+		m = b.AddMethod(0x0009, "getStatic", "()V")
+		m.EmitField(builder.Getstatic, "pkg/Outer", "count", "I")
+		m.Emit(builder.Pop)
+		m.Emit(builder.Return)
+		// Access is only checked when an instruction runs, so this never
+		// fails.
+		m = b.AddMethod(0x0009, "notExecuted", "()V")
+		skip := m.NewLabel()
+		m.EmitInt(0)
+		m.EmitBranch(builder.Ifeq, skip)
+		m.EmitInvoke(builder.Invokestatic, "pkg/Outer", "secret", "()V")
+		m.PlaceLabel(skip)
+		m.Emit(builder.Return)
+		loadBuiltTestClass(t, jvm, b)
+	}
+	outerClass, e := jvm.GetClass("pkg/Outer")
+	if e != nil {
+		t.Logf("Failed getting Outer class: %s\n", e)
+		t.FailNow()
+	}
+	getNamedTestMethod(t, outerClass, "secret").Native = func(
+		thread *Thread) error {
+		return nil
+	}
+	getNamedTestMethod(t, outerClass, "peek").Native = func(
+		thread *Thread) error {
+		_, e := thread.Stack.PopRef()
+		return e
+	}
+	instance, e := outerClass.CreateInstance()
+	if e != nil {
+		t.Logf("Failed creating an Outer instance: %s\n", e)
+		t.FailNow()
+	}
+	_, index, e := outerClass.ResolveStaticField("instance")
+	if e != nil {
+		t.Logf("Failed resolving Outer.instance: %s\n", e)
+		t.FailNow()
+	}
+	outerClass.StaticFieldValues[index] = instance
+
+	members := map[string]string{
+		"invokeStatic":  "method pkg/Outer.secret",
+		"invokeVirtual": "method pkg/Outer.peek",
+		"getField":      "field pkg/Outer.hidden",
+		"putField":      "field pkg/Outer.hidden",
+		"getStatic":     "field pkg/Outer.count",
+		"notExecuted":   "",
+	}
+	for _, x := range accessors {
+		class, e := jvm.GetClass(x.class)
+		if e != nil {
+			t.Logf("Couldn't find class %s: %s\n", x.class, e)
+			t.FailNow()
+		}
+		host := string(class.NestHost().Name)
+		if host != x.host {
+			t.Logf("Expected %s's nest host to be %s, got %s\n", x.class,
+				x.host, host)
+			t.Fail()
+		}
+		for method, member := range members {
+			_, e = jvm.StartNamedThread(x.class, "void "+method+"()", "test")
+			if e != nil {
+				t.Logf("Failed starting %s.%s: %s\n", x.class, method, e)
+				t.FailNow()
+			}
+			e = jvm.WaitForAllThreads()
+			if x.allowed || (member == "") {
+				if e != nil {
+					t.Logf("%s.%s failed: %s\n", x.class, method, e)
+					t.Fail()
+				}
+				continue
+			}
+			expected := x.class + " can't access private " + member
+			var thrown *ThrownException
+			if !errors.As(e, &thrown) {
+				t.Logf("Expected %s.%s to throw an IllegalAccessError, got "+
+					"%v\n", x.class, method, e)
+				t.Fail()
+				continue
+			}
+			s := ThrowableToString(thrown.Exception)
+			if s != "java.lang.IllegalAccessError: "+expected {
+				t.Logf("%s.%s threw an unexpected exception: %s\n", x.class,
+					method, s)
+				t.Fail()
+			}
+		}
+	}
+}
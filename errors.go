@@ -75,10 +75,16 @@ func (e BadLocalVariableError) Error() string {
 		"index %d", int(e))
 }
 
-type IndexOutOfBoundsError uint64
+// This is returned if an array index is out of bounds. Contains the invalid
+// index and the length of the array.
+type IndexOutOfBoundsError struct {
+	Index  Int
+	Length int
+}
 
 func (e IndexOutOfBoundsError) Error() string {
-	return fmt.Sprintf("Index out of bounds: %d", int(e))
+	return fmt.Sprintf("Index out of bounds: %d (length %d)", e.Index,
+		e.Length)
 }
 
 // This is returned if an object reference is expected, but nil is found.
@@ -96,6 +102,14 @@ func (e ArithmeticError) Error() string {
 	return fmt.Sprintf("Arithmetic error: %s", string(e))
 }
 
+// This is returned if a class is its own superclass or superinterface, either
+// directly or indirectly.
+type ClassCircularityError string
+
+func (e ClassCircularityError) Error() string {
+	return fmt.Sprintf("Class circularity error: %s", string(e))
+}
+
 // This type of error is returned if there's an error resolving a field of a
 // class, including a static value.
 type FieldError string
@@ -104,6 +118,31 @@ func (e FieldError) Error() string {
 	return fmt.Sprintf("Field error: %s", string(e))
 }
 
+// This is returned if method resolution or selection finds a method that
+// doesn't match how it's being invoked, e.g. using invokeinterface on an object
+// that doesn't implement the interface.
+type IncompatibleClassChangeError string
+
+func (e IncompatibleClassChangeError) Error() string {
+	return fmt.Sprintf("Incompatible class change: %s", string(e))
+}
+
+// This is returned if invoking a method selects an abstract method, or no
+// method at all.
+type AbstractMethodError string
+
+func (e AbstractMethodError) Error() string {
+	return fmt.Sprintf("Abstract method error: %s", string(e))
+}
+
+// This is returned if a class attempts to invoke a private method belonging
+// to a class outside of its nest.
+type IllegalAccessError string
+
+func (e IllegalAccessError) Error() string {
+	return fmt.Sprintf("Illegal access: %s", string(e))
+}
+
 // This type of error is returned when an illegal argument is passed to a
 // method.
 type IllegalArgumentError string
@@ -111,3 +150,56 @@ type IllegalArgumentError string
 func (e IllegalArgumentError) Error() string {
 	return fmt.Sprintf("Illegal argument: %s", string(e))
 }
+
+// This is returned when attempting to create an array with a negative length.
+type NegativeArraySizeError Int
+
+func (e NegativeArraySizeError) Error() string {
+	return fmt.Sprintf("Negative array size: %d", int(e))
+}
+
+// This is returned when attempting to store a reference in an array that
+// can't hold objects of the reference's type.
+type ArrayStoreError string
+
+func (e ArrayStoreError) Error() string {
+	return fmt.Sprintf("Array store error: %s", string(e))
+}
+
+// This is returned when a thread tries to exit, wait on, or notify a monitor
+// that it doesn't hold.
+type IllegalMonitorStateError string
+
+func (e IllegalMonitorStateError) Error() string {
+	return fmt.Sprintf("Illegal monitor state: %s", string(e))
+}
+
+// This is returned when a thread is interrupted while it's sleeping or
+// waiting, or if it was interrupted before it started sleeping or waiting.
+type InterruptedError string
+
+func (e InterruptedError) Error() string {
+	return fmt.Sprintf("Interrupted: %s", string(e))
+}
+
+// This is returned when a method's bytecode fails verification. The message
+// names the method and the bytecode offset where verification failed.
+type VerifyError string
+
+func (e VerifyError) Error() string {
+	return fmt.Sprintf("Verify error: %s", string(e))
+}
+
+// This is returned when a Java format string, e.g. one passed to
+// String.format, is invalid or doesn't match its arguments.
+type FormatError struct {
+	// The name of the java/util exception class corresponding to the error,
+	// e.g. "UnknownFormatConversionException".
+	Exception string
+	// The message used by the Java exception.
+	Message string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Format error: %s: %s", e.Exception, e.Message)
+}
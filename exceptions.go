@@ -0,0 +1,537 @@
+package bs_jvm
+
+// This file contains code for throwing and catching Java exceptions, and for
+// converting internal errors into Java exceptions.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A single entry in a method's exception table, converted to use instruction
+// indices rather than byte offsets.
+type ExceptionHandler struct {
+	// The first instruction index covered by the handler.
+	StartIndex int
+	// The index of the instruction after the last one covered by the handler.
+	EndIndex int
+	// The index of the first instruction in the handler.
+	HandlerIndex int
+	// The name of the class of exceptions caught by this handler. Empty if
+	// the handler catches all exceptions, i.e. a "finally" block.
+	CatchType string
+}
+
+// Converts the method's exception table into ExceptionHandlers. Requires a
+// map of byte offsets to instruction indices, and the number of instructions
+// in the method.
+func (m *Method) getExceptionHandlers(offsets map[uint]int,
+	instructionCount int) ([]ExceptionHandler, error) {
+	toReturn := make([]ExceptionHandler, len(m.exceptionTable))
+	for i, entry := range m.exceptionTable {
+		var ok bool
+		h := &(toReturn[i])
+		h.StartIndex, ok = offsets[uint(entry.StartPC)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid exception handler start offset: %d",
+				entry.StartPC)
+		}
+		// The end offset is exclusive, so it may be the end of the code.
+		if uint(entry.EndPC) == uint(len(m.CodeBytes)) {
+			h.EndIndex = instructionCount
+		} else {
+			h.EndIndex, ok = offsets[uint(entry.EndPC)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid exception handler end "+
+					"offset: %d", entry.EndPC)
+			}
+		}
+		h.HandlerIndex, ok = offsets[uint(entry.HandlerPC)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid exception handler offset: %d",
+				entry.HandlerPC)
+		}
+		if entry.CatchType == 0 {
+			continue
+		}
+		name, e := m.ContainingClass.File.GetClassConstantName(
+			entry.CatchType)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid exception handler catch "+
+				"type: %w", e)
+		}
+		h.CatchType = string(name)
+	}
+	return toReturn, nil
+}
+
+// Returns the index of the first instruction of the handler for an exception
+// of the given class, thrown at the given instruction index. Returns false if
+// the method doesn't have a matching handler.
+func (m *Method) findExceptionHandler(index uint, c *Class) (int, bool,
+	error) {
+	for _, h := range m.ExceptionHandlers {
+		if (int(index) < h.StartIndex) || (int(index) >= h.EndIndex) {
+			continue
+		}
+		if h.CatchType == "" {
+			return h.HandlerIndex, true, nil
+		}
+		catchClass, e := m.ContainingClass.ParentJVM.GetOrLoadClass(
+			h.CatchType)
+		if e != nil {
+			// If the class can't be found, then the exception can't be an
+			// instance of it.
+			_, notFound := e.(ClassNotFoundError)
+			if notFound {
+				continue
+			}
+			return 0, false, e
+		}
+		if c.IsSubclassOf(catchClass) {
+			return h.HandlerIndex, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// Returns the line number in the source file corresponding to the given
+// instruction index. Returns -1 if it isn't known.
+func (m *Method) getLineNumber(index uint) int {
+	if index >= uint(len(m.instructionOffsets)) {
+		return -1
+	}
+	offset := m.instructionOffsets[index]
+	toReturn := -1
+	bestStart := uint(0)
+	for _, entry := range m.lineNumbers {
+		start := uint(entry.StartPC)
+		if (start > offset) || ((toReturn >= 0) && (start < bestStart)) {
+			continue
+		}
+		bestStart = start
+		toReturn = int(entry.LineNumber)
+	}
+	return toReturn
+}
+
+// Returns the name of the source file the class was compiled from, or an
+// empty string if it isn't known.
+func (c *Class) SourceFile() string {
+	if c.File == nil {
+		return ""
+	}
+	for _, a := range c.File.Attributes {
+		if string(a.Name) != "SourceFile" {
+			continue
+		}
+		index, e := class_file.ParseSourceFileAttribute(a)
+		if e != nil {
+			return ""
+		}
+		name, e := c.File.GetUTF8Constant(index)
+		if e != nil {
+			return ""
+		}
+		return string(name)
+	}
+	return ""
+}
+
+// Holds information about a single method invocation in a stack trace.
+type StackTraceElement struct {
+	// The name of the class containing the method, using dots as separators,
+	// e.g. "java.lang.Object".
+	ClassName  string
+	MethodName string
+	// The name of the source file, or an empty string if it's not known.
+	FileName string
+	// The line number in the source file. This is -1 if the line number
+	// isn't known, or -2 if the method is native.
+	LineNumber int
+}
+
+// Formats the element the same way as Java's StackTraceElement.toString().
+func (s *StackTraceElement) String() string {
+	var location string
+	if s.LineNumber == -2 {
+		location = "Native Method"
+	} else if s.FileName == "" {
+		location = "Unknown Source"
+	} else if s.LineNumber < 0 {
+		location = s.FileName
+	} else {
+		location = fmt.Sprintf("%s:%d", s.FileName, s.LineNumber)
+	}
+	return fmt.Sprintf("%s.%s(%s)", s.ClassName, s.MethodName, location)
+}
+
+// Returns a StackTraceElement for the given instruction in the method.
+func (m *Method) getStackTraceElement(index uint) StackTraceElement {
+	className := strings.ReplaceAll(string(m.ContainingClass.Name), "/", ".")
+	toReturn := StackTraceElement{
+		ClassName:  className,
+		MethodName: m.Name,
+		FileName:   m.ContainingClass.SourceFile(),
+		LineNumber: m.getLineNumber(index),
+	}
+	if m.Native != nil {
+		toReturn.LineNumber = -2
+	}
+	return toReturn
+}
+
+// Holds a method and an instruction index within it.
+type stackFrameLocation struct {
+	method *Method
+	index  uint
+}
+
+// Returns the current location followed by the location of each method
+// invocation on the call stack, from most to least recent.
+func (t *Thread) getStackFrameLocations() []stackFrameLocation {
+	toReturn := make([]stackFrameLocation, 0, 16)
+	toReturn = append(toReturn, stackFrameLocation{
+		method: t.CurrentMethod,
+		index:  t.InstructionIndex,
+	})
+	for depth := 0; ; depth++ {
+		frame, e := t.Stack.PeekFrame(depth)
+		if e != nil {
+			break
+		}
+		// The return index is the one after the invoke instruction.
+		toReturn = append(toReturn, stackFrameLocation{
+			method: frame.Method,
+			index:  frame.ReturnIndex - 1,
+		})
+	}
+	return toReturn
+}
+
+// Converts a list of locations into stack trace elements.
+func locationsToStackTrace(locations []stackFrameLocation) []StackTraceElement {
+	toReturn := make([]StackTraceElement, len(locations))
+	for i, l := range locations {
+		toReturn[i] = l.method.getStackTraceElement(l.index)
+	}
+	return toReturn
+}
+
+// Returns the thread's current stack trace, starting with the current method.
+func (t *Thread) GetStackTrace() []StackTraceElement {
+	return locationsToStackTrace(t.getStackFrameLocations())
+}
+
+// Holds the internal state of an instance of java/lang/Throwable or one of
+// its subclasses. This is stored in the instance's NativeData.
+type ThrowableInfo struct {
+	// The detail message; either a *StringObject or null.
+	Message Object
+	// The throwable that caused this one, or null.
+	Cause Object
+	// The stack trace, from the most recent method invocation to the least.
+	StackTrace []StackTraceElement
+}
+
+// Returns the ThrowableInfo for the given instance of a Throwable, creating
+// an empty one if it doesn't exist yet.
+func GetThrowableInfo(o *ClassInstance) *ThrowableInfo {
+	toReturn, ok := o.NativeData.(*ThrowableInfo)
+	if !ok {
+		toReturn = &ThrowableInfo{}
+		o.NativeData = toReturn
+	}
+	return toReturn
+}
+
+// Sets the exception's stack trace to the thread's current stack trace. This
+// omits the frames for the exception's constructors.
+func (t *Thread) FillInStackTrace(exception *ClassInstance) {
+	locations := t.getStackFrameLocations()
+	for len(locations) > 0 {
+		m := locations[0].method
+		if (m.Name != "<init>") || !exception.C.IsSubclassOf(
+			m.ContainingClass) {
+			break
+		}
+		locations = locations[1:]
+	}
+	GetThrowableInfo(exception).StackTrace = locationsToStackTrace(locations)
+}
+
+// Creates a new instance of the named Throwable class with the given message,
+// and fills in its stack trace. This doesn't run any constructor. Returns an
+// error if the class can't be loaded.
+func (t *Thread) NewThrowable(className, message string) (*ClassInstance,
+	error) {
+	c, e := t.ParentJVM.GetOrLoadClass(className)
+	if e != nil {
+		return nil, e
+	}
+	toReturn, e := c.CreateInstance()
+	if e != nil {
+		return nil, e
+	}
+	GetThrowableInfo(toReturn).Message = NewStringObject(message)
+	t.FillInStackTrace(toReturn)
+	return toReturn, nil
+}
+
+// Returns a ThrownException containing a new instance of the named Throwable
+// class with the given message. Intended to be used by native methods that need
+// to throw Java exceptions, e.g. "return t.Throw(className, message)". Returns
+// a different error if the exception couldn't be created.
+func (t *Thread) Throw(className, message string) error {
+	exception, e := t.NewThrowable(className, message)
+	if e != nil {
+		return fmt.Errorf("Failed creating %s (%s): %w", className, message,
+			e)
+	}
+	return &ThrownException{
+		Exception: exception,
+	}
+}
+
+// Returns the string representation of the Throwable, in the same format as
+// Java's Throwable.toString().
+func ThrowableToString(o *ClassInstance) string {
+	className := strings.ReplaceAll(string(o.C.Name), "/", ".")
+	message, ok := GetThrowableInfo(o).Message.(*StringObject)
+	if !ok {
+		return className
+	}
+	return className + ": " + message.Value()
+}
+
+// Writes the Throwable's string representation and stack trace, followed by
+// those of its causes, in the same format as Java's printStackTrace().
+func WriteStackTrace(w io.Writer, o *ClassInstance) error {
+	var enclosingTrace []StackTraceElement
+	seen := make(map[*ClassInstance]bool)
+	prefix := ""
+	for (o != nil) && !seen[o] {
+		seen[o] = true
+		info := GetThrowableInfo(o)
+		_, e := fmt.Fprintf(w, "%s%s\n", prefix, ThrowableToString(o))
+		if e != nil {
+			return e
+		}
+		// Like Java, omit the frames in common with the enclosing trace.
+		trace := info.StackTrace
+		inCommon := 0
+		for (inCommon < len(trace)) && (inCommon < len(enclosingTrace)) {
+			a := trace[len(trace)-1-inCommon]
+			b := enclosingTrace[len(enclosingTrace)-1-inCommon]
+			if a != b {
+				break
+			}
+			inCommon++
+		}
+		for i := 0; i < (len(trace) - inCommon); i++ {
+			_, e = fmt.Fprintf(w, "\tat %s\n", trace[i].String())
+			if e != nil {
+				return e
+			}
+		}
+		if inCommon != 0 {
+			_, e = fmt.Fprintf(w, "\t... %d more\n", inCommon)
+			if e != nil {
+				return e
+			}
+		}
+		enclosingTrace = trace
+		prefix = "Caused by: "
+		o, _ = info.Cause.(*ClassInstance)
+	}
+	return nil
+}
+
+// This error is returned when a Java exception is thrown. If it isn't caught,
+// it will end the thread.
+type ThrownException struct {
+	// The instance of java/lang/Throwable, or a subclass, that was thrown.
+	Exception *ClassInstance
+}
+
+func (e *ThrownException) Error() string {
+	return "Exception thrown: " + ThrowableToString(e.Exception)
+}
+
+// Converts an error returned when executing an instruction into the Java
+// exception it corresponds to. Returns nil if the error doesn't correspond
+// to a Java exception, or if the exception class isn't available.
+func (t *Thread) errorToThrowable(e error) *ClassInstance {
+	var thrown *ThrownException
+	if errors.As(e, &thrown) {
+		return thrown.Exception
+	}
+	var className, message string
+	var arithmeticError ArithmeticError
+	var indexError IndexOutOfBoundsError
+	var nullError NullReferenceError
+	var argumentError IllegalArgumentError
+	var abstractError AbstractMethodError
+	var incompatibleError IncompatibleClassChangeError
+	var accessError IllegalAccessError
+	var negativeSizeError NegativeArraySizeError
+	var arrayStoreError ArrayStoreError
+	var monitorError IllegalMonitorStateError
+	var interruptedError InterruptedError
+	var verifyError VerifyError
+	var circularityError ClassCircularityError
+	var classError ClassNotFoundError
+	var methodError MethodNotFoundError
+	var fieldError FieldError
+	var formatError *FormatError
+	if errors.As(e, &arithmeticError) {
+		className = "java/lang/ArithmeticException"
+		message = string(arithmeticError)
+	} else if errors.As(e, &indexError) {
+		className = "java/lang/ArrayIndexOutOfBoundsException"
+		message = fmt.Sprintf("Index %d out of bounds for length %d",
+			indexError.Index, indexError.Length)
+	} else if errors.As(e, &nullError) {
+		className = "java/lang/NullPointerException"
+		message = string(nullError)
+	} else if errors.As(e, &argumentError) {
+		className = "java/lang/IllegalArgumentException"
+		message = string(argumentError)
+	} else if errors.As(e, &abstractError) {
+		className = "java/lang/AbstractMethodError"
+		message = string(abstractError)
+	} else if errors.As(e, &accessError) {
+		className = "java/lang/IllegalAccessError"
+		message = string(accessError)
+	} else if errors.As(e, &incompatibleError) {
+		className = "java/lang/IncompatibleClassChangeError"
+		message = string(incompatibleError)
+	} else if errors.As(e, &negativeSizeError) {
+		className = "java/lang/NegativeArraySizeException"
+		message = strconv.Itoa(int(negativeSizeError))
+	} else if errors.As(e, &arrayStoreError) {
+		className = "java/lang/ArrayStoreException"
+		message = string(arrayStoreError)
+	} else if errors.As(e, &monitorError) {
+		className = "java/lang/IllegalMonitorStateException"
+		message = string(monitorError)
+	} else if errors.As(e, &interruptedError) {
+		className = "java/lang/InterruptedException"
+		message = string(interruptedError)
+	} else if errors.As(e, &verifyError) {
+		className = "java/lang/VerifyError"
+		message = string(verifyError)
+	} else if errors.As(e, &circularityError) {
+		className = "java/lang/ClassCircularityError"
+		message = string(circularityError)
+	} else if errors.As(e, &classError) {
+		className = "java/lang/NoClassDefFoundError"
+		message = string(classError)
+	} else if errors.As(e, &methodError) {
+		className = "java/lang/NoSuchMethodError"
+		message = string(methodError)
+	} else if errors.As(e, &fieldError) {
+		className = "java/lang/NoSuchFieldError"
+		message = string(fieldError)
+	} else if errors.As(e, &formatError) {
+		className = "java/util/" + formatError.Exception
+		message = formatError.Message
+	} else if errors.Is(e, StackOverflowError) {
+		className = "java/lang/StackOverflowError"
+	} else {
+		return nil
+	}
+	toReturn, e := t.NewThrowable(className, message)
+	if e != nil {
+		return nil
+	}
+	if message == "" {
+		GetThrowableInfo(toReturn).Message = nil
+	}
+	return toReturn
+}
+
+// Writes a stack trace for an exception that wasn't caught to the JVM's
+// ErrorSink, if it has one.
+func (t *Thread) reportUncaughtException(exception *ClassInstance) {
+	sink := t.ParentJVM.ErrorSink
+	if sink == nil {
+		return
+	}
+	fmt.Fprintf(sink, "Exception in thread \"%s\" ", t.GetName())
+	WriteStackTrace(sink, exception)
+}
+
+// Called when executing an instruction returns an error. If the error is a
+// Java exception, or corresponds to one, this searches the current method and
+// its callers for a handler. If a handler is found, the thread's state is
+// updated to continue in the handler, and this returns nil. Otherwise, this
+// writes a stack trace to the JVM's ErrorSink and returns a ThrownException.
+// Errors that don't correspond to Java exceptions are returned unchanged.
+func (t *Thread) HandleException(thrown error) error {
+	exception := t.errorToThrowable(thrown)
+	if exception == nil {
+		return thrown
+	}
+	for {
+		handlerIndex, found, e := t.CurrentMethod.findExceptionHandler(
+			t.InstructionIndex, exception.C)
+		if e != nil {
+			return fmt.Errorf("Failed finding exception handler for %s: %w",
+				ThrowableToString(exception), e)
+		}
+		if found {
+			// Clear the method's operand stack, which starts where the
+			// calling method's stack ended.
+			var base StackSizes
+			frame, e := t.Stack.PeekFrame(0)
+			if e == nil {
+				base = frame.StackState
+			}
+			e = t.Stack.RestoreSizes(&base)
+			if e != nil {
+				return e
+			}
+			e = t.Stack.PushRef(exception)
+			if e != nil {
+				return e
+			}
+			t.InstructionIndex = uint(handlerIndex)
+			t.WasBranch = true
+			return nil
+		}
+		// A synchronized method releases its monitor when an exception
+		// leaves it. The exception is propagated even if the monitor isn't
+		// held at this point.
+		t.exitMethodMonitor()
+		frame, e := t.Stack.PopFrame()
+		if e == StackEmptyError {
+			break
+		}
+		if e != nil {
+			return e
+		}
+		e = t.RestoreReturnInfo(&frame)
+		if e != nil {
+			return e
+		}
+		// Handlers in the caller need to cover the invoke instruction, which
+		// precedes the return address.
+		t.InstructionIndex = frame.ReturnIndex - 1
+		if frame.NativeCall {
+			// The exception will be returned to the native method that
+			// called Invoke, which may propagate it further.
+			return &ThrownException{
+				Exception: exception,
+			}
+		}
+	}
+	t.reportUncaughtException(exception)
+	return &ThrownException{
+		Exception: exception,
+	}
+}
@@ -0,0 +1,299 @@
+package bs_jvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"strings"
+	"testing"
+)
+
+// Adds a Methodref constant to the class file, referring to a method in the
+// class itself. Returns the index of the new constant.
+func addTestMethodConstant(c *class_file.Class, name,
+	descriptor string) uint16 {
+	base := uint16(len(c.Constants))
+	c.Constants = append(c.Constants,
+		&class_file.ConstantUTF8Info{Bytes: []byte(name)},
+		&class_file.ConstantUTF8Info{Bytes: []byte(descriptor)},
+		&class_file.ConstantNameAndTypeInfo{
+			NameIndex:       base,
+			DescriptorIndex: base + 1,
+		},
+		&class_file.ConstantMethodInfo{
+			ClassIndex:       c.ThisClass,
+			NameAndTypeIndex: base + 2,
+		})
+	return base + 3
+}
+
+// Returns a public static method taking no arguments and returning void, with
+// a Code attribute containing the given bytecode and exception table.
+func getTestCodeMethod(name string, code []byte,
+	exceptionTable []class_file.ExceptionTableEntry) *class_file.Method {
+	data := &bytes.Buffer{}
+	// max_stack, max_locals, code_length
+	binary.Write(data, binary.BigEndian, uint16(4))
+	binary.Write(data, binary.BigEndian, uint16(0))
+	binary.Write(data, binary.BigEndian, uint32(len(code)))
+	data.Write(code)
+	binary.Write(data, binary.BigEndian, uint16(len(exceptionTable)))
+	for _, entry := range exceptionTable {
+		binary.Write(data, binary.BigEndian, &entry)
+	}
+	// attributes_count
+	binary.Write(data, binary.BigEndian, uint16(0))
+	toReturn := getStubMethod(name, 0x0009)
+	toReturn.Attributes = []*class_file.Attribute{
+		{
+			Name: []byte("Code"),
+			Info: data.Bytes(),
+		},
+	}
+	return toReturn
+}
+
+// Starts a thread running the named method in the Thrower class, and returns
+// the result of waiting for it to complete. This will be nil if the thread
+// exited normally.
+func runThrowerMethod(t *testing.T, jvm *JVM, name string) error {
+	key := GetMethodKey(getStubMethod(name, 0))
+	_, e := jvm.StartNamedThread("Thrower", key, "test")
+	if e != nil {
+		t.Logf("Failed starting thread for %s: %s\n", name, e)
+		t.FailNow()
+	}
+	return jvm.WaitForAllThreads()
+}
+
+func TestExceptionHandling(t *testing.T) {
+	jvm := NewJVM()
+	output := &bytes.Buffer{}
+	jvm.ErrorSink = output
+	loadStubClasses(t, jvm,
+		getStubClassFile("java/lang/Throwable", "java/lang/Object", 0x0021,
+			nil, nil, nil),
+		getStubClassFile("java/lang/ArithmeticException",
+			"java/lang/Throwable", 0x0021, nil, nil, nil))
+
+	thrower := getStubClassFile("Thrower", "java/lang/Object", 0x0021, nil,
+		nil, nil)
+	throwableIndex := addTestClassConstant(thrower, "java/lang/Throwable")
+	divideIndex := addTestMethodConstant(thrower, "divide", "()V")
+	// iconst_1, iconst_0, idiv, pop, return
+	divideCode := []byte{0x04, 0x03, 0x6c, 0x57, 0xb1}
+	// invokestatic divide, return
+	callDivideCode := []byte{0xb8, byte(divideIndex >> 8), byte(divideIndex),
+		0xb1}
+	// The same as callDivideCode, followed by a handler that pops the
+	// exception and returns.
+	catchCode := append(append([]byte{}, callDivideCode...), 0x57, 0xb1)
+	thrower.Methods = []*class_file.Method{
+		getTestCodeMethod("divide", divideCode, nil),
+		getTestCodeMethod("uncaught", callDivideCode, nil),
+		getTestCodeMethod("caught", catchCode,
+			[]class_file.ExceptionTableEntry{
+				{
+					StartPC:   0,
+					EndPC:     3,
+					HandlerPC: 4,
+					CatchType: throwableIndex,
+				},
+			}),
+	}
+	loadStubClasses(t, jvm, thrower)
+
+	e := runThrowerMethod(t, jvm, "caught")
+	if e != nil {
+		t.Logf("Expected the exception to be caught, got %s\n", e)
+		t.Fail()
+	}
+	if output.Len() != 0 {
+		t.Logf("Got unexpected error output: %s\n", output.String())
+		t.Fail()
+	}
+
+	e = runThrowerMethod(t, jvm, "uncaught")
+	var thrown *ThrownException
+	if !errors.As(e, &thrown) {
+		t.Logf("Expected a ThrownException, got %v\n", e)
+		t.FailNow()
+	}
+	className := string(thrown.Exception.C.Name)
+	if className != "java/lang/ArithmeticException" {
+		t.Logf("Expected an ArithmeticException, got %s\n", className)
+		t.Fail()
+	}
+	trace := output.String()
+	t.Logf("Uncaught exception output:\n%s", trace)
+	expectedLines := []string{
+		"Exception in thread \"test\" java.lang.ArithmeticException: / by " +
+			"zero",
+		"\tat Thrower.divide(",
+		"\tat Thrower.uncaught(",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(trace, line) {
+			t.Logf("Stack trace didn't contain %q\n", line)
+			t.Fail()
+		}
+	}
+}
+
+// Builds the class and loads it into the JVM.
+func loadBuiltTestClass(t *testing.T, jvm *JVM, b *builder.ClassBuilder) {
+	class, e := b.Build()
+	if e != nil {
+		t.Logf("Failed building class %s: %s\n", b.Name(), e)
+		t.FailNow()
+	}
+	loadStubClasses(t, jvm, class)
+}
+
+func TestRuntimeExceptions(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	for _, name := range []string{"java/lang/VerifyError",
+		"java/lang/ArrayIndexOutOfBoundsException"} {
+		loadBuiltTestClass(t, jvm, builder.NewClassBuilder(name,
+			"java/lang/Throwable"))
+	}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("NotThrowable",
+		"java/lang/Object"))
+
+	// Version 49 classes aren't verified using stack map frames, so athrow
+	// must check the type of the object it throws at runtime.
+	b := builder.NewClassBuilder("Thrower", "java/lang/Object")
+	b.MajorVersion = 49
+	m := b.AddMethod(0x0009, "index", "()V")
+	m.EmitInt(3)
+	// T_INT
+	m.Emit(builder.Newarray, 10)
+	m.EmitInt(5)
+	m.Emit(builder.Iaload)
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	m = b.AddMethod(0x0009, "throwObject", "()V")
+	m.EmitType(builder.New, "NotThrowable")
+	m.Emit(builder.Athrow)
+	loadBuiltTestClass(t, jvm, b)
+
+	tests := []struct {
+		method    string
+		exception string
+		message   string
+	}{
+		{"index", "java/lang/ArrayIndexOutOfBoundsException",
+			"Index 5 out of bounds for length 3"},
+		{"throwObject", "java/lang/VerifyError",
+			"Can only throw Throwable objects, got NotThrowable"},
+	}
+	for _, test := range tests {
+		e := runThrowerMethod(t, jvm, test.method)
+		var thrown *ThrownException
+		if !errors.As(e, &thrown) {
+			t.Logf("Expected %s to throw an exception, got %v\n",
+				test.method, e)
+			t.Fail()
+			continue
+		}
+		className := string(thrown.Exception.C.Name)
+		message, _ := GetThrowableInfo(thrown.Exception).Message.(*StringObject)
+		if (className != test.exception) || (message == nil) ||
+			(message.Value() != test.message) {
+			t.Logf("Expected %s to throw %s(%q), got %s(%s)\n", test.method,
+				test.exception, test.message, className, message)
+			t.Fail()
+		}
+	}
+}
+
+func TestLinkageErrors(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	for _, name := range []string{"java/lang/NoClassDefFoundError",
+		"java/lang/NoSuchMethodError", "java/lang/NoSuchFieldError"} {
+		loadBuiltTestClass(t, jvm, builder.NewClassBuilder(name,
+			"java/lang/Throwable"))
+	}
+	b := builder.NewClassBuilder("Linker", "java/lang/Object")
+	b.AddMethod(0x0109, "caught", "(Ljava/lang/Throwable;)V")
+	b.AddField(0x0008, "present", "I")
+	// Each of these methods refers to something that doesn't exist. They're
+	// resolved before they run, so the error is thrown where they're called.
+	m := b.AddMethod(0x0009, "missingClass", "()V")
+	m.EmitInvoke(builder.Invokestatic, "Missing", "run", "()V")
+	m.Emit(builder.Return)
+	m = b.AddMethod(0x0009, "missingMethod", "()V")
+	m.EmitInvoke(builder.Invokestatic, "Linker", "absent", "()V")
+	m.Emit(builder.Return)
+	m = b.AddMethod(0x0009, "missingField", "()V")
+	m.EmitField(builder.Getstatic, "Linker", "absent", "I")
+	m.Emit(builder.Pop)
+	m.Emit(builder.Return)
+	tests := []struct {
+		method    string
+		exception string
+	}{
+		{"missingClass", "java/lang/NoClassDefFoundError"},
+		{"missingMethod", "java/lang/NoSuchMethodError"},
+		{"missingField", "java/lang/NoSuchFieldError"},
+	}
+	for _, test := range tests {
+		m = b.AddMethod(0x0009, "catch_"+test.method, "()V")
+		start := m.NewLabel()
+		end := m.NewLabel()
+		handler := m.NewLabel()
+		m.PlaceLabel(start)
+		m.EmitInvoke(builder.Invokestatic, "Linker", test.method, "()V")
+		m.PlaceLabel(end)
+		m.Emit(builder.Return)
+		m.PlaceLabel(handler)
+		m.EmitInvoke(builder.Invokestatic, "Linker", "caught",
+			"(Ljava/lang/Throwable;)V")
+		m.Emit(builder.Return)
+		m.AddExceptionHandler(start, end, handler, test.exception)
+	}
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Linker")
+	if e != nil {
+		t.Logf("Failed getting Linker class: %s\n", e)
+		t.FailNow()
+	}
+	var caught []string
+	getNamedTestMethod(t, c, "caught").Native = func(thread *Thread) error {
+		o, e := thread.Stack.PopRef()
+		if e != nil {
+			return e
+		}
+		caught = append(caught, string(o.(*ClassInstance).C.Name))
+		return nil
+	}
+
+	for _, test := range tests {
+		caught = nil
+		_, e = jvm.StartNamedThread("Linker", "void catch_"+test.method+"()",
+			"test")
+		if e != nil {
+			t.Logf("Failed starting thread: %s\n", e)
+			t.FailNow()
+		}
+		e = jvm.WaitForAllThreads()
+		if e != nil {
+			t.Logf("Expected %s to be caught, got %s\n", test.exception, e)
+			t.Fail()
+			continue
+		}
+		if (len(caught) != 1) || (caught[0] != test.exception) {
+			t.Logf("Expected to catch %s, caught %v\n", test.exception,
+				caught)
+			t.Fail()
+		}
+	}
+}
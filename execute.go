@@ -85,6 +85,10 @@ func (n *ldcInstruction) Execute(t *Thread) error {
 	if n.isPrimitive {
 		return t.Stack.Push(n.primitiveValue)
 	}
+	dynamic, ok := n.reference.(*dynamicConstant)
+	if ok {
+		return dynamic.push(t)
+	}
 	return t.Stack.PushRef(n.reference)
 }
 
@@ -92,10 +96,18 @@ func (n *ldc_wInstruction) Execute(t *Thread) error {
 	if n.isPrimitive {
 		return t.Stack.Push(n.primitiveValue)
 	}
+	dynamic, ok := n.reference.(*dynamicConstant)
+	if ok {
+		return dynamic.push(t)
+	}
 	return t.Stack.PushRef(n.reference)
 }
 
 func (n *ldc2_wInstruction) Execute(t *Thread) error {
+	dynamic, ok := n.reference.(*dynamicConstant)
+	if ok {
+		return dynamic.push(t)
+	}
 	return t.Stack.PushLong(n.primitiveValue)
 }
 
@@ -186,7 +198,7 @@ func loadLocalReference(t *Thread, index int) error {
 		return BadLocalVariableError(index)
 	}
 	o := t.LocalVariables[index]
-	if o.IsPrimitive() {
+	if (o != nil) && o.IsPrimitive() {
 		return TypeError(fmt.Sprintf("Expected to load a reference, got %s",
 			o.TypeName()))
 	}
@@ -286,16 +298,16 @@ func (n *ialoadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(IntArray)
+	a, ok := o.(*IntArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected an int array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.Push(a[i])
+	return t.Stack.Push(a.Elements[i])
 }
 
 func (n *laloadInstruction) Execute(t *Thread) error {
@@ -307,16 +319,16 @@ func (n *laloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(LongArray)
+	a, ok := o.(*LongArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a long array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.PushLong(a[i])
+	return t.Stack.PushLong(a.Elements[i])
 }
 
 func (n *faloadInstruction) Execute(t *Thread) error {
@@ -328,16 +340,16 @@ func (n *faloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(FloatArray)
+	a, ok := o.(*FloatArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a float array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.PushFloat(a[i])
+	return t.Stack.PushFloat(a.Elements[i])
 }
 
 func (n *daloadInstruction) Execute(t *Thread) error {
@@ -349,16 +361,16 @@ func (n *daloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(DoubleArray)
+	a, ok := o.(*DoubleArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a double array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.PushDouble(a[i])
+	return t.Stack.PushDouble(a.Elements[i])
 }
 
 func (n *aaloadInstruction) Execute(t *Thread) error {
@@ -370,16 +382,16 @@ func (n *aaloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ReferenceArray)
+	a, ok := o.(*ReferenceArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a reference array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.PushRef(a[i])
+	return t.Stack.PushRef(a.Elements[i])
 }
 
 func (n *baloadInstruction) Execute(t *Thread) error {
@@ -391,10 +403,16 @@ func (n *baloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ByteArray)
-	if !ok {
-		return TypeError(fmt.Sprintf("Expected a byte array, got %s",
-			o.TypeName()))
+	// baload is used for both byte and boolean arrays.
+	var a []Byte
+	switch v := o.(type) {
+	case *ByteArray:
+		a = v.Elements
+	case *BooleanArray:
+		a = v.Elements
+	default:
+		return TypeError(fmt.Sprintf("Expected a byte or boolean array, got "+
+			"%s", o.TypeName()))
 	}
 	e = checkArrayIndex(i, len(a))
 	if e != nil {
@@ -412,16 +430,16 @@ func (n *caloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(CharArray)
+	a, ok := o.(*CharArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a char array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.Push(Int(uint32(a[i])))
+	return t.Stack.Push(Int(uint32(a.Elements[i])))
 }
 
 func (n *saloadInstruction) Execute(t *Thread) error {
@@ -433,16 +451,16 @@ func (n *saloadInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ShortArray)
+	a, ok := o.(*ShortArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a short array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(i, len(a))
+	e = checkArrayIndex(i, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	return t.Stack.Push(Int(a[i]))
+	return t.Stack.Push(Int(a.Elements[i]))
 }
 
 // Pushes an int from the local variable array onto the stack.
@@ -610,13 +628,13 @@ func (n *astore_3Instruction) Execute(t *Thread) error {
 // of a given array size. Returns an IndexOutOfBoundsError if the index is
 // invalid.
 func checkArrayIndex(index Int, arrayLength int) error {
-	if index < 0 {
-		return IndexOutOfBoundsError(index)
-	}
-	if int(index) < arrayLength {
+	if (index >= 0) && (int(index) < arrayLength) {
 		return nil
 	}
-	return IndexOutOfBoundsError(index)
+	return IndexOutOfBoundsError{
+		Index:  index,
+		Length: arrayLength,
+	}
 }
 
 func (n *iastoreInstruction) Execute(t *Thread) error {
@@ -632,16 +650,16 @@ func (n *iastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(IntArray)
+	a, ok := o.(*IntArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected an int array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = value
+	a.Elements[index] = value
 	return nil
 }
 
@@ -658,16 +676,16 @@ func (n *lastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(LongArray)
+	a, ok := o.(*LongArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a long array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = value
+	a.Elements[index] = value
 	return nil
 }
 
@@ -684,16 +702,16 @@ func (n *fastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(FloatArray)
+	a, ok := o.(*FloatArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a float array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = value
+	a.Elements[index] = value
 	return nil
 }
 
@@ -710,16 +728,16 @@ func (n *dastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(DoubleArray)
+	a, ok := o.(*DoubleArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a double array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = value
+	a.Elements[index] = value
 	return nil
 }
 
@@ -736,16 +754,23 @@ func (n *aastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ReferenceArray)
+	a, ok := o.(*ReferenceArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a reference array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = value
+	// Arrays are covariant, so the compiler can't always ensure that the
+	// value is compatible with the array's actual type.
+	if !IsNull(value) && !t.ParentJVM.IsInstanceOf(value,
+		ComponentType(a.Type)) {
+		return ArrayStoreError(fmt.Sprintf("Can't store %s in %s",
+			value.TypeName(), a.TypeName()))
+	}
+	a.Elements[index] = value
 	return nil
 }
 
@@ -762,10 +787,18 @@ func (n *bastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ByteArray)
-	if !ok {
-		return TypeError(fmt.Sprintf("Expected a byte array, got %s",
-			o.TypeName()))
+	// bastore is used for both byte and boolean arrays. Only the low bit of
+	// the value is stored in boolean arrays.
+	var a []Byte
+	switch v := o.(type) {
+	case *ByteArray:
+		a = v.Elements
+	case *BooleanArray:
+		a = v.Elements
+		value &= 1
+	default:
+		return TypeError(fmt.Sprintf("Expected a byte or boolean array, got "+
+			"%s", o.TypeName()))
 	}
 	e = checkArrayIndex(index, len(a))
 	if e != nil {
@@ -788,16 +821,16 @@ func (n *castoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(CharArray)
+	a, ok := o.(*CharArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a char array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = Char(value)
+	a.Elements[index] = Char(value)
 	return nil
 }
 
@@ -814,16 +847,16 @@ func (n *sastoreInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	a, ok := o.(ShortArray)
+	a, ok := o.(*ShortArray)
 	if !ok {
 		return TypeError(fmt.Sprintf("Expected a short array, got %s",
 			o.TypeName()))
 	}
-	e = checkArrayIndex(index, len(a))
+	e = checkArrayIndex(index, len(a.Elements))
 	if e != nil {
 		return e
 	}
-	a[index] = Short(value)
+	a.Elements[index] = Short(value)
 	return nil
 }
 
@@ -1089,7 +1122,7 @@ func (n *idivInstruction) Execute(t *Thread) error {
 		return e
 	}
 	if a == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.Push(b / a)
 }
@@ -1100,7 +1133,7 @@ func (n *ldivInstruction) Execute(t *Thread) error {
 		return e
 	}
 	if a == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.PushLong(b / a)
 }
@@ -1127,7 +1160,7 @@ func (n *iremInstruction) Execute(t *Thread) error {
 		return e
 	}
 	if a == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.Push(b % a)
 }
@@ -1138,7 +1171,7 @@ func (n *lremInstruction) Execute(t *Thread) error {
 		return e
 	}
 	if a == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.PushLong(b % a)
 }
@@ -1156,7 +1189,7 @@ func (n *fremInstruction) Execute(t *Thread) error {
 	}
 	// This is required behavior according to the JVM spec.
 	if int64(a) == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.PushFloat(Float(javaRemainder(float64(b), float64(a))))
 }
@@ -1167,7 +1200,7 @@ func (n *dremInstruction) Execute(t *Thread) error {
 		return e
 	}
 	if int64(a) == 0 {
-		return ArithmeticError("Division by zero")
+		return ArithmeticError("/ by zero")
 	}
 	return t.Stack.PushDouble(Double(javaRemainder(float64(b), float64(a))))
 }
@@ -1317,7 +1350,8 @@ func (n *iincInstruction) Execute(t *Thread) error {
 	if e != nil {
 		return e
 	}
-	t.LocalVariables[n.index] = v + Int(n.value)
+	// The increment is a signed byte.
+	t.LocalVariables[n.index] = v + Int(int8(n.value))
 	return nil
 }
 
@@ -1748,7 +1782,7 @@ func (n *lookupswitchInstruction) Execute(t *Thread) error {
 		return e
 	}
 	i := sort.Search(len(n.pairs), func(i int) bool {
-		return int32(v) >= n.pairs[i].match
+		return n.pairs[i].match >= int32(v)
 	})
 	if (i >= len(n.pairs)) || (n.pairs[i].match != int32(v)) {
 		t.InstructionIndex = n.defaultIndex
@@ -1849,18 +1883,34 @@ func (n *returnInstruction) Execute(t *Thread) error {
 }
 
 func (n *getstaticInstruction) Execute(t *Thread) error {
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
+	e = t.InitializeClass(n.class)
+	if e != nil {
+		return e
+	}
 	v := n.class.StaticFieldValues[n.index]
 	return t.Stack.PushUnconditional(v)
 }
 
 func (n *putstaticInstruction) Execute(t *Thread) error {
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
+	e = t.InitializeClass(n.class)
+	if e != nil {
+		return e
+	}
 	// We'll first look up the type that's stored in the field in order to pop
 	// the right type from the stack.
 	targetValue := n.class.StaticFieldValues[n.index]
 
 	// First, if this isn't a primitive it must be a reference, so we'll pop a
 	// reference off the stack and store it.
-	if !targetValue.IsPrimitive() {
+	if (targetValue == nil) || !targetValue.IsPrimitive() {
 		newValue, e := t.Stack.PopRef()
 		if e != nil {
 			return e
@@ -1877,7 +1927,6 @@ func (n *putstaticInstruction) Execute(t *Thread) error {
 	// Now that we know the value was a primitive we will need to pop the right
 	// type of primitive off the stack.
 	var newValue PrimitiveType
-	var e error
 
 	// We only care about floats, longs, and doubles. By default, we pop an
 	// int, since that's the smallest integral primitive that can be pushed
@@ -1904,23 +1953,35 @@ func (n *putstaticInstruction) Execute(t *Thread) error {
 	return nil
 }
 
+// Returns the object as a *ClassInstance, but only if it's an instance of the
+// given class or one of its subclasses.
+func getFieldInstance(o Object, c *Class) (*ClassInstance, error) {
+	instance, ok := o.(*ClassInstance)
+	if !ok {
+		return nil, TypeError(fmt.Sprintf("Expected a class instance, got %s",
+			o.String()))
+	}
+	if !instance.C.IsSubclassOf(c) {
+		return nil, TypeError(fmt.Sprintf("Expected an instance of %s, got "+
+			"%s", c.Name, instance.C.Name))
+	}
+	return instance, nil
+}
+
 func (n *getfieldInstruction) Execute(t *Thread) error {
-	v, e := t.Stack.PopRef()
+	e := n.access.check()
 	if e != nil {
 		return e
 	}
-	instance, ok := v.(*ClassInstance)
-	if !ok {
-		return TypeError(fmt.Sprintf("Didn't get a valid object for getfield."+
-			" Instead got %s", v.String()))
+	v, e := PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
 	}
-	fieldName := string(n.fieldReference.Field.Name)
-	instance, fieldIndex, e := instance.ResolveField(fieldName)
+	instance, e := getFieldInstance(v, n.class)
 	if e != nil {
-		return fmt.Errorf("Couldn't resolve field %s in %s: %s", fieldName,
-			instance.String(), e)
+		return fmt.Errorf("Invalid object for getfield: %w", e)
 	}
-	return t.Stack.PushUnconditional(instance.FieldValues[fieldIndex])
+	return t.Stack.PushUnconditional(instance.FieldValues[n.index])
 }
 
 func (n *putfieldInstruction) Execute(t *Thread) error {
@@ -1929,6 +1990,10 @@ func (n *putfieldInstruction) Execute(t *Thread) error {
 	// be popped of the stack first (and we don't know its size yet). So, we'll
 	// need to actually look at the type of the FieldOrMethodReference to
 	// figure out what to pop.
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
 	nameAndType := n.fieldReference.Field
 	fieldType, e := class_file.ParseFieldType(nameAndType.Type)
 	if e != nil {
@@ -1949,12 +2014,11 @@ func (n *putfieldInstruction) Execute(t *Thread) error {
 			toStore, e = t.Stack.PopFloat()
 		case 'D':
 			toStore, e = t.Stack.PopDouble()
-		case 'L':
+		case 'J':
 			toStore, e = t.Stack.PopLong()
 		default:
 			return fmt.Errorf("Unknown primitive field type: %s",
 				primitiveFieldType)
-			// Afterwards, check the error, then check AssignmentOK
 		}
 	}
 	if e != nil {
@@ -1965,25 +2029,24 @@ func (n *putfieldInstruction) Execute(t *Thread) error {
 
 	// Now that we've popped the value to store from the stack, we can get the
 	// object reference and figure out where to store it.
-	tmp, e := t.Stack.PopRef()
+	tmp, e := PopRefNotNull(t.Stack)
 	if e != nil {
 		return e
 	}
-	classInstance, ok := tmp.(*ClassInstance)
-	if !ok {
-		return fmt.Errorf("Expected to pop a class instance from the stack, "+
-			"got %s instead", tmp.String())
-	}
-	classInstance, fieldIndex, e := classInstance.ResolveField(
-		string(nameAndType.Name))
+	classInstance, e := getFieldInstance(tmp, n.class)
 	if e != nil {
-		return fmt.Errorf("Couldn't resolve field %s.%s: %s",
-			classInstance.TypeName(), nameAndType.Name, e)
+		return fmt.Errorf("Invalid object for putfield: %w", e)
 	}
+	fieldIndex := n.index
 
 	// Finally! Check that it's okay to assign the value to the one in the
 	// field, and actually update the field.
-	e = AssignmentOK(toStore, classInstance.FieldValues[fieldIndex])
+	currentValue := classInstance.FieldValues[fieldIndex]
+	if isPrimitive {
+		toStore = currentValue.(PrimitiveType).ConvertFrom(
+			toStore.(PrimitiveType))
+	}
+	e = AssignmentOK(toStore, currentValue)
 	if e != nil {
 		return TypeError(fmt.Sprintf("Trying to assign incompatible type to "+
 			"%s.%s: %s", classInstance.TypeName(), nameAndType.Name, e))
@@ -1992,48 +2055,152 @@ func (n *putfieldInstruction) Execute(t *Thread) error {
 	return nil
 }
 
+// Returns the method that should be invoked on the object below the method's
+// reference arguments on the stack, given the resolved method.
+func selectVirtualMethod(t *Thread, resolved *Method,
+	referenceArgs int) (*Method, error) {
+	receiver, e := t.Stack.PeekRef(referenceArgs)
+	if e != nil {
+		return nil, e
+	}
+	if IsNull(receiver) {
+		return nil, NullReferenceError(fmt.Sprintf("Invoking %s.%s on a "+
+			"null object", resolved.ContainingClass.Name, resolved.Name))
+	}
+	c := t.ParentJVM.getRuntimeClass(receiver)
+	if c == nil {
+		return resolved, nil
+	}
+	return c.SelectMethod(resolved)
+}
+
 func (n *invokevirtualInstruction) Execute(t *Thread) error {
-	return t.Call(n.method)
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
+	method, e := selectVirtualMethod(t, n.method, n.referenceArgs)
+	if e != nil {
+		return e
+	}
+	return t.Call(method)
 }
 
 func (n *invokespecialInstruction) Execute(t *Thread) error {
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
 	return t.Call(n.method)
 }
 
 func (n *invokestaticInstruction) Execute(t *Thread) error {
+	e := n.access.check()
+	if e != nil {
+		return e
+	}
+	e = t.InitializeClass(n.method.ContainingClass)
+	if e != nil {
+		return e
+	}
 	return t.Call(n.method)
 }
 
 func (n *invokeinterfaceInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	receiver, e := t.Stack.PeekRef(n.referenceArgs)
+	if e != nil {
+		return e
+	}
+	c := t.ParentJVM.getRuntimeClass(receiver)
+	if (c != nil) && !c.IsSubclassOf(n.class) {
+		return IncompatibleClassChangeError(fmt.Sprintf("%s doesn't "+
+			"implement %s", c.Name, n.class.Name))
+	}
+	method, e := selectVirtualMethod(t, n.method, n.referenceArgs)
+	if e != nil {
+		return e
+	}
+	return t.Call(method)
 }
 
 func (n *invokedynamicInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	callSite, e := n.getCallSite(t)
+	if e != nil {
+		return e
+	}
+	return t.Call(callSite.Target)
 }
 
 func (n *newInstruction) Execute(t *Thread) error {
-	instance, e := n.class.CreateInstance()
+	e := t.InitializeClass(n.class)
+	if e != nil {
+		return e
+	}
+	instance, e := n.class.NewObject()
 	if e != nil {
 		return fmt.Errorf("new %s failed: %w", n.class.Name, e)
 	}
 	return t.Stack.PushRef(instance)
 }
 
+// Pops an array length from the stack, and pushes a new array of the given
+// type.
+func pushNewArray(t *Thread, arrayType *class_file.ArrayType) error {
+	length, e := t.Stack.Pop()
+	if e != nil {
+		return e
+	}
+	a, e := NewArray(arrayType, length)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(a)
+}
+
 func (n *newarrayInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	return pushNewArray(t, n.arrayType)
 }
 
 func (n *anewarrayInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	return pushNewArray(t, n.arrayType)
 }
 
 func (n *arraylengthInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	o, e := PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	a, ok := o.(Array)
+	if !ok {
+		return TypeError(fmt.Sprintf("Expected an array, got %s",
+			o.TypeName()))
+	}
+	return t.Stack.Push(Int(a.Length()))
 }
 
 func (n *athrowInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	o, e := PopRefNotNull(t.Stack)
+	if e != nil {
+		return e
+	}
+	// Unverified code may try to throw an object that isn't a Throwable.
+	// Like HotSpot's verifier, reject it with a VerifyError.
+	exception, ok := o.(*ClassInstance)
+	if !ok {
+		return VerifyError("Can only throw Throwable objects, got " +
+			JavaClassName(o))
+	}
+	throwable, e := t.ParentJVM.GetClass("java/lang/Throwable")
+	if e != nil {
+		return e
+	}
+	if !exception.C.IsSubclassOf(throwable) {
+		return VerifyError("Can only throw Throwable objects, got " +
+			JavaClassName(o))
+	}
+	return &ThrownException{
+		Exception: exception,
+	}
 }
 
 func (n *checkcastInstruction) Execute(t *Thread) error {
@@ -2045,11 +2212,28 @@ func (n *instanceofInstruction) Execute(t *Thread) error {
 }
 
 func (n *monitorenterInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	o, e := t.Stack.PopRef()
+	if e != nil {
+		return e
+	}
+	monitor, e := GetMonitor(o)
+	if e != nil {
+		return e
+	}
+	monitor.Enter(t)
+	return nil
 }
 
 func (n *monitorexitInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	o, e := t.Stack.PopRef()
+	if e != nil {
+		return e
+	}
+	monitor, e := GetMonitor(o)
+	if e != nil {
+		return e
+	}
+	return monitor.Exit(t)
 }
 
 func (n *wideInstruction) Execute(t *Thread) error {
@@ -2061,7 +2245,20 @@ func (n *wideIincInstruction) Execute(t *Thread) error {
 }
 
 func (n *multianewarrayInstruction) Execute(t *Thread) error {
-	return NotImplementedError
+	// The length of the outermost dimension is deepest on the stack.
+	lengths := make([]Int, n.dimensions)
+	var e error
+	for i := len(lengths) - 1; i >= 0; i-- {
+		lengths[i], e = t.Stack.Pop()
+		if e != nil {
+			return e
+		}
+	}
+	a, e := NewMultiArray(n.arrayType, lengths)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushRef(a)
 }
 
 func (n *ifnullInstruction) Execute(t *Thread) error {
@@ -0,0 +1,891 @@
+package bs_jvm
+
+// This file contains an implementation of the format strings used by Java's
+// java.util.Formatter class, which is used by methods such as String.format
+// and PrintStream.printf. Java's format strings differ from Go's in many
+// details, so this can't simply use the fmt package.
+
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Matches a single format specifier at the start of a string. The groups are
+// the explicit argument index, flags, width, precision, date/time prefix, and
+// conversion, in that order. This is the same pattern used by Java.
+var formatSpecifierRegex = regexp.MustCompile(
+	`^%(\d+\$)?([-#+ 0,(<]*)(\d+)?(\.\d+)?([tT])?([a-zA-Z%])`)
+
+// Java's format flags, in the order Java uses when printing them.
+const formatFlags = "-#+ 0,(<"
+
+// Holds a single parsed format specifier, e.g. "%-10.3s".
+type formatSpecifier struct {
+	// The 1-based index of the argument given using "n$". This is 0 if the
+	// specifier uses the next ordinary argument, and -1 if it reuses the
+	// previous argument, i.e. it has the '<' flag.
+	index int
+	// The specifier's flags. Doesn't include duplicates.
+	flags string
+	// The minimum width of the output, or -1 if no width was given.
+	width int
+	// The precision, or -1 if no precision was given.
+	precision int
+	// The conversion character, converted to lowercase.
+	conversion byte
+	// Will be true if the conversion character was uppercase, in which case
+	// the output is converted to uppercase.
+	upper bool
+}
+
+// Returns true if the specifier includes the given flag.
+func (s *formatSpecifier) hasFlag(flag byte) bool {
+	return strings.IndexByte(s.flags, flag) >= 0
+}
+
+// Returns true if the specifier doesn't consume an argument.
+func (s *formatSpecifier) takesNoArgument() bool {
+	return (s.conversion == '%') || (s.conversion == 'n')
+}
+
+// Reconstructs the specifier, in the same format Java uses in exception
+// messages.
+func (s *formatSpecifier) String() string {
+	toReturn := "%" + s.flags
+	if s.index > 0 {
+		toReturn += strconv.Itoa(s.index) + "$"
+	}
+	if s.width != -1 {
+		toReturn += strconv.Itoa(s.width)
+	}
+	if s.precision != -1 {
+		toReturn += "." + strconv.Itoa(s.precision)
+	}
+	if s.upper {
+		return toReturn + strings.ToUpper(string(s.conversion))
+	}
+	return toReturn + string(s.conversion)
+}
+
+// Returns a FormatError with a FormatFlagsConversionMismatchException if the
+// specifier contains any of the given flags.
+func (s *formatSpecifier) checkBadFlags(flags string) error {
+	for i := range flags {
+		if s.hasFlag(flags[i]) {
+			return &FormatError{
+				Exception: "FormatFlagsConversionMismatchException",
+				Message: fmt.Sprintf("Conversion = %c, Flags = %c",
+					s.conversion, flags[i]),
+			}
+		}
+	}
+	return nil
+}
+
+// Returns an error if the specifier's width and flags are invalid for a
+// numeric conversion.
+func (s *formatSpecifier) checkNumeric() error {
+	if (s.width == -1) && (s.hasFlag('-') || s.hasFlag('0')) {
+		return &FormatError{
+			Exception: "MissingFormatWidthException",
+			Message:   s.String(),
+		}
+	}
+	if (s.hasFlag('+') && s.hasFlag(' ')) ||
+		(s.hasFlag('-') && s.hasFlag('0')) {
+		return &FormatError{
+			Exception: "IllegalFormatFlagsException",
+			Message:   fmt.Sprintf("Flags = '%s'", s.flags),
+		}
+	}
+	return nil
+}
+
+// Returns an error if the precision isn't -1.
+func (s *formatSpecifier) checkNoPrecision() error {
+	if s.precision == -1 {
+		return nil
+	}
+	return &FormatError{
+		Exception: "IllegalFormatPrecisionException",
+		Message:   strconv.Itoa(s.precision),
+	}
+}
+
+// Makes sure the specifier's flags, width and precision are valid for its
+// conversion, using the same rules as Java.
+func (s *formatSpecifier) validate() error {
+	var e error
+	switch s.conversion {
+	case 'b', 'h', 's':
+		if s.conversion != 's' {
+			e = s.checkBadFlags("#")
+			if e != nil {
+				return e
+			}
+		}
+		if (s.width == -1) && s.hasFlag('-') {
+			return &FormatError{
+				Exception: "MissingFormatWidthException",
+				Message:   s.String(),
+			}
+		}
+		return s.checkBadFlags("+ 0,(")
+	case 'c':
+		e = s.checkNoPrecision()
+		if e != nil {
+			return e
+		}
+		e = s.checkBadFlags("#+ 0,(")
+		if e != nil {
+			return e
+		}
+		if (s.width == -1) && s.hasFlag('-') {
+			return &FormatError{
+				Exception: "MissingFormatWidthException",
+				Message:   s.String(),
+			}
+		}
+		return nil
+	case 'd', 'o', 'x':
+		e = s.checkNumeric()
+		if e != nil {
+			return e
+		}
+		e = s.checkNoPrecision()
+		if e != nil {
+			return e
+		}
+		if s.conversion == 'd' {
+			return s.checkBadFlags("#")
+		}
+		return s.checkBadFlags(",")
+	case 'e', 'f', 'g', 'a':
+		e = s.checkNumeric()
+		if e != nil {
+			return e
+		}
+		switch s.conversion {
+		case 'e':
+			return s.checkBadFlags(",")
+		case 'g':
+			return s.checkBadFlags("#")
+		case 'a':
+			return s.checkBadFlags("(,")
+		}
+		return nil
+	case '%':
+		e = s.checkNoPrecision()
+		if e != nil {
+			return e
+		}
+		if (s.flags != "") && (s.flags != "-") {
+			return &FormatError{
+				Exception: "IllegalFormatFlagsException",
+				Message:   fmt.Sprintf("Flags = '%s'", s.flags),
+			}
+		}
+		if (s.width == -1) && s.hasFlag('-') {
+			return &FormatError{
+				Exception: "MissingFormatWidthException",
+				Message:   s.String(),
+			}
+		}
+		return nil
+	case 'n':
+		e = s.checkNoPrecision()
+		if e != nil {
+			return e
+		}
+		if s.width != -1 {
+			return &FormatError{
+				Exception: "IllegalFormatWidthException",
+				Message:   strconv.Itoa(s.width),
+			}
+		}
+		if s.flags != "" {
+			return &FormatError{
+				Exception: "IllegalFormatFlagsException",
+				Message:   fmt.Sprintf("Flags = '%s'", s.flags),
+			}
+		}
+		return nil
+	}
+	return &FormatError{
+		Exception: "UnknownFormatConversionException",
+		Message:   fmt.Sprintf("Conversion = '%c'", s.conversion),
+	}
+}
+
+// Parses the flags in a format specifier, returning them in Java's order.
+func parseFormatFlags(flags string) (string, error) {
+	var present [len(formatFlags)]bool
+	for i := range flags {
+		n := strings.IndexByte(formatFlags, flags[i])
+		if present[n] {
+			return "", &FormatError{
+				Exception: "DuplicateFormatFlagsException",
+				Message:   fmt.Sprintf("Flags = '%c'", flags[i]),
+			}
+		}
+		present[n] = true
+	}
+	toReturn := make([]byte, 0, len(flags))
+	for i, p := range present {
+		if p {
+			toReturn = append(toReturn, formatFlags[i])
+		}
+	}
+	return string(toReturn), nil
+}
+
+// Parses the format specifier at the start of the given string, which must
+// start with '%'. Returns the specifier and its length in bytes.
+func parseFormatSpecifier(format string) (*formatSpecifier, int, error) {
+	groups := formatSpecifierRegex.FindStringSubmatch(format)
+	if groups == nil {
+		// Like Java, report the first character that doesn't fit the
+		// pattern, or '%' if the string ends first.
+		c := byte('%')
+		rest := strings.TrimLeft(format[1:], "0123456789$-#+ ,(<.")
+		if rest != "" {
+			c = rest[0]
+		}
+		return nil, 0, &FormatError{
+			Exception: "UnknownFormatConversionException",
+			Message:   fmt.Sprintf("Conversion = '%c'", c),
+		}
+	}
+	var e error
+	toReturn := &formatSpecifier{
+		width:      -1,
+		precision:  -1,
+		conversion: groups[6][0],
+	}
+	if groups[1] != "" {
+		toReturn.index, e = strconv.Atoi(strings.TrimSuffix(groups[1], "$"))
+		if (e != nil) || (toReturn.index == 0) {
+			return nil, 0, &FormatError{
+				Exception: "IllegalFormatArgumentIndexException",
+				Message:   "Illegal format argument index = " + groups[1],
+			}
+		}
+	}
+	toReturn.flags, e = parseFormatFlags(groups[2])
+	if e != nil {
+		return nil, 0, e
+	}
+	if toReturn.hasFlag('<') {
+		toReturn.index = -1
+	}
+	if groups[3] != "" {
+		toReturn.width, e = strconv.Atoi(groups[3])
+		if e != nil {
+			return nil, 0, &FormatError{
+				Exception: "IllegalFormatWidthException",
+				Message:   groups[3],
+			}
+		}
+	}
+	if groups[4] != "" {
+		toReturn.precision, e = strconv.Atoi(groups[4][1:])
+		if e != nil {
+			return nil, 0, &FormatError{
+				Exception: "IllegalFormatPrecisionException",
+				Message:   groups[4][1:],
+			}
+		}
+	}
+	if groups[5] != "" {
+		// We don't support date and time conversions.
+		return nil, 0, &FormatError{
+			Exception: "UnknownFormatConversionException",
+			Message:   fmt.Sprintf("Conversion = '%s%s'", groups[5], groups[6]),
+		}
+	}
+	c := toReturn.conversion
+	if strings.IndexByte("BHSCXEGA", c) >= 0 {
+		toReturn.upper = true
+		toReturn.conversion = c - 'A' + 'a'
+	}
+	e = toReturn.validate()
+	if e != nil {
+		return nil, 0, e
+	}
+	return toReturn, len(groups[0]), nil
+}
+
+// Returns the length of the string in UTF-16 code units, like Java's
+// String.length().
+func javaStringLength(s string) int {
+	toReturn := 0
+	for _, r := range s {
+		toReturn++
+		if r >= 0x10000 {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Pads the string with spaces to the specifier's width, if necessary.
+func (s *formatSpecifier) justify(str string) string {
+	padding := s.width - javaStringLength(str)
+	if padding <= 0 {
+		return str
+	}
+	if s.hasFlag('-') {
+		return str + strings.Repeat(" ", padding)
+	}
+	return strings.Repeat(" ", padding) + str
+}
+
+// Applies the specifier's precision, case, and width to a string produced by
+// a general or character conversion.
+func (s *formatSpecifier) finishString(str string) string {
+	if (s.precision != -1) && (s.precision < javaStringLength(str)) {
+		chars := utf16.Encode([]rune(str))
+		str = string(utf16.Decode(chars[:s.precision]))
+	}
+	if s.upper {
+		str = strings.ToUpper(str)
+	}
+	return s.justify(str)
+}
+
+// Returns an IllegalFormatConversionException for an argument that can't be
+// used with the specifier's conversion.
+func (s *formatSpecifier) conversionError(arg Object) error {
+	return &FormatError{
+		Exception: "IllegalFormatConversionException",
+		Message: fmt.Sprintf("%c != %s", s.conversion,
+			JavaClassName(arg)),
+	}
+}
+
+// Inserts commas between groups of three digits in a string of digits.
+func groupDigits(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var sb strings.Builder
+	first := len(digits) % 3
+	if first == 0 {
+		first = 3
+	}
+	sb.WriteString(digits[:first])
+	for i := first; i < len(digits); i += 3 {
+		sb.WriteByte(',')
+		sb.WriteString(digits[i : i+3])
+	}
+	return sb.String()
+}
+
+// Returns the sign that precedes a number's magnitude, based on the flags.
+func (s *formatSpecifier) leadingSign(negative bool) string {
+	if !negative {
+		if s.hasFlag('+') {
+			return "+"
+		}
+		if s.hasFlag(' ') {
+			return " "
+		}
+		return ""
+	}
+	if s.hasFlag('(') {
+		return "("
+	}
+	return "-"
+}
+
+// Combines a number's sign and magnitude, which may contain a decimal point,
+// applying grouping and zero-padding. Padding is applied so that the sign and
+// magnitude, followed by a string of length suffixLength, fill the width.
+// Doesn't apply the trailing parenthesis for negative numbers.
+func (s *formatSpecifier) formatMagnitude(negative bool, magnitude string,
+	suffixLength int) string {
+	sign := s.leadingSign(negative)
+	if s.hasFlag(',') {
+		dot := strings.IndexByte(magnitude, '.')
+		if dot < 0 {
+			dot = len(magnitude)
+		}
+		magnitude = groupDigits(magnitude[:dot]) + magnitude[dot:]
+	}
+	width := s.width - suffixLength
+	if negative && s.hasFlag('(') {
+		width--
+	}
+	if (s.width != -1) && s.hasFlag('0') {
+		padding := width - len(sign) - len(magnitude)
+		if padding > 0 {
+			magnitude = strings.Repeat("0", padding) + magnitude
+		}
+	}
+	return sign + magnitude
+}
+
+// Formats an integer using the d, o, or x conversions. The bits arg gives the
+// size of the argument's Java type, used to print negative numbers in octal or
+// hexadecimal using two's complement.
+func (s *formatSpecifier) formatInteger(v int64, bits uint) (string, error) {
+	if s.conversion == 'd' {
+		negative := v < 0
+		magnitude := strconv.FormatUint(uint64(v), 10)
+		if negative {
+			magnitude = strconv.FormatUint(-uint64(v), 10)
+		}
+		str := s.formatMagnitude(negative, magnitude, 0)
+		if negative && s.hasFlag('(') {
+			str += ")"
+		}
+		return s.justify(str), nil
+	}
+	e := s.checkBadFlags("+ (")
+	if e != nil {
+		return "", e
+	}
+	u := uint64(v)
+	if bits < 64 {
+		u &= (uint64(1) << bits) - 1
+	}
+	var prefix, digits string
+	if s.conversion == 'o' {
+		digits = strconv.FormatUint(u, 8)
+		if s.hasFlag('#') {
+			prefix = "0"
+		}
+	} else {
+		digits = strconv.FormatUint(u, 16)
+		if s.hasFlag('#') {
+			prefix = "0x"
+		}
+	}
+	if s.hasFlag('0') {
+		padding := s.width - len(prefix) - len(digits)
+		if padding > 0 {
+			digits = strings.Repeat("0", padding) + digits
+		}
+	}
+	str := prefix + digits
+	if s.upper {
+		str = strings.ToUpper(str)
+	}
+	return s.justify(str), nil
+}
+
+// Returns the shortest string of decimal digits that uniquely identifies v,
+// which must be finite and positive, along with its decimal exponent, such
+// that v = 0.digits * 10^exponent. Java's Formatter rounds these digits,
+// rather than the exact binary value, so for example 0.125 and 1.005 both
+// round up when printed with two digits after the decimal point.
+func shortestDecimalDigits(v float64) ([]byte, int) {
+	tmp := strconv.FormatFloat(v, 'e', -1, 64)
+	exponentStart := strings.IndexByte(tmp, 'e')
+	exponent, _ := strconv.Atoi(tmp[exponentStart+1:])
+	digits := []byte(strings.Replace(tmp[:exponentStart], ".", "", 1))
+	return digits, exponent + 1
+}
+
+// Rounds the digits returned by shortestDecimalDigits so that at most count
+// digits remain, rounding half up. Returns the new digits and exponent. The
+// count may be zero or negative, in which case the result may be zero, which
+// is represented by an empty slice of digits.
+func roundDecimalDigits(digits []byte, exponent, count int) ([]byte, int) {
+	if count >= len(digits) {
+		return digits, exponent
+	}
+	if count < 0 {
+		return nil, exponent
+	}
+	roundUp := digits[count] >= '5'
+	digits = append([]byte{}, digits[:count]...)
+	if !roundUp {
+		return digits, exponent
+	}
+	for i := count - 1; i >= 0; i-- {
+		if digits[i] != '9' {
+			digits[i]++
+			return digits, exponent
+		}
+		digits[i] = '0'
+	}
+	// All of the digits were 9s, or there were no digits left.
+	return append([]byte{'1'}, digits...), exponent + 1
+}
+
+// Returns the digit at the given index, or '0' if it's out of range.
+func digitAt(digits []byte, i int) byte {
+	if (i < 0) || (i >= len(digits)) {
+		return '0'
+	}
+	return digits[i]
+}
+
+// Converts digits and an exponent, as returned by shortestDecimalDigits, to a
+// decimal string with the given number of digits after the decimal point. The
+// digits must already be rounded.
+func decimalDigitsToString(digits []byte, exponent, precision int,
+	alternate bool) string {
+	var sb strings.Builder
+	if exponent <= 0 {
+		sb.WriteByte('0')
+	}
+	for i := 0; i < exponent; i++ {
+		sb.WriteByte(digitAt(digits, i))
+	}
+	if (precision > 0) || alternate {
+		sb.WriteByte('.')
+	}
+	for i := 0; i < precision; i++ {
+		sb.WriteByte(digitAt(digits, exponent+i))
+	}
+	return sb.String()
+}
+
+// Formats a floating-point value using the e, f, g, or a conversions.
+func (s *formatSpecifier) formatFloat(v float64) (string, error) {
+	if math.IsNaN(v) {
+		return s.finishNumber("NaN"), nil
+	}
+	// Like Java, treat negative zero as negative.
+	negative := math.Signbit(v)
+	v = math.Abs(v)
+	if math.IsInf(v, 0) {
+		str := s.leadingSign(negative) + "Infinity"
+		if negative && s.hasFlag('(') {
+			str += ")"
+		}
+		return s.finishNumber(str), nil
+	}
+	if s.conversion == 'a' {
+		return s.formatHexFloat(negative, v), nil
+	}
+	precision := s.precision
+	if precision == -1 {
+		precision = 6
+	}
+	var digits []byte
+	exponent := 0
+	if v != 0 {
+		digits, exponent = shortestDecimalDigits(v)
+	}
+	conversion := s.conversion
+	if conversion == 'g' {
+		if precision == 0 {
+			precision = 1
+		}
+		if v == 0 {
+			conversion = 'f'
+			precision--
+		} else {
+			digits, exponent = roundDecimalDigits(digits, exponent, precision)
+			if (exponent-1 < -4) || (exponent-1 >= precision) {
+				conversion = 'e'
+				precision--
+			} else {
+				conversion = 'f'
+				precision -= exponent
+			}
+		}
+	}
+	var magnitude, suffix string
+	if conversion == 'f' {
+		digits, exponent = roundDecimalDigits(digits, exponent,
+			exponent+precision)
+		magnitude = decimalDigitsToString(digits, exponent, precision,
+			s.hasFlag('#'))
+	} else {
+		digits, exponent = roundDecimalDigits(digits, exponent, precision+1)
+		if len(digits) == 0 {
+			// The value is zero, which Java prints with a zero exponent.
+			exponent = 1
+		}
+		magnitude = decimalDigitsToString(digits, 1, precision, s.hasFlag('#'))
+		exponentSign := '+'
+		if exponent-1 < 0 {
+			exponentSign = '-'
+		}
+		suffix = fmt.Sprintf("e%c%02d", exponentSign, absInt(exponent-1))
+	}
+	str := s.formatMagnitude(negative, magnitude, len(suffix)) + suffix
+	if negative && s.hasFlag('(') {
+		str += ")"
+	}
+	return s.finishNumber(str), nil
+}
+
+// Returns v, which must be finite and positive, in the hexadecimal format used
+// by Java's Double.toHexString, without the leading "0x".
+func javaHexDouble(v float64) string {
+	if v == 0 {
+		return "0.0p0"
+	}
+	bits := math.Float64bits(v)
+	exponent := int(bits>>52) - 1023
+	lead := "1."
+	if exponent == -1023 {
+		// Subnormal numbers use a leading 0, with the minimum exponent.
+		lead = "0."
+		exponent = -1022
+	}
+	mantissa := fmt.Sprintf("%013x", bits&((uint64(1)<<52)-1))
+	mantissa = strings.TrimRight(mantissa, "0")
+	if mantissa == "" {
+		mantissa = "0"
+	}
+	return lead + mantissa + "p" + strconv.Itoa(exponent)
+}
+
+// Returns v, which must be finite and positive, in hexadecimal with the given
+// number of hexadecimal digits after the point, or all of the digits if the
+// precision is 0. Like Java, rounds half-even and normalizes subnormal values
+// when the precision is between 1 and 12. Doesn't include the leading "0x".
+func hexDoubleWithPrecision(v float64, precision int) string {
+	if (v == 0) || (precision == 0) || (precision >= 13) {
+		return javaHexDouble(v)
+	}
+	subnormal := (math.Float64bits(v) >> 52) == 0
+	if subnormal {
+		v *= math.Ldexp(1, 54)
+	}
+	shift := uint(52 - 4*precision)
+	bits := math.Float64bits(v)
+	significand := bits >> shift
+	discarded := bits & ((uint64(1) << shift) - 1)
+	half := uint64(1) << (shift - 1)
+	if (discarded > half) || ((discarded == half) && (significand&1 != 0)) {
+		significand++
+	}
+	rounded := math.Float64frombits(significand << shift)
+	if math.IsInf(rounded, 0) {
+		return "1.0p1024"
+	}
+	toReturn := javaHexDouble(rounded)
+	if !subnormal {
+		return toReturn
+	}
+	p := strings.IndexByte(toReturn, 'p')
+	exponent, _ := strconv.Atoi(toReturn[p+1:])
+	return toReturn[:p+1] + strconv.Itoa(exponent-54)
+}
+
+// Formats a finite floating-point value using the a conversion. Like Java,
+// zero-padding doesn't take the precision into account.
+func (s *formatSpecifier) formatHexFloat(negative bool, v float64) string {
+	precision := s.precision
+	if precision == -1 {
+		precision = 0
+	} else if precision == 0 {
+		precision = 1
+	}
+	hex := hexDoubleWithPrecision(v, precision)
+	var sb strings.Builder
+	sb.WriteString(s.leadingSign(negative))
+	sb.WriteString("0x")
+	if s.hasFlag('0') {
+		leading := 2
+		if negative || s.hasFlag(' ') || s.hasFlag('+') {
+			leading = 3
+		}
+		padding := s.width - len(hex) - leading
+		if padding > 0 {
+			sb.WriteString(strings.Repeat("0", padding))
+		}
+	}
+	p := strings.IndexByte(hex, 'p')
+	sb.WriteString(hex[:p])
+	if precision != 0 {
+		dot := strings.IndexByte(hex, '.')
+		for i := p - dot - 1; i < precision; i++ {
+			sb.WriteByte('0')
+		}
+	}
+	sb.WriteString(hex[p:])
+	return s.finishNumber(sb.String())
+}
+
+// Returns the absolute value of an int.
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Applies the specifier's case and width to a formatted number.
+func (s *formatSpecifier) finishNumber(str string) string {
+	if s.upper {
+		str = strings.ToUpper(str)
+	}
+	return s.justify(str)
+}
+
+// Formats a single argument using the given specifier.
+func (t *Thread) formatArgument(s *formatSpecifier, arg Object) (string,
+	error) {
+	switch s.conversion {
+	case '%':
+		return s.justify("%"), nil
+	case 'n':
+		return "\n", nil
+	case 'b':
+		if IsNull(arg) {
+			return s.finishString("false"), nil
+		}
+		boxed := GetBoxedValue(arg)
+		if (boxed != nil) && (boxed.Type == 'Z') {
+			return s.finishString(boxed.JavaString()), nil
+		}
+		return s.finishString("true"), nil
+	case 'h':
+		if IsNull(arg) {
+			return s.finishString("null"), nil
+		}
+		hash, e := t.ObjectHashCode(arg)
+		if e != nil {
+			return "", e
+		}
+		return s.finishString(strconv.FormatUint(uint64(uint32(hash)), 16)),
+			nil
+	case 's':
+		if IsNull(arg) {
+			return s.finishString("null"), nil
+		}
+		// Java only allows '#' for objects implementing Formattable, which
+		// we don't support.
+		e := s.checkBadFlags("#")
+		if e != nil {
+			return "", e
+		}
+		str, e := t.ObjectToString(arg)
+		if e != nil {
+			return "", e
+		}
+		return s.finishString(str), nil
+	}
+	if IsNull(arg) {
+		return s.finishString("null"), nil
+	}
+	value, valueType := unboxFormatArgument(arg)
+	switch s.conversion {
+	case 'c':
+		if strings.IndexByte("BCSI", byte(valueType)) < 0 {
+			break
+		}
+		c := value.(Int)
+		if (c < 0) || (c > 0x10ffff) {
+			return "", &FormatError{
+				Exception: "IllegalFormatCodePointException",
+				Message:   fmt.Sprintf("Code point = 0x%x", uint32(c)),
+			}
+		}
+		return s.finishString(string(rune(c))), nil
+	case 'd', 'o', 'x':
+		switch valueType {
+		case 'B':
+			return s.formatInteger(int64(value.(Int)), 8)
+		case 'S':
+			return s.formatInteger(int64(value.(Int)), 16)
+		case 'I':
+			return s.formatInteger(int64(value.(Int)), 32)
+		case 'J':
+			return s.formatInteger(int64(value.(Long)), 64)
+		}
+	case 'e', 'f', 'g', 'a':
+		switch valueType {
+		case 'F':
+			return s.formatFloat(float64(value.(Float)))
+		case 'D':
+			return s.formatFloat(float64(value.(Double)))
+		}
+	}
+	return "", s.conversionError(arg)
+}
+
+// Returns the primitive value held by a format argument, along with its type.
+// Boxed values, such as instances of java/lang/Integer, are unwrapped, and
+// primitive values are returned as-is. Returns a zero type if the argument
+// doesn't hold a primitive value.
+func unboxFormatArgument(arg Object) (Object, class_file.PrimitiveFieldType) {
+	boxed := GetBoxedValue(arg)
+	if boxed != nil {
+		return boxed.Value, boxed.Type
+	}
+	switch arg.(type) {
+	case Int:
+		return arg, 'I'
+	case Long:
+		return arg, 'J'
+	case Float:
+		return arg, 'F'
+	case Double:
+		return arg, 'D'
+	}
+	return arg, 0
+}
+
+// Formats the args using a format string with the syntax used by Java's
+// java.util.Formatter, returning the resulting string. A nil args slice is
+// treated like a null array in Java, so every specifier receives a null
+// argument. Returns a *FormatError if the format string is invalid or doesn't
+// match the args. Must only be called from a native method, since the args'
+// toString() methods may need to run.
+func (t *Thread) FormatString(format string, args []Object) (string, error) {
+	// Like Java, parse the entire format string before formatting any args,
+	// so that syntax errors are reported first.
+	var literals []string
+	var specifiers []*formatSpecifier
+	for {
+		n := strings.IndexByte(format, '%')
+		if n < 0 {
+			literals = append(literals, format)
+			break
+		}
+		literals = append(literals, format[:n])
+		specifier, length, e := parseFormatSpecifier(format[n:])
+		if e != nil {
+			return "", e
+		}
+		specifiers = append(specifiers, specifier)
+		format = format[n+length:]
+	}
+	var sb strings.Builder
+	last := -1
+	ordinary := -1
+	for i, specifier := range specifiers {
+		sb.WriteString(literals[i])
+		var arg Object
+		if !specifier.takesNoArgument() {
+			switch {
+			case specifier.index == 0:
+				ordinary++
+				last = ordinary
+			case specifier.index > 0:
+				last = specifier.index - 1
+			}
+			if (last < 0) || ((args != nil) && (last >= len(args))) {
+				return "", &FormatError{
+					Exception: "MissingFormatArgumentException",
+					Message: fmt.Sprintf("Format specifier '%s'",
+						specifier),
+				}
+			}
+			if args != nil {
+				arg = args[last]
+			}
+		}
+		str, e := t.formatArgument(specifier, arg)
+		if e != nil {
+			return "", e
+		}
+		sb.WriteString(str)
+	}
+	sb.WriteString(literals[len(literals)-1])
+	return sb.String(), nil
+}
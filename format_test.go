@@ -0,0 +1,222 @@
+package bs_jvm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns an instance of a stub class wrapping the given primitive value, in
+// the same way as the builtin java/lang/Integer and similar classes.
+func boxTestValue(t class_file.PrimitiveFieldType, v Object) Object {
+	return &ClassInstance{
+		C: &Class{
+			Name: []byte(BoxedClassName(t)),
+		},
+		NativeData: &BoxedValue{
+			Type:  t,
+			Value: v,
+		},
+	}
+}
+
+// Holds one of the test cases in test_data/format_strings.json. The expected
+// strings were produced by HotSpot's String.format.
+type formatTestCase struct {
+	Format string
+	// Each arg is null, or a type descriptor followed by a colon and the
+	// value, e.g. "I:-42" or "D:NaN". The "s" type is used for strings. A
+	// null list of args represents a null array, so every specifier receives
+	// null.
+	Args     []*string
+	Expected string
+}
+
+// Converts one of the args in a formatTestCase to an Object.
+func parseFormatTestArg(arg *string) (Object, error) {
+	if arg == nil {
+		return nil, nil
+	}
+	colon := strings.IndexByte(*arg, ':')
+	if colon != 1 {
+		return nil, fmt.Errorf("Invalid format test arg: %q", *arg)
+	}
+	t := class_file.PrimitiveFieldType((*arg)[0])
+	value := (*arg)[2:]
+	var e error
+	var v Object
+	switch t {
+	case 's':
+		return NewStringObject(value), nil
+	case 'Z':
+		var b bool
+		b, e = strconv.ParseBool(value)
+		v = Int(0)
+		if b {
+			v = Int(1)
+		}
+	case 'B', 'C', 'S', 'I':
+		var i int64
+		i, e = strconv.ParseInt(value, 10, 32)
+		v = Int(i)
+	case 'J':
+		var i int64
+		i, e = strconv.ParseInt(value, 10, 64)
+		v = Long(i)
+	case 'F':
+		var f float64
+		f, e = strconv.ParseFloat(value, 32)
+		v = Float(f)
+	case 'D':
+		var f float64
+		f, e = strconv.ParseFloat(value, 64)
+		v = Double(f)
+	default:
+		return nil, fmt.Errorf("Invalid format test arg type: %q", *arg)
+	}
+	if e != nil {
+		return nil, fmt.Errorf("Invalid format test arg %q: %w", *arg, e)
+	}
+	return boxTestValue(t, v), nil
+}
+
+func TestFormatString(t *testing.T) {
+	thread := &Thread{Name: "formatter"}
+	content, e := ioutil.ReadFile("test_data/format_strings.json")
+	if e != nil {
+		t.Logf("Failed reading format test cases: %s\n", e)
+		t.FailNow()
+	}
+	var tests []formatTestCase
+	e = json.Unmarshal(content, &tests)
+	if e != nil {
+		t.Logf("Failed parsing format test cases: %s\n", e)
+		t.FailNow()
+	}
+	if len(tests) == 0 {
+		t.Logf("Didn't find any format test cases\n")
+		t.FailNow()
+	}
+	for _, test := range tests {
+		var args []Object
+		if test.Args != nil {
+			args = make([]Object, len(test.Args))
+		}
+		for i, arg := range test.Args {
+			args[i], e = parseFormatTestArg(arg)
+			if e != nil {
+				t.Logf("Bad test case for %q: %s\n", test.Format, e)
+				t.FailNow()
+			}
+		}
+		result, e := thread.FormatString(test.Format, args)
+		if e != nil {
+			t.Logf("Failed formatting %q: %s\n", test.Format, e)
+			t.Fail()
+			continue
+		}
+		if result != test.Expected {
+			t.Logf("Formatting %q: expected %q, got %q\n", test.Format,
+				test.Expected, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestFormatStringErrors(t *testing.T) {
+	thread := &Thread{Name: "formatter"}
+	tests := []struct {
+		format    string
+		args      []Object
+		exception string
+		message   string
+	}{
+		{"%d", []Object{NewStringObject("x")},
+			"IllegalFormatConversionException", "d != java.lang.String"},
+		{"%d", []Object{boxTestValue('D', Double(1))},
+			"IllegalFormatConversionException", "d != java.lang.Double"},
+		{"%c", []Object{boxTestValue('J', Long(65))},
+			"IllegalFormatConversionException", "c != java.lang.Long"},
+		{"%s %s", []Object{NewStringObject("x")},
+			"MissingFormatArgumentException", "Format specifier '%s'"},
+		{"%<s", []Object{NewStringObject("x")},
+			"MissingFormatArgumentException", "Format specifier '%<s'"},
+		{"%q", []Object{}, "UnknownFormatConversionException",
+			"Conversion = 'q'"},
+		{"%-d", []Object{boxTestValue('I', Int(1))},
+			"MissingFormatWidthException", "%-d"},
+		{"%#d", []Object{boxTestValue('I', Int(1))},
+			"FormatFlagsConversionMismatchException",
+			"Conversion = d, Flags = #"},
+		{"%.2d", []Object{boxTestValue('I', Int(1))},
+			"IllegalFormatPrecisionException", "2"},
+		{"%--s", []Object{NewStringObject("x")},
+			"DuplicateFormatFlagsException", "Flags = '-'"},
+		{"%c", []Object{boxTestValue('I', Int(0x110000))},
+			"IllegalFormatCodePointException", "Code point = 0x110000"},
+	}
+	for _, test := range tests {
+		result, e := thread.FormatString(test.format, test.args)
+		if e == nil {
+			t.Logf("Didn't get expected error formatting %q. Got %q\n",
+				test.format, result)
+			t.Fail()
+			continue
+		}
+		var formatError *FormatError
+		if !errors.As(e, &formatError) {
+			t.Logf("Formatting %q returned an unexpected error: %s\n",
+				test.format, e)
+			t.Fail()
+			continue
+		}
+		if (formatError.Exception != test.exception) ||
+			(formatError.Message != test.message) {
+			t.Logf("Formatting %q: expected %s(%q), got %s(%q)\n",
+				test.format, test.exception, test.message,
+				formatError.Exception, formatError.Message)
+			t.Fail()
+		}
+	}
+}
+
+func TestBoxedValue(t *testing.T) {
+	tests := []struct {
+		value    *BoxedValue
+		expected string
+		hashCode Int
+	}{
+		{&BoxedValue{'Z', Int(1)}, "true", 1231},
+		{&BoxedValue{'Z', Int(0)}, "false", 1237},
+		{&BoxedValue{'C', Int('x')}, "x", 120},
+		{&BoxedValue{'B', Int(-1)}, "-1", -1},
+		{&BoxedValue{'I', Int(42)}, "42", 42},
+		{&BoxedValue{'J', Long(-1)}, "-1", 0},
+		{&BoxedValue{'J', Long(1 << 32)}, "4294967296", 1},
+		{&BoxedValue{'F', Float(1.5)}, "1.5", 0x3fc00000},
+		{&BoxedValue{'D', Double(1.0)}, "1.0", 1072693248},
+		{&BoxedValue{'D', Double(math.NaN())}, "NaN", 2146959360},
+		{&BoxedValue{'D', Double(math.SmallestNonzeroFloat64)}, "4.9E-324",
+			1},
+	}
+	for _, test := range tests {
+		s := test.value.JavaString()
+		if s != test.expected {
+			t.Logf("Expected %s to be %q, got %q\n", test.value.Type,
+				test.expected, s)
+			t.Fail()
+		}
+		hash := test.value.HashCode()
+		if hash != test.hashCode {
+			t.Logf("Expected the hash code of %s %s to be %d, got %d\n",
+				test.value.Type, s, test.hashCode, hash)
+			t.Fail()
+		}
+	}
+}
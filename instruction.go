@@ -4,6 +4,8 @@ package bs_jvm
 
 import (
 	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"sync"
 )
 
 // The interface through which JVM opcodes can be inspected or executed.
@@ -443,7 +445,8 @@ type ldcInstruction struct {
 	primitiveValue Int
 	// This will be the reference to push, if isPrimitive was false. If
 	// isPrimitive was true, this will still be set, but to the primitive
-	// reference.
+	// reference. For dynamic constants, this is a *dynamicConstant, which is
+	// resolved to the value to push when the instruction runs.
 	reference Object
 }
 
@@ -493,7 +496,8 @@ type ldc2_wInstruction struct {
 	// Once again, this will contain the bits of the double-precision number
 	primitiveValue Long
 	// This will be the primitive as an object, mostly so that a string can be
-	// formatted nicely.
+	// formatted nicely. For dynamic constants, this is instead the
+	// *dynamicConstant to resolve when the instruction runs.
 	reference Object
 }
 
@@ -2644,10 +2648,10 @@ func parseLookupswitchInstruction(opcode uint8, name string, address uint,
 	currentOffset := address + 1
 	// Skip padding as in tableswitch
 	var paddingBytes int
-	if (address % 4) == 0 {
+	if (currentOffset % 4) == 0 {
 		paddingBytes = 0
 	} else {
-		paddingBytes = 4 - int(address%4)
+		paddingBytes = 4 - int(currentOffset%4)
 	}
 	if paddingBytes > 0 {
 		toReturn.skippedBytes = make([]byte, paddingBytes)
@@ -2788,6 +2792,8 @@ type getstaticInstruction struct {
 	class *Class
 	// The index into the class' StaticFieldValues array.
 	index int
+	// Checks access to the field if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func (n *getstaticInstruction) String() string {
@@ -2804,7 +2810,7 @@ func parseGetstaticInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &getstaticInstruction{*toReturn, nil, 0}, nil
+	return &getstaticInstruction{*toReturn, nil, 0, nil}, nil
 }
 
 type putstaticInstruction struct {
@@ -2813,6 +2819,8 @@ type putstaticInstruction struct {
 	class *Class
 	// The index into the class' StaticFieldValues array.
 	index int
+	// Checks access to the field if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parsePutstaticInstruction(opcode uint8, name string, address uint,
@@ -2821,7 +2829,7 @@ func parsePutstaticInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &putstaticInstruction{*toReturn, nil, 0}, nil
+	return &putstaticInstruction{*toReturn, nil, 0, nil}, nil
 }
 
 func (n *putstaticInstruction) String() string {
@@ -2834,11 +2842,14 @@ func (n *putstaticInstruction) String() string {
 
 type getfieldInstruction struct {
 	twoByteArgumentInstruction
-	// Unlike getstatic, we can't figure out a field's index until runtime,
-	// when we know what object is actually on the stack. (Technically we
-	// *could* try, but doing so would rely on better type checking so it will
-	// be easier this way for now.)
 	fieldReference *FieldOrMethodReference
+	// The class declaring the field, which the object on the stack must be an
+	// instance of (or a subclass of).
+	class *Class
+	// The index of the field in the object's FieldValues array.
+	index int
+	// Checks access to the field if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parseGetfieldInstruction(opcode uint8, name string, address uint,
@@ -2847,12 +2858,13 @@ func parseGetfieldInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &getfieldInstruction{*toReturn, nil}, nil
+	return &getfieldInstruction{*toReturn, nil, nil, 0, nil}, nil
 }
 
 func (n *getfieldInstruction) String() string {
-	if n.fieldReference != nil {
-		return "getfield " + string(n.fieldReference.Field.Name)
+	if n.class != nil {
+		return fmt.Sprintf("getfield %s.%s", n.class.Name,
+			n.fieldReference.Field.Name)
 	}
 	return fmt.Sprintf("getfield %d", n.value)
 }
@@ -2860,6 +2872,13 @@ func (n *getfieldInstruction) String() string {
 type putfieldInstruction struct {
 	twoByteArgumentInstruction
 	fieldReference *FieldOrMethodReference
+	// The class declaring the field, which the object on the stack must be an
+	// instance of (or a subclass of).
+	class *Class
+	// The index of the field in the object's FieldValues array.
+	index int
+	// Checks access to the field if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parsePutfieldInstruction(opcode uint8, name string, address uint,
@@ -2868,20 +2887,27 @@ func parsePutfieldInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &putfieldInstruction{*toReturn, nil}, nil
+	return &putfieldInstruction{*toReturn, nil, nil, 0, nil}, nil
 }
 
 func (n *putfieldInstruction) String() string {
-	if n.fieldReference != nil {
-		return "getfield " + string(n.fieldReference.Field.Name)
+	if n.class != nil {
+		return fmt.Sprintf("putfield %s.%s", n.class.Name,
+			n.fieldReference.Field.Name)
 	}
-	return fmt.Sprintf("getfield %d", n.value)
+	return fmt.Sprintf("putfield %d", n.value)
 }
 
 type invokevirtualInstruction struct {
 	twoByteArgumentInstruction
-	// The method to be invoked.
+	// The resolved method. The method that's actually invoked depends on the
+	// class of the object it's invoked on.
 	method *Method
+	// The number of reference arguments the method takes, not counting the
+	// object it's invoked on.
+	referenceArgs int
+	// Checks access to the resolved method if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parseInvokevirtualInstruction(opcode uint8, name string, address uint,
@@ -2893,6 +2919,7 @@ func parseInvokevirtualInstruction(opcode uint8, name string, address uint,
 	return &invokevirtualInstruction{
 		twoByteArgumentInstruction: *toReturn,
 		method:                     nil,
+		referenceArgs:              0,
 	}, nil
 }
 
@@ -2910,6 +2937,8 @@ type invokespecialInstruction struct {
 	twoByteArgumentInstruction
 	// The method to be invoked.
 	method *Method
+	// Checks access to the resolved method if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parseInvokespecialInstruction(opcode uint8, name string, address uint,
@@ -2937,6 +2966,8 @@ func (n *invokespecialInstruction) String() string {
 type invokestaticInstruction struct {
 	twoByteArgumentInstruction
 	method *Method
+	// Checks access to the method if it's private, otherwise nil.
+	access *privateAccessCheck
 }
 
 func parseInvokestaticInstruction(opcode uint8, name string, address uint,
@@ -2956,7 +2987,7 @@ func (n *invokestaticInstruction) String() string {
 		return fmt.Sprintf("invokestatic %d", n.value)
 	}
 	m := n.method
-	return fmt.Sprintf("invokespecial %s %s.%s(%s)",
+	return fmt.Sprintf("invokestatic %s %s.%s(%s)",
 		m.Types.ReturnString(), n.method.ContainingClass.Name, m.Name,
 		m.Types.ArgumentsString())
 }
@@ -2964,6 +2995,23 @@ func (n *invokestaticInstruction) String() string {
 type invokeinterfaceInstruction struct {
 	twoByteArgumentInstruction
 	count uint8
+	// The interface named by the instruction's method reference.
+	class *Class
+	// The resolved interface method.
+	method *Method
+	// The number of reference arguments the method takes, not counting the
+	// object it's invoked on.
+	referenceArgs int
+}
+
+func (n *invokeinterfaceInstruction) String() string {
+	if n.method == nil {
+		return fmt.Sprintf("invokeinterface %d", n.value)
+	}
+	m := n.method
+	return fmt.Sprintf("invokeinterface %s %s.%s(%s)",
+		m.Types.ReturnString(), n.class.Name, m.Name,
+		m.Types.ArgumentsString())
 }
 
 // The invokeinterface instruction contains a single 0-byte at the end.
@@ -2991,11 +3039,34 @@ func parseInvokeinterfaceInstruction(opcode uint8, name string, address uint,
 	toReturn := invokeinterfaceInstruction{
 		twoByteArgumentInstruction: *tmp,
 		count:                      count,
+		class:                      nil,
+		method:                     nil,
+		referenceArgs:              0,
 	}
 	return &toReturn, nil
 }
 
-type invokedynamicInstruction struct{ twoByteArgumentInstruction }
+type invokedynamicInstruction struct {
+	twoByteArgumentInstruction
+	// The class containing the instruction.
+	class *Class
+	// The bootstrap method used to link the call site.
+	bootstrap *ResolvedMethodHandle
+	// The static arguments passed to the bootstrap method.
+	bootstrapArgs []Object
+	// The name and type of the call site, which are also passed to the
+	// bootstrap method.
+	name       string
+	methodType *MethodType
+	descriptor *class_file.MethodDescriptor
+	// The call site is linked by running the bootstrap method the first time
+	// the instruction is executed. If linking failed, linkError is the error
+	// to throw, and callSite is nil. Hold callSiteLock when accessing callSite
+	// or linkError.
+	callSite     *CallSite
+	linkError    error
+	callSiteLock sync.Mutex
+}
 
 // The invokedynamic instruction contains two 0-bytes following the 16-bit
 // index.
@@ -3015,7 +3086,8 @@ func parseInvokedynamicInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &invokedynamicInstruction{*toReturn}, nil
+	return &invokedynamicInstruction{twoByteArgumentInstruction: *toReturn},
+		nil
 }
 
 type newInstruction struct {
@@ -3043,7 +3115,24 @@ func (n *newInstruction) String() string {
 	return fmt.Sprintf("new %s", n.class.Name)
 }
 
-type newarrayInstruction struct{ singleByteArgumentInstruction }
+type newarrayInstruction struct {
+	singleByteArgumentInstruction
+	// The type of array to create, determined by the atype argument.
+	arrayType *class_file.ArrayType
+}
+
+// Maps the atype argument of the newarray instruction to the corresponding
+// primitive type descriptor.
+var newarrayElementTypes = map[uint8]byte{
+	4:  'Z',
+	5:  'C',
+	6:  'F',
+	7:  'D',
+	8:  'B',
+	9:  'S',
+	10: 'I',
+	11: 'J',
+}
 
 func parseNewarrayInstruction(opcode uint8, name string, address uint,
 	m Memory) (Instruction, error) {
@@ -3051,10 +3140,26 @@ func parseNewarrayInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &newarrayInstruction{*toReturn}, nil
+	elementType, ok := newarrayElementTypes[toReturn.value]
+	if !ok {
+		return nil, fmt.Errorf("Invalid newarray type: %d", toReturn.value)
+	}
+	return &newarrayInstruction{
+		singleByteArgumentInstruction: *toReturn,
+		arrayType:                     primitiveArrayType(elementType),
+	}, nil
+}
+
+func (n *newarrayInstruction) String() string {
+	return fmt.Sprintf("newarray %s", n.arrayType.ContentType)
 }
 
-type anewarrayInstruction struct{ twoByteArgumentInstruction }
+type anewarrayInstruction struct {
+	twoByteArgumentInstruction
+	// The type of array to create. This is the array type itself, rather than
+	// the type of its elements.
+	arrayType *class_file.ArrayType
+}
 
 func parseAnewarrayInstruction(opcode uint8, name string, address uint,
 	m Memory) (Instruction, error) {
@@ -3062,7 +3167,17 @@ func parseAnewarrayInstruction(opcode uint8, name string, address uint,
 	if e != nil {
 		return nil, e
 	}
-	return &anewarrayInstruction{*toReturn}, nil
+	return &anewarrayInstruction{
+		twoByteArgumentInstruction: *toReturn,
+		arrayType:                  nil,
+	}, nil
+}
+
+func (n *anewarrayInstruction) String() string {
+	if n.arrayType == nil {
+		return fmt.Sprintf("anewarray %d", n.value)
+	}
+	return fmt.Sprintf("anewarray %s", ComponentType(n.arrayType))
 }
 
 type arraylengthInstruction struct{ knownInstruction }
@@ -3260,6 +3375,8 @@ func parseWideInstruction(opcode uint8, name string, address uint,
 type multianewarrayInstruction struct {
 	typeIndex  uint16
 	dimensions uint8
+	// The type of array to create, resolved from typeIndex.
+	arrayType *class_file.ArrayType
 }
 
 func (n *multianewarrayInstruction) Raw() uint8 {
@@ -3275,13 +3392,12 @@ func (n *multianewarrayInstruction) Length() uint {
 	return 4
 }
 
-func (n *multianewarrayInstruction) Optimize(m *Method, offset uint,
-	instructionIndices map[uint]int) error {
-	return nil
-}
-
 func (n *multianewarrayInstruction) String() string {
-	return fmt.Sprintf("multianewarray 0x%04x %d", n.typeIndex, n.dimensions)
+	if n.arrayType == nil {
+		return fmt.Sprintf("multianewarray 0x%04x %d", n.typeIndex,
+			n.dimensions)
+	}
+	return fmt.Sprintf("multianewarray %s %d", n.arrayType, n.dimensions)
 }
 
 func parseMultianewarrayInstruction(opcode uint8, name string, address uint,
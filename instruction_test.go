@@ -67,3 +67,103 @@ func TestGetNextInstruction(t *testing.T) {
 	}
 	t.Logf("Got expected error from GetNextInstruction: %s\n", e)
 }
+
+func TestParseLookupswitchPadding(t *testing.T) {
+	// A nop followed by a lookupswitch at address 1. The padding aligns the
+	// default offset to address 4, so there are two padding bytes.
+	codeBytes := []byte{
+		0x00,
+		0xab, 0, 0,
+		0, 0, 0, 30,
+		0, 0, 0, 2,
+		0xff, 0xff, 0xff, 0xfb, 0, 0, 0, 26,
+		0, 0, 0x03, 0xe8, 0, 0, 0, 28,
+	}
+	instruction, e := GetNextInstruction(MemoryFromSlice(codeBytes), 1)
+	if e != nil {
+		t.Logf("Failed parsing lookupswitch: %s\n", e)
+		t.FailNow()
+	}
+	if instruction.Length() != uint(len(codeBytes)-1) {
+		t.Logf("Expected lookupswitch to be %d bytes, got %d\n",
+			len(codeBytes)-1, instruction.Length())
+		t.Fail()
+	}
+	n := instruction.(*lookupswitchInstruction)
+	if n.defaultOffset != 30 {
+		t.Logf("Expected a default offset of 30, got %d\n", n.defaultOffset)
+		t.Fail()
+	}
+	expected := []lookupswitchPair{{-5, 26}, {1000, 28}}
+	if len(n.pairs) != len(expected) {
+		t.Logf("Expected %d lookupswitch pairs, got %d\n", len(expected),
+			len(n.pairs))
+		t.FailNow()
+	}
+	for i := range expected {
+		if n.pairs[i] != expected[i] {
+			t.Logf("Expected pair %d to be %v, got %v\n", i, expected[i],
+				n.pairs[i])
+			t.Fail()
+		}
+	}
+}
+
+func TestExecuteLookupswitch(t *testing.T) {
+	n := &lookupswitchInstruction{
+		pairs:        []lookupswitchPair{{-5, 0}, {7, 0}, {1000, 0}},
+		indices:      []uint{10, 20, 30},
+		defaultIndex: 40,
+	}
+	thread := &Thread{
+		Stack: NewStack(),
+	}
+	tests := []struct {
+		value    Int
+		expected uint
+	}{
+		{-5, 10}, {7, 20}, {1000, 30}, {-100, 40}, {0, 40}, {5000, 40},
+	}
+	for _, test := range tests {
+		thread.Stack.Push(test.value)
+		e := n.Execute(thread)
+		if e != nil {
+			t.Logf("Failed executing lookupswitch: %s\n", e)
+			t.FailNow()
+		}
+		if thread.InstructionIndex != test.expected {
+			t.Logf("Expected lookupswitch on %d to jump to %d, got %d\n",
+				test.value, test.expected, thread.InstructionIndex)
+			t.Fail()
+		}
+	}
+}
+
+func TestExecuteIinc(t *testing.T) {
+	// iinc 0, -1 followed by iinc 1, 127.
+	codeBytes := []byte{0x84, 0x00, 0xff, 0x84, 0x01, 0x7f}
+	memory := MemoryFromSlice(codeBytes)
+	thread := &Thread{
+		LocalVariables: []Object{Int(5), Int(-200)},
+	}
+	address := uint(0)
+	for address < uint(len(codeBytes)) {
+		instruction, e := GetNextInstruction(memory, address)
+		if e != nil {
+			t.Logf("Failed parsing iinc: %s\n", e)
+			t.FailNow()
+		}
+		e = instruction.Execute(thread)
+		if e != nil {
+			t.Logf("Failed executing iinc: %s\n", e)
+			t.FailNow()
+		}
+		address += instruction.Length()
+	}
+	if (thread.LocalVariables[0] != Int(4)) ||
+		(thread.LocalVariables[1] != Int(-73)) {
+		t.Logf("Expected locals 4 and -73 after iinc, got %s and %s\n",
+			thread.LocalVariables[0], thread.LocalVariables[1])
+		t.Fail()
+	}
+}
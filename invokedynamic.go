@@ -0,0 +1,644 @@
+package bs_jvm
+
+// This file contains code supporting the invokedynamic instruction, including
+// the objects passed to and returned by bootstrap methods, and code allowing
+// native methods to invoke method handles.
+
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"sync"
+)
+
+// Returns the entries in the class file's BootstrapMethods attribute, or nil
+// if it doesn't have one.
+func getBootstrapMethods(
+	class *class_file.Class) ([]class_file.BootstrapMethod, error) {
+	for _, a := range class.Attributes {
+		if string(a.Name) != "BootstrapMethods" {
+			continue
+		}
+		toReturn, e := class_file.ParseBootstrapMethodsAttribute(a)
+		if e != nil {
+			return nil, fmt.Errorf("Failed parsing bootstrap methods: %w", e)
+		}
+		return toReturn, nil
+	}
+	return nil, nil
+}
+
+// Bootstrap methods must return one of these, in place of a
+// java/lang/invoke/CallSite object. Implements the Object interface.
+type CallSite struct {
+	// The static method that's invoked every time the invokedynamic
+	// instruction runs. Its argument and return types must match the
+	// descriptor of the invokedynamic instruction.
+	Target *Method
+}
+
+func (s *CallSite) IsPrimitive() bool {
+	return false
+}
+
+func (s *CallSite) TypeName() string {
+	return "java/lang/invoke/CallSite"
+}
+
+func (s *CallSite) String() string {
+	return "call site targeting " + s.Target.Name
+}
+
+// Passed to bootstrap methods in place of a
+// java/lang/invoke/MethodHandles$Lookup object. Implements the Object
+// interface.
+type MethodHandlesLookup struct {
+	// The class containing the invokedynamic instruction being linked.
+	C *Class
+}
+
+func (l *MethodHandlesLookup) IsPrimitive() bool {
+	return false
+}
+
+func (l *MethodHandlesLookup) TypeName() string {
+	return "java/lang/invoke/MethodHandles$Lookup"
+}
+
+func (l *MethodHandlesLookup) String() string {
+	return "lookup in " + l.C.String()
+}
+
+// Holds a method handle that's been resolved to the method it refers to, so
+// that native methods, such as the ones implementing lambdas, can invoke it.
+type ResolvedMethodHandle struct {
+	// The kind of the method handle, which determines how the method is
+	// invoked. This is between 5 (invokevirtual) and 9 (invokeinterface),
+	// since only handles referring to methods can be resolved.
+	Kind class_file.MethodHandleReferenceKind
+	// The class the handle refers to. This is the class instantiated by
+	// newinvokespecial handles.
+	C *Class
+	// The resolved method. For newinvokespecial handles, this is the
+	// constructor.
+	Method *Method
+}
+
+// Resolves the method referred to by a method handle, which must be one of
+// the *MethodHandle types referring to a method, rather than to a field.
+func ResolveMethodHandle(handle Object) (*ResolvedMethodHandle, error) {
+	if IsNull(handle) {
+		return nil, NullReferenceError("Can't resolve a null method handle")
+	}
+	var kind class_file.MethodHandleReferenceKind
+	var reference *FieldOrMethodReference
+	switch v := handle.(type) {
+	case *InvokeVirtualMethodHandle:
+		kind = 5
+		reference = &(v.FieldOrMethodReference)
+	case *InvokeStaticMethodHandle:
+		kind = 6
+		reference = &(v.FieldOrMethodReference)
+	case *InvokeSpecialMethodHandle:
+		kind = 7
+		reference = &(v.FieldOrMethodReference)
+	case *NewInvokeSpecialMethodHandle:
+		kind = 8
+		reference = &(v.FieldOrMethodReference)
+	case *InvokeInterfaceMethodHandle:
+		kind = 9
+		reference = &(v.FieldOrMethodReference)
+	default:
+		return nil, TypeError("Can't invoke " + handle.TypeName())
+	}
+	descriptor, e := class_file.ParseMethodDescriptor(reference.Field.Type)
+	if e != nil {
+		return nil, fmt.Errorf("Failed parsing method handle descriptor: %w",
+			e)
+	}
+	key := GetMethodKey(&class_file.Method{
+		Name:       reference.Field.Name,
+		Descriptor: descriptor,
+	})
+	var method *Method
+	if reference.C.IsInterface() {
+		method, e = reference.C.ResolveInterfaceMethod(key)
+	} else {
+		method, e = reference.C.ResolveMethod(key)
+	}
+	if e != nil {
+		return nil, fmt.Errorf("Failed resolving method handle: %w", e)
+	}
+	if (kind == 6) != method.IsStatic() {
+		return nil, IncompatibleClassChangeError(fmt.Sprintf("Method %s.%s "+
+			"can't be used with a %s method handle", reference.C.Name,
+			method.Name, kind))
+	}
+	return &ResolvedMethodHandle{
+		Kind:   kind,
+		C:      reference.C,
+		Method: method,
+	}, nil
+}
+
+// Returns the types of the args needed to invoke the handle. Unlike the
+// method's descriptor, this includes the object the method is invoked on, if
+// there is one.
+func (h *ResolvedMethodHandle) ArgumentTypes() []class_file.FieldType {
+	args := h.Method.Types.ArgumentTypes
+	if (h.Kind == 6) || (h.Kind == 8) {
+		return args
+	}
+	receiverType := class_file.ClassInstanceType(h.C.Name)
+	return append([]class_file.FieldType{receiverType}, args...)
+}
+
+// Returns the type of the value produced by invoking the handle. For
+// newinvokespecial handles, this is the type of the new object.
+func (h *ResolvedMethodHandle) ReturnType() class_file.FieldType {
+	if h.Kind == 8 {
+		return class_file.ClassInstanceType(h.C.Name)
+	}
+	return h.Method.Types.ReturnType
+}
+
+// Returns the method to run when invoking the handle with the given args,
+// selecting it based on the receiver's class if necessary. For
+// newinvokespecial handles, this creates the new object and prepends it to
+// the args.
+func (h *ResolvedMethodHandle) prepareCall(args []Object) (*Method, []Object,
+	Object, error) {
+	switch h.Kind {
+	case 5, 9:
+		if (len(args) == 0) || IsNull(args[0]) {
+			return nil, nil, nil, NullReferenceError(fmt.Sprintf("Invoking "+
+				"%s.%s on a null object", h.C.Name, h.Method.Name))
+		}
+		c := h.C.ParentJVM.getRuntimeClass(args[0])
+		if c == nil {
+			return h.Method, args, nil, nil
+		}
+		method, e := c.SelectMethod(h.Method)
+		if e != nil {
+			return nil, nil, nil, e
+		}
+		return method, args, nil, nil
+	case 8:
+		instance, e := h.C.NewObject()
+		if e != nil {
+			return nil, nil, nil, fmt.Errorf("Failed creating %s: %w",
+				h.C.Name, e)
+		}
+		args = append([]Object{instance}, args...)
+		return h.Method, args, instance, nil
+	}
+	return h.Method, args, nil, nil
+}
+
+// Invokes the handle from a native method, with the given args, which must
+// start with the object the method is invoked on if there is one. The
+// handle's method returns directly to the native method's caller, as if the
+// native method had returned its result, so the native method must return
+// immediately after calling this.
+func (h *ResolvedMethodHandle) TailCall(t *Thread, args []Object) error {
+	method, args, instance, e := h.prepareCall(args)
+	if e != nil {
+		return e
+	}
+	if instance != nil {
+		// The constructor returns void, so leave the new object on the stack
+		// as the result.
+		e = t.Stack.PushRef(instance)
+		if e != nil {
+			return e
+		}
+	}
+	for _, arg := range args {
+		e = t.Stack.PushUnconditional(arg)
+		if e != nil {
+			return fmt.Errorf("Failed pushing arg for %s: %w", method.Name, e)
+		}
+	}
+	return t.Call(method)
+}
+
+// Like TailCall, but runs the handle's method to completion and returns its
+// result, using Thread.Invoke.
+func (h *ResolvedMethodHandle) Invoke(t *Thread, args []Object) (Object,
+	error) {
+	method, args, instance, e := h.prepareCall(args)
+	if e != nil {
+		return nil, e
+	}
+	toReturn, e := t.Invoke(method, args...)
+	if e != nil {
+		return nil, e
+	}
+	if instance != nil {
+		return instance, nil
+	}
+	return toReturn, nil
+}
+
+// Returns true if the two method descriptors have the same argument and return
+// types.
+func descriptorsMatch(a, b *class_file.MethodDescriptor) bool {
+	return (a.ArgumentsString() == b.ArgumentsString()) &&
+		(a.ReturnString() == b.ReturnString())
+}
+
+// Loads the bootstrap method at the given index in the class' bootstrap
+// methods, returning the resolved method handle and its static arguments.
+func loadBootstrapMethod(c *Class, index uint16) (*ResolvedMethodHandle,
+	[]Object, error) {
+	if int(index) >= len(c.BootstrapMethods) {
+		return nil, nil, fmt.Errorf("Invalid bootstrap method index: %d",
+			index)
+	}
+	bootstrap := c.BootstrapMethods[index]
+	constant, e := c.File.GetConstant(bootstrap.Reference)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Couldn't get bootstrap method handle: "+
+			"%w", e)
+	}
+	handleInfo, ok := constant.(*class_file.ConstantMethodHandleInfo)
+	if !ok {
+		return nil, nil, fmt.Errorf("Didn't get a bootstrap method handle, "+
+			"instead got: %s", constant)
+	}
+	handle, e := convertMethodHandleInfoToObject(c, handleInfo)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Failed loading bootstrap method "+
+			"handle: %w", e)
+	}
+	resolved, e := ResolveMethodHandle(handle)
+	if e != nil {
+		return nil, nil, fmt.Errorf("Failed resolving bootstrap method: %w",
+			e)
+	}
+	if resolved.Kind != 6 {
+		return nil, nil, fmt.Errorf("Unsupported bootstrap method handle "+
+			"kind: %s", resolved.Kind)
+	}
+	args := make([]Object, len(bootstrap.Arguments))
+	for i, argIndex := range bootstrap.Arguments {
+		constant, e = c.File.GetConstant(argIndex)
+		if e != nil {
+			return nil, nil, fmt.Errorf("Couldn't get bootstrap method "+
+				"argument: %w", e)
+		}
+		args[i], e = ConvertConstantToObject(c, constant)
+		if e != nil {
+			return nil, nil, fmt.Errorf("Failed loading bootstrap method "+
+				"argument: %w", e)
+		}
+	}
+	return resolved, args, nil
+}
+
+// Returns the args passed to a bootstrap method: the given lookup, name, and
+// type args, followed by the static arguments. Like Java, trailing static
+// arguments are collected into an array if the bootstrap method takes a
+// variable number of arguments.
+func getBootstrapArgs(bootstrap *ResolvedMethodHandle, toReturn,
+	staticArgs []Object) ([]Object, error) {
+	method := bootstrap.Method
+	argTypes := method.Types.ArgumentTypes
+	if (method.AccessFlags & 0x0080) == 0 {
+		return append(toReturn, staticArgs...), nil
+	}
+	fixedCount := len(argTypes) - 1 - len(toReturn)
+	arrayType, ok := argTypes[len(argTypes)-1].(*class_file.ArrayType)
+	if (fixedCount < 0) || !ok {
+		return nil, fmt.Errorf("Invalid variable arity bootstrap method %s",
+			method.Name)
+	}
+	if len(staticArgs) < fixedCount {
+		return nil, fmt.Errorf("Bootstrap method %s needs at least %d "+
+			"static arguments", method.Name, fixedCount)
+	}
+	toReturn = append(toReturn, staticArgs[:fixedCount]...)
+	rest := make([]Object, len(staticArgs)-fixedCount)
+	copy(rest, staticArgs[fixedCount:])
+	return append(toReturn, &ReferenceArray{
+		Type:     arrayType,
+		Elements: rest,
+	}), nil
+}
+
+// Converts an error returned when running a bootstrap method, or when checking
+// its result, into the error that should be thrown by the instruction that
+// needed it. Like Java, Errors are thrown as-is, and other failures are
+// wrapped in a BootstrapMethodError with the given message. If the
+// BootstrapMethodError class isn't available, this returns the original error.
+func getBootstrapError(t *Thread, e error, message string) error {
+	exception := t.errorToThrowable(e)
+	if (exception != nil) && isErrorClass(exception.C) {
+		return &ThrownException{
+			Exception: exception,
+		}
+	}
+	if exception == nil {
+		message += ": " + e.Error()
+	}
+	wrapper, e2 := t.NewThrowable("java/lang/BootstrapMethodError", message)
+	if e2 != nil {
+		return e
+	}
+	if exception != nil {
+		GetThrowableInfo(wrapper).Cause = exception
+	}
+	return &ThrownException{
+		Exception: wrapper,
+	}
+}
+
+// Runs the bootstrap method, returning the call site it links the instruction
+// to.
+func (n *invokedynamicInstruction) runBootstrap(t *Thread) (*CallSite,
+	error) {
+	args, e := getBootstrapArgs(n.bootstrap, []Object{
+		&MethodHandlesLookup{C: n.class},
+		NewStringObject(n.name),
+		n.methodType,
+	}, n.bootstrapArgs)
+	if e != nil {
+		return nil, e
+	}
+	result, e := n.bootstrap.Invoke(t, args)
+	if e != nil {
+		return nil, fmt.Errorf("Bootstrap method %s.%s failed: %w",
+			n.bootstrap.C.Name, n.bootstrap.Method.Name, e)
+	}
+	callSite, ok := result.(*CallSite)
+	if !ok || (callSite.Target == nil) {
+		return nil, TypeError(fmt.Sprintf("Bootstrap method %s.%s didn't "+
+			"return a supported call site", n.bootstrap.C.Name,
+			n.bootstrap.Method.Name))
+	}
+	target := callSite.Target
+	if !target.IsStatic() || !descriptorsMatch(target.Types, n.descriptor) {
+		return nil, TypeError(fmt.Sprintf("Call site target %s doesn't "+
+			"match type %s", target.Name, *n.methodType))
+	}
+	return callSite, nil
+}
+
+// Returns the instruction's call site, running the bootstrap method to link it
+// if this is the first time the instruction has been executed. Like Java, the
+// lock isn't held while the bootstrap method runs, so several threads may run
+// it at once. The first result to be stored, which may be an error, is used by
+// all of them, and if linking failed, the instruction throws the same
+// exception every time it's executed.
+func (n *invokedynamicInstruction) getCallSite(t *Thread) (*CallSite, error) {
+	n.callSiteLock.Lock()
+	callSite, e := n.callSite, n.linkError
+	n.callSiteLock.Unlock()
+	if (callSite != nil) || (e != nil) {
+		return callSite, e
+	}
+	callSite, e = n.runBootstrap(t)
+	if e != nil {
+		callSite = nil
+		e = getBootstrapError(t, e, "Failed linking call site "+n.name)
+	}
+	n.callSiteLock.Lock()
+	defer n.callSiteLock.Unlock()
+	if (n.callSite == nil) && (n.linkError == nil) {
+		n.callSite = callSite
+		n.linkError = e
+	}
+	return n.callSite, n.linkError
+}
+
+// Holds the value of a CONSTANT_Dynamic entry in a class' constant pool. The
+// value is computed by running the bootstrap method the first time the
+// constant is loaded, and is shared by every instruction loading the same
+// constant. Implements the Object interface, so it can be stored in place of
+// the value by ldc instructions until it's resolved.
+type dynamicConstant struct {
+	// The class containing the constant.
+	class *Class
+	// The bootstrap method computing the value, and its static arguments.
+	bootstrap     *ResolvedMethodHandle
+	bootstrapArgs []Object
+	// The name and type of the constant, which are also passed to the
+	// bootstrap method.
+	name      string
+	fieldType class_file.FieldType
+	// Set once the bootstrap method has run. If resolution failed, err is the
+	// error to throw, and value is nil. Hold lock when accessing value, err,
+	// or resolved.
+	value    Object
+	err      error
+	resolved bool
+	lock     sync.Mutex
+}
+
+func (d *dynamicConstant) IsPrimitive() bool {
+	return false
+}
+
+func (d *dynamicConstant) TypeName() string {
+	return "dynamic constant"
+}
+
+func (d *dynamicConstant) String() string {
+	return fmt.Sprintf("dynamic constant %s:%s", d.name, d.fieldType)
+}
+
+// Returns true if the constant is a long or double, which must be loaded
+// using ldc2_w rather than ldc or ldc_w.
+func (d *dynamicConstant) isCategory2() bool {
+	switch d.fieldType {
+	case class_file.PrimitiveFieldType('J'),
+		class_file.PrimitiveFieldType('D'):
+		return true
+	}
+	return false
+}
+
+// Returns the dynamic constant at the given index in the class' constant
+// pool, loading its bootstrap method if this is the first time it's been
+// requested.
+func (c *Class) getDynamicConstant(index uint16) (*dynamicConstant, error) {
+	c.dynamicConstantsLock.Lock()
+	defer c.dynamicConstantsLock.Unlock()
+	toReturn := c.dynamicConstants[index]
+	if toReturn != nil {
+		return toReturn, nil
+	}
+	constant, e := c.File.GetConstant(index)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't get dynamic constant: %w", e)
+	}
+	info, ok := constant.(*class_file.ConstantDynamicInfo)
+	if !ok {
+		return nil, fmt.Errorf("Didn't get a dynamic constant, instead "+
+			"got: %s", constant)
+	}
+	bootstrap, bootstrapArgs, e := loadBootstrapMethod(c,
+		info.BootstrapMethodAttributeIndex)
+	if e != nil {
+		return nil, e
+	}
+	constant, e = c.File.GetConstant(info.NameAndTypeIndex)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't get dynamic constant name and "+
+			"type: %w", e)
+	}
+	nameAndTypeInfo, ok := constant.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return nil, fmt.Errorf("Didn't get a name and type constant, "+
+			"instead got: %s", constant)
+	}
+	nameAndType, e := ResolveNameAndTypeInfoConstant(c, nameAndTypeInfo)
+	if e != nil {
+		return nil, e
+	}
+	fieldType, e := class_file.ParseFieldType(nameAndType.Type)
+	if e != nil {
+		return nil, fmt.Errorf("Failed parsing dynamic constant type: %w", e)
+	}
+	toReturn = &dynamicConstant{
+		class:         c,
+		bootstrap:     bootstrap,
+		bootstrapArgs: bootstrapArgs,
+		name:          string(nameAndType.Name),
+		fieldType:     fieldType,
+	}
+	if c.dynamicConstants == nil {
+		c.dynamicConstants = make(map[uint16]*dynamicConstant)
+	}
+	c.dynamicConstants[index] = toReturn
+	return toReturn, nil
+}
+
+// Returns the object passed to the bootstrap method as the constant's type.
+// This is the class for class types, and the class representing the primitive
+// type, e.g. long.class, for primitive types, and the array's class, e.g.
+// int[].class, for array types.
+func (d *dynamicConstant) typeArg() (Object, error) {
+	jvm := d.class.ParentJVM
+	switch v := d.fieldType.(type) {
+	case class_file.ClassInstanceType:
+		return jvm.GetOrLoadClass(string(v))
+	case class_file.PrimitiveFieldType:
+		return jvm.GetPrimitiveClass(v), nil
+	case *class_file.ArrayType:
+		return jvm.GetArrayClass(v)
+	}
+	return nil, TypeError("Invalid dynamic constant type: " +
+		d.fieldType.String())
+}
+
+// Returns an error if the value returned by the bootstrap method can't be
+// used as the constant's value. Primitive values must have the constant's
+// type, except that all int-like types can be used for ints.
+func (d *dynamicConstant) checkValue(o Object) error {
+	p, isPrimitive := d.fieldType.(class_file.PrimitiveFieldType)
+	if IsNull(o) {
+		if !isPrimitive {
+			return nil
+		}
+		return TypeError(fmt.Sprintf("Bootstrap method %s.%s returned null "+
+			"for dynamic constant %s of type %s", d.bootstrap.C.Name,
+			d.bootstrap.Method.Name, d.name, d.fieldType))
+	}
+	if !isPrimitive {
+		if d.class.ParentJVM.IsInstanceOf(o, d.fieldType) {
+			return nil
+		}
+	} else {
+		var ok bool
+		switch p {
+		case 'J':
+			_, ok = o.(Long)
+		case 'F':
+			_, ok = o.(Float)
+		case 'D':
+			_, ok = o.(Double)
+		default:
+			switch o.(type) {
+			case Int, Short, Char, Byte, Bool:
+				ok = true
+			}
+		}
+		if ok {
+			return nil
+		}
+	}
+	return TypeError(fmt.Sprintf("Bootstrap method %s.%s returned %s, "+
+		"which can't be used for dynamic constant %s of type %s",
+		d.bootstrap.C.Name, d.bootstrap.Method.Name, o.TypeName(), d.name,
+		d.fieldType))
+}
+
+// Runs the bootstrap method, returning the constant's value.
+func (d *dynamicConstant) runBootstrap(t *Thread) (Object, error) {
+	typeArg, e := d.typeArg()
+	if e != nil {
+		return nil, fmt.Errorf("Failed loading the type of dynamic constant "+
+			"%s: %w", d.name, e)
+	}
+	args, e := getBootstrapArgs(d.bootstrap, []Object{
+		&MethodHandlesLookup{C: d.class},
+		NewStringObject(d.name),
+		typeArg,
+	}, d.bootstrapArgs)
+	if e != nil {
+		return nil, e
+	}
+	if d.bootstrap.ReturnType().String() == "void" {
+		return nil, TypeError(fmt.Sprintf("Bootstrap method %s.%s doesn't "+
+			"return a value", d.bootstrap.C.Name, d.bootstrap.Method.Name))
+	}
+	result, e := d.bootstrap.Invoke(t, args)
+	if e != nil {
+		return nil, fmt.Errorf("Bootstrap method %s.%s failed: %w",
+			d.bootstrap.C.Name, d.bootstrap.Method.Name, e)
+	}
+	e = d.checkValue(result)
+	if e != nil {
+		return nil, e
+	}
+	return result, nil
+}
+
+// Returns the constant's value, running the bootstrap method to compute it if
+// this is the first time it's been loaded. Like Java, the lock isn't held
+// while the bootstrap method runs, so several threads may run it at once. The
+// first result to be stored, which may be an error, is used by all of them,
+// and failed resolutions throw the same exception every time the constant is
+// loaded.
+func (d *dynamicConstant) getValue(t *Thread) (Object, error) {
+	d.lock.Lock()
+	resolved, value, e := d.resolved, d.value, d.err
+	d.lock.Unlock()
+	if resolved {
+		return value, e
+	}
+	value, e = d.runBootstrap(t)
+	if e != nil {
+		value = nil
+		e = getBootstrapError(t, e, "Failed resolving dynamic constant "+
+			d.name)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.resolved {
+		d.value = value
+		d.err = e
+		d.resolved = true
+	}
+	return d.value, d.err
+}
+
+// Pushes the constant's value onto the thread's stack, resolving it first if
+// necessary.
+func (d *dynamicConstant) push(t *Thread) error {
+	value, e := d.getValue(t)
+	if e != nil {
+		return e
+	}
+	return t.Stack.PushUnconditional(value)
+}
@@ -0,0 +1,702 @@
+package bs_jvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Adds a MethodHandle constant to the class file, referring to the given
+// Methodref constant. Returns the index of the new constant.
+func addTestMethodHandleConstant(c *class_file.Class,
+	kind class_file.MethodHandleReferenceKind, methodIndex uint16) uint16 {
+	c.Constants = append(c.Constants, &class_file.ConstantMethodHandleInfo{
+		ReferenceKind: kind,
+		Index:         methodIndex,
+	})
+	return uint16(len(c.Constants) - 1)
+}
+
+// Adds an InvokeDynamic constant, and the name and type constants it refers
+// to, to the class file. Returns the index of the new constant.
+func addTestInvokeDynamicConstant(c *class_file.Class, bootstrapIndex uint16,
+	name, descriptor string) uint16 {
+	base := uint16(len(c.Constants))
+	c.Constants = append(c.Constants,
+		&class_file.ConstantUTF8Info{Bytes: []byte(name)},
+		&class_file.ConstantUTF8Info{Bytes: []byte(descriptor)},
+		&class_file.ConstantNameAndTypeInfo{
+			NameIndex:       base,
+			DescriptorIndex: base + 1,
+		},
+		&class_file.ConstantInvokeDynamicInfo{
+			BootstrapMethodAttributeIndex: bootstrapIndex,
+			NameAndTypeIndex:              base + 2,
+		})
+	return base + 3
+}
+
+// Returns a BootstrapMethods attribute containing the given bootstrap
+// methods.
+func getTestBootstrapMethodsAttribute(
+	methods []class_file.BootstrapMethod) *class_file.Attribute {
+	data := &bytes.Buffer{}
+	binary.Write(data, binary.BigEndian, uint16(len(methods)))
+	for _, m := range methods {
+		binary.Write(data, binary.BigEndian, m.Reference)
+		binary.Write(data, binary.BigEndian, uint16(len(m.Arguments)))
+		binary.Write(data, binary.BigEndian, m.Arguments)
+	}
+	return &class_file.Attribute{
+		Name: []byte("BootstrapMethods"),
+		Info: data.Bytes(),
+	}
+}
+
+func TestInvokeDynamic(t *testing.T) {
+	jvm := NewJVM()
+	dynamic := getStubClassFile("Dynamic", "java/lang/Object", 0x0021, nil,
+		nil, nil)
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/invoke/MethodType;I)" +
+		"Ljava/lang/invoke/CallSite;"
+	methodIndex := addTestMethodConstant(dynamic, "bootstrap",
+		bootstrapDescriptor)
+	handleIndex := addTestMethodHandleConstant(dynamic, 6, methodIndex)
+	dynamic.Constants = append(dynamic.Constants,
+		&class_file.ConstantIntegerInfo{Value: 100})
+	staticArgIndex := uint16(len(dynamic.Constants) - 1)
+	dynamic.Attributes = []*class_file.Attribute{
+		getTestBootstrapMethodsAttribute([]class_file.BootstrapMethod{
+			{
+				Reference: handleIndex,
+				Arguments: []uint16{staticArgIndex},
+			},
+		}),
+	}
+	callSiteIndex := addTestInvokeDynamicConstant(dynamic, 0, "get", "()I")
+
+	bootstrap := getStubMethod("bootstrap", 0x0109)
+	bootstrap.Descriptor, _ = class_file.ParseMethodDescriptor(
+		[]byte(bootstrapDescriptor))
+	// bipush 42, ireturn
+	answer := getTestCodeMethod("answer", []byte{0x10, 42, 0xac}, nil)
+	answer.Descriptor.ReturnType = class_file.PrimitiveFieldType('I')
+	// invokedynamic get, pop, return
+	runCode := []byte{0xba, byte(callSiteIndex >> 8), byte(callSiteIndex), 0,
+		0, 0x57, 0xb1}
+	dynamic.Methods = []*class_file.Method{
+		bootstrap,
+		answer,
+		getTestCodeMethod("run", runCode, nil),
+	}
+	loadStubClasses(t, jvm, dynamic)
+	c, e := jvm.GetClass("Dynamic")
+	if e != nil {
+		t.Logf("Failed getting Dynamic class: %s\n", e)
+		t.FailNow()
+	}
+	answerMethod := c.Methods[GetMethodKey(answer)]
+
+	// The call site's target calls answer() using Invoke, and adds the
+	// static argument passed to the bootstrap method.
+	bootstrapCount := 0
+	var results []Int
+	c.Methods[GetMethodKey(bootstrap)].Native = func(thread *Thread) error {
+		bootstrapCount++
+		staticArg, e := thread.Stack.Pop()
+		if e != nil {
+			return e
+		}
+		args, e := thread.PopValues(bootstrap.Descriptor.ArgumentTypes[:3])
+		if e != nil {
+			return e
+		}
+		lookup := args[0].(*MethodHandlesLookup)
+		name := args[1].(*StringObject).Value()
+		methodType := string(*(args[2].(*MethodType)))
+		if (lookup.C != c) || (name != "get") || (methodType != "()I") {
+			t.Logf("Got bad bootstrap method args: %s, %s, %s\n", lookup,
+				name, methodType)
+			t.Fail()
+		}
+		target := &Method{
+			ContainingClass: c,
+			Name:            "target",
+			Types: &class_file.MethodDescriptor{
+				ArgumentTypes: []class_file.FieldType{},
+				ReturnType:    class_file.PrimitiveFieldType('I'),
+			},
+			AccessFlags:  0x0009,
+			OptimizeDone: true,
+			Native: func(thread *Thread) error {
+				result, e := thread.Invoke(answerMethod)
+				if e != nil {
+					return e
+				}
+				results = append(results, result.(Int)+staticArg)
+				return thread.Stack.Push(result.(Int) + staticArg)
+			},
+		}
+		return thread.Stack.PushRef(&CallSite{Target: target})
+	}
+
+	// Run the method twice, to make sure the call site is only linked once.
+	for i := 0; i < 2; i++ {
+		_, e = jvm.StartNamedThread("Dynamic",
+			GetMethodKey(getStubMethod("run", 0)), "test")
+		if e != nil {
+			t.Logf("Failed starting thread: %s\n", e)
+			t.FailNow()
+		}
+		e = jvm.WaitForAllThreads()
+		if e != nil {
+			t.Logf("Running invokedynamic failed: %s\n", e)
+			t.FailNow()
+		}
+	}
+	if bootstrapCount != 1 {
+		t.Logf("Expected the bootstrap method to run once, but it ran %d "+
+			"times\n", bootstrapCount)
+		t.Fail()
+	}
+	if (len(results) != 2) || (results[0] != 142) || (results[1] != 142) {
+		t.Logf("Got unexpected call site results: %v\n", results)
+		t.Fail()
+	}
+}
+
+func TestDynamicConstant(t *testing.T) {
+	jvm := NewJVM()
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/Class;I)Ljava/lang/Object;"
+	b := builder.NewClassBuilder("Condy", "java/lang/Object")
+	b.AddMethod(0x0109, "bootstrap", bootstrapDescriptor)
+	b.AddMethod(0x0109, "checkLong", "(J)V")
+	b.AddMethod(0x0109, "checkSelf", "(LCondy;)V")
+	bootstrapIndex := b.AddBootstrapMethod(b.Pool.MethodHandle(6,
+		b.Pool.Method("Condy", "bootstrap", bootstrapDescriptor)),
+		b.Pool.Integer(40))
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitLdc(b.Pool.Dynamic(bootstrapIndex, "answer", "J"))
+	m.EmitInvoke(builder.Invokestatic, "Condy", "checkLong", "(J)V")
+	m.EmitLdc(b.Pool.Dynamic(bootstrapIndex, "self", "LCondy;"))
+	m.EmitInvoke(builder.Invokestatic, "Condy", "checkSelf", "(LCondy;)V")
+	m.Emit(builder.Return)
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Condy")
+	if e != nil {
+		t.Logf("Failed getting Condy class: %s\n", e)
+		t.FailNow()
+	}
+
+	// The bootstrap method returns the static argument plus 2 for the long
+	// constant, and a new instance of the class for the other one.
+	bootstrapCount := 0
+	bootstrap := getNamedTestMethod(t, c, "bootstrap")
+	bootstrap.Native = func(thread *Thread) error {
+		bootstrapCount++
+		args, e := thread.PopValues(bootstrap.Types.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		lookup := args[0].(*MethodHandlesLookup)
+		name := args[1].(*StringObject).Value()
+		if lookup.C != c {
+			t.Logf("Got bad bootstrap lookup: %s\n", lookup)
+			t.Fail()
+		}
+		if name == "answer" {
+			if args[2] != jvm.GetPrimitiveClass('J') {
+				t.Logf("Expected long.class for a long, got %s\n", args[2])
+				t.Fail()
+			}
+			return thread.Stack.PushRef(Long(args[3].(Int) + 2))
+		}
+		if args[2] != c {
+			t.Logf("Got bad type for %s: %s\n", name, args[2])
+			t.Fail()
+		}
+		instance, e := c.CreateInstance()
+		if e != nil {
+			return e
+		}
+		return thread.Stack.PushRef(instance)
+	}
+	var longs []Long
+	getNamedTestMethod(t, c, "checkLong").Native = func(
+		thread *Thread) error {
+		v, e := thread.Stack.PopLong()
+		longs = append(longs, v)
+		return e
+	}
+	var instances []Object
+	getNamedTestMethod(t, c, "checkSelf").Native = func(
+		thread *Thread) error {
+		v, e := thread.Stack.PopRef()
+		instances = append(instances, v)
+		return e
+	}
+
+	// Each constant must only be resolved once, no matter how many times
+	// it's loaded.
+	for i := 0; i < 2; i++ {
+		_, e = jvm.StartNamedThread("Condy", "void run()", "test")
+		if e != nil {
+			t.Logf("Failed starting thread: %s\n", e)
+			t.FailNow()
+		}
+		e = jvm.WaitForAllThreads()
+		if e != nil {
+			t.Logf("Loading dynamic constants failed: %s\n", e)
+			t.FailNow()
+		}
+	}
+	if bootstrapCount != 2 {
+		t.Logf("Expected the bootstrap method to run twice, but it ran %d "+
+			"times\n", bootstrapCount)
+		t.Fail()
+	}
+	if (len(longs) != 2) || (longs[0] != 42) || (longs[1] != 42) {
+		t.Logf("Got unexpected long constants: %v\n", longs)
+		t.Fail()
+	}
+	if (len(instances) != 2) || (instances[0] != instances[1]) ||
+		(c.ParentJVM.getRuntimeClass(instances[0]) != c) {
+		t.Logf("Got unexpected instance constants: %v\n", instances)
+		t.Fail()
+	}
+}
+
+// Returns a static native method with no args, returning the given int.
+func getTestIntTarget(c *Class, v Int) *Method {
+	return &Method{
+		ContainingClass: c,
+		Name:            "target",
+		Types: &class_file.MethodDescriptor{
+			ArgumentTypes: []class_file.FieldType{},
+			ReturnType:    class_file.PrimitiveFieldType('I'),
+		},
+		AccessFlags:  0x0009,
+		OptimizeDone: true,
+		Native: func(thread *Thread) error {
+			return thread.Stack.Push(v)
+		},
+	}
+}
+
+// Returns the class' method with the given name, which must be unique.
+func getNamedTestMethod(t *testing.T, c *Class, name string) *Method {
+	for _, m := range c.Methods {
+		if m.Name == name {
+			return m
+		}
+	}
+	t.Logf("Couldn't find method %s in %s\n", name, c.Name)
+	t.FailNow()
+	return nil
+}
+
+func TestConcurrentInvokeDynamic(t *testing.T) {
+	jvm := NewJVM()
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/invoke/MethodType;)" +
+		"Ljava/lang/invoke/CallSite;"
+	b := builder.NewClassBuilder("Dynamic", "java/lang/Object")
+	b.AddMethod(0x0109, "bootstrap", bootstrapDescriptor)
+	b.AddMethod(0x0109, "report", "(I)V")
+	bootstrapIndex := b.AddBootstrapMethod(b.Pool.MethodHandle(6,
+		b.Pool.Method("Dynamic", "bootstrap", bootstrapDescriptor)))
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitInvokeDynamic(bootstrapIndex, "get", "()I")
+	m.EmitInvoke(builder.Invokestatic, "Dynamic", "report", "(I)V")
+	m.Emit(builder.Return)
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Dynamic")
+	if e != nil {
+		t.Logf("Failed getting Dynamic class: %s\n", e)
+		t.FailNow()
+	}
+
+	// The first thread to run the bootstrap method waits for the second one
+	// to link the call site, so the first thread's call site must be
+	// discarded.
+	var lock sync.Mutex
+	bootstrapCount := 0
+	secondStarted := make(chan bool)
+	bootstrap := getNamedTestMethod(t, c, "bootstrap")
+	bootstrap.Native = func(thread *Thread) error {
+		_, e := thread.PopValues(bootstrap.Types.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		lock.Lock()
+		bootstrapCount++
+		count := bootstrapCount
+		lock.Unlock()
+		if count == 1 {
+			select {
+			case <-secondStarted:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("The bootstrap method didn't run " +
+					"concurrently")
+			}
+		} else {
+			close(secondStarted)
+		}
+		return thread.Stack.PushRef(&CallSite{
+			Target: getTestIntTarget(c, Int(count)),
+		})
+	}
+	var results []Object
+	getNamedTestMethod(t, c, "report").Native = func(thread *Thread) error {
+		v, e := thread.Stack.Pop()
+		lock.Lock()
+		results = append(results, v)
+		lock.Unlock()
+		return e
+	}
+
+	for i := 0; i < 2; i++ {
+		_, e = jvm.StartNamedThread("Dynamic", "void run()", "test")
+		if e != nil {
+			t.Logf("Failed starting thread: %s\n", e)
+			t.FailNow()
+		}
+	}
+	e = jvm.WaitForAllThreads()
+	if e != nil {
+		t.Logf("Running invokedynamic failed: %s\n", e)
+		t.FailNow()
+	}
+	if (len(results) != 2) || (results[0] != Int(2)) ||
+		(results[1] != Int(2)) {
+		t.Logf("Expected both threads to use the second call site, got %v\n",
+			results)
+		t.Fail()
+	}
+}
+
+func TestConcurrentDynamicConstant(t *testing.T) {
+	jvm := NewJVM()
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/Class;)I"
+	b := builder.NewClassBuilder("Condy", "java/lang/Object")
+	b.AddMethod(0x0109, "bootstrap", bootstrapDescriptor)
+	b.AddMethod(0x0109, "report", "(I)V")
+	bootstrapIndex := b.AddBootstrapMethod(b.Pool.MethodHandle(6,
+		b.Pool.Method("Condy", "bootstrap", bootstrapDescriptor)))
+	m := b.AddMethod(0x0009, "run", "()V")
+	m.EmitLdc(b.Pool.Dynamic(bootstrapIndex, "value", "I"))
+	m.EmitInvoke(builder.Invokestatic, "Condy", "report", "(I)V")
+	m.Emit(builder.Return)
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Condy")
+	if e != nil {
+		t.Logf("Failed getting Condy class: %s\n", e)
+		t.FailNow()
+	}
+
+	// As with invokedynamic, the first thread to run the bootstrap method
+	// waits for the second one to store its value, so the first thread's
+	// value must be discarded.
+	var lock sync.Mutex
+	bootstrapCount := 0
+	secondStarted := make(chan bool)
+	bootstrap := getNamedTestMethod(t, c, "bootstrap")
+	bootstrap.Native = func(thread *Thread) error {
+		args, e := thread.PopValues(bootstrap.Types.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		if args[2] != jvm.GetPrimitiveClass('I') {
+			return fmt.Errorf("Expected int.class, got %s", args[2])
+		}
+		lock.Lock()
+		bootstrapCount++
+		count := bootstrapCount
+		lock.Unlock()
+		if count == 1 {
+			select {
+			case <-secondStarted:
+			case <-time.After(5 * time.Second):
+				return fmt.Errorf("The bootstrap method didn't run " +
+					"concurrently")
+			}
+		} else {
+			close(secondStarted)
+		}
+		return thread.Stack.Push(Int(count))
+	}
+	var results []Object
+	getNamedTestMethod(t, c, "report").Native = func(thread *Thread) error {
+		v, e := thread.Stack.Pop()
+		lock.Lock()
+		results = append(results, v)
+		lock.Unlock()
+		return e
+	}
+
+	for i := 0; i < 2; i++ {
+		_, e = jvm.StartNamedThread("Condy", "void run()", "test")
+		if e != nil {
+			t.Logf("Failed starting thread: %s\n", e)
+			t.FailNow()
+		}
+	}
+	e = jvm.WaitForAllThreads()
+	if e != nil {
+		t.Logf("Loading the dynamic constant failed: %s\n", e)
+		t.FailNow()
+	}
+	if (len(results) != 2) || (results[0] != Int(2)) ||
+		(results[1] != Int(2)) {
+		t.Logf("Expected both threads to use the second value, got %v\n",
+			results)
+		t.Fail()
+	}
+}
+
+func TestDynamicConstantErrors(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Error",
+		"java/lang/Throwable"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder(
+		"java/lang/BootstrapMethodError", "java/lang/Error"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("Failure",
+		"java/lang/Throwable"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("FatalFailure",
+		"java/lang/Error"))
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/Class;)Ljava/lang/Object;"
+	b := builder.NewClassBuilder("Condy", "java/lang/Object")
+	b.AddMethod(0x0109, "bootstrap", bootstrapDescriptor)
+	bootstrapIndex := b.AddBootstrapMethod(b.Pool.MethodHandle(6,
+		b.Pool.Method("Condy", "bootstrap", bootstrapDescriptor)))
+	for _, name := range []string{"exception", "error", "wrongType"} {
+		m := b.AddMethod(0x0009, name, "()V")
+		m.EmitLdc(b.Pool.Dynamic(bootstrapIndex, name, "LFailure;"))
+		m.Emit(builder.Pop)
+		m.Emit(builder.Return)
+	}
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Condy")
+	if e != nil {
+		t.Logf("Failed getting Condy class: %s\n", e)
+		t.FailNow()
+	}
+
+	// The bootstrap method only fails the first time it's called for each
+	// constant. Later loads must throw the same exception without running it
+	// again.
+	bootstrapCounts := make(map[string]int)
+	bootstrap := getNamedTestMethod(t, c, "bootstrap")
+	bootstrap.Native = func(thread *Thread) error {
+		args, e := thread.PopValues(bootstrap.Types.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		name := args[1].(*StringObject).Value()
+		bootstrapCounts[name]++
+		if bootstrapCounts[name] > 1 {
+			return thread.Stack.PushRef(nil)
+		}
+		switch name {
+		case "exception":
+			return thread.Throw("Failure", "Bootstrap failed")
+		case "error":
+			return thread.Throw("FatalFailure", "Bootstrap failed badly")
+		}
+		instance, e := c.CreateInstance()
+		if e != nil {
+			return e
+		}
+		return thread.Stack.PushRef(instance)
+	}
+
+	tests := []struct {
+		method    string
+		exception string
+		cause     string
+	}{
+		{"exception", "java/lang/BootstrapMethodError", "Failure"},
+		{"error", "FatalFailure", ""},
+		{"wrongType", "java/lang/BootstrapMethodError", ""},
+	}
+	for _, test := range tests {
+		var first *ClassInstance
+		for i := 0; i < 2; i++ {
+			_, e = jvm.StartNamedThread("Condy", "void "+test.method+"()",
+				"test")
+			if e != nil {
+				t.Logf("Failed starting thread: %s\n", e)
+				t.FailNow()
+			}
+			e = jvm.WaitForAllThreads()
+			var thrown *ThrownException
+			if !errors.As(e, &thrown) {
+				t.Logf("Expected %s to throw an exception, got %v\n",
+					test.method, e)
+				t.FailNow()
+			}
+			if first == nil {
+				first = thrown.Exception
+			} else if thrown.Exception != first {
+				t.Logf("%s threw a different exception the second time: "+
+					"%s\n", test.method, ThrowableToString(thrown.Exception))
+				t.Fail()
+			}
+		}
+		if string(first.C.Name) != test.exception {
+			t.Logf("Expected %s to throw %s, got %s\n", test.method,
+				test.exception, ThrowableToString(first))
+			t.Fail()
+		}
+		cause, _ := GetThrowableInfo(first).Cause.(*ClassInstance)
+		causeName := ""
+		if cause != nil {
+			causeName = string(cause.C.Name)
+		}
+		if causeName != test.cause {
+			t.Logf("Expected the cause of %s to be %q, got %q\n",
+				ThrowableToString(first), test.cause, causeName)
+			t.Fail()
+		}
+		if bootstrapCounts[test.method] != 1 {
+			t.Logf("Expected the bootstrap method for %s to run once, but "+
+				"it ran %d times\n", test.method,
+				bootstrapCounts[test.method])
+			t.Fail()
+		}
+	}
+}
+
+func TestInvokeDynamicErrors(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ErrorSink = &bytes.Buffer{}
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Error",
+		"java/lang/Throwable"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder(
+		"java/lang/BootstrapMethodError", "java/lang/Error"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("Failure",
+		"java/lang/Throwable"))
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("FatalFailure",
+		"java/lang/Error"))
+	bootstrapDescriptor := "(Ljava/lang/invoke/MethodHandles$Lookup;" +
+		"Ljava/lang/String;Ljava/lang/invoke/MethodType;)" +
+		"Ljava/lang/invoke/CallSite;"
+	b := builder.NewClassBuilder("Indy", "java/lang/Object")
+	b.AddMethod(0x0109, "bootstrap", bootstrapDescriptor)
+	bootstrapIndex := b.AddBootstrapMethod(b.Pool.MethodHandle(6,
+		b.Pool.Method("Indy", "bootstrap", bootstrapDescriptor)))
+	names := []string{"exception", "error", "notCallSite", "wrongTarget"}
+	for _, name := range names {
+		m := b.AddMethod(0x0009, name, "()V")
+		m.EmitInvokeDynamic(bootstrapIndex, name, "()J")
+		m.Emit(builder.Pop2)
+		m.Emit(builder.Return)
+	}
+	loadBuiltTestClass(t, jvm, b)
+	c, e := jvm.GetClass("Indy")
+	if e != nil {
+		t.Logf("Failed getting Indy class: %s\n", e)
+		t.FailNow()
+	}
+
+	// Like with dynamic constants, the bootstrap method only fails the first
+	// time it's called for each instruction, and later executions must throw
+	// the same exception without running it again.
+	bootstrapCounts := make(map[string]int)
+	bootstrap := getNamedTestMethod(t, c, "bootstrap")
+	bootstrap.Native = func(thread *Thread) error {
+		args, e := thread.PopValues(bootstrap.Types.ArgumentTypes)
+		if e != nil {
+			return e
+		}
+		name := args[1].(*StringObject).Value()
+		bootstrapCounts[name]++
+		if bootstrapCounts[name] > 1 {
+			return thread.Stack.PushRef(nil)
+		}
+		switch name {
+		case "exception":
+			return thread.Throw("Failure", "Bootstrap failed")
+		case "error":
+			return thread.Throw("FatalFailure", "Bootstrap failed badly")
+		case "notCallSite":
+			return thread.Stack.PushRef(NewStringObject("not a call site"))
+		}
+		// The target returns an int rather than a long.
+		return thread.Stack.PushRef(&CallSite{
+			Target: getTestIntTarget(c, 1),
+		})
+	}
+
+	tests := []struct {
+		method    string
+		exception string
+		cause     string
+	}{
+		{"exception", "java/lang/BootstrapMethodError", "Failure"},
+		{"error", "FatalFailure", ""},
+		{"notCallSite", "java/lang/BootstrapMethodError", ""},
+		{"wrongTarget", "java/lang/BootstrapMethodError", ""},
+	}
+	for _, test := range tests {
+		var first *ClassInstance
+		for i := 0; i < 2; i++ {
+			_, e = jvm.StartNamedThread("Indy", "void "+test.method+"()",
+				"test")
+			if e != nil {
+				t.Logf("Failed starting thread: %s\n", e)
+				t.FailNow()
+			}
+			e = jvm.WaitForAllThreads()
+			var thrown *ThrownException
+			if !errors.As(e, &thrown) {
+				t.Logf("Expected %s to throw an exception, got %v\n",
+					test.method, e)
+				t.FailNow()
+			}
+			if first == nil {
+				first = thrown.Exception
+			} else if thrown.Exception != first {
+				t.Logf("%s threw a different exception the second time: "+
+					"%s\n", test.method, ThrowableToString(thrown.Exception))
+				t.Fail()
+			}
+		}
+		if string(first.C.Name) != test.exception {
+			t.Logf("Expected %s to throw %s, got %s\n", test.method,
+				test.exception, ThrowableToString(first))
+			t.Fail()
+		}
+		cause, _ := GetThrowableInfo(first).Cause.(*ClassInstance)
+		causeName := ""
+		if cause != nil {
+			causeName = string(cause.C.Name)
+		}
+		if causeName != test.cause {
+			t.Logf("Expected the cause of %s to be %q, got %q\n",
+				ThrowableToString(first), test.cause, causeName)
+			t.Fail()
+		}
+		if bootstrapCounts[test.method] != 1 {
+			t.Logf("Expected the bootstrap method for %s to run once, but "+
+				"it ran %d times\n", test.method,
+				bootstrapCounts[test.method])
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package bs_jvm
+
+// This file contains code for loading classes from JAR (zip) files, and for
+// reading JAR manifests.
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// A ClassSource that reads class files from a JAR or zip archive.
+type ZipClassSource struct {
+	// The path to the archive, used when printing information.
+	path   string
+	reader *zip.ReadCloser
+	// Maps file names in the archive to the zip entries.
+	files map[string]*zip.File
+}
+
+// Opens the zip or JAR file at the given path for use as a ClassSource. The
+// file remains open until Close() is called.
+func OpenZipClassSource(path string) (*ZipClassSource, error) {
+	reader, e := zip.OpenReader(path)
+	if e != nil {
+		return nil, fmt.Errorf("Failed opening %s: %w", path, e)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+	return &ZipClassSource{
+		path:   path,
+		reader: reader,
+		files:  files,
+	}, nil
+}
+
+// Returns the full content of the named file in the archive. Returns a
+// ClassNotFoundError containing the file name if it doesn't exist.
+func (z *ZipClassSource) readFile(name string) ([]byte, error) {
+	f := z.files[name]
+	if f == nil {
+		return nil, ClassNotFoundError(name)
+	}
+	r, e := f.Open()
+	if e != nil {
+		return nil, fmt.Errorf("Failed opening %s in %s: %w", name, z.path, e)
+	}
+	defer r.Close()
+	content, e := ioutil.ReadAll(r)
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading %s in %s: %w", name, z.path, e)
+	}
+	return content, nil
+}
+
+func (z *ZipClassSource) ReadClassFile(className string) ([]byte, error) {
+	content, e := z.readFile(className + ".class")
+	if e != nil {
+		_, notFound := e.(ClassNotFoundError)
+		if notFound {
+			return nil, ClassNotFoundError(className)
+		}
+		return nil, e
+	}
+	return content, nil
+}
+
+func (z *ZipClassSource) String() string {
+	return "archive " + z.path
+}
+
+// Closes the underlying archive. The source can't be used after this.
+func (z *ZipClassSource) Close() error {
+	return z.reader.Close()
+}
+
+// Holds the main attributes from a JAR's META-INF/MANIFEST.MF file.
+type JARManifest struct {
+	// Maps attribute names to values, for all attributes in the manifest's
+	// main section.
+	Attributes map[string]string
+	// The name of the class containing the main method, using slashes rather
+	// than dots as separators, e.g. "com/example/Main". Empty if the manifest
+	// didn't specify a Main-Class.
+	MainClass string
+	// The space-separated URLs listed in the Class-Path attribute. These are
+	// relative to the directory containing the JAR.
+	ClassPath []string
+}
+
+// Parses the main section of a JAR manifest. Lines starting with a single
+// space are continuations of the previous line.
+func ParseJARManifest(data io.Reader) (*JARManifest, error) {
+	toReturn := &JARManifest{
+		Attributes: make(map[string]string),
+	}
+	lines := make([]string, 0, 16)
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// The main section ends at the first blank line.
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") {
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("Manifest starts with a continuation " +
+					"line")
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	e := scanner.Err()
+	if e != nil {
+		return nil, fmt.Errorf("Failed reading manifest: %w", e)
+	}
+	for _, line := range lines {
+		colon := strings.Index(line, ": ")
+		if colon <= 0 {
+			return nil, fmt.Errorf("Invalid manifest line: %q", line)
+		}
+		toReturn.Attributes[line[:colon]] = line[colon+2:]
+	}
+	mainClass := toReturn.Attributes["Main-Class"]
+	toReturn.MainClass = strings.ReplaceAll(strings.TrimSpace(mainClass), ".",
+		"/")
+	toReturn.ClassPath = strings.Fields(toReturn.Attributes["Class-Path"])
+	return toReturn, nil
+}
+
+// Reads and parses the archive's META-INF/MANIFEST.MF file.
+func (z *ZipClassSource) ReadManifest() (*JARManifest, error) {
+	content, e := z.readFile("META-INF/MANIFEST.MF")
+	if e != nil {
+		_, notFound := e.(ClassNotFoundError)
+		if notFound {
+			return nil, fmt.Errorf("%s doesn't contain a manifest", z.path)
+		}
+		return nil, e
+	}
+	return ParseJARManifest(strings.NewReader(string(content)))
+}
+
+// Adds the JAR at the given path to the JVM's class path, along with any
+// entries in its manifest's Class-Path. Returns the JAR's manifest, so that
+// the Main-Class can be found. Class-Path entries that don't exist are
+// skipped, as in other JVMs.
+func (j *JVM) AddJAR(path string) (*JARManifest, error) {
+	jar, e := OpenZipClassSource(path)
+	if e != nil {
+		return nil, e
+	}
+	manifest, e := jar.ReadManifest()
+	if e != nil {
+		jar.Close()
+		return nil, e
+	}
+	j.ClassPath = append(j.ClassPath, jar)
+	baseDir := filepath.Dir(path)
+	for _, entry := range manifest.ClassPath {
+		source, e := NewClassSource(filepath.Join(baseDir,
+			filepath.FromSlash(entry)))
+		if e != nil {
+			continue
+		}
+		j.ClassPath = append(j.ClassPath, source)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,130 @@
+package bs_jvm
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes a JAR containing RandomDotsSimple.class and the given manifest to a
+// temporary directory, returning the JAR's path.
+func writeTestJAR(t *testing.T, manifest string) string {
+	classData, e := ioutil.ReadFile("class_file/test_data/" +
+		"RandomDotsSimple.class")
+	if e != nil {
+		t.Logf("Failed reading test class file: %s\n", e)
+		t.FailNow()
+	}
+	path := filepath.Join(t.TempDir(), "test.jar")
+	f, e := os.Create(path)
+	if e != nil {
+		t.Logf("Failed creating test JAR: %s\n", e)
+		t.FailNow()
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	files := map[string][]byte{
+		"META-INF/MANIFEST.MF":   []byte(manifest),
+		"RandomDotsSimple.class": classData,
+	}
+	for name, content := range files {
+		fileWriter, e := w.Create(name)
+		if e != nil {
+			t.Logf("Failed creating %s in test JAR: %s\n", name, e)
+			t.FailNow()
+		}
+		_, e = fileWriter.Write(content)
+		if e != nil {
+			t.Logf("Failed writing %s in test JAR: %s\n", name, e)
+			t.FailNow()
+		}
+	}
+	e = w.Close()
+	if e != nil {
+		t.Logf("Failed finishing test JAR: %s\n", e)
+		t.FailNow()
+	}
+	return path
+}
+
+func TestParseJARManifest(t *testing.T) {
+	manifest := "Manifest-Version: 1.0\r\n" +
+		"Main-Class: com.example.Ma\r\n" +
+		" in\r\n" +
+		"Class-Path: lib/a.jar  lib/b.jar\r\n" +
+		"\r\n" +
+		"Name: other/Section.class\r\n" +
+		"Main-Class: Wrong\r\n"
+	m, e := ParseJARManifest(strings.NewReader(manifest))
+	if e != nil {
+		t.Logf("Failed parsing manifest: %s\n", e)
+		t.FailNow()
+	}
+	if m.MainClass != "com/example/Main" {
+		t.Logf("Got incorrect main class: %s\n", m.MainClass)
+		t.Fail()
+	}
+	if (len(m.ClassPath) != 2) || (m.ClassPath[1] != "lib/b.jar") {
+		t.Logf("Got incorrect class path: %v\n", m.ClassPath)
+		t.Fail()
+	}
+	if m.Attributes["Manifest-Version"] != "1.0" {
+		t.Logf("Got incorrect manifest version: %s\n",
+			m.Attributes["Manifest-Version"])
+		t.Fail()
+	}
+	_, e = ParseJARManifest(strings.NewReader("Not a manifest\n"))
+	if e == nil {
+		t.Logf("Didn't get an error for an invalid manifest.\n")
+		t.Fail()
+	}
+}
+
+func TestLoadClassFromJAR(t *testing.T) {
+	path := writeTestJAR(t, "Manifest-Version: 1.0\n"+
+		"Main-Class: RandomDotsSimple\n"+
+		"Class-Path: does_not_exist.jar\n")
+	jvm := NewJVM()
+	manifest, e := jvm.AddJAR(path)
+	if e != nil {
+		t.Logf("Failed adding JAR: %s\n", e)
+		t.FailNow()
+	}
+	if manifest.MainClass != "RandomDotsSimple" {
+		t.Logf("Got incorrect main class: %s\n", manifest.MainClass)
+		t.Fail()
+	}
+	if len(jvm.ClassPath) != 1 {
+		t.Logf("Expected only the JAR on the class path, got %d entries\n",
+			len(jvm.ClassPath))
+		t.Fail()
+	}
+	class, e := jvm.GetOrLoadClass(manifest.MainClass)
+	if e != nil {
+		t.Logf("Failed loading class from JAR: %s\n", e)
+		t.FailNow()
+	}
+	if string(class.Name) != "RandomDotsSimple" {
+		t.Logf("Loaded the wrong class: %s\n", class.Name)
+		t.Fail()
+	}
+	_, e = jvm.GetOrLoadClass("NotARealClass")
+	_, ok := e.(ClassNotFoundError)
+	if !ok {
+		t.Logf("Expected a ClassNotFoundError, got %v\n", e)
+		t.Fail()
+	}
+	source, e := NewClassSource(path)
+	if e != nil {
+		t.Logf("Failed opening JAR as a class path entry: %s\n", e)
+		t.FailNow()
+	}
+	_, ok = source.(*ZipClassSource)
+	if !ok {
+		t.Logf("Expected a JAR to be opened as a zip class source.\n")
+		t.Fail()
+	}
+}
@@ -3,12 +3,15 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/yalue/bs_jvm"
 	"github.com/yalue/bs_jvm/builtin_classes"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func NewJVMWithBuiltins() (*bs_jvm.JVM, error) {
@@ -23,40 +26,108 @@ func NewJVMWithBuiltins() (*bs_jvm.JVM, error) {
 	return j, nil
 }
 
+// Configures the JVM's class path and returns the name of the main class,
+// based on the command-line arguments. Also returns the arguments that should
+// be passed to the main class.
+func setupClassPath(j *bs_jvm.JVM, jarPath, classPath string) (string,
+	[]string, error) {
+	if jarPath != "" {
+		// As in java, -cp is ignored when running a JAR.
+		manifest, e := j.AddJAR(jarPath)
+		if e != nil {
+			return "", nil, e
+		}
+		if manifest.MainClass == "" {
+			return "", nil, fmt.Errorf("%s doesn't specify a Main-Class",
+				jarPath)
+		}
+		return manifest.MainClass, flag.Args(), nil
+	}
+	if len(flag.Args()) < 1 {
+		return "", nil, fmt.Errorf("Expected a class to run")
+	}
+	if classPath != "" {
+		sources, e := bs_jvm.ParseClassPath(classPath)
+		if e != nil {
+			return "", nil, e
+		}
+		j.ClassPath = append(j.ClassPath, sources...)
+	}
+	target := flag.Arg(0)
+	args := flag.Args()[1:]
+	if strings.HasSuffix(target, ".class") {
+		// Like java's default class path, look for other classes alongside
+		// the main class file.
+		if classPath == "" {
+			j.ClassPath = append(j.ClassPath,
+				bs_jvm.DirectoryClassSource(filepath.Dir(target)))
+		}
+		className, e := j.LoadClassFromFile(target)
+		if e != nil {
+			return "", nil, e
+		}
+		return className, args, nil
+	}
+	if classPath == "" {
+		j.ClassPath = append(j.ClassPath, bs_jvm.DirectoryClassSource("."))
+	}
+	return strings.ReplaceAll(target, ".", "/"), args, nil
+}
+
 func run() int {
 	showTrace := false
+	jarPath := ""
+	classPath := ""
 	flag.CommandLine.SetOutput(os.Stdout)
 	flag.Usage = func() {
 		fmt.Printf("Usage of %s:\n", os.Args[0])
-		fmt.Printf("   %s [OPTIONS] <file to run>\n", os.Args[0])
+		fmt.Printf("   %s [OPTIONS] <class file or class name> [args...]\n",
+			os.Args[0])
+		fmt.Printf("   %s [OPTIONS] -jar <JAR file> [args...]\n", os.Args[0])
 		fmt.Printf("[OPTIONS] are one or more of:\n")
 		flag.PrintDefaults()
 	}
 	flag.BoolVar(&showTrace, "show_trace", false, "If true, prints a trace "+
 		"of all executed instructions to stdout.")
+	flag.StringVar(&jarPath, "jar", "", "The path to a JAR file to run. The "+
+		"main class is taken from the JAR's manifest.")
+	flag.StringVar(&classPath, "cp", "", "A list of directories and JAR "+
+		"files to search for classes, separated by \""+
+		string(os.PathListSeparator)+"\". Defaults to the current "+
+		"directory, or the directory containing the class file to run.")
 	flag.Parse()
-	if len(flag.Args()) != 1 {
-		log.Printf("Usage: ./jvm [OPTIONS] <file to run>\n")
+	if (jarPath == "") && (len(flag.Args()) < 1) {
+		log.Printf("Usage: ./jvm [OPTIONS] <class file or class name> " +
+			"[args...]\n")
 		log.Printf("Run with \"--help\" for more information.\n")
 		return 1
 	}
-	filename := flag.Arg(0)
 	j, e := NewJVMWithBuiltins()
 	if e != nil {
-		log.Printf("Failed initializing JVM: %s\n")
+		log.Printf("Failed initializing JVM: %s\n", e)
 		return 1
 	}
 	if showTrace {
 		j.TraceSink = os.Stdout
 	}
+	mainClass, args, e := setupClassPath(j, jarPath, classPath)
+	if e != nil {
+		log.Printf("Error loading main class: %s\n", e)
+		return 1
+	}
 
 	// Now actually run the loaded class.
-	e = j.StartMainClass(filename)
+	e = j.StartMainClassByName(mainClass, args)
 	if e != nil {
 		log.Printf("Error running main class: %s\n", e)
 		return 1
 	}
 	e = j.WaitForAllThreads()
+	var uncaught *bs_jvm.ThrownException
+	if errors.As(e, &uncaught) {
+		// The stack trace was already printed when the thread exited.
+		return 1
+	}
 	if e != nil {
 		log.Printf("JVM exited with an error: %s\n", e)
 		return 1
@@ -1,6 +1,7 @@
 package bs_jvm
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -22,3 +23,85 @@ func TestLoadClass(t *testing.T) {
 		}
 	}
 }
+
+func TestGetOrLoadClass(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ClassPath = append(jvm.ClassPath,
+		DirectoryClassSource("class_file/test_data"))
+	_, e := jvm.GetClass("RandomDotsSimple")
+	if e == nil {
+		t.Logf("Got a class before it was loaded.\n")
+		t.FailNow()
+	}
+	class, e := jvm.GetOrLoadClass("RandomDotsSimple")
+	if e != nil {
+		t.Logf("Failed loading class from the class path: %s\n", e)
+		t.FailNow()
+	}
+	if string(class.Name) != "RandomDotsSimple" {
+		t.Logf("Loaded the wrong class: %s\n", class.Name)
+		t.FailNow()
+	}
+	tmp, e := jvm.GetOrLoadClass("RandomDotsSimple")
+	if e != nil {
+		t.Logf("Failed getting already-loaded class: %s\n", e)
+		t.FailNow()
+	}
+	if tmp != class {
+		t.Logf("Loaded the same class twice.\n")
+		t.Fail()
+	}
+	_, e = jvm.GetOrLoadClass("NotARealClass")
+	if e == nil {
+		t.Logf("Didn't get an error loading a nonexistent class.\n")
+		t.FailNow()
+	}
+	_, ok := e.(ClassNotFoundError)
+	if !ok {
+		t.Logf("Expected a ClassNotFoundError, got %s\n", e)
+		t.Fail()
+	}
+}
+
+func TestConcurrentGetOrLoadClass(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ClassPath = append(jvm.ClassPath,
+		DirectoryClassSource("class_file/test_data"))
+	var wg sync.WaitGroup
+	classes := make([]*Class, 8)
+	errs := make([]error, len(classes))
+	for i := range classes {
+		wg.Add(1)
+		go func(i int) {
+			classes[i], errs[i] = jvm.GetOrLoadClass("RandomDotsSimple")
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	loaded, e := jvm.GetClass("RandomDotsSimple")
+	if e != nil {
+		t.Logf("The class wasn't in the JVM after loading it: %s\n", e)
+		t.FailNow()
+	}
+	for i := range classes {
+		if errs[i] != nil {
+			t.Logf("Failed loading class in goroutine %d: %s\n", i, errs[i])
+			t.Fail()
+			continue
+		}
+		if classes[i] != loaded {
+			t.Logf("Goroutine %d got a different copy of the class\n", i)
+			t.Fail()
+		}
+	}
+	// Explicitly loading the class again must keep the existing copy.
+	e = jvm.LoadClass(loaded.File)
+	if e != nil {
+		t.Logf("Failed reloading the class: %s\n", e)
+		t.FailNow()
+	}
+	if jvm.Classes["RandomDotsSimple"] != loaded {
+		t.Logf("Loading the class again replaced the existing copy\n")
+		t.Fail()
+	}
+}
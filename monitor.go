@@ -0,0 +1,208 @@
+package bs_jvm
+
+// This file contains the implementation of Java monitors, which are used by
+// synchronized methods and blocks, as well as by Object.wait() and notify().
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A reentrant Java monitor. Every object that can be synchronized on contains
+// one. The zero value is an unowned monitor that's ready to use.
+type Monitor struct {
+	// Protects all of the monitor's other fields.
+	lock sync.Mutex
+	// Signaled when the monitor's owner releases it. Created the first time
+	// a thread needs to wait for the monitor.
+	released *sync.Cond
+	// The thread currently holding the monitor, or nil if it isn't held.
+	owner *Thread
+	// The number of times the owner has entered the monitor without exiting
+	// it.
+	count int
+	// Threads blocked in Wait() are notified by closing their channel in this
+	// list. Channels are removed from the list when they're closed.
+	waiters []chan bool
+}
+
+// Blocks until the monitor is unowned, then acquires it for t. Must be called
+// while holding m.lock.
+func (m *Monitor) acquire(t *Thread, count int) {
+	for (m.owner != nil) && (m.owner != t) {
+		if m.released == nil {
+			m.released = sync.NewCond(&m.lock)
+		}
+		m.released.Wait()
+	}
+	m.owner = t
+	m.count += count
+}
+
+// Returns an IllegalMonitorStateError if t doesn't hold the monitor. Must be
+// called while holding m.lock.
+func (m *Monitor) checkOwner(t *Thread, action string) error {
+	if m.owner != t {
+		return IllegalMonitorStateError(fmt.Sprintf("Thread %s called %s "+
+			"without holding the monitor", t.GetName(), action))
+	}
+	return nil
+}
+
+// Makes the monitor available to other threads. Must be called while holding
+// m.lock.
+func (m *Monitor) release() {
+	m.owner = nil
+	m.count = 0
+	if m.released != nil {
+		m.released.Signal()
+	}
+}
+
+// Enters the monitor on behalf of the given thread, blocking until no other
+// thread holds it. A thread may enter the same monitor multiple times, but
+// must exit it the same number of times before other threads can enter it.
+func (m *Monitor) Enter(t *Thread) {
+	m.lock.Lock()
+	m.acquire(t, 1)
+	m.lock.Unlock()
+}
+
+// Exits the monitor, which must be held by the given thread. Returns an
+// IllegalMonitorStateError if the thread doesn't hold the monitor.
+func (m *Monitor) Exit(t *Thread) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e := m.checkOwner(t, "monitorexit")
+	if e != nil {
+		return e
+	}
+	m.count--
+	if m.count == 0 {
+		m.release()
+	}
+	return nil
+}
+
+// Returns true if the given thread holds the monitor.
+func (m *Monitor) IsOwnedBy(t *Thread) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.owner == t
+}
+
+// Implements Object.wait(). Releases the monitor, which must be held by the
+// given thread, and waits until another thread calls Notify or NotifyAll, the
+// timeout expires, or the thread is interrupted. A timeout of 0 waits
+// indefinitely. Re-acquires the monitor before returning, as many times as it
+// had been entered. Returns an InterruptedError if the thread was interrupted
+// before being notified. If the thread was both notified and interrupted,
+// this returns nil, and the thread remains interrupted.
+func (m *Monitor) Wait(t *Thread, timeout time.Duration) error {
+	m.lock.Lock()
+	e := m.checkOwner(t, "wait()")
+	if e != nil {
+		m.lock.Unlock()
+		return e
+	}
+	count := m.count
+	notified := make(chan bool)
+	m.waiters = append(m.waiters, notified)
+	m.release()
+	m.lock.Unlock()
+
+	// Even if this returns an error, we still need to re-acquire the monitor
+	// before returning.
+	waitError := t.BlockOn(notified, timeout, "wait")
+
+	m.lock.Lock()
+	// Notify closes the channel while holding m.lock, so this reliably tells
+	// us whether we were notified, even if BlockOn returned for some other
+	// reason.
+	wasNotified := false
+	select {
+	case <-notified:
+		wasNotified = true
+	default:
+	}
+	if !wasNotified {
+		// Stop waiting for a notification if we timed out or were
+		// interrupted.
+		for i, c := range m.waiters {
+			if c == notified {
+				m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	m.acquire(t, count)
+	m.lock.Unlock()
+	var interrupted InterruptedError
+	if wasNotified && errors.As(waitError, &interrupted) {
+		// The notification must not be lost (JLS 17.2.4), so return
+		// normally, and leave the interrupt pending instead.
+		t.Interrupt()
+		return nil
+	}
+	return waitError
+}
+
+// Implements Object.notify(). Wakes up one thread waiting on the monitor, if
+// there are any. The monitor must be held by the given thread.
+func (m *Monitor) Notify(t *Thread) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e := m.checkOwner(t, "notify()")
+	if e != nil {
+		return e
+	}
+	if len(m.waiters) == 0 {
+		return nil
+	}
+	close(m.waiters[0])
+	m.waiters = m.waiters[1:]
+	return nil
+}
+
+// Implements Object.notifyAll(). Wakes up every thread waiting on the monitor.
+// The monitor must be held by the given thread.
+func (m *Monitor) NotifyAll(t *Thread) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e := m.checkOwner(t, "notifyAll()")
+	if e != nil {
+		return e
+	}
+	for _, c := range m.waiters {
+		close(c)
+	}
+	m.waiters = nil
+	return nil
+}
+
+// Embedded in each type of object that can be synchronized on, to give each
+// object its own monitor.
+type objectMonitor struct {
+	monitor Monitor
+}
+
+// Returns the object's monitor.
+func (o *objectMonitor) GetMonitor() *Monitor {
+	return &(o.monitor)
+}
+
+// Returns the monitor belonging to the given object. Returns a
+// NullReferenceError if the object is null, or a TypeError if the object
+// doesn't have a monitor.
+func GetMonitor(o Object) (*Monitor, error) {
+	if IsNull(o) {
+		return nil, NullReferenceError("Can't synchronize on null")
+	}
+	withMonitor, ok := o.(interface{ GetMonitor() *Monitor })
+	if !ok {
+		return nil, TypeError("Can't synchronize on " + o.TypeName())
+	}
+	return withMonitor.GetMonitor(), nil
+}
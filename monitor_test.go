@@ -0,0 +1,158 @@
+package bs_jvm
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitorEnterExit(t *testing.T) {
+	a := &Thread{Name: "a"}
+	b := &Thread{Name: "b"}
+	instance := &ClassInstance{}
+	m, e := GetMonitor(instance)
+	if e != nil {
+		t.Logf("Failed getting monitor: %s\n", e)
+		t.FailNow()
+	}
+	e = m.Exit(a)
+	if _, ok := e.(IllegalMonitorStateError); !ok {
+		t.Logf("Expected an IllegalMonitorStateError, got %v\n", e)
+		t.Fail()
+	}
+
+	// Enter the monitor twice, then make sure b can't acquire it until a has
+	// exited it twice.
+	m.Enter(a)
+	m.Enter(a)
+	acquired := make(chan bool)
+	go func() {
+		m.Enter(b)
+		acquired <- true
+	}()
+	e = m.Exit(a)
+	if e != nil {
+		t.Logf("Failed exiting monitor: %s\n", e)
+		t.FailNow()
+	}
+	select {
+	case <-acquired:
+		t.Logf("Thread b acquired a monitor that was still held\n")
+		t.FailNow()
+	case <-time.After(20 * time.Millisecond):
+	}
+	e = m.Exit(a)
+	if e != nil {
+		t.Logf("Failed exiting monitor the second time: %s\n", e)
+		t.FailNow()
+	}
+	<-acquired
+	if !m.IsOwnedBy(b) {
+		t.Logf("Thread b should hold the monitor\n")
+		t.Fail()
+	}
+
+	_, e = GetMonitor(nil)
+	if _, ok := e.(NullReferenceError); !ok {
+		t.Logf("Expected a NullReferenceError, got %v\n", e)
+		t.Fail()
+	}
+}
+
+func TestMonitorWaitNotify(t *testing.T) {
+	var m Monitor
+	waiters := []*Thread{{Name: "a"}, {Name: "b"}}
+	notifier := &Thread{Name: "notifier"}
+	e := m.Notify(notifier)
+	if _, ok := e.(IllegalMonitorStateError); !ok {
+		t.Logf("Expected an IllegalMonitorStateError, got %v\n", e)
+		t.Fail()
+	}
+
+	// Wait with a timeout, and no notification.
+	m.Enter(notifier)
+	e = m.Wait(notifier, time.Millisecond)
+	if e != nil {
+		t.Logf("Failed waiting with a timeout: %s\n", e)
+		t.FailNow()
+	}
+	if !m.IsOwnedBy(notifier) {
+		t.Logf("Wait didn't re-acquire the monitor\n")
+		t.FailNow()
+	}
+	m.Exit(notifier)
+
+	// Both waiters should be woken up by notifyAll.
+	var wg sync.WaitGroup
+	ready := make(chan bool)
+	for _, thread := range waiters {
+		wg.Add(1)
+		go func(thread *Thread) {
+			defer wg.Done()
+			m.Enter(thread)
+			ready <- true
+			e := m.Wait(thread, 0)
+			if e != nil {
+				t.Logf("Wait failed: %s\n", e)
+				t.Fail()
+			}
+			m.Exit(thread)
+		}(thread)
+	}
+	// Each waiter will release the monitor when it starts waiting, so once
+	// we've acquired it after each waiter is ready, both will be waiting.
+	<-ready
+	<-ready
+	m.Enter(notifier)
+	m.Enter(notifier)
+	e = m.NotifyAll(notifier)
+	if e != nil {
+		t.Logf("Failed notifying all threads: %s\n", e)
+		t.FailNow()
+	}
+	m.Exit(notifier)
+	m.Exit(notifier)
+	wg.Wait()
+}
+
+func TestMonitorNotifyInterrupted(t *testing.T) {
+	var m Monitor
+	waiter := &Thread{Name: "waiter"}
+	notifier := &Thread{Name: "notifier"}
+	ready := make(chan bool)
+	result := make(chan error)
+	// Holding the waiter's interrupt lock keeps it from checking whether
+	// it's been interrupted until after it's been both notified and
+	// interrupted. The notification must not be lost, so Wait must return
+	// normally, and leave the waiter interrupted (JLS 17.2.4).
+	waiter.interruptLock.Lock()
+	go func() {
+		m.Enter(waiter)
+		ready <- true
+		e := m.Wait(waiter, 0)
+		m.Exit(waiter)
+		result <- e
+	}()
+	<-ready
+	// The waiter releases the monitor when it starts waiting.
+	m.Enter(notifier)
+	e := m.Notify(notifier)
+	if e != nil {
+		waiter.interruptLock.Unlock()
+		t.Logf("Failed notifying the waiter: %s\n", e)
+		t.FailNow()
+	}
+	waiter.interrupted = true
+	close(waiter.getInterruptSignal())
+	waiter.interruptLock.Unlock()
+	m.Exit(notifier)
+	e = <-result
+	if e != nil {
+		t.Logf("Wait lost a notification, returning %s\n", e)
+		t.Fail()
+	}
+	if !waiter.IsInterrupted() {
+		t.Logf("The waiter's interrupt was lost\n")
+		t.Fail()
+	}
+}
@@ -3,7 +3,10 @@ package bs_jvm
 // This file contains types relating to various JVM objects.
 
 import (
+	"fmt"
 	"github.com/yalue/bs_jvm/class_file"
+	"reflect"
+	"strings"
 )
 
 // A JVM object can be either a primitive or a reference type.
@@ -13,6 +16,16 @@ type Object interface {
 	String() string
 }
 
+// Returns true if the object is a Java null reference. Null references may
+// either be a nil Object or a *NullObject.
+func IsNull(o Object) bool {
+	if o == nil {
+		return true
+	}
+	_, isNull := o.(*NullObject)
+	return isNull
+}
+
 // A "null" object in java, used as a placeholder for uninitialized objects.
 type NullObject struct {
 	// The type destcriptor of the object, if it's known. Will be nil if it
@@ -34,3 +47,158 @@ func (o *NullObject) String() string {
 	}
 	return "null, instance of type " + o.ExpectedType.String()
 }
+
+// Returns a hash code based on the object's identity, like Java's
+// System.identityHashCode. Returns 0 for null.
+func IdentityHashCode(o Object) Int {
+	if IsNull(o) {
+		return 0
+	}
+	v := reflect.ValueOf(o)
+	if v.Kind() != reflect.Ptr {
+		return 0
+	}
+	// Objects are always at least 8-byte aligned, so the lowest bits of the
+	// pointer are always the same.
+	p := uint64(v.Pointer()) >> 3
+	return Int(uint32(p^(p>>32)) & 0x7fffffff)
+}
+
+// Converts an internal class name, e.g. "java/lang/String", to the format
+// used by Java's Class.getName, e.g. "java.lang.String".
+func binaryClassName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// Returns the name of the array type in the format used by Java's
+// Class.getName, e.g. "[I" or "[Ljava.lang.String;".
+func arrayClassName(t *class_file.ArrayType) string {
+	var component string
+	switch v := t.ContentType.(type) {
+	case class_file.PrimitiveFieldType:
+		component = string(rune(v))
+	case class_file.ClassInstanceType:
+		component = "L" + binaryClassName(string(v)) + ";"
+	default:
+		component = v.String()
+	}
+	return strings.Repeat("[", int(t.Dimensions)) + component
+}
+
+// Returns the name of the object's class, in the format used by Java's
+// Class.getName.
+func JavaClassName(o Object) string {
+	switch v := o.(type) {
+	case *ClassInstance:
+		return binaryClassName(string(v.C.Name))
+	case *StringObject:
+		return "java.lang.String"
+	case *Class:
+		return "java.lang.Class"
+	case Array:
+		return arrayClassName(v.ArrayType())
+	}
+	return o.TypeName()
+}
+
+// Converts the object to a string, like Java's String.valueOf(Object). Calls
+// the object's toString() method if its class has one. Otherwise, returns the
+// class name and identity hash code, like java/lang/Object's default
+// toString(). Must only be called from a native method, since toString() may
+// need to run.
+func (t *Thread) ObjectToString(o Object) (string, error) {
+	if IsNull(o) {
+		return "null", nil
+	}
+	switch v := o.(type) {
+	case *StringObject:
+		return v.Value(), nil
+	case *Class:
+		if v.IsPrimitiveType() {
+			return string(v.Name), nil
+		}
+		if v.IsInterface() {
+			return "interface " + binaryClassName(string(v.Name)), nil
+		}
+		return "class " + binaryClassName(string(v.Name)), nil
+	case *ClassInstance:
+		// The classes wrapping primitive values are final, so there's no
+		// need to invoke their toString() methods.
+		boxed := GetBoxedValue(v)
+		if boxed != nil {
+			return boxed.JavaString(), nil
+		}
+		toString, e := v.C.ResolveMethod(GetMethodKey(&class_file.Method{
+			Name: []byte("toString"),
+			Descriptor: &class_file.MethodDescriptor{
+				ArgumentTypes: []class_file.FieldType{},
+				ReturnType:    class_file.ClassInstanceType("java/lang/String"),
+			},
+		}))
+		if e != nil {
+			break
+		}
+		toString, e = v.C.SelectMethod(toString)
+		if e != nil {
+			return "", e
+		}
+		result, e := t.Invoke(toString, v)
+		if e != nil {
+			return "", e
+		}
+		if IsNull(result) {
+			return "null", nil
+		}
+		s, ok := result.(*StringObject)
+		if !ok {
+			return "", TypeError(fmt.Sprintf("%s.toString() returned %s",
+				v.C.Name, result.TypeName()))
+		}
+		return s.Value(), nil
+	}
+	return fmt.Sprintf("%s@%x", JavaClassName(o), uint32(IdentityHashCode(o))),
+		nil
+}
+
+// Returns the result of calling the object's hashCode() method, like Java's
+// Objects.hashCode. Returns 0 for null. Must only be called from a native
+// method, since hashCode() may need to run.
+func (t *Thread) ObjectHashCode(o Object) (Int, error) {
+	if IsNull(o) {
+		return 0, nil
+	}
+	switch v := o.(type) {
+	case *StringObject:
+		return v.HashCode(), nil
+	case *ClassInstance:
+		boxed := GetBoxedValue(v)
+		if boxed != nil {
+			return boxed.HashCode(), nil
+		}
+		hashCode, e := v.C.ResolveMethod(GetMethodKey(&class_file.Method{
+			Name: []byte("hashCode"),
+			Descriptor: &class_file.MethodDescriptor{
+				ArgumentTypes: []class_file.FieldType{},
+				ReturnType:    class_file.PrimitiveFieldType('I'),
+			},
+		}))
+		if e != nil {
+			break
+		}
+		hashCode, e = v.C.SelectMethod(hashCode)
+		if e != nil {
+			return 0, e
+		}
+		result, e := t.Invoke(hashCode, v)
+		if e != nil {
+			return 0, e
+		}
+		hash, ok := result.(Int)
+		if !ok {
+			return 0, TypeError(fmt.Sprintf("%s.hashCode() returned %s",
+				v.C.Name, result.TypeName()))
+		}
+		return hash, nil
+	}
+	return IdentityHashCode(o), nil
+}
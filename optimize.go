@@ -8,15 +8,20 @@ import (
 	"math"
 )
 
-// Converts a constant to a value that can be pushed by ldc or ldc_w. Returns
-// the primitive value or reference to push. Returns true if the returned value
-// is a primitive.
-func constantToLdcInfo(class *Class, c class_file.Constant) (Int, Object, bool,
+// Converts the constant at the given index to a value that can be pushed by
+// ldc or ldc_w. Returns the primitive value or reference to push. Returns true
+// if the returned value is a primitive. Dynamic constants are returned as a
+// *dynamicConstant reference, which must be resolved when the instruction
+// runs.
+func constantToLdcInfo(class *Class, index uint16) (Int, Object, bool,
 	error) {
 	var primitive Int
 	var reference Object = nil
 	isPrimitive := false
-	var e error
+	c, e := class.File.GetConstant(index)
+	if e != nil {
+		return 0, nil, false, e
+	}
 	// Primitive types can be converted now, but references to other objects
 	// can be handled later--for now just read them from the class file.
 	switch v := c.(type) {
@@ -34,6 +39,16 @@ func constantToLdcInfo(class *Class, c class_file.Constant) (Int, Object, bool,
 		if e != nil {
 			return 0, nil, false, e
 		}
+	case *class_file.ConstantDynamicInfo:
+		dynamic, e := class.getDynamicConstant(index)
+		if e != nil {
+			return 0, nil, false, e
+		}
+		if dynamic.isCategory2() {
+			return 0, nil, false, TypeError(fmt.Sprintf("Can't load %s "+
+				"using ldc", dynamic))
+		}
+		reference = dynamic
 	default:
 		// ldc only allows the types of constants listed above.
 		return 0, nil, false, TypeError(fmt.Sprintf("Invalid ldc constant: %s",
@@ -44,12 +59,8 @@ func constantToLdcInfo(class *Class, c class_file.Constant) (Int, Object, bool,
 
 func (n *ldcInstruction) Optimize(m *Method, offset uint,
 	instructionIndices map[uint]int) error {
-	constant, e := m.ContainingClass.File.GetConstant(uint16(n.value))
-	if e != nil {
-		return e
-	}
 	primitive, reference, isPrimitive, e := constantToLdcInfo(
-		m.ContainingClass, constant)
+		m.ContainingClass, uint16(n.value))
 	if e != nil {
 		return e
 	}
@@ -61,12 +72,8 @@ func (n *ldcInstruction) Optimize(m *Method, offset uint,
 
 func (n *ldc_wInstruction) Optimize(m *Method, offset uint,
 	instructionIndices map[uint]int) error {
-	constant, e := m.ContainingClass.File.GetConstant(n.value)
-	if e != nil {
-		return e
-	}
 	primitive, reference, isPrimitive, e := constantToLdcInfo(
-		m.ContainingClass, constant)
+		m.ContainingClass, n.value)
 	if e != nil {
 		return e
 	}
@@ -89,6 +96,16 @@ func (n *ldc2_wInstruction) Optimize(m *Method, offset uint,
 	case *class_file.ConstantDoubleInfo:
 		n.primitiveValue = Long(math.Float64bits(v.Value))
 		n.reference = Double(v.Value)
+	case *class_file.ConstantDynamicInfo:
+		dynamic, e := m.ContainingClass.getDynamicConstant(n.value)
+		if e != nil {
+			return e
+		}
+		if !dynamic.isCategory2() {
+			return TypeError(fmt.Sprintf("Can't load %s using ldc2_w",
+				dynamic))
+		}
+		n.reference = dynamic
 	default:
 		return TypeError(fmt.Sprintf("Invalid ldc2_w constant: %s", constant))
 	}
@@ -456,6 +473,7 @@ func (n *getstaticInstruction) Optimize(m *Method, offset uint,
 	}
 	n.class = targetClass
 	n.index = index
+	n.access = m.ContainingClass.getFieldAccessCheck(targetClass, fieldName)
 	return nil
 }
 
@@ -476,30 +494,52 @@ func (n *putstaticInstruction) Optimize(m *Method, offset uint,
 	}
 	n.class = targetClass
 	n.index = index
+	n.access = m.ContainingClass.getFieldAccessCheck(targetClass, fieldName)
 	return nil
 }
 
+// Resolves the field, which may be declared in a superclass of the class named
+// in the field reference. Since inherited fields are laid out at the start of
+// each instance's FieldValues, the index will be the same for any object
+// that's an instance of the declaring class.
 func (n *getfieldInstruction) Optimize(m *Method, offset uint,
 	indices map[uint]int) error {
-	// There's potentially a lot more we can do here, but for now we'll just
-	// look up the field's name ahead of time.
 	fieldInfo, e := lookupFieldInfoConstant(m.ContainingClass, n.value)
 	if e != nil {
 		return fmt.Errorf("Failed resolving field for getfield "+
 			"instruction: %w", e)
 	}
+	fieldName := string(fieldInfo.Field.Name)
+	targetClass, index, e := fieldInfo.C.ResolveField(fieldName)
+	if e != nil {
+		return fmt.Errorf("Couldn't resolve field %s in class %s: %w",
+			fieldName, fieldInfo.C.Name, e)
+	}
 	n.fieldReference = fieldInfo
+	n.class = targetClass
+	n.index = index
+	n.access = m.ContainingClass.getFieldAccessCheck(targetClass, fieldName)
 	return nil
 }
 
 func (n *putfieldInstruction) Optimize(m *Method, offset uint,
 	indices map[uint]int) error {
+	// Basically the same as for getfield.
 	fieldInfo, e := lookupFieldInfoConstant(m.ContainingClass, n.value)
 	if e != nil {
 		return fmt.Errorf("Failed resolving field for putfield "+
 			"instruction: %w", e)
 	}
+	fieldName := string(fieldInfo.Field.Name)
+	targetClass, index, e := fieldInfo.C.ResolveField(fieldName)
+	if e != nil {
+		return fmt.Errorf("Couldn't resolve field %s in class %s: %w",
+			fieldName, fieldInfo.C.Name, e)
+	}
 	n.fieldReference = fieldInfo
+	n.class = targetClass
+	n.index = index
+	n.access = m.ContainingClass.getFieldAccessCheck(targetClass, fieldName)
 	return nil
 }
 
@@ -525,6 +565,86 @@ func lookupClassConstant(currentClass *Class, index uint16) (*Class, error) {
 	return toReturn, nil
 }
 
+// Like lookupClassConstant, but returns the type named by the class constant.
+// Unlike lookupClassConstant, this also supports class constants naming array
+// types, e.g. "[I". Loads the named class, or the class of the array's
+// elements, if it hasn't been loaded yet.
+func lookupClassConstantType(currentClass *Class,
+	index uint16) (class_file.FieldType, error) {
+	classFile := currentClass.File
+	constant, e := classFile.GetConstant(index)
+	if e != nil {
+		return nil, fmt.Errorf("Couldn't get class info constant: %w", e)
+	}
+	classInfo, ok := constant.(*class_file.ConstantClassInfo)
+	if !ok {
+		return nil, fmt.Errorf("Expected a class info constant, got %s",
+			constant)
+	}
+	name, e := classFile.GetUTF8Constant(classInfo.NameIndex)
+	if e != nil {
+		return nil, fmt.Errorf("Failed getting class name: %w", e)
+	}
+	var toReturn class_file.FieldType
+	toReturn = class_file.ClassInstanceType(name)
+	className := string(name)
+	if (len(name) != 0) && (name[0] == '[') {
+		arrayType, e := class_file.ParseFieldType(name)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid array class name: %w", e)
+		}
+		toReturn = arrayType
+		contentType := arrayType.(*class_file.ArrayType).ContentType
+		contentClass, isClass := contentType.(class_file.ClassInstanceType)
+		if !isClass {
+			// Arrays of primitives don't need to load any classes.
+			return toReturn, nil
+		}
+		className = string(contentClass)
+	}
+	_, e = currentClass.ParentJVM.GetOrLoadClass(className)
+	if e != nil {
+		return nil, e
+	}
+	return toReturn, nil
+}
+
+func (n *anewarrayInstruction) Optimize(m *Method, offset uint,
+	indices map[uint]int) error {
+	elementType, e := lookupClassConstantType(m.ContainingClass, n.value)
+	if e != nil {
+		return fmt.Errorf("Failed resolving anewarray type: %w", e)
+	}
+	n.arrayType = &class_file.ArrayType{
+		Dimensions:  1,
+		ContentType: elementType,
+	}
+	elementArrayType, isArray := elementType.(*class_file.ArrayType)
+	if isArray {
+		n.arrayType.Dimensions = elementArrayType.Dimensions + 1
+		n.arrayType.ContentType = elementArrayType.ContentType
+	}
+	return nil
+}
+
+func (n *multianewarrayInstruction) Optimize(m *Method, offset uint,
+	indices map[uint]int) error {
+	t, e := lookupClassConstantType(m.ContainingClass, n.typeIndex)
+	if e != nil {
+		return fmt.Errorf("Failed resolving multianewarray type: %w", e)
+	}
+	arrayType, ok := t.(*class_file.ArrayType)
+	if !ok {
+		return fmt.Errorf("multianewarray requires an array type, got %s", t)
+	}
+	if (n.dimensions == 0) || (n.dimensions > arrayType.Dimensions) {
+		return fmt.Errorf("Can't use multianewarray to create %d dimensions "+
+			"of %s", n.dimensions, arrayType)
+	}
+	n.arrayType = arrayType
+	return nil
+}
+
 func (n *newInstruction) Optimize(m *Method, offset uint,
 	indices map[uint]int) error {
 	class, e := lookupClassConstant(m.ContainingClass, n.value)
@@ -535,60 +655,91 @@ func (n *newInstruction) Optimize(m *Method, offset uint,
 	return nil
 }
 
-// Like lookupFieldInfoConstant, but for methods.
+// Like lookupFieldInfoConstant, but for methods. The constant may either be a
+// method or interface method reference. Returns the resolved reference, and
+// the method it resolves to.
 func lookupMethodInfoConstant(c *Class, index uint16) (*FieldOrMethodReference,
-	error) {
+	*Method, error) {
 	classFile := c.File
 	constant, e := classFile.GetConstant(index)
 	if e != nil {
-		return nil, fmt.Errorf("Couldn't get method info constant: %w", e)
+		return nil, nil, fmt.Errorf("Couldn't get method info constant: %w", e)
 	}
-	_, ok := constant.(*class_file.ConstantMethodInfo)
-	if !ok {
-		return nil, fmt.Errorf("Didn't get a method info constant, instead "+
-			"got: %s", constant.String())
+	_, isMethod := constant.(*class_file.ConstantMethodInfo)
+	_, isInterfaceMethod := constant.(*class_file.ConstantInterfaceMethodInfo)
+	if !isMethod && !isInterfaceMethod {
+		return nil, nil, fmt.Errorf("Didn't get a method info constant, "+
+			"instead got: %s", constant.String())
 	}
 	tmp, e := ConvertConstantToObject(c, constant)
 	if e != nil {
-		return nil, fmt.Errorf("Error processing method info constant: %w", e)
+		return nil, nil, fmt.Errorf("Error processing method info constant: "+
+			"%w", e)
 	}
 	methodRef, ok := tmp.(*FieldOrMethodReference)
 	if !ok {
-		return nil, fmt.Errorf("Didn't get expected method reference object, "+
-			"instead got: %s", tmp.String())
-	}
-	return methodRef, nil
-}
-
-func (n *invokespecialInstruction) Optimize(m *Method, offset uint,
-	indices map[uint]int) error {
-	methodInfo, e := lookupMethodInfoConstant(m.ContainingClass, n.value)
-	if e != nil {
-		return fmt.Errorf("Failed resolving method for invokespecial "+
-			"instruction: %w", e)
+		return nil, nil, fmt.Errorf("Didn't get expected method reference "+
+			"object, instead got: %s", tmp.String())
 	}
 	// We need the parsed method descriptor to convert into our "key" format
 	// used for looking up the method in the class.
 	methodDescriptor, e := class_file.ParseMethodDescriptor(
-		methodInfo.Field.Type)
+		methodRef.Field.Type)
 	if e != nil {
-		return fmt.Errorf("Failed parsing method %s descriptor for "+
-			"invokespecial instruction: %w", methodInfo.Field.Name, e)
+		return nil, nil, fmt.Errorf("Failed parsing method %s descriptor: %w",
+			methodRef.Field.Name, e)
 	}
-	tmp := &class_file.Method{
-		Name:       methodInfo.Field.Name,
+	key := GetMethodKey(&class_file.Method{
+		Name:       methodRef.Field.Name,
 		Descriptor: methodDescriptor,
+	})
+	var method *Method
+	if isInterfaceMethod {
+		method, e = methodRef.C.ResolveInterfaceMethod(key)
+	} else {
+		method, e = methodRef.C.ResolveMethod(key)
 	}
-	key := GetMethodKey(tmp)
-	method, e := methodInfo.C.GetMethod(key)
 	if e != nil {
-		return fmt.Errorf("Failed getting method %s: %w",
-			methodInfo.Field.Name, e)
+		return nil, nil, fmt.Errorf("Failed getting method %s: %w",
+			methodRef.Field.Name, e)
+	}
+	return methodRef, method, nil
+}
+
+// Returns the number of reference arguments taken by the method, not
+// including "this". These are the references that will be above the receiver
+// on the reference stack when the method is invoked.
+func countReferenceArgs(m *Method) int {
+	toReturn := 0
+	for _, t := range m.Types.ArgumentTypes {
+		_, isPrimitive := t.(class_file.PrimitiveFieldType)
+		if !isPrimitive {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Resolves the method, but the actual method to call is selected from the
+// superclass when calling a superclass' method from a subclass.
+func (n *invokespecialInstruction) Optimize(m *Method, offset uint,
+	indices map[uint]int) error {
+	methodInfo, method, e := lookupMethodInfoConstant(m.ContainingClass,
+		n.value)
+	if e != nil {
+		return fmt.Errorf("Failed resolving method for invokespecial "+
+			"instruction: %w", e)
 	}
 	if method.IsStatic() {
 		return TypeError(fmt.Sprintf("Can't use static method %s with the "+
 			"invokespecial instruction", methodInfo.Field.Name))
 	}
+	n.access = m.ContainingClass.getMethodAccessCheck(method)
+	method, e = m.ContainingClass.SelectSpecialMethod(methodInfo.C, method)
+	if e != nil {
+		return fmt.Errorf("Failed selecting method %s for invokespecial "+
+			"instruction: %w", methodInfo.Field.Name, e)
+	}
 	n.method = method
 	return nil
 }
@@ -596,65 +747,105 @@ func (n *invokespecialInstruction) Optimize(m *Method, offset uint,
 func (n *invokestaticInstruction) Optimize(m *Method, offset uint,
 	indices map[uint]int) error {
 	// This process is very similar to the one used in invokespecial
-	methodInfo, e := lookupMethodInfoConstant(m.ContainingClass, n.value)
+	methodInfo, method, e := lookupMethodInfoConstant(m.ContainingClass,
+		n.value)
 	if e != nil {
 		return fmt.Errorf("Failed resolving method for invokestatic "+
 			"instruction: %w", e)
 	}
-	methodDescriptor, e := class_file.ParseMethodDescriptor(
-		methodInfo.Field.Type)
-	if e != nil {
-		return fmt.Errorf("Failed parsing %s descriptor for "+
-			"invokestatic instruction: %w", methodInfo.Field.Name, e)
-	}
-	tmp := &class_file.Method{
-		Name:       methodInfo.Field.Name,
-		Descriptor: methodDescriptor,
-	}
-	key := GetMethodKey(tmp)
-	method, e := methodInfo.C.GetMethod(key)
-	if e != nil {
-		return fmt.Errorf("Failed getting method %s: %w",
-			methodInfo.Field.Name, e)
-	}
 	// The spec requires invokestatic to only ever be used with static methods.
 	if !method.IsStatic() {
 		return TypeError(fmt.Sprintf("Can't call non-static method %s with "+
 			"the invokestatic instruction", methodInfo.Field.Name))
 	}
+	n.access = m.ContainingClass.getMethodAccessCheck(method)
 	n.method = method
 	return nil
 }
 
+// Resolves the method. The method that's actually invoked is selected at
+// runtime, based on the class of the object it's invoked on.
 func (n *invokevirtualInstruction) Optimize(m *Method, offset uint,
 	indices map[uint]int) error {
-	// Similar to other invoke instructions.
-	methodInfo, e := lookupMethodInfoConstant(m.ContainingClass, n.value)
+	methodInfo, method, e := lookupMethodInfoConstant(m.ContainingClass,
+		n.value)
 	if e != nil {
 		return fmt.Errorf("Failed resolving method for invokevirtual "+
 			"instruction: %w", e)
 	}
-	methodDescriptor, e := class_file.ParseMethodDescriptor(
-		methodInfo.Field.Type)
-	if e != nil {
-		return fmt.Errorf("Failed parsing %s descriptor for "+
-			"invokevirtual instruction: %w", methodInfo.Field.Name, e)
-	}
-	tmp := &class_file.Method{
-		Name:       methodInfo.Field.Name,
-		Descriptor: methodDescriptor,
-	}
-	key := GetMethodKey(tmp)
-	method, e := methodInfo.C.GetMethod(key)
-	if e != nil {
-		return fmt.Errorf("Failed getting method %s: %w",
-			methodInfo.Field.Name, e)
-	}
 	// It isn't allowed to use invokevirtual with static methods.
 	if method.IsStatic() {
 		return TypeError(fmt.Sprintf("Can't use static method %s with the "+
 			"invokevirtual instruction", methodInfo.Field.Name))
 	}
+	n.access = m.ContainingClass.getMethodAccessCheck(method)
 	n.method = method
+	n.referenceArgs = countReferenceArgs(method)
+	return nil
+}
+
+func (n *invokeinterfaceInstruction) Optimize(m *Method, offset uint,
+	indices map[uint]int) error {
+	methodInfo, method, e := lookupMethodInfoConstant(m.ContainingClass,
+		n.value)
+	if e != nil {
+		return fmt.Errorf("Failed resolving method for invokeinterface "+
+			"instruction: %w", e)
+	}
+	if !methodInfo.C.IsInterface() {
+		return IncompatibleClassChangeError(fmt.Sprintf("invokeinterface "+
+			"used with %s, which isn't an interface", methodInfo.C.Name))
+	}
+	if method.IsStatic() || method.IsPrivate() {
+		return IncompatibleClassChangeError(fmt.Sprintf("Can't use %s with "+
+			"the invokeinterface instruction", methodInfo.Field.Name))
+	}
+	n.class = methodInfo.C
+	n.method = method
+	n.referenceArgs = countReferenceArgs(method)
+	return nil
+}
+
+// Resolves the bootstrap method and its static arguments. The call site isn't
+// linked until the instruction runs, since that requires running the
+// bootstrap method.
+func (n *invokedynamicInstruction) Optimize(m *Method, offset uint,
+	indices map[uint]int) error {
+	c := m.ContainingClass
+	constant, e := c.File.GetConstant(n.value)
+	if e != nil {
+		return fmt.Errorf("Couldn't get invokedynamic constant: %w", e)
+	}
+	info, ok := constant.(*class_file.ConstantInvokeDynamicInfo)
+	if !ok {
+		return fmt.Errorf("Didn't get an invokedynamic constant, instead "+
+			"got: %s", constant)
+	}
+	n.bootstrap, n.bootstrapArgs, e = loadBootstrapMethod(c,
+		info.BootstrapMethodAttributeIndex)
+	if e != nil {
+		return e
+	}
+	constant, e = c.File.GetConstant(info.NameAndTypeIndex)
+	if e != nil {
+		return fmt.Errorf("Couldn't get call site name and type: %w", e)
+	}
+	nameAndTypeInfo, ok := constant.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return fmt.Errorf("Didn't get a name and type constant, instead "+
+			"got: %s", constant)
+	}
+	nameAndType, e := ResolveNameAndTypeInfoConstant(c, nameAndTypeInfo)
+	if e != nil {
+		return e
+	}
+	n.descriptor, e = class_file.ParseMethodDescriptor(nameAndType.Type)
+	if e != nil {
+		return fmt.Errorf("Failed parsing call site descriptor: %w", e)
+	}
+	methodType := MethodType(nameAndType.Type)
+	n.methodType = &methodType
+	n.name = string(nameAndType.Name)
+	n.class = c
 	return nil
 }
@@ -3,7 +3,9 @@ package bs_jvm
 // This file contains definitions of the JVM's primitivae data types.
 
 import (
+	"math"
 	"strconv"
+	"strings"
 )
 
 // A special interface implemented only by primitive types, to allow converting
@@ -179,12 +181,63 @@ func (b Bool) ConvertFrom(v PrimitiveType) PrimitiveType {
 	return Bool((v.IntValue() & 1) != 0)
 }
 
+// Formats a float or double the same way as Java's Float.toString or
+// Double.toString. The bitSize must be 32 for floats or 64 for doubles.
+func formatJavaFloat(v float64, bitSize int) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	case v == 0:
+		if math.Signbit(v) {
+			return "-0.0"
+		}
+		return "0.0"
+	}
+	magnitude := math.Abs(v)
+	if (magnitude >= 1e-3) && (magnitude < 1e7) {
+		toReturn := strconv.FormatFloat(v, 'f', -1, bitSize)
+		if !strings.Contains(toReturn, ".") {
+			toReturn += ".0"
+		}
+		return toReturn
+	}
+	// Go formats these like "1.5e+07", but Java formats them like "1.5E7".
+	tmp := strconv.FormatFloat(v, 'e', -1, bitSize)
+	if !strings.Contains(tmp, ".") {
+		// Java always prints at least two digits, and uses the two-digit
+		// value closest to v rather than padding the shortest one with a
+		// zero. This only matters for subnormal values, e.g. Java prints
+		// Double.MIN_VALUE as 4.9E-324 rather than 5.0E-324.
+		twoDigits := strconv.FormatFloat(v, 'e', 1, bitSize)
+		parsed, e := strconv.ParseFloat(twoDigits, bitSize)
+		if (e == nil) && (parsed == v) {
+			tmp = twoDigits
+		}
+	}
+	exponentStart := strings.IndexByte(tmp, 'e')
+	mantissa := tmp[:exponentStart]
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	exponent, _ := strconv.Atoi(tmp[exponentStart+1:])
+	return mantissa + "E" + strconv.Itoa(exponent)
+}
+
 type Float float32
 
 func (f Float) String() string {
 	return "float: " + strconv.FormatFloat(float64(f), 'g', 5, 32)
 }
 
+// Returns the float formatted as a string, like Java's Float.toString.
+func (f Float) JavaString() string {
+	return formatJavaFloat(float64(f), 32)
+}
+
 func (f Float) TypeName() string {
 	return "float"
 }
@@ -211,6 +264,11 @@ func (d Double) String() string {
 	return "double: " + strconv.FormatFloat(float64(d), 'g', 5, 64)
 }
 
+// Returns the double formatted as a string, like Java's Double.toString.
+func (d Double) JavaString() string {
+	return formatJavaFloat(float64(d), 64)
+}
+
 func (d Double) TypeName() string {
 	return "double"
 }
@@ -27,6 +27,11 @@ type ReturnInfo struct {
 	ReturnIndex    uint
 	StackState     StackSizes
 	LocalVariables []Object
+	// The monitor held by Method if it's synchronized, or nil otherwise.
+	Monitor *Monitor
+	// True if this frame was pushed by a native method calling Thread.Invoke.
+	// Uncaught exceptions don't propagate past these frames.
+	NativeCall bool
 }
 
 // An interface for a function call stack. A thread can keep this separate from
@@ -36,6 +41,10 @@ type CallStack interface {
 	PushFrame(f ReturnInfo) error
 	// Used to pop a return method and instruction index from the stack.
 	PopFrame() (ReturnInfo, error)
+	// Returns the frame the given number of positions below the top of the
+	// call stack, without popping it. A depth of 0 is the most recently
+	// pushed frame. Returns StackEmptyError if there aren't enough frames.
+	PeekFrame(depth int) (ReturnInfo, error)
 }
 
 // Implements the CallStack interface.
@@ -59,6 +68,13 @@ func (s *basicCallStack) PushFrame(f ReturnInfo) error {
 	return nil
 }
 
+func (s *basicCallStack) PeekFrame(depth int) (ReturnInfo, error) {
+	if (depth < 0) || (depth >= len(s.frames)) {
+		return ReturnInfo{}, StackEmptyError
+	}
+	return s.frames[len(s.frames)-1-depth], nil
+}
+
 func (s *basicCallStack) PopFrame() (ReturnInfo, error) {
 	if len(s.frames) == 0 {
 		return ReturnInfo{}, StackEmptyError
@@ -73,7 +89,9 @@ func (s *basicCallStack) PopFrame() (ReturnInfo, error) {
 type ReferenceStack interface {
 	PushRef(r Object) error
 	PopRef() (Object, error)
-	// Returns the current stack top indicator, which can be restored later.
+	// Returns the reference the given number of positions below the top of
+	// the stack without popping anything. A depth of 0 is the top reference.
+	PeekRef(depth int) (Object, error)
 	// Returns the current stack size, which can be restored later.
 	GetSize() int
 	// Sets the size of the stack, used for discarding multiple values at once.
@@ -102,6 +120,13 @@ func (s *basicReferenceStack) PopRef() (Object, error) {
 	return toReturn, nil
 }
 
+func (s *basicReferenceStack) PeekRef(depth int) (Object, error) {
+	if (depth < 0) || (depth >= len(s.references)) {
+		return nil, StackEmptyError
+	}
+	return s.references[len(s.references)-1-depth], nil
+}
+
 func (s *basicReferenceStack) GetSize() int {
 	return len(s.references)
 }
@@ -239,6 +264,10 @@ type ThreadStack interface {
 	PopDouble() (Double, error)
 	PushRef(r Object) error
 	PopRef() (Object, error)
+	// Returns a reference from the reference stack without popping it. Only
+	// references are counted when determining depth; a depth of 0 is the
+	// most recently pushed reference.
+	PeekRef(depth int) (Object, error)
 	// Pops whatever was the most recently pushed, either a primitive or a
 	// reference. If the most recently pushed thing was a primitive, this will
 	// return an int regardless of what type of primitive was pushed.
@@ -251,6 +280,9 @@ type ThreadStack interface {
 	PushFrame(f ReturnInfo) error
 	// Used to pop a return method and instruction index from the stack.
 	PopFrame() (ReturnInfo, error)
+	// Returns a frame from the call stack without popping it. A depth of 0 is
+	// the most recently pushed frame.
+	PeekFrame(depth int) (ReturnInfo, error)
 	// Backs up the sizes of the stacks so that they can be restored later.
 	GetSizes() StackSizes
 	// Restores the stack positions contained in the given call frame. Used
@@ -358,6 +390,10 @@ func (s *basicStack) PopRef() (Object, error) {
 	return r, e
 }
 
+func (s *basicStack) PeekRef(depth int) (Object, error) {
+	return s.refs.PeekRef(depth)
+}
+
 func (s *basicStack) PopUnconditional() (Object, error) {
 	if s.IsRef[len(s.IsRef)-1] {
 		return s.PopRef()
@@ -366,7 +402,7 @@ func (s *basicStack) PopUnconditional() (Object, error) {
 }
 
 func (s *basicStack) PushUnconditional(o Object) error {
-	if !o.IsPrimitive() {
+	if (o == nil) || !o.IsPrimitive() {
 		return s.PushRef(o)
 	}
 	switch v := o.(type) {
@@ -401,7 +437,7 @@ func PopRefNotNull(s ThreadStack) (Object, error) {
 	if e != nil {
 		return nil, e
 	}
-	if o == nil {
+	if IsNull(o) {
 		return nil, NullReferenceError("Expected to pop a non-null reference")
 	}
 	return o, nil
@@ -415,6 +451,10 @@ func (s *basicStack) PopFrame() (ReturnInfo, error) {
 	return s.calls.PopFrame()
 }
 
+func (s *basicStack) PeekFrame(depth int) (ReturnInfo, error) {
+	return s.calls.PeekFrame(depth)
+}
+
 func (s *basicStack) GetSizes() StackSizes {
 	return StackSizes{
 		DataStackSize:      s.data.GetSize(),
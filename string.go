@@ -0,0 +1,168 @@
+package bs_jvm
+
+// This file contains the internal representation of java/lang/String
+// instances, and functions for converting between Java's UTF-16 strings and
+// Go strings.
+
+import (
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Holds an instance of java/lang/String. Like in Java, the string's contents
+// are a sequence of UTF-16 code units, which may include unpaired surrogates.
+// Strings are immutable once they've been constructed.
+type StringObject struct {
+	chars []uint16
+	objectMonitor
+}
+
+// Returns a new StringObject containing the given Go string.
+func NewStringObject(s string) *StringObject {
+	return &StringObject{
+		chars: utf16.Encode([]rune(s)),
+	}
+}
+
+// Returns a new StringObject containing the given UTF-16 code units. The
+// StringObject takes ownership of the slice, so the caller must not modify it
+// afterwards.
+func NewStringObjectFromUTF16(chars []uint16) *StringObject {
+	return &StringObject{
+		chars: chars,
+	}
+}
+
+// Sets the contents of a newly allocated, empty StringObject. Used by String
+// constructors, which run after the "new" instruction creates the object. The
+// StringObject takes ownership of the slice.
+func (s *StringObject) Initialize(chars []uint16) {
+	s.chars = chars
+}
+
+func (s *StringObject) IsPrimitive() bool {
+	return false
+}
+
+func (s *StringObject) TypeName() string {
+	return "String"
+}
+
+// Returns the string's contents as a Go string. Unpaired surrogates are
+// replaced with U+FFFD.
+func (s *StringObject) Value() string {
+	return string(utf16.Decode(s.chars))
+}
+
+func (s *StringObject) String() string {
+	return fmt.Sprintf("%q", s.Value())
+}
+
+// Returns the UTF-16 code units making up the string. The returned slice must
+// not be modified.
+func (s *StringObject) UTF16() []uint16 {
+	return s.chars
+}
+
+// Returns the number of UTF-16 code units in the string, like Java's
+// String.length().
+func (s *StringObject) Length() int {
+	return len(s.chars)
+}
+
+// Returns the string's hash code, computed in the same way as Java's
+// String.hashCode().
+func (s *StringObject) HashCode() Int {
+	var toReturn Int
+	for _, c := range s.chars {
+		toReturn = 31*toReturn + Int(c)
+	}
+	return toReturn
+}
+
+// Returns true if the other string contains the same sequence of UTF-16 code
+// units as this one.
+func (s *StringObject) Equals(other *StringObject) bool {
+	if len(s.chars) != len(other.chars) {
+		return false
+	}
+	for i, c := range s.chars {
+		if other.chars[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a Go string that's unique to the string's sequence of code units,
+// for use as a map key. Unlike Value(), this doesn't lose unpaired surrogates.
+func (s *StringObject) internKey() string {
+	toReturn := make([]byte, 2*len(s.chars))
+	for i, c := range s.chars {
+		toReturn[2*i] = byte(c >> 8)
+		toReturn[2*i+1] = byte(c)
+	}
+	return string(toReturn)
+}
+
+// Returns the canonical instance of the given string, like Java's
+// String.intern(). If an equal string hasn't been interned yet, s becomes the
+// canonical instance.
+func (j *JVM) InternString(s *StringObject) *StringObject {
+	key := s.internKey()
+	j.internedStringsLock.Lock()
+	defer j.internedStringsLock.Unlock()
+	toReturn := j.internedStrings[key]
+	if toReturn != nil {
+		return toReturn
+	}
+	if j.internedStrings == nil {
+		j.internedStrings = make(map[string]*StringObject)
+	}
+	j.internedStrings[key] = s
+	return s
+}
+
+// Decodes the "modified UTF-8" format used by class file string constants
+// into UTF-16 code units. Characters outside of the basic multilingual plane
+// are encoded as two 3-byte surrogates in modified UTF-8, which this converts
+// to a surrogate pair. Standard 4-byte UTF-8 sequences are also accepted.
+// Invalid bytes are converted to U+FFFD.
+func DecodeModifiedUTF8(data []byte) []uint16 {
+	toReturn := make([]uint16, 0, len(data))
+	for len(data) > 0 {
+		b := data[0]
+		if b < 0x80 {
+			toReturn = append(toReturn, uint16(b))
+			data = data[1:]
+			continue
+		}
+		if ((b & 0xe0) == 0xc0) && (len(data) >= 2) &&
+			((data[1] & 0xc0) == 0x80) {
+			c := (uint16(b&0x1f) << 6) | uint16(data[1]&0x3f)
+			toReturn = append(toReturn, c)
+			data = data[2:]
+			continue
+		}
+		if ((b & 0xf0) == 0xe0) && (len(data) >= 3) &&
+			((data[1] & 0xc0) == 0x80) && ((data[2] & 0xc0) == 0x80) {
+			// This may be half of a surrogate pair, which we keep as-is.
+			c := (uint16(b&0x0f) << 12) | (uint16(data[1]&0x3f) << 6) |
+				uint16(data[2]&0x3f)
+			toReturn = append(toReturn, c)
+			data = data[3:]
+			continue
+		}
+		r, size := utf8.DecodeRune(data)
+		if (r == utf8.RuneError) || (size != 4) {
+			toReturn = append(toReturn, 0xfffd)
+			data = data[1:]
+			continue
+		}
+		high, low := utf16.EncodeRune(r)
+		toReturn = append(toReturn, uint16(high), uint16(low))
+		data = data[4:]
+	}
+	return toReturn
+}
@@ -0,0 +1,90 @@
+package bs_jvm
+
+import (
+	"testing"
+)
+
+func TestDecodeModifiedUTF8(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected []uint16
+	}{
+		{[]byte("abc"), []uint16{'a', 'b', 'c'}},
+		// Modified UTF-8 encodes the null character using two bytes.
+		{[]byte{0xc0, 0x80}, []uint16{0}},
+		{[]byte("é中"), []uint16{0xe9, 0x4e2d}},
+		// U+1F600, encoded as a surrogate pair in modified UTF-8 and in
+		// standard UTF-8.
+		{[]byte{0xed, 0xa0, 0xbd, 0xed, 0xb8, 0x80}, []uint16{0xd83d, 0xde00}},
+		{[]byte("\U0001F600"), []uint16{0xd83d, 0xde00}},
+		// An unpaired surrogate is kept.
+		{[]byte{'a', 0xed, 0xa0, 0xbd}, []uint16{'a', 0xd83d}},
+		// Invalid bytes are replaced.
+		{[]byte{0xff, 'a', 0xc3}, []uint16{0xfffd, 'a', 0xfffd}},
+	}
+	for _, test := range tests {
+		s := NewStringObjectFromUTF16(DecodeModifiedUTF8(test.data))
+		expected := NewStringObjectFromUTF16(test.expected)
+		if !s.Equals(expected) {
+			t.Logf("Decoding % x: expected %x, got %x\n", test.data,
+				test.expected, s.UTF16())
+			t.Fail()
+		}
+	}
+}
+
+func TestStringObject(t *testing.T) {
+	s := NewStringObject("x\U0001F600")
+	if s.Length() != 3 {
+		t.Logf("Expected a length of 3, got %d\n", s.Length())
+		t.Fail()
+	}
+	if s.Value() != "x\U0001F600" {
+		t.Logf("Got incorrect Go string: %s\n", s)
+		t.Fail()
+	}
+	// These values match the results of Java's String.hashCode().
+	if NewStringObject("hello").HashCode() != 99162322 {
+		t.Logf("Got incorrect hash code for \"hello\": %d\n",
+			NewStringObject("hello").HashCode())
+		t.Fail()
+	}
+	hash := NewStringObject("The quick brown fox").HashCode()
+	if hash != -1739336029 {
+		t.Logf("Got incorrect hash code for a long string: %d\n", hash)
+		t.Fail()
+	}
+	if NewStringObject("").HashCode() != 0 {
+		t.Logf("Expected the empty string's hash code to be 0\n")
+		t.Fail()
+	}
+	if s.Equals(NewStringObject("x")) || !s.Equals(NewStringObject(
+		"x\U0001F600")) {
+		t.Logf("Got incorrect results comparing strings\n")
+		t.Fail()
+	}
+}
+
+func TestInternString(t *testing.T) {
+	j := NewJVM()
+	a := NewStringObject("interned")
+	if j.InternString(a) != a {
+		t.Logf("The first interned string wasn't canonical\n")
+		t.Fail()
+	}
+	if j.InternString(NewStringObject("interned")) != a {
+		t.Logf("Interning an equal string didn't return the original\n")
+		t.Fail()
+	}
+	if j.InternString(NewStringObject("other")) == a {
+		t.Logf("Interning a different string returned the original\n")
+		t.Fail()
+	}
+	// Strings differing only in unpaired surrogates must not be merged.
+	b := j.InternString(NewStringObjectFromUTF16([]uint16{0xd800}))
+	c := j.InternString(NewStringObjectFromUTF16([]uint16{0xd801}))
+	if b == c {
+		t.Logf("Different unpaired surrogates were interned together\n")
+		t.Fail()
+	}
+}
@@ -0,0 +1,137 @@
+package bs_jvm
+
+// This file contains code for interrupting threads, and for blocking threads
+// in a way that can be interrupted.
+
+import (
+	"time"
+)
+
+// Returns the thread's name. Safe to call from any thread.
+func (t *Thread) GetName() string {
+	t.nameLock.Lock()
+	defer t.nameLock.Unlock()
+	return t.Name
+}
+
+// Changes the thread's name. Safe to call from any thread.
+func (t *Thread) SetName(name string) {
+	t.nameLock.Lock()
+	defer t.nameLock.Unlock()
+	t.Name = name
+}
+
+// Returns a channel that will be closed when the thread is interrupted. Must
+// be called while holding t.interruptLock.
+func (t *Thread) getInterruptSignal() chan bool {
+	if t.interruptSignal == nil {
+		t.interruptSignal = make(chan bool)
+	}
+	return t.interruptSignal
+}
+
+// Sets the thread's interrupted status, waking it up if it's sleeping, waiting
+// on a monitor, or joining another thread.
+func (t *Thread) Interrupt() {
+	t.interruptLock.Lock()
+	defer t.interruptLock.Unlock()
+	if t.interrupted {
+		return
+	}
+	t.interrupted = true
+	close(t.getInterruptSignal())
+}
+
+// Returns true if the thread has been interrupted, and the interrupt hasn't
+// been cleared.
+func (t *Thread) IsInterrupted() bool {
+	t.interruptLock.Lock()
+	defer t.interruptLock.Unlock()
+	return t.interrupted
+}
+
+// Clears the thread's interrupted status. Returns true if the thread had been
+// interrupted.
+func (t *Thread) ClearInterrupt() bool {
+	t.interruptLock.Lock()
+	defer t.interruptLock.Unlock()
+	if !t.interrupted {
+		return false
+	}
+	t.interrupted = false
+	t.interruptSignal = nil
+	return true
+}
+
+// Returns a channel that will be closed when the thread is interrupted. If
+// the thread has already been interrupted, this clears the interrupt and
+// returns an InterruptedError instead.
+func (t *Thread) interruptibleSignal(action string) (chan bool, error) {
+	t.interruptLock.Lock()
+	defer t.interruptLock.Unlock()
+	if t.interrupted {
+		t.interrupted = false
+		t.interruptSignal = nil
+		return nil, InterruptedError(action + " interrupted")
+	}
+	return t.getInterruptSignal(), nil
+}
+
+// Blocks until the given channel is closed or receives a value, the timeout
+// expires, or the thread is interrupted. A timeout of 0 waits indefinitely.
+// Returns an InterruptedError, and clears the thread's interrupted status, if
+// the thread was interrupted before or while waiting. Otherwise returns nil.
+// The action is used in the InterruptedError's message.
+func (t *Thread) BlockOn(c <-chan bool, timeout time.Duration,
+	action string) error {
+	interrupt, e := t.interruptibleSignal(action)
+	if e != nil {
+		return e
+	}
+	var timeoutSignal <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutSignal = timer.C
+	}
+	select {
+	case <-c:
+	case <-timeoutSignal:
+	case <-interrupt:
+		t.ClearInterrupt()
+		return InterruptedError(action + " interrupted")
+	}
+	return nil
+}
+
+// Causes the thread to sleep for the given amount of time. Returns an
+// InterruptedError if the thread is interrupted.
+func (t *Thread) Sleep(duration time.Duration) error {
+	if duration <= 0 {
+		// Like Java, a sleep of 0 still throws if the thread was interrupted.
+		_, e := t.interruptibleSignal("sleep")
+		return e
+	}
+	return t.BlockOn(nil, duration, "sleep")
+}
+
+// Returns a channel that will be closed when the thread has exited. Returns
+// nil for threads that weren't created using JVM.NewThread, which will never
+// be closed.
+func (t *Thread) Done() <-chan bool {
+	return t.done
+}
+
+// Returns true if the thread has exited. Always returns false for threads
+// that weren't created using JVM.NewThread.
+func (t *Thread) HasExited() bool {
+	if t.done == nil {
+		return false
+	}
+	select {
+	case <-t.done:
+		return true
+	default:
+	}
+	return false
+}
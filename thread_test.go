@@ -0,0 +1,194 @@
+package bs_jvm
+
+import (
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"testing"
+	"time"
+)
+
+func TestThreadSleepInterrupt(t *testing.T) {
+	thread := &Thread{Name: "sleeper"}
+	e := thread.Sleep(time.Millisecond)
+	if e != nil {
+		t.Logf("Failed sleeping: %s\n", e)
+		t.FailNow()
+	}
+
+	// Sleeping for 0 time should still fail if the thread was interrupted.
+	thread.Interrupt()
+	if !thread.IsInterrupted() {
+		t.Logf("The thread wasn't marked as interrupted\n")
+		t.FailNow()
+	}
+	e = thread.Sleep(0)
+	if _, ok := e.(InterruptedError); !ok {
+		t.Logf("Expected an InterruptedError, got %v\n", e)
+		t.FailNow()
+	}
+	if thread.IsInterrupted() {
+		t.Logf("Sleep didn't clear the interrupted status\n")
+		t.FailNow()
+	}
+
+	// Interrupt a sleep that would otherwise never finish in time.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		thread.Interrupt()
+	}()
+	e = thread.Sleep(time.Hour)
+	if _, ok := e.(InterruptedError); !ok {
+		t.Logf("Expected an InterruptedError, got %v\n", e)
+		t.FailNow()
+	}
+	if thread.ClearInterrupt() {
+		t.Logf("The interrupted status was still set after sleeping\n")
+		t.Fail()
+	}
+}
+
+func TestDaemonThreads(t *testing.T) {
+	jvm := NewJVM()
+	sleeper := getStubClassFile("Sleeper", "java/lang/Object", 0x0021, nil,
+		nil, nil)
+	blockIndex := addTestMethodConstant(sleeper, "block", "()V")
+	// invokestatic block, return
+	blockForeverCode := []byte{0xb8, byte(blockIndex >> 8), byte(blockIndex),
+		0xb1}
+	sleeper.Methods = []*class_file.Method{
+		// public static native
+		getStubMethod("block", 0x0109),
+		getTestCodeMethod("blockForever", blockForeverCode, nil),
+		getTestCodeMethod("quick", []byte{0xb1}, nil),
+	}
+	loadStubClasses(t, jvm, sleeper)
+	c, e := jvm.GetClass("Sleeper")
+	if e != nil {
+		t.Logf("Failed getting Sleeper class: %s\n", e)
+		t.FailNow()
+	}
+	// The native block() method waits until the thread is interrupted.
+	c.Methods[GetMethodKey(getStubMethod("block", 0))].Native =
+		func(thread *Thread) error {
+			return thread.BlockOn(nil, 0, "block")
+		}
+
+	method := c.Methods[GetMethodKey(getStubMethod("blockForever", 0))]
+	daemon, e := jvm.NewThread(method, "daemon")
+	if e != nil {
+		t.Logf("Failed creating daemon thread: %s\n", e)
+		t.FailNow()
+	}
+	daemon.Daemon = true
+	e = daemon.Start()
+	if e != nil {
+		t.Logf("Failed starting daemon thread: %s\n", e)
+		t.FailNow()
+	}
+	_, e = jvm.StartNamedThread("Sleeper",
+		GetMethodKey(getStubMethod("quick", 0)), "main")
+	if e != nil {
+		t.Logf("Failed starting main thread: %s\n", e)
+		t.FailNow()
+	}
+
+	// WaitForAllThreads must return even though the daemon is still blocked.
+	waitResult := make(chan error)
+	go func() {
+		waitResult <- jvm.WaitForAllThreads()
+	}()
+	select {
+	case e = <-waitResult:
+	case <-time.After(time.Second):
+		t.Logf("WaitForAllThreads waited for a daemon thread\n")
+		t.FailNow()
+	}
+	if e != nil {
+		t.Logf("Main thread failed: %s\n", e)
+		t.FailNow()
+	}
+	if daemon.HasExited() {
+		t.Logf("The daemon thread exited before being interrupted\n")
+		t.FailNow()
+	}
+
+	// Interrupting the daemon should cause it to exit.
+	daemon.Interrupt()
+	select {
+	case <-daemon.Done():
+	case <-time.After(time.Second):
+		t.Logf("The daemon thread didn't exit after being interrupted\n")
+		t.FailNow()
+	}
+	if !daemon.HasExited() {
+		t.Logf("HasExited returned false after the daemon exited\n")
+		t.Fail()
+	}
+}
+
+func TestThreadRename(t *testing.T) {
+	thread := &Thread{Name: "before"}
+	done := make(chan bool)
+	// Run under the race detector, this checks that renaming a thread doesn't
+	// race with reading its name elsewhere, e.g. in stack traces.
+	go func() {
+		for i := 0; i < 100; i++ {
+			thread.GetName()
+		}
+		close(done)
+	}()
+	thread.SetName("after")
+	<-done
+	if thread.GetName() != "after" {
+		t.Logf("Expected the thread to be named after, got %s\n",
+			thread.GetName())
+		t.Fail()
+	}
+}
+
+func TestConcurrentOptimize(t *testing.T) {
+	jvm := NewJVM()
+	b := builder.NewClassBuilder("Racer", "java/lang/Object")
+	// Counts to 100 in a loop, so that optimizing the method must resolve
+	// branch targets.
+	m := b.AddMethod(0x0009, "count", "()I")
+	loop := m.NewLabel()
+	done := m.NewLabel()
+	m.EmitInt(0)
+	m.EmitLocal(builder.Istore, 0)
+	m.PlaceLabel(loop)
+	m.EmitLocal(builder.Iload, 0)
+	m.EmitInt(100)
+	m.EmitBranch(builder.If_icmpge, done)
+	m.EmitIinc(0, 1)
+	m.EmitBranch(builder.Goto, loop)
+	m.PlaceLabel(done)
+	m.EmitLocal(builder.Iload, 0)
+	m.Emit(builder.Ireturn)
+	// Throws null, failing the thread, if count() returns the wrong value.
+	m = b.AddMethod(0x0009, "run", "()V")
+	correct := m.NewLabel()
+	m.EmitInvoke(builder.Invokestatic, "Racer", "count", "()I")
+	m.EmitInt(100)
+	m.EmitBranch(builder.If_icmpeq, correct)
+	m.Emit(builder.Aconst_null)
+	m.Emit(builder.Athrow)
+	m.PlaceLabel(correct)
+	m.Emit(builder.Return)
+	loadBuiltTestClass(t, jvm, b)
+
+	// None of the threads have called count() yet, so they all race to
+	// optimize it.
+	for i := 0; i < 8; i++ {
+		_, e := jvm.StartNamedThread("Racer", "void run()", "racer")
+		if e != nil {
+			t.Logf("Failed starting thread %d: %s\n", i, e)
+			t.FailNow()
+		}
+	}
+	e := jvm.WaitForAllThreads()
+	if e != nil {
+		t.Logf("A thread failed: %s\n", e)
+		t.FailNow()
+	}
+}
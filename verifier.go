@@ -0,0 +1,1791 @@
+package bs_jvm
+
+// This file contains the bytecode verifier, which checks the types of the
+// values used by every instruction in a method before the method is run. It
+// implements verification by type checking, using the frames in the method's
+// StackMapTable attribute, as described in section 4.10.1 of the JVM spec.
+
+import (
+	"fmt"
+	"github.com/yalue/bs_jvm/class_file"
+	"strings"
+)
+
+// The tags of the types tracked by the verifier. These are the same as the
+// tags used in StackMapTable attributes.
+const (
+	verifyTop class_file.VerificationTypeInfoTag = iota
+	verifyInt
+	verifyFloat
+	verifyDouble
+	verifyLong
+	verifyNull
+	verifyUninitializedThis
+	verifyObject
+	verifyUninitialized
+)
+
+// The type of a local variable or operand stack entry, as tracked by the
+// verifier.
+type verificationType struct {
+	tag class_file.VerificationTypeInfoTag
+	// The class or array type of the reference, if tag is verifyObject.
+	reference class_file.FieldType
+	// The offset of the new instruction that created the object, if tag is
+	// verifyUninitialized.
+	newOffset uint
+}
+
+var (
+	topType               = verificationType{tag: verifyTop}
+	intType               = verificationType{tag: verifyInt}
+	floatType             = verificationType{tag: verifyFloat}
+	longType              = verificationType{tag: verifyLong}
+	doubleType            = verificationType{tag: verifyDouble}
+	nullType              = verificationType{tag: verifyNull}
+	uninitializedThisType = verificationType{tag: verifyUninitializedThis}
+)
+
+// Returns the verification type for a reference to the given class or array
+// type.
+func objectType(t class_file.FieldType) verificationType {
+	return verificationType{
+		tag:       verifyObject,
+		reference: t,
+	}
+}
+
+// Returns the verification type used for a value of the given field type.
+// Booleans, bytes, chars, and shorts are all treated as ints.
+func fieldVerificationType(t class_file.FieldType) verificationType {
+	p, isPrimitive := t.(class_file.PrimitiveFieldType)
+	if !isPrimitive {
+		return objectType(t)
+	}
+	switch p {
+	case 'J':
+		return longType
+	case 'F':
+		return floatType
+	case 'D':
+		return doubleType
+	}
+	return intType
+}
+
+// Converts the name in a class constant to a field type. The names of array
+// classes are descriptors, e.g. "[I".
+func classNameToFieldType(name []byte) (class_file.FieldType, error) {
+	if (len(name) != 0) && (name[0] == '[') {
+		return class_file.ParseFieldType(name)
+	}
+	return class_file.ClassInstanceType(name), nil
+}
+
+// Returns true if values of this type occupy two local variable or operand
+// stack slots.
+func (v verificationType) isCategory2() bool {
+	return (v.tag == verifyLong) || (v.tag == verifyDouble)
+}
+
+// Returns true if this is any kind of reference, including null and
+// uninitialized objects.
+func (v verificationType) isReference() bool {
+	switch v.tag {
+	case verifyNull, verifyUninitializedThis, verifyObject,
+		verifyUninitialized:
+		return true
+	}
+	return false
+}
+
+// Returns true if the two types are identical.
+func (v verificationType) equals(other verificationType) bool {
+	if v.tag != other.tag {
+		return false
+	}
+	switch v.tag {
+	case verifyObject:
+		return class_file.FieldTypeDescriptor(v.reference) ==
+			class_file.FieldTypeDescriptor(other.reference)
+	case verifyUninitialized:
+		return v.newOffset == other.newOffset
+	}
+	return true
+}
+
+func (v verificationType) String() string {
+	switch v.tag {
+	case verifyTop:
+		return "top"
+	case verifyInt:
+		return "int"
+	case verifyFloat:
+		return "float"
+	case verifyLong:
+		return "long"
+	case verifyDouble:
+		return "double"
+	case verifyNull:
+		return "null"
+	case verifyUninitializedThis:
+		return "uninitializedThis"
+	case verifyObject:
+		if _, isArray := v.reference.(*class_file.ArrayType); isArray {
+			return class_file.FieldTypeDescriptor(v.reference)
+		}
+		return v.reference.String()
+	case verifyUninitialized:
+		return fmt.Sprintf("uninitialized(%d)", v.newOffset)
+	}
+	return v.tag.String()
+}
+
+// The types of the local variables and operand stack at some point in a
+// method.
+type verifierFrame struct {
+	// Contains one entry per local variable slot. Longs and doubles occupy
+	// two slots, the second of which is top.
+	locals []verificationType
+	// Contains one entry per value on the operand stack, including longs and
+	// doubles.
+	stack []verificationType
+}
+
+func (f *verifierFrame) copy() *verifierFrame {
+	return &verifierFrame{
+		locals: append([]verificationType{}, f.locals...),
+		stack:  append([]verificationType{}, f.stack...),
+	}
+}
+
+// Returns the number of slots used by the operand stack.
+func (f *verifierFrame) stackSize() int {
+	toReturn := 0
+	for _, t := range f.stack {
+		toReturn++
+		if t.isCategory2() {
+			toReturn++
+		}
+	}
+	return toReturn
+}
+
+// Returns true if one of the local variables is uninitializedThis, meaning
+// that the method is a constructor that hasn't called its superclass'
+// constructor yet.
+func (f *verifierFrame) thisUninitialized() bool {
+	for _, t := range f.locals {
+		if t.tag == verifyUninitializedThis {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces every occurrence of the old type in the frame with the new type.
+// Used when an object is initialized, or overwritten.
+func (f *verifierFrame) replaceType(old, new verificationType) {
+	for i := range f.locals {
+		if f.locals[i].equals(old) {
+			f.locals[i] = new
+		}
+	}
+	for i := range f.stack {
+		if f.stack[i].equals(old) {
+			f.stack[i] = new
+		}
+	}
+}
+
+// Holds the state of the verifier while it checks a single method.
+type verifier struct {
+	m    *Method
+	file *class_file.Class
+	code []byte
+	// The offsets of every instruction in the method, in order.
+	offsets []uint
+	// Contains true for the offset of every instruction in the method.
+	isInstruction map[uint]bool
+	// Maps instruction offsets to the stack map frames at those offsets.
+	frames map[uint]*verifierFrame
+	// The types before the current instruction, which are updated as the
+	// instruction is checked.
+	current *verifierFrame
+	// The offset of the instruction being checked.
+	offset uint
+}
+
+// Returns a VerifyError naming the method and the current offset.
+func (v *verifier) errorf(format string, args ...interface{}) error {
+	m := v.m
+	return VerifyError(fmt.Sprintf("%s.%s%s at offset %d: %s",
+		m.ContainingClass.Name, m.Name, m.Types.Descriptor(),
+		v.offset, fmt.Sprintf(format, args...)))
+}
+
+// Returns true if a value of the given reference type can be assigned to a
+// variable of the other type, following the isJavaAssignable rules in the
+// JVM spec. Like the spec, this treats interfaces like java/lang/Object. The
+// check also succeeds if one of the classes isn't available, since the class
+// will need to be loaded before it can actually be used.
+func (v *verifier) isJavaAssignable(from, to class_file.FieldType) bool {
+	j := v.m.ContainingClass.ParentJVM
+	switch t := to.(type) {
+	case class_file.ClassInstanceType:
+		if t == "java/lang/Object" {
+			return true
+		}
+		switch f := from.(type) {
+		case *class_file.ArrayType:
+			return (t == "java/lang/Cloneable") || (t == "java/io/Serializable")
+		case class_file.ClassInstanceType:
+			if (f == t) || (j == nil) {
+				return true
+			}
+			toClass, e := j.GetOrLoadClass(string(t))
+			if (e != nil) || toClass.IsInterface() {
+				return true
+			}
+			fromClass, e := j.GetOrLoadClass(string(f))
+			if e != nil {
+				return true
+			}
+			return fromClass.IsSubclassOf(toClass)
+		}
+		return false
+	case *class_file.ArrayType:
+		f, ok := from.(*class_file.ArrayType)
+		if !ok {
+			return false
+		}
+		fromComponent := ComponentType(f)
+		toComponent := ComponentType(t)
+		_, fromIsPrimitive := fromComponent.(class_file.PrimitiveFieldType)
+		_, toIsPrimitive := toComponent.(class_file.PrimitiveFieldType)
+		if fromIsPrimitive || toIsPrimitive {
+			return fromComponent == toComponent
+		}
+		return v.isJavaAssignable(fromComponent, toComponent)
+	}
+	return false
+}
+
+// Returns true if a value of the from type can be used where a value of the
+// to type is expected.
+func (v *verifier) isAssignable(from, to verificationType) bool {
+	if to.tag == verifyTop {
+		return true
+	}
+	switch from.tag {
+	case verifyNull:
+		return (to.tag == verifyNull) || (to.tag == verifyObject)
+	case verifyObject:
+		return (to.tag == verifyObject) &&
+			v.isJavaAssignable(from.reference, to.reference)
+	}
+	return from.equals(to)
+}
+
+// Returns nil if the types in the from frame can be used where the to frame
+// is expected, e.g. when branching to an instruction with a stack map frame.
+// The target is the offset of the to frame, and is used in error messages.
+func (v *verifier) checkFrameAssignable(from, to *verifierFrame,
+	target uint) error {
+	if len(from.stack) != len(to.stack) {
+		return v.errorf("The stack map frame at offset %d expects %d values "+
+			"on the operand stack, but got %d", target, len(to.stack),
+			len(from.stack))
+	}
+	for i := range from.stack {
+		if !v.isAssignable(from.stack[i], to.stack[i]) {
+			return v.errorf("The stack map frame at offset %d expects %s at "+
+				"operand stack index %d, but got %s", target, to.stack[i], i,
+				from.stack[i])
+		}
+	}
+	for i := range from.locals {
+		if !v.isAssignable(from.locals[i], to.locals[i]) {
+			return v.errorf("The stack map frame at offset %d expects %s in "+
+				"local variable %d, but got %s", target, to.locals[i], i,
+				from.locals[i])
+		}
+	}
+	if from.thisUninitialized() && !to.thisUninitialized() {
+		return v.errorf("The stack map frame at offset %d expects this to "+
+			"be initialized", target)
+	}
+	return nil
+}
+
+// Checks that the current types can be used at the given branch target.
+func (v *verifier) checkBranch(target int64) error {
+	if (target < 0) || !v.isInstruction[uint(target)] {
+		return v.errorf("Invalid branch target %d", target)
+	}
+	frame := v.frames[uint(target)]
+	if frame == nil {
+		return v.errorf("Branch target %d has no stack map frame", target)
+	}
+	return v.checkFrameAssignable(v.current, frame, uint(target))
+}
+
+// Reads an unsigned byte following the current instruction's opcode.
+func (v *verifier) u1(argOffset uint) uint8 {
+	return v.code[v.offset+argOffset]
+}
+
+// Reads an unsigned 16-bit value following the current instruction's
+// opcode.
+func (v *verifier) u2(argOffset uint) uint16 {
+	return uint16(v.u1(argOffset))<<8 | uint16(v.u1(argOffset+1))
+}
+
+// Reads a signed 32-bit value at the given offset in the code.
+func (v *verifier) s4At(offset uint) int32 {
+	c := v.code
+	return int32(uint32(c[offset])<<24 | uint32(c[offset+1])<<16 |
+		uint32(c[offset+2])<<8 | uint32(c[offset+3]))
+}
+
+// Returns the absolute offset of a branch target, given its offset relative
+// to the current instruction.
+func (v *verifier) branchTarget(relative int32) int64 {
+	return int64(v.offset) + int64(relative)
+}
+
+// Pushes a value onto the operand stack, checking that the stack doesn't
+// grow beyond max_stack.
+func (v *verifier) push(t verificationType) error {
+	v.current.stack = append(v.current.stack, t)
+	if v.current.stackSize() > v.m.MaxStack {
+		return v.errorf("The operand stack exceeds max_stack (%d)",
+			v.m.MaxStack)
+	}
+	return nil
+}
+
+// Pushes the given values onto the operand stack, in order.
+func (v *verifier) pushAll(types ...verificationType) error {
+	for _, t := range types {
+		e := v.push(t)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Pops any value from the operand stack.
+func (v *verifier) popAny() (verificationType, error) {
+	stack := v.current.stack
+	if len(stack) == 0 {
+		return topType, v.errorf("Operand stack underflow")
+	}
+	v.current.stack = stack[:len(stack)-1]
+	return stack[len(stack)-1], nil
+}
+
+// Pops a value that occupies a single operand stack slot.
+func (v *verifier) popCategory1() (verificationType, error) {
+	toReturn, e := v.popAny()
+	if e != nil {
+		return toReturn, e
+	}
+	if toReturn.isCategory2() {
+		return toReturn, v.errorf("Expected a single-slot value on the "+
+			"operand stack, but got %s", toReturn)
+	}
+	return toReturn, nil
+}
+
+// Pops a value from the operand stack, which must be assignable to the
+// expected type. Returns the type that was actually popped.
+func (v *verifier) pop(expected verificationType) (verificationType, error) {
+	toReturn, e := v.popAny()
+	if e != nil {
+		return toReturn, e
+	}
+	if !v.isAssignable(toReturn, expected) {
+		return toReturn, v.errorf("Expected %s on the operand stack, but got "+
+			"%s", expected, toReturn)
+	}
+	return toReturn, nil
+}
+
+// Pops values of the given types from the operand stack. The types are given
+// in the order they were pushed.
+func (v *verifier) popAll(types ...verificationType) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		_, e := v.pop(types[i])
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Pops any reference from the operand stack, including uninitialized ones.
+func (v *verifier) popReference() (verificationType, error) {
+	toReturn, e := v.popAny()
+	if e != nil {
+		return toReturn, e
+	}
+	if !toReturn.isReference() {
+		return toReturn, v.errorf("Expected a reference on the operand stack, "+
+			"but got %s", toReturn)
+	}
+	return toReturn, nil
+}
+
+// Pops an array reference, or null, from the operand stack.
+func (v *verifier) popArray() (verificationType, error) {
+	toReturn, e := v.popAny()
+	if e != nil {
+		return toReturn, e
+	}
+	if toReturn.tag == verifyNull {
+		return toReturn, nil
+	}
+	if toReturn.tag == verifyObject {
+		if _, isArray := toReturn.reference.(*class_file.ArrayType); isArray {
+			return toReturn, nil
+		}
+	}
+	return toReturn, v.errorf("Expected an array on the operand stack, but "+
+		"got %s", toReturn)
+}
+
+// Checks that the given local variable index is valid for a value of the
+// given type.
+func (v *verifier) checkLocalIndex(index int, t verificationType) error {
+	last := index
+	if t.isCategory2() {
+		last++
+	}
+	if last >= len(v.current.locals) {
+		return v.errorf("Invalid local variable index %d (max_locals is %d)",
+			index, len(v.current.locals))
+	}
+	return nil
+}
+
+// Returns the type of the given local variable, which must be assignable to
+// the expected type.
+func (v *verifier) loadLocal(index int,
+	expected verificationType) (verificationType, error) {
+	e := v.checkLocalIndex(index, expected)
+	if e != nil {
+		return topType, e
+	}
+	toReturn := v.current.locals[index]
+	if !v.isAssignable(toReturn, expected) {
+		return toReturn, v.errorf("Expected %s in local variable %d, but got "+
+			"%s", expected, index, toReturn)
+	}
+	return toReturn, nil
+}
+
+// Sets the type of the given local variable. If this overwrites half of a
+// long or double, the other half becomes unusable.
+func (v *verifier) storeLocal(index int, t verificationType) error {
+	e := v.checkLocalIndex(index, t)
+	if e != nil {
+		return e
+	}
+	locals := v.current.locals
+	if (index > 0) && locals[index-1].isCategory2() {
+		locals[index-1] = topType
+	}
+	locals[index] = t
+	if t.isCategory2() {
+		locals[index+1] = topType
+	}
+	return nil
+}
+
+// The types used by the load and store instructions, in the same order as
+// their opcodes. A nil entry is a reference.
+var loadStoreTypes = []*verificationType{
+	&intType, &longType, &floatType, &doubleType, nil,
+}
+
+// Checks a load instruction that loads a value of the given kind (an index
+// into loadStoreTypes) from the given local variable.
+func (v *verifier) verifyLoad(kind int, index int) error {
+	expected := loadStoreTypes[kind]
+	if expected != nil {
+		_, e := v.loadLocal(index, *expected)
+		if e != nil {
+			return e
+		}
+		return v.push(*expected)
+	}
+	e := v.checkLocalIndex(index, topType)
+	if e != nil {
+		return e
+	}
+	t := v.current.locals[index]
+	if !t.isReference() {
+		return v.errorf("Expected a reference in local variable %d, but got "+
+			"%s", index, t)
+	}
+	return v.push(t)
+}
+
+// Like verifyLoad, but for store instructions.
+func (v *verifier) verifyStore(kind int, index int) error {
+	expected := loadStoreTypes[kind]
+	var t verificationType
+	var e error
+	if expected != nil {
+		t, e = v.pop(*expected)
+	} else {
+		t, e = v.popReference()
+	}
+	if e != nil {
+		return e
+	}
+	return v.storeLocal(index, t)
+}
+
+// The component types of the arrays used by the array load and store
+// instructions, in the same order as their opcodes. 'L' is any reference.
+const arrayInstructionTypes = "IJFDLBCS"
+
+// Checks an array load or store instruction. The kind is the instruction's
+// index into arrayInstructionTypes.
+func (v *verifier) verifyArrayAccess(kind int, isStore bool) error {
+	componentType := class_file.PrimitiveFieldType(arrayInstructionTypes[kind])
+	var value verificationType
+	var e error
+	if isStore {
+		if componentType == 'L' {
+			value, e = v.popReference()
+		} else {
+			value, e = v.pop(fieldVerificationType(componentType))
+		}
+		if e != nil {
+			return e
+		}
+		if value.tag == verifyUninitialized ||
+			value.tag == verifyUninitializedThis {
+			return v.errorf("Can't store an uninitialized object in an array")
+		}
+	}
+	_, e = v.pop(intType)
+	if e != nil {
+		return e
+	}
+	array, e := v.popArray()
+	if e != nil {
+		return e
+	}
+	var component class_file.FieldType
+	if array.tag == verifyObject {
+		component = ComponentType(array.reference.(*class_file.ArrayType))
+		p, isPrimitive := component.(class_file.PrimitiveFieldType)
+		var ok bool
+		switch componentType {
+		case 'L':
+			ok = !isPrimitive
+		case 'B':
+			// baload and bastore are used for both byte and boolean arrays.
+			ok = isPrimitive && ((p == 'B') || (p == 'Z'))
+		default:
+			ok = isPrimitive && (p == componentType)
+		}
+		if !ok {
+			return v.errorf("Can't use %s with an array of %s",
+				opcodeTable[v.code[v.offset]].name, component)
+		}
+	}
+	if isStore {
+		return nil
+	}
+	if componentType != 'L' {
+		return v.push(fieldVerificationType(componentType))
+	}
+	if component == nil {
+		// Loading from a null array will throw an exception, so the value's
+		// type doesn't matter.
+		return v.push(nullType)
+	}
+	return v.push(objectType(component))
+}
+
+// Returns the field type named by the class constant at the given index.
+func (v *verifier) getClassConstant(index uint16) (class_file.FieldType,
+	error) {
+	name, e := v.file.GetClassConstantName(index)
+	if e != nil {
+		return nil, v.errorf("Invalid class constant %d: %s", index, e)
+	}
+	toReturn, e := classNameToFieldType(name)
+	if e != nil {
+		return nil, v.errorf("Invalid class name %s: %s", name, e)
+	}
+	return toReturn, nil
+}
+
+// Returns the name and descriptor from the NameAndType constant at the given
+// index.
+func (v *verifier) getNameAndType(index uint16) (string, []byte, error) {
+	constant, e := v.file.GetConstant(index)
+	if e != nil {
+		return "", nil, v.errorf("Invalid constant index %d: %s", index, e)
+	}
+	nameAndType, ok := constant.(*class_file.ConstantNameAndTypeInfo)
+	if !ok {
+		return "", nil, v.errorf("Constant %d isn't a NameAndType", index)
+	}
+	name, e := v.file.GetUTF8Constant(nameAndType.NameIndex)
+	if e != nil {
+		return "", nil, v.errorf("Invalid name: %s", e)
+	}
+	descriptor, e := v.file.GetUTF8Constant(nameAndType.DescriptorIndex)
+	if e != nil {
+		return "", nil, v.errorf("Invalid descriptor: %s", e)
+	}
+	return string(name), descriptor, nil
+}
+
+// Holds the information about a field or method needed by the verifier,
+// obtained from a Fieldref, Methodref, or InterfaceMethodref constant.
+type verifierMemberReference struct {
+	class      class_file.FieldType
+	name       string
+	descriptor []byte
+	// The tag of the constant the information was obtained from.
+	tag class_file.ConstantTag
+}
+
+// Returns the field or method referred to by the constant at the given index.
+func (v *verifier) getMemberReference(
+	index uint16) (*verifierMemberReference, error) {
+	constant, e := v.file.GetConstant(index)
+	if e != nil {
+		return nil, v.errorf("Invalid constant index %d: %s", index, e)
+	}
+	var classIndex, nameAndTypeIndex uint16
+	switch c := constant.(type) {
+	case *class_file.ConstantFieldInfo:
+		classIndex, nameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+	case *class_file.ConstantMethodInfo:
+		classIndex, nameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+	case *class_file.ConstantInterfaceMethodInfo:
+		classIndex, nameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+	default:
+		return nil, v.errorf("Constant %d isn't a field or method reference",
+			index)
+	}
+	class, e := v.getClassConstant(classIndex)
+	if e != nil {
+		return nil, e
+	}
+	name, descriptor, e := v.getNameAndType(nameAndTypeIndex)
+	if e != nil {
+		return nil, e
+	}
+	return &verifierMemberReference{
+		class:      class,
+		name:       name,
+		descriptor: descriptor,
+		tag:        constant.Tag(),
+	}, nil
+}
+
+// Checks an ldc, ldc_w, or ldc2_w instruction loading the given constant.
+func (v *verifier) verifyLdc(index uint16, wide bool) error {
+	constant, e := v.file.GetConstant(index)
+	if e != nil {
+		return v.errorf("Invalid constant index %d: %s", index, e)
+	}
+	var t verificationType
+	switch c := constant.(type) {
+	case *class_file.ConstantIntegerInfo:
+		t = intType
+	case *class_file.ConstantFloatInfo:
+		t = floatType
+	case *class_file.ConstantLongInfo:
+		t = longType
+	case *class_file.ConstantDoubleInfo:
+		t = doubleType
+	case *class_file.ConstantStringInfo:
+		t = objectType(class_file.ClassInstanceType("java/lang/String"))
+	case *class_file.ConstantClassInfo:
+		t = objectType(class_file.ClassInstanceType("java/lang/Class"))
+	case *class_file.ConstantMethodTypeInfo:
+		t = objectType(class_file.ClassInstanceType(
+			"java/lang/invoke/MethodType"))
+	case *class_file.ConstantMethodHandleInfo:
+		t = objectType(class_file.ClassInstanceType(
+			"java/lang/invoke/MethodHandle"))
+	case *class_file.ConstantDynamicInfo:
+		_, descriptor, e := v.getNameAndType(c.NameAndTypeIndex)
+		if e != nil {
+			return e
+		}
+		fieldType, e := class_file.ParseFieldType(descriptor)
+		if e != nil {
+			return v.errorf("Invalid dynamic constant type %s: %s",
+				descriptor, e)
+		}
+		t = fieldVerificationType(fieldType)
+	default:
+		return v.errorf("Can't load constant %d (%s)", index, constant)
+	}
+	if t.isCategory2() != wide {
+		return v.errorf("Can't load constant %d (%s) using %s", index,
+			constant, opcodeTable[v.code[v.offset]].name)
+	}
+	return v.push(t)
+}
+
+// Checks a getstatic, putstatic, getfield, or putfield instruction.
+func (v *verifier) verifyFieldAccess(opcode uint8) error {
+	field, e := v.getMemberReference(v.u2(1))
+	if e != nil {
+		return e
+	}
+	if field.tag != 9 {
+		return v.errorf("Expected a Fieldref constant")
+	}
+	fieldType, e := class_file.ParseFieldType(field.descriptor)
+	if e != nil {
+		return v.errorf("Invalid descriptor for field %s: %s", field.name, e)
+	}
+	t := fieldVerificationType(fieldType)
+	switch opcode {
+	case 0xb2:
+		return v.push(t)
+	case 0xb3:
+		_, e = v.pop(t)
+		return e
+	case 0xb4:
+		_, e = v.pop(objectType(field.class))
+		if e != nil {
+			return e
+		}
+		return v.push(t)
+	}
+	_, e = v.pop(t)
+	if e != nil {
+		return e
+	}
+	receiver, e := v.popReference()
+	if e != nil {
+		return e
+	}
+	// Constructors may set fields declared in their own class before calling
+	// the superclass constructor.
+	thisName := string(v.m.ContainingClass.Name)
+	if (receiver.tag == verifyUninitializedThis) &&
+		(field.class.String() == thisName) {
+		return nil
+	}
+	if !v.isAssignable(receiver, objectType(field.class)) {
+		return v.errorf("Can't set field %s.%s on %s", field.class,
+			field.name, receiver)
+	}
+	return nil
+}
+
+// Returns the type of the object that will be initialized by calling <init>
+// on the given uninitialized object.
+func (v *verifier) getInitializedType(
+	receiver verificationType) (verificationType, error) {
+	if receiver.tag == verifyUninitializedThis {
+		return objectType(class_file.ClassInstanceType(
+			v.m.ContainingClass.Name)), nil
+	}
+	t, e := v.getClassConstant(uint16(v.code[receiver.newOffset+1])<<8 |
+		uint16(v.code[receiver.newOffset+2]))
+	if e != nil {
+		return topType, e
+	}
+	return objectType(t), nil
+}
+
+// Checks a call to an instance initialization method. The receiver must be
+// an uninitialized object of the class containing the <init> method, or, in
+// a constructor, uninitializedThis may be initialized by calling the
+// superclass' constructor.
+func (v *verifier) verifyInitCall(method *verifierMemberReference) error {
+	receiver, e := v.popAny()
+	if e != nil {
+		return e
+	}
+	if (receiver.tag != verifyUninitialized) &&
+		(receiver.tag != verifyUninitializedThis) {
+		return v.errorf("Can't call %s.<init> on %s, which is already "+
+			"initialized", method.class, receiver)
+	}
+	initialized, e := v.getInitializedType(receiver)
+	if e != nil {
+		return e
+	}
+	className := method.class.String()
+	valid := className == initialized.reference.String()
+	if !valid && (receiver.tag == verifyUninitializedThis) {
+		superName, e := v.file.GetSuperClassName()
+		valid = (e == nil) && (string(superName) == className)
+	}
+	if !valid {
+		return v.errorf("Can't initialize %s using %s.<init>", initialized,
+			className)
+	}
+	v.current.replaceType(receiver, initialized)
+	return nil
+}
+
+// Checks an invokevirtual, invokespecial, invokestatic, invokeinterface, or
+// invokedynamic instruction.
+func (v *verifier) verifyInvoke(opcode uint8) error {
+	var method *verifierMemberReference
+	var e error
+	if opcode == 0xba {
+		method, e = v.getInvokeDynamicReference()
+	} else {
+		method, e = v.getMemberReference(v.u2(1))
+	}
+	if e != nil {
+		return e
+	}
+	switch {
+	case (opcode == 0xb6) && (method.tag != 10):
+		return v.errorf("invokevirtual requires a Methodref constant")
+	case (opcode == 0xb9) && (method.tag != 11):
+		return v.errorf("invokeinterface requires an InterfaceMethodref " +
+			"constant")
+	case method.tag == 9:
+		return v.errorf("Can't invoke field %s", method.name)
+	}
+	isInit := method.name == "<init>"
+	if strings.HasPrefix(method.name, "<") && (!isInit || (opcode != 0xb7)) {
+		return v.errorf("Can't call %s using %s", method.name,
+			opcodeTable[opcode].name)
+	}
+	descriptor, e := class_file.ParseMethodDescriptor(method.descriptor)
+	if e != nil {
+		return v.errorf("Invalid descriptor for method %s: %s", method.name, e)
+	}
+	args := make([]verificationType, len(descriptor.ArgumentTypes))
+	argSlots := 0
+	for i, t := range descriptor.ArgumentTypes {
+		args[i] = fieldVerificationType(t)
+		argSlots++
+		if args[i].isCategory2() {
+			argSlots++
+		}
+	}
+	e = v.popAll(args...)
+	if e != nil {
+		return e
+	}
+	if opcode == 0xb9 {
+		if (int(v.u1(3)) != (argSlots + 1)) || (v.u1(4) != 0) {
+			return v.errorf("Invalid invokeinterface count for %s",
+				method.name)
+		}
+	}
+	if (opcode == 0xba) && (v.u2(3) != 0) {
+		return v.errorf("The last two bytes of invokedynamic must be 0")
+	}
+	if isInit {
+		e = v.verifyInitCall(method)
+	} else if (opcode != 0xb8) && (opcode != 0xba) {
+		_, e = v.pop(objectType(method.class))
+	}
+	if e != nil {
+		return e
+	}
+	if descriptor.ReturnType.String() == "void" {
+		return nil
+	}
+	return v.push(fieldVerificationType(descriptor.ReturnType))
+}
+
+// Returns the name and descriptor of the call site used by the current
+// invokedynamic instruction, as a verifierMemberReference without a class.
+func (v *verifier) getInvokeDynamicReference() (*verifierMemberReference,
+	error) {
+	index := v.u2(1)
+	constant, e := v.file.GetConstant(index)
+	if e != nil {
+		return nil, v.errorf("Invalid constant index %d: %s", index, e)
+	}
+	info, ok := constant.(*class_file.ConstantInvokeDynamicInfo)
+	if !ok {
+		return nil, v.errorf("invokedynamic requires an InvokeDynamic " +
+			"constant")
+	}
+	name, descriptor, e := v.getNameAndType(info.NameAndTypeIndex)
+	if e != nil {
+		return nil, e
+	}
+	return &verifierMemberReference{
+		name:       name,
+		descriptor: descriptor,
+		tag:        constant.Tag(),
+	}, nil
+}
+
+// Checks a return instruction. The expected type is the type of value
+// returned by the instruction, or nil for the return instruction.
+func (v *verifier) verifyReturn(expected *verificationType) error {
+	returnType := v.m.Types.ReturnType
+	if expected == nil {
+		if returnType.String() != "void" {
+			return v.errorf("Can't use return in a method returning %s",
+				returnType)
+		}
+		if v.current.thisUninitialized() {
+			return v.errorf("The constructor returns without calling " +
+				"another constructor")
+		}
+		return nil
+	}
+	if returnType.String() == "void" {
+		return v.errorf("Can't return a value from a void method")
+	}
+	required := fieldVerificationType(returnType)
+	if expected.tag == verifyObject {
+		// This is areturn, so check the value against the actual return
+		// type.
+		expected = &required
+	} else if !expected.equals(required) {
+		return v.errorf("Can't use %s in a method returning %s",
+			opcodeTable[v.code[v.offset]].name, returnType)
+	}
+	_, e := v.pop(*expected)
+	return e
+}
+
+// Checks the new instruction, which pushes an uninitialized object.
+func (v *verifier) verifyNew() error {
+	class, e := v.getClassConstant(v.u2(1))
+	if e != nil {
+		return e
+	}
+	if _, isArray := class.(*class_file.ArrayType); isArray {
+		return v.errorf("Can't create an array using new")
+	}
+	t := verificationType{
+		tag:       verifyUninitialized,
+		newOffset: v.offset,
+	}
+	for _, s := range v.current.stack {
+		if s.equals(t) {
+			return v.errorf("The object created by this instruction is " +
+				"already on the operand stack")
+		}
+	}
+	v.current.replaceType(t, topType)
+	return v.push(t)
+}
+
+// Returns the type of an array containing elements of the given type.
+func (v *verifier) getArrayOf(t class_file.FieldType) (verificationType,
+	error) {
+	dimensions := 1
+	content := t
+	if a, isArray := t.(*class_file.ArrayType); isArray {
+		dimensions += int(a.Dimensions)
+		content = a.ContentType
+	}
+	if dimensions > 255 {
+		return topType, v.errorf("Arrays can't have more than 255 dimensions")
+	}
+	return objectType(&class_file.ArrayType{
+		Dimensions:  uint8(dimensions),
+		ContentType: content,
+	}), nil
+}
+
+// The element types of the arrays created by newarray, indexed by the
+// instruction's atype operand.
+var newarrayTypes = map[uint8]class_file.PrimitiveFieldType{
+	4:  'Z',
+	5:  'C',
+	6:  'F',
+	7:  'D',
+	8:  'B',
+	9:  'S',
+	10: 'I',
+	11: 'J',
+}
+
+// Checks the instructions that create arrays.
+func (v *verifier) verifyNewArray(opcode uint8) error {
+	var arrayType verificationType
+	var e error
+	dimensions := 1
+	switch opcode {
+	case 0xbc:
+		elementType, ok := newarrayTypes[v.u1(1)]
+		if !ok {
+			return v.errorf("Invalid newarray type: %d", v.u1(1))
+		}
+		arrayType, e = v.getArrayOf(elementType)
+	case 0xbd:
+		var elementType class_file.FieldType
+		elementType, e = v.getClassConstant(v.u2(1))
+		if e != nil {
+			return e
+		}
+		arrayType, e = v.getArrayOf(elementType)
+	default:
+		var t class_file.FieldType
+		t, e = v.getClassConstant(v.u2(1))
+		if e != nil {
+			return e
+		}
+		dimensions = int(v.u1(3))
+		a, isArray := t.(*class_file.ArrayType)
+		if !isArray || (dimensions == 0) ||
+			(int(a.Dimensions) < dimensions) {
+			return v.errorf("Can't create %d dimensions of %s", dimensions,
+				t)
+		}
+		arrayType = objectType(t)
+	}
+	if e != nil {
+		return e
+	}
+	for i := 0; i < dimensions; i++ {
+		_, e = v.pop(intType)
+		if e != nil {
+			return e
+		}
+	}
+	return v.push(arrayType)
+}
+
+// Checks the tableswitch and lookupswitch instructions.
+func (v *verifier) verifySwitch(opcode uint8) error {
+	_, e := v.pop(intType)
+	if e != nil {
+		return e
+	}
+	// The instruction's operands are aligned to a multiple of 4 bytes.
+	base := (v.offset + 4) &^ 3
+	targets := []int64{v.branchTarget(v.s4At(base))}
+	if opcode == 0xaa {
+		low := v.s4At(base + 4)
+		high := v.s4At(base + 8)
+		for i := int64(0); i <= (int64(high) - int64(low)); i++ {
+			relative := v.s4At(base + 12 + uint(i)*4)
+			targets = append(targets, v.branchTarget(relative))
+		}
+	} else {
+		pairCount := v.s4At(base + 4)
+		previous := int64(0)
+		for i := int64(0); i < int64(pairCount); i++ {
+			pairOffset := base + 8 + uint(i)*8
+			match := int64(v.s4At(pairOffset))
+			if (i > 0) && (match <= previous) {
+				return v.errorf("lookupswitch values must be sorted")
+			}
+			previous = match
+			targets = append(targets,
+				v.branchTarget(v.s4At(pairOffset+4)))
+		}
+	}
+	for _, target := range targets {
+		e = v.checkBranch(target)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Checks the instructions that duplicate or reorder values on the operand
+// stack: dup_x1, dup_x2, dup2, dup2_x1, dup2_x2, and swap.
+func (v *verifier) verifyStackManipulation(opcode uint8) error {
+	v1, e := v.popAny()
+	if e != nil {
+		return e
+	}
+	// Only the dup2 instructions can operate on a long or double on top of
+	// the stack.
+	isDup2 := (opcode >= 0x5c) && (opcode <= 0x5e)
+	if v1.isCategory2() && !isDup2 {
+		return v.errorf("Can't use %s with a %s on top of the stack",
+			opcodeTable[opcode].name, v1)
+	}
+	switch opcode {
+	case 0x5a:
+		v2, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		return v.pushAll(v1, v2, v1)
+	case 0x5b:
+		v2, e := v.popAny()
+		if e != nil {
+			return e
+		}
+		if v2.isCategory2() {
+			return v.pushAll(v1, v2, v1)
+		}
+		v3, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		return v.pushAll(v1, v3, v2, v1)
+	case 0x5c:
+		if v1.isCategory2() {
+			return v.pushAll(v1, v1)
+		}
+		v2, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		return v.pushAll(v2, v1, v2, v1)
+	case 0x5d:
+		if v1.isCategory2() {
+			v2, e := v.popCategory1()
+			if e != nil {
+				return e
+			}
+			return v.pushAll(v1, v2, v1)
+		}
+		v2, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		v3, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		return v.pushAll(v2, v1, v3, v2, v1)
+	case 0x5e:
+		if v1.isCategory2() {
+			v2, e := v.popAny()
+			if e != nil {
+				return e
+			}
+			if v2.isCategory2() {
+				return v.pushAll(v1, v2, v1)
+			}
+			v3, e := v.popCategory1()
+			if e != nil {
+				return e
+			}
+			return v.pushAll(v1, v3, v2, v1)
+		}
+		v2, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		v3, e := v.popAny()
+		if e != nil {
+			return e
+		}
+		if v3.isCategory2() {
+			return v.pushAll(v2, v1, v3, v2, v1)
+		}
+		v4, e := v.popCategory1()
+		if e != nil {
+			return e
+		}
+		return v.pushAll(v2, v1, v4, v3, v2, v1)
+	}
+	// swap
+	v2, e := v.popCategory1()
+	if e != nil {
+		return e
+	}
+	return v.pushAll(v1, v2)
+}
+
+// Holds the types popped and pushed by an instruction that only uses the
+// operand stack.
+type verifierStackEffect struct {
+	pops   []verificationType
+	pushes []verificationType
+}
+
+// Converts a string of type characters into a list of verification types.
+// The characters are I, J, F, and D for primitives, and N for null.
+func parseVerifierTypes(types string) []verificationType {
+	toReturn := make([]verificationType, len(types))
+	for i := range types {
+		switch types[i] {
+		case 'N':
+			toReturn[i] = nullType
+		default:
+			toReturn[i] = fieldVerificationType(
+				class_file.PrimitiveFieldType(types[i]))
+		}
+	}
+	return toReturn
+}
+
+// Maps opcodes to the types they pop and push, for instructions that only
+// use the operand stack.
+var verifierStackEffects = getVerifierStackEffects()
+
+func getVerifierStackEffects() map[uint8]verifierStackEffect {
+	effects := map[uint8][2]string{
+		0x00: {"", ""},
+		0x01: {"", "N"},
+		0x09: {"", "J"},
+		0x0a: {"", "J"},
+		0x0b: {"", "F"},
+		0x0c: {"", "F"},
+		0x0d: {"", "F"},
+		0x0e: {"", "D"},
+		0x0f: {"", "D"},
+		0x10: {"", "I"},
+		0x11: {"", "I"},
+		0x74: {"I", "I"},
+		0x75: {"J", "J"},
+		0x76: {"F", "F"},
+		0x77: {"D", "D"},
+		0x78: {"II", "I"},
+		0x79: {"JI", "J"},
+		0x7a: {"II", "I"},
+		0x7b: {"JI", "J"},
+		0x7c: {"II", "I"},
+		0x7d: {"JI", "J"},
+		0x7e: {"II", "I"},
+		0x7f: {"JJ", "J"},
+		0x80: {"II", "I"},
+		0x81: {"JJ", "J"},
+		0x82: {"II", "I"},
+		0x83: {"JJ", "J"},
+		0x85: {"I", "J"},
+		0x86: {"I", "F"},
+		0x87: {"I", "D"},
+		0x88: {"J", "I"},
+		0x89: {"J", "F"},
+		0x8a: {"J", "D"},
+		0x8b: {"F", "I"},
+		0x8c: {"F", "J"},
+		0x8d: {"F", "D"},
+		0x8e: {"D", "I"},
+		0x8f: {"D", "J"},
+		0x90: {"D", "F"},
+		0x91: {"I", "I"},
+		0x92: {"I", "I"},
+		0x93: {"I", "I"},
+		0x94: {"JJ", "I"},
+		0x95: {"FF", "I"},
+		0x96: {"FF", "I"},
+		0x97: {"DD", "I"},
+		0x98: {"DD", "I"},
+	}
+	// iconst_m1 through iconst_5
+	for opcode := uint8(0x02); opcode <= 0x08; opcode++ {
+		effects[opcode] = [2]string{"", "I"}
+	}
+	// The add, sub, mul, div, and rem instructions each come in I, J, F, and
+	// D variants.
+	for opcode := uint8(0x60); opcode <= 0x73; opcode++ {
+		t := string("IJFD"[(opcode-0x60)%4])
+		effects[opcode] = [2]string{t + t, t}
+	}
+	toReturn := make(map[uint8]verifierStackEffect)
+	for opcode, effect := range effects {
+		toReturn[opcode] = verifierStackEffect{
+			pops:   parseVerifierTypes(effect[0]),
+			pushes: parseVerifierTypes(effect[1]),
+		}
+	}
+	return toReturn
+}
+
+// Checks the current instruction, updating v.current to hold the types after
+// it runs. Returns true if execution can continue to the next instruction.
+func (v *verifier) verifyInstruction() (bool, error) {
+	opcode := v.code[v.offset]
+	effect, ok := verifierStackEffects[opcode]
+	if ok {
+		e := v.popAll(effect.pops...)
+		if e != nil {
+			return false, e
+		}
+		return true, v.pushAll(effect.pushes...)
+	}
+	var e error
+	switch {
+	case opcode == 0x12:
+		return true, v.verifyLdc(uint16(v.u1(1)), false)
+	case opcode == 0x13:
+		return true, v.verifyLdc(v.u2(1), false)
+	case opcode == 0x14:
+		return true, v.verifyLdc(v.u2(1), true)
+	case (opcode >= 0x15) && (opcode <= 0x19):
+		return true, v.verifyLoad(int(opcode-0x15), int(v.u1(1)))
+	case (opcode >= 0x1a) && (opcode <= 0x2d):
+		n := int(opcode - 0x1a)
+		return true, v.verifyLoad(n/4, n%4)
+	case (opcode >= 0x2e) && (opcode <= 0x35):
+		return true, v.verifyArrayAccess(int(opcode-0x2e), false)
+	case (opcode >= 0x36) && (opcode <= 0x3a):
+		return true, v.verifyStore(int(opcode-0x36), int(v.u1(1)))
+	case (opcode >= 0x3b) && (opcode <= 0x4e):
+		n := int(opcode - 0x3b)
+		return true, v.verifyStore(n/4, n%4)
+	case (opcode >= 0x4f) && (opcode <= 0x56):
+		return true, v.verifyArrayAccess(int(opcode-0x4f), true)
+	case opcode == 0x57:
+		_, e = v.popCategory1()
+		return true, e
+	case opcode == 0x58:
+		var t verificationType
+		t, e = v.popAny()
+		if (e == nil) && !t.isCategory2() {
+			_, e = v.popCategory1()
+		}
+		return true, e
+	case opcode == 0x59:
+		var t verificationType
+		t, e = v.popCategory1()
+		if e == nil {
+			e = v.pushAll(t, t)
+		}
+		return true, e
+	case (opcode >= 0x5a) && (opcode <= 0x5f):
+		return true, v.verifyStackManipulation(opcode)
+	case opcode == 0x84:
+		_, e = v.loadLocal(int(v.u1(1)), intType)
+		return true, e
+	case (opcode >= 0x99) && (opcode <= 0xa6):
+		switch {
+		case opcode <= 0x9e:
+			_, e = v.pop(intType)
+		case opcode <= 0xa4:
+			e = v.popAll(intType, intType)
+		default:
+			_, e = v.popReference()
+			if e == nil {
+				_, e = v.popReference()
+			}
+		}
+		if e != nil {
+			return false, e
+		}
+		return true, v.checkBranch(v.branchTarget(int32(int16(v.u2(1)))))
+	case opcode == 0xa7:
+		return false, v.checkBranch(v.branchTarget(int32(int16(v.u2(1)))))
+	case (opcode == 0xa8) || (opcode == 0xa9) || (opcode == 0xc9):
+		return false, v.errorf("The jsr and ret instructions aren't allowed " +
+			"in methods with stack map frames")
+	case (opcode == 0xaa) || (opcode == 0xab):
+		return false, v.verifySwitch(opcode)
+	case (opcode >= 0xac) && (opcode <= 0xb0):
+		var expected verificationType
+		switch opcode {
+		case 0xac:
+			expected = intType
+		case 0xad:
+			expected = longType
+		case 0xae:
+			expected = floatType
+		case 0xaf:
+			expected = doubleType
+		default:
+			expected = objectType(class_file.ClassInstanceType(
+				"java/lang/Object"))
+		}
+		return false, v.verifyReturn(&expected)
+	case opcode == 0xb1:
+		return false, v.verifyReturn(nil)
+	case (opcode >= 0xb2) && (opcode <= 0xb5):
+		return true, v.verifyFieldAccess(opcode)
+	case (opcode >= 0xb6) && (opcode <= 0xba):
+		return true, v.verifyInvoke(opcode)
+	case opcode == 0xbb:
+		return true, v.verifyNew()
+	case (opcode == 0xbc) || (opcode == 0xbd) || (opcode == 0xc5):
+		return true, v.verifyNewArray(opcode)
+	case opcode == 0xbe:
+		_, e = v.popArray()
+		if e == nil {
+			e = v.push(intType)
+		}
+		return true, e
+	case opcode == 0xbf:
+		_, e = v.pop(objectType(class_file.ClassInstanceType(
+			"java/lang/Throwable")))
+		return false, e
+	case (opcode == 0xc0) || (opcode == 0xc1):
+		var t class_file.FieldType
+		t, e = v.getClassConstant(v.u2(1))
+		if e == nil {
+			_, e = v.pop(objectType(class_file.ClassInstanceType(
+				"java/lang/Object")))
+		}
+		if e != nil {
+			return false, e
+		}
+		if opcode == 0xc0 {
+			return true, v.push(objectType(t))
+		}
+		return true, v.push(intType)
+	case (opcode == 0xc2) || (opcode == 0xc3):
+		_, e = v.popReference()
+		return true, e
+	case opcode == 0xc4:
+		return true, v.verifyWide()
+	case (opcode == 0xc6) || (opcode == 0xc7):
+		_, e = v.popReference()
+		if e != nil {
+			return false, e
+		}
+		return true, v.checkBranch(v.branchTarget(int32(int16(v.u2(1)))))
+	case opcode == 0xc8:
+		return false, v.checkBranch(v.branchTarget(v.s4At(v.offset + 1)))
+	}
+	return false, v.errorf("Unknown opcode 0x%02x", opcode)
+}
+
+// Checks a wide instruction.
+func (v *verifier) verifyWide() error {
+	opcode := v.u1(1)
+	index := int(v.u2(2))
+	switch {
+	case (opcode >= 0x15) && (opcode <= 0x19):
+		return v.verifyLoad(int(opcode-0x15), index)
+	case (opcode >= 0x36) && (opcode <= 0x3a):
+		return v.verifyStore(int(opcode-0x36), index)
+	case opcode == 0x84:
+		_, e := v.loadLocal(index, intType)
+		return e
+	}
+	return v.errorf("Can't use wide with opcode 0x%02x", opcode)
+}
+
+// Checks that the current types satisfy every exception handler covering the
+// current instruction.
+func (v *verifier) checkExceptionHandlers() error {
+	for _, entry := range v.m.exceptionTable {
+		start, end := uint(entry.StartPC), uint(entry.EndPC)
+		if (v.offset < start) || (v.offset >= end) {
+			continue
+		}
+		catchType := class_file.FieldType(class_file.ClassInstanceType(
+			"java/lang/Throwable"))
+		if entry.CatchType != 0 {
+			var e error
+			catchType, e = v.getClassConstant(entry.CatchType)
+			if e != nil {
+				return e
+			}
+		}
+		handler := uint(entry.HandlerPC)
+		frame := v.frames[handler]
+		if frame == nil {
+			return v.errorf("Exception handler %d has no stack map frame",
+				handler)
+		}
+		exceptionFrame := &verifierFrame{
+			locals: v.current.locals,
+			stack:  []verificationType{objectType(catchType)},
+		}
+		e := v.checkFrameAssignable(exceptionFrame, frame, handler)
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Checks that the exception table refers to valid instructions and catch
+// types.
+func (v *verifier) checkExceptionTable() error {
+	throwable := objectType(class_file.ClassInstanceType(
+		"java/lang/Throwable"))
+	codeLength := uint(len(v.code))
+	for _, entry := range v.m.exceptionTable {
+		start, end := uint(entry.StartPC), uint(entry.EndPC)
+		handler := uint(entry.HandlerPC)
+		if (start >= end) || !v.isInstruction[start] ||
+			(!v.isInstruction[end] && (end != codeLength)) ||
+			!v.isInstruction[handler] {
+			return v.errorf("Invalid exception table entry: %d-%d, handler %d",
+				start, end, handler)
+		}
+		if entry.CatchType == 0 {
+			continue
+		}
+		catchType, e := v.getClassConstant(entry.CatchType)
+		if e != nil {
+			return e
+		}
+		if !v.isAssignable(objectType(catchType), throwable) {
+			return v.errorf("Exception handler %d catches %s, which isn't a "+
+				"Throwable", handler, catchType)
+		}
+	}
+	return nil
+}
+
+// Returns the types of the method's local variables when it's invoked, one
+// per value rather than one per slot.
+func (v *verifier) getInitialLocals() []verificationType {
+	var toReturn []verificationType
+	m := v.m
+	if !m.IsStatic() {
+		className := string(m.ContainingClass.Name)
+		if (m.Name == "<init>") && (className != "java/lang/Object") {
+			toReturn = append(toReturn, uninitializedThisType)
+		} else {
+			toReturn = append(toReturn, objectType(
+				class_file.ClassInstanceType(className)))
+		}
+	}
+	for _, t := range m.Types.ArgumentTypes {
+		toReturn = append(toReturn, fieldVerificationType(t))
+	}
+	return toReturn
+}
+
+// Converts a list of local variable types, with one entry per value, to one
+// entry per slot, padded with top to the method's max_locals.
+func (v *verifier) expandLocals(values []verificationType) (
+	[]verificationType, error) {
+	toReturn := make([]verificationType, 0, v.m.MaxLocals)
+	for _, t := range values {
+		toReturn = append(toReturn, t)
+		if t.isCategory2() {
+			toReturn = append(toReturn, topType)
+		}
+	}
+	if len(toReturn) > v.m.MaxLocals {
+		return nil, v.errorf("The method's local variables need %d slots, "+
+			"but max_locals is %d", len(toReturn), v.m.MaxLocals)
+	}
+	for len(toReturn) < v.m.MaxLocals {
+		toReturn = append(toReturn, topType)
+	}
+	return toReturn, nil
+}
+
+// Converts a type from a stack map frame into a verificationType.
+func (v *verifier) convertTypeInfo(
+	info class_file.VerificationTypeInfo) (verificationType, error) {
+	switch info.Tag {
+	case verifyObject:
+		t, e := v.getClassConstant(info.Other)
+		if e != nil {
+			return topType, e
+		}
+		return objectType(t), nil
+	case verifyUninitialized:
+		offset := uint(info.Other)
+		if !v.isInstruction[offset] || (v.code[offset] != 0xbb) {
+			return topType, v.errorf("Uninitialized type refers to offset "+
+				"%d, which isn't a new instruction", offset)
+		}
+		return verificationType{
+			tag:       verifyUninitialized,
+			newOffset: offset,
+		}, nil
+	}
+	if info.Tag > verifyUninitialized {
+		return topType, v.errorf("Invalid verification type tag %d",
+			info.Tag)
+	}
+	return verificationType{tag: info.Tag}, nil
+}
+
+// Converts a list of types from a stack map frame into verificationTypes.
+func (v *verifier) convertTypeInfos(
+	infos []class_file.VerificationTypeInfo) ([]verificationType, error) {
+	toReturn := make([]verificationType, len(infos))
+	var e error
+	for i, info := range infos {
+		toReturn[i], e = v.convertTypeInfo(info)
+		if e != nil {
+			return nil, e
+		}
+	}
+	return toReturn, nil
+}
+
+// Parses the method's StackMapTable attribute, if it has one, and populates
+// v.frames. Requires the types of the method's initial local variables.
+func (v *verifier) getStackMapFrames(locals []verificationType) error {
+	v.frames = make(map[uint]*verifierFrame)
+	if v.m.stackMapTable == nil {
+		return nil
+	}
+	entries, e := class_file.ParseStackMapTableAttribute(v.m.stackMapTable)
+	if e != nil {
+		return v.errorf("Invalid StackMapTable: %s", e)
+	}
+	offset := int64(-1)
+	for _, entry := range entries {
+		offset += int64(entry.OffsetDelta()) + 1
+		v.offset = uint(offset)
+		var stack []verificationType
+		var info class_file.VerificationTypeInfo
+		switch f := entry.(type) {
+		case *class_file.SameStackMapFrame,
+			*class_file.SameStackMapFrameExtended:
+		case *class_file.OneItemStackMapFrame:
+			info = f.Info
+			stack = make([]verificationType, 1)
+		case *class_file.OneItemStackMapFrameExtended:
+			info = f.Info
+			stack = make([]verificationType, 1)
+		case *class_file.ChopStackMapFrame:
+			count := 251 - int(f.FrameType())
+			if count > len(locals) {
+				return v.errorf("Can't remove %d local variables from the "+
+					"stack map frame", count)
+			}
+			locals = locals[:len(locals)-count]
+		case *class_file.AppendStackMapFrame:
+			added, e := v.convertTypeInfos(f.Locals)
+			if e != nil {
+				return e
+			}
+			locals = append(locals[:len(locals):len(locals)], added...)
+		case *class_file.FullStackMapFrame:
+			locals, e = v.convertTypeInfos(f.Locals)
+			if e != nil {
+				return e
+			}
+			stack, e = v.convertTypeInfos(f.Stack)
+			if e != nil {
+				return e
+			}
+		default:
+			return v.errorf("Unsupported stack map frame: %s", entry)
+		}
+		if len(stack) == 1 && (stack[0].tag == verifyTop) {
+			stack[0], e = v.convertTypeInfo(info)
+			if e != nil {
+				return e
+			}
+		}
+		if !v.isInstruction[uint(offset)] {
+			return v.errorf("There's a stack map frame at offset %d, which "+
+				"isn't the start of an instruction", offset)
+		}
+		frame := &verifierFrame{
+			stack: stack,
+		}
+		frame.locals, e = v.expandLocals(locals)
+		if e != nil {
+			return e
+		}
+		if frame.stackSize() > v.m.MaxStack {
+			return v.errorf("The stack map frame's operand stack exceeds "+
+				"max_stack (%d)", v.m.MaxStack)
+		}
+		v.frames[uint(offset)] = frame
+	}
+	return nil
+}
+
+// Finds the offset of every instruction in the method's code.
+func (v *verifier) getInstructionOffsets() error {
+	v.isInstruction = make(map[uint]bool)
+	memory := MemoryFromSlice(v.code)
+	offset := uint(0)
+	for offset < uint(len(v.code)) {
+		v.offset = offset
+		instruction, e := GetNextInstruction(memory, offset)
+		if e != nil {
+			return v.errorf("Invalid instruction: %s", e)
+		}
+		v.offsets = append(v.offsets, offset)
+		v.isInstruction[offset] = true
+		offset += instruction.Length()
+	}
+	if offset != uint(len(v.code)) {
+		return v.errorf("The last instruction is truncated")
+	}
+	return nil
+}
+
+// Returns true if the method needs to be checked by the verifier. Like the
+// JVM spec, this requires verification by type checking for class files with
+// version 51 and above. Version 50 class files are only verified if the
+// method has a StackMapTable, and older class files aren't verified.
+func (m *Method) needsVerification() bool {
+	if (m.Native != nil) || (len(m.CodeBytes) == 0) {
+		return false
+	}
+	file := m.ContainingClass.File
+	if file == nil {
+		return false
+	}
+	if file.MajorVersion == 50 {
+		return m.stackMapTable != nil
+	}
+	return file.MajorVersion > 50
+}
+
+// Checks the method's bytecode, using the types in its StackMapTable
+// attribute, and returns a VerifyError naming the method and bytecode offset
+// if it's malformed. This is called by Optimize, so it doesn't usually need to
+// be called directly. Returns nil without doing anything for methods that
+// don't need to be verified, including native methods and methods in class
+// files older than version 50.
+func (m *Method) Verify() error {
+	if !m.needsVerification() {
+		return nil
+	}
+	v := &verifier{
+		m:    m,
+		file: m.ContainingClass.File,
+		code: m.CodeBytes,
+	}
+	e := v.getInstructionOffsets()
+	if e != nil {
+		return e
+	}
+	initialLocals := v.getInitialLocals()
+	e = v.getStackMapFrames(initialLocals)
+	if e != nil {
+		return e
+	}
+	v.offset = 0
+	e = v.checkExceptionTable()
+	if e != nil {
+		return e
+	}
+	current := &verifierFrame{}
+	current.locals, e = v.expandLocals(initialLocals)
+	if e != nil {
+		return e
+	}
+	fallsThrough := true
+	for _, offset := range v.offsets {
+		v.offset = offset
+		frame := v.frames[offset]
+		if frame != nil {
+			if fallsThrough {
+				e = v.checkFrameAssignable(current, frame, offset)
+				if e != nil {
+					return e
+				}
+			}
+			current = frame.copy()
+		} else if !fallsThrough {
+			return v.errorf("Expected a stack map frame after an " +
+				"unconditional branch")
+		}
+		v.current = current
+		e = v.checkExceptionHandlers()
+		if e != nil {
+			return e
+		}
+		fallsThrough, e = v.verifyInstruction()
+		if e != nil {
+			return e
+		}
+	}
+	if fallsThrough {
+		return v.errorf("Execution can continue past the end of the code")
+	}
+	return nil
+}
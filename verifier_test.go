@@ -0,0 +1,565 @@
+package bs_jvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/yalue/bs_jvm/class_file"
+	"github.com/yalue/bs_jvm/class_file/builder"
+	"strings"
+	"testing"
+)
+
+// Returns a static method with the given descriptor and code, for testing
+// the verifier. If stackMap isn't nil, it's used as the contents of the
+// method's StackMapTable attribute.
+func getVerifierTestMethod(c *class_file.Class, name, descriptor string,
+	maxStack, maxLocals uint16, code, stackMap []byte) *class_file.Method {
+	data := &bytes.Buffer{}
+	binary.Write(data, binary.BigEndian, maxStack)
+	binary.Write(data, binary.BigEndian, maxLocals)
+	binary.Write(data, binary.BigEndian, uint32(len(code)))
+	data.Write(code)
+	// exception_table_length
+	binary.Write(data, binary.BigEndian, uint16(0))
+	if stackMap == nil {
+		binary.Write(data, binary.BigEndian, uint16(0))
+	} else {
+		c.Constants = append(c.Constants,
+			&class_file.ConstantUTF8Info{Bytes: []byte("StackMapTable")})
+		binary.Write(data, binary.BigEndian, uint16(1))
+		binary.Write(data, binary.BigEndian, uint16(len(c.Constants)-1))
+		binary.Write(data, binary.BigEndian, uint32(len(stackMap)))
+		data.Write(stackMap)
+	}
+	toReturn := getStubMethod(name, 0x0009)
+	toReturn.Descriptor, _ = class_file.ParseMethodDescriptor(
+		[]byte(descriptor))
+	toReturn.Attributes = []*class_file.Attribute{
+		{
+			Name: []byte("Code"),
+			Info: data.Bytes(),
+		},
+	}
+	return toReturn
+}
+
+// Loads a version 52 class containing a single method with the given code
+// and stack map, and returns the method. The method takes an int and returns
+// an int.
+func loadVerifierTestMethod(t *testing.T, code,
+	stackMap []byte) *Method {
+	jvm := NewJVM()
+	c := getStubClassFile("Verified", "java/lang/Object", 0x0021, nil, nil,
+		nil)
+	c.MajorVersion = 52
+	method := getVerifierTestMethod(c, "count", "(I)I", 1, 2, code, stackMap)
+	c.Methods = []*class_file.Method{method}
+	loadStubClasses(t, jvm, c)
+	class, e := jvm.GetClass("Verified")
+	if e != nil {
+		t.Logf("Failed getting class: %s\n", e)
+		t.FailNow()
+	}
+	return class.Methods[GetMethodKey(method)]
+}
+
+// Returns the bytecode of a method that counts up to its argument in a loop.
+// The first byte loads the initial count, which must be an int for the code
+// to be valid.
+func getCountLoopCode(firstByte byte) []byte {
+	return []byte{
+		firstByte,        // 0: iconst_0
+		0x3c,             // 1: istore_1
+		0x1a,             // 2: iload_0
+		0x9e, 0x00, 0x0c, // 3: ifle 15
+		0x84, 0x01, 0x01, // 6: iinc 1, 1
+		0x84, 0x00, 0xff, // 9: iinc 0, -1
+		0xa7, 0xff, 0xf6, // 12: goto 2
+		0x1b, // 15: iload_1
+		0xac, // 16: ireturn
+	}
+}
+
+// The stack map for the code returned by getCountLoopCode: an append frame
+// adding an int local at offset 2, followed by a same frame at offset 15.
+var countLoopStackMap = []byte{0x00, 0x02, 252, 0x00, 0x02, 0x01, 12}
+
+func TestVerifyClassFiles(t *testing.T) {
+	jvm := NewJVM()
+	jvm.ClassPath = append(jvm.ClassPath,
+		DirectoryClassSource("class_file/test_data"))
+	for _, name := range []string{"RandomDots", "RandomDotsSimple"} {
+		class, e := jvm.GetOrLoadClass(name)
+		if e != nil {
+			t.Logf("Failed loading %s: %s\n", name, e)
+			t.FailNow()
+		}
+		for key, method := range class.Methods {
+			e = method.Verify()
+			if e != nil {
+				t.Logf("Failed verifying %s.%s: %s\n", name, key, e)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestVerifyStackMapFrames(t *testing.T) {
+	method := loadVerifierTestMethod(t, getCountLoopCode(0x03),
+		countLoopStackMap)
+	e := method.Optimize()
+	if e != nil {
+		t.Logf("Failed optimizing a valid method: %s\n", e)
+		t.FailNow()
+	}
+
+	// Branching to an offset without a stack map frame must fail.
+	method = loadVerifierTestMethod(t, getCountLoopCode(0x03), nil)
+	e = method.Verify()
+	var verifyError VerifyError
+	if !errors.As(e, &verifyError) {
+		t.Logf("Expected a VerifyError without a stack map, got %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	if !strings.Contains(e.Error(), "Verified.count(I)I at offset 3") {
+		t.Logf("The error didn't name the method and offset\n")
+		t.Fail()
+	}
+}
+
+func TestVerifyTypeMismatch(t *testing.T) {
+	// Use fconst_0 rather than iconst_0, so istore_1 stores a float.
+	method := loadVerifierTestMethod(t, getCountLoopCode(0x0b),
+		countLoopStackMap)
+	e := method.Optimize()
+	var verifyError VerifyError
+	if !errors.As(e, &verifyError) {
+		t.Logf("Expected a VerifyError for a type mismatch, got %v\n", e)
+		t.FailNow()
+	}
+	t.Logf("Got expected error: %s\n", e)
+	if !strings.Contains(e.Error(), "at offset 1") {
+		t.Logf("The error didn't name the correct offset\n")
+		t.Fail()
+	}
+	if method.OptimizeDone {
+		t.Logf("A method that failed verification was marked as optimized\n")
+		t.Fail()
+	}
+}
+
+// Describes a method used to test the verifier.
+type verifierTestCase struct {
+	name string
+	// The method's name and descriptor. Methods other than constructors are
+	// static.
+	method     string
+	descriptor string
+	// Emits the method's code.
+	build func(m *builder.MethodBuilder)
+	// If patchFrom is nonzero, the first instance of it in the method's code
+	// is replaced with patchTo after the class is built. This is used to
+	// produce invalid code that the builder would reject.
+	patchFrom byte
+	patchTo   byte
+	// The class file's major version. Defaults to 52.
+	version uint16
+	// A substring of the expected VerifyError, or empty if the method is
+	// valid.
+	expected string
+}
+
+// Builds and loads a class named VerifierTest containing the test case's
+// method, and returns the result of verifying the method.
+func (c *verifierTestCase) run(t *testing.T) error {
+	jvm := NewJVM()
+	loadBuiltTestClass(t, jvm, builder.NewClassBuilder("java/lang/Throwable",
+		"java/lang/Object"))
+	b := builder.NewClassBuilder("VerifierTest", "java/lang/Object")
+	if c.version != 0 {
+		b.MajorVersion = c.version
+	}
+	access := class_file.MethodAccessFlags(0x0009)
+	if c.method == "<init>" {
+		access = 0x0001
+	}
+	c.build(b.AddMethod(access, c.method, c.descriptor))
+	loadBuiltTestClass(t, jvm, b)
+	class, e := jvm.GetClass("VerifierTest")
+	if e != nil {
+		t.Logf("Failed getting class: %s\n", e)
+		t.FailNow()
+	}
+	var method *Method
+	for _, m := range class.Methods {
+		if m.Name == c.method {
+			method = m
+		}
+	}
+	if c.patchFrom != 0 {
+		i := bytes.IndexByte(method.CodeBytes, c.patchFrom)
+		if i < 0 {
+			t.Logf("Couldn't find opcode 0x%02x to patch in %s\n",
+				c.patchFrom, c.name)
+			t.FailNow()
+		}
+		method.CodeBytes[i] = c.patchTo
+	}
+	return method.Verify()
+}
+
+// Emits code creating and initializing a new instance of the named class.
+func emitNewObject(m *builder.MethodBuilder, className string) {
+	m.EmitType(builder.New, className)
+	m.Emit(builder.Dup)
+	m.EmitInvoke(builder.Invokespecial, className, "<init>", "()V")
+}
+
+func TestVerifyMethods(t *testing.T) {
+	tests := []*verifierTestCase{
+		{
+			name:       "initialized object",
+			method:     "create",
+			descriptor: "()Ljava/lang/Object;",
+			build: func(m *builder.MethodBuilder) {
+				emitNewObject(m, "VerifierTest")
+				m.Emit(builder.Areturn)
+			},
+		},
+		{
+			name:       "returning an uninitialized object",
+			method:     "create",
+			descriptor: "()Ljava/lang/Object;",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitType(builder.New, "VerifierTest")
+				m.Emit(builder.Areturn)
+			},
+			expected: "but got uninitialized(0)",
+		},
+		{
+			// The builder rejects this, so patch invokevirtual into
+			// invokespecial.
+			name:       "initializing an object twice",
+			method:     "create",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				emitNewObject(m, "VerifierTest")
+				m.EmitInvoke(builder.Invokevirtual, "VerifierTest",
+					"<init>", "()V")
+				m.Emit(builder.Return)
+			},
+			patchFrom: byte(builder.Invokevirtual),
+			patchTo:   byte(builder.Invokespecial),
+			expected:  "already initialized",
+		},
+		{
+			name:       "calling a method on an uninitialized object",
+			method:     "create",
+			descriptor: "()I",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitType(builder.New, "VerifierTest")
+				m.EmitInvoke(builder.Invokevirtual, "java/lang/Object",
+					"hashCode", "()I")
+				m.Emit(builder.Ireturn)
+			},
+			expected: "uninitialized",
+		},
+		{
+			name:       "storing an uninitialized object in an array",
+			method:     "create",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitInt(1)
+				m.EmitType(builder.Anewarray, "java/lang/Object")
+				m.EmitInt(0)
+				m.EmitType(builder.New, "VerifierTest")
+				m.Emit(builder.Aastore)
+				m.Emit(builder.Return)
+			},
+			expected: "Can't store an uninitialized object in an array",
+		},
+		{
+			name:       "constructor",
+			method:     "<init>",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitLocal(builder.Aload, 0)
+				m.EmitInvoke(builder.Invokespecial, "java/lang/Object",
+					"<init>", "()V")
+				m.Emit(builder.Return)
+			},
+		},
+		{
+			name:       "constructor without a superclass constructor call",
+			method:     "<init>",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.Emit(builder.Return)
+			},
+			expected: "The constructor returns without calling",
+		},
+		{
+			name:       "using uninitializedThis",
+			method:     "<init>",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitLocal(builder.Aload, 0)
+				m.EmitInvoke(builder.Invokevirtual, "java/lang/Object",
+					"hashCode", "()I")
+				m.Emit(builder.Pop)
+				m.EmitLocal(builder.Aload, 0)
+				m.EmitInvoke(builder.Invokespecial, "java/lang/Object",
+					"<init>", "()V")
+				m.Emit(builder.Return)
+			},
+			expected: "uninitializedThis",
+		},
+		{
+			name:       "exception handler",
+			method:     "divide",
+			descriptor: "(I)I",
+			build: func(m *builder.MethodBuilder) {
+				start := m.NewLabel()
+				end := m.NewLabel()
+				handler := m.NewLabel()
+				m.PlaceLabel(start)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Iload, 0)
+				m.Emit(builder.Idiv)
+				m.PlaceLabel(end)
+				m.Emit(builder.Ireturn)
+				m.PlaceLabel(handler)
+				m.Emit(builder.Pop)
+				m.EmitLocal(builder.Iload, 0)
+				m.Emit(builder.Ireturn)
+				m.AddExceptionHandler(start, end, handler,
+					"java/lang/Throwable")
+			},
+		},
+		{
+			name:       "exception handler catching a non-Throwable",
+			method:     "divide",
+			descriptor: "(I)I",
+			build: func(m *builder.MethodBuilder) {
+				start := m.NewLabel()
+				end := m.NewLabel()
+				handler := m.NewLabel()
+				m.PlaceLabel(start)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Iload, 0)
+				m.Emit(builder.Idiv)
+				m.PlaceLabel(end)
+				m.Emit(builder.Ireturn)
+				m.PlaceLabel(handler)
+				m.Emit(builder.Pop)
+				m.EmitLocal(builder.Iload, 0)
+				m.Emit(builder.Ireturn)
+				m.AddExceptionHandler(start, end, handler, "VerifierTest")
+			},
+			expected: "which isn't a",
+		},
+		{
+			// The handler's frame can't include locals that are only set in
+			// the protected code. The builder computes the correct frame, so
+			// patch iconst_2 in the handler into iload_1.
+			name:       "exception handler using a local set in the try block",
+			method:     "divide",
+			descriptor: "(I)I",
+			build: func(m *builder.MethodBuilder) {
+				start := m.NewLabel()
+				end := m.NewLabel()
+				handler := m.NewLabel()
+				m.PlaceLabel(start)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Istore, 1)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Iload, 0)
+				m.Emit(builder.Idiv)
+				m.PlaceLabel(end)
+				m.Emit(builder.Ireturn)
+				m.PlaceLabel(handler)
+				m.Emit(builder.Pop)
+				m.EmitInt(2)
+				m.Emit(builder.Ireturn)
+				m.AddExceptionHandler(start, end, handler,
+					"java/lang/Throwable")
+			},
+			patchFrom: byte(builder.Iconst_2),
+			patchTo:   byte(builder.Iload_1),
+			expected:  "Expected int in local variable 1",
+		},
+		{
+			name:       "long local",
+			method:     "get",
+			descriptor: "()J",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitLong(7)
+				m.EmitLocal(builder.Lstore, 0)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Istore, 2)
+				m.EmitLocal(builder.Lload, 0)
+				m.Emit(builder.Lreturn)
+			},
+		},
+		{
+			// Patch istore_2 into istore_1, overwriting the second half of
+			// the long.
+			name:       "overwriting half of a long",
+			method:     "get",
+			descriptor: "()J",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitLong(7)
+				m.EmitLocal(builder.Lstore, 0)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Istore, 2)
+				m.EmitLocal(builder.Lload, 0)
+				m.Emit(builder.Lreturn)
+			},
+			patchFrom: byte(builder.Istore_2),
+			patchTo:   byte(builder.Istore_1),
+			expected:  "Expected long in local variable 0",
+		},
+		{
+			// Patch iload_2 into iload_1, reading the second half of the
+			// double.
+			name:       "reading half of a double",
+			method:     "get",
+			descriptor: "()I",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitDouble(7)
+				m.EmitLocal(builder.Dstore, 0)
+				m.EmitInt(1)
+				m.EmitLocal(builder.Istore, 2)
+				m.EmitLocal(builder.Iload, 2)
+				m.Emit(builder.Ireturn)
+			},
+			patchFrom: byte(builder.Iload_2),
+			patchTo:   byte(builder.Iload_1),
+			expected:  "Expected int in local variable 1",
+		},
+		{
+			// The builder doesn't support subroutines, so patch a goto into a
+			// jsr.
+			name:       "jsr in a version 51 class",
+			method:     "jump",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				target := m.NewLabel()
+				m.EmitBranch(builder.Goto, target)
+				m.PlaceLabel(target)
+				m.Emit(builder.Return)
+			},
+			patchFrom: byte(builder.Goto),
+			patchTo:   byte(builder.Jsr),
+			version:   51,
+			expected:  "The jsr and ret instructions aren't allowed",
+		},
+		{
+			name:       "ret in a version 52 class",
+			method:     "jump",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.Emit(builder.Bipush, 0)
+				m.Emit(builder.Pop)
+				m.Emit(builder.Return)
+			},
+			patchFrom: byte(builder.Bipush),
+			patchTo:   byte(builder.Ret),
+			expected:  "The jsr and ret instructions aren't allowed",
+		},
+		{
+			name:       "returning a float from an int method",
+			method:     "get",
+			descriptor: "()I",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitFloat(1)
+				m.Emit(builder.Freturn)
+			},
+			expected: "Can't use freturn in a method returning int",
+		},
+		{
+			name:       "returning a long using ireturn",
+			method:     "get",
+			descriptor: "()I",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitLong(1)
+				m.Emit(builder.Ireturn)
+			},
+			expected: "Expected int on the operand stack, but got long",
+		},
+		{
+			name:       "returning a value from a void method",
+			method:     "get",
+			descriptor: "()V",
+			build: func(m *builder.MethodBuilder) {
+				m.EmitInt(1)
+				m.Emit(builder.Ireturn)
+			},
+			expected: "Can't return a value from a void method",
+		},
+		{
+			name:       "returning nothing from an int method",
+			method:     "get",
+			descriptor: "()I",
+			build: func(m *builder.MethodBuilder) {
+				m.Emit(builder.Return)
+			},
+			expected: "Can't use return in a method returning int",
+		},
+		{
+			name:       "returning the wrong class",
+			method:     "get",
+			descriptor: "()LVerifierTest;",
+			build: func(m *builder.MethodBuilder) {
+				emitNewObject(m, "java/lang/Throwable")
+				m.Emit(builder.Areturn)
+			},
+			expected: "Expected VerifierTest on the operand stack",
+		},
+		{
+			// The frame after the branches must hold an Object[], not an
+			// Object, for aaload to be allowed.
+			name:   "aaload after merging reference arrays",
+			method: "pick",
+			descriptor: "(Z[LVerifierTest;[Ljava/lang/Object;)" +
+				"Ljava/lang/Object;",
+			build: func(m *builder.MethodBuilder) {
+				other := m.NewLabel()
+				done := m.NewLabel()
+				m.EmitLocal(builder.Iload, 0)
+				m.EmitBranch(builder.Ifeq, other)
+				m.EmitLocal(builder.Aload, 1)
+				m.EmitBranch(builder.Goto, done)
+				m.PlaceLabel(other)
+				m.EmitLocal(builder.Aload, 2)
+				m.PlaceLabel(done)
+				m.EmitInt(0)
+				m.Emit(builder.Aaload)
+				m.Emit(builder.Areturn)
+			},
+		},
+	}
+	for _, test := range tests {
+		e := test.run(t)
+		if test.expected == "" {
+			if e != nil {
+				t.Logf("Failed verifying valid %s: %s\n", test.name, e)
+				t.Fail()
+			}
+			continue
+		}
+		var verifyError VerifyError
+		if !errors.As(e, &verifyError) {
+			t.Logf("Expected a VerifyError for %s, got %v\n", test.name, e)
+			t.Fail()
+			continue
+		}
+		if !strings.Contains(e.Error(), test.expected) {
+			t.Logf("Expected the error for %s to contain %q, got: %s\n",
+				test.name, test.expected, e)
+			t.Fail()
+		}
+	}
+}